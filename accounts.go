@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// deleteMeHandler deletes the requesting user's account. With a grace
+// period configured the account is only marked for deletion and logged out
+// everywhere; logging in again before the period is up cancels it.
+func (cfg *apiConfig) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.deletionGrace <= 0 {
+		if err := cfg.store(r.Context()).DeleteUser(userId); err != nil {
+			respondDataWriteError(w, err)
+			return
+		}
+		w.WriteHeader(204)
+		return
+	}
+	if _, err := cfg.store(r.Context()).RequestUserDeletion(userId, time.Now()); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// purgeDeletedUsers deletes the accounts whose grace period is over,
+// checking every interval until ctx is done.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			purged, err := cfg.store(ctx).PurgeUsers(now.Add(-cfg.deletionGrace))
+			if err != nil {
+				return fmt.Errorf("purging deleted accounts: %w", err)
+			}
+			if purged > 0 {
+				log.Printf("Deleted %d accounts", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// userRoles lists the roles to put in the user's access tokens.
+func userRoles(user database.User) []string {
+	if user.IsAdmin {
+		return []string{auth.RoleAdmin}
+	}
+	return nil
+}
+
+// middlewareRequireRole only lets through requests whose access token
+// carries role. Requests without a valid token get a 401, and signed-in
+// users without the role a 403.
+func (cfg *apiConfig) middlewareRequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := cfg.tokens.ValidateClaims(bearerToken(r), auth.AccessIssuer)
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !claims.HasRole(role) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// middlewareRequireAdmin guards the admin router: it lets through requests
+// carrying the ADMIN_TOKEN as their bearer token, for scripts, and otherwise
+// requires an access token with the admin role.
+func (cfg *apiConfig) middlewareRequireAdmin(next http.Handler) http.Handler {
+	requireRole := cfg.middlewareRequireRole(auth.RoleAdmin)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminToken != "" && subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(cfg.adminToken)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requireRole.ServeHTTP(w, r)
+	})
+}
+
+// bootstrapAdmin makes the user with the ADMIN_EMAIL address an admin, so a
+// fresh instance has someone to promote everyone else. Only once the address
+// is verified, though: whoever signs up with it first need not own it. A user
+// who verifies it later is promoted by promoteAdminEmail then.
+func (cfg *apiConfig) bootstrapAdmin() error {
+	if cfg.adminEmail == "" {
+		return nil
+	}
+	user, err := cfg.db.GetUser(cfg.adminEmail)
+	if errors.Is(err, database.ErrUserDoesNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = cfg.promoteAdminEmail(cfg.db, user)
+	return err
+}
+
+// promoteAdminEmail makes user an admin if they have verified the
+// ADMIN_EMAIL address, and returns them as they now are.
+func (cfg *apiConfig) promoteAdminEmail(db database.Storage, user database.User) (database.User, error) {
+	if cfg.adminEmail == "" || !user.Verified || user.IsAdmin || !strings.EqualFold(user.Email, strings.TrimSpace(cfg.adminEmail)) {
+		return user, nil
+	}
+	user, err := db.SetAdmin(user.Id, true)
+	if err != nil {
+		return database.User{}, err
+	}
+	log.Printf("Made %s an admin", user.Email)
+	return user, nil
+}
+
+func (cfg *apiConfig) getUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.store(r.Context()).GetUsers()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(users)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// deleteAdminChirpHandler deletes any chirp, whoever wrote it.
+func (cfg *apiConfig) deleteAdminChirpHandler(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	chirp, found, err := cfg.store(r.Context()).GetChirp(chirpId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	if err := cfg.store(r.Context()).DeleteChirp(chirp.Id, chirp.AuthorId); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.announceDelete(r.Context(), chirp)
+	w.WriteHeader(204)
+}
+
+// postBanUserHandler suspends a user indefinitely, until they are unbanned
+// or win an appeal.
+func (cfg *apiConfig) postBanUserHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	userId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if strings.TrimSpace(params.Reason) == "" {
+		respondValidationError(w, "reason is required")
+		return
+	}
+	cfg.recordModerationAction(w, r, database.ModerationAction{
+		UserId: userId,
+		Kind:   database.ActionSuspend,
+		Reason: params.Reason,
+	})
+}
+
+// deleteBanUserHandler lifts every active suspension of a user, temporary
+// ones included.
+func (cfg *apiConfig) deleteBanUserHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	userId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	lifted, err := cfg.store(r.Context()).LiftSuspensions(userId, time.Now())
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+
+	type returnVal struct {
+		Lifted int `json:"lifted"`
+	}
+	data, err := json.Marshal(returnVal{Lifted: lifted})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// putAdminHandler grants or revokes a user's admin role. It takes effect
+// from the user's next login or token refresh.
+func (cfg *apiConfig) putAdminHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	userId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+
+	type parameters struct {
+		IsAdmin bool `json:"is_admin"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	user, err := cfg.store(r.Context()).SetAdmin(userId, params.IsAdmin)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMiddlewareRequireRole(t *testing.T) {
+	tokens := auth.NewIssuer("secret")
+	user, err := tokens.NewAccessToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := tokens.NewAccessToken(2, auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{tokens: tokens}
+
+	runMiddlewareRequireRoleTest(t, cfg, "", 401)
+	runMiddlewareRequireRoleTest(t, cfg, "Bearer wrong", 401)
+	runMiddlewareRequireRoleTest(t, cfg, "Bearer "+user, 403)
+	runMiddlewareRequireRoleTest(t, cfg, "Bearer "+admin, 200)
+}
+
+func runMiddlewareRequireRoleTest(t *testing.T, cfg *apiConfig, authorization string, status int) {
+	t.Logf("Starting test for middlewareRequireRole with: Authorization %q, and expecting: %d", authorization, status)
+	handler := cfg.middlewareRequireRole(auth.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	r := httptest.NewRequest("GET", "/admin/users", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != status {
+		t.Errorf("Expecting: %d, but got: %d", status, w.Code)
+	}
+}
+
+func TestMiddlewareRequireAdmin(t *testing.T) {
+	tokens := auth.NewIssuer("secret")
+	user, err := tokens.NewAccessToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := tokens.NewAccessToken(2, auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{tokens: tokens, adminToken: "admin token"}
+
+	runMiddlewareRequireAdminTest(t, cfg, "", 401)
+	runMiddlewareRequireAdminTest(t, cfg, "Bearer wrong", 401)
+	runMiddlewareRequireAdminTest(t, cfg, "Bearer "+user, 403)
+	runMiddlewareRequireAdminTest(t, cfg, "Bearer "+admin, 200)
+	runMiddlewareRequireAdminTest(t, cfg, "Bearer admin token", 200)
+
+	cfg.adminToken = ""
+	runMiddlewareRequireAdminTest(t, cfg, "Bearer ", 401)
+}
+
+func runMiddlewareRequireAdminTest(t *testing.T, cfg *apiConfig, authorization string, status int) {
+	t.Logf("Starting test for middlewareRequireAdmin with: Authorization %q and ADMIN_TOKEN %q, and expecting: %d", authorization, cfg.adminToken, status)
+	handler := cfg.middlewareRequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	r := httptest.NewRequest("POST", "/admin/reset", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != status {
+		t.Errorf("Expecting: %d, but got: %d", status, w.Code)
+	}
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("first@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runBootstrapAdminTest(t, &apiConfig{db: db, adminEmail: "First@example.com"}, "first@example.com", false)
+	token, err := db.CreateVerificationToken(user.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyUser(token); err != nil {
+		t.Fatal(err)
+	}
+	runBootstrapAdminTest(t, &apiConfig{db: db, adminEmail: "nobody@example.com"}, "first@example.com", false)
+	runBootstrapAdminTest(t, &apiConfig{db: db, adminEmail: "First@example.com"}, "first@example.com", true)
+}
+
+func TestAdminEmailSignup(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), adminEmail: "admin@example.com", jobs: newJobQueue(time.Second, time.Second, time.Minute)}
+	post := func(handler http.HandlerFunc, body string) database.User {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", "/api/users", strings.NewReader(body)))
+		user := database.User{}
+		if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+			t.Fatalf("%s: %s", err, w.Body.String())
+		}
+		return user
+	}
+
+	t.Logf("Starting test for postUsersHandler with: the ADMIN_EMAIL address, and expecting: no admin until it is verified")
+	user := post(cfg.postUsersHandler, `{"email": "Admin@example.com", "password": "correct horse battery"}`)
+	if stored, err := db.GetUserById(user.Id); err != nil || user.IsAdmin || stored.IsAdmin {
+		t.Errorf("Expecting: not an admin, but got: %v %v, %v", user.IsAdmin, stored.IsAdmin, err)
+	}
+
+	t.Logf("Starting test for postVerifyUserHandler with: the ADMIN_EMAIL address, and expecting: an admin")
+	token, err := db.CreateVerificationToken(user.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user = post(cfg.postVerifyUserHandler, `{"token": "`+token+`"}`)
+	if stored, err := db.GetUserById(user.Id); err != nil || !user.IsAdmin || !stored.IsAdmin {
+		t.Errorf("Expecting: an admin, but got: %v %v, %v", user.IsAdmin, stored.IsAdmin, err)
+	}
+}
+
+func runBootstrapAdminTest(t *testing.T, cfg *apiConfig, email string, expecting bool) {
+	t.Logf("Starting test for bootstrapAdmin with: ADMIN_EMAIL %q, and expecting: %s admin %t", cfg.adminEmail, email, expecting)
+	if err := cfg.bootstrapAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.db.GetUser(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.IsAdmin != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, user.IsAdmin)
+	}
+}
+
+// urlParam matches the parameters in a route pattern.
+var urlParam = regexp.MustCompile(`\{[^}]*\}`)
+
+func TestAdminRouterRequiresAdmin(t *testing.T) {
+	tokens := auth.NewIssuer("secret")
+	user, err := tokens.NewAccessToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{tokens: tokens, adminToken: "admin token"}
+	router := cfg.newAdminRouter()
+
+	// The routes named here must stay on the admin router; every other
+	// route found on it is checked as well.
+	routes := map[string]bool{
+		"GET /users":                 true,
+		"PUT /users/{id}/admin":      true,
+		"POST /users/{id}/ban":       true,
+		"DELETE /users/{id}/ban":     true,
+		"DELETE /chirps/{id}":        true,
+		"POST /backup":               true,
+		"POST /restore":              true,
+		"DELETE /profanity":          true,
+		"POST /profanity":            true,
+		"GET /profanity":             true,
+		"POST /chirps/{id}/restore":  true,
+		"POST /jobs/{id}/requeue":    true,
+		"POST /import":               true,
+		"POST /appeals/{id}/resolve": true,
+		"POST /reports/{id}/resolve": true,
+		"DELETE /emoji/{shortcode}":  true,
+		"PUT /emoji/{shortcode}":     true,
+		"POST /chirps/{id}/remove":   true,
+		"POST /users/{id}/suspend":   true,
+	}
+	err = chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes[method+" "+route] = false
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for route, missing := range routes {
+		if missing {
+			t.Errorf("Expecting: %s on the admin router, but it is not", route)
+			continue
+		}
+		method, path, _ := strings.Cut(route, " ")
+		path = urlParam.ReplaceAllString(path, "1")
+		for authorization, status := range map[string]int{"": 401, "Bearer " + user: 403} {
+			t.Logf("Starting test for the admin router with: %s and Authorization %q, and expecting: %d", route, authorization, status)
+			r := httptest.NewRequest(method, path, nil)
+			if authorization != "" {
+				r.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != status {
+				t.Errorf("Expecting: %d, but got: %d", status, w.Code)
+			}
+		}
+	}
+}
+
+func TestDeleteAdminChirp(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("boots@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp(database.Chirp{Body: "hello", AuthorId: user.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, broker: newChirpBroker(), numericChirpIds: true}
+	router := chi.NewRouter()
+	router.Delete("/admin/chirps/{id}", cfg.deleteAdminChirpHandler)
+
+	for _, c := range []struct {
+		id     string
+		status int
+	}{
+		{"not-a-chirp", 404},
+		{"999", 404},
+		{chirp.ShortId, 204},
+		{"1", 404},
+	} {
+		t.Logf("Starting test for deleteAdminChirpHandler with: id %q, and expecting: %d", c.id, c.status)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/admin/chirps/"+c.id, nil))
+		if w.Code != c.status {
+			t.Errorf("Expecting: %d, but got: %d", c.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Product events are counted apart from HTTP traffic, so operators can tell
+// how the instance is used rather than how busy it is.
+const (
+	eventSignup       = "signup"
+	eventChirpCreated = "chirp_created"
+	eventLike         = "like"
+	eventUpgrade      = "upgrade"
+)
+
+// analyticsEvent is one product event as exported to the analytics sink.
+type analyticsEvent struct {
+	Event  string    `json:"event"`
+	UserId int       `json:"user_id,omitempty"`
+	At     time.Time `json:"timestamp"`
+}
+
+// analyticsSink receives product events in batches.
+type analyticsSink interface {
+	Send(ctx context.Context, events []analyticsEvent) error
+}
+
+// analytics counts product events and, when it has a sink, keeps them
+// until the next export. Instances that would rather not count anything
+// turn it off with ANALYTICS=false. A nil *analytics tracks nothing.
+type analytics struct {
+	mux    sync.Mutex
+	counts map[string]int
+	sink   analyticsSink
+	// pending holds the events not exported yet, up to maxPending; the
+	// oldest are dropped beyond that while the sink is down.
+	pending    []analyticsEvent
+	maxPending int
+}
+
+// newAnalytics returns nil when analytics is disabled.
+func newAnalytics(enabled bool, sink analyticsSink, maxPending int) *analytics {
+	if !enabled {
+		return nil
+	}
+	return &analytics{counts: make(map[string]int), sink: sink, maxPending: maxPending}
+}
+
+// track counts event, done by userId, or by nobody in particular when it
+// is zero.
+func (a *analytics) track(event string, userId int) {
+	if a == nil {
+		return
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.counts[event]++
+	if a.sink == nil {
+		return
+	}
+	a.pending = append(a.pending, analyticsEvent{Event: event, UserId: userId, At: time.Now().UTC()})
+	if extra := len(a.pending) - a.maxPending; extra > 0 {
+		a.pending = a.pending[extra:]
+	}
+}
+
+// snapshot returns the counts so far by event.
+func (a *analytics) snapshot() map[string]int {
+	if a == nil {
+		return nil
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	counts := make(map[string]int, len(a.counts))
+	for event, n := range a.counts {
+		counts[event] = n
+	}
+	return counts
+}
+
+// flush sends the pending events to the sink. Events the sink failed to
+// take are kept for the next flush.
+func (a *analytics) flush(ctx context.Context) error {
+	a.mux.Lock()
+	events := a.pending
+	a.pending = nil
+	a.mux.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	err := a.sink.Send(ctx, events)
+	if err != nil {
+		a.mux.Lock()
+		a.pending = append(events, a.pending...)
+		if extra := len(a.pending) - a.maxPending; extra > 0 {
+			a.pending = a.pending[extra:]
+		}
+		a.mux.Unlock()
+	}
+	return err
+}
+
+// exportAnalyticsWorker flushes the pending events every interval, and once
+// more when ctx is done. A sink that is down does not stop it: the events
+// wait for the next flush.
+func (cfg *apiConfig) exportAnalyticsWorker(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return cfg.analytics.flush(flushCtx)
+		case <-ticker.C:
+			if err := cfg.analytics.flush(ctx); err != nil {
+				log.Printf("Error exporting analytics: %s", err)
+			}
+		}
+	}
+}
+
+// newAnalyticsSink returns the sink for target: an http or https URL takes
+// Segment-style batches, anything else names a file events are appended to
+// as JSON lines. An empty target exports nothing.
+func newAnalyticsSink(target, writeKey string) analyticsSink {
+	switch {
+	case target == "":
+		return nil
+	case strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://"):
+		return &httpAnalyticsSink{url: target, writeKey: writeKey, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return fileAnalyticsSink{path: target}
+}
+
+// httpAnalyticsSink posts events in the shape of Segment's batch API, with
+// the write key as the basic auth user name, which most analytics
+// collectors accept.
+type httpAnalyticsSink struct {
+	url      string
+	writeKey string
+	client   *http.Client
+}
+
+func (s *httpAnalyticsSink) Send(ctx context.Context, events []analyticsEvent) error {
+	type track struct {
+		Type        string    `json:"type"`
+		Event       string    `json:"event"`
+		UserId      string    `json:"userId,omitempty"`
+		AnonymousId string    `json:"anonymousId,omitempty"`
+		Timestamp   time.Time `json:"timestamp"`
+	}
+	batch := make([]track, len(events))
+	for i, event := range events {
+		batch[i] = track{Type: "track", Event: event.Event, Timestamp: event.At}
+		if event.UserId != 0 {
+			batch[i].UserId = strconv.Itoa(event.UserId)
+		} else {
+			batch[i].AnonymousId = "chirpy"
+		}
+	}
+	body, err := json.Marshal(map[string]any{"batch": batch})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.writeKey != "" {
+		req.SetBasicAuth(s.writeKey, "")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("analytics sink answered %s", resp.Status)
+	}
+	return nil
+}
+
+// fileAnalyticsSink appends events to a file, one JSON object a line.
+type fileAnalyticsSink struct {
+	path string
+}
+
+func (s fileAnalyticsSink) Send(ctx context.Context, events []analyticsEvent) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
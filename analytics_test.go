@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnalytics(t *testing.T) {
+	t.Logf("Starting test for analytics with: analytics turned off, and expecting: nothing counted")
+	off := newAnalytics(false, nil, 10)
+	off.track(eventSignup, 1)
+	if counts := off.snapshot(); counts != nil {
+		t.Errorf("Expecting: no counts, but got: %v", counts)
+	}
+
+	t.Logf("Starting test for analytics with: no sink, and expecting: events counted but not kept")
+	counting := newAnalytics(true, nil, 10)
+	counting.track(eventSignup, 1)
+	counting.track(eventLike, 1)
+	counting.track(eventLike, 2)
+	if counts := counting.snapshot(); counts[eventSignup] != 1 || counts[eventLike] != 2 || len(counting.pending) != 0 {
+		t.Errorf("Expecting: 1 signup and 2 likes, none pending, but got: %v and %d pending", counts, len(counting.pending))
+	}
+
+	t.Logf("Starting test for analytics with: an HTTP sink that fails once, and expecting: the events sent on the next flush")
+	var batches []map[string][]map[string]any
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "write-key" {
+			t.Errorf("Expecting: write-key, but got: %q", user)
+		}
+		if failing {
+			w.WriteHeader(503)
+			return
+		}
+		batch := map[string][]map[string]any{}
+		json.NewDecoder(r.Body).Decode(&batch)
+		batches = append(batches, batch)
+	}))
+	defer server.Close()
+	exporting := newAnalytics(true, newAnalyticsSink(server.URL, "write-key"), 2)
+	exporting.track(eventChirpCreated, 1)
+	if err := exporting.flush(context.Background()); err == nil {
+		t.Errorf("Expecting: an error, but got: %v", err)
+	}
+	exporting.track(eventUpgrade, 2)
+	exporting.track(eventUpgrade, 3)
+	failing = false
+	if err := exporting.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 || len(batches[0]["batch"]) != 2 || batches[0]["batch"][1]["userId"] != "3" || batches[0]["batch"][0]["event"] != eventUpgrade {
+		t.Errorf("Expecting: the 2 newest events in one batch, but got: %v", batches)
+	}
+
+	t.Logf("Starting test for analytics with: a file sink, and expecting: one JSON line per event")
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	toFile := newAnalytics(true, newAnalyticsSink(path, ""), 10)
+	toFile.track(eventSignup, 4)
+	toFile.track(eventLike, 4)
+	if err := toFile.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"event":"signup"`) {
+		t.Errorf("Expecting: 2 lines, the first a signup, but got: %s", data)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// Machine clients may sign requests with an API key instead of sending a JWT.
+// The signature is the hex encoded HMAC-SHA256, keyed with the API key secret, of
+//
+//	METHOD + "\n" + REQUEST_URI + "\n" + TIMESTAMP + "\n" + NONCE + "\n" + hex(sha256(body))
+//
+// where TIMESTAMP is the Unix time sent in the X-Chirpy-Timestamp header and
+// NONCE a value sent in the X-Chirpy-Nonce header that the key never signs
+// twice. Each nonce is only accepted once per key, so a captured request
+// cannot be replayed while its timestamp is still fresh.
+const (
+	signatureKeyHeader       = "X-Chirpy-Key-Id"
+	signatureTimestampHeader = "X-Chirpy-Timestamp"
+	signatureNonceHeader     = "X-Chirpy-Nonce"
+	signatureHeader          = "X-Chirpy-Signature"
+	signatureMaxSkew         = 5 * time.Minute
+)
+
+type contextKey string
+
+const signedUserIdKey contextKey = "signedUserId"
+
+// signedUserId returns the user that signed r, if middlewareRequestSignature verified it.
+func signedUserId(r *http.Request) (int, bool) {
+	id, ok := r.Context().Value(signedUserIdKey).(int)
+	return id, ok
+}
+
+func (cfg *apiConfig) middlewareRequestSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature := r.Header.Get(signatureHeader)
+		if signature == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The body is held whole to check the signature, so it is capped
+		// at the largest any API route takes, an upload's.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.mediaMaxBytes+1<<20))
+		if err != nil {
+			respondParamsDecodingError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		keyId := r.Header.Get(signatureKeyHeader)
+		key, found, err := cfg.store(r.Context()).GetAPIKey(keyId)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		if !found {
+			log.Printf("Rejected signed request with unknown key %q", keyId)
+			w.WriteHeader(401)
+			return
+		}
+		timestamp, nonce := r.Header.Get(signatureTimestampHeader), r.Header.Get(signatureNonceHeader)
+		now := time.Now()
+		if !timestampWithinSkew(timestamp, now) {
+			log.Printf("Rejected signed request with stale timestamp from key %q", keyId)
+			w.WriteHeader(401)
+			return
+		}
+		if nonce == "" {
+			log.Printf("Rejected signed request without a nonce from key %q", keyId)
+			w.WriteHeader(401)
+			return
+		}
+		expected := signRequest(key.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			log.Printf("Rejected signed request with bad signature from key %q", keyId)
+			w.WriteHeader(401)
+			return
+		}
+		// As with Polka's, only correctly signed nonces are remembered.
+		if !cfg.signatureNonces.use(keyId+":"+nonce, now) {
+			log.Printf("Rejected replayed signed request from key %q", keyId)
+			w.WriteHeader(401)
+			return
+		}
+		ctx := context.WithValue(r.Context(), signedUserIdKey, key.UserId)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func signRequest(secret, method, requestURI, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func timestampWithinSkew(timestamp string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	return skew < signatureMaxSkew && skew > -signatureMaxSkew
+}
+
+// API keys are managed with an access token only, so that a leaked key
+// cannot be used to mint more keys.
+func (cfg *apiConfig) postAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.accessTokenUserId(r)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		Name string `json:"name"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	key, err := cfg.store(r.Context()).CreateAPIKey(userId, params.Name)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+
+	type returnVal struct {
+		database.APIKey
+		Secret string `json:"secret"`
+	}
+	data, err := json.Marshal(returnVal{APIKey: key, Secret: key.Secret})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.accessTokenUserId(r)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	keys, err := cfg.store(r.Context()).GetAPIKeys(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := cfg.accessTokenUserId(r)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	err = cfg.store(r.Context()).DeleteAPIKey(chi.URLParam(r, "id"), userId)
+	if err != nil {
+		respondDatabaseError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
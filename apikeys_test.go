@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestRequestSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	runTimestampWithinSkewTest(t, strconv.FormatInt(now.Unix(), 10), now, true)
+	runTimestampWithinSkewTest(t, strconv.FormatInt(now.Add(-time.Minute).Unix(), 10), now, true)
+	runTimestampWithinSkewTest(t, strconv.FormatInt(now.Add(-time.Hour).Unix(), 10), now, false)
+	runTimestampWithinSkewTest(t, strconv.FormatInt(now.Add(time.Hour).Unix(), 10), now, false)
+	runTimestampWithinSkewTest(t, "yesterday", now, false)
+
+	a := signRequest("secret", "POST", "/api/chirps", "1700000000", "n1", []byte(`{"body":"hi"}`))
+	b := signRequest("secret", "POST", "/api/chirps", "1700000000", "n1", []byte(`{"body":"hello"}`))
+	t.Logf("Starting test for signRequest with differing bodies, and expecting differing signatures")
+	if a == b {
+		t.Errorf("Expecting differing signatures, but got: %s for both", a)
+	}
+}
+
+func TestMiddlewareRequestSignature(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("boots@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := db.CreateAPIKey(user.Id, "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, mediaMaxBytes: 1 << 10, signatureNonces: newNonceCache()}
+	handler := cfg.middlewareRequestSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userId, ok := signedUserId(r); !ok || userId != user.Id {
+			w.WriteHeader(401)
+		}
+	}))
+	send := func(nonce, body string) int {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		r := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(body))
+		r.Header.Set(signatureKeyHeader, key.Id)
+		r.Header.Set(signatureTimestampHeader, timestamp)
+		r.Header.Set(signatureNonceHeader, nonce)
+		r.Header.Set(signatureHeader, signRequest(key.Secret, "POST", "/api/chirps", timestamp, nonce, []byte(body)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	cases := []struct {
+		name, nonce, body string
+		status            int
+	}{
+		{"a fresh nonce", "n1", `{"body":"hi"}`, 200},
+		{"the same nonce again", "n1", `{"body":"hi"}`, 401},
+		{"no nonce", "", `{"body":"hi"}`, 401},
+		{"another nonce", "n2", `{"body":"hi"}`, 200},
+		{"a body over the cap", "n3", strings.Repeat("x", 1<<10+1<<20+1), 413},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for middlewareRequestSignature with: %s, and expecting: %d", c.name, c.status)
+		if got := send(c.nonce, c.body); got != c.status {
+			t.Errorf("Expecting: %d, but got: %d", c.status, got)
+		}
+	}
+}
+
+func runTimestampWithinSkewTest(t *testing.T, timestamp string, now time.Time, expecting bool) {
+	t.Logf("Starting test for timestampWithinSkew with: \"%s\", and expecting: %t", timestamp, expecting)
+	got := timestampWithinSkew(timestamp, now)
+	if got != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, got)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// authenticate identifies the user making the request, either from a verified
+// request signature or from a chirpy access token. It responds with a 401 and
+// returns false when the request carries neither.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, ok := cfg.requestUserId(r)
+	if !ok {
+		w.WriteHeader(401)
+		return 0, false
+	}
+	return id, true
+}
+
+// requestUserId returns the user r is made on behalf of, if it is signed or
+// carries a valid access token, for handlers that also serve anonymous
+// clients.
+func (cfg *apiConfig) requestUserId(r *http.Request) (int, bool) {
+	if id, ok := signedUserId(r); ok {
+		return id, true
+	}
+	id, err := cfg.accessTokenUserId(r)
+	return id, err == nil
+}
+
+// accessTokenUserId validates the bearer access token on r and returns its subject.
+func (cfg *apiConfig) accessTokenUserId(r *http.Request) (int, error) {
+	return cfg.tokens.Validate(bearerToken(r), auth.AccessIssuer)
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// tokenExpiry tells clients when the tokens of a login or refresh response
+// run out, so they can refresh ahead of time without decoding the JWT.
+type tokenExpiry struct {
+	TokenType             string    `json:"token_type"`
+	ExpiresIn             int       `json:"expires_in"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshTokenExpiresIn int       `json:"refresh_token_expires_in"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// newTokenExpiry describes an access token issued no earlier than issuedAt
+// and the session holding its refresh token. Times are in whole seconds,
+// like the token's exp claim, and never later than the real expiry.
+func newTokenExpiry(issuedAt time.Time, session database.Session) tokenExpiry {
+	expiresAt := issuedAt.Add(auth.AccessTokenTTL).Truncate(time.Second).UTC()
+	refreshExpiresAt := session.ExpiresAt.Truncate(time.Second).UTC()
+	return tokenExpiry{
+		TokenType:             "Bearer",
+		ExpiresIn:             int(auth.AccessTokenTTL / time.Second),
+		ExpiresAt:             expiresAt,
+		RefreshTokenExpiresIn: int(refreshExpiresAt.Sub(issuedAt) / time.Second),
+		RefreshTokenExpiresAt: refreshExpiresAt,
+	}
+}
+
+// Token response formats. The chirpy format is the user plus token and
+// refresh_token; the oauth2 format is the RFC 6749 envelope that
+// off-the-shelf OAuth2 client libraries expect.
+const (
+	tokenFormatChirpy = "chirpy"
+	tokenFormatOAuth2 = "oauth2"
+)
+
+// tokenFormatHeader lets a client ask for a token format other than the
+// server's default.
+const tokenFormatHeader = "X-Token-Format"
+
+func validTokenFormat(format string) bool {
+	return format == tokenFormatChirpy || format == tokenFormatOAuth2
+}
+
+// requestTokenFormat is the token format r asks for, or the server's
+// default. It answers 400 and returns false for unknown formats.
+func (cfg *apiConfig) requestTokenFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.Header.Get(tokenFormatHeader)
+	if format == "" {
+		return cfg.tokenFormat, true
+	}
+	if !validTokenFormat(format) {
+		respondValidationError(w, tokenFormatHeader+" must be "+tokenFormatChirpy+" or "+tokenFormatOAuth2)
+		return "", false
+	}
+	return format, true
+}
+
+// respondOAuth2Token answers with the RFC 6749 section 5.1 token response.
+// The refresh token is left out when it is empty, as it is when it was set
+// in the remember-me cookie.
+func respondOAuth2Token(w http.ResponseWriter, accessToken, refreshToken string, expiry tokenExpiry) {
+	type returnVal struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+	}
+	data, err := json.Marshal(returnVal{
+		AccessToken:  accessToken,
+		TokenType:    expiry.TokenType,
+		ExpiresIn:    expiry.ExpiresIn,
+		RefreshToken: refreshToken,
+	})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,230 @@
+// Package auth issues and validates the access JWTs Chirpy hands out at
+// login. Refresh tokens are opaque and live in the session store instead.
+//
+// Applications embedding Chirpy can attach their own claims to every token
+// with RegisterClaimsHook, and enforce them on incoming tokens with
+// RegisterClaimsValidator, typically from an init function.
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessIssuer = "chirpy-access"
+
+	AccessTokenTTL = 1 * time.Hour
+	// RefreshTokenTTL is how long a session lives without being refreshed.
+	RefreshTokenTTL = (60 * 24) * time.Hour
+)
+
+// RoleAdmin is the role that lets a user's access tokens call the admin
+// endpoints.
+const RoleAdmin = "admin"
+
+var (
+	ErrWrongIssuer = errors.New("token was not issued for this purpose")
+	ErrUnknownKey  = errors.New("token was not signed by a known key")
+)
+
+// ClaimsHook adds custom claims to a token for userId before it is signed.
+// issuer is AccessIssuer. The registered claims (iss, sub,
+// iat, exp) and roles are set after the hooks run and cannot be overridden.
+type ClaimsHook func(userId int, issuer string, claims jwt.MapClaims) error
+
+// ClaimsValidator inspects the claims of an incoming token whose signature,
+// expiry, and issuer have already been checked. Returning an error rejects
+// the token.
+type ClaimsValidator func(issuer string, claims jwt.MapClaims) error
+
+var (
+	extensionsMux sync.RWMutex
+	claimsHooks   []ClaimsHook
+	validators    []ClaimsValidator
+)
+
+func RegisterClaimsHook(hook ClaimsHook) {
+	extensionsMux.Lock()
+	defer extensionsMux.Unlock()
+	claimsHooks = append(claimsHooks, hook)
+}
+
+func RegisterClaimsValidator(validator ClaimsValidator) {
+	extensionsMux.Lock()
+	defer extensionsMux.Unlock()
+	validators = append(validators, validator)
+}
+
+// Issuer signs and validates tokens. It signs with its first key and
+// accepts tokens signed by any of them, so a key being retired keeps
+// validating its tokens until they expire.
+type Issuer struct {
+	keys []signingKey
+}
+
+// signingKey is either an HMAC secret or a private key whose public half is
+// published as a JWK. Its id goes in the kid header of the tokens it signs.
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+	jwk       *JWK // nil for HMAC secrets
+	// retireAfter is when the key stops validating tokens, zero for never.
+	retireAfter time.Time
+}
+
+// retired reports whether the key has stopped validating tokens at now.
+func (k signingKey) retired(now time.Time) bool {
+	return !k.retireAfter.IsZero() && now.After(k.retireAfter)
+}
+
+func hmacKey(id string, secret []byte) signingKey {
+	return signingKey{id: id, method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// NewIssuer signs tokens with HS256 and secret. It is the fallback for
+// instances without a private key, whose tokens only Chirpy can check.
+func NewIssuer(secret string) *Issuer {
+	return &Issuer{keys: []signingKey{hmacKey("", []byte(secret))}}
+}
+
+// NewAccessToken issues an access token for userId carrying the given roles
+// in its "roles" claim.
+func (i *Issuer) NewAccessToken(userId int, roles ...string) (string, error) {
+	return i.newToken(userId, roles, AccessIssuer, AccessTokenTTL)
+}
+
+func (i *Issuer) newToken(userId int, roles []string, issuer string, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{}
+	extensionsMux.RLock()
+	hooks := claimsHooks
+	extensionsMux.RUnlock()
+	for _, hook := range hooks {
+		if err := hook(userId, issuer, claims); err != nil {
+			return "", err
+		}
+	}
+	now := time.Now()
+	claims["iss"] = issuer
+	claims["sub"] = strconv.Itoa(userId)
+	claims["iat"] = jwt.NewNumericDate(now)
+	claims["exp"] = jwt.NewNumericDate(now.Add(ttl))
+	if len(roles) > 0 {
+		claims["roles"] = roles
+	} else {
+		delete(claims, "roles")
+	}
+
+	key := i.keys[0]
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+	return token.SignedString(key.signKey)
+}
+
+// Claims are what Chirpy reads from a validated token.
+type Claims struct {
+	UserId int
+	Roles  []string
+}
+
+// HasRole reports whether the token was issued with role.
+func (c Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// Validate checks that token is a well formed, unexpired token from issuer
+// and returns the id of the user it was issued to.
+func (i *Issuer) Validate(token, issuer string) (int, error) {
+	claims, err := i.ValidateClaims(token, issuer)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserId, nil
+}
+
+// ValidateClaims is Validate, also returning the roles the token carries.
+func (i *Issuer) ValidateClaims(token, issuer string) (Claims, error) {
+	claims := jwt.MapClaims{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, i.verificationKey)
+	if err != nil {
+		return Claims{}, err
+	}
+	tokenIssuer, err := parsedToken.Claims.GetIssuer()
+	if err != nil {
+		return Claims{}, err
+	}
+	if tokenIssuer != issuer {
+		return Claims{}, ErrWrongIssuer
+	}
+	extensionsMux.RLock()
+	checks := validators
+	extensionsMux.RUnlock()
+	for _, validate := range checks {
+		if err := validate(issuer, claims); err != nil {
+			return Claims{}, err
+		}
+	}
+	subject, err := parsedToken.Claims.GetSubject()
+	if err != nil {
+		return Claims{}, err
+	}
+	userId, err := strconv.Atoi(subject)
+	if err != nil {
+		return Claims{}, err
+	}
+	roles, err := parseRoles(claims["roles"])
+	if err != nil {
+		return Claims{}, err
+	}
+	return Claims{UserId: userId, Roles: roles}, nil
+}
+
+// verificationKey finds the key named by token's kid header. Tokens without
+// one are accepted by issuers with a single key, or by a key without an id,
+// which covers tokens signed before the instance configured key ids. A key
+// only verifies tokens in its own algorithm, so a public key can never be
+// used as an HMAC secret, and none once it has retired.
+func (i *Issuer) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range i.keys {
+		if key.id != kid && (kid != "" || len(i.keys) > 1) {
+			continue
+		}
+		if token.Method.Alg() != key.method.Alg() || key.retired(time.Now()) {
+			return nil, ErrUnknownKey
+		}
+		return key.verifyKey, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+var errMalformedRoles = errors.New("roles claim must be an array of strings")
+
+// parseRoles reads the roles claim, which decodes from JSON as a []any.
+func parseRoles(value any) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	list, ok := value.([]any)
+	if !ok {
+		return nil, errMalformedRoles
+	}
+	roles := make([]string, 0, len(list))
+	for _, role := range list {
+		role, ok := role.(string)
+		if !ok {
+			return nil, errMalformedRoles
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
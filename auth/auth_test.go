@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func Test(t *testing.T) {
+	issuer := NewIssuer("secret")
+
+	access, err := issuer.NewAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := issuer.newToken(7, nil, "someone-else", AccessTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runValidateTest(t, issuer, access, AccessIssuer, 7, nil)
+	runValidateTest(t, issuer, foreign, AccessIssuer, 0, ErrWrongIssuer)
+
+	admin, err := issuer.NewAccessToken(8, RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRolesTest(t, issuer, access, RoleAdmin, false)
+	runRolesTest(t, issuer, admin, RoleAdmin, true)
+	runRolesTest(t, issuer, admin, "moderator", false)
+
+	errTenant := errors.New("wrong tenant")
+	RegisterClaimsHook(func(userId int, issuer string, claims jwt.MapClaims) error {
+		claims["tenant"] = "acme"
+		claims["sub"] = "should be overridden"
+		return nil
+	})
+	RegisterClaimsValidator(func(issuer string, claims jwt.MapClaims) error {
+		if claims["tenant"] != "acme" {
+			return errTenant
+		}
+		return nil
+	})
+	withTenant, err := issuer.NewAccessToken(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runValidateTest(t, issuer, withTenant, AccessIssuer, 9, nil)
+	runValidateTest(t, issuer, access, AccessIssuer, 0, errTenant)
+}
+
+func runValidateTest(t *testing.T, issuer *Issuer, token, tokenIssuer string, expecting int, expectingErr error) {
+	t.Logf("Starting test for Validate with a %s token, and expecting: %d, %v", tokenIssuer, expecting, expectingErr)
+	got, err := issuer.Validate(token, tokenIssuer)
+	if !errors.Is(err, expectingErr) {
+		t.Errorf("Expecting: %v, but got: %v", expectingErr, err)
+	}
+	if got != expecting {
+		t.Errorf("Expecting: %d, but got: %d", expecting, got)
+	}
+}
+
+func runRolesTest(t *testing.T, issuer *Issuer, token, role string, expecting bool) {
+	t.Logf("Starting test for ValidateClaims with: role %q, and expecting: %t", role, expecting)
+	claims, err := issuer.ValidateClaims(token, AccessIssuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claims.HasRole(role); got != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, got)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported private key: expecting RSA or ECDSA")
+
+// NewKeyIssuer signs tokens with the PEM encoded RSA or ECDSA private key:
+// RS256 for RSA keys, and ES256, ES384 or ES512 depending on the curve for
+// ECDSA ones. Other services can then check Chirpy's tokens against the
+// public keys from JWKS without holding any secret. The key id is the
+// key's RFC 7638 thumbprint.
+func NewKeyIssuer(pemData []byte) (*Issuer, error) {
+	key, err := privateSigningKey("", pemData)
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{keys: []signingKey{key}}, nil
+}
+
+// Key configures one of the keys of NewRotatingIssuer. Exactly one of
+// Secret and PEM is set.
+type Key struct {
+	Id     string // the kid header of the tokens the key signs
+	Secret []byte // an HMAC secret, signing with HS256
+	PEM    []byte // a PEM encoded RSA or ECDSA private key, as for NewKeyIssuer
+	// RetireAfter is when a key that no longer signs stops validating the
+	// tokens it signed, zero for never.
+	RetireAfter time.Time
+}
+
+// NewRotatingIssuer signs new tokens with the first of keys and validates
+// tokens signed by any of them. To rotate, put a new key first and keep the
+// old one after it until the last tokens it signed have expired.
+//
+// Only the first key needs an id. A later key without one validates the
+// tokens signed before the instance configured key ids: those without a
+// kid header for a secret, and those with its thumbprint for a private key.
+func NewRotatingIssuer(keys []Key) (*Issuer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	if keys[0].Id == "" {
+		return nil, errors.New("the signing key needs an id")
+	}
+	if !keys[0].RetireAfter.IsZero() {
+		return nil, fmt.Errorf("signing key %q cannot retire while it signs new tokens", keys[0].Id)
+	}
+	issuer := &Issuer{}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k.Id] {
+			return nil, fmt.Errorf("duplicate signing key id %q", k.Id)
+		}
+		seen[k.Id] = true
+		if (len(k.Secret) == 0) == (len(k.PEM) == 0) {
+			return nil, fmt.Errorf("signing key %q needs either a secret or a private key", k.Id)
+		}
+		if len(k.Secret) > 0 {
+			key := hmacKey(k.Id, k.Secret)
+			key.retireAfter = k.RetireAfter
+			issuer.keys = append(issuer.keys, key)
+			continue
+		}
+		key, err := privateSigningKey(k.Id, k.PEM)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %w", k.Id, err)
+		}
+		key.retireAfter = k.RetireAfter
+		issuer.keys = append(issuer.keys, key)
+	}
+	return issuer, nil
+}
+
+// KeyInfo describes one of an issuer's keys to operators, without any of
+// its secret parts.
+type KeyInfo struct {
+	Id  string `json:"kid"`
+	Alg string `json:"alg"`
+	// Signing is set on the key new tokens are signed with.
+	Signing     bool       `json:"signing"`
+	RetireAfter *time.Time `json:"retire_after,omitempty"`
+	Retired     bool       `json:"retired"`
+}
+
+// Keys describes the issuer's keys as of now, the signing key first.
+func (i *Issuer) Keys(now time.Time) []KeyInfo {
+	infos := make([]KeyInfo, len(i.keys))
+	for n, key := range i.keys {
+		infos[n] = KeyInfo{Id: key.id, Alg: key.method.Alg(), Signing: n == 0, Retired: key.retired(now)}
+		if !key.retireAfter.IsZero() {
+			retireAfter := key.retireAfter
+			infos[n].RetireAfter = &retireAfter
+		}
+	}
+	return infos
+}
+
+// privateSigningKey reads a private key, identified by its thumbprint when
+// id is empty.
+func privateSigningKey(id string, pemData []byte) (signingKey, error) {
+	key, err := parsePrivateKey(pemData)
+	if err != nil {
+		return signingKey{}, err
+	}
+	var method jwt.SigningMethod
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return signingKey{}, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, key.Curve.Params().Name)
+		}
+	}
+	jwk, err := publicJWK(key.Public(), method.Alg())
+	if err != nil {
+		return signingKey{}, err
+	}
+	if id != "" {
+		jwk.Kid = id
+	}
+	return signingKey{id: jwk.Kid, method: method, signKey: key, verifyKey: key.Public(), jwk: &jwk}, nil
+}
+
+// parsePrivateKey reads the first PEM block of pemData, in PKCS #1, SEC 1
+// or PKCS #8 form.
+func parsePrivateKey(pemData []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// JWK is a public key in the JSON Web Key format of RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the issuer's private keys, including
+// those being retired until they retire. HMAC secrets must never be
+// published, so an issuer with only secrets has an empty set.
+func (i *Issuer) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range i.keys {
+		if key.jwk != nil && !key.retired(now) {
+			set.Keys = append(set.Keys, *key.jwk)
+		}
+	}
+	return set
+}
+
+// publicJWK describes key for alg, with its RFC 7638 thumbprint as the key
+// id.
+func publicJWK(key crypto.PublicKey, alg string) (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	var jwk JWK
+	var members any
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		jwk = JWK{Kty: "RSA", N: b64(key.N.Bytes()), E: b64(big.NewInt(int64(key.E)).Bytes())}
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk = JWK{Kty: "EC", Crv: key.Curve.Params().Name, X: b64(key.X.FillBytes(make([]byte, size))), Y: b64(key.Y.FillBytes(make([]byte, size)))}
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		return JWK{}, ErrUnsupportedKey
+	}
+	// The thumbprint hashes the required members in lexicographic order,
+	// which is the order of the struct fields above.
+	data, err := json.Marshal(members)
+	if err != nil {
+		return JWK{}, err
+	}
+	sum := sha256.Sum256(data)
+	jwk.Use, jwk.Alg, jwk.Kid = "sig", alg, b64(sum[:])
+	return jwk, nil
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestKeyIssuer(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runKeyIssuerTest(t, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), "RS256")
+	runKeyIssuerTest(t, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}), "RS256")
+	runKeyIssuerTest(t, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}), "ES256")
+
+	t.Logf("Starting test for NewKeyIssuer with: no PEM data, and expecting: an error")
+	if _, err := NewKeyIssuer([]byte("secret")); err == nil {
+		t.Errorf("Expecting: an error, but got: nil")
+	}
+
+	t.Logf("Starting test for JWKS with: an HMAC issuer, and expecting: no keys")
+	if keys := NewIssuer("secret").JWKS().Keys; len(keys) != 0 {
+		t.Errorf("Expecting: no keys, but got: %v", keys)
+	}
+}
+
+func runKeyIssuerTest(t *testing.T, pemData []byte, alg string) {
+	t.Logf("Starting test for NewKeyIssuer with: a %s key, and expecting: tokens it and its JWK validate", alg)
+	issuer, err := NewKeyIssuer(pemData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := issuer.NewAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runValidateTest(t, issuer, token, AccessIssuer, 7, nil)
+
+	keys := issuer.JWKS().Keys
+	if len(keys) != 1 || keys[0].Alg != alg {
+		t.Fatalf("Expecting: one %s key, but got: %v", alg, keys)
+	}
+	parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != keys[0].Kid {
+			t.Errorf("Expecting: kid %s, but got: %v", keys[0].Kid, token.Header["kid"])
+		}
+		return jwkPublicKey(t, keys[0]), nil
+	})
+	if err != nil || !parsed.Valid {
+		t.Errorf("Expecting: a token valid under the JWK, but got: %v", err)
+	}
+
+	forged, err := NewIssuer("secret").NewAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Validate(forged, AccessIssuer); err == nil {
+		t.Errorf("Expecting: HS256 tokens to be rejected, but got: nil")
+	}
+}
+
+// jwkPublicKey rebuilds the public key a JWK describes, the way a service
+// reading the JWKS would.
+func jwkPublicKey(t *testing.T, jwk JWK) any {
+	decode := func(s string) *big.Int {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return new(big.Int).SetBytes(data)
+	}
+	if jwk.Kty == "RSA" {
+		return &rsa.PublicKey{N: decode(jwk.N), E: int(decode(jwk.E).Int64())}
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: decode(jwk.X), Y: decode(jwk.Y)}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example key and thumbprint from RFC 7638, section 3.1.
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	expecting := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	t.Logf("Starting test for publicJWK with: the RFC 7638 key, and expecting: kid %s", expecting)
+	jwk, err := publicJWK(key, "RS256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.Kid != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, jwk.Kid)
+	}
+}
+
+func TestRotatingIssuer(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKey := Key{Id: "2023", Secret: []byte("old secret")}
+	newKey := Key{Id: "2024", PEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})}
+
+	before, err := NewRotatingIssuer([]Key{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotating, err := NewRotatingIssuer([]Key{newKey, oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired, err := NewRotatingIssuer([]Key{newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.NewAccessToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := rotating.NewAccessToken(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noKid, err := NewIssuer("old secret").NewAccessToken(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runValidateTest(t, rotating, oldToken, AccessIssuer, 7, nil)
+	runValidateTest(t, rotating, newToken, AccessIssuer, 8, nil)
+	runValidateTest(t, retired, newToken, AccessIssuer, 8, nil)
+	runValidateTest(t, retired, oldToken, AccessIssuer, 0, ErrUnknownKey)
+	runValidateTest(t, rotating, noKid, AccessIssuer, 0, ErrUnknownKey)
+	runValidateTest(t, before, noKid, AccessIssuer, 9, nil)
+
+	t.Logf("Starting test for JWKS with: an EC key and a secret, and expecting: the EC key only")
+	if keys := rotating.JWKS().Keys; len(keys) != 1 || keys[0].Kid != newKey.Id {
+		t.Errorf("Expecting: key %s, but got: %v", newKey.Id, keys)
+	}
+
+	legacy, err := NewRotatingIssuer([]Key{newKey, {Secret: []byte("old secret")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runValidateTest(t, legacy, noKid, AccessIssuer, 9, nil)
+	runValidateTest(t, legacy, newToken, AccessIssuer, 8, nil)
+
+	past := time.Now().Add(-time.Minute)
+	expired, err := NewRotatingIssuer([]Key{newKey, {Id: oldKey.Id, Secret: oldKey.Secret, RetireAfter: past}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runValidateTest(t, expired, oldToken, AccessIssuer, 0, ErrUnknownKey)
+	runValidateTest(t, expired, newToken, AccessIssuer, 8, nil)
+
+	t.Logf("Starting test for Keys with: a signing key and a retired one, and expecting: both, described")
+	infos := expired.Keys(time.Now())
+	if len(infos) != 2 || !infos[0].Signing || infos[0].Alg != "ES256" || infos[1].Signing || !infos[1].Retired ||
+		infos[1].RetireAfter == nil || !infos[1].RetireAfter.Equal(past) {
+		t.Errorf("Expecting: a signing ES256 key and a retired secret, but got: %+v", infos)
+	}
+
+	for _, keys := range [][]Key{nil, {{Id: "a", Secret: []byte("x")}, {Id: "a", Secret: []byte("y")}}, {{Id: "a"}}, {{Secret: []byte("x")}},
+		{{Id: "a", Secret: []byte("x"), RetireAfter: past}}} {
+		t.Logf("Starting test for NewRotatingIssuer with: %d keys, and expecting: an error", len(keys))
+		if _, err := NewRotatingIssuer(keys); err == nil {
+			t.Errorf("Expecting: an error, but got: nil")
+		}
+	}
+}
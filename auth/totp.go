@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// TOTP parameters, the defaults of RFC 6238 that every authenticator app
+// supports.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30 * time.Second
+)
+
+// totpSkew is how many periods either side of the current one a code is
+// still accepted, allowing for clocks that drift and codes typed slowly.
+const totpSkew = 1
+
+// NewTOTPSecret returns a random 160 bit TOTP secret.
+func NewTOTPSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeTOTPSecret returns secret in the base32 form users type into their
+// authenticator app.
+func EncodeTOTPSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps read from a QR
+// code, for the account of a user of issuer.
+func TOTPURI(issuer, account string, secret []byte) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+		RawQuery: url.Values{
+			"secret": {EncodeTOTPSecret(secret)},
+			"issuer": {issuer},
+		}.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPCode returns the code for secret at the given time, per RFC 6238
+// with HMAC-SHA1.
+func TOTPCode(secret []byte, at time.Time) string {
+	return totpCode(secret, uint64(at.Unix()/int64(TOTPPeriod/time.Second)))
+}
+
+func totpCode(secret []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	modulus := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulus)
+}
+
+// ValidTOTP reports whether code is the code for secret at the given time,
+// or for one of the periods next to it.
+func ValidTOTP(secret []byte, code string, at time.Time) bool {
+	_, ok := MatchTOTP(secret, code, at)
+	return ok
+}
+
+// MatchTOTP is ValidTOTP that also returns the time step code was for, so
+// callers can refuse a step they have already accepted (RFC 6238 §5.2).
+func MatchTOTP(secret []byte, code string, at time.Time) (int64, bool) {
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	counter := at.Unix() / int64(TOTPPeriod/time.Second)
+	var matched int64
+	valid := 0
+	for step := counter - totpSkew; step <= counter+totpSkew; step++ {
+		equal := subtle.ConstantTimeCompare([]byte(totpCode(secret, uint64(step))), []byte(code))
+		if equal == 1 {
+			matched = step
+		}
+		valid |= equal
+	}
+	return matched, valid == 1
+}
+
+var ErrSealedDataInvalid = errors.New("sealed data is corrupt or was sealed with another key")
+
+// SecretBox encrypts secrets the server must be able to read back, such as
+// TOTP secrets, before they are stored, so a leaked database alone does not
+// give them away.
+type SecretBox struct {
+	aead cipher.AEAD
+	// previous open what was sealed before the key was rotated.
+	previous []cipher.AEAD
+}
+
+// NewSecretBox seals with AES-256-GCM under a key derived from key, which
+// can be any string with enough entropy. It also opens what was sealed
+// under any of the previous keys, until Reseal has moved it to key.
+func NewSecretBox(key string, previous ...string) (*SecretBox, error) {
+	aead, err := newSecretAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	box := &SecretBox{aead: aead}
+	for _, key := range previous {
+		aead, err := newSecretAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		box.previous = append(box.previous, aead)
+	}
+	return box, nil
+}
+
+func newSecretAEAD(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PreviousKeys returns how many previous keys the box still opens with.
+func (b *SecretBox) PreviousKeys() int {
+	return len(b.previous)
+}
+
+// Seal encrypts plaintext under a random nonce, which it prepends to the
+// result.
+func (b *SecretBox) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts what Seal returned, under the current key or a previous
+// one.
+func (b *SecretBox) Open(sealed []byte) ([]byte, error) {
+	plaintext, _, err := b.open(sealed)
+	return plaintext, err
+}
+
+// Reseal returns sealed under the current key, and whether it had to be
+// sealed again because it was under a previous key.
+func (b *SecretBox) Reseal(sealed []byte) ([]byte, bool, error) {
+	plaintext, current, err := b.open(sealed)
+	if err != nil || current {
+		return sealed, false, err
+	}
+	resealed, err := b.Seal(plaintext)
+	if err != nil {
+		return nil, false, err
+	}
+	return resealed, true, nil
+}
+
+// open decrypts sealed and reports whether it was under the current key.
+func (b *SecretBox) open(sealed []byte) ([]byte, bool, error) {
+	for i, aead := range append([]cipher.AEAD{b.aead}, b.previous...) {
+		size := aead.NonceSize()
+		if len(sealed) < size {
+			return nil, false, ErrSealedDataInvalid
+		}
+		plaintext, err := aead.Open(nil, sealed[:size], sealed[size:], nil)
+		if err == nil {
+			return plaintext, i == 0, nil
+		}
+	}
+	return nil, false, ErrSealedDataInvalid
+}
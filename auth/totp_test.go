@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238, appendix B, cut to six digits.
+	secret := []byte("12345678901234567890")
+	runTOTPCodeTest(t, secret, 59, "287082")
+	runTOTPCodeTest(t, secret, 1111111109, "081804")
+	runTOTPCodeTest(t, secret, 1234567890, "005924")
+	runTOTPCodeTest(t, secret, 2000000000, "279037")
+
+	now := time.Unix(1111111109, 0)
+	runValidTOTPTest(t, secret, TOTPCode(secret, now), now, true)
+	runValidTOTPTest(t, secret, TOTPCode(secret, now.Add(-TOTPPeriod)), now, true)
+	runValidTOTPTest(t, secret, TOTPCode(secret, now.Add(TOTPPeriod)), now, true)
+	runValidTOTPTest(t, secret, TOTPCode(secret, now.Add(-3*TOTPPeriod)), now, false)
+	runValidTOTPTest(t, secret, "", now, false)
+	runValidTOTPTest(t, []byte("another secret"), TOTPCode(secret, now), now, false)
+
+	t.Logf("Starting test for MatchTOTP with: the code of the next period, and expecting: its step")
+	if step, ok := MatchTOTP(secret, TOTPCode(secret, now.Add(TOTPPeriod)), now); !ok || step != now.Unix()/30+1 {
+		t.Errorf("Expecting: step %d, but got: %d, %v", now.Unix()/30+1, step, ok)
+	}
+
+	t.Logf("Starting test for TOTPURI with: an account, and expecting: an otpauth URI with the base32 secret")
+	uri := TOTPURI("Chirpy", "boots@example.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:boots@example.com?") || !strings.Contains(uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ") {
+		t.Errorf("Expecting: an otpauth URI, but got: %s", uri)
+	}
+}
+
+func runTOTPCodeTest(t *testing.T, secret []byte, unix int64, expecting string) {
+	t.Logf("Starting test for TOTPCode with: time %d, and expecting: %s", unix, expecting)
+	if code := TOTPCode(secret, time.Unix(unix, 0)); code != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, code)
+	}
+}
+
+func runValidTOTPTest(t *testing.T, secret []byte, code string, at time.Time, expecting bool) {
+	t.Logf("Starting test for ValidTOTP with: code %q, and expecting: %v", code, expecting)
+	if valid := ValidTOTP(secret, code, at); valid != expecting {
+		t.Errorf("Expecting: %v, but got: %v", expecting, valid)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewSecretBox("other key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("a TOTP secret")
+	sealed, err := box.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for SecretBox with: a sealed secret, and expecting: it opens under its key only")
+	if bytes.Contains(sealed, plaintext) {
+		t.Errorf("Expecting: ciphertext, but got: %q", sealed)
+	}
+	opened, err := box.Open(sealed)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expecting: %q, but got: %q, %v", plaintext, opened, err)
+	}
+	if _, err := other.Open(sealed); err != ErrSealedDataInvalid {
+		t.Errorf("Expecting: %v, but got: %v", ErrSealedDataInvalid, err)
+	}
+	if _, err := box.Open(sealed[:4]); err != ErrSealedDataInvalid {
+		t.Errorf("Expecting: %v, but got: %v", ErrSealedDataInvalid, err)
+	}
+
+	t.Logf("Starting test for SecretBox with: a rotated key, and expecting: old secrets open and reseal under the new key")
+	rotated, err := NewSecretBox("other key", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := rotated.Open(sealed); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expecting: %q, but got: %q, %v", plaintext, opened, err)
+	}
+	resealed, changed, err := rotated.Reseal(sealed)
+	if err != nil || !changed {
+		t.Fatalf("Expecting: a resealed secret, but got: %v, %v", changed, err)
+	}
+	if opened, err := other.Open(resealed); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expecting: %q under the new key, but got: %q, %v", plaintext, opened, err)
+	}
+	if again, changed, err := rotated.Reseal(resealed); err != nil || changed || !bytes.Equal(again, resealed) {
+		t.Errorf("Expecting: the secret left as it is, but got: %v, %v", changed, err)
+	}
+}
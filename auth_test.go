@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestNewTokenExpiry(t *testing.T) {
+	issuedAt := time.Date(2024, 1, 2, 3, 4, 5, 600_000_000, time.UTC)
+	session := database.Session{ExpiresAt: issuedAt.Add(auth.RefreshTokenTTL)}
+	expecting := tokenExpiry{
+		TokenType:             "Bearer",
+		ExpiresIn:             3600,
+		ExpiresAt:             time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC),
+		RefreshTokenExpiresIn: int(auth.RefreshTokenTTL/time.Second) - 1,
+		RefreshTokenExpiresAt: time.Date(2024, 3, 2, 3, 4, 5, 0, time.UTC),
+	}
+	t.Logf("Starting test for newTokenExpiry with: %s, and expecting: %+v", issuedAt, expecting)
+	if got := newTokenExpiry(issuedAt, session); got != expecting {
+		t.Errorf("Expecting: %+v, but got: %+v", expecting, got)
+	}
+}
+
+func TestRequestTokenFormat(t *testing.T) {
+	cfg := &apiConfig{tokenFormat: tokenFormatChirpy}
+	runRequestTokenFormatTest(t, cfg, "", tokenFormatChirpy, 0)
+	runRequestTokenFormatTest(t, cfg, tokenFormatOAuth2, tokenFormatOAuth2, 0)
+	runRequestTokenFormatTest(t, cfg, "saml", "", 400)
+	cfg.tokenFormat = tokenFormatOAuth2
+	runRequestTokenFormatTest(t, cfg, "", tokenFormatOAuth2, 0)
+	runRequestTokenFormatTest(t, cfg, tokenFormatChirpy, tokenFormatChirpy, 0)
+}
+
+func runRequestTokenFormatTest(t *testing.T, cfg *apiConfig, header, expecting string, expectingStatus int) {
+	t.Logf("Starting test for requestTokenFormat with: default %s and header %q, and expecting: %q (status %d)", cfg.tokenFormat, header, expecting, expectingStatus)
+	r := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+	if header != "" {
+		r.Header.Set(tokenFormatHeader, header)
+	}
+	w := httptest.NewRecorder()
+	format, ok := cfg.requestTokenFormat(w, r)
+	if ok != (expectingStatus == 0) || format != expecting {
+		t.Errorf("Expecting: %q, %t, but got: %q, %t", expecting, expectingStatus == 0, format, ok)
+	}
+	if expectingStatus != 0 && w.Code != expectingStatus {
+		t.Errorf("Expecting: %d, but got: %d", expectingStatus, w.Code)
+	}
+}
+
+func TestRespondOAuth2Token(t *testing.T) {
+	expiry := tokenExpiry{TokenType: "Bearer", ExpiresIn: 3600}
+	expecting := map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 3600.0, "refresh_token": "refresh"}
+	t.Logf("Starting test for respondOAuth2Token with: %+v, and expecting: %v", expiry, expecting)
+	w := httptest.NewRecorder()
+	respondOAuth2Token(w, "access", "refresh", expiry)
+	got := map[string]any{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, got)
+	}
+	for key, value := range expecting {
+		if got[key] != value {
+			t.Errorf("Expecting: %s %v, but got: %v", key, value, got[key])
+		}
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expecting: Cache-Control no-store, but got: %q", w.Header().Get("Cache-Control"))
+	}
+}
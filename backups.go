@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// Backups are named after when they were taken, so sorting their names
+// sorts them by age.
+const (
+	backupPrefix     = "chirpy-"
+	backupSuffix     = ".backup"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// backupConfig says where and how often the database is snapshotted. An
+// empty dir turns scheduled backups off, and a retain of zero or less keeps
+// every backup.
+type backupConfig struct {
+	dir      string
+	interval time.Duration
+	retain   int
+}
+
+type backupFile struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// writeBackup snapshots db into a new file in dir. The file only appears
+// under its final name once complete, so a crash never leaves a partial
+// backup to restore from.
+func writeBackup(db database.Storage, dir string, now time.Time) (backupFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return backupFile{}, err
+	}
+	tmp, err := os.CreateTemp(dir, ".backup-*")
+	if err != nil {
+		return backupFile{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := db.Backup(tmp); err != nil {
+		tmp.Close()
+		return backupFile{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return backupFile{}, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return backupFile{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return backupFile{}, err
+	}
+	now = now.UTC()
+	name := backupPrefix + now.Format(backupTimeFormat) + backupSuffix
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return backupFile{}, err
+	}
+	return backupFile{Name: name, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// listBackups returns the names of the backups in dir, oldest first.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if isBackupName(entry.Name()) && entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) &&
+		filepath.Base(name) == name
+}
+
+// pruneBackups removes all but the newest retain backups in dir.
+func pruneBackups(dir string, retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+	names, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > retain {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// backupWorker snapshots the database every interval until ctx is done,
+// keeping the newest backups only.
+func (cfg *apiConfig) backupWorker(ctx context.Context) error {
+	ticker := time.NewTicker(cfg.backups.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			backup, err := writeBackup(cfg.db, cfg.backups.dir, now)
+			if err != nil {
+				return fmt.Errorf("backing up database: %w", err)
+			}
+			log.Printf("Backed up database to %s (%d bytes)", backup.Name, backup.Size)
+			if err := pruneBackups(cfg.backups.dir, cfg.backups.retain); err != nil {
+				return fmt.Errorf("pruning backups: %w", err)
+			}
+		}
+	}
+}
+
+// postBackupHandler snapshots the database into the backup directory and
+// answers with the new backup.
+func (cfg *apiConfig) postBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.backups.dir == "" {
+		respondValidationError(w, "no backup directory is configured")
+		return
+	}
+	backup, err := writeBackup(cfg.db, cfg.backups.dir, time.Now())
+	if errors.Is(err, errors.ErrUnsupported) {
+		respondNotImplemented(w, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, "Error backing up database", err)
+		return
+	}
+	if err := pruneBackups(cfg.backups.dir, cfg.backups.retain); err != nil {
+		logRequestf(requestId(r.Context()), "Error pruning backups: %s", err)
+	}
+
+	data, err := json.Marshal(backup)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// postRestoreHandler replaces the database with the backup named by the name
+// query parameter, or with the backup in the request body when there is no
+// name. When a backup directory is configured the current database is
+// backed up there first, so a mistaken restore can itself be undone.
+func (cfg *apiConfig) postRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	var source io.Reader = r.Body
+	if name := r.URL.Query().Get("name"); name != "" {
+		if cfg.backups.dir == "" {
+			respondValidationError(w, "no backup directory is configured")
+			return
+		}
+		if !isBackupName(name) {
+			respondValidationError(w, "not a backup name")
+			return
+		}
+		file, err := os.Open(filepath.Join(cfg.backups.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			w.WriteHeader(404)
+			return
+		}
+		if err != nil {
+			respondError(w, "Error opening backup", err)
+			return
+		}
+		defer file.Close()
+		source = file
+	}
+
+	type returnVal struct {
+		SafetyBackup *backupFile `json:"safety_backup,omitempty"`
+	}
+	respBody := returnVal{}
+	if cfg.backups.dir != "" {
+		backup, err := writeBackup(cfg.db, cfg.backups.dir, time.Now())
+		if errors.Is(err, errors.ErrUnsupported) {
+			respondNotImplemented(w, err.Error())
+			return
+		}
+		if err != nil {
+			respondError(w, "Error backing up database before restoring", err)
+			return
+		}
+		respBody.SafetyBackup = &backup
+	}
+
+	err := cfg.store(r.Context()).Restore(source)
+	if errors.Is(err, database.ErrInvalidBackup) {
+		respondValidationError(w, err.Error())
+		return
+	}
+	if errors.Is(err, errors.ErrUnsupported) {
+		respondNotImplemented(w, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(w, "Error restoring database", err)
+		return
+	}
+	logRequestf(requestId(r.Context()), "Restored database from backup")
+
+	data, err := json.Marshal(respBody)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
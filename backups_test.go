@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestBackups(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "backups")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var names []string
+	for i := 0; i < 4; i++ {
+		backup, err := writeBackup(db, dir, start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, backup.Name)
+	}
+
+	expecting := start.Add(3 * time.Hour)
+	t.Logf("Starting test for lastBackup with: %v, and expecting: %s", names, expecting)
+	takenAt, err := lastBackup(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if takenAt == nil || !takenAt.Equal(expecting) {
+		t.Errorf("Expecting: %s, but got: %v", expecting, takenAt)
+	}
+
+	runPruneBackupsTest(t, dir, 2, names[2:])
+	runPruneBackupsTest(t, dir, 0, names[2:])
+}
+
+func runPruneBackupsTest(t *testing.T, dir string, retain int, expecting []string) {
+	t.Logf("Starting test for pruneBackups with: retain %d, and expecting: %v", retain, expecting)
+	if err := pruneBackups(dir, retain); err != nil {
+		t.Fatal(err)
+	}
+	names, err := listBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(expecting) {
+		t.Fatalf("Expecting: %v, but got: %v", expecting, names)
+	}
+	for i := range names {
+		if names[i] != expecting[i] {
+			t.Errorf("Expecting: %v, but got: %v", expecting, names)
+		}
+	}
+}
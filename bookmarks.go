@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (cfg *apiConfig) postChirpBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpBookmark(w, r, cfg.store(r.Context()).BookmarkChirp)
+}
+
+func (cfg *apiConfig) deleteChirpBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpBookmark(w, r, cfg.store(r.Context()).UnbookmarkChirp)
+}
+
+// setChirpBookmark is setChirpLike for bookmarks. Nobody else sees them, so
+// suspended users may keep theirs in order.
+func (cfg *apiConfig) setChirpBookmark(w http.ResponseWriter, r *http.Request, update func(chirpId, userId int) error) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	chirpId, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	err = update(chirpId, userId)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// getUserBookmarksHandler lists the requesting user's bookmarks, most
+// recently bookmarked first, a page at a time.
+func (cfg *apiConfig) getUserBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	page, limit, ok := cfg.listingPage(w, r)
+	if !ok {
+		return
+	}
+	chirps, err := cfg.store(r.Context()).GetBookmarkedChirps(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	chirps, more := paginate(chirps, page, limit)
+	if more {
+		setNextPageLink(w, r, page, limit)
+	}
+	resp, err := cfg.renderChirps(r.Context(), chirps)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
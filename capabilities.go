@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// capabilities returns the optional store features handlers may use. While
+// reads are mirrored that is only what both stores offer, so a secondary
+// store on another backend serves requests the way the primary does, and
+// its responses can be compared.
+func (cfg *apiConfig) capabilities() database.Capabilities {
+	stores := []database.Storage{cfg.db}
+	if cfg.mirror != nil {
+		stores = append(stores, cfg.mirror.db)
+	}
+	return database.CapabilitiesOf(stores...)
+}
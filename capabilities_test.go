@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestCapabilities(t *testing.T) {
+	gob, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := database.NewSQLiteDB(filepath.Join(t.TempDir(), "database.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	for _, db := range []database.Storage{gob, sqlite} {
+		user, err := db.CreateUser("boots@example.com", "hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, body := range []string{"Fresh BREAD today #baking", "no bread here", "just toast #baking"} {
+			if _, err := db.CreateChirp(database.Chirp{Body: body, AuthorId: user.Id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	renderer, err := loadRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		cfg    *apiConfig
+		search bool
+	}{
+		{"gob", &apiConfig{db: gob, renderer: renderer}, false},
+		{"sqlite", &apiConfig{db: sqlite, renderer: renderer}, true},
+		{"sqlite mirrored to gob", &apiConfig{db: sqlite, renderer: renderer, mirror: newMirror(gob, 0, time.Second)}, false},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for capabilities with: %s, and expecting: search %t", c.name, c.search)
+		if caps := c.cfg.capabilities(); caps.Search != c.search || !caps.Transactions {
+			t.Errorf("Expecting: search %t with transactions, but got: %+v", c.search, caps)
+		}
+
+		for query, expecting := range map[string][]string{
+			"?q=bread&sort=asc":            {"Fresh BREAD today #baking", "no bread here"},
+			"?q=bread&tag=baking":          {"Fresh BREAD today #baking"},
+			"?q=toast&author_id=2":         nil,
+			"?q=%23BAKING&sort=asc&page=1": {"Fresh BREAD today #baking", "just toast #baking"},
+		} {
+			if !c.search {
+				t.Logf("Starting test for getChirpsHandler with: %s on %s, and expecting: 501", query, c.name)
+				w := httptest.NewRecorder()
+				c.cfg.getChirpsHandler(w, httptest.NewRequest("GET", "/api/chirps"+query, nil))
+				if w.Code != 501 {
+					t.Errorf("Expecting: 501, but got: %d", w.Code)
+				}
+				continue
+			}
+			t.Logf("Starting test for getChirpsHandler with: %s on %s, and expecting: %q", query, c.name, expecting)
+			w := httptest.NewRecorder()
+			c.cfg.getChirpsHandler(w, httptest.NewRequest("GET", "/api/chirps"+query, nil))
+			var chirps []chirpResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &chirps); err != nil {
+				t.Fatalf("%s: %s", err, w.Body.String())
+			}
+			var bodies []string
+			for _, chirp := range chirps {
+				bodies = append(bodies, chirp.Body)
+			}
+			if !slices.Equal(bodies, expecting) {
+				t.Errorf("Expecting: %q, but got: %q", expecting, bodies)
+			}
+		}
+	}
+}
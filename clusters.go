@@ -0,0 +1,192 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/avearmin/chirpy/internal/similarity"
+)
+
+// reportCluster is a group of near-duplicate chirps, at least one of them
+// reported, that moderators can act on as one.
+type reportCluster struct {
+	ChirpIds  []int `json:"chirp_ids"`
+	AuthorIds []int `json:"author_ids"`
+	ReportIds []int `json:"report_ids"`
+	// Sample is the body of the earliest chirp in the cluster.
+	Sample string `json:"sample"`
+}
+
+// getReportClustersHandler groups recent chirps into clusters of near
+// duplicates and lists those with open reports, most reported first, so
+// a spam wave shows up once rather than as a report per chirp. Reported
+// chirps older than the window are clustered too.
+func (cfg *apiConfig) getReportClustersHandler(w http.ResponseWriter, r *http.Request) {
+	recent, err := cfg.store(r.Context()).GetChirpsSince(time.Now().Add(-cfg.similarityWindow))
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	reports, err := cfg.store(r.Context()).GetOpenReports()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	chirps := make(map[int]database.Chirp, len(recent))
+	for _, chirp := range recent {
+		chirps[chirp.Id] = chirp
+	}
+	reportsByChirp := make(map[int][]int)
+	var older []int
+	for _, report := range reports {
+		if _, found := chirps[report.ChirpId]; !found && len(reportsByChirp[report.ChirpId]) == 0 {
+			older = append(older, report.ChirpId)
+		}
+		reportsByChirp[report.ChirpId] = append(reportsByChirp[report.ChirpId], report.Id)
+	}
+	if len(older) > 0 {
+		reported, err := cfg.store(r.Context()).GetChirpsByIds(older)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		for _, chirp := range reported {
+			chirps[chirp.Id] = chirp
+		}
+	}
+
+	docs := make([]similarity.Doc, 0, len(chirps))
+	for _, chirp := range chirps {
+		docs = append(docs, similarity.Doc{Id: chirp.Id, Text: chirp.Body})
+	}
+	clusters := make([]reportCluster, 0)
+	for _, ids := range similarity.Cluster(docs, similarity.Options{}) {
+		cluster := reportCluster{ChirpIds: ids, AuthorIds: []int{}, ReportIds: []int{}}
+		earliest := chirps[ids[0]]
+		for _, id := range ids {
+			chirp := chirps[id]
+			if !slices.Contains(cluster.AuthorIds, chirp.AuthorId) {
+				cluster.AuthorIds = append(cluster.AuthorIds, chirp.AuthorId)
+			}
+			cluster.ReportIds = append(cluster.ReportIds, reportsByChirp[id]...)
+			if chirp.CreatedAt.Before(earliest.CreatedAt) {
+				earliest = chirp
+			}
+		}
+		if len(cluster.ReportIds) == 0 {
+			continue
+		}
+		slices.Sort(cluster.AuthorIds)
+		slices.Sort(cluster.ReportIds)
+		cluster.Sample = earliest.Body
+		clusters = append(clusters, cluster)
+	}
+	slices.SortStableFunc(clusters, func(a, b reportCluster) int {
+		if len(a.ReportIds) != len(b.ReportIds) {
+			return cmp.Compare(len(b.ReportIds), len(a.ReportIds))
+		}
+		return cmp.Compare(len(b.ChirpIds), len(a.ChirpIds))
+	})
+
+	data, err := json.Marshal(clusters)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// postResolveClusterHandler resolves every open report on the chirps of a
+// cluster at once. Upholding them with a removal reason also removes the
+// chirps, recording a moderation action against each author.
+func (cfg *apiConfig) postResolveClusterHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChirpIds     []int  `json:"chirp_ids"`
+		Resolution   string `json:"resolution"`
+		RemoveReason string `json:"remove_reason"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if len(params.ChirpIds) == 0 {
+		respondValidationError(w, "chirp_ids must list the chirps of the cluster")
+		return
+	}
+	if params.Resolution != database.ResolutionUpheld && params.Resolution != database.ResolutionDismissed {
+		respondValidationError(w, "resolution must be upheld or dismissed")
+		return
+	}
+	remove := strings.TrimSpace(params.RemoveReason) != ""
+	if remove && params.Resolution != database.ResolutionUpheld {
+		respondValidationError(w, "only upheld reports can remove chirps")
+		return
+	}
+
+	reports, err := cfg.store(r.Context()).GetOpenReports()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	resolved := 0
+	for _, report := range reports {
+		if !slices.Contains(params.ChirpIds, report.ChirpId) {
+			continue
+		}
+		if _, err := cfg.store(r.Context()).ResolveReport(report.Id, params.Resolution); err != nil {
+			respondDataWriteError(w, err)
+			return
+		}
+		resolved++
+	}
+	removed := 0
+	if remove {
+		chirps, err := cfg.store(r.Context()).GetChirpsByIds(params.ChirpIds)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		for _, chirp := range chirps {
+			if err := cfg.store(r.Context()).DeleteChirp(chirp.Id, chirp.AuthorId); err != nil {
+				respondDataWriteError(w, err)
+				return
+			}
+			cfg.announceDelete(r.Context(), chirp)
+			_, err := cfg.store(r.Context()).CreateModerationAction(database.ModerationAction{
+				UserId:  chirp.AuthorId,
+				Kind:    database.ActionRemoveChirp,
+				ChirpId: &chirp.Id,
+				Reason:  params.RemoveReason,
+			})
+			if err != nil {
+				respondDataWriteError(w, err)
+				return
+			}
+			removed++
+		}
+	}
+
+	type returnVal struct {
+		Resolved int `json:"resolved"`
+		Removed  int `json:"removed"`
+	}
+	data, err := json.Marshal(returnVal{Resolved: resolved, Removed: removed})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
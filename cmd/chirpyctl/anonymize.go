@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// anonymize reads a backup into a scratch database, replaces its personal
+// data there, and writes the result as a new backup of the same kind. The
+// input backup and the server's database are never written to.
+func anonymize(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("chirpyctl anonymize", flag.ContinueOnError)
+	in := flags.String("in", "", "backup to anonymize, such as one the server wrote to BACKUP_DIR")
+	out := flags.String("out", "", "file to write the anonymized backup to")
+	driver := flags.String("db-driver", "gob", "kind of backup: gob or sqlite")
+	password := flags.String("password", "chirpy", "password every anonymized user logs in with")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *in == "" || *out == "" {
+		return errors.New("both --in and --out are required")
+	}
+	if filepath.Clean(*in) == filepath.Clean(*out) {
+		return errors.New("--out must not be --in: the backup is not anonymized in place")
+	}
+	if *driver != "gob" && *driver != "sqlite" {
+		return fmt.Errorf("unknown backup kind %q: must be gob or sqlite", *driver)
+	}
+
+	input, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	dir, err := os.MkdirTemp("", "chirpy-anonymize-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	db, err := database.Open(*driver, filepath.Join(dir, "database."+*driver), "")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Restore(input); err != nil {
+		return err
+	}
+	stats, err := db.Anonymize(*password)
+	if err != nil {
+		return err
+	}
+
+	output, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := db.Backup(output); err != nil {
+		output.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := output.Close(); err != nil {
+		os.Remove(*out)
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(stats); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s: users log in as user<id>@anonymized.invalid with password %q.\n", *out, *password)
+	return nil
+}
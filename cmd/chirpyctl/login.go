@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// deviceCode is the server's answer to starting a device login.
+type deviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// login signs in to a running server with the device flow, so no password
+// is typed into the terminal: the user approves the login in a browser, and
+// the tokens the server hands out are written to stdout.
+func login(args []string, getenv func(string) string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("chirpyctl login", flag.ContinueOnError)
+	server := flags.String("server", getenv("CHIRPY_URL"), "URL of the Chirpy server (env CHIRPY_URL, default http://localhost:8080)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	base := strings.TrimSuffix(*server, "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var code deviceCode
+	if _, err := postJSON(client, base+"/api/device/code", nil, &code); err != nil {
+		return fmt.Errorf("starting login: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "To log in, open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	if code.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "or open %s\n", code.VerificationURIComplete)
+	}
+
+	interval := time.Duration(code.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		var tokens json.RawMessage
+		status, err := postJSON(client, base+"/api/device/token", map[string]string{"device_code": code.DeviceCode}, &tokens)
+		if err == nil {
+			_, err = fmt.Fprintf(stdout, "%s\n", tokens)
+			return err
+		}
+		if status != http.StatusBadRequest {
+			return err
+		}
+		switch err.Error() {
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return errors.New("login was denied")
+		default:
+			return fmt.Errorf("login failed: %w", err)
+		}
+	}
+	return errors.New("login code expired")
+}
+
+// postJSON posts body and decodes a 200 answer into out. Other answers are
+// returned as errors carrying the response's error field, if it has one.
+func postJSON(client *http.Client, url string, body any, out any) (int, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return resp.StatusCode, errors.New(failure.Error)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,230 @@
+// Command chirpyctl administers a Chirpy database from the command line.
+//
+// Usage:
+//
+//	chirpyctl export [--format ndjson|json] [--out file] [database flags]
+//	chirpyctl import [--policy skip|overwrite|remap] [--in file] [database flags]
+//	chirpyctl login [--server url]
+//	chirpyctl rotate-secret jwt [--config file] [--kid id] [--key-file file] [--retire-after duration]
+//	chirpyctl rotate-secret totp [--key key] [--previous-keys keys] [database flags]
+//	chirpyctl anonymize --in backup --out file [--db-driver gob|sqlite] [--password password]
+//
+// Import reads an export into an existing database and prints how many
+// records of each type it imported and skipped. The gob backend is not safe
+// to import into while the server is running; use the admin endpoint
+// instead.
+//
+// Login signs in to a running server with the device flow and prints the
+// tokens it hands out; the user approves the login in a browser.
+//
+// Rotate-secret jwt puts a new signing key first in the jwt_keys of the
+// server's config file, for the servers to sign with once restarted, and
+// gives the keys before it a retire_after until which they still validate
+// the tokens they signed. Rotate-secret totp reseals the stored TOTP
+// secrets under TOTP_ENCRYPTION_KEY, after the server has been restarted
+// with the old key in TOTP_PREVIOUS_ENCRYPTION_KEYS; like import, it is not
+// safe against a running server on the gob backend. GET /admin/keys shows
+// how far either rotation has got.
+//
+// Anonymize copies a backup with its personal data replaced, so it can be
+// shared to reproduce bugs: emails become user<id>@anonymized.invalid,
+// every password becomes --password, drafts, scheduled chirps, report
+// reasons, and appeals keep only their shape, client addresses are replaced
+// consistently, secrets are regenerated, TOTP is turned off, and job
+// payloads and stored idempotent responses are emptied. It prints how many
+// records of each kind it rewrote.
+//
+// The database flags --db-driver, --db-path, and --database-url default to
+// DB_DRIVER, DB_PATH, and DATABASE_URL, as they do for the server.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+const usage = `usage: chirpyctl <command> [flags]
+
+commands:
+  export   write users, follows, chirps, and likes as JSON
+  import   read an export into the database
+  login    sign in to a server and print its tokens
+  rotate-secret jwt|totp
+           rotate the token signing key or the TOTP encryption key
+  anonymize
+           copy a backup with its personal data replaced
+`
+
+func main() {
+	err := run(os.Args[1:], os.Getenv, os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "chirpyctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, getenv func(string) string, stdout io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+	switch args[0] {
+	case "export":
+		return export(args[1:], getenv, stdout)
+	case "import":
+		return importExport(args[1:], getenv, os.Stdin, stdout)
+	case "login":
+		return login(args[1:], getenv, stdout)
+	case "rotate-secret":
+		return rotateSecret(args[1:], getenv, stdout)
+	case "anonymize":
+		return anonymize(args[1:], stdout)
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stderr, usage)
+		return flag.ErrHelp
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
+
+// databaseFlags adds the flags every command uses to find the database and
+// returns a function that opens it once flags are parsed.
+func databaseFlags(flags *flag.FlagSet, getenv func(string) string) func() (database.Storage, error) {
+	driver := flags.String("db-driver", getenv("DB_DRIVER"), "storage backend: gob, sqlite, or postgres (env DB_DRIVER)")
+	path := flags.String("db-path", getenv("DB_PATH"), "database file for the gob and sqlite drivers (env DB_PATH)")
+	url := flags.String("database-url", getenv("DATABASE_URL"), "connection URL for the postgres driver (env DATABASE_URL)")
+	return func() (database.Storage, error) {
+		if *path == "" {
+			switch *driver {
+			case "", "gob":
+				*path = "./database.gob"
+			case "sqlite":
+				*path = "./database.sqlite"
+			}
+		}
+		return database.Open(*driver, *path, *url)
+	}
+}
+
+// export writes one record per line for ndjson, or a single array for json.
+// Records are written as they are read, so even large databases export in
+// bounded memory.
+func export(args []string, getenv func(string) string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("chirpyctl export", flag.ContinueOnError)
+	format := flags.String("format", "ndjson", "output format: ndjson or json")
+	out := flags.String("out", "", "file to write to instead of standard output")
+	open := databaseFlags(flags, getenv)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "ndjson" && *format != "json" {
+		return fmt.Errorf("unknown format %q: must be ndjson or json", *format)
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w := stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	if err := writeExport(db, buffered, *format == "json"); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+func writeExport(db database.Storage, w io.Writer, array bool) error {
+	separator, end := "\n", ""
+	if array {
+		separator, end = ",\n", "]\n"
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+	}
+	first := true
+	err := db.Export(func(record database.Record) error {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if array && !first {
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+		}
+		first = false
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if !array {
+			_, err = io.WriteString(w, separator)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, end)
+	return err
+}
+
+// importExport is the import command; import is a keyword.
+func importExport(args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("chirpyctl import", flag.ContinueOnError)
+	policyName := flags.String("policy", string(database.ImportSkip), "what to do with records that are already stored: skip, overwrite, or remap")
+	in := flags.String("in", "", "file to read instead of standard input")
+	open := databaseFlags(flags, getenv)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	policy, err := database.ParseImportPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r := stdin
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	var importer *database.Importer
+	err = db.WriteBatch(func(db database.Storage) error {
+		importer = database.NewImporter(db, policy)
+		return database.ReadRecords(r, importer.Import)
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(importer.Stats)
+}
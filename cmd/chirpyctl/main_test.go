@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.gob")
+	db, err := database.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("boots@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(database.Chirp{Body: "hello", AuthorId: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(key string) string { return map[string]string{"DB_PATH": path}[key] }
+
+	runExportTest(t, []string{"--format", "ndjson"}, getenv, "{\"type\":\"user\",", 2)
+	runExportTest(t, []string{"--format", "json"}, getenv, "[{\"type\":\"user\",", 2)
+	runExportTest(t, []string{"--format", "csv"}, getenv, "", 0)
+}
+
+func runExportTest(t *testing.T, args []string, getenv func(string) string, prefix string, lines int) {
+	t.Logf("Starting test for export with: %v, and expecting: %d lines starting with %s", args, lines, prefix)
+	var out bytes.Buffer
+	err := run(append([]string{"export"}, args...), getenv, &out)
+	if lines == 0 {
+		if err == nil {
+			t.Errorf("Expecting: an error, but got: %s", out.String())
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), prefix) || strings.Count(out.String(), "\n") != lines {
+		t.Errorf("Expecting: %d lines starting with %s, but got: %s", lines, prefix, out.String())
+	}
+	if strings.Contains(out.String(), "password") {
+		t.Errorf("Expecting: no password hashes, but got: %s", out.String())
+	}
+	if strings.HasPrefix(prefix, "[") && !json.Valid(out.Bytes()) {
+		t.Errorf("Expecting: a JSON array, but got: %s", out.String())
+	}
+}
+
+func TestImport(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source.gob")
+	db, err := database.NewDB(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := db.CreateUser("boots@example.com", "hunter2")
+	chirp, _ := db.CreateChirp(database.Chirp{Body: "hello", AuthorId: user.Id})
+	db.CreateChirp(database.Chirp{Body: "hi back", AuthorId: user.Id, ParentId: &chirp.Id})
+	for _, format := range []string{"ndjson", "json"} {
+		export := filepath.Join(t.TempDir(), "export."+format)
+		sourceEnv := func(key string) string { return map[string]string{"DB_PATH": source}[key] }
+		if err := run([]string{"export", "--format", format, "--out", export}, sourceEnv, &bytes.Buffer{}); err != nil {
+			t.Fatal(err)
+		}
+		target := filepath.Join(t.TempDir(), "target.gob")
+		targetEnv := func(key string) string { return map[string]string{"DB_PATH": target}[key] }
+
+		runImportTest(t, []string{"--in", export, "--policy", "remap"}, targetEnv, database.ImportStats{
+			Imported: map[string]int{"user": 1, "chirp": 2},
+			Skipped:  map[string]int{},
+		})
+		runImportTest(t, []string{"--in", export}, targetEnv, database.ImportStats{
+			Imported: map[string]int{},
+			Skipped:  map[string]int{"user": 1, "chirp": 2},
+		})
+	}
+}
+
+func runImportTest(t *testing.T, args []string, getenv func(string) string, expecting database.ImportStats) {
+	t.Logf("Starting test for import with: %v, and expecting: %v", args, expecting)
+	var out bytes.Buffer
+	if err := run(append([]string{"import"}, args...), getenv, &out); err != nil {
+		t.Fatal(err)
+	}
+	var stats database.ImportStats
+	if err := json.Unmarshal(out.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, stats)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	runLoginTest(t, []string{"authorization_pending", "authorization_pending"}, "", `{"token":"access"}`)
+	runLoginTest(t, []string{"authorization_pending", "access_denied"}, "login was denied", "")
+	runLoginTest(t, []string{"expired_token"}, "expired_token", "")
+}
+
+// runLoginTest answers polls with the RFC 8628 errors in polls, and then
+// with tokens.
+func runLoginTest(t *testing.T, polls []string, expectingErr, expecting string) {
+	t.Logf("Starting test for login with: polls %v, and expecting: %q or error %q", polls, expecting, expectingErr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/device/code", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"device_code":"dc","user_code":"WDJB-MJHT","verification_uri":"http://chirpy/activate","expires_in":60,"interval":0}`))
+	})
+	mux.HandleFunc("/api/device/token", func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			DeviceCode string `json:"device_code"`
+		}
+		json.NewDecoder(r.Body).Decode(&params)
+		if params.DeviceCode != "dc" {
+			t.Errorf("Expecting: device code dc, but got: %s", params.DeviceCode)
+		}
+		if len(polls) > 0 {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": polls[0]})
+			polls = polls[1:]
+			return
+		}
+		w.Write([]byte(`{"token":"access"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := run([]string{"login", "--server", server.URL}, func(string) string { return "" }, &out)
+	if expectingErr != "" {
+		if err == nil || !strings.Contains(err.Error(), expectingErr) {
+			t.Errorf("Expecting: error %q, but got: %v", expectingErr, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out.String()) != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, out.String())
+	}
+}
+
+func TestRotateSecret(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chirpy.yaml")
+	if err := os.WriteFile(path, []byte("# the test server\nport: \"8080\"\njwt_secret: old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(key string) string { return map[string]string{"CHIRPY_CONFIG": path}[key] }
+
+	t.Logf("Starting test for rotate-secret jwt with: a single jwt_secret, and expecting: a new key first and the old one retiring")
+	if err := run([]string{"rotate-secret", "jwt", "--kid", "new"}, getenv, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	keys := readJWTKeys(t, path)
+	if len(keys) != 2 || keys[0].Id != "new" || keys[0].Secret == "" || !keys[0].RetireAfter.IsZero() ||
+		keys[1].Id != "" || keys[1].Secret != "old" || !keys[1].RetireAfter.After(time.Now()) {
+		t.Errorf("Expecting: a new signing key and the old secret retiring, but got: %+v", keys)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "# the test server") || strings.Contains(string(data), "jwt_secret") {
+		t.Errorf("Expecting: the comment kept and jwt_secret gone, but got: %s", data)
+	}
+
+	t.Logf("Starting test for rotate-secret jwt with: a taken kid, and expecting: an error")
+	if err := run([]string{"rotate-secret", "jwt", "--kid", "new"}, getenv, &bytes.Buffer{}); err == nil {
+		t.Errorf("Expecting: an error, but got: nil")
+	}
+
+	t.Logf("Starting test for rotate-secret jwt with: a key file, and expecting: it signs and the retired key is dropped")
+	doc := "jwt_keys:\n  - kid: new\n    secret: s\n  - secret: old\n    retire_after: 2020-01-01T00:00:00Z\n"
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "jwt.pem")
+	if err := run([]string{"rotate-secret", "jwt", "--kid", "newer", "--key-file", keyFile}, getenv, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	keys = readJWTKeys(t, path)
+	if len(keys) != 2 || keys[0].Id != "newer" || keys[0].KeyFile != keyFile || keys[1].Id != "new" || keys[1].RetireAfter.IsZero() {
+		t.Errorf("Expecting: the key file first and key new retiring, but got: %+v", keys)
+	}
+
+	t.Logf("Starting test for rotate-secret totp with: a secret under the previous key, and expecting: it is resealed")
+	dbPath := filepath.Join(dir, "database.gob")
+	db, err := database.NewDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldBox, err := auth.NewSecretBox("old key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("boots@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := oldBox.Seal([]byte("a TOTP secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTOTPSecret(user.Id, sealed); err != nil {
+		t.Fatal(err)
+	}
+	totpEnv := func(key string) string {
+		return map[string]string{"DB_PATH": dbPath, "TOTP_ENCRYPTION_KEY": "new key", "TOTP_PREVIOUS_ENCRYPTION_KEYS": "old key"}[key]
+	}
+	for _, expecting := range []string{`"resealed": 1`, `"current": 1`} {
+		var out bytes.Buffer
+		if err := run([]string{"rotate-secret", "totp"}, totpEnv, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), expecting) {
+			t.Errorf("Expecting: %s, but got: %s", expecting, out.String())
+		}
+	}
+	db, err = database.NewDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBox, err := auth.NewSecretBox("new key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := newBox.Open(user.TOTPSecret); err != nil || string(secret) != "a TOTP secret" {
+		t.Errorf("Expecting: the secret under the new key, but got: %q, %v", secret, err)
+	}
+}
+
+func readJWTKeys(t *testing.T, path string) []configJWTKey {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		JWTKeys []configJWTKey `yaml:"jwt_keys"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.JWTKeys
+}
+
+func TestAnonymize(t *testing.T) {
+	for _, driver := range []string{"gob", "sqlite"} {
+		dir := t.TempDir()
+		db, err := database.Open(driver, filepath.Join(dir, "database."+driver), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := db.CreateUser("boots@example.com", "hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.CreateChirp(database.Chirp{Body: "a public chirp", AuthorId: user.Id}); err != nil {
+			t.Fatal(err)
+		}
+		backup := filepath.Join(dir, "backup")
+		file, err := os.Create(backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Backup(file); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		db.Close()
+
+		t.Logf("Starting test for anonymize with: a %s backup, and expecting: a copy with the email and password replaced", driver)
+		out := filepath.Join(dir, "anonymized")
+		var stats bytes.Buffer
+		if err := run([]string{"anonymize", "--in", backup, "--out", out, "--db-driver", driver, "--password", "secret"}, os.Getenv, &stats); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(stats.String(), `"users": 1`) {
+			t.Errorf("Expecting: 1 user, but got: %s", stats.String())
+		}
+		anonymized, err := database.Open(driver, filepath.Join(dir, "restored."+driver), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err = os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = anonymized.Restore(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := anonymized.ComparePasswords("secret", database.AnonymousEmail(user.Id)); err != nil {
+			t.Errorf("Expecting: the user to log in with the synthetic email and password, but got: %v", err)
+		}
+		if chirps, err := anonymized.GetChirps("asc"); err != nil || len(chirps) != 1 || chirps[0].Body != "a public chirp" {
+			t.Errorf("Expecting: the chirp kept, but got: %+v, %v", chirps, err)
+		}
+		anonymized.Close()
+
+		t.Logf("Starting test for anonymize with: --out the same as --in, and expecting: an error")
+		if err := run([]string{"anonymize", "--in", backup, "--out", backup}, os.Getenv, &bytes.Buffer{}); err == nil {
+			t.Errorf("Expecting: an error, but got: nil")
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// rotateSecret is the rotate-secret command. jwt rotates the token signing
+// key in the config file, totp moves the stored TOTP secrets to the current
+// encryption key.
+func rotateSecret(args []string, getenv func(string) string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("rotate-secret needs the secret to rotate: jwt or totp")
+	}
+	switch args[0] {
+	case "jwt":
+		return rotateJWTKey(args[1:], getenv, stdout)
+	case "totp":
+		return rotateTOTPKey(args[1:], getenv, stdout)
+	}
+	return fmt.Errorf("unknown secret %q: must be jwt or totp", args[0])
+}
+
+// configJWTKey is an entry of the jwt_keys block of the server's config
+// file.
+type configJWTKey struct {
+	Id          string    `yaml:"kid,omitempty"`
+	Secret      string    `yaml:"secret,omitempty"`
+	KeyFile     string    `yaml:"key_file,omitempty"`
+	RetireAfter time.Time `yaml:"retire_after,omitempty"`
+}
+
+// rotateJWTKey puts a new signing key at the head of jwt_keys in the config
+// file. The keys that signed before it are kept to validate their tokens
+// until retire_after, and those already past it are removed. A config with
+// a single jwt_secret or jwt_key_file is turned into jwt_keys, the old key
+// keeping no kid so the tokens it signed without one still validate.
+func rotateJWTKey(args []string, getenv func(string) string, stdout io.Writer) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	flags := flag.NewFlagSet("chirpyctl rotate-secret jwt", flag.ContinueOnError)
+	path := flags.String("config", getenv("CHIRPY_CONFIG"), "the server's YAML config file (env CHIRPY_CONFIG, default chirpy.yaml)")
+	kid := flags.String("kid", now.Format("2006-01-02"), "id of the new key, sent in the kid header of the tokens it signs")
+	keyFile := flags.String("key-file", "", "write a new ECDSA P-256 private key here and sign with it, instead of with a new HMAC secret")
+	retireAfter := flags.Duration("retire-after", 24*time.Hour, "how long the old keys keep validating tokens: enough for every server to restart with the new key, plus the hour access tokens live")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		*path = "chirpy.yaml"
+	}
+	if *kid == "" {
+		return errors.New("the new key needs a --kid")
+	}
+	if *retireAfter < auth.AccessTokenTTL {
+		return fmt.Errorf("--retire-after must be at least %s, the lifetime of the tokens the old keys signed", auth.AccessTokenTTL)
+	}
+
+	doc, err := readConfigNode(*path)
+	if err != nil {
+		return err
+	}
+	root := doc.Content[0]
+	var keys []configJWTKey
+	var notes []string
+	if node := mappingValue(root, "jwt_keys"); node != nil {
+		if err := node.Decode(&keys); err != nil {
+			return fmt.Errorf("reading jwt_keys: %w", err)
+		}
+	}
+	if len(keys) == 0 {
+		legacy := configJWTKey{}
+		if node := mappingValue(root, "jwt_secret"); node != nil {
+			legacy.Secret = node.Value
+		}
+		if node := mappingValue(root, "jwt_key_file"); node != nil {
+			legacy.KeyFile = node.Value
+		}
+		if secret := getenv("JWT_SECRET"); secret != "" {
+			legacy.Secret = secret
+		}
+		if file := getenv("JWT_KEY_FILE"); file != "" {
+			legacy.KeyFile = file
+		}
+		if legacy.Secret != "" && legacy.KeyFile != "" {
+			legacy.Secret = ""
+		}
+		if legacy.Secret == "" && legacy.KeyFile == "" {
+			return fmt.Errorf("%s has no jwt_keys, jwt_secret, or jwt_key_file to rotate", *path)
+		}
+		keys = []configJWTKey{legacy}
+		deleteMappingKey(root, "jwt_secret")
+		deleteMappingKey(root, "jwt_key_file")
+	}
+	if getenv("JWT_SECRET") != "" || getenv("JWT_KEY_FILE") != "" {
+		notes = append(notes, "Unset JWT_SECRET and JWT_KEY_FILE before restarting: the server refuses them alongside jwt_keys.")
+	}
+
+	kept := make([]configJWTKey, 0, len(keys)+1)
+	for _, key := range keys {
+		if key.Id == *kid {
+			return fmt.Errorf("key id %q is taken: choose another with --kid", *kid)
+		}
+		if !key.RetireAfter.IsZero() && now.After(key.RetireAfter) {
+			notes = append(notes, fmt.Sprintf("Removed %s, retired since %s.", describeKid(key.Id), key.RetireAfter.Format(time.RFC3339)))
+			continue
+		}
+		if key.RetireAfter.IsZero() {
+			key.RetireAfter = now.Add(*retireAfter)
+		}
+		kept = append(kept, key)
+	}
+
+	key := configJWTKey{Id: *kid}
+	if *keyFile != "" {
+		if err := writeSigningKey(*keyFile); err != nil {
+			return err
+		}
+		key.KeyFile = *keyFile
+	} else {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		key.Secret = base64.RawURLEncoding.EncodeToString(secret)
+	}
+	keys = append([]configJWTKey{key}, kept...)
+	if err := checkJWTKeys(keys); err != nil {
+		return err
+	}
+
+	var node yaml.Node
+	if err := node.Encode(keys); err != nil {
+		return err
+	}
+	setMappingValue(root, "jwt_keys", &node)
+	if err := writeConfigNode(*path, doc); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "Wrote %s:\n", *path)
+	fmt.Fprintf(stdout, "  %s signs new tokens once the server restarts\n", describeKid(key.Id))
+	for _, key := range kept {
+		fmt.Fprintf(stdout, "  %s validates the tokens it signed until %s\n", describeKid(key.Id), key.RetireAfter.Format(time.RFC3339))
+	}
+	for _, note := range notes {
+		fmt.Fprintln(stdout, note)
+	}
+	fmt.Fprintln(stdout, "Restart the servers to switch to the new key; GET /admin/keys shows the keys each one uses.")
+	return nil
+}
+
+// describeKid names a key for the operator.
+func describeKid(id string) string {
+	if id == "" {
+		return "the key without a kid"
+	}
+	return "key " + id
+}
+
+// writeSigningKey writes a new ECDSA P-256 private key to path, refusing
+// to overwrite one.
+func writeSigningKey(path string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// checkJWTKeys makes sure the server will accept keys before they are
+// written, reading key files as the server does.
+func checkJWTKeys(keys []configJWTKey) error {
+	authKeys := make([]auth.Key, len(keys))
+	for i, key := range keys {
+		authKeys[i] = auth.Key{Id: key.Id, Secret: []byte(key.Secret), RetireAfter: key.RetireAfter}
+		if key.KeyFile != "" {
+			pemData, err := os.ReadFile(key.KeyFile)
+			if err != nil {
+				return err
+			}
+			authKeys[i].PEM = pemData
+		}
+	}
+	_, err := auth.NewRotatingIssuer(authKeys)
+	return err
+}
+
+// readConfigNode reads the YAML document at path, keeping its comments and
+// layout for writeConfigNode. A missing file reads as an empty mapping.
+func readConfigNode(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s is not a mapping of settings", path)
+	}
+	return doc, nil
+}
+
+// writeConfigNode replaces the file at path with doc, keeping its mode.
+// The file is written next to it and renamed over it, so the server never
+// reads half a config.
+func writeConfigNode(path string, doc *yaml.Node) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	encoder := yaml.NewEncoder(tmp)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mappingValue returns the value of key in mapping, nil if it has none.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets key to value in mapping, in place if it is there.
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// deleteMappingKey removes key and its value from mapping.
+func deleteMappingKey(mapping *yaml.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// rotateTOTPKey reseals the stored TOTP secrets under the current
+// encryption key. The server should already run with the new key in
+// TOTP_ENCRYPTION_KEY and the old ones in TOTP_PREVIOUS_ENCRYPTION_KEYS, so
+// it seals new enrollments under the new key and still reads the rest;
+// once this reports no secret left behind, the previous keys can go.
+func rotateTOTPKey(args []string, getenv func(string) string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("chirpyctl rotate-secret totp", flag.ContinueOnError)
+	key := flags.String("key", getenv("TOTP_ENCRYPTION_KEY"), "the encryption key to reseal under (env TOTP_ENCRYPTION_KEY)")
+	previous := flags.String("previous-keys", getenv("TOTP_PREVIOUS_ENCRYPTION_KEYS"), "comma-separated keys the secrets may be sealed under now (env TOTP_PREVIOUS_ENCRYPTION_KEYS)")
+	open := databaseFlags(flags, getenv)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *key == "" {
+		return errors.New("no encryption key: set --key or TOTP_ENCRYPTION_KEY")
+	}
+	var previousKeys []string
+	for _, k := range strings.Split(*previous, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			previousKeys = append(previousKeys, k)
+		}
+	}
+	if len(previousKeys) == 0 {
+		return errors.New("no previous keys to move secrets from: set --previous-keys or TOTP_PREVIOUS_ENCRYPTION_KEYS")
+	}
+	box, err := auth.NewSecretBox(*key, previousKeys...)
+	if err != nil {
+		return err
+	}
+
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var stats struct {
+		Resealed   int `json:"resealed"`
+		Current    int `json:"current"`
+		Unreadable int `json:"unreadable"`
+	}
+	err = db.WriteBatch(func(db database.Storage) error {
+		users, err := db.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if user.TOTPSecret == nil {
+				continue
+			}
+			sealed, resealed, err := box.Reseal(user.TOTPSecret)
+			switch {
+			case errors.Is(err, auth.ErrSealedDataInvalid):
+				stats.Unreadable++
+				continue
+			case err != nil:
+				return err
+			case !resealed:
+				stats.Current++
+				continue
+			}
+			if err := db.ReplaceTOTPSecret(user.Id, sealed); err != nil {
+				return err
+			}
+			stats.Resealed++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(stats); err != nil {
+		return err
+	}
+	if stats.Unreadable > 0 {
+		fmt.Fprintf(os.Stderr, "%d secrets open under none of the keys: add the key they were sealed under to --previous-keys and run again.\n", stats.Unreadable)
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "Every secret is sealed under the current key: TOTP_PREVIOUS_ENCRYPTION_KEYS can be emptied.")
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// concurrencyExempt lists paths the concurrency caps leave alone: health
+// checks, so the load balancer still sees an overloaded server as alive,
+// and the event streams, which stay open for as long as clients watch.
+var concurrencyExempt = map[string]bool{
+	"/api/healthz":       true,
+	"/api/readyz":        true,
+	"/api/stream":        true,
+	"/api/chirps/stream": true,
+}
+
+// concurrencyLimits caps the requests being served at once, in all and per
+// client address. Requests over a cap wait in a queue of up to queue
+// requests for at most queueTimeout. A cap of zero or less disables it.
+type concurrencyLimits struct {
+	global       int
+	perIP        int
+	queue        int
+	queueTimeout time.Duration
+}
+
+// ipSlots is the semaphore of one client address, dropped once no request
+// of the address holds or waits for it.
+type ipSlots struct {
+	slots chan struct{}
+	users int
+}
+
+// concurrencyLimiter enforces concurrencyLimits. Every request opens the
+// database, so without it a single client could use up the server's file
+// handles.
+type concurrencyLimiter struct {
+	limits  concurrencyLimits
+	global  chan struct{}
+	mux     sync.Mutex
+	waiting int
+	ips     map[string]*ipSlots
+}
+
+func newConcurrencyLimiter(limits concurrencyLimits) *concurrencyLimiter {
+	l := &concurrencyLimiter{limits: limits, ips: make(map[string]*ipSlots)}
+	if limits.global > 0 {
+		l.global = make(chan struct{}, limits.global)
+	}
+	return l
+}
+
+// acquire takes a slot for a request from ip, waiting in the queue if
+// there is room. It returns the function giving the slot back, or false
+// when the request must be refused.
+func (l *concurrencyLimiter) acquire(ctx context.Context, ip string) (func(), bool) {
+	var ipSem *ipSlots
+	if l.limits.perIP > 0 {
+		l.mux.Lock()
+		ipSem = l.ips[ip]
+		if ipSem == nil {
+			ipSem = &ipSlots{slots: make(chan struct{}, l.limits.perIP)}
+			l.ips[ip] = ipSem
+		}
+		ipSem.users++
+		l.mux.Unlock()
+	}
+	releaseIP := func() {
+		if ipSem == nil {
+			return
+		}
+		l.mux.Lock()
+		defer l.mux.Unlock()
+		ipSem.users--
+		if ipSem.users == 0 {
+			delete(l.ips, ip)
+		}
+	}
+
+	deadline := time.Now().Add(l.limits.queueTimeout)
+	if ipSem != nil && !l.wait(ctx, ipSem.slots, deadline) {
+		releaseIP()
+		return nil, false
+	}
+	if l.global != nil && !l.wait(ctx, l.global, deadline) {
+		if ipSem != nil {
+			<-ipSem.slots
+		}
+		releaseIP()
+		return nil, false
+	}
+	return func() {
+		if l.global != nil {
+			<-l.global
+		}
+		if ipSem != nil {
+			<-ipSem.slots
+		}
+		releaseIP()
+	}, true
+}
+
+// wait takes a slot of sem, queueing until deadline when it is full and
+// the queue has room.
+func (l *concurrencyLimiter) wait(ctx context.Context, sem chan struct{}, deadline time.Time) bool {
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+	}
+
+	l.mux.Lock()
+	if l.waiting >= l.limits.queue {
+		l.mux.Unlock()
+		return false
+	}
+	l.waiting++
+	l.mux.Unlock()
+	defer func() {
+		l.mux.Lock()
+		l.waiting--
+		l.mux.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// middlewareConcurrency serves requests within the concurrency caps and
+// refuses the rest with 503 and a Retry-After header.
+func (cfg *apiConfig) middlewareConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if concurrencyExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, ok := cfg.inFlight.acquire(r.Context(), clientIP(r))
+		if !ok {
+			respondOverloaded(w)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondOverloaded(w http.ResponseWriter) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: "server is busy, try again shortly"})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(1))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(data)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := newConcurrencyLimiter(concurrencyLimits{global: 2, perIP: 1, queue: 1, queueTimeout: 50 * time.Millisecond})
+
+	t.Logf("Starting test for acquire with: a second request from one address, and expecting: it waits, then is refused")
+	releaseFirst, ok := l.acquire(ctx, "192.0.2.1")
+	if !ok {
+		t.Fatal("Expecting: a slot, but got: none")
+	}
+	if _, ok := l.acquire(ctx, "192.0.2.1"); ok {
+		t.Errorf("Expecting: no slot over the per-address cap, but got: one")
+	}
+
+	t.Logf("Starting test for acquire with: the global cap reached, and expecting: a queued request gets the next free slot")
+	releaseSecond, ok := l.acquire(ctx, "192.0.2.2")
+	if !ok {
+		t.Fatal("Expecting: a slot, but got: none")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		releaseFirst()
+	}()
+	releaseThird, ok := l.acquire(ctx, "192.0.2.3")
+	if !ok {
+		t.Fatal("Expecting: a slot once one was released, but got: none")
+	}
+
+	t.Logf("Starting test for acquire with: a full queue, and expecting: an immediate refusal")
+	l.limits.queue = 0
+	start := time.Now()
+	if _, ok := l.acquire(ctx, "192.0.2.4"); ok || time.Since(start) > 10*time.Millisecond {
+		t.Errorf("Expecting: an immediate refusal, but got: %v after %v", ok, time.Since(start))
+	}
+
+	releaseSecond()
+	releaseThird()
+	if len(l.ips) != 0 || len(l.global) != 0 {
+		t.Errorf("Expecting: every slot returned, but got: %d addresses and %d slots", len(l.ips), len(l.global))
+	}
+}
+
+func TestMiddlewareConcurrency(t *testing.T) {
+	cfg := &apiConfig{inFlight: newConcurrencyLimiter(concurrencyLimits{global: 1, queue: 0})}
+	release, _ := cfg.inFlight.acquire(context.Background(), "192.0.2.1")
+	defer release()
+	handler := cfg.middlewareConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	runMiddlewareConcurrencyTest(t, handler, "/api/chirps", http.StatusServiceUnavailable)
+	runMiddlewareConcurrencyTest(t, handler, "/api/healthz", http.StatusOK)
+}
+
+func runMiddlewareConcurrencyTest(t *testing.T, handler http.Handler, path string, expecting int) {
+	t.Logf("Starting test for middlewareConcurrency with: %s while full, and expecting: %d", path, expecting)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+	if resp.Code != expecting {
+		t.Errorf("Expecting: %d, but got: %d", expecting, resp.Code)
+	}
+	if expecting == http.StatusServiceUnavailable && resp.Header().Get("Retry-After") == "" {
+		t.Errorf("Expecting: a Retry-After header, but got: %v", resp.Header())
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is read when it exists and no other file is named.
+const defaultConfigPath = "chirpy.yaml"
+
+// config holds the settings the server cannot start without. Each is read
+// from the YAML config file, then overridden by its environment variable,
+// then by its command-line flag. Tuning knobs with safe defaults stay
+// environment-only.
+type config struct {
+	Port        string   `yaml:"port"`
+	StaticDir   string   `yaml:"static_dir"`
+	DBDriver    string   `yaml:"db_driver"`
+	DBPath      string   `yaml:"db_path"`
+	DatabaseURL string   `yaml:"database_url"`
+	MediaDir    string   `yaml:"media_dir"`
+	JWTSecret   string   `yaml:"jwt_secret"`
+	JWTKeyFile  string   `yaml:"jwt_key_file"`
+	JWTKeys     []jwtKey `yaml:"jwt_keys"`
+	PolkaAPIKey string   `yaml:"polka_api_key"`
+	PolkaSecret string   `yaml:"polka_webhook_secret"`
+	TLSCert     string   `yaml:"tls_cert"`
+	TLSKey      string   `yaml:"tls_key"`
+	Domain      string   `yaml:"domain"`
+	ChirpIds    string   `yaml:"chirp_ids"`
+	NodeId      string   `yaml:"node_id"`
+}
+
+// jwtKey is one entry of the jwt_keys block. The first entry signs new
+// tokens; later ones only validate tokens they signed before, until their
+// retire_after time or until the operator removes them. chirpyctl
+// rotate-secret maintains the block.
+type jwtKey struct {
+	Id          string    `yaml:"kid"`
+	Secret      string    `yaml:"secret"`
+	KeyFile     string    `yaml:"key_file"`
+	RetireAfter time.Time `yaml:"retire_after,omitempty"`
+}
+
+// loadConfig builds the config from the file named by --config or
+// CHIRPY_CONFIG, the environment, and args, and checks it is usable.
+func loadConfig(args []string, getenv func(string) string) (config, error) {
+	cfg := config{
+		Port:      "8080",
+		StaticDir: "./app",
+		MediaDir:  "./media",
+	}
+
+	flags := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	path := flags.String("config", "", "path to a YAML config file (env CHIRPY_CONFIG, default "+defaultConfigPath+" if present)")
+	port := flags.String("port", "", "port to listen on (env PORT)")
+	staticDir := flags.String("static-dir", "", "directory served under /app (env STATIC_DIR)")
+	dbDriver := flags.String("db-driver", "", "storage backend: gob, sqlite, or postgres (env DB_DRIVER)")
+	dbPath := flags.String("db-path", "", "database file for the gob and sqlite drivers (env DB_PATH)")
+	mediaDir := flags.String("media-dir", "", "directory uploaded media is stored in (env MEDIA_DIR)")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file to serve HTTPS with (env TLS_CERT)")
+	tlsKey := flags.String("tls-key", "", "private key file for --tls-cert (env TLS_KEY)")
+	domain := flags.String("domain", "", "domain to get Let's Encrypt certificates for, comma-separated (env DOMAIN)")
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	if *path == "" {
+		*path = getenv("CHIRPY_CONFIG")
+	}
+	if err := cfg.readFile(*path); err != nil {
+		return config{}, err
+	}
+
+	override(&cfg.Port, getenv("PORT"), *port)
+	override(&cfg.StaticDir, getenv("STATIC_DIR"), *staticDir)
+	override(&cfg.DBDriver, getenv("DB_DRIVER"), *dbDriver)
+	override(&cfg.DBPath, getenv("DB_PATH"), *dbPath)
+	override(&cfg.DatabaseURL, getenv("DATABASE_URL"))
+	override(&cfg.MediaDir, getenv("MEDIA_DIR"), *mediaDir)
+	override(&cfg.JWTSecret, getenv("JWT_SECRET"))
+	override(&cfg.JWTKeyFile, getenv("JWT_KEY_FILE"))
+	override(&cfg.PolkaAPIKey, getenv("POLKA_API_KEY"))
+	override(&cfg.PolkaSecret, getenv("POLKA_WEBHOOK_SECRET"))
+	override(&cfg.TLSCert, getenv("TLS_CERT"), *tlsCert)
+	override(&cfg.TLSKey, getenv("TLS_KEY"), *tlsKey)
+	override(&cfg.Domain, getenv("DOMAIN"), *domain)
+	override(&cfg.ChirpIds, getenv("CHIRP_IDS"))
+	override(&cfg.NodeId, getenv("NODE_ID"))
+
+	if cfg.DBPath == "" {
+		switch cfg.DBDriver {
+		case "", "gob":
+			cfg.DBPath = "./database.gob"
+		case "sqlite":
+			cfg.DBPath = "./database.sqlite"
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// readFile merges the YAML file at path into cfg. An empty path reads
+// defaultConfigPath if it exists.
+func (cfg *config) readFile(path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// override sets *field to the last non-empty value.
+func override(field *string, values ...string) {
+	for _, value := range values {
+		if value != "" {
+			*field = value
+		}
+	}
+}
+
+func (cfg config) validate() error {
+	var errs []error
+	switch {
+	case len(cfg.JWTKeys) > 0 && (cfg.JWTSecret != "" || cfg.JWTKeyFile != ""):
+		errs = append(errs, errors.New("set either jwt_keys or a single jwt_secret or jwt_key_file, not both"))
+	case len(cfg.JWTKeys) > 0:
+		seen := make(map[string]bool)
+		for i, key := range cfg.JWTKeys {
+			// Only a key that has stopped signing may go without a kid,
+			// to validate the tokens signed before there were any.
+			if (key.Id == "" && i == 0) || seen[key.Id] {
+				errs = append(errs, fmt.Errorf("jwt_keys entry %d needs a kid of its own", i+1))
+			}
+			seen[key.Id] = true
+			if i == 0 && !key.RetireAfter.IsZero() {
+				errs = append(errs, errors.New("the first jwt_keys entry signs new tokens and cannot have a retire_after"))
+			}
+			if (key.Secret == "") == (key.KeyFile == "") {
+				errs = append(errs, fmt.Errorf("jwt_keys entry %d needs either a secret or a key_file", i+1))
+			}
+		}
+	case cfg.JWTSecret == "" && cfg.JWTKeyFile == "":
+		errs = append(errs, errors.New("no JWT secret: set jwt_secret in the config file or JWT_SECRET in the environment, or jwt_key_file (JWT_KEY_FILE) to sign with a private key"))
+	}
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %q: must be a number from 1 to 65535", cfg.Port))
+	}
+	switch cfg.DBDriver {
+	case "", "gob", "sqlite":
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			errs = append(errs, errors.New("the postgres driver needs database_url in the config file or DATABASE_URL in the environment"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown database driver %q: must be gob, sqlite, or postgres", cfg.DBDriver))
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	if cfg.Domain != "" && cfg.TLSCert != "" {
+		errs = append(errs, errors.New("set either domain for Let's Encrypt certificates or tls_cert and tls_key, not both"))
+	}
+	if _, err := cfg.idGenerator(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// idGenerator returns the generator chirp ids are made with, nil for the
+// store's own sequence. Servers sharing a database with snowflake ids each
+// need a node_id of their own.
+func (cfg config) idGenerator() (database.IdGenerator, error) {
+	node := 0
+	if cfg.NodeId != "" {
+		var err error
+		node, err = strconv.Atoi(cfg.NodeId)
+		if err != nil || node < 0 || node > database.MaxNodeId {
+			return nil, fmt.Errorf("invalid node_id %q: must be a number from 0 to %d", cfg.NodeId, database.MaxNodeId)
+		}
+	}
+	return database.NewIdGenerator(cfg.ChirpIds, node)
+}
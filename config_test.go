@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.yaml")
+	err := os.WriteFile(path, []byte("port: \"9000\"\njwt_secret: from-file\ndb_driver: sqlite\nstatic_dir: ./public\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CHIRPY_CONFIG": path, "PORT": "9100"}
+	getenv := func(key string) string { return env[key] }
+
+	runLoadConfigTest(t, nil, getenv, config{
+		Port: "9100", StaticDir: "./public", DBDriver: "sqlite", DBPath: "./database.sqlite",
+		MediaDir: "./media", JWTSecret: "from-file",
+	}, true)
+	runLoadConfigTest(t, []string{"--port", "9200", "--db-path", "/tmp/chirpy.sqlite"}, getenv, config{
+		Port: "9200", StaticDir: "./public", DBDriver: "sqlite", DBPath: "/tmp/chirpy.sqlite",
+		MediaDir: "./media", JWTSecret: "from-file",
+	}, true)
+
+	noFile := func(key string) string {
+		return map[string]string{"CHIRPY_CONFIG": filepath.Join(t.TempDir(), "missing.yaml")}[key]
+	}
+	runLoadConfigTest(t, nil, noFile, config{}, false)
+
+	noSecret := func(key string) string { return "" }
+	runLoadConfigTest(t, []string{"--config", ""}, noSecret, config{}, false)
+	runLoadConfigTest(t, []string{"--port", "http"}, getenv, config{}, false)
+	runLoadConfigTest(t, []string{"--tls-cert", "cert.pem", "--tls-key", "key.pem"}, getenv, config{
+		Port: "9100", StaticDir: "./public", DBDriver: "sqlite", DBPath: "./database.sqlite",
+		MediaDir: "./media", JWTSecret: "from-file", TLSCert: "cert.pem", TLSKey: "key.pem",
+	}, true)
+	runLoadConfigTest(t, []string{"--tls-cert", "cert.pem"}, getenv, config{}, false)
+
+	env["CHIRP_IDS"], env["NODE_ID"] = "snowflake", "7"
+	runLoadConfigTest(t, nil, getenv, config{
+		Port: "9100", StaticDir: "./public", DBDriver: "sqlite", DBPath: "./database.sqlite",
+		MediaDir: "./media", JWTSecret: "from-file", ChirpIds: "snowflake", NodeId: "7",
+	}, true)
+	env["NODE_ID"] = "1024"
+	runLoadConfigTest(t, nil, getenv, config{}, false)
+	env["CHIRP_IDS"], env["NODE_ID"] = "uuid", ""
+	runLoadConfigTest(t, nil, getenv, config{}, false)
+	delete(env, "CHIRP_IDS")
+	runLoadConfigTest(t, []string{"--domain", "chirpy.example", "--tls-cert", "cert.pem", "--tls-key", "key.pem"}, getenv, config{}, false)
+
+	keysPath := filepath.Join(t.TempDir(), "keys.yaml")
+	err = os.WriteFile(keysPath, []byte("jwt_keys:\n  - kid: \"2024\"\n    key_file: jwt.pem\n  - kid: \"2023\"\n    secret: old\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysEnv := map[string]string{"CHIRPY_CONFIG": keysPath}
+	withKeys := func(key string) string { return keysEnv[key] }
+	runLoadConfigTest(t, nil, withKeys, config{
+		Port: "8080", StaticDir: "./app", DBPath: "./database.gob", MediaDir: "./media",
+		JWTKeys: []jwtKey{{Id: "2024", KeyFile: "jwt.pem"}, {Id: "2023", Secret: "old"}},
+	}, true)
+	keysEnv["JWT_SECRET"] = "also"
+	runLoadConfigTest(t, nil, withKeys, config{}, false)
+
+	rotatedPath := filepath.Join(t.TempDir(), "rotated.yaml")
+	err = os.WriteFile(rotatedPath, []byte("jwt_keys:\n  - kid: \"2024\"\n    secret: new\n  - secret: old\n    retire_after: 2024-06-01T12:00:00Z\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysEnv = map[string]string{"CHIRPY_CONFIG": rotatedPath}
+	runLoadConfigTest(t, nil, withKeys, config{
+		Port: "8080", StaticDir: "./app", DBPath: "./database.gob", MediaDir: "./media",
+		JWTKeys: []jwtKey{{Id: "2024", Secret: "new"}, {Secret: "old", RetireAfter: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)}},
+	}, true)
+	err = os.WriteFile(rotatedPath, []byte("jwt_keys:\n  - secret: new\n    retire_after: 2024-06-01T12:00:00Z\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runLoadConfigTest(t, nil, withKeys, config{}, false)
+}
+
+func runLoadConfigTest(t *testing.T, args []string, getenv func(string) string, expecting config, valid bool) {
+	t.Logf("Starting test for loadConfig with: %v, and expecting: %+v (valid: %t)", args, expecting, valid)
+	conf, err := loadConfig(args, getenv)
+	if valid != (err == nil) {
+		t.Errorf("Expecting: valid %t, but got: %v", valid, err)
+		return
+	}
+	if valid && !reflect.DeepEqual(conf, expecting) {
+		t.Errorf("Expecting: %+v, but got: %+v", expecting, conf)
+	}
+}
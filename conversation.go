@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+const (
+	defaultContextLimit = 40
+	maxContextLimit     = 200
+)
+
+// getChirpContextHandler returns a chirp with the chain of chirps it replies
+// to, root first, and a page of the thread below it. Descendants are listed
+// depth first, oldest reply first at each level, each with its depth below
+// the chirp so clients can indent it. Pass the returned next id as after to
+// get the following page.
+func (cfg *apiConfig) getChirpContextHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	limit := defaultContextLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxContextLimit {
+			respondValidationError(w, "limit must be a number from 1 to "+strconv.Itoa(maxContextLimit))
+			return
+		}
+	}
+	after := 0
+	if value := r.URL.Query().Get("after"); value != "" {
+		after, err = strconv.Atoi(value)
+		if err != nil {
+			respondValidationError(w, "after must be a chirp id")
+			return
+		}
+	}
+
+	chirp, ok, err := cfg.store(r.Context()).GetChirp(id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+	ancestors, err := cfg.ancestors(r.Context(), chirp)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	descendants, err := cfg.store(r.Context()).GetDescendantsWithDeleted(chirp.Id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	thread := pruneTombstones(flattenThread(chirp.Id, descendants))
+	page, next, ok := pageThread(thread, after, limit)
+	if !ok {
+		respondValidationError(w, "after is not a chirp in this thread")
+		return
+	}
+
+	chirps := append(ancestors, chirp)
+	for _, entry := range page {
+		chirps = append(chirps, entry.chirp)
+	}
+	rendered, err := cfg.renderThread(r.Context(), chirps)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+
+	type descendant struct {
+		chirpResponse
+		Depth int `json:"depth"`
+	}
+	type deletedDescendant struct {
+		tombstone
+		Depth int `json:"depth"`
+	}
+	type returnVal struct {
+		Ancestors   []any `json:"ancestors"`
+		Chirp       any   `json:"chirp"`
+		Descendants []any `json:"descendants"`
+		Next        *int  `json:"next"`
+	}
+	resp := returnVal{
+		Ancestors:   rendered[:len(ancestors)],
+		Chirp:       rendered[len(ancestors)],
+		Descendants: make([]any, len(page)),
+		Next:        next,
+	}
+	for i, entry := range page {
+		switch rendered := rendered[len(ancestors)+1+i].(type) {
+		case chirpResponse:
+			resp.Descendants[i] = descendant{chirpResponse: rendered, Depth: entry.depth}
+		case tombstone:
+			resp.Descendants[i] = deletedDescendant{tombstone: rendered, Depth: entry.depth}
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// ancestors walks up from chirp to the root of its conversation and returns
+// the chirps on the way, root first. Deleted ancestors are kept so they can
+// be shown as tombstones. A purged one is known only by its id, and ends
+// the walk.
+func (cfg *apiConfig) ancestors(ctx context.Context, chirp database.Chirp) ([]database.Chirp, error) {
+	ancestors := []database.Chirp{}
+	for chirp.ParentId != nil {
+		parent, found, err := cfg.store(ctx).GetChirpWithDeleted(*chirp.ParentId)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			purged := time.Time{}
+			ancestors = append(ancestors, database.Chirp{Id: *chirp.ParentId, DeletedAt: &purged})
+			break
+		}
+		ancestors = append(ancestors, parent)
+		chirp = parent
+	}
+	for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+		ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+	}
+	return ancestors, nil
+}
+
+// renderThread renders the chirps of a thread in order, with a tombstone
+// in place of each deleted one.
+func (cfg *apiConfig) renderThread(ctx context.Context, chirps []database.Chirp) ([]any, error) {
+	live := make([]database.Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		if chirp.DeletedAt == nil {
+			live = append(live, chirp)
+		}
+	}
+	rendered, err := cfg.renderChirps(ctx, live)
+	if err != nil {
+		return nil, err
+	}
+	thread := make([]any, 0, len(chirps))
+	for _, chirp := range chirps {
+		if chirp.DeletedAt != nil {
+			thread = append(thread, newTombstone(chirp.Id))
+			continue
+		}
+		thread = append(thread, rendered[0])
+		rendered = rendered[1:]
+	}
+	return thread, nil
+}
+
+type threadEntry struct {
+	chirp database.Chirp
+	depth int
+}
+
+// flattenThread orders the descendants of rootId depth first. Descendants
+// must be in ascending id order, which keeps siblings oldest first.
+func flattenThread(rootId int, descendants []database.Chirp) []threadEntry {
+	children := make(map[int][]database.Chirp)
+	for _, chirp := range descendants {
+		children[*chirp.ParentId] = append(children[*chirp.ParentId], chirp)
+	}
+	thread := make([]threadEntry, 0, len(descendants))
+	var visit func(parentId, depth int)
+	visit = func(parentId, depth int) {
+		for _, chirp := range children[parentId] {
+			thread = append(thread, threadEntry{chirp: chirp, depth: depth})
+			visit(chirp.Id, depth+1)
+		}
+	}
+	visit(rootId, 1)
+	return thread
+}
+
+// pruneTombstones drops the deleted chirps of a flattened thread that have
+// no live replies below them. The rest stay, so those replies still hang
+// off something.
+func pruneTombstones(thread []threadEntry) []threadEntry {
+	kept := make([]threadEntry, 0, len(thread))
+	// Walking backwards, an entry has a kept descendant exactly when the
+	// entry kept just after it is deeper.
+	nextDepth := 0
+	for i := len(thread) - 1; i >= 0; i-- {
+		entry := thread[i]
+		if entry.chirp.DeletedAt != nil && nextDepth <= entry.depth {
+			continue
+		}
+		kept = append(kept, entry)
+		nextDepth = entry.depth
+	}
+	slices.Reverse(kept)
+	return kept
+}
+
+// pageThread returns up to limit entries following the one for chirp after,
+// or from the start when after is zero, and the id to continue from if
+// there are more. It reports false if after is not in the thread.
+func pageThread(thread []threadEntry, after, limit int) ([]threadEntry, *int, bool) {
+	start := 0
+	if after != 0 {
+		i := slices.IndexFunc(thread, func(entry threadEntry) bool { return entry.chirp.Id == after })
+		if i < 0 {
+			return nil, nil, false
+		}
+		start = i + 1
+	}
+	end := min(start+limit, len(thread))
+	page := thread[start:end]
+	if end == len(thread) {
+		return page, nil, true
+	}
+	next := page[len(page)-1].chirp.Id
+	return page, &next, true
+}
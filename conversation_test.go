@@ -0,0 +1,52 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestConversation(t *testing.T) {
+	reply := func(id, parentId int) database.Chirp {
+		return database.Chirp{Id: id, ParentId: &parentId}
+	}
+	// 1 <- 2 <- 4, 1 <- 3 <- 5 <- 6
+	thread := flattenThread(1, []database.Chirp{reply(2, 1), reply(3, 1), reply(4, 2), reply(5, 3), reply(6, 5)})
+	runPageThreadTest(t, thread, 0, 3, []int{2, 4, 3}, []int{1, 2, 1}, 3)
+	runPageThreadTest(t, thread, 3, 3, []int{5, 6}, []int{2, 3}, 0)
+
+	deleted := func(chirp database.Chirp) database.Chirp {
+		chirp.DeletedAt = &time.Time{}
+		return chirp
+	}
+	// 1 <- (2) <- 4, 1 <- 3 <- (5) <- (6), 1 <- (7)
+	withDeleted := flattenThread(1, []database.Chirp{deleted(reply(2, 1)), reply(3, 1), reply(4, 2), deleted(reply(5, 3)), deleted(reply(6, 5)), deleted(reply(7, 1))})
+	runPageThreadTest(t, pruneTombstones(withDeleted), 0, 10, []int{2, 4, 3}, []int{1, 2, 1}, 0)
+
+	t.Logf("Starting test for pageThread with: an unknown cursor, and expecting: false")
+	if _, _, ok := pageThread(thread, 99, 3); ok {
+		t.Errorf("Expecting: false, but got: %t", ok)
+	}
+}
+
+func runPageThreadTest(t *testing.T, thread []threadEntry, after, limit int, ids, depths []int, next int) {
+	t.Logf("Starting test for pageThread with: after %d and limit %d, and expecting: %v at depths %v, next %d", after, limit, ids, depths, next)
+	page, gotNext, ok := pageThread(thread, after, limit)
+	if !ok {
+		t.Errorf("Expecting: a page, but got: none")
+		return
+	}
+	gotIds, gotDepths := []int{}, []int{}
+	for _, entry := range page {
+		gotIds = append(gotIds, entry.chirp.Id)
+		gotDepths = append(gotDepths, entry.depth)
+	}
+	if !slices.Equal(gotIds, ids) || !slices.Equal(gotDepths, depths) {
+		t.Errorf("Expecting: %v at depths %v, but got: %v at depths %v", ids, depths, gotIds, gotDepths)
+	}
+	if (gotNext == nil) != (next == 0) || gotNext != nil && *gotNext != next {
+		t.Errorf("Expecting: next %d, but got: %v", next, gotNext)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is what cross-origin requests a group of routes allows. The
+// zero policy allows none: browsers get no CORS headers, and preflights
+// are refused.
+type corsPolicy struct {
+	// origins lists the origins allowed, "*" allowing any.
+	origins []string
+	methods []string
+	// exposeHeaders lists the response headers scripts may read.
+	exposeHeaders []string
+	// maxAge is how long browsers may cache a preflight's answer.
+	maxAge time.Duration
+	// denyForeign refuses every request from another origin with 403, not
+	// just preflights, so a page elsewhere cannot even send one.
+	denyForeign bool
+}
+
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	return slices.Contains(p.origins, "*") || slices.Contains(p.origins, origin)
+}
+
+// corsPolicies chooses the CORS policy of each request. Like bodyLimits
+// it goes by path, as preflights must be answered before routing, rate
+// limiting, or authentication could refuse them.
+type corsPolicies struct {
+	def corsPolicy
+	// paths holds the policies for the paths under each prefix, the
+	// longest prefix winning.
+	paths map[string]corsPolicy
+}
+
+// policy returns the policy for requests to path.
+func (c corsPolicies) policy(path string) corsPolicy {
+	policy, longest := c.def, -1
+	for prefix, prefixPolicy := range c.paths {
+		if len(prefix) > longest && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			policy, longest = prefixPolicy, len(prefix)
+		}
+	}
+	return policy
+}
+
+// newCorsPolicies opens /api to origins, which CORS_ORIGINS lists and
+// defaults to any, lets anyone read media and the signing keys, and keeps
+// /admin and everything else to their own origin.
+func newCorsPolicies(origins []string) corsPolicies {
+	publicRead := corsPolicy{
+		origins: []string{"*"},
+		methods: []string{http.MethodGet, http.MethodHead},
+		maxAge:  24 * time.Hour,
+	}
+	return corsPolicies{
+		paths: map[string]corsPolicy{
+			"/api": {
+				origins:       origins,
+				methods:       []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+				exposeHeaders: []string{"Location", "Upload-Offset", "Upload-Length"},
+				maxAge:        10 * time.Minute,
+			},
+			"/admin":       {denyForeign: true},
+			"/media":       publicRead,
+			"/.well-known": publicRead,
+		},
+	}
+}
+
+// corsOrigins reads a comma-separated list of origins, defaulting to any.
+func corsOrigins(list string) []string {
+	var origins []string
+	for _, origin := range strings.Split(list, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
+}
+
+// sameOrigin reports whether origin is the host r was sent to, which
+// browsers also name in the Origin of some same-origin requests.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// middlewareCors answers preflights and marks the responses browsers may
+// share with other origins, following the policy of the request's path.
+func (cfg *apiConfig) middlewareCors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || sameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		policy := cfg.cors.policy(r.URL.Path)
+		wildcard := slices.Contains(policy.origins, "*")
+		if !wildcard {
+			// The answer differs by origin, so caches must keep them apart.
+			w.Header().Add("Vary", "Origin")
+		}
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		preflight := r.Method == http.MethodOptions && requestedMethod != ""
+		if !policy.allowsOrigin(origin) {
+			if preflight || policy.denyForeign {
+				respondForbidden(w, "cross-origin requests are not allowed here")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if wildcard {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if !preflight {
+			if len(policy.exposeHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.exposeHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !slices.Contains(policy.methods, requestedMethod) {
+			respondForbidden(w, requestedMethod+" is not allowed cross-origin here")
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
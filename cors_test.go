@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareCors(t *testing.T) {
+	cfg := &apiConfig{cors: newCorsPolicies(corsOrigins("https://app.example.com/, https://beta.example.com"))}
+	cases := []struct {
+		name, method, path, origin, requestMethod string
+		status                                    int
+		allowOrigin                               string
+		reached                                   bool
+	}{
+		{"no origin", "GET", "/admin/stats", "", "", 200, "", true},
+		{"same origin", "POST", "/admin/reset", "http://example.com", "", 200, "", true},
+		{"api preflight from a listed origin", "OPTIONS", "/api/chirps", "https://app.example.com", "POST", 204, "https://app.example.com", false},
+		{"api request from a listed origin", "POST", "/api/chirps", "https://beta.example.com", "", 200, "https://beta.example.com", true},
+		{"api preflight from another origin", "OPTIONS", "/api/chirps", "https://evil.example", "POST", 403, "", false},
+		{"api request from another origin", "GET", "/api/chirps", "https://evil.example", "", 200, "", true},
+		{"admin preflight from another origin", "OPTIONS", "/admin/reset", "https://app.example.com", "POST", 403, "", false},
+		{"admin request from another origin", "POST", "/admin/reset", "https://app.example.com", "", 403, "", false},
+		{"media read from any origin", "GET", "/media/abc", "https://evil.example", "", 200, "*", true},
+		{"media preflight for a write", "OPTIONS", "/media/abc", "https://evil.example", "DELETE", 403, "*", false},
+		{"media preflight for a read", "OPTIONS", "/media/abc", "https://evil.example", "GET", 204, "*", false},
+		{"static files from another origin", "GET", "/app/index.html", "https://app.example.com", "", 200, "", true},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for middlewareCors with: %s, and expecting: %d", c.name, c.status)
+		reached := false
+		handler := cfg.middlewareCors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+			w.WriteHeader(200)
+		}))
+		r := httptest.NewRequest(c.method, "http://example.com"+c.path, nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if c.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", c.requestMethod)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.status || reached != c.reached {
+			t.Errorf("Expecting: %d and reached %v, but got: %d and reached %v", c.status, c.reached, w.Code, reached)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != c.allowOrigin {
+			t.Errorf("Expecting: Access-Control-Allow-Origin %q, but got: %q", c.allowOrigin, got)
+		}
+	}
+
+	t.Logf("Starting test for corsOrigins with: no list, and expecting: any origin")
+	if origins := corsOrigins(""); len(origins) != 1 || origins[0] != "*" {
+		t.Errorf("Expecting: [*], but got: %v", origins)
+	}
+}
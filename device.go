@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// deviceFlow configures the device authorization flow of RFC 8628, which
+// lets clients without a browser, such as chirpyctl, log in: the client
+// gets a code, its user approves it at /activate, and the client polls for
+// tokens.
+type deviceFlow struct {
+	ttl      time.Duration // how long a code can be approved
+	interval time.Duration // how often clients may poll
+}
+
+// Errors of the device token endpoint, from RFC 8628 section 3.5.
+const (
+	deviceErrPending  = "authorization_pending"
+	deviceErrSlowDown = "slow_down"
+	deviceErrDenied   = "access_denied"
+	deviceErrExpired  = "expired_token"
+	deviceErrInvalid  = "invalid_grant"
+)
+
+// requestBaseURL is the scheme and host r was made to.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: r.Host}).String()
+}
+
+// activationURL is the page where the user enters userCode, or with the
+// code filled in when it is not empty.
+func activationURL(r *http.Request, userCode string) string {
+	u := requestBaseURL(r) + "/activate"
+	if userCode != "" {
+		u += "?" + url.Values{"user_code": {userCode}}.Encode()
+	}
+	return u
+}
+
+func (cfg *apiConfig) postDeviceCodeHandler(w http.ResponseWriter, r *http.Request) {
+	auth, deviceCode, err := cfg.store(r.Context()).CreateDeviceAuthorization(cfg.device.ttl)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+
+	type returnVal struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	data, err := json.Marshal(returnVal{
+		DeviceCode:              deviceCode,
+		UserCode:                auth.UserCode,
+		VerificationURI:         activationURL(r, ""),
+		VerificationURIComplete: activationURL(r, auth.UserCode),
+		ExpiresIn:               int(cfg.device.ttl / time.Second),
+		Interval:                int(cfg.device.interval / time.Second),
+	})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// postDeviceTokenHandler answers a client polling with its device code:
+// with the login response once the user approved, and otherwise with the
+// RFC 8628 error saying whether to keep polling.
+func (cfg *apiConfig) postDeviceTokenHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		DeviceCode string `json:"device_code"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	format, ok := cfg.requestTokenFormat(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	auth, err := cfg.store(r.Context()).PollDeviceAuthorization(params.DeviceCode, now)
+	if errors.Is(err, database.ErrDeviceCodeDoesNotExist) {
+		respondValidationError(w, deviceErrInvalid)
+		return
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	switch {
+	case auth.Expired(now):
+		respondValidationError(w, deviceErrExpired)
+	case auth.State == database.DeviceDenied:
+		respondValidationError(w, deviceErrDenied)
+	case auth.State == database.DevicePending && auth.PolledAt != nil && now.Sub(*auth.PolledAt) < cfg.device.interval:
+		respondValidationError(w, deviceErrSlowDown)
+	case auth.State == database.DevicePending:
+		respondValidationError(w, deviceErrPending)
+	default:
+		user, err := cfg.store(r.Context()).GetUserById(auth.UserId)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		cfg.respondLogin(w, r, format, user, false)
+	}
+}
+
+// postDeviceActivateHandler lets a signed-in user approve or deny a device
+// code from any client.
+func (cfg *apiConfig) postDeviceActivateHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	auth, err := cfg.store(r.Context()).DecideDeviceAuthorization(params.UserCode, userId, params.Approve)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(auth)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+var activatePage = template.Must(template.New("activate").Parse(`<!DOCTYPE html>
+<html>
+  <head><title>Activate a device - Chirpy</title></head>
+  <body>
+    <h1>Activate a device</h1>
+    {{if .Message}}<p>{{.Message}}</p>{{end}}
+    {{if not .Done}}
+    <form method="post" action="/activate">
+      <p><label>Code shown on your device <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+      <p><label>Email <input name="email" type="email" autocomplete="username" required></label></p>
+      <p><label>Password <input name="password" type="password" autocomplete="current-password" required></label></p>
+      <p><label>Two-factor code, if enabled <input name="totp_code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+      <p>
+        <button name="decision" value="approve">Log the device in</button>
+        <button name="decision" value="deny">Deny</button>
+      </p>
+    </form>
+    {{end}}
+  </body>
+</html>
+`))
+
+type activatePageData struct {
+	UserCode string
+	Message  string
+	Done     bool
+}
+
+func renderActivatePage(w http.ResponseWriter, status int, data activatePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	activatePage.Execute(w, data)
+}
+
+// getActivateHandler shows the page where users approve device codes,
+// with the code filled in when the device linked to it.
+func (cfg *apiConfig) getActivateHandler(w http.ResponseWriter, r *http.Request) {
+	renderActivatePage(w, 200, activatePageData{UserCode: r.URL.Query().Get("user_code")})
+}
+
+// postActivateHandler approves or denies a device code for the user
+// signing in with the page's form.
+func (cfg *apiConfig) postActivateHandler(w http.ResponseWriter, r *http.Request) {
+	userCode := r.PostFormValue("user_code")
+	email := r.PostFormValue("email")
+	now, ip := time.Now(), clientIP(r)
+	lockedUntil, err := cfg.loginLockout(r.Context(), email, ip, now)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if lockedUntil != nil {
+		renderActivatePage(w, http.StatusLocked, activatePageData{UserCode: userCode, Message: lockedOutMessage})
+		return
+	}
+	if err := cfg.store(r.Context()).ComparePasswords(r.PostFormValue("password"), email); err != nil {
+		cfg.activateLoginFailed(w, r, userCode, email, ip, now, "Wrong email or password.")
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUser(email)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	ok, err := cfg.secondFactor(r, user, r.PostFormValue("totp_code"))
+	if err != nil {
+		respondError(w, "Error checking the second factor", err)
+		return
+	}
+	if !ok {
+		cfg.activateLoginFailed(w, r, userCode, email, ip, now, "Enter a valid two-factor or recovery code.")
+		return
+	}
+	if err := cfg.resetLoginFailures(r.Context(), email); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	approve := r.PostFormValue("decision") == "approve"
+	_, err = cfg.store(r.Context()).DecideDeviceAuthorization(userCode, user.Id, approve)
+	var conflict *database.ConflictError
+	switch {
+	case errors.Is(err, database.ErrDeviceCodeDoesNotExist):
+		renderActivatePage(w, 404, activatePageData{UserCode: userCode, Message: "That code is wrong or has expired."})
+	case errors.As(err, &conflict):
+		renderActivatePage(w, 409, activatePageData{Message: conflict.Reason, Done: true})
+	case err != nil:
+		respondDataWriteError(w, err)
+	case approve:
+		renderActivatePage(w, 200, activatePageData{Message: "Your device is now logged in. You can close this page.", Done: true})
+	default:
+		renderActivatePage(w, 200, activatePageData{Message: "The device was denied.", Done: true})
+	}
+}
+
+const lockedOutMessage = "Too many failed logins. Try again later."
+
+// activateLoginFailed records a failed login on the activation page and
+// shows it again with message, or with the lockout the failure started.
+func (cfg *apiConfig) activateLoginFailed(w http.ResponseWriter, r *http.Request, userCode, email, ip string, at time.Time, message string) {
+	lockedUntil, err := cfg.recordLoginFailure(r.Context(), email, ip, at)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if lockedUntil != nil {
+		renderActivatePage(w, http.StatusLocked, activatePageData{UserCode: userCode, Message: lockedOutMessage})
+		return
+	}
+	renderActivatePage(w, 401, activatePageData{UserCode: userCode, Message: message})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// draftParams is the body of requests saving a draft.
+type draftParams struct {
+	Body     string         `json:"body"`
+	ParentId *int           `json:"parent_id"`
+	Media    []mediaRequest `json:"media"`
+}
+
+// decodeDraft reads and checks a draft the user is saving. Drafts are held
+// to the same limits as chirps, so publishing one only fails if something
+// changed in the meantime.
+func (cfg *apiConfig) decodeDraft(w http.ResponseWriter, r *http.Request, userId int) (database.Draft, bool) {
+	decoder := newJSONDecoder(r.Body)
+	params := draftParams{}
+	if err := decoder.Decode(&params); err != nil {
+		respondParamsDecodingError(w, err)
+		return database.Draft{}, false
+	}
+	if reason := cfg.validateChirpBody(params.Body); reason != "" {
+		respondValidationError(w, reason)
+		return database.Draft{}, false
+	}
+	attachments, reason, err := cfg.attachMedia(r.Context(), userId, params.Media)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return database.Draft{}, false
+	}
+	if reason != "" {
+		respondValidationError(w, reason)
+		return database.Draft{}, false
+	}
+	return database.Draft{AuthorId: userId, Body: params.Body, ParentId: params.ParentId, Media: attachments}, true
+}
+
+// draftIdParam reads the draft id from the URL, answering 400 if it is not
+// a number.
+func draftIdParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondValidationError(w, "draft id must be a number")
+		return 0, false
+	}
+	return id, true
+}
+
+func respondDraft(w http.ResponseWriter, status int, draft database.Draft) {
+	data, err := json.Marshal(draft)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	drafts, err := cfg.store(r.Context()).GetDrafts(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(drafts)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) postDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	draft, ok := cfg.decodeDraft(w, r, userId)
+	if !ok {
+		return
+	}
+	draft, err := cfg.store(r.Context()).CreateDraft(draft)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	respondDraft(w, 201, draft)
+}
+
+func (cfg *apiConfig) getDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	id, ok := draftIdParam(w, r)
+	if !ok {
+		return
+	}
+	draft, err := cfg.store(r.Context()).GetDraft(id, userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	respondDraft(w, 200, draft)
+}
+
+func (cfg *apiConfig) putDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	id, ok := draftIdParam(w, r)
+	if !ok {
+		return
+	}
+	draft, ok := cfg.decodeDraft(w, r, userId)
+	if !ok {
+		return
+	}
+	draft.Id = id
+	draft, err := cfg.store(r.Context()).UpdateDraft(draft)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	respondDraft(w, 200, draft)
+}
+
+func (cfg *apiConfig) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	id, ok := draftIdParam(w, r)
+	if !ok {
+		return
+	}
+	if err := cfg.store(r.Context()).DeleteDraft(id, userId); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// postPublishDraftHandler posts a draft as a chirp, answering as
+// POST /api/chirps would, and deletes the draft once it is published. A
+// draft that no longer passes, say because its media was deleted, is kept.
+func (cfg *apiConfig) postPublishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) || cfg.rejectUnverified(w, r, userId) {
+		return
+	}
+	id, ok := draftIdParam(w, r)
+	if !ok {
+		return
+	}
+	draft, err := cfg.store(r.Context()).GetDraft(id, userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	media := make([]mediaRequest, len(draft.Media))
+	for i, attachment := range draft.Media {
+		media[i] = mediaRequest{Id: attachment.Id, AltText: attachment.AltText}
+	}
+	if !cfg.postChirp(w, r, userId, chirpRequest{Body: draft.Body, ParentId: draft.ParentId, Media: media}) {
+		return
+	}
+	if err := cfg.store(r.Context()).DeleteDraft(draft.Id, userId); err != nil {
+		log.Printf("Published draft %d but could not delete it: %v", draft.Id, err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDrafts(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		db:             db,
+		tokens:         auth.NewIssuer("secret"),
+		maxChirpLength: 140,
+		profanity:      newProfanityFilter(database.DefaultBlockedWords),
+		broker:         newChirpBroker(),
+		inboxes:        newFeedInboxes(2, 1),
+	}
+	router := chi.NewRouter()
+	router.Post("/api/drafts", cfg.postDraftHandler)
+	router.Put("/api/drafts/{id}", cfg.putDraftHandler)
+	router.Get("/api/drafts/{id}", cfg.getDraftHandler)
+	router.Post("/api/drafts/{id}/publish", cfg.postPublishDraftHandler)
+	author, _ := db.CreateUser("author@example.com", "hash")
+	verification, _ := db.CreateVerificationToken(author.Id, time.Hour)
+	if _, err := db.VerifyUser(verification); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := cfg.tokens.NewAccessToken(author.Id)
+	stranger, _ := cfg.tokens.NewAccessToken(author.Id + 1)
+
+	runDraftRequest(t, router, token, "POST", "/api/drafts", `{"body":"`+strings.Repeat("a", 141)+`"}`, 400)
+	resp := runDraftRequest(t, router, token, "POST", "/api/drafts", `{"body":"First try"}`, 201)
+	draft := database.Draft{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &draft); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/drafts/" + strconv.Itoa(draft.Id)
+	runDraftRequest(t, router, token, "PUT", path, `{"body":"What a kerfuffle"}`, 200)
+	runDraftRequest(t, router, stranger, "GET", path, "", 404)
+	runDraftRequest(t, router, token, "GET", "/api/drafts/first", "", 400)
+	runDraftRequest(t, router, token, "PUT", "/api/drafts/first", `{"body":"What a kerfuffle"}`, 400)
+	runDraftRequest(t, router, stranger, "POST", path+"/publish", "", 404)
+
+	resp = runDraftRequest(t, router, token, "POST", path+"/publish", "", 201)
+	chirp := chirpResponse{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &chirp); err != nil {
+		t.Fatal(err)
+	}
+	if chirp.Body != "What a ****" || chirp.AuthorId != author.Id {
+		t.Errorf("Expecting: the edited body censored, but got: %+v", chirp)
+	}
+	runDraftRequest(t, router, token, "GET", path, "", 404)
+}
+
+func runDraftRequest(t *testing.T, router http.Handler, token, method, path, body string, expecting int) *httptest.ResponseRecorder {
+	t.Logf("Starting test for %s %s with: %q, and expecting: %d", method, path, body, expecting)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != expecting {
+		t.Errorf("Expecting: %d, but got: %d %s", expecting, resp.Code, resp.Body.String())
+	}
+	return resp
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/avearmin/chirpy/internal/richtext"
+	"github.com/go-chi/chi/v5"
+)
+
+// emojiResponse is a custom emoji as clients see it.
+type emojiResponse struct {
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+}
+
+// getEmojiHandler lists the instance's custom emoji so clients can offer
+// them in a picker and render them in chirps they display themselves.
+func (cfg *apiConfig) getEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	emoji, err := cfg.store(r.Context()).GetEmoji()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	resp := make([]emojiResponse, 0, len(emoji))
+	for _, e := range emoji {
+		resp = append(resp, emojiResponse{Shortcode: e.Shortcode, URL: "/media/" + e.MediaId})
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// putEmojiHandler adds the custom emoji named by the URL, or replaces its
+// image, using media that has already been uploaded.
+func (cfg *apiConfig) putEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	shortcode := chi.URLParam(r, "shortcode")
+	if !richtext.ValidShortcode(shortcode) {
+		respondValidationError(w, "shortcode must start with a letter and be 2 to 32 letters, digits, or underscores")
+		return
+	}
+
+	type parameters struct {
+		MediaId string `json:"media_id"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if _, err := cfg.store(r.Context()).GetMedia(params.MediaId); errors.Is(err, database.ErrMediaDoesNotExist) {
+		respondValidationError(w, "media "+params.MediaId+" does not exist")
+		return
+	} else if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	emoji, err := cfg.store(r.Context()).PutEmoji(shortcode, params.MediaId)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(emojiResponse{Shortcode: emoji.Shortcode, URL: "/media/" + emoji.MediaId})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) deleteEmojiHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.store(r.Context()).DeleteEmoji(chi.URLParam(r, "shortcode"))
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// customEmoji maps each of the instance's shortcodes to its image URL.
+func (cfg *apiConfig) customEmoji(ctx context.Context) (map[string]string, error) {
+	emoji, err := cfg.store(ctx).GetEmoji()
+	if err != nil {
+		return nil, err
+	}
+	urls := make(map[string]string, len(emoji))
+	for _, e := range emoji {
+		urls[e.Shortcode] = "/media/" + e.MediaId
+	}
+	return urls, nil
+}
+
+// withEmoji sets the image URL of each emoji entity the instance has and
+// drops the rest, which stay as plain text in the body.
+func withEmoji(entities []richtext.Entity, emoji map[string]string) []richtext.Entity {
+	kept := make([]richtext.Entity, 0, len(entities))
+	for _, entity := range entities {
+		if entity.Type == richtext.TypeEmoji {
+			url, ok := emoji[entity.Value]
+			if !ok {
+				continue
+			}
+			entity.URL = url
+		}
+		kept = append(kept, entity)
+	}
+	return kept
+}
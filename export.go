@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// exportFile is one JSON file of a zipped export.
+type exportFile struct {
+	name string
+	data []byte
+}
+
+// getUserExportHandler hands the requesting user everything stored about
+// them: a single JSON document by default, or with format=zip an archive
+// holding one JSON file per part.
+func (cfg *apiConfig) getUserExportHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		respondValidationError(w, "format must be json or zip")
+		return
+	}
+	export, err := cfg.store(r.Context()).ExportUser(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	if format != "zip" {
+		data, err := json.Marshal(export)
+		if err != nil {
+			respondJSONMarshalError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.json"`)
+		w.WriteHeader(200)
+		w.Write(data)
+		return
+	}
+
+	parts := []struct {
+		name  string
+		value any
+	}{
+		{"profile.json", export.Profile},
+		{"chirps.json", export.Chirps},
+		{"likes.json", export.Likes},
+		{"following.json", export.Following},
+		{"followers.json", export.Followers},
+	}
+	files := make([]exportFile, 0, len(parts))
+	for _, part := range parts {
+		data, err := json.MarshalIndent(part.value, "", "  ")
+		if err != nil {
+			respondJSONMarshalError(w, err)
+			return
+		}
+		files = append(files, exportFile{name: part.name, data: data})
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="chirpy-export.zip"`)
+	w.WriteHeader(200)
+	if err := writeExportZip(w, files, export.ExportedAt); err != nil {
+		// The status is already sent, so all that is left is to log it.
+		log.Printf("Error writing export for user %d: %s", userId, err)
+	}
+}
+
+func writeExportZip(w io.Writer, files []exportFile, modified time.Time) error {
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
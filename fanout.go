@@ -0,0 +1,221 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// fanoutQueueLength bounds how many new chirps can wait for a fan-out worker.
+const fanoutQueueLength = 1024
+
+// feedInboxes caches every reader's home timeline as a bounded inbox of chirp
+// ids that fan-out workers push new chirps into as they are posted, so reading
+// a feed does not mean scanning every chirp. Authors with more than
+// maxFollowers followers are not fanned out; their chirps are pulled when a
+// follower reads their feed instead.
+type feedInboxes struct {
+	mux          sync.Mutex
+	size         int
+	maxFollowers int
+	inboxes      map[int][]int // reader id -> chirp ids, newest first
+	pulled       map[int]bool  // author id -> too many followers to fan out
+	queue        chan fanoutRequest
+}
+
+func newFeedInboxes(size, maxFollowers int) *feedInboxes {
+	return &feedInboxes{
+		size:         size,
+		maxFollowers: maxFollowers,
+		inboxes:      make(map[int][]int),
+		pulled:       make(map[int]bool),
+		queue:        make(chan fanoutRequest, fanoutQueueLength),
+	}
+}
+
+func (f *feedInboxes) get(readerId int) ([]int, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	inbox, found := f.inboxes[readerId]
+	return slices.Clone(inbox), found
+}
+
+func (f *feedInboxes) set(readerId int, chirpIds []int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.inboxes[readerId] = f.trim(chirpIds)
+}
+
+// push adds a chirp to a reader's inbox. Readers without an inbox are left
+// alone; theirs is pulled in full the next time they read their feed.
+func (f *feedInboxes) push(readerId, chirpId int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	inbox, found := f.inboxes[readerId]
+	if !found {
+		return
+	}
+	f.inboxes[readerId] = f.trim(append(inbox, chirpId))
+}
+
+// trim sorts chirp ids newest first and drops duplicates and whatever does
+// not fit.
+func (f *feedInboxes) trim(chirpIds []int) []int {
+	slices.SortFunc(chirpIds, func(a, b int) int { return cmp.Compare(b, a) })
+	chirpIds = slices.Compact(chirpIds)
+	if len(chirpIds) > f.size {
+		chirpIds = chirpIds[:f.size]
+	}
+	return chirpIds
+}
+
+// drop forgets a reader's inbox, for example because who they follow changed.
+func (f *feedInboxes) drop(readerId int) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	delete(f.inboxes, readerId)
+}
+
+// reset forgets every inbox, for when a chirp could not be fanned out.
+func (f *feedInboxes) reset() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.inboxes = make(map[int][]int)
+}
+
+// clear forgets every inbox and which authors are pulled, for when the
+// database has been wiped and ids will be handed out again.
+func (f *feedInboxes) clear() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.inboxes = make(map[int][]int)
+	f.pulled = make(map[int]bool)
+}
+
+func (f *feedInboxes) setPulled(authorId int, pulled bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if pulled {
+		f.pulled[authorId] = true
+	} else {
+		delete(f.pulled, authorId)
+	}
+}
+
+// pulledAmong returns the authors in authorIds whose chirps are not fanned out.
+func (f *feedInboxes) pulledAmong(authorIds []int) []int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	pulled := []int{}
+	for _, id := range authorIds {
+		if f.pulled[id] {
+			pulled = append(pulled, id)
+		}
+	}
+	return pulled
+}
+
+// fanoutRequest is a chirp waiting to be fanned out, with the id of the
+// request that posted it for the worker's logs.
+type fanoutRequest struct {
+	chirp     database.Chirp
+	requestId string
+}
+
+// enqueueFanout hands a new chirp to the fan-out workers without waiting.
+// If they are too far behind the chirp is dropped and every inbox is
+// forgotten, so readers pull their feeds afresh rather than miss it.
+func (cfg *apiConfig) enqueueFanout(ctx context.Context, chirp database.Chirp) {
+	if chirp.ParentId != nil {
+		return
+	}
+	select {
+	case cfg.inboxes.queue <- fanoutRequest{chirp: chirp, requestId: requestId(ctx)}:
+	default:
+		logRequestf(requestId(ctx), "Fan-out queue full, dropping feed inboxes")
+		cfg.inboxes.reset()
+	}
+}
+
+// fanoutWorker pushes queued chirps into their followers' inboxes until ctx
+// is done. Several run at once.
+func (cfg *apiConfig) fanoutWorker(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case req := <-cfg.inboxes.queue:
+			cfg.fanOut(req.chirp, req.requestId)
+		}
+	}
+}
+
+func (cfg *apiConfig) fanOut(chirp database.Chirp, requestId string) {
+	followers, err := cfg.db.GetFollowers(chirp.AuthorId)
+	if err != nil {
+		logRequestf(requestId, "Error fanning out chirp %d, dropping feed inboxes: %s", chirp.Id, err)
+		cfg.inboxes.reset()
+		return
+	}
+	cfg.inboxes.push(chirp.AuthorId, chirp.Id)
+	if len(followers) > cfg.inboxes.maxFollowers {
+		cfg.inboxes.setPulled(chirp.AuthorId, true)
+		return
+	}
+	cfg.inboxes.setPulled(chirp.AuthorId, false)
+	for _, followerId := range followers {
+		cfg.inboxes.push(followerId, chirp.Id)
+	}
+}
+
+// homeTimeline returns the recent top-level chirps of the reader and the
+// authors they follow, from the reader's inbox plus whatever has to be pulled.
+func (cfg *apiConfig) homeTimeline(ctx context.Context, userId int) ([]database.Chirp, error) {
+	following, err := cfg.store(ctx).GetFollowing(userId)
+	if err != nil {
+		return nil, err
+	}
+	// A mirrored replay reads the secondary store, so it neither uses
+	// the inboxes the primary filled nor fills them.
+	ids, found := cfg.inboxes.get(userId)
+	if !found || mirrored(ctx) {
+		ids, err = cfg.pullChirpIds(ctx, append(following, userId))
+		if err != nil {
+			return nil, err
+		}
+		if !mirrored(ctx) {
+			cfg.inboxes.set(userId, slices.Clone(ids))
+		}
+	}
+	pulled, err := cfg.pullChirpIds(ctx, cfg.inboxes.pulledAmong(following))
+	if err != nil {
+		return nil, err
+	}
+	return cfg.store(ctx).GetChirpsByIds(append(ids, pulled...))
+}
+
+// pullChirpIds returns the ids of the newest top-level chirps by authorIds,
+// at most an inbox's worth from each.
+func (cfg *apiConfig) pullChirpIds(ctx context.Context, authorIds []int) ([]int, error) {
+	ids := []int{}
+	for _, authorId := range authorIds {
+		chirps, err := cfg.store(ctx).GetChirpsFromId(authorId, "desc")
+		if err != nil {
+			return nil, err
+		}
+		pulled := 0
+		for _, chirp := range chirps {
+			if pulled == cfg.inboxes.size {
+				break
+			}
+			if chirp.ParentId == nil {
+				ids = append(ids, chirp.Id)
+				pulled++
+			}
+		}
+	}
+	return ids, nil
+}
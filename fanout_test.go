@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestFanout(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _ := db.CreateUser("reader@example.com", "hunter2")
+	friend, _ := db.CreateUser("friend@example.com", "hunter2")
+	celebrity, _ := db.CreateUser("celebrity@example.com", "hunter2")
+	fan, _ := db.CreateUser("fan@example.com", "hunter2")
+	stranger, _ := db.CreateUser("stranger@example.com", "hunter2")
+	db.Follow(reader.Id, friend.Id)
+	db.Follow(reader.Id, celebrity.Id)
+	db.Follow(fan.Id, celebrity.Id)
+
+	cfg := &apiConfig{db: db, inboxes: newFeedInboxes(2, 1)}
+	post := func(authorId int) database.Chirp {
+		chirp, err := db.CreateChirp(database.Chirp{Body: "hello", AuthorId: authorId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.fanOut(chirp, "")
+		return chirp
+	}
+	old := post(friend.Id)
+	runHomeTimelineTest(t, cfg, reader.Id, []int{old.Id})
+
+	fromFriend := post(friend.Id)
+	fromCelebrity := post(celebrity.Id)
+	post(stranger.Id)
+	runHomeTimelineTest(t, cfg, reader.Id, []int{old.Id, fromFriend.Id, fromCelebrity.Id})
+
+	t.Logf("Starting test for fanOut with: too many followers, and expecting: %v", []int{fromFriend.Id, old.Id})
+	if inbox, _ := cfg.inboxes.get(reader.Id); !slices.Equal(inbox, []int{fromFriend.Id, old.Id}) {
+		t.Errorf("Expecting: %v, but got: %v", []int{fromFriend.Id, old.Id}, inbox)
+	}
+}
+
+func runHomeTimelineTest(t *testing.T, cfg *apiConfig, userId int, expecting []int) {
+	t.Logf("Starting test for homeTimeline with: %d, and expecting: %v", userId, expecting)
+	chirps, err := cfg.homeTimeline(context.Background(), userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(chirps))
+	for i, chirp := range chirps {
+		ids[i] = chirp.Id
+	}
+	if !slices.Equal(ids, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, ids)
+	}
+}
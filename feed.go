@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/avearmin/chirpy/feed"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// feedLength caps how many chirps GET /api/feed returns.
+const feedLength = 50
+
+// putUserPreferencesHandler updates the requesting user's preferences.
+// Only the feed algorithm exists so far; an empty one resets it to the
+// server default.
+func (cfg *apiConfig) putUserPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		FeedAlgorithm string `json:"feed_algorithm"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if params.FeedAlgorithm != "" {
+		if _, ok := feed.Get(params.FeedAlgorithm); !ok {
+			respondValidationError(w, "unknown feed algorithm")
+			return
+		}
+	}
+
+	err = cfg.store(r.Context()).SetFeedAlgorithm(userId, params.FeedAlgorithm)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.writePreferences(w, params.FeedAlgorithm)
+}
+
+func (cfg *apiConfig) getUserPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	cfg.writePreferences(w, user.FeedAlgorithm)
+}
+
+func (cfg *apiConfig) writePreferences(w http.ResponseWriter, feedAlgorithm string) {
+	type returnVal struct {
+		FeedAlgorithm  string   `json:"feed_algorithm"`
+		FeedAlgorithms []string `json:"feed_algorithms"`
+	}
+	data, err := json.Marshal(returnVal{FeedAlgorithm: feedAlgorithm, FeedAlgorithms: feed.Names()})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// getFeedHandler returns the user's home timeline ordered by the ranker
+// named in the algorithm query parameter, falling back to the user's
+// preference and then the server default. The "for you" ranker has a
+// precomputed feed of its own.
+func (cfg *apiConfig) getFeedHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	name := r.URL.Query().Get("algorithm")
+	if name == "" {
+		user, err := cfg.store(r.Context()).GetUserById(userId)
+		if err != nil && !errors.Is(err, database.ErrUserDoesNotExist) {
+			respondDataFetchError(w, err)
+			return
+		}
+		name = user.FeedAlgorithm
+	}
+	ranker, ok := feed.Get(name)
+	if !ok {
+		if r.URL.Query().Get("algorithm") != "" {
+			respondValidationError(w, "unknown feed algorithm")
+			return
+		}
+		// The preferred ranker may have been compiled out since it was chosen.
+		ranker, _ = feed.Get(feed.Default)
+	}
+
+	var ranked []database.Chirp
+	var err error
+	if ranker.Name() == feed.ForYou {
+		ranked, err = cfg.forYouFeed(r.Context(), userId)
+	} else {
+		ranked, err = cfg.rankHomeTimeline(r.Context(), userId, ranker)
+	}
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	resp, err := cfg.renderChirps(r.Context(), ranked)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Feed-Algorithm", ranker.Name())
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) rankHomeTimeline(ctx context.Context, userId int, ranker feed.Ranker) ([]database.Chirp, error) {
+	chirps, err := cfg.homeTimeline(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[int]database.Chirp, len(chirps))
+	items := make([]feed.Item, 0, len(chirps))
+	for _, chirp := range chirps {
+		byId[chirp.Id] = chirp
+		items = append(items, feedItem(chirp))
+	}
+	return rankChirps(ranker, items, byId, time.Now()), nil
+}
+
+func feedItem(chirp database.Chirp) feed.Item {
+	return feed.Item{
+		ChirpId:    chirp.Id,
+		AuthorId:   chirp.AuthorId,
+		CreatedAt:  chirp.CreatedAt,
+		LikeCount:  chirp.LikeCount,
+		ReplyCount: chirp.ReplyCount,
+	}
+}
+
+// rankChirps orders items with ranker and returns the first feedLength of
+// the chirps they stand for.
+func rankChirps(ranker feed.Ranker, items []feed.Item, byId map[int]database.Chirp, now time.Time) []database.Chirp {
+	items = ranker.Rank(items, now)
+	ranked := make([]database.Chirp, 0, min(len(items), feedLength))
+	for _, item := range items {
+		if len(ranked) == feedLength {
+			break
+		}
+		if chirp, found := byId[item.ChirpId]; found {
+			ranked = append(ranked, chirp)
+		}
+	}
+	return ranked
+}
+
+func (cfg *apiConfig) getFeedMarkerHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	marker, err := cfg.store(r.Context()).GetFeedMarker(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	cfg.writeFeedMarker(w, r, marker)
+}
+
+// putFeedMarkerHandler records the newest chirp the user has seen. The
+// marker only moves forward; the response has the marker as stored.
+func (cfg *apiConfig) putFeedMarkerHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		LastReadId int `json:"last_read_id"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if params.LastReadId < 0 {
+		respondValidationError(w, "last_read_id must not be negative")
+		return
+	}
+
+	marker, err := cfg.store(r.Context()).SetFeedMarker(userId, params.LastReadId)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.writeFeedMarker(w, r, marker)
+}
+
+// writeFeedMarker answers with the marker and how many chirps in the user's
+// home timeline are newer than it.
+func (cfg *apiConfig) writeFeedMarker(w http.ResponseWriter, r *http.Request, marker database.FeedMarker) {
+	chirps, err := cfg.homeTimeline(r.Context(), marker.UserId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	type returnVal struct {
+		database.FeedMarker
+		UnreadCount int `json:"unread_count"`
+	}
+	resp := returnVal{FeedMarker: marker}
+	for _, chirp := range chirps {
+		if chirp.Id > marker.LastReadId && chirp.AuthorId != marker.UserId {
+			resp.UnreadCount++
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,154 @@
+// Package feed orders the chirps shown on a user's home feed. Each ordering
+// is a Ranker registered under a name users can pick as their preference;
+// operators can compile in their own next to the built-in ones:
+//
+//	func init() {
+//		feed.Register(newestFromFriends{})
+//	}
+package feed
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Item is a candidate chirp with the signals rankers may use.
+type Item struct {
+	ChirpId    int
+	AuthorId   int
+	CreatedAt  time.Time
+	LikeCount  int
+	ReplyCount int
+
+	// Followed is set when the reader follows the author.
+	Followed bool
+	// NetworkEngagements counts likes and replies from people the reader
+	// follows.
+	NetworkEngagements int
+}
+
+// Ranker orders feed candidates. Rank may reorder items in place and must
+// not add to them.
+type Ranker interface {
+	Name() string
+	Rank(items []Item, now time.Time) []Item
+}
+
+const (
+	Chronological = "chronological"
+	Trending      = "trending"
+	ForYou        = "for_you"
+
+	// Default is used for users who have not chosen a ranker.
+	Default = Chronological
+)
+
+var (
+	mux     sync.RWMutex
+	rankers = map[string]Ranker{}
+)
+
+func init() {
+	Register(chronological{})
+	Register(trending{})
+	Register(forYou{})
+}
+
+// Register makes a ranker available under its name, replacing any ranker
+// registered under the same name before.
+func Register(r Ranker) {
+	mux.Lock()
+	defer mux.Unlock()
+	rankers[r.Name()] = r
+}
+
+// Get returns the ranker registered under name.
+func Get(name string) (Ranker, bool) {
+	mux.RLock()
+	defer mux.RUnlock()
+	r, ok := rankers[name]
+	return r, ok
+}
+
+// Names lists the registered rankers in alphabetical order.
+func Names() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+	names := make([]string, 0, len(rankers))
+	for name := range rankers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// chronological puts the newest chirps first.
+type chronological struct{}
+
+func (chronological) Name() string { return Chronological }
+
+func (chronological) Rank(items []Item, now time.Time) []Item {
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.After(items[j].CreatedAt)
+		}
+		return items[i].ChirpId > items[j].ChirpId
+	})
+	return items
+}
+
+// trending weighs engagement against age, so a busy chirp from this morning
+// can outrank a quiet one from a minute ago but not one from last week.
+type trending struct{}
+
+func (trending) Name() string { return Trending }
+
+func (trending) Rank(items []Item, now time.Time) []Item {
+	scores := make(map[int]float64, len(items))
+	for _, item := range items {
+		scores[item.ChirpId] = trendingScore(item, now)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if scores[items[i].ChirpId] != scores[items[j].ChirpId] {
+			return scores[items[i].ChirpId] > scores[items[j].ChirpId]
+		}
+		return items[i].ChirpId > items[j].ChirpId
+	})
+	return items
+}
+
+// forYou is trending boosted by the reader's network: chirps from followed
+// authors count double, and every engagement from someone the reader follows
+// counts as much again as the chirp's whole public score.
+type forYou struct{}
+
+func (forYou) Name() string { return ForYou }
+
+func (forYou) Rank(items []Item, now time.Time) []Item {
+	scores := make(map[int]float64, len(items))
+	for _, item := range items {
+		score := trendingScore(item, now) * float64(1+item.NetworkEngagements)
+		if item.Followed {
+			score *= 2
+		}
+		scores[item.ChirpId] = score
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if scores[items[i].ChirpId] != scores[items[j].ChirpId] {
+			return scores[items[i].ChirpId] > scores[items[j].ChirpId]
+		}
+		return items[i].ChirpId > items[j].ChirpId
+	})
+	return items
+}
+
+// trendingScore counts a reply as two likes and decays the total with age,
+// with a two hour head start so brand new chirps do not dominate.
+func trendingScore(item Item, now time.Time) float64 {
+	engagement := float64(item.LikeCount + 2*item.ReplyCount + 1)
+	ageHours := max(now.Sub(item.CreatedAt).Hours(), 0)
+	return engagement / math.Pow(ageHours+2, 1.5)
+}
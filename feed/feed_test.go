@@ -0,0 +1,50 @@
+package feed
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	items := []Item{
+		{ChirpId: 1, CreatedAt: now.Add(-30 * time.Hour), LikeCount: 40},
+		{ChirpId: 2, CreatedAt: now.Add(-3 * time.Hour), LikeCount: 10, ReplyCount: 3},
+		{ChirpId: 3, CreatedAt: now.Add(-time.Minute)},
+	}
+
+	runRankTest(t, Chronological, items, now, []int{3, 2, 1})
+	runRankTest(t, Trending, items, now, []int{2, 3, 1})
+
+	items[0].NetworkEngagements = 3
+	items[2].Followed = true
+	runRankTest(t, ForYou, items, now, []int{2, 1, 3})
+
+	t.Logf("Starting test for Get with: unknown, and expecting: false")
+	if _, ok := Get("unknown"); ok {
+		t.Errorf("Expecting: %v, but got: %v", false, ok)
+	}
+	expected := []string{Chronological, ForYou, Trending}
+	t.Logf("Starting test for Names, and expecting: %v", expected)
+	if names := Names(); !slices.Equal(names, expected) {
+		t.Errorf("Expecting: %v, but got: %v", expected, names)
+	}
+}
+
+func runRankTest(t *testing.T, name string, items []Item, now time.Time, expected []int) {
+	t.Logf("Starting test for Rank with: %s, and expecting: %v", name, expected)
+	ranker, ok := Get(name)
+	if !ok {
+		t.Errorf("Expecting: ranker %s, but got: none", name)
+		return
+	}
+	ranked := ranker.Rank(slices.Clone(items), now)
+	ids := make([]int, len(ranked))
+	for i, item := range ranked {
+		ids[i] = item.ChirpId
+	}
+	if !slices.Equal(ids, expected) {
+		t.Errorf("Expecting: %v, but got: %v", expected, ids)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func (cfg *apiConfig) postFollowHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, cfg.store(r.Context()).Follow)
+}
+
+func (cfg *apiConfig) deleteFollowHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollow(w, r, cfg.store(r.Context()).Unfollow)
+}
+
+func (cfg *apiConfig) setFollow(w http.ResponseWriter, r *http.Request, update func(followerId, followeeId int) error) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	urlParam := chi.URLParam(r, "id")
+	followeeId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	err = update(userId, followeeId)
+	if errors.Is(err, database.ErrCannotFollowSelf) {
+		respondValidationError(w, err.Error())
+		return
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.inboxes.drop(userId)
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, cfg.store(r.Context()).GetFollowing)
+}
+
+func (cfg *apiConfig) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, cfg.store(r.Context()).GetFollowers)
+}
+
+// listFollows answers with the profiles of the users list returns for the
+// user in the URL.
+func (cfg *apiConfig) listFollows(w http.ResponseWriter, r *http.Request, list func(userId int) ([]int, error)) {
+	urlParam := chi.URLParam(r, "id")
+	userId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	if _, err := cfg.store(r.Context()).GetUserById(userId); err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	ids, err := list(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	profiles, err := cfg.store(r.Context()).GetProfiles(ids)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	resp := make([]database.Profile, 0, len(ids))
+	for _, id := range ids {
+		if profile, found := profiles[id]; found {
+			resp = append(resp, profile)
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
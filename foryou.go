@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/avearmin/chirpy/feed"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+const (
+	// forYouWindow is how far back the "for you" feed looks for chirps.
+	forYouWindow = 7 * 24 * time.Hour
+	// forYouIdle is how long a reader can stay away before the background
+	// job stops rebuilding their feed.
+	forYouIdle = 24 * time.Hour
+)
+
+// forYouFeeds holds the precomputed "for you" feed of every recent reader.
+// A background job rebuilds them, so serving one is a map lookup.
+type forYouFeeds struct {
+	mux   sync.Mutex
+	feeds map[int]forYouFeed // reader id -> feed
+}
+
+type forYouFeed struct {
+	chirps  []database.Chirp
+	builtAt time.Time
+	readAt  time.Time
+}
+
+func newForYouFeeds() *forYouFeeds {
+	return &forYouFeeds{feeds: make(map[int]forYouFeed)}
+}
+
+// get returns the reader's feed as of its last rebuild and notes that they
+// are still reading it.
+func (f *forYouFeeds) get(userId int, now time.Time) ([]database.Chirp, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	cached, found := f.feeds[userId]
+	if !found {
+		return nil, false
+	}
+	cached.readAt = now
+	f.feeds[userId] = cached
+	return cached.chirps, true
+}
+
+func (f *forYouFeeds) put(userId int, chirps []database.Chirp, now time.Time) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	readAt := now
+	if cached, found := f.feeds[userId]; found {
+		readAt = cached.readAt
+	}
+	f.feeds[userId] = forYouFeed{chirps: chirps, builtAt: now, readAt: readAt}
+}
+
+// clear forgets every feed, for when the database has been wiped.
+func (f *forYouFeeds) clear() {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.feeds = make(map[int]forYouFeed)
+}
+
+// readers forgets the feeds of readers idle since before idleSince and
+// returns everyone else.
+func (f *forYouFeeds) readers(idleSince time.Time) []int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	ids := make([]int, 0, len(f.feeds))
+	for id, cached := range f.feeds {
+		if cached.readAt.Before(idleSince) {
+			delete(f.feeds, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// refreshForYouFeeds rebuilds the feeds of recent readers every interval
+// until ctx is done.
+func (cfg *apiConfig) refreshForYouFeeds(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		now := time.Now()
+		for _, userId := range cfg.forYou.readers(now.Add(-forYouIdle)) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			chirps, err := cfg.buildForYouFeed(ctx, userId, now)
+			if err != nil {
+				log.Printf("Error building feed for user %d: %s", userId, err)
+				continue
+			}
+			cfg.forYou.put(userId, chirps, now)
+		}
+	}
+}
+
+// forYouFeed returns the reader's precomputed feed, building it on the spot
+// the first time they ask for it. Mirrored replays always build it from the
+// secondary store and leave the cache alone.
+func (cfg *apiConfig) forYouFeed(ctx context.Context, userId int) ([]database.Chirp, error) {
+	now := time.Now()
+	if mirrored(ctx) {
+		return cfg.buildForYouFeed(ctx, userId, now)
+	}
+	if chirps, found := cfg.forYou.get(userId, now); found {
+		return chirps, nil
+	}
+	chirps, err := cfg.buildForYouFeed(ctx, userId, now)
+	if err != nil {
+		return nil, err
+	}
+	cfg.forYou.put(userId, chirps, now)
+	return chirps, nil
+}
+
+// buildForYouFeed mixes recent chirps from the authors the reader follows
+// with chirps the people they follow have liked or replied to. Readers who
+// follow nobody get the most popular recent chirps instead.
+func (cfg *apiConfig) buildForYouFeed(ctx context.Context, userId int, now time.Time) ([]database.Chirp, error) {
+	following, err := cfg.store(ctx).GetFollowing(userId)
+	if err != nil {
+		return nil, err
+	}
+	followed := make(map[int]bool, len(following))
+	candidates := make(map[int]database.Chirp)
+	engagements := make(map[int]int) // chirp id -> likes and replies from followed users
+	for _, followeeId := range following {
+		followed[followeeId] = true
+		chirps, err := cfg.store(ctx).GetChirpsFromId(followeeId, "asc")
+		if err != nil {
+			return nil, err
+		}
+		for _, chirp := range chirps {
+			if chirp.ParentId != nil {
+				engagements[*chirp.ParentId]++
+				continue
+			}
+			candidates[chirp.Id] = chirp
+		}
+		liked, err := cfg.store(ctx).GetLikedChirps(followeeId)
+		if err != nil {
+			return nil, err
+		}
+		for _, chirp := range liked {
+			engagements[chirp.Id]++
+			if chirp.ParentId == nil {
+				candidates[chirp.Id] = chirp
+			}
+		}
+	}
+	for chirpId := range engagements {
+		if _, found := candidates[chirpId]; found {
+			continue
+		}
+		chirp, found, err := cfg.store(ctx).GetChirp(chirpId)
+		if err != nil {
+			return nil, err
+		}
+		if found && chirp.ParentId == nil {
+			candidates[chirp.Id] = chirp
+		}
+	}
+	if len(candidates) == 0 {
+		chirps, err := cfg.store(ctx).GetChirps("asc")
+		if err != nil {
+			return nil, err
+		}
+		for _, chirp := range chirps {
+			if chirp.ParentId == nil {
+				candidates[chirp.Id] = chirp
+			}
+		}
+	}
+
+	since := now.Add(-forYouWindow)
+	items := make([]feed.Item, 0, len(candidates))
+	for _, chirp := range candidates {
+		if chirp.AuthorId == userId || chirp.CreatedAt.Before(since) {
+			continue
+		}
+		item := feedItem(chirp)
+		item.Followed = followed[chirp.AuthorId]
+		item.NetworkEngagements = engagements[chirp.Id]
+		items = append(items, item)
+	}
+	ranker, _ := feed.Get(feed.ForYou)
+	return rankChirps(ranker, items, candidates, now), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// honeypot serves paths that no legitimate client of ours ever requests,
+// such as /wp-login.php or /.env. Clients probing them are logged, slowed
+// down, and banned once they have probed often enough within the window.
+type honeypot struct {
+	paths        []string
+	tarpit       time.Duration
+	window       time.Duration
+	banThreshold int // 0 disables auto-banning
+	banDuration  time.Duration
+	bans         *ipBanList
+
+	mux    sync.Mutex
+	probes map[string][]time.Time
+}
+
+// honeypotPrune is how often the probes that have left the window are
+// forgotten, so scanners rotating through addresses cannot grow the map
+// without bound.
+const honeypotPrune = time.Minute
+
+var defaultHoneypotPaths = []string{
+	"/wp-login.php",
+	"/wp-admin",
+	"/xmlrpc.php",
+	"/.env",
+	"/.git/config",
+	"/phpmyadmin",
+}
+
+func (h *honeypot) handler(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	score := h.record(ip, time.Now())
+	log.Printf("Honeypot hit from %s (score %d): %s %s", ip, score, r.Method, r.URL.Path)
+	if h.banThreshold > 0 && score >= h.banThreshold {
+		h.bans.Ban(ip, h.banDuration)
+		log.Printf("Banned %s for probing honeypot routes", ip)
+	}
+
+	select {
+	case <-time.After(h.tarpit):
+	case <-r.Context().Done():
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// record notes a probe from ip and returns how many probes it has made within the window.
+func (h *honeypot) record(ip string, now time.Time) int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	recent := h.probes[ip][:0]
+	for _, t := range h.probes[ip] {
+		if now.Sub(t) < h.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	h.probes[ip] = recent
+	return len(recent)
+}
+
+// prune forgets the probes made before the window, and the addresses left
+// with none.
+func (h *honeypot) prune(now time.Time) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for ip, probes := range h.probes {
+		if len(probes) == 0 || now.Sub(probes[len(probes)-1]) >= h.window {
+			delete(h.probes, ip)
+		}
+	}
+}
+
+// pruneWorker prunes the probes every honeypotPrune until ctx is done.
+func (h *honeypot) pruneWorker(ctx context.Context) error {
+	ticker := time.NewTicker(honeypotPrune)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			h.prune(now)
+		}
+	}
+}
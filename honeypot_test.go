@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoneypot(t *testing.T) {
+	h := &honeypot{window: time.Minute, probes: make(map[string][]time.Time)}
+	start := time.Now()
+	runHoneypotRecordTest(t, h, "1.2.3.4", start, 1)
+	runHoneypotRecordTest(t, h, "1.2.3.4", start.Add(10*time.Second), 2)
+	runHoneypotRecordTest(t, h, "5.6.7.8", start.Add(10*time.Second), 1)
+	runHoneypotRecordTest(t, h, "1.2.3.4", start.Add(65*time.Second), 2)
+	runHoneypotRecordTest(t, h, "1.2.3.4", start.Add(5*time.Minute), 1)
+
+	t.Logf("Starting test for honeypot.prune with: one address probed in the window, and expecting: only it kept")
+	h.prune(start.Add(5*time.Minute + 30*time.Second))
+	if _, ok := h.probes["1.2.3.4"]; !ok || len(h.probes) != 1 {
+		t.Errorf("Expecting: only 1.2.3.4 kept, but got: %v", h.probes)
+	}
+
+	bans := newIPBanList()
+	bans.Ban("1.2.3.4", time.Hour)
+	runIsBannedTest(t, bans, "1.2.3.4", true)
+	runIsBannedTest(t, bans, "9.9.9.9", false)
+	bans.Ban("9.9.9.9", 0)
+	runIsBannedTest(t, bans, "9.9.9.9", true)
+}
+
+func runHoneypotRecordTest(t *testing.T, h *honeypot, ip string, at time.Time, expecting int) {
+	t.Logf("Starting test for honeypot.record with: \"%s\", and expecting: %d", ip, expecting)
+	got := h.record(ip, at)
+	if got != expecting {
+		t.Errorf("Expecting: %d, but got: %d", expecting, got)
+	}
+}
+
+func runIsBannedTest(t *testing.T, bans *ipBanList, ip string, expecting bool) {
+	t.Logf("Starting test for IsBanned with: \"%s\", and expecting: %t", ip, expecting)
+	got := bans.IsBanned(ip)
+	if got != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, got)
+	}
+}
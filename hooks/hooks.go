@@ -0,0 +1,114 @@
+// Package hooks lets operators compile in plugins that take part in the chirp
+// lifecycle, for example to enforce a link allowlist, without patching the
+// HTTP handlers.
+//
+// A plugin implements any of PreCreateHook, PostCreateHook, and PreDeleteHook
+// and is registered once at startup, typically from an init function:
+//
+//	func init() {
+//		hooks.Register(linkAllowlist{})
+//	}
+package hooks
+
+import (
+	"sync"
+)
+
+// ChirpDraft is a chirp that has not been stored yet. Id is zero for new
+// chirps and set when an existing chirp is being edited.
+type ChirpDraft struct {
+	Id       int
+	AuthorId int
+	Body     string
+}
+
+// Chirp is a chirp as it exists in storage.
+type Chirp struct {
+	Id       int
+	AuthorId int
+	Body     string
+}
+
+// Plugin is any value implementing at least one of the hook interfaces.
+type Plugin interface {
+	Name() string
+}
+
+// PreCreateHook runs before a chirp is created or edited. It may rewrite
+// draft.Body, or return an error to refuse the chirp; a *Rejection is
+// reported back to the client, anything else is treated as a server error.
+type PreCreateHook interface {
+	BeforeCreateChirp(draft *ChirpDraft) error
+}
+
+// PostCreateHook runs after a chirp has been stored.
+type PostCreateHook interface {
+	AfterCreateChirp(chirp Chirp)
+}
+
+// PreDeleteHook runs before a chirp is deleted and may veto the deletion
+// the same way PreCreateHook vetoes a creation.
+type PreDeleteHook interface {
+	BeforeDeleteChirp(chirp Chirp, requesterId int) error
+}
+
+// Rejection is returned by a hook to refuse an action with a reason the
+// client is allowed to see.
+type Rejection struct {
+	Plugin string
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return r.Plugin + ": " + r.Reason
+}
+
+var (
+	mux     sync.RWMutex
+	plugins []Plugin
+)
+
+func Register(p Plugin) {
+	mux.Lock()
+	defer mux.Unlock()
+	plugins = append(plugins, p)
+}
+
+func registered() []Plugin {
+	mux.RLock()
+	defer mux.RUnlock()
+	return plugins
+}
+
+// PreCreate runs every PreCreateHook in registration order, stopping at the first error.
+func PreCreate(draft *ChirpDraft) error {
+	for _, p := range registered() {
+		if hook, ok := p.(PreCreateHook); ok {
+			if err := hook.BeforeCreateChirp(draft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// PostCreate runs every PostCreateHook in registration order.
+func PostCreate(chirp Chirp) {
+	for _, p := range registered() {
+		if hook, ok := p.(PostCreateHook); ok {
+			hook.AfterCreateChirp(chirp)
+		}
+	}
+}
+
+// PreDelete runs every PreDeleteHook in registration order, stopping at the first error.
+func PreDelete(chirp Chirp, requesterId int) error {
+	for _, p := range registered() {
+		if hook, ok := p.(PreDeleteHook); ok {
+			if err := hook.BeforeDeleteChirp(chirp, requesterId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package hooks
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type noLinks struct{}
+
+func (noLinks) Name() string { return "no-links" }
+
+func (noLinks) BeforeCreateChirp(draft *ChirpDraft) error {
+	if strings.Contains(draft.Body, "http") {
+		return &Rejection{Plugin: "no-links", Reason: "links are not allowed"}
+	}
+	draft.Body = strings.TrimSpace(draft.Body)
+	return nil
+}
+
+type counter struct{ created int }
+
+func (c *counter) Name() string { return "counter" }
+
+func (c *counter) AfterCreateChirp(chirp Chirp) { c.created++ }
+
+func Test(t *testing.T) {
+	c := &counter{}
+	Register(noLinks{})
+	Register(c)
+
+	runPreCreateTest(t, "  hello  ", "hello", false)
+	runPreCreateTest(t, "see http://example.com", "see http://example.com", true)
+
+	PostCreate(Chirp{Id: 1})
+	t.Logf("Starting test for PostCreate, and expecting: 1")
+	if c.created != 1 {
+		t.Errorf("Expecting: 1, but got: %d", c.created)
+	}
+
+	t.Logf("Starting test for PreDelete without delete hooks, and expecting: <nil>")
+	if err := PreDelete(Chirp{Id: 1}, 1); err != nil {
+		t.Errorf("Expecting: <nil>, but got: %v", err)
+	}
+}
+
+func runPreCreateTest(t *testing.T, body, expecting string, rejected bool) {
+	t.Logf("Starting test for PreCreate with: \"%s\", and expecting: \"%s\", rejected: %t", body, expecting, rejected)
+	draft := &ChirpDraft{Body: body}
+	err := PreCreate(draft)
+	var rejection *Rejection
+	if errors.As(err, &rejection) != rejected {
+		t.Errorf("Expecting rejected: %t, but got: %v", rejected, err)
+	}
+	if draft.Body != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, draft.Body)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// Clients that may retry a POST send an Idempotency-Key header with a value
+// of their choosing. The first request with a key is handled as usual and,
+// if it succeeds, its response is kept for idempotencyWindow; retries with
+// the same key get that response again instead of being handled twice.
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyWindow         = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+)
+
+// idempotencyScope names the key space a request's idempotency key belongs
+// to, so that keys chosen by different clients never collide. It returns
+// false for requests handled without idempotency, such as unauthenticated
+// ones the handler is about to refuse.
+type idempotencyScope func(r *http.Request) (string, bool)
+
+// chirpIdempotencyScope gives each user keys of their own.
+func (cfg *apiConfig) chirpIdempotencyScope(r *http.Request) (string, bool) {
+	userId, ok := cfg.requestUserId(r)
+	return "chirps:" + strconv.Itoa(userId), ok
+}
+
+// polkaIdempotencyScope gives Polka its own keys.
+func polkaIdempotencyScope(r *http.Request) (string, bool) {
+	return "polka", true
+}
+
+// idempotencyRecorder passes the response through while keeping a copy.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// middlewareIdempotency answers retries of a request carrying an
+// Idempotency-Key with the response to the first one. Only successful
+// responses are kept: after an error the key is released, so the request
+// can be retried as is. A key reused for a different request is refused.
+func (cfg *apiConfig) middlewareIdempotency(scope idempotencyScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				respondValidationError(w, fmt.Sprintf("%s must be at most %d characters long", idempotencyKeyHeader, maxIdempotencyKeyLength))
+				return
+			}
+			prefix, ok := scope(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				respondParamsDecodingError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := sha256.New()
+			hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+			hash.Write(body)
+
+			claim := database.IdempotencyRecord{Key: prefix + ":" + key, RequestHash: hex.EncodeToString(hash.Sum(nil)), CreatedAt: time.Now()}
+			record, claimed, err := cfg.store(r.Context()).ClaimIdempotencyKey(claim, claim.CreatedAt.Add(-idempotencyWindow))
+			if err != nil {
+				respondDataWriteError(w, err)
+				return
+			}
+			if !claimed {
+				replayIdempotentResponse(w, claim, record)
+				return
+			}
+
+			saved := false
+			defer func() {
+				if !saved {
+					if err := cfg.db.ReleaseIdempotencyKey(claim.Key); err != nil {
+						logRequestf(requestId(r.Context()), "Error releasing idempotency key: %s", err)
+					}
+				}
+			}()
+			rec := &idempotencyRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status < 200 || rec.status > 299 {
+				return
+			}
+			claim.Status = rec.status
+			claim.ContentType = rec.Header().Get("Content-Type")
+			claim.Body = rec.body.Bytes()
+			// The response was sent, so it is saved even if the request's
+			// context has since been cancelled.
+			if err := cfg.db.SaveIdempotentResponse(claim); err != nil {
+				logRequestf(requestId(r.Context()), "Error saving idempotent response: %s", err)
+				return
+			}
+			saved = true
+		})
+	}
+}
+
+// replayIdempotentResponse answers a retry of the request that claimed
+// record's key.
+func replayIdempotentResponse(w http.ResponseWriter, retry, record database.IdempotencyRecord) {
+	if record.RequestHash != retry.RequestHash {
+		respondRejectedError(w, "This "+idempotencyKeyHeader+" was used for a different request.")
+		return
+	}
+	if record.Status == 0 {
+		respondConflictError(w, "A request with this "+idempotencyKeyHeader+" is still being handled.")
+		return
+	}
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(record.Status)
+	w.Write(record.Body)
+}
+
+// purgeIdempotencyKeys forgets the keys past idempotencyWindow every
+// interval until ctx is done.
+func (cfg *apiConfig) purgeIdempotencyKeys(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			purged, err := cfg.store(ctx).PurgeIdempotencyKeys(now.Add(-idempotencyWindow))
+			if err != nil {
+				return fmt.Errorf("purging idempotency keys: %w", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d idempotency keys", purged)
+			}
+		}
+	}
+}
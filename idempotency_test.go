@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestMiddlewareIdempotency(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db}
+	handled := 0
+	status := 201
+	handler := cfg.middlewareIdempotency(polkaIdempotencyScope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	post := func(key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	cases := []struct {
+		name      string
+		key       string
+		body      string
+		status    int
+		expecting int
+		handled   int
+		replayed  bool
+	}{
+		{"no key", "", `{"n":1}`, 201, 201, 1, false},
+		{"no key again", "", `{"n":1}`, 201, 201, 2, false},
+		{"a new key", "k1", `{"n":1}`, 201, 201, 3, false},
+		{"the key again", "k1", `{"n":1}`, 201, 201, 3, true},
+		{"the key with another body", "k1", `{"n":2}`, 201, 422, 3, false},
+		{"a key whose request fails", "k2", `{"n":1}`, 500, 500, 4, false},
+		{"the failed key again", "k2", `{"n":1}`, 201, 201, 5, false},
+		{"a key too long", strings.Repeat("k", maxIdempotencyKeyLength+1), `{"n":1}`, 201, 400, 5, false},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for middlewareIdempotency with: %s, and expecting: %d", c.name, c.expecting)
+		status = c.status
+		w := post(c.key, c.body)
+		if w.Code != c.expecting || handled != c.handled {
+			t.Errorf("Expecting: %d after %d handled, but got: %d after %d handled", c.expecting, c.handled, w.Code, handled)
+		}
+		if replayed := w.Header().Get(idempotencyReplayedHeader) == "true"; replayed != c.replayed {
+			t.Errorf("Expecting: replayed %t, but got: %t", c.replayed, replayed)
+		}
+		if c.replayed && (w.Body.String() != c.body || w.Header().Get("Content-Type") != "application/json") {
+			t.Errorf("Expecting: %s, but got: %s (%s)", c.body, w.Body.String(), w.Header().Get("Content-Type"))
+		}
+	}
+
+	t.Logf("Starting test for middlewareIdempotency with: a key whose request is still being handled, and expecting: 409")
+	inFlight := cfg.middlewareIdempotency(polkaIdempotencyScope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retry := post("k3", `{"n":1}`); retry.Code != 409 {
+			t.Errorf("Expecting: 409, but got: %d", retry.Code)
+		}
+		w.WriteHeader(200)
+	}))
+	r := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(`{"n":1}`))
+	r.Header.Set(idempotencyKeyHeader, "k3")
+	inFlight.ServeHTTP(httptest.NewRecorder(), r)
+}
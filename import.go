@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// jobImport jobs read an export saved in the blob store into the database.
+const jobImport = "import"
+
+type importJob struct {
+	BlobKey string                `json:"blob_key"`
+	Policy  database.ImportPolicy `json:"policy"`
+}
+
+// postImportHandler saves an export from the request body and queues it to
+// be read into the database, resolving collisions with the policy query
+// parameter (skip by default). It answers 202 with the job, whose result
+// once done says how many records were imported and skipped.
+func (cfg *apiConfig) postImportHandler(w http.ResponseWriter, r *http.Request) {
+	policy := database.ImportSkip
+	if value := r.URL.Query().Get("policy"); value != "" {
+		var err error
+		policy, err = database.ParseImportPolicy(value)
+		if err != nil {
+			respondValidationError(w, err.Error())
+			return
+		}
+	}
+
+	key, err := newBlobKey()
+	if err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	key = "import-" + key
+	if _, err := cfg.blobs.Put(key, r.Body); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	job, err := cfg.enqueueJob(r.Context(), jobImport, importJob{BlobKey: key, Policy: policy}, time.Time{})
+	if err != nil {
+		cfg.blobs.Delete(key)
+		respondDataWriteError(w, err)
+		return
+	}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(202)
+	w.Write(data)
+}
+
+// importJob stores the whole export in one batch, so a failed import
+// leaves nothing behind. It is only tried once, since a bad export fails
+// the same way every time; the export is kept until the import succeeds,
+// so a dead import can be requeued once it is fixed.
+func (cfg *apiConfig) importJob(ctx context.Context, payload json.RawMessage) (any, error) {
+	params := importJob{}
+	if err := json.Unmarshal(payload, &params); err != nil {
+		return nil, err
+	}
+	export, err := cfg.blobs.Open(params.BlobKey)
+	if err != nil {
+		return nil, err
+	}
+	defer export.Close()
+	var importer *database.Importer
+	err = cfg.store(ctx).WriteBatch(func(db database.Storage) error {
+		importer = database.NewImporter(db, params.Policy)
+		return database.ReadRecords(export, importer.Import)
+	})
+	if err != nil && importer != nil {
+		return nil, fmt.Errorf("%w (after %v, none of it kept)", err, importer.Stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg.blobs.Delete(params.BlobKey)
+	return importer.Stats, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"unicode/utf8"
+)
+
+// validateChirpBody checks a chirp body against the instance limits. Every
+// path that stores a body goes through it, so the returned reason, when not
+// empty, explains what to fix.
+func (cfg *apiConfig) validateChirpBody(body string) string {
+	if length := utf8.RuneCountInString(body); length > cfg.maxChirpLength {
+		return fmt.Sprintf("chirp is %d characters long, the limit is %d", length, cfg.maxChirpLength)
+	}
+	return ""
+}
+
+// getInstanceHandler describes this instance's limits so clients can check
+// chirps before sending them.
+func (cfg *apiConfig) getInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	type returnVal struct {
+		MaxChirpLength      int      `json:"max_chirp_length"`
+		MaxMediaAttachments int      `json:"max_media_attachments"`
+		MaxMediaBytes       int64    `json:"max_media_bytes"`
+		MediaTypes          []string `json:"media_types"`
+		RequireAltText      bool     `json:"require_alt_text"`
+	}
+	resp := returnVal{
+		MaxChirpLength:      cfg.maxChirpLength,
+		MaxMediaAttachments: maxAttachments,
+		MaxMediaBytes:       cfg.mediaMaxBytes,
+		MediaTypes:          make([]string, 0, len(mediaTypes)),
+		RequireAltText:      cfg.requireAltText,
+	}
+	for mediaType := range mediaTypes {
+		resp.MediaTypes = append(resp.MediaTypes, mediaType)
+	}
+	slices.Sort(resp.MediaTypes)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInstance(t *testing.T) {
+	cfg := &apiConfig{maxChirpLength: 10}
+	runValidateChirpBodyTest(t, cfg, "short", true)
+	runValidateChirpBodyTest(t, cfg, strings.Repeat("a", 10), true)
+	runValidateChirpBodyTest(t, cfg, strings.Repeat("a", 11), false)
+	runValidateChirpBodyTest(t, cfg, strings.Repeat("é", 10), true)
+}
+
+func runValidateChirpBodyTest(t *testing.T, cfg *apiConfig, body string, expecting bool) {
+	t.Logf("Starting test for validateChirpBody with: \"%s\", and expecting: %t", body, expecting)
+	got := cfg.validateChirpBody(body) == ""
+	if got != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, got)
+	}
+}
@@ -0,0 +1,99 @@
+// Package blobstore keeps the bytes of uploaded files, addressed by key.
+// Metadata about the files lives in the database.
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore is implemented by every place uploads can be kept.
+type BlobStore interface {
+	// Put stores everything read from r under key, replacing any blob
+	// already there, and returns the number of bytes written.
+	Put(key string, r io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// Keys are used as file names, so they are restricted to characters that
+// cannot escape the directory.
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Dir stores each blob as a file in a directory.
+type Dir struct {
+	path string
+}
+
+var _ BlobStore = (*Dir)(nil)
+
+// NewDir stores blobs under path, creating it if needed.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) file(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(d.path, key), nil
+}
+
+// Put writes to a temporary file first so that readers never see a
+// partially written blob.
+func (d *Dir) Put(key string, r io.Reader) (int64, error) {
+	path, err := d.file(key)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(d.path, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func (d *Dir) Open(key string) (io.ReadCloser, error) {
+	path, err := d.file(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (d *Dir) Delete(key string) error {
+	path, err := d.file(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
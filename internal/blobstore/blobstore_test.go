@@ -0,0 +1,53 @@
+package blobstore
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	dir, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runPutOpenTest(t, dir, "abc123", "hello")
+	runPutOpenTest(t, dir, "abc123", "replaced")
+	runKeyTest(t, dir, "../escape", ErrInvalidKey)
+	runKeyTest(t, dir, "missing", ErrNotFound)
+
+	t.Logf("Starting test for Delete with: \"%s\", and expecting: %v", "abc123", ErrNotFound)
+	if err := dir.Delete("abc123"); err != nil {
+		t.Error(err)
+	}
+	if _, err := dir.Open("abc123"); err != ErrNotFound {
+		t.Errorf("Expecting: %v, but got: %v", ErrNotFound, err)
+	}
+}
+
+func runPutOpenTest(t *testing.T, dir *Dir, key, content string) {
+	t.Logf("Starting test for Put and Open with: \"%s\", and expecting: \"%s\"", key, content)
+	n, err := dir.Put(key, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Errorf("Expecting: %d, but got: %d", len(content), n)
+	}
+	blob, err := dir.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	got, _ := io.ReadAll(blob)
+	if string(got) != content {
+		t.Errorf("Expecting: %s, but got: %s", content, got)
+	}
+}
+
+func runKeyTest(t *testing.T, dir *Dir, key string, expecting error) {
+	t.Logf("Starting test for Open with: \"%s\", and expecting: %v", key, expecting)
+	if _, err := dir.Open(key); err != expecting {
+		t.Errorf("Expecting: %v, but got: %v", expecting, err)
+	}
+}
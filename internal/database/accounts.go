@@ -0,0 +1,245 @@
+package database
+
+import (
+	"time"
+)
+
+// RequestUserDeletion marks the user's account for deletion as of at, ends
+// their sessions and revokes their API keys. The account stays until PurgeUsers runs past the
+// grace period, unless CancelUserDeletion keeps it.
+func (db *DB) RequestUserDeletion(id int, at time.Time) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return User{}, notFound(ErrUserDoesNotExist, id)
+	}
+	requestedAt := at.UTC()
+	user.DeletionRequestedAt = &requestedAt
+	dbStruct.Users[id] = user
+	for sessionId, session := range dbStruct.Sessions {
+		if session.UserId == id {
+			delete(dbStruct.Sessions, sessionId)
+		}
+	}
+	for keyId, key := range dbStruct.APIKeys {
+		if key.UserId == id {
+			delete(dbStruct.APIKeys, keyId)
+		}
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *DB) CancelUserDeletion(id int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return notFound(ErrUserDoesNotExist, id)
+	}
+	user.DeletionRequestedAt = nil
+	dbStruct.Users[id] = user
+	return db.writeDB(dbStruct)
+}
+
+// DeleteUser removes a user and everything that is theirs alone. Their
+// chirps are deleted as if they had deleted each one, so replies to them
+// keep a tombstone to hang off until PurgeChirps removes them for good.
+func (db *DB) DeleteUser(id int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Users[id]; !found {
+		return notFound(ErrUserDoesNotExist, id)
+	}
+	dbStruct.deleteUser(id, time.Now().UTC())
+	return db.writeDB(dbStruct)
+}
+
+// PurgeUsers deletes the users who asked for it before before, and
+// returns how many there were.
+func (db *DB) PurgeUsers(before time.Time) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	purged := 0
+	for id, user := range dbStruct.Users {
+		if user.DeletionRequestedAt != nil && user.DeletionRequestedAt.Before(before) {
+			dbStruct.deleteUser(id, now)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (dbStruct *DBStructure) deleteUser(id int, at time.Time) {
+	delete(dbStruct.Users, id)
+	var authored []int
+	for chirpId, chirp := range dbStruct.Chirps {
+		if chirp.AuthorId == id && chirp.DeletedAt == nil {
+			authored = append(authored, chirpId)
+		}
+	}
+	// Read each chirp afresh, as deleting one may have changed its reply
+	// count.
+	for _, chirpId := range authored {
+		chirp := dbStruct.Chirps[chirpId]
+		chirp.DeletedAt = &at
+		dbStruct.Chirps[chirpId] = chirp
+		if chirp.ParentId != nil {
+			if parent, found := dbStruct.Chirps[*chirp.ParentId]; found {
+				parent.ReplyCount--
+				dbStruct.Chirps[parent.Id] = parent
+			}
+		}
+		dbStruct.countRechirp(chirp, -1)
+	}
+	for chirpId := range dbStruct.Likes[id] {
+		if chirp, found := dbStruct.Chirps[chirpId]; found {
+			chirp.LikeCount--
+			dbStruct.Chirps[chirpId] = chirp
+		}
+	}
+	delete(dbStruct.Likes, id)
+	delete(dbStruct.Bookmarks, id)
+	for chirpId, votes := range dbStruct.PollVotes {
+		if option, voted := votes[id]; voted {
+			delete(votes, id)
+			poll := dbStruct.Polls[chirpId]
+			poll.Options = append([]PollOption(nil), poll.Options...)
+			poll.Options[option].Votes--
+			poll.VotesCount--
+			dbStruct.Polls[chirpId] = poll
+		}
+	}
+	delete(dbStruct.Follows, id)
+	for _, followees := range dbStruct.Follows {
+		delete(followees, id)
+	}
+	delete(dbStruct.FeedMarkers, id)
+	delete(dbStruct.SecurityEvents, id)
+	for sessionId, session := range dbStruct.Sessions {
+		if session.UserId == id {
+			delete(dbStruct.Sessions, sessionId)
+		}
+	}
+	for keyId, key := range dbStruct.APIKeys {
+		if key.UserId == id {
+			delete(dbStruct.APIKeys, keyId)
+		}
+	}
+	for hash, token := range dbStruct.VerificationTokens {
+		if token.UserId == id {
+			delete(dbStruct.VerificationTokens, hash)
+		}
+	}
+	for code, auth := range dbStruct.DeviceAuthorizations {
+		if auth.UserId == id {
+			delete(dbStruct.DeviceAuthorizations, code)
+		}
+	}
+	for scheduledId, scheduled := range dbStruct.ScheduledChirps {
+		if scheduled.AuthorId == id {
+			delete(dbStruct.ScheduledChirps, scheduledId)
+		}
+	}
+	for draftId, draft := range dbStruct.Drafts {
+		if draft.AuthorId == id {
+			delete(dbStruct.Drafts, draftId)
+		}
+	}
+}
+
+func (db *SQLDB) RequestUserDeletion(id int, at time.Time) (User, error) {
+	result, err := db.exec(`UPDATE users SET deletion_requested_at = ? WHERE id = ?`, at.UTC(), id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := requireRow(result, notFound(ErrUserDoesNotExist, id)); err != nil {
+		return User{}, err
+	}
+	for _, table := range []string{"sessions", "api_keys"} {
+		if _, err := db.exec(`DELETE FROM `+table+` WHERE user_id = ?`, id); err != nil {
+			return User{}, err
+		}
+	}
+	return db.GetUserById(id)
+}
+
+func (db *SQLDB) CancelUserDeletion(id int) error {
+	result, err := db.exec(`UPDATE users SET deletion_requested_at = NULL WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrUserDoesNotExist, id))
+}
+
+func (db *SQLDB) DeleteUser(id int) error {
+	result, err := db.exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(result, notFound(ErrUserDoesNotExist, id)); err != nil {
+		return err
+	}
+	// Like and reply counts are counted when read, so they follow along.
+	if _, err := db.exec(`UPDATE chirps SET deleted_at = ? WHERE author_id = ? AND deleted_at IS NULL`, time.Now().UTC(), id); err != nil {
+		return err
+	}
+	for _, table := range []string{"chirp_tags", "scheduled_chirps", "drafts"} {
+		if _, err := db.exec(`DELETE FROM `+table+` WHERE author_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	if _, err := db.exec(`DELETE FROM follows WHERE follower_id = ? OR followee_id = ?`, id, id); err != nil {
+		return err
+	}
+	for _, table := range []string{"likes", "bookmarks", "poll_votes", "feed_markers", "sessions", "api_keys", "verification_tokens", "device_authorizations", "security_events"} {
+		if _, err := db.exec(`DELETE FROM `+table+` WHERE user_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLDB) PurgeUsers(before time.Time) (int, error) {
+	rows, err := db.query(`SELECT id FROM users WHERE deletion_requested_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := db.DeleteUser(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// GetUsers returns every user, in the order they signed up.
+func (db *DB) GetUsers() ([]User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(dbStruct.Users))
+	for _, user := range dbStruct.Users {
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b User) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return users, nil
+}
+
+func (db *DB) SetAdmin(id int, isAdmin bool) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return User{}, notFound(ErrUserDoesNotExist, id)
+	}
+	user.IsAdmin = isAdmin
+	dbStruct.Users[id] = user
+	if err := db.writeDB(dbStruct); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// LiftSuspensions revokes every suspension of the user still active at the
+// given time and returns how many there were.
+func (db *DB) LiftSuspensions(userId int, at time.Time) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	if _, found := dbStruct.Users[userId]; !found {
+		return 0, notFound(ErrUserDoesNotExist, userId)
+	}
+	revokedAt := at.UTC()
+	lifted := 0
+	for id, action := range dbStruct.ModerationActions {
+		if action.UserId != userId || !action.Active(at) {
+			continue
+		}
+		action.RevokedAt = &revokedAt
+		dbStruct.ModerationActions[id] = action
+		lifted++
+	}
+	if lifted == 0 {
+		return 0, nil
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return 0, err
+	}
+	return lifted, nil
+}
+
+func (db *SQLDB) GetUsers() ([]User, error) {
+	rows, err := db.query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (db *SQLDB) SetAdmin(id int, isAdmin bool) (User, error) {
+	result, err := db.exec(`UPDATE users SET is_admin = ? WHERE id = ?`, isAdmin, id)
+	if err != nil {
+		return User{}, err
+	}
+	if err := requireRow(result, notFound(ErrUserDoesNotExist, id)); err != nil {
+		return User{}, err
+	}
+	return db.GetUserById(id)
+}
+
+func (db *SQLDB) LiftSuspensions(userId int, at time.Time) (int, error) {
+	if _, err := db.GetUserById(userId); err != nil {
+		return 0, err
+	}
+	result, err := db.exec(`UPDATE moderation_actions SET revoked_at = ?
+		WHERE user_id = ? AND kind = ? AND revoked_at IS NULL AND (until IS NULL OR until > ?)`,
+		at.UTC(), userId, ActionSuspend, at.UTC())
+	if err != nil {
+		return 0, err
+	}
+	lifted, err := result.RowsAffected()
+	return int(lifted), err
+}
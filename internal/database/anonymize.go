@@ -0,0 +1,319 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AnonymizeStats counts what Anonymize replaced.
+type AnonymizeStats struct {
+	Users int `json:"users"`
+	// PrivateTexts counts the drafts, scheduled chirps, report reasons, and
+	// appeal messages rewritten.
+	PrivateTexts   int `json:"private_texts"`
+	IPs            int `json:"ips"` // distinct addresses
+	LoginThrottles int `json:"login_throttles"`
+	// Secrets counts the API key and webhook secrets regenerated.
+	Secrets         int `json:"secrets"`
+	Jobs            int `json:"jobs"`
+	IdempotencyKeys int `json:"idempotency_keys"`
+}
+
+// AnonymousEmail is the address Anonymize gives the user with id.
+func AnonymousEmail(id int) string {
+	return fmt.Sprintf("user%d@anonymized.invalid", id)
+}
+
+// anonymizer picks the synthetic values of one Anonymize, so a value seen
+// twice is replaced the same way both times.
+type anonymizer struct {
+	emails  map[string]string // lower-cased email -> synthetic email
+	ips     map[string]string
+	unknown int
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{emails: make(map[string]string), ips: make(map[string]string)}
+}
+
+// user replaces the email of the user with id, remembering it for the
+// login throttles kept by email.
+func (a *anonymizer) user(id int, email string) string {
+	anonymous := AnonymousEmail(id)
+	a.emails[strings.ToLower(strings.TrimSpace(email))] = anonymous
+	return anonymous
+}
+
+// email replaces an address no user may have.
+func (a *anonymizer) email(email string) string {
+	if anonymous, found := a.emails[email]; found {
+		return anonymous
+	}
+	a.unknown++
+	anonymous := fmt.Sprintf("unknown%d@anonymized.invalid", a.unknown)
+	a.emails[email] = anonymous
+	return anonymous
+}
+
+// ip replaces addr with a private IPv4 address, or a unique local IPv6
+// address for IPv6 ones.
+func (a *anonymizer) ip(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	if anonymous, found := a.ips[addr]; found {
+		return anonymous
+	}
+	n := len(a.ips) + 1
+	var anonymous netip.Addr
+	if parsed, err := netip.ParseAddr(addr); err == nil && parsed.Is6() && !parsed.Is4In6() {
+		anonymous = netip.AddrFrom16([16]byte{0: 0xfd, 12: byte(n >> 24), 13: byte(n >> 16), 14: byte(n >> 8), 15: byte(n)})
+	} else {
+		anonymous = netip.AddrFrom4([4]byte{10, byte(n >> 16), byte(n >> 8), byte(n)})
+	}
+	a.ips[addr] = anonymous.String()
+	return a.ips[addr]
+}
+
+// throttleSubject replaces the subject of a login throttle of kind.
+func (a *anonymizer) throttleSubject(kind, subject string) string {
+	if kind == ThrottleIP {
+		return a.ip(subject)
+	}
+	return a.email(subject)
+}
+
+// anonymousText replaces every letter of s with x and every digit with 0,
+// keeping its length, case, spacing, and punctuation.
+func anonymousText(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsUpper(r):
+			return 'X'
+		case unicode.IsLetter(r):
+			return 'x'
+		case unicode.IsDigit(r):
+			return '0'
+		}
+		return r
+	}, s)
+}
+
+// emptyPayload replaces the payloads of jobs, which may hold anything the
+// server queued.
+var emptyPayload = json.RawMessage(`{}`)
+
+// Anonymize replaces the personal data in the database with synthetic
+// values, keeping every record so the data has the same shape and volume:
+// emails become AnonymousEmail, every password becomes password, private
+// text is blanked letter by letter, addresses are replaced consistently,
+// and secrets are regenerated. TOTP is turned off, as the secrets are
+// dropped, and stored idempotent responses and job payloads are emptied.
+func (db *DB) Anonymize(password string) (AnonymizeStats, error) {
+	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return AnonymizeStats{}, err
+	}
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return AnonymizeStats{}, err
+	}
+	a := newAnonymizer()
+	stats := AnonymizeStats{}
+	for id, user := range dbStruct.Users {
+		user.Email = a.user(id, user.Email)
+		user.Password = hashPass
+		user.TOTPSecret, user.TOTPEnabled, user.RecoveryCodes = nil, false, nil
+		dbStruct.Users[id] = user
+		stats.Users++
+	}
+	for id, draft := range dbStruct.Drafts {
+		draft.Body = anonymousText(draft.Body)
+		dbStruct.Drafts[id] = draft
+		stats.PrivateTexts++
+	}
+	for id, scheduled := range dbStruct.ScheduledChirps {
+		scheduled.Body = anonymousText(scheduled.Body)
+		dbStruct.ScheduledChirps[id] = scheduled
+		stats.PrivateTexts++
+	}
+	for id, report := range dbStruct.Reports {
+		report.Reason = anonymousText(report.Reason)
+		dbStruct.Reports[id] = report
+		stats.PrivateTexts++
+	}
+	for id, appeal := range dbStruct.Appeals {
+		appeal.Message = anonymousText(appeal.Message)
+		dbStruct.Appeals[id] = appeal
+		stats.PrivateTexts++
+	}
+	for _, events := range dbStruct.SecurityEvents {
+		for i := range events {
+			events[i].IP = a.ip(events[i].IP)
+		}
+	}
+	throttles := make(map[string]LoginThrottle, len(dbStruct.LoginThrottles))
+	for _, throttle := range dbStruct.LoginThrottles {
+		throttle.Subject = a.throttleSubject(throttle.Kind, throttle.Subject)
+		throttles[throttleKey(throttle.Kind, throttle.Subject)] = throttle
+		stats.LoginThrottles++
+	}
+	dbStruct.LoginThrottles = throttles
+	for id, key := range dbStruct.APIKeys {
+		if key.Secret, err = randomHex(32); err != nil {
+			return AnonymizeStats{}, err
+		}
+		dbStruct.APIKeys[id] = key
+		stats.Secrets++
+	}
+	for id, hook := range dbStruct.Webhooks {
+		if hook.Secret, err = randomHex(32); err != nil {
+			return AnonymizeStats{}, err
+		}
+		dbStruct.Webhooks[id] = hook
+		stats.Secrets++
+	}
+	for id, job := range dbStruct.Jobs {
+		job.Payload, job.Result, job.LastError = emptyPayload, nil, anonymousText(job.LastError)
+		dbStruct.Jobs[id] = job
+		stats.Jobs++
+	}
+	for key, record := range dbStruct.IdempotencyKeys {
+		record.Body = nil
+		dbStruct.IdempotencyKeys[key] = record
+		stats.IdempotencyKeys++
+	}
+	stats.IPs = len(a.ips)
+	if err := db.writeDB(dbStruct); err != nil {
+		return AnonymizeStats{}, err
+	}
+	return stats, nil
+}
+
+func (db *SQLDB) Anonymize(password string) (AnonymizeStats, error) {
+	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return AnonymizeStats{}, err
+	}
+	a := newAnonymizer()
+	stats := AnonymizeStats{}
+	err = db.WriteBatch(func(store Storage) error {
+		tx := store.(*SQLDB)
+		type userEmail struct {
+			id    int
+			email string
+		}
+		users, err := queryRows(tx, `SELECT id, email FROM users`, func(row scanner) (userEmail, error) {
+			user := userEmail{}
+			err := row.Scan(&user.id, &user.email)
+			return user, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			_, err := tx.exec(`UPDATE users SET email = ?, password = ?, totp_secret = NULL, totp_enabled = FALSE, recovery_codes = '' WHERE id = ?`,
+				a.user(user.id, user.email), hashPass, user.id)
+			if err != nil {
+				return err
+			}
+		}
+		stats.Users = len(users)
+
+		for _, text := range []struct{ table, column string }{
+			{"drafts", "body"}, {"scheduled_chirps", "body"}, {"reports", "reason"}, {"appeals", "message"},
+		} {
+			n, err := tx.rewriteColumn(text.table, "id", text.column, anonymousText)
+			if err != nil {
+				return err
+			}
+			stats.PrivateTexts += n
+		}
+		if _, err := tx.rewriteColumn("security_events", "id", "ip", a.ip); err != nil {
+			return err
+		}
+
+		throttles, err := queryRows(tx, `SELECT kind, subject FROM login_throttles`, func(row scanner) (LoginThrottle, error) {
+			throttle := LoginThrottle{}
+			err := row.Scan(&throttle.Kind, &throttle.Subject)
+			return throttle, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, throttle := range throttles {
+			_, err := tx.exec(`UPDATE login_throttles SET subject = ? WHERE kind = ? AND subject = ?`,
+				a.throttleSubject(throttle.Kind, throttle.Subject), throttle.Kind, throttle.Subject)
+			if err != nil {
+				return err
+			}
+		}
+		stats.LoginThrottles = len(throttles)
+
+		var secretErr error
+		newSecret := func(string) string {
+			secret, err := randomHex(32)
+			if err != nil {
+				secretErr = err
+			}
+			return secret
+		}
+		for _, table := range []string{"api_keys", "webhooks"} {
+			n, err := tx.rewriteColumn(table, "id", "secret", newSecret)
+			if err != nil {
+				return err
+			}
+			if secretErr != nil {
+				return secretErr
+			}
+			stats.Secrets += n
+		}
+		if stats.Jobs, err = tx.rewriteColumn("jobs", "id", "last_error", anonymousText); err != nil {
+			return err
+		}
+		if _, err := tx.exec(`UPDATE jobs SET payload = ?, result = NULL`, string(emptyPayload)); err != nil {
+			return err
+		}
+		result, err := tx.exec(`UPDATE idempotency_keys SET body = NULL`)
+		if err != nil {
+			return err
+		}
+		keys, err := result.RowsAffected()
+		stats.IdempotencyKeys = int(keys)
+		return err
+	})
+	if err != nil {
+		return AnonymizeStats{}, err
+	}
+	stats.IPs = len(a.ips)
+	return stats, nil
+}
+
+// rewriteColumn sets column of every row of table, found by its key column,
+// to rewrite of its value, and returns how many rows there were.
+func (db *SQLDB) rewriteColumn(table, key, column string, rewrite func(string) string) (int, error) {
+	type row struct {
+		key   any
+		value string
+	}
+	rows, err := queryRows(db, `SELECT `+key+`, `+column+` FROM `+table, func(s scanner) (row, error) {
+		r := row{}
+		err := s.Scan(&r.key, &r.value)
+		return r, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range rows {
+		_, err := db.exec(`UPDATE `+table+` SET `+column+` = ? WHERE `+key+` = ?`, rewrite(r.value), r.key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// APIKey lets a machine client sign requests on behalf of UserId. The secret
+// is only ever shown to the user when the key is created.
+type APIKey struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	UserId    int       `json:"user_id"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAPIKey(userId int, name string) (APIKey, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return APIKey{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return APIKey{
+		Id:        id,
+		Name:      name,
+		UserId:    userId,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (db *DB) CreateAPIKey(userId int, name string) (APIKey, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return APIKey{}, err
+	}
+	key, err := newAPIKey(userId, name)
+	if err != nil {
+		return APIKey{}, err
+	}
+	dbStruct.APIKeys[key.Id] = key
+	if err := db.writeDB(dbStruct); err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (db *DB) GetAPIKey(id string) (APIKey, bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return APIKey{}, false, err
+	}
+	key, found := dbStruct.APIKeys[id]
+	return key, found, nil
+}
+
+func (db *DB) GetAPIKeys(userId int) ([]APIKey, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]APIKey, 0)
+	for _, key := range dbStruct.APIKeys {
+		if key.UserId == userId {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (db *DB) DeleteAPIKey(id string, idOfRequestingUser int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	key, found := dbStruct.APIKeys[id]
+	if !found {
+		return notFound(ErrAPIKeyDoesNotExist, id)
+	}
+	if key.UserId != idOfRequestingUser {
+		return ErrAuthorization
+	}
+	delete(dbStruct.APIKeys, id)
+	return db.writeDB(dbStruct)
+}
+
+func (db *SQLDB) CreateAPIKey(userId int, name string) (APIKey, error) {
+	key, err := newAPIKey(userId, name)
+	if err != nil {
+		return APIKey{}, err
+	}
+	_, err = db.exec(`INSERT INTO api_keys (id, name, user_id, secret, created_at) VALUES (?, ?, ?, ?, ?)`,
+		key.Id, key.Name, key.UserId, key.Secret, key.CreatedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+func (db *SQLDB) GetAPIKey(id string) (APIKey, bool, error) {
+	key := APIKey{}
+	err := db.queryRow(`SELECT id, name, user_id, secret, created_at FROM api_keys WHERE id = ?`, id).
+		Scan(&key.Id, &key.Name, &key.UserId, &key.Secret, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, false, nil
+	}
+	if err != nil {
+		return APIKey{}, false, err
+	}
+	return key, true, nil
+}
+
+func (db *SQLDB) GetAPIKeys(userId int) ([]APIKey, error) {
+	rows, err := db.query(`SELECT id, name, user_id, secret, created_at FROM api_keys WHERE user_id = ? ORDER BY created_at`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		key := APIKey{}
+		if err := rows.Scan(&key.Id, &key.Name, &key.UserId, &key.Secret, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (db *SQLDB) DeleteAPIKey(id string, idOfRequestingUser int) error {
+	key, found, err := db.GetAPIKey(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(ErrAPIKeyDoesNotExist, id)
+	}
+	if key.UserId != idOfRequestingUser {
+		return ErrAuthorization
+	}
+	_, err = db.exec(`DELETE FROM api_keys WHERE id = ?`, id)
+	return err
+}
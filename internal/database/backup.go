@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrInvalidBackup is returned by Restore for input that is not a backup
+// of this kind of store.
+var ErrInvalidBackup = errors.New("Not a valid backup of this database.")
+
+// Backup writes a copy of the whole database to w, in the form Restore
+// reads back.
+func (db *DB) Backup(w io.Writer) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(dbStruct)
+}
+
+// Restore replaces the whole database with the backup read from r. Files
+// from older versions are upgraded as they would be on load.
+func (db *DB) Restore(r io.Reader) error {
+	dbStruct := DBStructure{}
+	if err := gob.NewDecoder(r).Decode(&dbStruct); err != nil {
+		return fmt.Errorf("%w (%w)", ErrInvalidBackup, err)
+	}
+	dbStruct.initMaps()
+	if db.journal != nil {
+		// Everything journaled so far predates the backup and must not be
+		// replayed over it.
+		db.mux.RLock()
+		dbStruct.JournalSeq = db.journal.seq
+		db.mux.RUnlock()
+	}
+	return db.writeDB(dbStruct)
+}
+
+// Backup writes a copy of the whole SQLite database file to w. Postgres
+// databases are backed up with pg_dump instead.
+func (db *SQLDB) Backup(w io.Writer) error {
+	if db.dialect.driver != sqliteDialect.driver {
+		return fmt.Errorf("%s: use the engine's own backup tools: %w", db.dialect.driver, errors.ErrUnsupported)
+	}
+	path, err := tempPath("chirpy-backup-*.sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if _, err := db.conn.Exec(`VACUUM INTO ?`, path); err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// Restore replaces every table of the SQLite database with its contents in
+// the backup read from r, in one transaction. The backup is first migrated
+// to the current schema, so backups taken by older versions restore too.
+func (db *SQLDB) Restore(r io.Reader) error {
+	if db.dialect.driver != sqliteDialect.driver {
+		return fmt.Errorf("%s: use the engine's own backup tools: %w", db.dialect.driver, errors.ErrUnsupported)
+	}
+	path, err := tempPath("chirpy-restore-*.sqlite")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := writeFile(path, r); err != nil {
+		return err
+	}
+	backup, err := openSQLDB(sqliteDialect, "file:"+path)
+	if err != nil {
+		return fmt.Errorf("%w (%w)", ErrInvalidBackup, err)
+	}
+	if err := backup.Close(); err != nil {
+		return err
+	}
+
+	// ATTACH applies to one connection, so the whole restore runs on one.
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `ATTACH DATABASE ? AS backup`, path); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE backup`)
+
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM main.sqlite_master
+		WHERE type = 'table' AND name <> 'schema_migrations' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return err
+	}
+	tables := []string{"sqlite_sequence"}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if _, err := tx.Exec(`DELETE FROM main.` + table); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO main.` + table + ` SELECT * FROM backup.` + table); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// tempPath returns an unused path in the temporary directory.
+func tempPath(pattern string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), os.Remove(file.Name())
+}
+
+func writeFile(path string, r io.Reader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,49 @@
+package database
+
+// WriteBatch runs fn with a view of the store whose writes are kept only if
+// fn returns nil, and then all at once, so an operation that touches several
+// records is never left half done. fn must use the view it is given rather
+// than the store, and return the errors it gets from it: a failed write may
+// leave the view partly changed. Batches do not nest; a batch run inside
+// another joins it.
+//
+// The gob store holds its lock while fn runs, so other requests wait for
+// the batch, and writes the file once at the end.
+func (db *DB) WriteBatch(fn func(Storage) error) error {
+	if db.batch != nil {
+		return fn(db)
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	dbStruct, err := db.readDB()
+	if err != nil {
+		return err
+	}
+	view := *db
+	view.batch = &dbStruct
+	if err := fn(&view); err != nil {
+		return err
+	}
+	if err := db.context().Err(); err != nil {
+		return err
+	}
+	return db.saveDB(*view.batch)
+}
+
+// WriteBatch runs fn in a transaction.
+func (db *SQLDB) WriteBatch(fn func(Storage) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	tx, err := db.conn.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	view := *db
+	view.tx = tx
+	if err := fn(&view); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// BookmarkChirp saves chirpId to userId's bookmarks. Bookmarks are private
+// to the user, and bookmarking a chirp twice is not an error.
+func (db *DB) BookmarkChirp(chirpId, userId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.liveChirp(chirpId); !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	if _, bookmarked := dbStruct.Bookmarks[userId][chirpId]; bookmarked {
+		return nil
+	}
+	if dbStruct.Bookmarks[userId] == nil {
+		dbStruct.Bookmarks[userId] = make(map[int]time.Time)
+	}
+	dbStruct.Bookmarks[userId][chirpId] = time.Now().UTC()
+	return db.writeDB(dbStruct)
+}
+
+// UnbookmarkChirp removes chirpId from userId's bookmarks, if it is there.
+// Chirps deleted since they were bookmarked can still be removed.
+func (db *DB) UnbookmarkChirp(chirpId, userId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, bookmarked := dbStruct.Bookmarks[userId][chirpId]; !bookmarked {
+		if _, found := dbStruct.liveChirp(chirpId); !found {
+			return notFound(ErrChirpDoesNotExist, chirpId)
+		}
+		return nil
+	}
+	delete(dbStruct.Bookmarks[userId], chirpId)
+	return db.writeDB(dbStruct)
+}
+
+// GetBookmarkedChirps returns the chirps userId has bookmarked, most
+// recently bookmarked first.
+func (db *DB) GetBookmarkedChirps(userId int) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	bookmarked := dbStruct.Bookmarks[userId]
+	chirps := make([]Chirp, 0, len(bookmarked))
+	for chirpId := range bookmarked {
+		if chirp, found := dbStruct.liveChirp(chirpId); found {
+			chirps = append(chirps, chirp)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		if c := bookmarked[b.Id].Compare(bookmarked[a.Id]); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+	return chirps, nil
+}
+
+func (db *SQLDB) BookmarkChirp(chirpId, userId int) error {
+	_, found, err := db.GetChirp(chirpId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	_, err = db.exec(`INSERT INTO bookmarks (user_id, chirp_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		userId, chirpId, time.Now().UTC())
+	return err
+}
+
+func (db *SQLDB) UnbookmarkChirp(chirpId, userId int) error {
+	result, err := db.exec(`DELETE FROM bookmarks WHERE user_id = ? AND chirp_id = ?`, userId, chirpId)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, found, err := db.GetChirp(chirpId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	return nil
+}
+
+func (db *SQLDB) GetBookmarkedChirps(userId int) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps
+		JOIN bookmarks ON bookmarks.chirp_id = chirps.id
+		WHERE bookmarks.user_id = ? AND chirps.deleted_at IS NULL
+		ORDER BY bookmarks.created_at DESC, chirps.id DESC`, userId)
+}
@@ -0,0 +1,58 @@
+package database
+
+// Capabilities lists the optional features a store offers. Handlers check
+// them and do without what is missing, rather than failing on some
+// backends.
+type Capabilities struct {
+	// Search is SearchChirps looking through chirp bodies.
+	Search bool `json:"search"`
+	// Transactions is WriteBatch keeping either all of a batch's writes or
+	// none of them.
+	Transactions bool `json:"transactions"`
+	// Streaming is Export emitting records as it reads them, rather than
+	// reading the whole store into memory first.
+	Streaming bool `json:"streaming"`
+}
+
+// CapabilitiesOf returns the capabilities every one of stores offers. While
+// backends run side by side, as during a migration, handlers that go by
+// these answer the same whichever store serves them.
+func CapabilitiesOf(stores ...Storage) Capabilities {
+	caps := Capabilities{Search: true, Transactions: true, Streaming: true}
+	for _, store := range stores {
+		caps.Search = caps.Search && store.SupportsSearch()
+		caps.Transactions = caps.Transactions && store.SupportsTransactions()
+		caps.Streaming = caps.Streaming && store.SupportsStreaming()
+	}
+	return caps
+}
+
+// SupportsSearch is false: the gob store has no index over chirp bodies,
+// and scanning them all would hold up the whole file on every query.
+func (db *DB) SupportsSearch() bool {
+	return false
+}
+
+// SupportsTransactions is true: a batch is applied to a copy of the store,
+// which is written only if the batch succeeds.
+func (db *DB) SupportsTransactions() bool {
+	return true
+}
+
+// SupportsStreaming is false: the whole file is decoded before the first
+// record is emitted.
+func (db *DB) SupportsStreaming() bool {
+	return false
+}
+
+func (db *SQLDB) SupportsSearch() bool {
+	return true
+}
+
+func (db *SQLDB) SupportsTransactions() bool {
+	return true
+}
+
+func (db *SQLDB) SupportsStreaming() bool {
+	return true
+}
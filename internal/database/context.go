@@ -0,0 +1,34 @@
+package database
+
+import "context"
+
+// WithContext returns a view of the store whose calls give up with ctx's
+// error once ctx is done, so a request that is cancelled or runs out of
+// time stops waiting on the database. The view shares everything else with
+// the store, and batches run on it take ctx along.
+func (db *DB) WithContext(ctx context.Context) Storage {
+	view := *db
+	view.ctx = ctx
+	return &view
+}
+
+func (db *SQLDB) WithContext(ctx context.Context) Storage {
+	view := *db
+	view.ctx = ctx
+	return &view
+}
+
+// context returns the context the store's calls run under.
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func (db *SQLDB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
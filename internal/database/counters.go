@@ -0,0 +1,152 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// CounterDayLayout formats the day a counter counts, in UTC.
+const CounterDayLayout = "2006-01-02"
+
+// DailyCounter is how many times something, such as a page view or a
+// signup, happened on one day.
+type DailyCounter struct {
+	Name  string `json:"name"`
+	Day   string `json:"day"` // in CounterDayLayout
+	Count int    `json:"count"`
+}
+
+// AddCounters adds each counter's count to what is stored for its name and
+// day.
+func (db *DB) AddCounters(counters []DailyCounter) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	for _, counter := range counters {
+		if dbStruct.Counters[counter.Name] == nil {
+			dbStruct.Counters[counter.Name] = make(map[string]int)
+		}
+		dbStruct.Counters[counter.Name][counter.Day] += counter.Count
+	}
+	return db.writeDB(dbStruct)
+}
+
+// GetCounters returns the counters of the days from since on, by day and
+// then name.
+func (db *DB) GetCounters(since string) ([]DailyCounter, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	counters := make([]DailyCounter, 0)
+	for name, days := range dbStruct.Counters {
+		for day, count := range days {
+			if day >= since {
+				counters = append(counters, DailyCounter{Name: name, Day: day, Count: count})
+			}
+		}
+	}
+	slices.SortFunc(counters, compareCounters)
+	return counters, nil
+}
+
+// GetCounterTotals returns the count of every counter over all days, by
+// name.
+func (db *DB) GetCounterTotals() (map[string]int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]int, len(dbStruct.Counters))
+	for name, days := range dbStruct.Counters {
+		for _, count := range days {
+			totals[name] += count
+		}
+	}
+	return totals, nil
+}
+
+// ResetCounter forgets every day's count for name.
+func (db *DB) ResetCounter(name string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Counters[name]; !found {
+		return nil
+	}
+	delete(dbStruct.Counters, name)
+	return db.writeDB(dbStruct)
+}
+
+func compareCounters(a, b DailyCounter) int {
+	if a.Day != b.Day {
+		if a.Day < b.Day {
+			return -1
+		}
+		return 1
+	}
+	if a.Name < b.Name {
+		return -1
+	}
+	if a.Name > b.Name {
+		return 1
+	}
+	return 0
+}
+
+// CounterDay returns the day t falls on, as counters name it.
+func CounterDay(t time.Time) string {
+	return t.UTC().Format(CounterDayLayout)
+}
+
+func (db *SQLDB) AddCounters(counters []DailyCounter) error {
+	return db.WriteBatch(func(store Storage) error {
+		tx := store.(*SQLDB)
+		for _, counter := range counters {
+			_, err := tx.exec(`INSERT INTO counters (name, day, count) VALUES (?, ?, ?)
+				ON CONFLICT (name, day) DO UPDATE SET count = counters.count + excluded.count`,
+				counter.Name, counter.Day, counter.Count)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *SQLDB) GetCounters(since string) ([]DailyCounter, error) {
+	return queryRows(db, `SELECT name, day, count FROM counters WHERE day >= ? ORDER BY day, name`,
+		func(row scanner) (DailyCounter, error) {
+			counter := DailyCounter{}
+			err := row.Scan(&counter.Name, &counter.Day, &counter.Count)
+			return counter, err
+		}, since)
+}
+
+func (db *SQLDB) GetCounterTotals() (map[string]int, error) {
+	type total struct {
+		name  string
+		count int
+	}
+	rows, err := queryRows(db, `SELECT name, SUM(count) FROM counters GROUP BY name`,
+		func(row scanner) (total, error) {
+			t := total{}
+			err := row.Scan(&t.name, &t.count)
+			return t, err
+		})
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]int, len(rows))
+	for _, t := range rows {
+		totals[t.name] = t.count
+	}
+	return totals, nil
+}
+
+func (db *SQLDB) ResetCounter(name string) error {
+	_, err := db.exec(`DELETE FROM counters WHERE name = ?`, name)
+	return err
+}
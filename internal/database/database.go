@@ -2,37 +2,60 @@ package database
 
 import (
 	"cmp"
+	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"slices"
 
+	"github.com/avearmin/chirpy/internal/richtext"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Errors raised by package database
-var (
-	ErrUserAlreadyExists   = errors.New("This user already exists.")
-	ErrUserDoesNotExist    = errors.New("User not found.")
-	ErrTokenAlreadyRevoked = errors.New("Token is already revoked.")
-	ErrChirpDoesNotExist   = errors.New("Chirp not found.")
-	ErrAuthorization       = errors.New("This action is not authorized.")
-)
-
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path    string
+	mux     *sync.RWMutex
+	journal *journal
+	ids     IdGenerator
+	// batch is set on the view WriteBatch hands out, which reads and
+	// writes it instead of the file.
+	batch *DBStructure
+	// ctx is set on the view WithContext hands out.
+	ctx context.Context
 }
 
 type Chirp struct {
-	Body     string `json:"body"`
-	Id       int    `json:"id"`
-	AuthorId int    `json:"author_id"`
+	Body       string            `json:"body"`
+	Id         int               `json:"id"`
+	ShortId    string            `json:"short_id"` // public identifier used in URLs
+	AuthorId   int               `json:"author_id"`
+	ParentId   *int              `json:"parent_id"`
+	EditedAt   *time.Time        `json:"edited_at"`
+	LikeCount  int               `json:"like_count"`
+	ReplyCount int               `json:"reply_count"`
+	CreatedAt  time.Time         `json:"created_at"` // zero for chirps stored before it was recorded
+	Entities   []richtext.Entity `json:"entities"`   // nil for chirps stored before entities were extracted
+	Tags       []string          `json:"tags"`       // hashtags, lowercased; nil for chirps stored before they were
+	Media      []Attachment      `json:"media"`
+	// Censored is set when the profanity filter changed the body.
+	Censored bool `json:"censored"`
+	// RechirpOfId is set on a rechirp, which reposts that chirp to the
+	// rechirper's followers and has no body of its own.
+	RechirpOfId  *int `json:"rechirp_of_id"`
+	RechirpCount int  `json:"rechirp_count"`
+	// QuotedId is the chirp a quote chirp comments on.
+	QuotedId *int `json:"quoted_id"`
+	// DeletedAt is when the chirp was deleted. Deleted chirps are hidden
+	// from every read until an admin restores them or they are purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type User struct {
@@ -40,62 +63,286 @@ type User struct {
 	Password    []byte `json:"-"` // Should be encoded into Gob but not JSON
 	Id          int    `json:"id"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Verified    bool   `json:"verified"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	// BannerURL is the wide image shown across the top of the profile.
+	BannerURL string `json:"banner_url"`
+	Website   string `json:"website"`
+	// WebsiteVerifiedAt is when Website was found linking back to the
+	// profile with rel="me". Changing Website clears it.
+	WebsiteVerifiedAt *time.Time `json:"website_verified_at"`
+	// MovedTo is the profile URL of the account the user moved to on
+	// another instance, and MovedFrom that of the account they moved here
+	// from.
+	MovedTo   string `json:"moved_to"`
+	MovedFrom string `json:"moved_from"`
+	// FeedAlgorithm names the feed ranker the user prefers; empty means
+	// the server default.
+	FeedAlgorithm string `json:"feed_algorithm"`
+	// IsAdmin lets the user call the admin endpoints with their own access
+	// token.
+	IsAdmin bool `json:"is_admin"`
+	// DeletionRequestedAt is set while the user's request to delete their
+	// account waits out its grace period.
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
+	// TOTPSecret is the user's encrypted TOTP secret. Logging in asks for
+	// a code from it once TOTPEnabled is set, which happens when the user
+	// proves their authenticator app has it.
+	TOTPSecret  []byte `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// TOTPLastStep is the time step of the last TOTP code accepted from
+	// the user, so that code cannot be used again.
+	TOTPLastStep int64 `json:"-"`
+	// RecoveryCodes holds the hashes of the unused recovery codes, each
+	// good for one login in place of a TOTP code.
+	RecoveryCodes []string `json:"-"`
 }
 
 type DBStructure struct {
-	NextChirpId          int
-	NextUserId           int
-	Chirps               map[int]Chirp
-	Users                map[int]User
-	RevokedRefreshTokens map[string]time.Time
+	NextChirpId       int
+	NextUserId        int
+	NextReportId      int
+	NextActionId      int
+	NextAppealId      int
+	Chirps            map[int]Chirp
+	Users             map[int]User
+	Sessions          map[string]Session // session id -> session
+	APIKeys           map[string]APIKey
+	Replies           map[int][]int             // parent chirp id -> reply ids, oldest first
+	Likes             map[int]map[int]time.Time // user id -> liked chirp id -> liked at
+	Reports           map[int]Report
+	ModerationActions map[int]ModerationAction
+	Appeals           map[int]Appeal
+	Links             map[string]Link // short code -> link
+	// VerificationTokens maps the hash of each outstanding email
+	// verification token to the token.
+	VerificationTokens map[string]VerificationToken
+	// Upgrades is how many of upgrades have been applied to this file.
+	Upgrades int
+	// VerifiedBackfilled was set by the first upgrade before upgrades
+	// were counted.
+	VerifiedBackfilled bool
+	Media              map[string]Media
+	Follows            map[int]map[int]time.Time // follower id -> followee id -> followed at
+	FeedMarkers        map[int]FeedMarker
+	Emoji              map[string]Emoji // shortcode -> emoji
+	NextJobId          int
+	Jobs               map[int]Job
+	// JournalSeq is the last journal entry this snapshot holds.
+	JournalSeq    uint64
+	ChirpShortIds map[string]int // short id -> chirp id
+	// DeviceAuthorizations maps user codes to device logins in progress.
+	DeviceAuthorizations map[string]DeviceAuthorization
+	// LoginThrottles counts failed logins by throttleKey.
+	LoginThrottles map[string]LoginThrottle
+	// Uploads holds the chunked uploads in progress by id.
+	Uploads map[string]Upload
+	// BlockedWords holds the profanity filter's patterns by pattern.
+	BlockedWords         map[string]BlockedWord
+	NextScheduledChirpId int
+	// ScheduledChirps holds the chirps waiting to be published by id.
+	ScheduledChirps map[int]ScheduledChirp
+	// MediaBlobs holds the stored blobs media share, by content hash.
+	MediaBlobs  map[string]MediaBlob
+	NextDraftId int
+	// Drafts holds the chirps saved without publishing by id.
+	Drafts map[int]Draft
+	// Bookmarks holds the chirps each user saved for later.
+	Bookmarks map[int]map[int]time.Time // user id -> bookmarked chirp id -> bookmarked at
+	// Polls holds the polls attached to chirps by chirp id.
+	Polls         map[int]Poll
+	PollVotes     map[int]map[int]int // chirp id -> voter id -> option voted for
+	NextWebhookId int
+	// Webhooks holds the URLs events are posted to by id.
+	Webhooks map[int]Webhook
+	// IdempotencyKeys holds the responses to requests made with an
+	// idempotency key by key.
+	IdempotencyKeys     map[string]IdempotencyRecord
+	NextSecurityEventId int
+	// SecurityEvents holds each user's security log by user id, oldest
+	// first.
+	SecurityEvents map[int][]SecurityEvent
+	// Counters holds the daily counts of what the server counts, by name
+	// and then day.
+	Counters map[string]map[string]int
+	// upgraded is set when loading applied upgrades that are not saved yet.
+	upgraded bool
 }
 
+// NewDB opens the gob database at path, creating it if it does not exist.
+// It fails with ErrCorruptDatabase if the file cannot be decoded, rather
+// than serving or overwriting what is left of it. Changes journaled since
+// the last snapshot, such as by a server that crashed, are replayed and
+// folded into a new one.
 func NewDB(path string) (*DB, error) {
 	db := DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:    path,
+		mux:     &sync.RWMutex{},
+		journal: &journal{path: path + journalSuffix},
 	}
 	if err := db.ensureDB(); err != nil {
 		return nil, err
 	}
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	db.journal.seq = dbStruct.JournalSeq
+	if exists(db.journal.path) || dbStruct.upgraded {
+		if err := db.writeDB(dbStruct); err != nil {
+			return nil, err
+		}
+	}
+	db.removeTempFiles()
 	return &db, nil
 }
 
-func (db *DB) CreateChirp(createdBy int, body string) (Chirp, error) {
+// CreateChirp stores chirp under a newly assigned Id. If ParentId is set the
+// chirp is a reply and the parent must exist.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
 	dbStruct, err := db.loadDB()
 	if err != nil {
 		return Chirp{}, err
 	}
-	chirp := Chirp{
-		Id:       dbStruct.NextChirpId,
-		AuthorId: createdBy,
-		Body:     body,
+	chirp.Id, err = db.nextChirpId(dbStruct)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.Entities = richtext.Extract(chirp.Body)
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	chirp.CreatedAt = time.Now().UTC()
+	chirp.LikeCount = 0
+	chirp.ReplyCount = 0
+	chirp.RechirpCount = 0
+	if chirp.ParentId != nil {
+		if _, found := dbStruct.liveChirp(*chirp.ParentId); !found {
+			return Chirp{}, ErrParentDoesNotExist
+		}
+	}
+	chirp.ShortId = ""
+	if err := dbStruct.assignShortId(&chirp); err != nil {
+		return Chirp{}, err
+	}
+	dbStruct.addChirp(chirp)
+	if err := db.commit(dbStruct, journalEntry{Op: journalCreateChirp, Chirp: chirp}); err != nil {
+		return Chirp{}, err
 	}
-	dbStruct.Chirps[dbStruct.NextChirpId] = chirp
-	dbStruct.NextChirpId++
-	db.writeDB(dbStruct)
 	return chirp, nil
 }
 
+// addChirp stores a new chirp and counts it as a reply to its parent, or a
+// rechirp of the chirp it reposts.
+func (dbStruct *DBStructure) addChirp(chirp Chirp) {
+	if chirp.ParentId != nil {
+		if parent, found := dbStruct.Chirps[*chirp.ParentId]; found {
+			dbStruct.Replies[parent.Id] = append(dbStruct.Replies[parent.Id], chirp.Id)
+			parent.ReplyCount++
+			dbStruct.Chirps[parent.Id] = parent
+		}
+	}
+	dbStruct.countRechirp(chirp, 1)
+	dbStruct.Chirps[chirp.Id] = chirp
+	dbStruct.ChirpShortIds[chirp.ShortId] = chirp.Id
+	dbStruct.advanceNextChirpId(chirp.Id)
+}
+
+// advanceNextChirpId keeps sequential ids clear of id. A generator may
+// hand out the largest int, which no sequential id can follow, so that id
+// leaves the counter alone rather than wrapping it round.
+func (dbStruct *DBStructure) advanceNextChirpId(id int) {
+	if id < math.MaxInt {
+		dbStruct.NextChirpId = max(dbStruct.NextChirpId, id+1)
+	}
+}
+
 func (db *DB) DeleteChirp(chirpIdToDelete, idOfRequestingUser int) error {
 	dbStruct, err := db.loadDB()
 	if err != nil {
 		return err
 	}
-	chirp, found := dbStruct.Chirps[chirpIdToDelete]
+	chirp, found := dbStruct.liveChirp(chirpIdToDelete)
 	if !found {
-		return ErrChirpDoesNotExist
+		return notFound(ErrChirpDoesNotExist, chirpIdToDelete)
 	}
 	if chirp.AuthorId != idOfRequestingUser {
 		return ErrAuthorization
 	}
-	delete(dbStruct.Chirps, chirpIdToDelete)
+	deletedAt := time.Now().UTC()
+	chirp.DeletedAt = &deletedAt
+	dbStruct.Chirps[chirpIdToDelete] = chirp
+	if chirp.ParentId != nil {
+		if parent, found := dbStruct.Chirps[*chirp.ParentId]; found {
+			parent.ReplyCount--
+			dbStruct.Chirps[parent.Id] = parent
+		}
+	}
+	dbStruct.countRechirp(chirp, -1)
 	if err := db.writeDB(dbStruct); err != nil {
 		return err
 	}
 	return nil
 }
 
+// countRechirp adds delta to the rechirp count of the chirp that chirp
+// reposts, if it is a rechirp.
+func (dbStruct *DBStructure) countRechirp(chirp Chirp, delta int) {
+	if chirp.RechirpOfId == nil {
+		return
+	}
+	if original, found := dbStruct.Chirps[*chirp.RechirpOfId]; found {
+		original.RechirpCount += delta
+		dbStruct.Chirps[original.Id] = original
+	}
+}
+
+// GetRechirp returns the author's rechirp of the chirp with id, if they
+// have one.
+func (db *DB) GetRechirp(authorId, id int) (Chirp, bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.AuthorId == authorId && chirp.RechirpOfId != nil && *chirp.RechirpOfId == id && chirp.DeletedAt == nil {
+			return chirp, true, nil
+		}
+	}
+	return Chirp{}, false, nil
+}
+
+// UpdateChirp replaces the body of a chirp; censored records whether the
+// profanity filter changed it.
+func (db *DB) UpdateChirp(chirpIdToUpdate, idOfRequestingUser int, body string, censored bool) (Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, found := dbStruct.liveChirp(chirpIdToUpdate)
+	if !found {
+		return Chirp{}, notFound(ErrChirpDoesNotExist, chirpIdToUpdate)
+	}
+	if chirp.AuthorId != idOfRequestingUser {
+		return Chirp{}, ErrAuthorization
+	}
+	if chirp.RechirpOfId != nil {
+		return Chirp{}, ErrRechirpNotEditable
+	}
+	editedAt := time.Now().UTC()
+	chirp.Body = body
+	chirp.Censored = censored
+	chirp.Entities = richtext.Extract(body)
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	chirp.EditedAt = &editedAt
+	dbStruct.Chirps[chirpIdToUpdate] = chirp
+	if err := db.writeDB(dbStruct); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
 func (db *DB) CreateUser(email, password string) (User, error) {
 	dbStruct, err := db.loadDB()
 	if err != nil {
@@ -115,12 +362,18 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 		Password:    hashPass,
 		IsChirpyRed: false,
 	}
-	dbStruct.Users[dbStruct.NextUserId] = user
-	dbStruct.NextUserId++
-	db.writeDB(dbStruct)
+	dbStruct.addUser(user)
+	if err := db.commit(dbStruct, journalEntry{Op: journalCreateUser, User: user}); err != nil {
+		return User{}, err
+	}
 	return user, nil
 }
 
+func (dbStruct *DBStructure) addUser(user User) {
+	dbStruct.Users[user.Id] = user
+	dbStruct.NextUserId = max(dbStruct.NextUserId, user.Id+1)
+}
+
 func (db *DB) GetUser(email string) (User, error) {
 	normalizedEmail := normalizeEmail(email)
 	user, found, err := db.getUserByEmail(normalizedEmail)
@@ -152,28 +405,70 @@ func (db *DB) GetChirp(id int) (Chirp, bool, error) {
 	if err != nil {
 		return Chirp{}, false, err
 	}
-	found, ok := dbStruct.Chirps[id]
+	found, ok := dbStruct.liveChirp(id)
 	if !ok {
 		return Chirp{}, false, nil
 	}
 	return found, true, nil
 }
 
+// liveChirp returns the chirp with id unless there is none or it is deleted.
+func (dbStruct *DBStructure) liveChirp(id int) (Chirp, bool) {
+	chirp, found := dbStruct.Chirps[id]
+	if !found || chirp.DeletedAt != nil {
+		return Chirp{}, false
+	}
+	return chirp, true
+}
+
+// GetChirpsByIds returns the chirps with the given ids in ascending id
+// order, skipping ids that do not exist.
+func (db *DB) GetChirpsByIds(ids []int) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		if chirp, found := dbStruct.liveChirp(id); found {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sortChirps(chirps, "asc")
+	return slices.CompactFunc(chirps, func(a, b Chirp) bool { return a.Id == b.Id }), nil
+}
+
 func (db *DB) GetChirps(order string) ([]Chirp, error) {
 	dbStruct, err := db.loadDB()
 	if err != nil {
 		return nil, err
 	}
-	keys := make([]Chirp, len(dbStruct.Chirps))
-	i := 0
-	for id := range dbStruct.Chirps {
-		keys[i] = dbStruct.Chirps[id]
-		i++
+	keys := make([]Chirp, 0, len(dbStruct.Chirps))
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.DeletedAt == nil {
+			keys = append(keys, chirp)
+		}
 	}
 	sortChirps(keys, order)
 	return keys, nil
 }
 
+// GetChirpsSince returns the chirps posted at or after since, oldest first.
+func (db *DB) GetChirpsSince(since time.Time) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	chirps := make([]Chirp, 0)
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.DeletedAt == nil && !chirp.CreatedAt.Before(since) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sortChirps(chirps, "asc")
+	return chirps, nil
+}
+
 func (db *DB) GetChirpsFromId(authorId int, order string) ([]Chirp, error) {
 	dbStruct, err := db.loadDB()
 	if err != nil {
@@ -183,7 +478,7 @@ func (db *DB) GetChirpsFromId(authorId int, order string) ([]Chirp, error) {
 	i := 0
 	for chirpId := range dbStruct.Chirps {
 		chirp := dbStruct.Chirps[chirpId]
-		if authorId == chirp.AuthorId {
+		if authorId == chirp.AuthorId && chirp.DeletedAt == nil {
 			keys = append(keys, chirp)
 		}
 		i++
@@ -192,6 +487,58 @@ func (db *DB) GetChirpsFromId(authorId int, order string) ([]Chirp, error) {
 	return keys, nil
 }
 
+// GetDescendants returns every chirp in the thread below rootId, replies
+// to replies included, in ascending id order. Replies below a deleted chirp
+// are left out with it.
+func (db *DB) GetDescendants(rootId int) ([]Chirp, error) {
+	return db.descendants(rootId, false)
+}
+
+// GetDescendantsWithDeleted is GetDescendants keeping deleted chirps, and
+// so the replies below them, in the thread.
+func (db *DB) GetDescendantsWithDeleted(rootId int) ([]Chirp, error) {
+	return db.descendants(rootId, true)
+}
+
+func (db *DB) descendants(rootId int, withDeleted bool) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	descendants := []Chirp{}
+	queue := slices.Clone(dbStruct.Replies[rootId])
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		chirp, found := dbStruct.Chirps[id]
+		if !found || (chirp.DeletedAt != nil && !withDeleted) {
+			continue
+		}
+		descendants = append(descendants, chirp)
+		queue = append(queue, dbStruct.Replies[id]...)
+	}
+	sortChirps(descendants, "asc")
+	return descendants, nil
+}
+
+// GetReplies returns the direct replies to parentId.
+func (db *DB) GetReplies(parentId int, order string) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]Chirp, 0, len(dbStruct.Replies[parentId]))
+	for _, id := range dbStruct.Replies[parentId] {
+		if chirp, found := dbStruct.liveChirp(id); found {
+			replies = append(replies, chirp)
+		}
+	}
+	sortChirps(replies, order)
+	return replies, nil
+}
+
+// sortChirps orders chirps by when they were posted, breaking ties by id.
+// Anything but asc or desc leaves the order unspecified.
 func sortChirps(s []Chirp, order string) {
 	if order == "asc" {
 		ascSort(s)
@@ -204,32 +551,31 @@ func sortChirps(s []Chirp, order string) {
 }
 
 func ascSort(s []Chirp) {
-	slices.SortStableFunc(s, func(a, b Chirp) int {
-		return cmp.Compare(a.Id, b.Id)
-	})
+	slices.SortStableFunc(s, compareChirps)
 }
 
 func descSort(s []Chirp) {
 	slices.SortStableFunc(s, func(a, b Chirp) int {
-		return cmp.Compare(b.Id, a.Id)
+		return compareChirps(b, a)
 	})
 }
 
+func compareChirps(a, b Chirp) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Id, b.Id)
+}
+
 func (db *DB) ensureDB() error {
 	if exists(db.path) {
 		return nil
 	}
-	_, err := os.Create(db.path)
-	if err != nil {
-		return err
-	}
 	dbStruct := DBStructure{
-		NextChirpId:          1,
-		NextUserId:           1,
-		Chirps:               make(map[int]Chirp),
-		Users:                make(map[int]User),
-		RevokedRefreshTokens: make(map[string]time.Time),
+		NextChirpId: 1,
+		NextUserId:  1,
 	}
+	dbStruct.initMaps()
 	if err := db.writeDB(dbStruct); err != nil {
 		return err
 	}
@@ -245,9 +591,21 @@ func exists(path string) bool {
 }
 
 func (db *DB) loadDB() (DBStructure, error) {
-	dbStruct := DBStructure{}
+	if err := db.context().Err(); err != nil {
+		return DBStructure{}, err
+	}
+	if db.batch != nil {
+		return *db.batch, nil
+	}
 	db.mux.RLocker().Lock()
 	defer db.mux.RLocker().Unlock()
+	return db.readDB()
+}
+
+// readDB decodes the database file and replays the journal onto it. The
+// caller holds db.mux.
+func (db *DB) readDB() (DBStructure, error) {
+	dbStruct := DBStructure{}
 	file, err := os.Open(db.path)
 	if err != nil {
 		return DBStructure{}, err
@@ -255,26 +613,298 @@ func (db *DB) loadDB() (DBStructure, error) {
 	defer file.Close()
 	decoder := gob.NewDecoder(file)
 	if err := decoder.Decode(&dbStruct); err != nil {
-		return DBStructure{}, err
+		return DBStructure{}, fmt.Errorf("reading %s: %w (%w)", db.path, ErrCorruptDatabase, err)
+	}
+	dbStruct.initMaps()
+	if db.journal != nil {
+		if err := db.replayJournal(&dbStruct); err != nil {
+			return DBStructure{}, err
+		}
 	}
 	return dbStruct, nil
 }
 
+// initMaps allocates any collection that is still nil and starts any id
+// counter that is still zero. Files written before a collection was added
+// decode with it missing.
+func (dbStruct *DBStructure) initMaps() {
+	if dbStruct.NextReportId == 0 {
+		dbStruct.NextReportId = 1
+	}
+	if dbStruct.NextActionId == 0 {
+		dbStruct.NextActionId = 1
+	}
+	if dbStruct.NextAppealId == 0 {
+		dbStruct.NextAppealId = 1
+	}
+	if dbStruct.Chirps == nil {
+		dbStruct.Chirps = make(map[int]Chirp)
+	}
+	if dbStruct.Users == nil {
+		dbStruct.Users = make(map[int]User)
+	}
+	if dbStruct.Sessions == nil {
+		dbStruct.Sessions = make(map[string]Session)
+	}
+	if dbStruct.APIKeys == nil {
+		dbStruct.APIKeys = make(map[string]APIKey)
+	}
+	if dbStruct.Replies == nil {
+		dbStruct.Replies = make(map[int][]int)
+	}
+	if dbStruct.Likes == nil {
+		dbStruct.Likes = make(map[int]map[int]time.Time)
+	}
+	if dbStruct.Reports == nil {
+		dbStruct.Reports = make(map[int]Report)
+	}
+	if dbStruct.ModerationActions == nil {
+		dbStruct.ModerationActions = make(map[int]ModerationAction)
+	}
+	if dbStruct.Appeals == nil {
+		dbStruct.Appeals = make(map[int]Appeal)
+	}
+	if dbStruct.Links == nil {
+		dbStruct.Links = make(map[string]Link)
+	}
+	if dbStruct.VerificationTokens == nil {
+		dbStruct.VerificationTokens = make(map[string]VerificationToken)
+	}
+	if dbStruct.Media == nil {
+		dbStruct.Media = make(map[string]Media)
+	}
+	if dbStruct.Follows == nil {
+		dbStruct.Follows = make(map[int]map[int]time.Time)
+	}
+	if dbStruct.FeedMarkers == nil {
+		dbStruct.FeedMarkers = make(map[int]FeedMarker)
+	}
+	if dbStruct.Emoji == nil {
+		dbStruct.Emoji = make(map[string]Emoji)
+	}
+	if dbStruct.Jobs == nil {
+		dbStruct.Jobs = make(map[int]Job)
+	}
+	if dbStruct.ChirpShortIds == nil {
+		dbStruct.ChirpShortIds = make(map[string]int)
+	}
+	if dbStruct.DeviceAuthorizations == nil {
+		dbStruct.DeviceAuthorizations = make(map[string]DeviceAuthorization)
+	}
+	if dbStruct.LoginThrottles == nil {
+		dbStruct.LoginThrottles = make(map[string]LoginThrottle)
+	}
+	if dbStruct.Uploads == nil {
+		dbStruct.Uploads = make(map[string]Upload)
+	}
+	if dbStruct.BlockedWords == nil {
+		dbStruct.BlockedWords = make(map[string]BlockedWord)
+	}
+	if dbStruct.ScheduledChirps == nil {
+		dbStruct.ScheduledChirps = make(map[int]ScheduledChirp)
+	}
+	if dbStruct.MediaBlobs == nil {
+		dbStruct.MediaBlobs = make(map[string]MediaBlob)
+	}
+	if dbStruct.Drafts == nil {
+		dbStruct.Drafts = make(map[int]Draft)
+	}
+	if dbStruct.Bookmarks == nil {
+		dbStruct.Bookmarks = make(map[int]map[int]time.Time)
+	}
+	if dbStruct.Polls == nil {
+		dbStruct.Polls = make(map[int]Poll)
+	}
+	if dbStruct.PollVotes == nil {
+		dbStruct.PollVotes = make(map[int]map[int]int)
+	}
+	if dbStruct.Webhooks == nil {
+		dbStruct.Webhooks = make(map[int]Webhook)
+	}
+	if dbStruct.IdempotencyKeys == nil {
+		dbStruct.IdempotencyKeys = make(map[string]IdempotencyRecord)
+	}
+	if dbStruct.SecurityEvents == nil {
+		dbStruct.SecurityEvents = make(map[int][]SecurityEvent)
+	}
+	if dbStruct.Counters == nil {
+		dbStruct.Counters = make(map[string]map[string]int)
+	}
+	dbStruct.upgrade()
+}
+
+// upgrades bring files written by older versions up to date. Like the SQL
+// migrations they are applied once each, in order, so new ones must only
+// ever be appended.
+var upgrades = []func(dbStruct *DBStructure){
+	// Users who signed up before email verification existed keep posting.
+	func(dbStruct *DBStructure) {
+		for id, user := range dbStruct.Users {
+			user.Verified = true
+			dbStruct.Users[id] = user
+		}
+	},
+	// Reply counts were not stored alongside chirps.
+	func(dbStruct *DBStructure) {
+		for parentId, replies := range dbStruct.Replies {
+			if parent, found := dbStruct.Chirps[parentId]; found {
+				parent.ReplyCount = len(replies)
+				dbStruct.Chirps[parentId] = parent
+			}
+		}
+	},
+	// Chirps had no short ids.
+	func(dbStruct *DBStructure) {
+		for id, chirp := range dbStruct.Chirps {
+			if err := dbStruct.assignShortId(&chirp); err != nil {
+				continue
+			}
+			dbStruct.Chirps[id] = chirp
+			dbStruct.ChirpShortIds[chirp.ShortId] = id
+		}
+	},
+	// The profanity filter's words were fixed in the code.
+	func(dbStruct *DBStructure) {
+		for _, pattern := range DefaultBlockedWords {
+			dbStruct.BlockedWords[pattern] = BlockedWord{Pattern: pattern, CreatedAt: time.Now().UTC()}
+		}
+	},
+}
+
+func (dbStruct *DBStructure) upgrade() {
+	if dbStruct.VerifiedBackfilled && dbStruct.Upgrades == 0 {
+		dbStruct.Upgrades = 1
+	}
+	for ; dbStruct.Upgrades < len(upgrades); dbStruct.Upgrades++ {
+		upgrades[dbStruct.Upgrades](dbStruct)
+		dbStruct.upgraded = true
+	}
+	dbStruct.VerifiedBackfilled = true
+}
+
+// writeDB replaces the database file with dbStructure. It writes a temporary
+// file beside it and renames that over the original once it is on disk, so
+// a crash part way through leaves the previous version intact.
 func (db *DB) writeDB(dbStructure DBStructure) error {
+	if err := db.context().Err(); err != nil {
+		return err
+	}
+	if db.batch != nil {
+		*db.batch = dbStructure
+		return nil
+	}
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	return db.saveDB(dbStructure)
+}
+
+// updateDB runs fn on the database and saves it if fn reports a change,
+// holding db.mux throughout, so no other write can land between what fn
+// reads and what it writes. Within a batch, which already holds db.mux, fn
+// works on the batch.
+func (db *DB) updateDB(fn func(*DBStructure) (bool, error)) error {
+	if err := db.context().Err(); err != nil {
+		return err
+	}
+	if db.batch != nil {
+		_, err := fn(db.batch)
+		return err
+	}
 	db.mux.Lock()
 	defer db.mux.Unlock()
-	file, err := os.OpenFile(db.path, os.O_WRONLY|os.O_TRUNC, 0664)
+	dbStruct, err := db.readDB()
 	if err != nil {
 		return err
 	}
+	changed, err := fn(&dbStruct)
+	if err != nil || !changed {
+		return err
+	}
+	return db.saveDB(dbStruct)
+}
+
+// saveDB is writeDB for callers that hold db.mux.
+func (db *DB) saveDB(dbStructure DBStructure) error {
+	dir, base := filepath.Split(db.path)
+	file, err := os.CreateTemp(dir, base+tempSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
 	defer file.Close()
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(dbStructure); err != nil {
+	mode := fs.FileMode(0664)
+	if info, err := os.Stat(db.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := file.Chmod(mode); err != nil {
 		return err
 	}
+	if err := gob.NewEncoder(file).Encode(dbStructure); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), db.path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	if db.journal != nil {
+		return db.trimJournal(dbStructure.JournalSeq)
+	}
 	return nil
 }
 
+// tempSuffix ends the pattern for the files writeDB renames into place.
+const tempSuffix = ".tmp-*"
+
+// removeTempFiles deletes temporary files left by writes that crashed
+// before their rename.
+func (db *DB) removeTempFiles() {
+	matches, err := filepath.Glob(db.path + tempSuffix)
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
+
+// syncDir flushes the directory entry of a rename to disk. Not every
+// platform can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	f.Sync()
+	f.Close()
+}
+
+// Ping reports whether the database file can still be read.
+func (db *DB) Ping() error {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+	_, err := os.Stat(db.path)
+	return err
+}
+
+// Close folds the journal into the snapshot, so a clean shutdown leaves a
+// single file. Every write is already on disk. The DB must not be used
+// afterwards.
+func (db *DB) Close() error {
+	if db.journal == nil {
+		return nil
+	}
+	return db.Compact()
+}
+
 func (db *DB) ComparePasswords(password, withEmail string) error {
 	normalizedEmail := normalizeEmail(withEmail)
 	user, err := db.GetUser(normalizedEmail)
@@ -296,19 +926,19 @@ func (db *DB) UpdateUser(id int, email, password string) error {
 	}
 	user, found := dbStruct.Users[id]
 	if !found {
-		return ErrUserDoesNotExist
+		return notFound(ErrUserDoesNotExist, id)
 	}
 	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	updatedUser := User{
-		Email:       email,
-		Password:    hashPass,
-		Id:          id,
-		IsChirpyRed: user.IsChirpyRed,
+	email = normalizeEmail(email)
+	if user.Email != email {
+		user.Verified = false
 	}
-	dbStruct.Users[id] = updatedUser
+	user.Email = email
+	user.Password = hashPass
+	dbStruct.Users[id] = user
 	err = db.writeDB(dbStruct)
 	if err != nil {
 		return err
@@ -329,47 +959,33 @@ func (db *DB) getUserIdByEmail(email string) (int, bool, error) {
 	return 0, false, nil
 }
 
-func (db *DB) RevokeRefreshToken(token string) error {
+func (db *DB) UpgradeUser(id int) error {
 	dbStruct, err := db.loadDB()
 	if err != nil {
 		return err
 	}
-	revoked, err := db.IsTokenRevoked(token)
-	if err != nil {
-		return err
-	}
-	if revoked {
-		return ErrTokenAlreadyRevoked
+	user, found := dbStruct.Users[id]
+	if !found {
+		return notFound(ErrUserDoesNotExist, id)
 	}
-	dbStruct.RevokedRefreshTokens[token] = time.Now()
+	user.IsChirpyRed = true
+	dbStruct.Users[id] = user
 	if err := db.writeDB(dbStruct); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (db *DB) IsTokenRevoked(token string) (bool, error) {
-	dbStruct, err := db.loadDB()
-	if err != nil {
-		return false, err
-	}
-	_, revoked := dbStruct.RevokedRefreshTokens[token]
-	return revoked, nil
-}
-
-func (db *DB) UpgradeUser(id int) error {
+func (db *DB) SetFeedAlgorithm(id int, algorithm string) error {
 	dbStruct, err := db.loadDB()
 	if err != nil {
 		return err
 	}
 	user, found := dbStruct.Users[id]
 	if !found {
-		return ErrUserDoesNotExist
+		return notFound(ErrUserDoesNotExist, id)
 	}
-	user.IsChirpyRed = true
+	user.FeedAlgorithm = algorithm
 	dbStruct.Users[id] = user
-	if err := db.writeDB(dbStruct); err != nil {
-		return err
-	}
-	return nil
+	return db.writeDB(dbStruct)
 }
@@ -1,18 +1,94 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) {
 	runExistsTest(t, "./database.go", true)
 	runExistsTest(t, "./this/does/not/exists.go", false)
+	runSnowflakeIdsTest(t)
+	runRandomIdsTest(t)
 
 	runEnsureDBTest(t)
 
+	runCorruptDBTest(t)
+
+	runJournalTest(t)
+	runShortIdUpgradeTest(t)
+
 	runGetChirpsTest(t)
+
+	runGetRepliesTest(t)
+
+	path := "./test_profiles.gob"
+	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runProfilesTest(t, db)
+	runModerationTest(t, db)
+	runSessionsTest(t, db)
+	runLinksTest(t, db)
+	runVerificationTest(t, db)
+	runFeedSignalsTest(t, db)
+	runFollowsTest(t, db)
+	runFeedMarkerTest(t, db)
+	runDescendantsTest(t, db)
+	runTagsTest(t, db)
+	runEmojiTest(t, db)
+	runExportTest(t, db)
+	runImportTest(t, db)
+	runJobsTest(t, db)
+	runChirpOrderTest(t, db)
+	runJobLeaseTest(t, db)
+	runBackupTest(t, db)
+	runShortIdTest(t, db)
+	runSoftDeleteTest(t, db)
+	runAdminTest(t, db)
+	runDeleteUserTest(t, db)
+	runExportUserTest(t, db)
+	runChirpsSinceTest(t, db)
+	runStatsTest(t, db)
+	runDeviceAuthorizationTest(t, db)
+	runTOTPTest(t, db)
+	runLoginThrottleTest(t, db)
+	runUploadTest(t, db)
+	runBlockedWordsTest(t, db)
+	runScheduledChirpTest(t, db)
+	runMediaDedupTest(t, db)
+	runDraftTest(t, db)
+	runOrphanedMediaTest(t, db)
+	runRechirpTest(t, db)
+	runBookmarkTest(t, db)
+	runWebsiteTest(t, db)
+	runMovedAccountTest(t, db)
+	runPollTest(t, db)
+	runIdGeneratorTest(t, db)
+	runWebhookTest(t, db)
+	runWriteBatchTest(t, db)
+	runIdempotencyTest(t, db)
+	runSecurityEventsTest(t, db)
+	runWithContextTest(t, db)
+	runCountersTest(t, db)
+	runAnonymizeTest(t, db)
+	runWipeTest(t, db)
+	runSearchTest(t, db)
 }
 
 func runExistsTest(t *testing.T, path string, expecting bool) {
@@ -26,6 +102,7 @@ func runExistsTest(t *testing.T, path string, expecting bool) {
 func runEnsureDBTest(t *testing.T) {
 	path := "./test_db.gob"
 	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
 	db := &DB{
 		path: path,
 		mux:  &sync.RWMutex{},
@@ -50,9 +127,113 @@ func runEnsureDBTest(t *testing.T) {
 	}
 }
 
+func runCorruptDBTest(t *testing.T) {
+	path := "./test_corrupt.gob"
+	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
+	if _, err := NewDB(path); err != nil {
+		t.Fatal(err)
+	}
+	leftover := path + ".tmp-123"
+	if err := os.WriteFile(leftover, []byte("half written"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(leftover)
+	t.Logf("Starting test for NewDB with: a leftover temporary file, and expecting: it removed")
+	if _, err := NewDB(path); err != nil {
+		t.Fatal(err)
+	}
+	if exists(leftover) {
+		t.Errorf("Expecting: %s removed, but got: it still exists", leftover)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)/2], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for NewDB with: a truncated file, and expecting: %v", ErrCorruptDatabase)
+	if _, err := NewDB(path); !errors.Is(err, ErrCorruptDatabase) {
+		t.Errorf("Expecting: %v, but got: %v", ErrCorruptDatabase, err)
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(data)/2 {
+		t.Errorf("Expecting: the corrupt file left as it was, but got: %d bytes", len(after))
+	}
+}
+
+func runJournalTest(t *testing.T) {
+	path := "./test_journal.gob"
+	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("journal@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "journaled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "journaled reply", ParentId: &root.Id}); err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := db.CreateSession(user.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteSession(token); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(path + journalSuffix) {
+		t.Errorf("Expecting: a journal, but got: none")
+	}
+
+	file, err := os.OpenFile(path+journalSuffix, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 1, 0, 42})
+	file.Close()
+
+	t.Logf("Starting test for NewDB with: a journal ending in a torn record, and expecting: every complete entry replayed")
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(path + journalSuffix) {
+		t.Errorf("Expecting: the journal folded into the snapshot, but got: it still exists")
+	}
+	chirp, found, err := db.GetChirp(root.Id)
+	if err != nil || !found || chirp.ReplyCount != 1 {
+		t.Errorf("Expecting: chirp %d with a reply, but got: %+v, %t, %v", root.Id, chirp, found, err)
+	}
+	if _, err := db.GetUser("journal@example.com"); err != nil {
+		t.Errorf("Expecting: the journaled user, but got: %v", err)
+	}
+	if err := db.DeleteSession(token); !errors.Is(err, ErrSessionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionDoesNotExist, err)
+	}
+	next, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "after replay"})
+	if err != nil || next.Id != root.Id+2 {
+		t.Errorf("Expecting: chirp %d, but got: %+v, %v", root.Id+2, next, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if exists(path + journalSuffix) {
+		t.Errorf("Expecting: no journal after Close, but got: one")
+	}
+}
+
 func runGetChirpsTest(t *testing.T) {
 	path := "./test_db.gob"
 	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
 
 	expecting := []Chirp{
 		{Id: 1, Body: "Some chirp"},
@@ -66,7 +247,7 @@ func runGetChirpsTest(t *testing.T) {
 		t.Error(err)
 	}
 
-	dbStruct := DBStructure{NextChirpId: 1, Chirps: make(map[int]Chirp)}
+	dbStruct := DBStructure{NextChirpId: 1, Chirps: make(map[int]Chirp), Upgrades: len(upgrades)}
 	dbStruct.Chirps[0] = Chirp{Id: 1, Body: "Some chirp"}
 	dbStruct.Chirps[1] = Chirp{Id: 2, Body: "Some other chirp"}
 
@@ -75,15 +256,2562 @@ func runGetChirpsTest(t *testing.T) {
 		t.Error(err)
 	}
 
-	got, err := db.GetChirps()
+	got, err := db.GetChirps("asc")
 	if err != nil {
 		t.Error(err)
 	}
 
 	for i, _ := range got {
-		if got[i] != expecting[i] {
+		if !reflect.DeepEqual(got[i], expecting[i]) {
 			t.Errorf("Expecting: %v, but got: %v", expecting, got)
 		}
 	}
 
 }
+
+func runGetRepliesTest(t *testing.T) {
+	path := "./test_db.gob"
+	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
+
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Parent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := db.CreateChirp(Chirp{AuthorId: 2, Body: "First", ParentId: &parent.Id})
+	second, _ := db.CreateChirp(Chirp{AuthorId: 1, Body: "Second", ParentId: &parent.Id})
+	db.CreateChirp(Chirp{AuthorId: 1, Body: "Unrelated"})
+
+	t.Logf("Starting test for GetReplies with: %d, and expecting: %v", parent.Id, []int{first.Id, second.Id})
+	got, err := db.GetReplies(parent.Id, "asc")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(got) != 2 || got[0].Id != first.Id || got[1].Id != second.Id {
+		t.Errorf("Expecting: %v, but got: %v", []Chirp{first, second}, got)
+	}
+
+	t.Logf("Starting test for GetReplies after deleting a reply, and expecting: %v", []int{second.Id})
+	if err := db.DeleteChirp(first.Id, 2); err != nil {
+		t.Error(err)
+	}
+	got, _ = db.GetReplies(parent.Id, "asc")
+	if len(got) != 1 || got[0].Id != second.Id {
+		t.Errorf("Expecting: %v, but got: %v", []Chirp{second}, got)
+	}
+}
+
+func runProfilesTest(t *testing.T, db Storage) {
+	alice, err := db.CreateUser("alice@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := db.CreateUser("bob@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handle, bio := "@Alice", "Hello"
+	expecting := Profile{Id: alice.Id, Handle: "alice", Bio: bio}
+	t.Logf("Starting test for UpdateProfile with: \"%s\", and expecting: %v", handle, expecting)
+	got, err := db.UpdateProfile(alice.Id, ProfileUpdate{Handle: &handle, Bio: &bio})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Profile() != expecting {
+		t.Errorf("Expecting: %v, but got: %v", expecting, got.Profile())
+	}
+
+	t.Logf("Starting test for UpdateProfile with a taken handle: \"%s\", and expecting: %v", "ALICE", ErrHandleTaken)
+	taken := "ALICE"
+	if _, err := db.UpdateProfile(bob.Id, ProfileUpdate{Handle: &taken}); err != ErrHandleTaken {
+		t.Errorf("Expecting: %v, but got: %v", ErrHandleTaken, err)
+	}
+
+	t.Logf("Starting test for UpdateUser keeping the profile of: %d, and expecting: %v", alice.Id, expecting)
+	if err := db.UpdateUser(alice.Id, "alice@example.org", "hunter3"); err != nil {
+		t.Error(err)
+	}
+	user, err := db.GetUserById(alice.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Profile() != expecting {
+		t.Errorf("Expecting: %v, but got: %v", expecting, user.Profile())
+	}
+
+	t.Logf("Starting test for GetProfiles with: %v, and expecting: %d profiles", []int{alice.Id, bob.Id, 999}, 2)
+	profiles, err := db.GetProfiles([]int{alice.Id, bob.Id, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 || profiles[alice.Id] != expecting {
+		t.Errorf("Expecting: %v, but got: %v", expecting, profiles)
+	}
+}
+
+func runModerationTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("suspended@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	until := time.Now().UTC().Add(time.Hour)
+	action, err := db.CreateModerationAction(ModerationAction{UserId: user.Id, Kind: ActionSuspend, Reason: "spam", Until: &until})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for IsSuspended with: %d, and expecting: true now and false after %v", user.Id, until)
+	if suspended, _ := db.IsSuspended(user.Id, time.Now()); !suspended {
+		t.Errorf("Expecting: true, but got: %t", suspended)
+	}
+	if suspended, _ := db.IsSuspended(user.Id, until.Add(time.Second)); suspended {
+		t.Errorf("Expecting: false, but got: %t", suspended)
+	}
+
+	t.Logf("Starting test for CreateAppeal by another user with: %d, and expecting: %v", action.Id, ErrActionDoesNotExist)
+	if _, err := db.CreateAppeal(Appeal{ActionId: action.Id, UserId: user.Id + 1, Message: "not me"}); !errors.Is(err, ErrActionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrActionDoesNotExist, err)
+	}
+	appeal, err := db.CreateAppeal(Appeal{ActionId: action.Id, UserId: user.Id, Message: "it was a joke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for CreateAppeal twice with: %d, and expecting: %v", action.Id, ErrAlreadyAppealed)
+	if _, err := db.CreateAppeal(Appeal{ActionId: action.Id, UserId: user.Id, Message: "again"}); err != ErrAlreadyAppealed {
+		t.Errorf("Expecting: %v, but got: %v", ErrAlreadyAppealed, err)
+	}
+
+	t.Logf("Starting test for GetModerationActions with: %d, and expecting the appeal: %d", user.Id, appeal.Id)
+	actions, err := db.GetModerationActions(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || actions[0].Reason != "spam" || actions[0].Appeal == nil || actions[0].Appeal.Id != appeal.Id {
+		t.Errorf("Expecting: %v, but got: %v", action, actions)
+	}
+
+	t.Logf("Starting test for ResolveAppeal with: \"%s\", and expecting the suspension lifted", AppealGranted)
+	if open, _ := db.GetOpenAppeals(); len(open) != 1 {
+		t.Errorf("Expecting: 1, but got: %d", len(open))
+	}
+	if _, err := db.ResolveAppeal(appeal.Id, AppealGranted); err != nil {
+		t.Fatal(err)
+	}
+	if suspended, _ := db.IsSuspended(user.Id, time.Now()); suspended {
+		t.Errorf("Expecting: false, but got: %t", suspended)
+	}
+	if open, _ := db.GetOpenAppeals(); len(open) != 0 {
+		t.Errorf("Expecting: 0, but got: %d", len(open))
+	}
+	if _, err := db.ResolveAppeal(appeal.Id+100, AppealDenied); !errors.Is(err, ErrAppealDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrAppealDoesNotExist, err)
+	}
+}
+
+func runSessionsTest(t *testing.T, db Storage) {
+	session, token, err := db.CreateSession(3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for RotateSession with session: %s, and expecting a new token", session.Id)
+	rotated, newToken, err := db.RotateSession(token, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Id != session.Id || newToken == token || rotated.UserId != 3 {
+		t.Errorf("Expecting: %v, but got: %v", session, rotated)
+	}
+
+	t.Logf("Starting test for RotateSession replaying an old token, and expecting: %v", ErrSessionReplayed)
+	if _, _, err := db.RotateSession(token, time.Hour); err != ErrSessionReplayed {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionReplayed, err)
+	}
+	t.Logf("Starting test for RotateSession after a replay, and expecting: %v", ErrSessionDoesNotExist)
+	if _, _, err := db.RotateSession(newToken, time.Hour); !errors.Is(err, ErrSessionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for RotateSession with an expired session, and expecting: %v", ErrSessionDoesNotExist)
+	_, expired, err := db.CreateSession(3, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.RotateSession(expired, time.Hour); !errors.Is(err, ErrSessionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for DeleteUserSessions with: %d, and expecting every session gone", 3)
+	_, first, _ := db.CreateSession(3, time.Hour)
+	_, second, _ := db.CreateSession(3, time.Hour)
+	_, other, _ := db.CreateSession(4, time.Hour)
+	if err := db.DeleteSession(first); err != nil {
+		t.Error(err)
+	}
+	if err := db.DeleteSession(first); !errors.Is(err, ErrSessionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionDoesNotExist, err)
+	}
+	if err := db.DeleteUserSessions(3); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := db.RotateSession(second, time.Hour); !errors.Is(err, ErrSessionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionDoesNotExist, err)
+	}
+	if _, _, err := db.RotateSession(other, time.Hour); err != nil {
+		t.Errorf("Expecting: <nil>, but got: %v", err)
+	}
+
+	t.Logf("Starting test for RotateSession with a capped session, and expecting: the expiry stops at the cap")
+	capped, cappedToken, err := db.CreateCappedSession(3, time.Hour, 90*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capped.MaxExpiresAt == nil || !capped.ExpiresAt.Before(*capped.MaxExpiresAt) {
+		t.Fatalf("Expecting: an hour long session capped later, but got: %+v", capped)
+	}
+	rotated, _, err = db.RotateSession(cappedToken, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.ExpiresAt.Equal(*capped.MaxExpiresAt) {
+		t.Errorf("Expecting: %v, but got: %v", *capped.MaxExpiresAt, rotated.ExpiresAt)
+	}
+}
+
+func runLinksTest(t *testing.T, db Storage) {
+	chirp, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "https://a.example https://b.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := []string{"https://a.example", "https://b.example"}
+
+	t.Logf("Starting test for CreateLinks with: %v, and expecting: %d links", urls, 2)
+	links, err := db.CreateLinks(chirp.Id, urls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := db.CreateLinks(chirp.Id, urls[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || !reflect.DeepEqual(links, again) {
+		t.Errorf("Expecting: %v, but got: %v", links, again)
+	}
+
+	t.Logf("Starting test for FollowLink with: \"%s\", and expecting: %d click", links[0].Code, 1)
+	if _, err := db.FollowLink(links[0].Code, true); err != nil {
+		t.Error(err)
+	}
+	got, err := db.FollowLink(links[0].Code, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.URL != links[0].URL || got.Clicks != 1 {
+		t.Errorf("Expecting: %v, but got: %v", links[0], got)
+	}
+
+	t.Logf("Starting test for FollowLink after deleting chirp: %d, and expecting: %v", chirp.Id, ErrLinkDoesNotExist)
+	if err := db.DeleteChirp(chirp.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.FollowLink(links[0].Code, true); !errors.Is(err, ErrLinkDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrLinkDoesNotExist, err)
+	}
+}
+
+func runVerificationTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("unverified@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Verified {
+		t.Errorf("Expecting: false, but got: %t", user.Verified)
+	}
+	expired, err := db.CreateVerificationToken(user.Id, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for VerifyUser with an expired token, and expecting: %v", ErrInvalidVerification)
+	if _, err := db.VerifyUser(expired); err != ErrInvalidVerification {
+		t.Errorf("Expecting: %v, but got: %v", ErrInvalidVerification, err)
+	}
+
+	first, _ := db.CreateVerificationToken(user.Id, time.Hour)
+	second, err := db.CreateVerificationToken(user.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for VerifyUser with a replaced token, and expecting: %v", ErrInvalidVerification)
+	if _, err := db.VerifyUser(first); err != ErrInvalidVerification {
+		t.Errorf("Expecting: %v, but got: %v", ErrInvalidVerification, err)
+	}
+	t.Logf("Starting test for VerifyUser with the latest token, and expecting: true")
+	verified, err := db.VerifyUser(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Verified {
+		t.Errorf("Expecting: true, but got: %t", verified.Verified)
+	}
+	if _, err := db.CreateVerificationToken(user.Id, time.Hour); err != ErrAlreadyVerified {
+		t.Errorf("Expecting: %v, but got: %v", ErrAlreadyVerified, err)
+	}
+
+	t.Logf("Starting test for UpdateUser with a new email, and expecting: false")
+	if err := db.UpdateUser(user.Id, "changed@example.com", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := db.GetUserById(user.Id)
+	if changed.Verified {
+		t.Errorf("Expecting: false, but got: %t", changed.Verified)
+	}
+}
+
+func runFeedSignalsTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("ranked@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := db.CreateChirp(Chirp{Body: "parent", AuthorId: user.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for CreateChirp, and expecting: a created_at")
+	if parent.CreatedAt.IsZero() {
+		t.Errorf("Expecting: a created_at, but got: %v", parent.CreatedAt)
+	}
+	reply, _ := db.CreateChirp(Chirp{Body: "reply", AuthorId: user.Id, ParentId: &parent.Id})
+	db.CreateChirp(Chirp{Body: "another reply", AuthorId: user.Id, ParentId: &parent.Id})
+	if err := db.DeleteChirp(reply.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for GetChirp reply count, and expecting: 1")
+	stored, _, err := db.GetChirp(parent.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReplyCount != 1 {
+		t.Errorf("Expecting: 1, but got: %d", stored.ReplyCount)
+	}
+	if !stored.CreatedAt.Equal(parent.CreatedAt) {
+		t.Errorf("Expecting: %v, but got: %v", parent.CreatedAt, stored.CreatedAt)
+	}
+
+	t.Logf("Starting test for SetFeedAlgorithm with: trending, and expecting: trending")
+	if err := db.SetFeedAlgorithm(user.Id, "trending"); err != nil {
+		t.Fatal(err)
+	}
+	updated, _ := db.GetUserById(user.Id)
+	if updated.FeedAlgorithm != "trending" {
+		t.Errorf("Expecting: trending, but got: %s", updated.FeedAlgorithm)
+	}
+	if err := db.SetFeedAlgorithm(-1, "trending"); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+}
+
+func runFollowsTest(t *testing.T, db Storage) {
+	follower, err := db.CreateUser("follower@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	followee, err := db.CreateUser("followee@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for Follow with: self, and expecting: %v", ErrCannotFollowSelf)
+	if err := db.Follow(follower.Id, follower.Id); err != ErrCannotFollowSelf {
+		t.Errorf("Expecting: %v, but got: %v", ErrCannotFollowSelf, err)
+	}
+	t.Logf("Starting test for Follow with: unknown user, and expecting: %v", ErrUserDoesNotExist)
+	if err := db.Follow(follower.Id, -1); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+
+	db.Follow(follower.Id, followee.Id)
+	if err := db.Follow(follower.Id, followee.Id); err != nil {
+		t.Errorf("Expecting: %v, but got: %v", nil, err)
+	}
+	t.Logf("Starting test for GetFollowing and GetFollowers, and expecting: %v", []int{followee.Id})
+	following, _ := db.GetFollowing(follower.Id)
+	if !reflect.DeepEqual(following, []int{followee.Id}) {
+		t.Errorf("Expecting: %v, but got: %v", []int{followee.Id}, following)
+	}
+	followers, _ := db.GetFollowers(followee.Id)
+	if !reflect.DeepEqual(followers, []int{follower.Id}) {
+		t.Errorf("Expecting: %v, but got: %v", []int{follower.Id}, followers)
+	}
+
+	t.Logf("Starting test for Unfollow, and expecting: []")
+	if err := db.Unfollow(follower.Id, followee.Id); err != nil {
+		t.Fatal(err)
+	}
+	following, _ = db.GetFollowing(follower.Id)
+	if len(following) != 0 {
+		t.Errorf("Expecting: [], but got: %v", following)
+	}
+}
+
+func runFeedMarkerTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for GetFeedMarker with: no marker, and expecting: 0")
+	marker, err := db.GetFeedMarker(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.LastReadId != 0 || marker.UserId != 42 {
+		t.Errorf("Expecting: 0, but got: %+v", marker)
+	}
+
+	db.SetFeedMarker(42, 10)
+	t.Logf("Starting test for SetFeedMarker with: an older chirp, and expecting: 10")
+	marker, err = db.SetFeedMarker(42, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marker.LastReadId != 10 {
+		t.Errorf("Expecting: 10, but got: %d", marker.LastReadId)
+	}
+	t.Logf("Starting test for SetFeedMarker with: a newer chirp, and expecting: 12")
+	db.SetFeedMarker(42, 12)
+	marker, _ = db.GetFeedMarker(42)
+	if marker.LastReadId != 12 || marker.UpdatedAt == nil {
+		t.Errorf("Expecting: 12, but got: %+v", marker)
+	}
+}
+
+func runDescendantsTest(t *testing.T, db Storage) {
+	root, err := db.CreateChirp(Chirp{Body: "root", AuthorId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := db.CreateChirp(Chirp{Body: "first", AuthorId: 1, ParentId: &root.Id})
+	second, _ := db.CreateChirp(Chirp{Body: "second", AuthorId: 1, ParentId: &root.Id})
+	nested, _ := db.CreateChirp(Chirp{Body: "nested", AuthorId: 1, ParentId: &first.Id})
+	db.CreateChirp(Chirp{Body: "elsewhere", AuthorId: 1})
+
+	expecting := []int{first.Id, second.Id, nested.Id}
+	t.Logf("Starting test for GetDescendants, and expecting: %v", expecting)
+	descendants, err := db.GetDescendants(root.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, chirp := range descendants {
+		ids = append(ids, chirp.Id)
+	}
+	if !reflect.DeepEqual(ids, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, ids)
+	}
+}
+
+func runTagsTest(t *testing.T, db Storage) {
+	tagged, err := db.CreateChirp(Chirp{Body: "learning #Gophers and #queries", AuthorId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateChirp(Chirp{Body: "more #gophers", AuthorId: 2})
+	db.CreateChirp(Chirp{Body: "#queries #queries #queries", AuthorId: 1})
+	edited, _ := db.CreateChirp(Chirp{Body: "#typo", AuthorId: 3})
+	if _, err := db.UpdateChirp(edited.Id, 3, "#gophers fixed", false); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for GetChirpsByTag with: #GoPhers, and expecting: 3 chirps")
+	chirps, err := db.GetChirpsByTag("#GoPhers", "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 3 || chirps[0].Id != tagged.Id || !reflect.DeepEqual(chirps[0].Tags, []string{"gophers", "queries"}) {
+		t.Errorf("Expecting: 3 chirps starting with %d, but got: %v", tagged.Id, chirps)
+	}
+	if typos, _ := db.GetChirpsByTag("typo", "asc"); len(typos) != 0 {
+		t.Errorf("Expecting: no chirps, but got: %v", typos)
+	}
+
+	expecting := []TrendingTag{{Tag: "gophers", Uses: 3, Authors: 3}, {Tag: "queries", Uses: 2, Authors: 1}}
+	t.Logf("Starting test for GetTrends, and expecting: %v", expecting)
+	trends, err := db.GetTrends(time.Now().Add(-time.Hour), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(trends, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, trends)
+	}
+	if trends, _ := db.GetTrends(time.Now().Add(time.Hour), 5); len(trends) != 0 {
+		t.Errorf("Expecting: no trends, but got: %v", trends)
+	}
+}
+
+func runEmojiTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for PutEmoji with: :blobcat: twice, and expecting: the second media id")
+	first, err := db.PutEmoji("blobcat", "aaaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.PutEmoji("ablobwave", "bbbb")
+	second, err := db.PutEmoji("blobcat", "cccc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.MediaId != "cccc" || !second.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("Expecting: media cccc created at %v, but got: %v", first.CreatedAt, second)
+	}
+
+	t.Logf("Starting test for GetEmoji, and expecting: [ablobwave blobcat]")
+	emoji, err := db.GetEmoji()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emoji) != 2 || emoji[0].Shortcode != "ablobwave" || emoji[1].MediaId != "cccc" {
+		t.Errorf("Expecting: [ablobwave blobcat], but got: %v", emoji)
+	}
+
+	t.Logf("Starting test for DeleteEmoji with: :blobcat: twice, and expecting: %v the second time", ErrEmojiDoesNotExist)
+	if err := db.DeleteEmoji("blobcat"); err != nil {
+		t.Errorf("Expecting: %v, but got: %v", nil, err)
+	}
+	if err := db.DeleteEmoji("blobcat"); !errors.Is(err, ErrEmojiDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrEmojiDoesNotExist, err)
+	}
+}
+
+func runExportTest(t *testing.T, db Storage) {
+	exporter, err := db.CreateUser("exporter@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	followee, err := db.CreateUser("exported@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp(Chirp{Body: "export me", AuthorId: followee.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateChirp(Chirp{Body: "and my reply", AuthorId: exporter.Id, ParentId: &chirp.Id})
+	if err := db.Follow(exporter.Id, followee.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LikeChirp(chirp.Id, exporter.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for Export, and expecting: users, follows, chirps, then likes, with parents before replies")
+	order := map[string]int{RecordUser: 0, RecordFollow: 1, RecordChirp: 2, RecordLike: 3}
+	last := 0
+	counts := make(map[string]int)
+	exported := make(map[int]bool)
+	err = db.Export(func(record Record) error {
+		if order[record.Type] < last {
+			t.Errorf("Expecting: %s records before %d, but got one after", record.Type, last)
+		}
+		last = order[record.Type]
+		counts[record.Type]++
+		switch data := record.Data.(type) {
+		case User:
+			if data.Password != nil {
+				t.Errorf("Expecting: no password hash, but got one for user %d", data.Id)
+			}
+		case Chirp:
+			if data.ParentId != nil && !exported[*data.ParentId] {
+				t.Errorf("Expecting: parent %d before reply %d, but got the reply first", *data.ParentId, data.Id)
+			}
+			exported[data.Id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, recordType := range []string{RecordUser, RecordFollow, RecordChirp, RecordLike} {
+		if counts[recordType] == 0 {
+			t.Errorf("Expecting: %s records, but got: %v", recordType, counts)
+		}
+	}
+
+	stop := errors.New("stop")
+	if err := db.Export(func(Record) error { return stop }); err != stop {
+		t.Errorf("Expecting: %v, but got: %v", stop, err)
+	}
+}
+
+func runImportTest(t *testing.T, db Storage) {
+	parentId := 9001
+	created := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Type: RecordUser, Data: User{Id: 9000, Email: "Merged@example.com", Handle: "merged"}},
+		{Type: RecordUser, Data: User{Id: 9002, Email: "exporter@example.com"}},
+		{Type: RecordFollow, Data: Follow{FollowerId: 9002, FolloweeId: 9000, CreatedAt: created}},
+		{Type: RecordChirp, Data: Chirp{Id: 9001, Body: "brought over #imported", AuthorId: 9000, CreatedAt: created}},
+		{Type: RecordChirp, Data: Chirp{Id: 9003, Body: "a reply #imported", AuthorId: 9002, ParentId: &parentId, CreatedAt: created}},
+		{Type: RecordLike, Data: Like{UserId: 9002, ChirpId: 9001, CreatedAt: created}},
+		{Type: RecordLike, Data: Like{UserId: 9004, ChirpId: 9001, CreatedAt: created}},
+	}
+
+	t.Logf("Starting test for Import with: remap, and expecting: 2 chirps with new ids, one liked reply")
+	importer := NewImporter(db, ImportRemap)
+	for _, record := range records {
+		if err := importer.Import(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chirps, err := db.GetChirpsByTag("imported", "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 || chirps[0].Id == 9001 || chirps[1].ParentId == nil || *chirps[1].ParentId != chirps[0].Id ||
+		chirps[0].LikeCount != 1 || chirps[0].ReplyCount != 1 || !chirps[0].CreatedAt.Equal(created) {
+		t.Errorf("Expecting: a liked chirp with one reply, but got: %v", chirps)
+	}
+	expecting := ImportStats{
+		Imported: map[string]int{RecordUser: 1, RecordFollow: 1, RecordChirp: 2, RecordLike: 1},
+		Skipped:  map[string]int{RecordUser: 1, RecordLike: 1},
+	}
+	if !reflect.DeepEqual(importer.Stats, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, importer.Stats)
+	}
+	merged, err := db.GetUser("merged@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ComparePasswords("", "merged@example.com"); err == nil {
+		t.Errorf("Expecting: imported users to have no password, but got: a match")
+	}
+
+	t.Logf("Starting test for Import with: overwrite, and expecting: the user's display name to change")
+	importer = NewImporter(db, ImportOverwrite)
+	err = importer.Import(Record{Type: RecordUser, Data: User{Id: merged.Id, Email: merged.Email, DisplayName: "Merged"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := db.GetUserById(merged.Id); user.DisplayName != "Merged" {
+		t.Errorf("Expecting: Merged, but got: %v", user)
+	}
+
+	t.Logf("Starting test for Import with: skip, and expecting: a colliding chirp and its reply skipped")
+	importer = NewImporter(db, ImportSkip)
+	collidingId := chirps[0].Id
+	for _, record := range []Record{
+		{Type: RecordUser, Data: User{Id: merged.Id, Email: merged.Email}},
+		{Type: RecordChirp, Data: Chirp{Id: collidingId, Body: "not mine", AuthorId: 12345}},
+		{Type: RecordChirp, Data: Chirp{Id: collidingId + 1000, Body: "replying", AuthorId: merged.Id, ParentId: &collidingId}},
+	} {
+		if err := importer.Import(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if importer.Stats.Skipped[RecordChirp] != 2 || importer.Stats.Skipped[RecordUser] != 1 {
+		t.Errorf("Expecting: 1 user and 2 chirps skipped, but got: %v", importer.Stats)
+	}
+}
+
+func runJobsTest(t *testing.T, db Storage) {
+	now := time.Now().UTC()
+	later, err := db.EnqueueJob(Job{Kind: "test", Payload: json.RawMessage(`{"n":2}`), MaxAttempts: 3, RunAt: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := db.EnqueueJob(Job{Kind: "test", Payload: json.RawMessage(`{"n":1}`), MaxAttempts: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for ClaimJob, and expecting: job %d, then nothing due", first.Id)
+	claimed, found, err := db.ClaimJob(time.Now(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || claimed.Id != first.Id || claimed.State != JobRunning || claimed.Attempts != 1 || string(claimed.Payload) != `{"n":1}` {
+		t.Errorf("Expecting: job %d running, but got: %+v", first.Id, claimed)
+	}
+	if _, found, _ := db.ClaimJob(time.Now(), time.Minute); found {
+		t.Errorf("Expecting: no due jobs, but got one")
+	}
+
+	t.Logf("Starting test for FailJob, and expecting: a retry and then a dead job")
+	retryAt := time.Now().Add(-time.Second)
+	if job, err := db.FailJob(first.Id, "flaky", &retryAt); err != nil || job.State != JobQueued {
+		t.Errorf("Expecting: queued, but got: %+v, %v", job, err)
+	}
+	claimed, _, _ = db.ClaimJob(time.Now(), time.Minute)
+	if claimed.Id != first.Id || claimed.Attempts != 2 || claimed.LastError != "flaky" {
+		t.Errorf("Expecting: second attempt at job %d, but got: %+v", first.Id, claimed)
+	}
+	if _, err := db.RequeueJob(first.Id); err != ErrJobNotDead {
+		t.Errorf("Expecting: %v, but got: %v", ErrJobNotDead, err)
+	}
+	if _, err := db.FailJob(first.Id, "broken", nil); err != nil {
+		t.Fatal(err)
+	}
+	dead, err := db.GetJobs(JobDead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Id != first.Id || dead[0].LastError != "broken" {
+		t.Errorf("Expecting: job %d dead, but got: %+v", first.Id, dead)
+	}
+
+	t.Logf("Starting test for RequeueJob, and expecting: job %d runs again and completes", first.Id)
+	if job, err := db.RequeueJob(first.Id); err != nil || job.State != JobQueued || job.Attempts != 0 {
+		t.Errorf("Expecting: queued with no attempts, but got: %+v, %v", job, err)
+	}
+	claimed, _, _ = db.ClaimJob(time.Now(), time.Minute)
+	if err := db.CompleteJob(claimed.Id, json.RawMessage(`{"ok":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	job, err := db.GetJob(first.Id)
+	if err != nil || job.State != JobDone || string(job.Result) != `{"ok":true}` {
+		t.Errorf("Expecting: done with a result, but got: %+v, %v", job, err)
+	}
+	if all, _ := db.GetJobs(""); len(all) != 2 || all[0].Id != later.Id {
+		t.Errorf("Expecting: both jobs, but got: %+v", all)
+	}
+	if _, err := db.GetJob(later.Id + 100); !errors.Is(err, ErrJobDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrJobDoesNotExist, err)
+	}
+}
+
+func runChirpOrderTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("chronology@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent, err := db.CreateChirp(Chirp{Body: "posted just now", AuthorId: user.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := db.PutChirp(Chirp{Body: "imported from years ago", AuthorId: user.Id, CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for GetChirpsFromId with: desc, and expecting: chirp %d before chirp %d", recent.Id, old.Id)
+	chirps, err := db.GetChirpsFromId(user.Id, "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 || chirps[0].Id != recent.Id || chirps[1].Id != old.Id {
+		t.Errorf("Expecting: [%d %d], but got: %v", recent.Id, old.Id, chirps)
+	}
+	t.Logf("Starting test for GetChirpsFromId with: asc, and expecting: chirp %d before chirp %d", old.Id, recent.Id)
+	chirps, err = db.GetChirpsFromId(user.Id, "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 || chirps[0].Id != old.Id || chirps[1].Id != recent.Id {
+		t.Errorf("Expecting: [%d %d], but got: %v", old.Id, recent.Id, chirps)
+	}
+}
+
+func runJobLeaseTest(t *testing.T, db Storage) {
+	job, err := db.EnqueueJob(Job{Kind: "test", MaxAttempts: 3, RequestId: "req-9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claimed, found, err := db.ClaimJob(now, time.Minute)
+	if err != nil || !found || claimed.Id != job.Id || claimed.RequestId != "req-9" {
+		t.Fatalf("Expecting: job %d claimed, but got: %+v, %v", job.Id, claimed, err)
+	}
+
+	t.Logf("Starting test for ClaimJob with: job %d's lease still held, and expecting: nothing due", job.Id)
+	if _, found, _ := db.ClaimJob(now.Add(30*time.Second), time.Minute); found {
+		t.Errorf("Expecting: no due jobs, but got one")
+	}
+	if err := db.ExtendJobLease(job.Id, claimed.Attempts, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := db.ClaimJob(now.Add(90*time.Second), time.Minute); found {
+		t.Errorf("Expecting: no due jobs after renewing the lease, but got one")
+	}
+
+	t.Logf("Starting test for ClaimJob with: job %d's lease run out, and expecting: a second attempt", job.Id)
+	reclaimed, found, err := db.ClaimJob(now.Add(3*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || reclaimed.Id != job.Id || reclaimed.Attempts != 2 || reclaimed.LastError != "lease expired" {
+		t.Errorf("Expecting: job %d reclaimed on attempt 2, but got: %+v", job.Id, reclaimed)
+	}
+	if err := db.ExtendJobLease(job.Id, claimed.Attempts, now.Add(4*time.Minute)); err != ErrJobLeaseLost {
+		t.Errorf("Expecting: %v, but got: %v", ErrJobLeaseLost, err)
+	}
+	if err := db.ExtendJobLease(job.Id+100, 1, now); !errors.Is(err, ErrJobDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrJobDoesNotExist, err)
+	}
+	if err := db.CompleteJob(job.Id, json.RawMessage(`null`)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func runBackupTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("backup@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "before the backup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backup bytes.Buffer
+	if err := db.Backup(&backup); err != nil {
+		t.Fatal(err)
+	}
+	lost, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "after the backup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for Restore with: a backup taken before chirp %d, and expecting: chirp %d only", lost.Id, kept.Id)
+	if err := db.Restore(bytes.NewReader(backup.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := db.GetChirp(kept.Id); err != nil || !found {
+		t.Errorf("Expecting: chirp %d, but got: %t, %v", kept.Id, found, err)
+	}
+	if _, found, err := db.GetChirp(lost.Id); err != nil || found {
+		t.Errorf("Expecting: no chirp %d, but got: %t, %v", lost.Id, found, err)
+	}
+	if _, err := db.GetUser("backup@example.com"); err != nil {
+		t.Errorf("Expecting: the user from the backup, but got: %v", err)
+	}
+	if _, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "after the restore"}); err != nil {
+		t.Errorf("Expecting: writes to work after restoring, but got: %v", err)
+	}
+
+	t.Logf("Starting test for Restore with: garbage, and expecting: %v", ErrInvalidBackup)
+	if err := db.Restore(bytes.NewReader([]byte("not a backup"))); !errors.Is(err, ErrInvalidBackup) {
+		t.Errorf("Expecting: %v, but got: %v", ErrInvalidBackup, err)
+	}
+	if _, found, err := db.GetChirp(kept.Id); err != nil || !found {
+		t.Errorf("Expecting: chirp %d left alone, but got: %t, %v", kept.Id, found, err)
+	}
+}
+
+func runShortIdTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("shortid@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ShortId == "" || first.ShortId == second.ShortId {
+		t.Errorf("Expecting: distinct short ids, but got: %q and %q", first.ShortId, second.ShortId)
+	}
+
+	t.Logf("Starting test for GetChirpByShortId with: %q, and expecting: chirp %d", second.ShortId, second.Id)
+	got, found, err := db.GetChirpByShortId(second.ShortId)
+	if err != nil || !found || got.Id != second.Id || got.ShortId != second.ShortId {
+		t.Errorf("Expecting: chirp %d, but got: %+v, %t, %v", second.Id, got, found, err)
+	}
+	if _, found, err := db.GetChirpByShortId("nope"); err != nil || found {
+		t.Errorf("Expecting: no chirp, but got: %t, %v", found, err)
+	}
+	if err := db.DeleteChirp(second.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := db.GetChirpByShortId(second.ShortId); err != nil || found {
+		t.Errorf("Expecting: no chirp after deleting it, but got: %t, %v", found, err)
+	}
+}
+
+// runShortIdUpgradeTest opens a file from before short ids and checks the
+// ids given to its chirps are saved rather than made up on every load.
+func runShortIdUpgradeTest(t *testing.T) {
+	path := "./test_short_ids.gob"
+	defer os.Remove(path)
+	defer os.Remove(path + journalSuffix)
+	db := &DB{path: path, mux: &sync.RWMutex{}}
+	legacy := DBStructure{NextChirpId: 2, Chirps: map[int]Chirp{1: {Id: 1, Body: "old"}}, Upgrades: 2}
+	if err := db.writeDB(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for NewDB with: a chirp without a short id, and expecting: one assigned and kept")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, _, err := db.GetChirp(1)
+	if err != nil || chirp.ShortId == "" {
+		t.Fatalf("Expecting: a short id, but got: %+v, %v", chirp, err)
+	}
+	reopened, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, found, err := reopened.GetChirpByShortId(chirp.ShortId)
+	if err != nil || !found || got.Id != 1 {
+		t.Errorf("Expecting: chirp 1 by %q, but got: %+v, %t, %v", chirp.ShortId, got, found, err)
+	}
+}
+
+func runSoftDeleteTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("softdelete@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "parent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "#softdeleted reply", ParentId: &parent.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LikeChirp(reply.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for DeleteChirp with: chirp %d, and expecting: it hidden from reads", reply.Id)
+	if err := db.DeleteChirp(reply.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := db.GetChirp(reply.Id); err != nil || found {
+		t.Errorf("Expecting: no chirp %d, but got: %t, %v", reply.Id, found, err)
+	}
+	if replies, err := db.GetReplies(parent.Id, "asc"); err != nil || len(replies) != 0 {
+		t.Errorf("Expecting: no replies, but got: %v, %v", replies, err)
+	}
+	if got, _, err := db.GetChirp(parent.Id); err != nil || got.ReplyCount != 0 {
+		t.Errorf("Expecting: a reply count of 0, but got: %d, %v", got.ReplyCount, err)
+	}
+	if tagged, err := db.GetChirpsByTag("softdeleted", "asc"); err != nil || len(tagged) != 0 {
+		t.Errorf("Expecting: no tagged chirps, but got: %v, %v", tagged, err)
+	}
+	if got, found, err := db.GetChirpWithDeleted(reply.Id); err != nil || !found || got.DeletedAt == nil {
+		t.Errorf("Expecting: chirp %d marked deleted, but got: %+v, %t, %v", reply.Id, got, found, err)
+	}
+	if thread, err := db.GetDescendantsWithDeleted(parent.Id); err != nil || len(thread) != 1 || thread[0].Id != reply.Id {
+		t.Errorf("Expecting: chirp %d in the thread, but got: %v, %v", reply.Id, thread, err)
+	}
+	if err := db.LikeChirp(reply.Id, user.Id); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+	if err := db.DeleteChirp(reply.Id, user.Id); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for RestoreChirp with: chirp %d, and expecting: it back with its like", reply.Id)
+	restored, err := db.RestoreChirp(reply.Id)
+	if err != nil || restored.DeletedAt != nil {
+		t.Fatalf("Expecting: chirp %d restored, but got: %+v, %v", reply.Id, restored, err)
+	}
+	if got, found, err := db.GetChirp(reply.Id); err != nil || !found || got.LikeCount != 1 {
+		t.Errorf("Expecting: chirp %d with 1 like, but got: %+v, %t, %v", reply.Id, got, found, err)
+	}
+	if got, _, err := db.GetChirp(parent.Id); err != nil || got.ReplyCount != 1 {
+		t.Errorf("Expecting: a reply count of 1, but got: %d, %v", got.ReplyCount, err)
+	}
+	if tagged, err := db.GetChirpsByTag("softdeleted", "asc"); err != nil || len(tagged) != 1 {
+		t.Errorf("Expecting: 1 tagged chirp, but got: %v, %v", tagged, err)
+	}
+	if _, err := db.RestoreChirp(reply.Id); !errors.Is(err, ErrChirpNotDeleted) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpNotDeleted, err)
+	}
+	if _, err := db.RestoreChirp(reply.Id + 100); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+
+	// Clear out chirps deleted by earlier tests.
+	if _, err := db.PurgeChirps(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for PurgeChirps with: chirp %d deleted, and expecting: it gone for good once old enough", reply.Id)
+	if err := db.DeleteChirp(reply.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := db.PurgeChirps(time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("Expecting: 0 purged, but got: %d, %v", purged, err)
+	}
+	if purged, err := db.PurgeChirps(time.Now().Add(time.Minute)); err != nil || purged != 1 {
+		t.Errorf("Expecting: 1 purged, but got: %d, %v", purged, err)
+	}
+	if _, err := db.RestoreChirp(reply.Id); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+	if liked, err := db.GetLikedChirps(user.Id); err != nil || len(liked) != 0 {
+		t.Errorf("Expecting: no liked chirps, but got: %v, %v", liked, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	runErrorIsTest(t, notFound(ErrJobDoesNotExist, 7), ErrJobDoesNotExist, true)
+	runErrorIsTest(t, notFound(ErrJobDoesNotExist, 7), notFound(ErrJobDoesNotExist, 7), true)
+	runErrorIsTest(t, notFound(ErrJobDoesNotExist, 7), notFound(ErrJobDoesNotExist, 8), false)
+	runErrorIsTest(t, notFound(ErrJobDoesNotExist, 7), ErrChirpDoesNotExist, false)
+	runErrorIsTest(t, fmt.Errorf("claiming: %w", notFound(ErrChirpDoesNotExist, 3)), ErrChirpDoesNotExist, true)
+	runErrorIsTest(t, ErrHandleTaken, ErrUserAlreadyExists, false)
+
+	var notFoundErr *NotFoundError
+	if err := fmt.Errorf("loading: %w", notFound(ErrMediaDoesNotExist, "abc")); !errors.As(err, &notFoundErr) || notFoundErr.ID != "abc" {
+		t.Errorf("Expecting: a NotFoundError for abc, but got: %v", err)
+	}
+	var conflict *ConflictError
+	if !errors.As(fmt.Errorf("saving: %w", ErrAlreadyAppealed), &conflict) {
+		t.Errorf("Expecting: a ConflictError, but got: none")
+	}
+}
+
+func runErrorIsTest(t *testing.T, err, target error, expecting bool) {
+	t.Logf("Starting test for errors.Is with: %v and %v, and expecting: %t", err, target, expecting)
+	if got := errors.Is(err, target); got != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, got)
+	}
+}
+
+func runAdminTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("admin@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for SetAdmin with: user %d, and expecting: the flag stored", user.Id)
+	if _, err := db.SetAdmin(user.Id, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetUser("admin@example.com"); err != nil || !got.IsAdmin {
+		t.Errorf("Expecting: an admin, but got: %v, %v", got.IsAdmin, err)
+	}
+	if got, err := db.SetAdmin(user.Id, false); err != nil || got.IsAdmin {
+		t.Errorf("Expecting: no admin, but got: %v, %v", got.IsAdmin, err)
+	}
+	if _, err := db.SetAdmin(-1, true); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for GetUsers with: user %d, and expecting: every user by id", user.Id)
+	users, err := db.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) == 0 || users[len(users)-1].Id != user.Id {
+		t.Errorf("Expecting: user %d last, but got: %v", user.Id, users)
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i-1].Id >= users[i].Id {
+			t.Errorf("Expecting: users ordered by id, but got: %d before %d", users[i-1].Id, users[i].Id)
+		}
+	}
+
+	t.Logf("Starting test for LiftSuspensions with: user %d, and expecting: 2 lifted", user.Id)
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+	for _, until := range []*time.Time{nil, &later, &earlier} {
+		if _, err := db.CreateModerationAction(ModerationAction{UserId: user.Id, Kind: ActionSuspend, Reason: "spam", Until: until}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lifted, err := db.LiftSuspensions(user.Id, now); err != nil || lifted != 2 {
+		t.Errorf("Expecting: 2, but got: %d, %v", lifted, err)
+	}
+	if suspended, err := db.IsSuspended(user.Id, now); err != nil || suspended {
+		t.Errorf("Expecting: not suspended, but got: %t, %v", suspended, err)
+	}
+	if lifted, err := db.LiftSuspensions(user.Id, now); err != nil || lifted != 0 {
+		t.Errorf("Expecting: 0, but got: %d, %v", lifted, err)
+	}
+	if _, err := db.LiftSuspensions(-1, now); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+}
+
+func runDeleteUserTest(t *testing.T, db Storage) {
+	leaving, err := db.CreateUser("leaving@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staying, err := db.CreateUser("staying@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := db.CreateChirp(Chirp{AuthorId: staying.Id, Body: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := db.CreateChirp(Chirp{AuthorId: leaving.Id, Body: "reply", ParentId: &root.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LikeChirp(root.Id, leaving.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Follow(staying.Id, leaving.Id); err != nil {
+		t.Fatal(err)
+	}
+	_, refreshToken, err := db.CreateSession(leaving.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := db.CreateAPIKey(leaving.Id, "bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for RequestUserDeletion with: user %d, and expecting: marked, logged out and their API keys revoked", leaving.Id)
+	now := time.Now()
+	if got, err := db.RequestUserDeletion(leaving.Id, now); err != nil || got.DeletionRequestedAt == nil {
+		t.Fatalf("Expecting: a deletion request, but got: %+v, %v", got, err)
+	}
+	if _, _, err := db.RotateSession(refreshToken, time.Hour); !errors.Is(err, ErrSessionDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrSessionDoesNotExist, err)
+	}
+	if _, found, err := db.GetAPIKey(key.Id); err != nil || found {
+		t.Errorf("Expecting: key %s revoked, but got: %t, %v", key.Id, found, err)
+	}
+	if purged, err := db.PurgeUsers(now.Add(-time.Minute)); err != nil || purged != 0 {
+		t.Errorf("Expecting: 0 purged within the grace period, but got: %d, %v", purged, err)
+	}
+
+	t.Logf("Starting test for CancelUserDeletion with: user %d, and expecting: the request cleared", leaving.Id)
+	if err := db.CancelUserDeletion(leaving.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetUserById(leaving.Id); err != nil || got.DeletionRequestedAt != nil {
+		t.Errorf("Expecting: no deletion request, but got: %v, %v", got.DeletionRequestedAt, err)
+	}
+	if purged, err := db.PurgeUsers(now.Add(time.Minute)); err != nil || purged != 0 {
+		t.Errorf("Expecting: 0 purged, but got: %d, %v", purged, err)
+	}
+
+	t.Logf("Starting test for PurgeUsers with: user %d past the grace period, and expecting: the user and their traces gone", leaving.Id)
+	if _, err := db.RequestUserDeletion(leaving.Id, now); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := db.PurgeUsers(now.Add(time.Minute)); err != nil || purged != 1 {
+		t.Errorf("Expecting: 1 purged, but got: %d, %v", purged, err)
+	}
+	if _, err := db.GetUserById(leaving.Id); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+	if got, found, err := db.GetChirpWithDeleted(reply.Id); err != nil || !found || got.DeletedAt == nil {
+		t.Errorf("Expecting: chirp %d deleted, but got: %+v, %t, %v", reply.Id, got, found, err)
+	}
+	if got, _, err := db.GetChirp(root.Id); err != nil || got.LikeCount != 0 || got.ReplyCount != 0 {
+		t.Errorf("Expecting: no likes or replies left, but got: %d likes, %d replies, %v", got.LikeCount, got.ReplyCount, err)
+	}
+	if following, err := db.GetFollowing(staying.Id); err != nil || len(following) != 0 {
+		t.Errorf("Expecting: no follows left, but got: %v, %v", following, err)
+	}
+	if err := db.DeleteUser(leaving.Id); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+}
+
+func runExportUserTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("export@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	friend, err := db.CreateUser("exportfriend@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "mine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := db.CreateChirp(Chirp{AuthorId: friend.Id, Body: "theirs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LikeChirp(other.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Follow(user.Id, friend.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Follow(friend.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for ExportUser with: user %d, and expecting: their profile, chirp, like and follows", user.Id)
+	export, err := db.ExportUser(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Profile.Email != user.Email || export.Profile.Password != nil {
+		t.Errorf("Expecting: %s without a password hash, but got: %+v", user.Email, export.Profile)
+	}
+	if len(export.Chirps) != 1 || export.Chirps[0].Id != chirp.Id {
+		t.Errorf("Expecting: chirp %d, but got: %v", chirp.Id, export.Chirps)
+	}
+	if len(export.Likes) != 1 || export.Likes[0].ChirpId != other.Id {
+		t.Errorf("Expecting: a like of chirp %d, but got: %v", other.Id, export.Likes)
+	}
+	if len(export.Following) != 1 || export.Following[0].FolloweeId != friend.Id {
+		t.Errorf("Expecting: following %d, but got: %v", friend.Id, export.Following)
+	}
+	if len(export.Followers) != 1 || export.Followers[0].FollowerId != friend.Id {
+		t.Errorf("Expecting: followed by %d, but got: %v", friend.Id, export.Followers)
+	}
+	if _, err := db.ExportUser(-1); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+}
+
+func runChirpsSinceTest(t *testing.T, db Storage) {
+	since := time.Now()
+	first, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "since one"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "since two"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "since deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteChirp(deleted.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	expecting := []int{first.Id, second.Id}
+	t.Logf("Starting test for GetChirpsSince with: %s, and expecting: %v", since, expecting)
+	chirps, err := db.GetChirpsSince(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int{}
+	for _, chirp := range chirps {
+		got = append(got, chirp.Id)
+	}
+	if !reflect.DeepEqual(got, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, got)
+	}
+}
+
+func runStatsTest(t *testing.T, db Storage) {
+	before, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateUser("stats@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "counted"}); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "not counted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteChirp(deleted.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.EnqueueJob(Job{Kind: "stats"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for Stats with: a new user, two chirps with one deleted and a queued job, and expecting: one more of each")
+	after, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Users != before.Users+1 {
+		t.Errorf("Expecting: %d users, but got: %d", before.Users+1, after.Users)
+	}
+	if after.Chirps != before.Chirps+1 {
+		t.Errorf("Expecting: %d chirps, but got: %d", before.Chirps+1, after.Chirps)
+	}
+	if after.Jobs[JobQueued] != before.Jobs[JobQueued]+1 {
+		t.Errorf("Expecting: %d queued jobs, but got: %d", before.Jobs[JobQueued]+1, after.Jobs[JobQueued])
+	}
+	if after.SizeBytes <= 0 {
+		t.Errorf("Expecting: a positive size, but got: %d", after.SizeBytes)
+	}
+}
+
+func runDeviceAuthorizationTest(t *testing.T, db Storage) {
+	auth, deviceCode, err := db.CreateDeviceAuthorization(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for PollDeviceAuthorization with: a pending code, and expecting: %s", DevicePending)
+	first := time.Now()
+	polled, err := db.PollDeviceAuthorization(deviceCode, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polled.State != DevicePending || polled.PolledAt != nil {
+		t.Errorf("Expecting: pending and never polled, but got: %+v", polled)
+	}
+	polled, err = db.PollDeviceAuthorization(deviceCode, first.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polled.PolledAt == nil || polled.PolledAt.Sub(first).Abs() > time.Millisecond {
+		t.Errorf("Expecting: polled at %s, but got: %v", first, polled.PolledAt)
+	}
+
+	lowered := strings.ToLower(strings.ReplaceAll(auth.UserCode, "-", ""))
+	t.Logf("Starting test for DecideDeviceAuthorization with: %s, and expecting: %s", lowered, DeviceApproved)
+	decided, err := db.DecideDeviceAuthorization(lowered, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decided.State != DeviceApproved || decided.UserId != 1 {
+		t.Errorf("Expecting: approved by 1, but got: %+v", decided)
+	}
+	if _, err := db.DecideDeviceAuthorization(auth.UserCode, 1, false); !errors.Is(err, ErrDeviceCodeUsed) {
+		t.Errorf("Expecting: %v, but got: %v", ErrDeviceCodeUsed, err)
+	}
+
+	t.Logf("Starting test for PollDeviceAuthorization with: an approved code, and expecting: approved once")
+	polled, err = db.PollDeviceAuthorization(deviceCode, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polled.State != DeviceApproved || polled.UserId != 1 {
+		t.Errorf("Expecting: approved by 1, but got: %+v", polled)
+	}
+	if _, err := db.PollDeviceAuthorization(deviceCode, time.Now()); !errors.Is(err, ErrDeviceCodeDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrDeviceCodeDoesNotExist, err)
+	}
+
+	expired, _, err := db.CreateDeviceAuthorization(-time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for GetDeviceAuthorization with: an expired code, and expecting: %v", ErrDeviceCodeDoesNotExist)
+	if _, err := db.GetDeviceAuthorization(expired.UserCode); !errors.Is(err, ErrDeviceCodeDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrDeviceCodeDoesNotExist, err)
+	}
+}
+
+func runTOTPTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("totp@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for EnableTOTP with: an enrolled secret, and expecting: it is enabled with hashed recovery codes")
+	if err := db.SetTOTPSecret(user.Id, []byte("sealed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnableTOTP(user.Id, []string{"aaaaa-bbbbb", "ccccc-ddddd"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err = db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.TOTPEnabled || string(user.TOTPSecret) != "sealed" || len(user.RecoveryCodes) != 2 || user.RecoveryCodes[0] == "aaaaa-bbbbb" {
+		t.Errorf("Expecting: enabled with two hashed codes, but got: %+v", user)
+	}
+	if err := db.SetTOTPSecret(user.Id, []byte("other")); !errors.Is(err, ErrTOTPEnabled) {
+		t.Errorf("Expecting: %v, but got: %v", ErrTOTPEnabled, err)
+	}
+	if err := db.EnableTOTP(user.Id, nil); !errors.Is(err, ErrTOTPEnabled) {
+		t.Errorf("Expecting: %v, but got: %v", ErrTOTPEnabled, err)
+	}
+
+	t.Logf("Starting test for ReplaceTOTPSecret with: an enabled secret, and expecting: it is replaced and stays enabled")
+	if err := db.ReplaceTOTPSecret(user.Id, []byte("resealed")); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := db.GetUserById(user.Id); err != nil || !user.TOTPEnabled || string(user.TOTPSecret) != "resealed" {
+		t.Errorf("Expecting: an enabled, resealed secret, but got: %+v, %v", user, err)
+	}
+	if err := db.ReplaceTOTPSecret(user.Id+1000, []byte("resealed")); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for UseRecoveryCode with: a code used twice, and expecting: true, then false")
+	for i, expecting := range []bool{true, false} {
+		used, err := db.UseRecoveryCode(user.Id, "AAAAABBBBB")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != expecting {
+			t.Errorf("Expecting: %v on use %d, but got: %v", expecting, i+1, used)
+		}
+	}
+	if used, err := db.UseRecoveryCode(user.Id, "ccccc-ddddd"); err != nil || !used {
+		t.Errorf("Expecting: the other code to work, but got: %v, %v", used, err)
+	}
+
+	for _, c := range []struct {
+		step      int64
+		expecting bool
+	}{{100, true}, {100, false}, {99, false}, {101, true}} {
+		t.Logf("Starting test for UseTOTPStep with: step %d, and expecting: %v", c.step, c.expecting)
+		if used, err := db.UseTOTPStep(user.Id, c.step); err != nil || used != c.expecting {
+			t.Errorf("Expecting: %v, but got: %v, %v", c.expecting, used, err)
+		}
+	}
+	if got, err := db.GetUserById(user.Id); err != nil || got.TOTPLastStep != 101 {
+		t.Errorf("Expecting: last step 101, but got: %d, %v", got.TOTPLastStep, err)
+	}
+	if _, err := db.UseTOTPStep(user.Id+1000, 1); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for UseTOTPStep with: 8 concurrent logins with step 200, and expecting: exactly 1 accepted")
+	var wg sync.WaitGroup
+	var accepted atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			used, err := db.UseTOTPStep(user.Id, 200)
+			if err != nil {
+				t.Error(err)
+			}
+			if used {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted.Load() != 1 {
+		t.Errorf("Expecting: 1, but got: %d", accepted.Load())
+	}
+}
+
+func runLoginThrottleTest(t *testing.T, db Storage) {
+	policy := LockoutPolicy{Limit: 3, Window: time.Minute, Duration: time.Hour}
+	start := time.Now()
+	t.Logf("Starting test for RecordLoginFailure with: %d failures, and expecting: locked out on the last", policy.Limit)
+	var throttle LoginThrottle
+	var err error
+	for i := 0; i < policy.Limit; i++ {
+		if throttle.Locked(start) {
+			t.Fatalf("Expecting: no lockout after %d failures, but got: %+v", i, throttle)
+		}
+		throttle, err = db.RecordLoginFailure(ThrottleAccount, "lock@example.com", start.Add(time.Duration(i)*time.Second), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !throttle.Locked(start.Add(time.Minute)) || throttle.Locked(start.Add(2*time.Hour)) {
+		t.Errorf("Expecting: locked for an hour, but got: %+v", throttle)
+	}
+	stored, err := db.GetLoginThrottle(ThrottleAccount, "lock@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Failures != policy.Limit || !stored.Locked(start.Add(time.Minute)) {
+		t.Errorf("Expecting: %+v, but got: %+v", throttle, stored)
+	}
+	lockouts, err := db.ActiveLockouts(start.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockouts[ThrottleAccount] != 1 || lockouts[ThrottleIP] != 0 {
+		t.Errorf("Expecting: one locked account, but got: %v", lockouts)
+	}
+
+	t.Logf("Starting test for RecordLoginFailure with: failures further apart than the window, and expecting: no lockout")
+	for i := 0; i < policy.Limit; i++ {
+		throttle, err = db.RecordLoginFailure(ThrottleIP, "192.0.2.1", start.Add(time.Duration(i)*policy.Window), policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if throttle.Failures != 1 || throttle.LockedUntil != nil {
+		t.Errorf("Expecting: one failure, but got: %+v", throttle)
+	}
+
+	t.Logf("Starting test for ResetLoginFailures with: a locked account, and expecting: no failures")
+	if err := db.ResetLoginFailures(ThrottleAccount, "lock@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := db.GetLoginThrottle(ThrottleAccount, "lock@example.com"); err != nil || stored.Failures != 0 || stored.Locked(start) {
+		t.Errorf("Expecting: no failures, but got: %+v, %v", stored, err)
+	}
+}
+
+func runUploadTest(t *testing.T, db Storage) {
+	start := time.Now()
+	upload, err := db.CreateUpload(Upload{OwnerId: 1, Length: 10, AltText: "A cat", ExpiresAt: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for AppendUploadChunk with: two chunks, and expecting: the offset and chunks to add up")
+	if _, err := db.AppendUploadChunk(upload.Id, 0, "chunk-a", 4); err != nil {
+		t.Fatal(err)
+	}
+	appended, err := db.AppendUploadChunk(upload.Id, 4, "chunk-b", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetUpload(upload.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appended.Offset != 10 || stored.Offset != 10 || !reflect.DeepEqual(stored.Chunks, []string{"chunk-a", "chunk-b"}) {
+		t.Errorf("Expecting: offset 10 with chunk-a and chunk-b, but got: %+v and %+v", appended, stored)
+	}
+
+	t.Logf("Starting test for AppendUploadChunk with: a stale offset, and expecting: %v", ErrUploadOffsetMismatch)
+	if _, err := db.AppendUploadChunk(upload.Id, 4, "chunk-c", 6); !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUploadOffsetMismatch, err)
+	}
+	if _, err := db.AppendUploadChunk("missing", 0, "chunk-c", 6); !errors.Is(err, ErrUploadDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUploadDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for GetExpiredUploads with: one upload past its expiry, and expecting: only that one")
+	expired, err := db.GetExpiredUploads(start.Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].Id != upload.Id {
+		t.Errorf("Expecting: %s, but got: %+v", upload.Id, expired)
+	}
+	if expired, _ := db.GetExpiredUploads(start); len(expired) != 0 {
+		t.Errorf("Expecting: none, but got: %+v", expired)
+	}
+
+	if err := db.DeleteUpload(upload.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUpload(upload.Id); !errors.Is(err, ErrUploadDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUploadDoesNotExist, err)
+	}
+}
+
+func runBlockedWordsTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for GetBlockedWords with: a new database, and expecting: %v", DefaultBlockedWords)
+	words, err := db.GetBlockedWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != len(DefaultBlockedWords) || words[0].Pattern != "fornax" {
+		t.Errorf("Expecting: %v, but got: %+v", DefaultBlockedWords, words)
+	}
+
+	t.Logf("Starting test for AddBlockedWord with: a phrase added twice, and expecting: it listed once")
+	first, err := db.AddBlockedWord("what the fork*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := db.AddBlockedWord("what the fork*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("Expecting: %v, but got: %v", first, again)
+	}
+	if words, _ := db.GetBlockedWords(); len(words) != len(DefaultBlockedWords)+1 {
+		t.Errorf("Expecting: %d words, but got: %+v", len(DefaultBlockedWords)+1, words)
+	}
+
+	if err := db.DeleteBlockedWord("what the fork*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteBlockedWord("what the fork*"); !errors.Is(err, ErrBlockedWordDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrBlockedWordDoesNotExist, err)
+	}
+}
+
+func runScheduledChirpTest(t *testing.T, db Storage) {
+	now := time.Now().UTC()
+	later, err := db.CreateScheduledChirp(ScheduledChirp{AuthorId: 7, Body: "Later", PublishAt: now.Add(time.Hour), PublishAtLocal: "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	soon, err := db.CreateScheduledChirp(ScheduledChirp{AuthorId: 7, Body: "Soon", PublishAt: now.Add(time.Minute), Censored: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := db.CreateScheduledChirp(ScheduledChirp{AuthorId: 8, Body: "Other", PublishAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for GetScheduledChirps with: author 7, and expecting: %d then %d", soon.Id, later.Id)
+	chirps, err := db.GetScheduledChirps(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 || chirps[0].Id != soon.Id || chirps[1].PublishAtLocal != "local" || !chirps[0].Censored {
+		t.Errorf("Expecting: %d then %d, but got: %+v", soon.Id, later.Id, chirps)
+	}
+
+	t.Logf("Starting test for DeleteScheduledChirp with: another author's chirp, and expecting: %v", ErrScheduledChirpDoesNotExist)
+	if err := db.DeleteScheduledChirp(other.Id, 7); !errors.Is(err, ErrScheduledChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrScheduledChirpDoesNotExist, err)
+	}
+	if err := db.DeleteScheduledChirp(other.Id, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for TakeDueScheduledChirps with: a time past the first, and expecting: only %d, taken once", soon.Id)
+	due, err := db.TakeDueScheduledChirps(now.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].Id != soon.Id || due[0].Chirp().Body != "Soon" {
+		t.Errorf("Expecting: %d, but got: %+v", soon.Id, due)
+	}
+	if due, _ := db.TakeDueScheduledChirps(now.Add(30 * time.Minute)); len(due) != 0 {
+		t.Errorf("Expecting: nothing, but got: %+v", due)
+	}
+	if chirps, _ := db.GetScheduledChirps(7); len(chirps) != 1 || chirps[0].Id != later.Id {
+		t.Errorf("Expecting: %d, but got: %+v", later.Id, chirps)
+	}
+}
+
+func runMediaDedupTest(t *testing.T, db Storage) {
+	first, err := db.CreateMedia(Media{OwnerId: 1, Key: "first", Hash: "abc", ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Starting test for CreateMedia with: a hash already stored, and expecting: the blob %q shared", first.Key)
+	second, err := db.CreateMedia(Media{OwnerId: 2, Key: "second", Hash: "abc", ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Key != "first" || second.Id == first.Id {
+		t.Errorf("Expecting: new media on blob first, but got: %+v", second)
+	}
+	if stored, _ := db.GetMedia(second.Id); stored.Key != "first" || stored.Hash != "abc" {
+		t.Errorf("Expecting: blob first, but got: %+v", stored)
+	}
+
+	t.Logf("Starting test for DeleteMedia with: media sharing a blob, and expecting: the blob orphaned only by the last")
+	if orphaned, err := db.DeleteMedia(first.Id); err != nil || orphaned != "" {
+		t.Errorf("Expecting: nothing orphaned, but got: %q, %v", orphaned, err)
+	}
+	if orphaned, err := db.DeleteMedia(second.Id); err != nil || orphaned != "first" {
+		t.Errorf("Expecting: first orphaned, but got: %q, %v", orphaned, err)
+	}
+	if _, err := db.DeleteMedia(second.Id); !errors.Is(err, ErrMediaDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrMediaDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for CreateMedia with: a hash whose blob was orphaned, and expecting: the new blob kept")
+	third, err := db.CreateMedia(Media{OwnerId: 1, Key: "third", Hash: "abc", ContentType: "image/png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Key != "third" {
+		t.Errorf("Expecting: third, but got: %q", third.Key)
+	}
+	unhashed, _ := db.CreateMedia(Media{OwnerId: 1, Key: "unhashed", ContentType: "image/png"})
+	if orphaned, _ := db.DeleteMedia(unhashed.Id); orphaned != "unhashed" {
+		t.Errorf("Expecting: unhashed orphaned, but got: %q", orphaned)
+	}
+}
+
+func runDraftTest(t *testing.T, db Storage) {
+	first, err := db.CreateDraft(Draft{AuthorId: 7, Body: "First", Media: []Attachment{{Id: "abc", AltText: "A cat"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateDraft(Draft{AuthorId: 7, Body: "Second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateDraft(Draft{AuthorId: 8, Body: "Other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for UpdateDraft with: %d edited, and expecting: it listed first", first.Id)
+	parentId := 3
+	updated, err := db.UpdateDraft(Draft{Id: first.Id, AuthorId: 7, Body: "Edited", ParentId: &parentId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Body != "Edited" || updated.ParentId == nil || len(updated.Media) != 0 || updated.UpdatedAt.Before(first.UpdatedAt) {
+		t.Errorf("Expecting: the edited draft, but got: %+v", updated)
+	}
+	drafts, err := db.GetDrafts(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts) != 2 || drafts[0].Id != first.Id || drafts[1].Id != second.Id {
+		t.Errorf("Expecting: %d then %d, but got: %+v", first.Id, second.Id, drafts)
+	}
+
+	t.Logf("Starting test for GetDraft, UpdateDraft and DeleteDraft with: another author, and expecting: %v", ErrDraftDoesNotExist)
+	if _, err := db.GetDraft(first.Id, 8); !errors.Is(err, ErrDraftDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrDraftDoesNotExist, err)
+	}
+	if _, err := db.UpdateDraft(Draft{Id: first.Id, AuthorId: 8}); !errors.Is(err, ErrDraftDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrDraftDoesNotExist, err)
+	}
+	if err := db.DeleteDraft(first.Id, 8); !errors.Is(err, ErrDraftDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrDraftDoesNotExist, err)
+	}
+	if err := db.DeleteDraft(first.Id, 7); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetDraft(second.Id, 7); err != nil || got.Body != "Second" {
+		t.Errorf("Expecting: Second, but got: %+v, %v", got, err)
+	}
+}
+
+func runOrphanedMediaTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("orphans@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string) Media {
+		media, err := db.CreateMedia(Media{OwnerId: user.Id, Key: name, ContentType: "image/png"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return media
+	}
+	orphan, attached, deleted, drafted, scheduled, avatar := create("orphan"), create("attached"), create("deleted"), create("drafted"), create("scheduled"), create("avatar")
+
+	chirp, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "With media", Media: []Attachment{{Id: attached.Id}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := db.CreateChirp(Chirp{AuthorId: user.Id, Body: "Deleted", Media: []Attachment{{Id: deleted.Id}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteChirp(gone.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateDraft(Draft{AuthorId: user.Id, Media: []Attachment{{Id: drafted.Id}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateScheduledChirp(ScheduledChirp{AuthorId: user.Id, Media: []Attachment{{Id: scheduled.Id}}, PublishAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	avatarURL := "https://chirpy.example/media/" + avatar.Id
+	if _, err := db.UpdateProfile(user.Id, ProfileUpdate{AvatarURL: &avatarURL}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for GetOrphanedMedia with: media attached to a chirp (%d), a deleted chirp, a draft, a scheduled chirp and an avatar, and expecting: only %s", chirp.Id, orphan.Id)
+	orphaned, err := db.GetOrphanedMedia(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, media := range orphaned {
+		switch media.Id {
+		case orphan.Id:
+			found = true
+		case attached.Id, deleted.Id, drafted.Id, scheduled.Id, avatar.Id:
+			t.Errorf("Expecting: %s kept, but got: it orphaned", media.Key)
+		}
+	}
+	if !found {
+		t.Errorf("Expecting: %s orphaned, but got: %+v", orphan.Id, orphaned)
+	}
+
+	t.Logf("Starting test for GetOrphanedMedia with: a time before any upload, and expecting: nothing")
+	if orphaned, _ := db.GetOrphanedMedia(orphan.CreatedAt.Add(-time.Minute)); len(orphaned) != 0 {
+		t.Errorf("Expecting: nothing, but got: %+v", orphaned)
+	}
+}
+
+func runRechirpTest(t *testing.T, db Storage) {
+	original, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Worth sharing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rechirp, err := db.CreateChirp(Chirp{AuthorId: 2, RechirpOfId: &original.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := db.CreateChirp(Chirp{AuthorId: 3, Body: "So true", QuotedId: &original.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for CreateChirp with: a rechirp and a quote of %d, and expecting: one rechirp counted", original.Id)
+	if got, _, _ := db.GetChirp(original.Id); got.RechirpCount != 1 {
+		t.Errorf("Expecting: 1, but got: %d", got.RechirpCount)
+	}
+	if got, _, _ := db.GetChirp(quote.Id); got.QuotedId == nil || *got.QuotedId != original.Id {
+		t.Errorf("Expecting: a quote of %d, but got: %+v", original.Id, got)
+	}
+	if got, found, err := db.GetRechirp(2, original.Id); err != nil || !found || got.Id != rechirp.Id {
+		t.Errorf("Expecting: %d, but got: %+v, %t, %v", rechirp.Id, got, found, err)
+	}
+	if _, found, _ := db.GetRechirp(3, original.Id); found {
+		t.Errorf("Expecting: no rechirp by the quoter, but got: one")
+	}
+
+	t.Logf("Starting test for UpdateChirp with: a rechirp, and expecting: %v", ErrRechirpNotEditable)
+	if _, err := db.UpdateChirp(rechirp.Id, 2, "Now a quote", false); !errors.Is(err, ErrRechirpNotEditable) {
+		t.Errorf("Expecting: %v, but got: %v", ErrRechirpNotEditable, err)
+	}
+
+	t.Logf("Starting test for DeleteChirp and RestoreChirp with: the rechirp, and expecting: the count to follow")
+	if err := db.DeleteChirp(rechirp.Id, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := db.GetChirp(original.Id); got.RechirpCount != 0 {
+		t.Errorf("Expecting: 0, but got: %d", got.RechirpCount)
+	}
+	if _, found, _ := db.GetRechirp(2, original.Id); found {
+		t.Errorf("Expecting: the deleted rechirp gone, but got: it found")
+	}
+	if _, err := db.RestoreChirp(rechirp.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := db.GetChirp(original.Id); got.RechirpCount != 1 {
+		t.Errorf("Expecting: 1, but got: %d", got.RechirpCount)
+	}
+}
+
+func runBookmarkTest(t *testing.T, db Storage) {
+	first, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Read later"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Read this too"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for BookmarkChirp with: two chirps, one bookmarked twice, and expecting: the latest first")
+	for _, chirpId := range []int{first.Id, second.Id, second.Id} {
+		if err := db.BookmarkChirp(chirpId, 5); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	bookmarked, err := db.GetBookmarkedChirps(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bookmarked) != 2 || bookmarked[0].Id != second.Id || bookmarked[1].Id != first.Id {
+		t.Errorf("Expecting: %d then %d, but got: %+v", second.Id, first.Id, bookmarked)
+	}
+	if others, _ := db.GetBookmarkedChirps(6); len(others) != 0 {
+		t.Errorf("Expecting: no bookmarks for another user, but got: %+v", others)
+	}
+	if err := db.BookmarkChirp(9999, 5); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for GetBookmarkedChirps with: a bookmarked chirp deleted, and expecting: it left out but still removable")
+	if err := db.DeleteChirp(first.Id, 1); err != nil {
+		t.Fatal(err)
+	}
+	if bookmarked, _ := db.GetBookmarkedChirps(5); len(bookmarked) != 1 || bookmarked[0].Id != second.Id {
+		t.Errorf("Expecting: only %d, but got: %+v", second.Id, bookmarked)
+	}
+	if err := db.UnbookmarkChirp(first.Id, 5); err != nil {
+		t.Errorf("Expecting: no error, but got: %v", err)
+	}
+	if err := db.UnbookmarkChirp(first.Id, 5); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for UnbookmarkChirp with: the remaining bookmark, twice, and expecting: no bookmarks")
+	for i := 0; i < 2; i++ {
+		if err := db.UnbookmarkChirp(second.Id, 5); err != nil {
+			t.Errorf("Expecting: no error, but got: %v", err)
+		}
+	}
+	if bookmarked, _ := db.GetBookmarkedChirps(5); len(bookmarked) != 0 {
+		t.Errorf("Expecting: no bookmarks, but got: %+v", bookmarked)
+	}
+}
+
+func runWebsiteTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("website@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	website := "https://website.example/"
+
+	t.Logf("Starting test for VerifyWebsite with: no website set, and expecting: %v", ErrWebsiteChanged)
+	if _, err := db.VerifyWebsite(user.Id, ""); !errors.Is(err, ErrWebsiteChanged) {
+		t.Errorf("Expecting: %v, but got: %v", ErrWebsiteChanged, err)
+	}
+	if _, err := db.UpdateProfile(user.Id, ProfileUpdate{Website: &website}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyWebsite(user.Id, "https://old.example/"); !errors.Is(err, ErrWebsiteChanged) {
+		t.Errorf("Expecting: %v, but got: %v", ErrWebsiteChanged, err)
+	}
+
+	t.Logf("Starting test for VerifyWebsite with: the current website, and expecting: it verified until changed")
+	if got, err := db.VerifyWebsite(user.Id, website); err != nil || got.WebsiteVerifiedAt == nil || !got.Profile().WebsiteVerified {
+		t.Errorf("Expecting: a verified website, but got: %+v, %v", got, err)
+	}
+	bio := "Unrelated change"
+	if got, _ := db.UpdateProfile(user.Id, ProfileUpdate{Bio: &bio, Website: &website}); got.WebsiteVerifiedAt == nil {
+		t.Errorf("Expecting: still verified, but got: %+v", got)
+	}
+	moved := "https://moved.example/"
+	db.UpdateProfile(user.Id, ProfileUpdate{Website: &moved})
+	if got, _ := db.GetUserById(user.Id); got.Website != moved || got.WebsiteVerifiedAt != nil {
+		t.Errorf("Expecting: %s unverified, but got: %+v", moved, got)
+	}
+	if _, err := db.VerifyWebsite(9999, moved); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+}
+
+func runMovedAccountTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("moved@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := "https://old.example/api/users/4", "https://next.example/api/users/8"
+
+	t.Logf("Starting test for GetUserByMovedFrom with: %s, and expecting: the user who moved from it", from)
+	if _, found, _ := db.GetUserByMovedFrom(from); found {
+		t.Errorf("Expecting: nobody before the move, but got: someone")
+	}
+	if _, err := db.UpdateProfile(user.Id, ProfileUpdate{MovedFrom: &from, MovedTo: &to}); err != nil {
+		t.Fatal(err)
+	}
+	got, found, err := db.GetUserByMovedFrom(from)
+	if err != nil || !found || got.Id != user.Id || got.MovedTo != to || got.Profile().MovedTo != to {
+		t.Errorf("Expecting: %d moved to %s, but got: %+v, %t, %v", user.Id, to, got, found, err)
+	}
+	if _, found, _ := db.GetUserByMovedFrom(""); found {
+		t.Errorf("Expecting: nobody for an empty URL, but got: someone")
+	}
+}
+
+func runPollTest(t *testing.T, db Storage) {
+	chirp, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Tabs or spaces?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	poll, err := db.CreatePoll(chirp.Id, []string{"Tabs", "Spaces"}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreatePoll(9999, []string{"Yes", "No"}, time.Now().Add(time.Hour)); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for VotePoll with: two voters, and expecting: one vote each")
+	for userId, option := range map[int]int{2: 0, 3: 1} {
+		if _, err := db.VotePoll(chirp.Id, userId, option); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poll, err = db.VotePoll(chirp.Id, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if poll.VotesCount != 3 || poll.Options[0].Votes != 1 || poll.Options[1].Votes != 2 || poll.Options[1].Title != "Spaces" {
+		t.Errorf("Expecting: 1 for tabs and 2 for spaces, but got: %+v", poll)
+	}
+	for _, vote := range []struct {
+		chirpId, userId, option int
+		want                    error
+	}{
+		{chirp.Id, 2, 1, ErrAlreadyVoted},
+		{chirp.Id, 5, 2, ErrInvalidPollOption},
+		{chirp.Id, 5, -1, ErrInvalidPollOption},
+		{9999, 5, 0, ErrPollDoesNotExist},
+	} {
+		if _, err := db.VotePoll(vote.chirpId, vote.userId, vote.option); !errors.Is(err, vote.want) {
+			t.Errorf("Expecting: %v, but got: %v", vote.want, err)
+		}
+	}
+	polls, err := db.GetPolls([]int{chirp.Id, 9999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := polls[chirp.Id]; len(polls) != 1 || got.VotesCount != 3 || got.Options[1].Votes != 2 {
+		t.Errorf("Expecting: the tallied poll alone, but got: %+v", polls)
+	}
+
+	t.Logf("Starting test for ClosePolls with: the poll due, and expecting: it closed and refusing votes")
+	if closed, _ := db.ClosePolls(time.Now()); len(closed) != 0 {
+		t.Errorf("Expecting: nothing due yet, but got: %v", closed)
+	}
+	closed, err := db.ClosePolls(time.Now().Add(2 * time.Hour))
+	if err != nil || len(closed) != 1 || closed[0] != chirp.Id {
+		t.Errorf("Expecting: [%d], but got: %v, %v", chirp.Id, closed, err)
+	}
+	if polls, _ := db.GetPolls([]int{chirp.Id}); polls[chirp.Id].ClosedAt == nil {
+		t.Errorf("Expecting: the poll closed, but got: %+v", polls[chirp.Id])
+	}
+	if _, err := db.VotePoll(chirp.Id, 6, 0); !errors.Is(err, ErrPollClosed) {
+		t.Errorf("Expecting: %v, but got: %v", ErrPollClosed, err)
+	}
+}
+
+// listedIds hands out its ids in order.
+type listedIds struct{ ids []int }
+
+func (l *listedIds) NextId() (int, error) {
+	id := l.ids[0]
+	l.ids = l.ids[1:]
+	return id, nil
+}
+
+func runIdGeneratorTest(t *testing.T, db Storage) {
+	const generated = 1 << 40
+	t.Logf("Starting test for SetIdGenerator with: a generator that repeats an id, and expecting: the repeat skipped")
+	db.SetIdGenerator(&listedIds{ids: []int{generated, generated, generated + 1}})
+	first, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "From the generator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Also from the generator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Id != generated || second.Id != generated+1 {
+		t.Errorf("Expecting: ids %d and %d, but got: %d and %d", generated, generated+1, first.Id, second.Id)
+	}
+	if chirp, found, err := db.GetChirp(second.Id); err != nil || !found || chirp.Body != second.Body {
+		t.Errorf("Expecting: %+v, but got: %+v (found: %t, err: %v)", second, chirp, found, err)
+	}
+
+	t.Logf("Starting test for SetIdGenerator with: nil, and expecting: sequential ids after the generated ones")
+	db.SetIdGenerator(nil)
+	third, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Counted again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Id != generated+2 {
+		t.Errorf("Expecting: %d, but got: %d", generated+2, third.Id)
+	}
+}
+
+func runSnowflakeIdsTest(t *testing.T) {
+	t.Logf("Starting test for SnowflakeIds with: ids in the same and the next millisecond, and expecting: increasing ids with the node id")
+	if _, err := NewSnowflakeIds(MaxNodeId + 1); err == nil {
+		t.Errorf("Expecting: an error for node %d, but got: nil", MaxNodeId+1)
+	}
+	ids, err := NewSnowflakeIds(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := snowflakeEpoch.Add(time.Hour)
+	ids.now = func() time.Time { return now }
+	var got []int
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			now = now.Add(time.Millisecond)
+		}
+		id, err := ids.NextId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	ms := int(time.Hour / time.Millisecond)
+	expecting := []int{ms<<22 | 5<<12, ms<<22 | 5<<12 | 1, (ms+1)<<22 | 5<<12}
+	if !reflect.DeepEqual(got, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, got)
+	}
+
+}
+
+func runRandomIdsTest(t *testing.T) {
+	t.Logf("Starting test for RandomIds with: ids in the same millisecond, after the clock goes back and in a later one, and expecting: increasing ids in the time's bits")
+	ids := NewRandomIds()
+	now := snowflakeEpoch.Add(time.Hour)
+	ids.now = func() time.Time { return now }
+	var got []int
+	for _, step := range []time.Duration{0, 0, -time.Millisecond, 2 * time.Millisecond} {
+		now = now.Add(step)
+		id, err := ids.NextId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	ms := int(time.Hour / time.Millisecond)
+	increasing := true
+	for i := 1; i < len(got); i++ {
+		increasing = increasing && got[i] > got[i-1]
+	}
+	if !increasing || got[0]>>randomIdBits != ms || got[3]>>randomIdBits != ms+1 {
+		t.Errorf("Expecting: increasing ids from millisecond %d to %d, but got: %v", ms, ms+1, got)
+	}
+
+	t.Logf("Starting test for addChirp with: the largest id, and expecting: the sequential counter left alone")
+	dbStruct := DBStructure{NextChirpId: 7, Chirps: make(map[int]Chirp), ChirpShortIds: make(map[string]int)}
+	dbStruct.addChirp(Chirp{Id: math.MaxInt})
+	if dbStruct.NextChirpId != 7 {
+		t.Errorf("Expecting: 7, but got: %d", dbStruct.NextChirpId)
+	}
+}
+
+func runWebhookTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for CreateWebhook with: two webhooks, and expecting: both listed with their events and secrets")
+	first, err := db.CreateWebhook("https://hooks.example/chirps", []string{"chirp.created", "chirp.deleted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateWebhook("https://hooks.example/billing", []string{"user.upgraded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Secret == "" || first.Secret == second.Secret {
+		t.Errorf("Expecting: a secret of their own, but got: %q and %q", first.Secret, second.Secret)
+	}
+	webhooks, err := db.GetWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhooks) != 2 || webhooks[0].Id != first.Id || !webhooks[0].Subscribes("chirp.deleted") || webhooks[1].Secret != second.Secret {
+		t.Errorf("Expecting: %+v and %+v, but got: %+v", first, second, webhooks)
+	}
+
+	t.Logf("Starting test for DeleteWebhook with: the first webhook, and expecting: it gone")
+	if err := db.DeleteWebhook(first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetWebhook(first.Id); !errors.Is(err, ErrWebhookDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrWebhookDoesNotExist, err)
+	}
+	if err := db.DeleteWebhook(first.Id); !errors.Is(err, ErrWebhookDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrWebhookDoesNotExist, err)
+	}
+	if hook, err := db.GetWebhook(second.Id); err != nil || hook.URL != second.URL {
+		t.Errorf("Expecting: %+v, but got: %+v (err: %v)", second, hook, err)
+	}
+}
+
+func runWriteBatchTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for WriteBatch with: a batch that fails after its writes, and expecting: none of them kept")
+	failure := errors.New("changed my mind")
+	var discarded Chirp
+	err := db.WriteBatch(func(batch Storage) error {
+		var err error
+		discarded, err = batch.CreateChirp(Chirp{AuthorId: 1, Body: "Never posted"})
+		if err != nil {
+			return err
+		}
+		if _, found, err := batch.GetChirp(discarded.Id); err != nil || !found {
+			t.Errorf("Expecting: the batch to see its own chirp, but got: found %t, err %v", found, err)
+		}
+		if _, err := batch.CreatePoll(discarded.Id, []string{"Yes", "No"}, time.Now().Add(time.Hour)); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expecting: %v, but got: %v", failure, err)
+	}
+	if chirp, found, err := db.GetChirp(discarded.Id); err != nil || (found && chirp.Body == discarded.Body) {
+		t.Errorf("Expecting: the chirp discarded, but got: %+v (found: %t, err: %v)", chirp, found, err)
+	}
+
+	t.Logf("Starting test for WriteBatch with: a nested batch that succeeds, and expecting: every write kept")
+	var chirp Chirp
+	err = db.WriteBatch(func(batch Storage) error {
+		var err error
+		chirp, err = batch.CreateChirp(Chirp{AuthorId: 1, Body: "Posted with a poll"})
+		if err != nil {
+			return err
+		}
+		return batch.WriteBatch(func(nested Storage) error {
+			_, err := nested.CreatePoll(chirp.Id, []string{"Yes", "No"}, time.Now().Add(time.Hour))
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	polls, err := db.GetPolls([]int{chirp.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, found, _ := db.GetChirp(chirp.Id); !found || stored.Body != chirp.Body || len(polls) != 1 {
+		t.Errorf("Expecting: the chirp and its poll, but got: %+v and %+v", stored, polls)
+	}
+}
+
+func runIdempotencyTest(t *testing.T, db Storage) {
+	now := time.Now()
+	since := now.Add(-24 * time.Hour)
+	first := IdempotencyRecord{Key: "chirps:1:retry-me", RequestHash: "abc", CreatedAt: now}
+
+	t.Logf("Starting test for ClaimIdempotencyKey with: a new key, and expecting: it claimed")
+	if _, claimed, err := db.ClaimIdempotencyKey(first, since); err != nil || !claimed {
+		t.Fatalf("Expecting: the key claimed, but got: claimed %t, err %v", claimed, err)
+	}
+
+	t.Logf("Starting test for ClaimIdempotencyKey with: the key again before a response, and expecting: the pending record")
+	record, claimed, err := db.ClaimIdempotencyKey(IdempotencyRecord{Key: first.Key, RequestHash: "abc", CreatedAt: now}, since)
+	if err != nil || claimed || record.Status != 0 || record.RequestHash != "abc" {
+		t.Errorf("Expecting: the pending record, but got: %+v (claimed: %t, err: %v)", record, claimed, err)
+	}
+
+	t.Logf("Starting test for SaveIdempotentResponse with: a response, and expecting: retries to get it")
+	first.Status = 201
+	first.ContentType = "application/json"
+	first.Body = []byte(`{"id":1}`)
+	if err := db.SaveIdempotentResponse(first); err != nil {
+		t.Fatal(err)
+	}
+	record, claimed, err = db.ClaimIdempotencyKey(IdempotencyRecord{Key: first.Key, RequestHash: "abc", CreatedAt: now}, since)
+	if err != nil || claimed || record.Status != 201 || record.ContentType != first.ContentType || string(record.Body) != string(first.Body) {
+		t.Errorf("Expecting: the saved response, but got: %+v (claimed: %t, err: %v)", record, claimed, err)
+	}
+
+	t.Logf("Starting test for ClaimIdempotencyKey with: the key after the window, and expecting: it claimed afresh")
+	record, claimed, err = db.ClaimIdempotencyKey(IdempotencyRecord{Key: first.Key, RequestHash: "def", CreatedAt: now.Add(25 * time.Hour)}, now.Add(time.Hour))
+	if err != nil || !claimed || record.Status != 0 || record.RequestHash != "def" {
+		t.Errorf("Expecting: the key claimed afresh, but got: %+v (claimed: %t, err: %v)", record, claimed, err)
+	}
+
+	t.Logf("Starting test for ReleaseIdempotencyKey with: a claimed key, and expecting: it free to claim again")
+	if err := db.ReleaseIdempotencyKey(first.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed, err := db.ClaimIdempotencyKey(first, since); err != nil || !claimed {
+		t.Errorf("Expecting: the key claimed, but got: claimed %t, err %v", claimed, err)
+	}
+
+	t.Logf("Starting test for PurgeIdempotencyKeys with: one old key and one recent key, and expecting: 1 purged")
+	old := IdempotencyRecord{Key: "polka:old", RequestHash: "ghi", CreatedAt: now.Add(-48 * time.Hour)}
+	if _, _, err := db.ClaimIdempotencyKey(old, now.Add(-72*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := db.PurgeIdempotencyKeys(since)
+	if err != nil || purged != 1 {
+		t.Errorf("Expecting: 1, but got: %d (err: %v)", purged, err)
+	}
+	if _, claimed, err := db.ClaimIdempotencyKey(first, since); err != nil || claimed {
+		t.Errorf("Expecting: the recent key kept, but got: claimed %t, err %v", claimed, err)
+	}
+
+	t.Logf("Starting test for ClaimIdempotencyKey with: 8 concurrent retries of a new key, and expecting: exactly 1 claimed")
+	var wg sync.WaitGroup
+	var claims atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, claimed, err := db.ClaimIdempotencyKey(IdempotencyRecord{Key: "chirps:1:concurrent", RequestHash: "abc", CreatedAt: now}, since)
+			if err != nil {
+				t.Error(err)
+			}
+			if claimed {
+				claims.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if claims.Load() != 1 {
+		t.Errorf("Expecting: 1, but got: %d", claims.Load())
+	}
+}
+
+func runSecurityEventsTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("security@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for RecordSecurityEvent with: a password change and more logins than are kept, and expecting: the newest logins and the password change, newest first")
+	now := time.Now()
+	if _, err := db.RecordSecurityEvent(SecurityEvent{UserId: user.Id, Kind: SecurityPasswordChanged, IP: "192.0.2.1", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	var last SecurityEvent
+	for i := 0; i < maxSecurityEventsPerKind+5; i++ {
+		if last, err = db.RecordSecurityEvent(SecurityEvent{UserId: user.Id, Kind: SecurityLogin, CreatedAt: now.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := db.GetSecurityEvents(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != maxSecurityEventsPerKind+1 || events[0].Id != last.Id || events[len(events)-1].Kind != SecurityPasswordChanged || events[len(events)-1].IP != "192.0.2.1" {
+		t.Errorf("Expecting: %d events from login %d down to the password change, but got: %+v", maxSecurityEventsPerKind+1, last.Id, events)
+	}
+
+	t.Logf("Starting test for RecordSecurityEvent with: a user that does not exist, and expecting: ErrUserDoesNotExist")
+	if _, err := db.RecordSecurityEvent(SecurityEvent{UserId: user.Id + 1000, Kind: SecurityLogin, CreatedAt: now}); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+
+	t.Logf("Starting test for GetUserSessions with: two live sessions, and expecting: both, and none once revoked")
+	for i := 0; i < 2; i++ {
+		if _, _, err := db.CreateSession(user.Id, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sessions, err := db.GetUserSessions(user.Id, time.Now()); err != nil || len(sessions) != 2 {
+		t.Errorf("Expecting: 2 sessions, but got: %+v (err: %v)", sessions, err)
+	}
+	if sessions, err := db.GetUserSessions(user.Id, time.Now().Add(2*time.Hour)); err != nil || len(sessions) != 0 {
+		t.Errorf("Expecting: no sessions once expired, but got: %+v (err: %v)", sessions, err)
+	}
+	if err := db.DeleteUserSessions(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err := db.GetUserSessions(user.Id, time.Now()); err != nil || len(sessions) != 0 {
+		t.Errorf("Expecting: no sessions, but got: %+v (err: %v)", sessions, err)
+	}
+
+	t.Logf("Starting test for DeleteUser with: a user with a security log, and expecting: the log gone")
+	if err := db.DeleteUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := db.GetSecurityEvents(user.Id); err != nil || len(events) != 0 {
+		t.Errorf("Expecting: no events, but got: %+v (err: %v)", events, err)
+	}
+}
+
+func runWithContextTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for WithContext with: a cancelled context, and expecting: reads and writes to fail with context.Canceled")
+	ctx, cancel := context.WithCancel(context.Background())
+	view := db.WithContext(ctx)
+	if _, err := view.GetChirps("asc"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := view.GetChirps("asc"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting: %v, but got: %v", context.Canceled, err)
+	}
+	chirp, err := view.CreateChirp(Chirp{AuthorId: 1, Body: "Too late"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting: %v, but got: %v", context.Canceled, err)
+	}
+	if stored, found, _ := db.GetChirp(chirp.Id); found && stored.Body == "Too late" {
+		t.Errorf("Expecting: the chirp not created, but got: %+v", stored)
+	}
+
+	t.Logf("Starting test for WithContext with: a batch on a context cancelled during it, and expecting: none of its writes kept")
+	ctx, cancel = context.WithCancel(context.Background())
+	var created Chirp
+	err = db.WithContext(ctx).WriteBatch(func(batch Storage) error {
+		var err error
+		if created, err = batch.CreateChirp(Chirp{AuthorId: 1, Body: "Cancelled midway"}); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expecting: %v, but got: %v", context.Canceled, err)
+	}
+	if stored, found, _ := db.GetChirp(created.Id); found && stored.Body == "Cancelled midway" {
+		t.Errorf("Expecting: the chirp not kept, but got: %+v", stored)
+	}
+
+	t.Logf("Starting test for WithContext with: a live context, and expecting: the store to work as usual")
+	chirp, err = db.WithContext(context.Background()).CreateChirp(Chirp{AuthorId: 1, Body: "Right on time"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, found, err := db.GetChirp(chirp.Id); err != nil || !found || stored.Body != chirp.Body {
+		t.Errorf("Expecting: %+v, but got: %+v (found: %t, err: %v)", chirp, stored, found, err)
+	}
+}
+
+func runCountersTest(t *testing.T, db Storage) {
+	t.Logf("Starting test for AddCounters with: counts added twice to a day, and expecting: their sum")
+	err := db.AddCounters([]DailyCounter{{Name: "hits", Day: "2024-06-01", Count: 2}, {Name: "hits", Day: "2024-06-02", Count: 1}, {Name: "logins", Day: "2024-06-02", Count: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddCounters([]DailyCounter{{Name: "hits", Day: "2024-06-02", Count: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	counters, err := db.GetCounters("2024-06-02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expecting := []DailyCounter{{Name: "hits", Day: "2024-06-02", Count: 6}, {Name: "logins", Day: "2024-06-02", Count: 4}}
+	if !reflect.DeepEqual(counters, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, counters)
+	}
+
+	t.Logf("Starting test for GetCounterTotals with: two days of hits, and expecting: hits 8 and logins 4")
+	totals, err := db.GetCounterTotals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if totals["hits"] != 8 || totals["logins"] != 4 {
+		t.Errorf("Expecting: hits 8 and logins 4, but got: %v", totals)
+	}
+
+	t.Logf("Starting test for ResetCounter with: hits, and expecting: only logins left")
+	if err := db.ResetCounter("hits"); err != nil {
+		t.Fatal(err)
+	}
+	counters, err = db.GetCounters("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counters) != 1 || counters[0].Name != "logins" {
+		t.Errorf("Expecting: only logins, but got: %v", counters)
+	}
+}
+
+func runAnonymizeTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("private@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTOTPSecret(user.Id, []byte("sealed secret")); err != nil {
+		t.Fatal(err)
+	}
+	draft, err := db.CreateDraft(Draft{AuthorId: user.Id, Body: "Meet me at 5, Bob!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"203.0.113.7", "203.0.113.7", "2001:db8::1"} {
+		if _, err := db.RecordSecurityEvent(SecurityEvent{UserId: user.Id, Kind: "login", IP: ip, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy := LockoutPolicy{Limit: 5, Window: time.Hour, Duration: time.Hour}
+	if _, err := db.RecordLoginFailure(ThrottleAccount, "private@example.com", time.Now(), policy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RecordLoginFailure(ThrottleIP, "203.0.113.7", time.Now(), policy); err != nil {
+		t.Fatal(err)
+	}
+	key, err := db.CreateAPIKey(user.Id, "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for Anonymize with: a user's email, password, TOTP secret, draft, addresses, and API key, and expecting: all replaced")
+	stats, err := db.Anonymize("anonymous")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users < 1 || stats.PrivateTexts < 1 || stats.IPs < 2 || stats.LoginThrottles < 2 || stats.Secrets < 1 {
+		t.Errorf("Expecting: every kind of data counted, but got: %+v", stats)
+	}
+	if _, err := db.GetUser("private@example.com"); err == nil {
+		t.Errorf("Expecting: the email gone, but got: a user")
+	}
+	anonymous, err := db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anonymous.Email != AnonymousEmail(user.Id) || anonymous.TOTPSecret != nil || anonymous.TOTPEnabled {
+		t.Errorf("Expecting: %s without TOTP, but got: %+v", AnonymousEmail(user.Id), anonymous)
+	}
+	if err := db.ComparePasswords("anonymous", AnonymousEmail(user.Id)); err != nil {
+		t.Errorf("Expecting: the synthetic password, but got: %v", err)
+	}
+	draft, err = db.GetDraft(draft.Id, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draft.Body != "Xxxx xx xx 0, Xxx!" {
+		t.Errorf("Expecting: Xxxx xx xx 0, Xxx!, but got: %s", draft.Body)
+	}
+	events, err := db.GetSecurityEvents(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].IP == "203.0.113.7" || events[2].IP != events[1].IP || events[1].IP == events[0].IP ||
+		!strings.Contains(events[0].IP, ":") {
+		t.Errorf("Expecting: three events with two synthetic addresses, but got: %+v", events)
+	}
+	if throttle, err := db.GetLoginThrottle(ThrottleAccount, AnonymousEmail(user.Id)); err != nil || throttle.Failures != 1 {
+		t.Errorf("Expecting: the account throttle kept under the synthetic email, but got: %+v, %v", throttle, err)
+	}
+	if throttle, err := db.GetLoginThrottle(ThrottleIP, "203.0.113.7"); err != nil || throttle.Failures != 0 {
+		t.Errorf("Expecting: no throttle left for the address, but got: %+v, %v", throttle, err)
+	}
+	if anonymousKey, _, err := db.GetAPIKey(key.Id); err != nil || anonymousKey.Secret == key.Secret || len(anonymousKey.Secret) != len(key.Secret) {
+		t.Errorf("Expecting: a new secret, but got: %+v, %v", anonymousKey, err)
+	}
+}
+
+func runWipeTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("wiped@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "soon gone", AuthorId: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	words, err := db.GetBlockedWords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for Wipe with: users and chirps, and expecting: them counted and gone, and the blocked words kept")
+	stats, err := db.Wipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Users < 1 || stats.Chirps < 1 {
+		t.Errorf("Expecting: the users and chirps counted, but got: %+v", stats)
+	}
+	if users, err := db.GetUsers(); err != nil || len(users) != 0 {
+		t.Errorf("Expecting: no users, but got: %v, %v", users, err)
+	}
+	if chirps, err := db.GetChirps("asc"); err != nil || len(chirps) != 0 {
+		t.Errorf("Expecting: no chirps, but got: %v, %v", chirps, err)
+	}
+	if after, err := db.GetBlockedWords(); err != nil || len(after) != len(words) {
+		t.Errorf("Expecting: %d blocked words, but got: %v, %v", len(words), after, err)
+	}
+
+	t.Logf("Starting test for Wipe with: a user created afterwards, and expecting: id 1")
+	user, err = db.CreateUser("wiped@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != 1 {
+		t.Errorf("Expecting: 1, but got: %d", user.Id)
+	}
+}
+
+func runSearchTest(t *testing.T, db Storage) {
+	user, err := db.CreateUser("searcher@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"Fresh Bread today", "no bread here, only 100% toast", "nothing_to see"} {
+		if _, err := db.CreateChirp(Chirp{Body: body, AuthorId: user.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !db.SupportsSearch() {
+		t.Logf("Starting test for SearchChirps with: a store without search, and expecting: ErrUnsupported")
+		if _, err := db.SearchChirps("bread", "asc"); !errors.Is(err, errors.ErrUnsupported) {
+			t.Errorf("Expecting: %v, but got: %v", errors.ErrUnsupported, err)
+		}
+		return
+	}
+	cases := []struct {
+		query  string
+		bodies []string
+	}{
+		{"BREAD", []string{"Fresh Bread today", "no bread here, only 100% toast"}},
+		{"0% t", []string{"no bread here, only 100% toast"}},
+		{"g_t", []string{"nothing_to see"}},
+		{"d_t", nil},
+		{"%", []string{"no bread here, only 100% toast"}},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for SearchChirps with: %q, and expecting: %q", c.query, c.bodies)
+		chirps, err := db.SearchChirps(c.query, "asc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bodies []string
+		for _, chirp := range chirps {
+			bodies = append(bodies, chirp.Body)
+		}
+		if !slices.Equal(bodies, c.bodies) {
+			t.Errorf("Expecting: %q, but got: %q", c.bodies, bodies)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Device authorization states. A pending authorization waits for its user
+// to approve or deny it; the device learns the outcome on its next poll.
+const (
+	DevicePending  = "pending"
+	DeviceApproved = "approved"
+	DeviceDenied   = "denied"
+)
+
+// DeviceAuthorization is a login started on a device without a browser,
+// such as a command line client, and finished by its user elsewhere. The
+// device polls with its device code, of which only the hash is stored, and
+// the user types in the short user code.
+type DeviceAuthorization struct {
+	UserCode       string     `json:"user_code"`
+	DeviceCodeHash string     `json:"-"`
+	State          string     `json:"state"`
+	UserId         int        `json:"user_id"` // who approved or denied it
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	PolledAt       *time.Time `json:"polled_at"`
+}
+
+// Expired reports whether the authorization can no longer be used at the
+// given time.
+func (auth DeviceAuthorization) Expired(at time.Time) bool {
+	return !at.Before(auth.ExpiresAt)
+}
+
+// userCodeAlphabet leaves out vowels, so user codes never spell words, and
+// digits, which are easily confused with letters.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// newUserCode returns a random code like WDJB-MJHT, as RFC 8628 suggests.
+func newUserCode() (string, error) {
+	code := make([]byte, 0, 9)
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code = append(code, userCodeAlphabet[n.Int64()])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode forgives the ways people retype a code: lower case,
+// spaces, and a missing or misplaced dash.
+func normalizeUserCode(code string) string {
+	letters := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	if len(letters) != 8 {
+		return letters
+	}
+	return letters[:4] + "-" + letters[4:]
+}
+
+// CreateDeviceAuthorization starts a device login lasting ttl and returns
+// it with its device code.
+func (db *DB) CreateDeviceAuthorization(ttl time.Duration) (DeviceAuthorization, string, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return DeviceAuthorization{}, "", err
+	}
+	now := time.Now().UTC()
+	for code, auth := range dbStruct.DeviceAuthorizations {
+		if auth.Expired(now) {
+			delete(dbStruct.DeviceAuthorizations, code)
+		}
+	}
+	deviceCode, deviceCodeHash, err := newSessionToken()
+	if err != nil {
+		return DeviceAuthorization{}, "", err
+	}
+	userCode, err := newUserCode()
+	for err == nil && dbStruct.DeviceAuthorizations[userCode].UserCode != "" {
+		userCode, err = newUserCode()
+	}
+	if err != nil {
+		return DeviceAuthorization{}, "", err
+	}
+	auth := DeviceAuthorization{
+		UserCode:       userCode,
+		DeviceCodeHash: deviceCodeHash,
+		State:          DevicePending,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(ttl),
+	}
+	dbStruct.DeviceAuthorizations[userCode] = auth
+	if err := db.writeDB(dbStruct); err != nil {
+		return DeviceAuthorization{}, "", err
+	}
+	return auth, deviceCode, nil
+}
+
+// GetDeviceAuthorization returns the unexpired authorization with userCode.
+func (db *DB) GetDeviceAuthorization(userCode string) (DeviceAuthorization, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	userCode = normalizeUserCode(userCode)
+	auth, found := dbStruct.DeviceAuthorizations[userCode]
+	if !found || auth.Expired(time.Now()) {
+		return DeviceAuthorization{}, notFound(ErrDeviceCodeDoesNotExist, userCode)
+	}
+	return auth, nil
+}
+
+// DecideDeviceAuthorization records that the user approved or denied the
+// pending authorization with userCode.
+func (db *DB) DecideDeviceAuthorization(userCode string, userId int, approve bool) (DeviceAuthorization, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	userCode = normalizeUserCode(userCode)
+	auth, found := dbStruct.DeviceAuthorizations[userCode]
+	if !found || auth.Expired(time.Now()) {
+		return DeviceAuthorization{}, notFound(ErrDeviceCodeDoesNotExist, userCode)
+	}
+	if auth.State != DevicePending {
+		return DeviceAuthorization{}, ErrDeviceCodeUsed
+	}
+	auth.State, auth.UserId = DeviceDenied, userId
+	if approve {
+		auth.State = DeviceApproved
+	}
+	dbStruct.DeviceAuthorizations[userCode] = auth
+	if err := db.writeDB(dbStruct); err != nil {
+		return DeviceAuthorization{}, err
+	}
+	return auth, nil
+}
+
+// PollDeviceAuthorization records a poll with deviceCode at the given time
+// and returns the authorization as it was before, so the caller can tell
+// whether the device polls too often. Once the authorization is approved,
+// denied or expired, the poll that reports it also deletes it, so a device
+// code is only ever exchanged for tokens once.
+func (db *DB) PollDeviceAuthorization(deviceCode string, at time.Time) (DeviceAuthorization, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	deviceCodeHash := hashToken(deviceCode)
+	for code, auth := range dbStruct.DeviceAuthorizations {
+		if auth.DeviceCodeHash != deviceCodeHash {
+			continue
+		}
+		if auth.State != DevicePending || auth.Expired(at) {
+			delete(dbStruct.DeviceAuthorizations, code)
+		} else {
+			polledAt := at.UTC()
+			updated := auth
+			updated.PolledAt = &polledAt
+			dbStruct.DeviceAuthorizations[code] = updated
+		}
+		if err := db.writeDB(dbStruct); err != nil {
+			return DeviceAuthorization{}, err
+		}
+		return auth, nil
+	}
+	return DeviceAuthorization{}, ErrDeviceCodeDoesNotExist
+}
+
+const deviceAuthorizationColumns = `user_code, device_code_hash, state, user_id, created_at, expires_at, polled_at`
+
+func scanDeviceAuthorization(row interface{ Scan(...any) error }) (DeviceAuthorization, error) {
+	var auth DeviceAuthorization
+	err := row.Scan(&auth.UserCode, &auth.DeviceCodeHash, &auth.State, &auth.UserId,
+		&auth.CreatedAt, &auth.ExpiresAt, &auth.PolledAt)
+	return auth, err
+}
+
+func (db *SQLDB) CreateDeviceAuthorization(ttl time.Duration) (DeviceAuthorization, string, error) {
+	now := time.Now().UTC()
+	if _, err := db.exec(`DELETE FROM device_authorizations WHERE expires_at <= ?`, now); err != nil {
+		return DeviceAuthorization{}, "", err
+	}
+	deviceCode, deviceCodeHash, err := newSessionToken()
+	if err != nil {
+		return DeviceAuthorization{}, "", err
+	}
+	auth := DeviceAuthorization{
+		DeviceCodeHash: deviceCodeHash,
+		State:          DevicePending,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(ttl),
+	}
+	// Retry the rare user code that is already in use.
+	for attempt := 0; ; attempt++ {
+		auth.UserCode, err = newUserCode()
+		if err != nil {
+			return DeviceAuthorization{}, "", err
+		}
+		_, err = db.exec(`INSERT INTO device_authorizations (`+deviceAuthorizationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			auth.UserCode, auth.DeviceCodeHash, auth.State, auth.UserId, auth.CreatedAt, auth.ExpiresAt, auth.PolledAt)
+		if err == nil {
+			return auth, deviceCode, nil
+		}
+		if attempt == 3 {
+			return DeviceAuthorization{}, "", err
+		}
+	}
+}
+
+func (db *SQLDB) GetDeviceAuthorization(userCode string) (DeviceAuthorization, error) {
+	userCode = normalizeUserCode(userCode)
+	auth, err := scanDeviceAuthorization(db.queryRow(`SELECT `+deviceAuthorizationColumns+`
+		FROM device_authorizations WHERE user_code = ? AND expires_at > ?`, userCode, time.Now().UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeviceAuthorization{}, notFound(ErrDeviceCodeDoesNotExist, userCode)
+	}
+	return auth, err
+}
+
+func (db *SQLDB) DecideDeviceAuthorization(userCode string, userId int, approve bool) (DeviceAuthorization, error) {
+	auth, err := db.GetDeviceAuthorization(userCode)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	if auth.State != DevicePending {
+		return DeviceAuthorization{}, ErrDeviceCodeUsed
+	}
+	state := DeviceDenied
+	if approve {
+		state = DeviceApproved
+	}
+	result, err := db.exec(`UPDATE device_authorizations SET state = ?, user_id = ? WHERE user_code = ? AND state = ?`,
+		state, userId, auth.UserCode, DevicePending)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	if err := requireRow(result, ErrDeviceCodeUsed); err != nil {
+		return DeviceAuthorization{}, err
+	}
+	auth.State, auth.UserId = state, userId
+	return auth, nil
+}
+
+func (db *SQLDB) PollDeviceAuthorization(deviceCode string, at time.Time) (DeviceAuthorization, error) {
+	deviceCodeHash := hashToken(deviceCode)
+	at = at.UTC()
+	// Claiming a decided or expired authorization deletes it in the same
+	// statement, so concurrent polls cannot both be handed tokens.
+	auth, err := scanDeviceAuthorization(db.queryRow(`DELETE FROM device_authorizations
+		WHERE device_code_hash = ? AND (state <> ? OR expires_at <= ?)
+		RETURNING `+deviceAuthorizationColumns, deviceCodeHash, DevicePending, at))
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return auth, err
+	}
+	auth, err = scanDeviceAuthorization(db.queryRow(`SELECT `+deviceAuthorizationColumns+`
+		FROM device_authorizations WHERE device_code_hash = ?`, deviceCodeHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeviceAuthorization{}, ErrDeviceCodeDoesNotExist
+	}
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	_, err = db.exec(`UPDATE device_authorizations SET polled_at = ? WHERE user_code = ?`, at, auth.UserCode)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	return auth, nil
+}
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Draft is a chirp its author has saved without publishing. Only the author
+// can see it.
+type Draft struct {
+	Id        int          `json:"id"`
+	AuthorId  int          `json:"author_id"`
+	Body      string       `json:"body"`
+	ParentId  *int         `json:"parent_id"`
+	Media     []Attachment `json:"media"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+func (db *DB) CreateDraft(draft Draft) (Draft, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	dbStruct.NextDraftId = max(dbStruct.NextDraftId, 1)
+	draft.Id = dbStruct.NextDraftId
+	draft.CreatedAt = time.Now().UTC()
+	draft.UpdatedAt = draft.CreatedAt
+	dbStruct.Drafts[draft.Id] = draft
+	dbStruct.NextDraftId++
+	if err := db.writeDB(dbStruct); err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// GetDraft returns a draft of the author. Those of other authors are
+// reported missing.
+func (db *DB) GetDraft(id, authorId int) (Draft, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	draft, found := dbStruct.Drafts[id]
+	if !found || draft.AuthorId != authorId {
+		return Draft{}, notFound(ErrDraftDoesNotExist, id)
+	}
+	return draft, nil
+}
+
+// GetDrafts returns the author's drafts, most recently updated first.
+func (db *DB) GetDrafts(authorId int) ([]Draft, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	drafts := []Draft{}
+	for _, draft := range dbStruct.Drafts {
+		if draft.AuthorId == authorId {
+			drafts = append(drafts, draft)
+		}
+	}
+	slices.SortFunc(drafts, func(a, b Draft) int {
+		if c := b.UpdatedAt.Compare(a.UpdatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Id, a.Id)
+	})
+	return drafts, nil
+}
+
+// UpdateDraft replaces the body, parent and media of the draft with
+// draft.Id, which must belong to draft.AuthorId.
+func (db *DB) UpdateDraft(draft Draft) (Draft, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Draft{}, err
+	}
+	stored, found := dbStruct.Drafts[draft.Id]
+	if !found || stored.AuthorId != draft.AuthorId {
+		return Draft{}, notFound(ErrDraftDoesNotExist, draft.Id)
+	}
+	stored.Body = draft.Body
+	stored.ParentId = draft.ParentId
+	stored.Media = draft.Media
+	stored.UpdatedAt = time.Now().UTC()
+	dbStruct.Drafts[stored.Id] = stored
+	if err := db.writeDB(dbStruct); err != nil {
+		return Draft{}, err
+	}
+	return stored, nil
+}
+
+func (db *DB) DeleteDraft(id, authorId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	draft, found := dbStruct.Drafts[id]
+	if !found || draft.AuthorId != authorId {
+		return notFound(ErrDraftDoesNotExist, id)
+	}
+	delete(dbStruct.Drafts, id)
+	return db.writeDB(dbStruct)
+}
+
+const draftColumns = `id, author_id, body, parent_id, media, created_at, updated_at`
+
+func scanDraft(row scanner) (Draft, error) {
+	draft := Draft{}
+	var media sql.NullString
+	err := row.Scan(&draft.Id, &draft.AuthorId, &draft.Body, &draft.ParentId, &media, &draft.CreatedAt, &draft.UpdatedAt)
+	if err != nil {
+		return draft, err
+	}
+	if media.Valid {
+		if err := json.Unmarshal([]byte(media.String), &draft.Media); err != nil {
+			return draft, err
+		}
+	}
+	return draft, nil
+}
+
+func (db *SQLDB) CreateDraft(draft Draft) (Draft, error) {
+	media, err := json.Marshal(draft.Media)
+	if err != nil {
+		return Draft{}, err
+	}
+	draft.CreatedAt = time.Now().UTC()
+	draft.UpdatedAt = draft.CreatedAt
+	err = db.queryRow(`INSERT INTO drafts (author_id, body, parent_id, media, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		draft.AuthorId, draft.Body, draft.ParentId, string(media), draft.CreatedAt, draft.UpdatedAt).Scan(&draft.Id)
+	if err != nil {
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+func (db *SQLDB) GetDraft(id, authorId int) (Draft, error) {
+	draft, err := scanDraft(db.queryRow(`SELECT `+draftColumns+` FROM drafts WHERE id = ? AND author_id = ?`, id, authorId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Draft{}, notFound(ErrDraftDoesNotExist, id)
+	}
+	return draft, err
+}
+
+func (db *SQLDB) GetDrafts(authorId int) ([]Draft, error) {
+	rows, err := db.query(`SELECT `+draftColumns+` FROM drafts WHERE author_id = ? ORDER BY updated_at DESC, id DESC`, authorId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	drafts := []Draft{}
+	for rows.Next() {
+		draft, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	return drafts, rows.Err()
+}
+
+func (db *SQLDB) UpdateDraft(draft Draft) (Draft, error) {
+	media, err := json.Marshal(draft.Media)
+	if err != nil {
+		return Draft{}, err
+	}
+	updated, err := scanDraft(db.queryRow(`UPDATE drafts SET body = ?, parent_id = ?, media = ?, updated_at = ?
+		WHERE id = ? AND author_id = ? RETURNING `+draftColumns,
+		draft.Body, draft.ParentId, string(media), time.Now().UTC(), draft.Id, draft.AuthorId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Draft{}, notFound(ErrDraftDoesNotExist, draft.Id)
+	}
+	return updated, err
+}
+
+func (db *SQLDB) DeleteDraft(id, authorId int) error {
+	result, err := db.exec(`DELETE FROM drafts WHERE id = ? AND author_id = ?`, id, authorId)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrDraftDoesNotExist, id))
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// Emoji is an instance's custom emoji: chirps that contain :Shortcode: show
+// the image uploaded as media MediaId in its place.
+type Emoji struct {
+	Shortcode string    `json:"shortcode"`
+	MediaId   string    `json:"media_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PutEmoji points shortcode at mediaId, adding the emoji if it is new.
+func (db *DB) PutEmoji(shortcode, mediaId string) (Emoji, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Emoji{}, err
+	}
+	emoji, found := dbStruct.Emoji[shortcode]
+	if !found {
+		emoji = Emoji{Shortcode: shortcode, CreatedAt: time.Now().UTC()}
+	}
+	emoji.MediaId = mediaId
+	dbStruct.Emoji[shortcode] = emoji
+	if err := db.writeDB(dbStruct); err != nil {
+		return Emoji{}, err
+	}
+	return emoji, nil
+}
+
+func (db *DB) DeleteEmoji(shortcode string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Emoji[shortcode]; !found {
+		return notFound(ErrEmojiDoesNotExist, shortcode)
+	}
+	delete(dbStruct.Emoji, shortcode)
+	return db.writeDB(dbStruct)
+}
+
+// GetEmoji returns every custom emoji ordered by shortcode.
+func (db *DB) GetEmoji() ([]Emoji, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	emoji := make([]Emoji, 0, len(dbStruct.Emoji))
+	for _, e := range dbStruct.Emoji {
+		emoji = append(emoji, e)
+	}
+	slices.SortFunc(emoji, func(a, b Emoji) int { return cmp.Compare(a.Shortcode, b.Shortcode) })
+	return emoji, nil
+}
+
+func (db *SQLDB) PutEmoji(shortcode, mediaId string) (Emoji, error) {
+	_, err := db.exec(`INSERT INTO emoji (shortcode, media_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (shortcode) DO UPDATE SET media_id = excluded.media_id`,
+		shortcode, mediaId, time.Now().UTC())
+	if err != nil {
+		return Emoji{}, err
+	}
+	emoji := Emoji{}
+	err = db.queryRow(`SELECT shortcode, media_id, created_at FROM emoji WHERE shortcode = ?`, shortcode).
+		Scan(&emoji.Shortcode, &emoji.MediaId, &emoji.CreatedAt)
+	return emoji, err
+}
+
+func (db *SQLDB) DeleteEmoji(shortcode string) error {
+	result, err := db.exec(`DELETE FROM emoji WHERE shortcode = ?`, shortcode)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrEmojiDoesNotExist, shortcode))
+}
+
+func (db *SQLDB) GetEmoji() ([]Emoji, error) {
+	rows, err := db.query(`SELECT shortcode, media_id, created_at FROM emoji ORDER BY shortcode`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emoji := []Emoji{}
+	for rows.Next() {
+		e := Emoji{}
+		if err := rows.Scan(&e.Shortcode, &e.MediaId, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		emoji = append(emoji, e)
+	}
+	return emoji, rows.Err()
+}
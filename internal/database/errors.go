@@ -0,0 +1,89 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NotFoundError reports that there is no Kind with ID. The ErrXDoesNotExist
+// errors are NotFoundErrors without an ID, and errors.Is matches any
+// NotFoundError to the one of its Kind, so callers can test for them
+// whichever ID was missing.
+type NotFoundError struct {
+	Kind string
+	ID   any
+}
+
+func (e *NotFoundError) Error() string {
+	if e.ID == nil {
+		return e.Kind + " not found."
+	}
+	return fmt.Sprintf("%s %v not found.", e.Kind, e.ID)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	t, ok := target.(*NotFoundError)
+	return ok && t.Kind == e.Kind && (t.ID == nil || t.ID == e.ID)
+}
+
+// notFound returns err, one of the ErrXDoesNotExist errors, naming id.
+func notFound(err *NotFoundError, id any) error {
+	return &NotFoundError{Kind: err.Kind, ID: id}
+}
+
+// ConflictError reports a change refused because of the state of what it
+// would change, such as a duplicate or a repeated one-time action.
+type ConflictError struct {
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return e.Reason
+}
+
+// Errors raised by package database. Use errors.Is to test for them, and
+// errors.As with *NotFoundError or *ConflictError to test for a class.
+var (
+	ErrUserDoesNotExist           = &NotFoundError{Kind: "User"}
+	ErrSessionDoesNotExist        = &NotFoundError{Kind: "Session"}
+	ErrLinkDoesNotExist           = &NotFoundError{Kind: "Link"}
+	ErrMediaDoesNotExist          = &NotFoundError{Kind: "Media"}
+	ErrEmojiDoesNotExist          = &NotFoundError{Kind: "Emoji"}
+	ErrJobDoesNotExist            = &NotFoundError{Kind: "Job"}
+	ErrChirpDoesNotExist          = &NotFoundError{Kind: "Chirp"}
+	ErrAPIKeyDoesNotExist         = &NotFoundError{Kind: "API key"}
+	ErrReportDoesNotExist         = &NotFoundError{Kind: "Report"}
+	ErrActionDoesNotExist         = &NotFoundError{Kind: "Moderation action"}
+	ErrAppealDoesNotExist         = &NotFoundError{Kind: "Appeal"}
+	ErrDeviceCodeDoesNotExist     = &NotFoundError{Kind: "Device code"}
+	ErrUploadDoesNotExist         = &NotFoundError{Kind: "Upload"}
+	ErrBlockedWordDoesNotExist    = &NotFoundError{Kind: "Blocked word"}
+	ErrScheduledChirpDoesNotExist = &NotFoundError{Kind: "Scheduled chirp"}
+	ErrDraftDoesNotExist          = &NotFoundError{Kind: "Draft"}
+	ErrPollDoesNotExist           = &NotFoundError{Kind: "Poll"}
+	ErrWebhookDoesNotExist        = &NotFoundError{Kind: "Webhook"}
+
+	ErrUserAlreadyExists    = &ConflictError{Reason: "This user already exists."}
+	ErrAlreadyVerified      = &ConflictError{Reason: "Email address is already verified."}
+	ErrHandleTaken          = &ConflictError{Reason: "This handle is already taken."}
+	ErrAlreadyAppealed      = &ConflictError{Reason: "This action has already been appealed."}
+	ErrJobNotDead           = &ConflictError{Reason: "Only dead jobs can be requeued."}
+	ErrChirpNotDeleted      = &ConflictError{Reason: "This chirp is not deleted."}
+	ErrDeviceCodeUsed       = &ConflictError{Reason: "This code was already approved or denied."}
+	ErrTOTPEnabled          = &ConflictError{Reason: "Two-factor authentication is already enabled."}
+	ErrUploadOffsetMismatch = &ConflictError{Reason: "Upload offset does not match the bytes received."}
+	ErrRechirpNotEditable   = &ConflictError{Reason: "Rechirps cannot be edited."}
+	ErrAlreadyRechirped     = &ConflictError{Reason: "This chirp is already rechirped."}
+	ErrWebsiteChanged       = &ConflictError{Reason: "The website changed while it was being verified."}
+	ErrPollClosed           = &ConflictError{Reason: "This poll is closed."}
+	ErrAlreadyVoted         = &ConflictError{Reason: "You have already voted in this poll."}
+
+	ErrSessionReplayed     = errors.New("Refresh token was already used.")
+	ErrInvalidVerification = errors.New("Verification token is invalid or has expired.")
+	ErrCannotFollowSelf    = errors.New("Users cannot follow themselves.")
+	ErrInvalidPollOption   = errors.New("Poll option does not exist.")
+	ErrJobLeaseLost        = errors.New("Job is no longer leased to this worker.")
+	ErrCorruptDatabase     = errors.New("Database file is corrupt.")
+	ErrAuthorization       = errors.New("This action is not authorized.")
+	ErrParentDoesNotExist  = errors.New("Parent chirp not found.")
+)
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// Record types, in the order Export emits them. Replies always follow their
+// parent, so an export can be read back in a single pass.
+const (
+	RecordUser   = "user"
+	RecordFollow = "follow"
+	RecordChirp  = "chirp"
+	RecordLike   = "like"
+)
+
+// Record is one entry of an export. Data is a User, Follow, Chirp, or Like
+// as named by Type. Users never carry their password hash.
+type Record struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// Follow is one user following another.
+type Follow struct {
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Like is one user liking a chirp.
+type Like struct {
+	UserId    int       `json:"user_id"`
+	ChirpId   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Export passes every user, follow, chirp, and like to emit, stopping at the
+// first error emit returns. The gob file is already held in memory, so
+// nothing is gained by streaming it.
+func (db *DB) Export(emit func(Record) error) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	for _, id := range sortedKeys(dbStruct.Users) {
+		user := dbStruct.Users[id]
+		user.Password = nil
+		if err := emit(Record{Type: RecordUser, Data: user}); err != nil {
+			return err
+		}
+	}
+	for _, followerId := range sortedKeys(dbStruct.Follows) {
+		followees := dbStruct.Follows[followerId]
+		for _, followeeId := range sortedKeys(followees) {
+			follow := Follow{FollowerId: followerId, FolloweeId: followeeId, CreatedAt: followees[followeeId]}
+			if err := emit(Record{Type: RecordFollow, Data: follow}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range sortedKeys(dbStruct.Chirps) {
+		if err := emit(Record{Type: RecordChirp, Data: dbStruct.Chirps[id]}); err != nil {
+			return err
+		}
+	}
+	for _, userId := range sortedKeys(dbStruct.Likes) {
+		liked := dbStruct.Likes[userId]
+		for _, chirpId := range sortedKeys(liked) {
+			like := Like{UserId: userId, ChirpId: chirpId, CreatedAt: liked[chirpId]}
+			if err := emit(Record{Type: RecordLike, Data: like}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp.Compare[int])
+	return keys
+}
+
+// Export streams each table a row at a time, so memory use does not grow
+// with the size of the database.
+func (db *SQLDB) Export(emit func(Record) error) error {
+	err := db.exportRows(`SELECT `+userColumns+` FROM users ORDER BY id`, func(row scanner) (any, error) {
+		user, err := scanUser(row)
+		user.Password = nil
+		return user, err
+	}, RecordUser, emit)
+	if err != nil {
+		return err
+	}
+	err = db.exportRows(`SELECT follower_id, followee_id, created_at FROM follows ORDER BY follower_id, followee_id`, func(row scanner) (any, error) {
+		follow := Follow{}
+		err := row.Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt)
+		return follow, err
+	}, RecordFollow, emit)
+	if err != nil {
+		return err
+	}
+	err = db.exportRows(`SELECT `+chirpColumns+` FROM chirps ORDER BY chirps.id`, func(row scanner) (any, error) {
+		return scanChirp(row)
+	}, RecordChirp, emit)
+	if err != nil {
+		return err
+	}
+	return db.exportRows(`SELECT user_id, chirp_id, created_at FROM likes ORDER BY user_id, chirp_id`, func(row scanner) (any, error) {
+		like := Like{}
+		err := row.Scan(&like.UserId, &like.ChirpId, &like.CreatedAt)
+		return like, err
+	}, RecordLike, emit)
+}
+
+func (db *SQLDB) exportRows(query string, scan func(scanner) (any, error), recordType string, emit func(Record) error) error {
+	rows, err := db.query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		data, err := scan(rows)
+		if err != nil {
+			return err
+		}
+		if err := emit(Record{Type: recordType, Data: data}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// Follow records that followerId follows followeeId. Following someone twice
+// is not an error.
+func (db *DB) Follow(followerId, followeeId int) error {
+	if followerId == followeeId {
+		return ErrCannotFollowSelf
+	}
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Users[followeeId]; !found {
+		return notFound(ErrUserDoesNotExist, followeeId)
+	}
+	if _, following := dbStruct.Follows[followerId][followeeId]; following {
+		return nil
+	}
+	if dbStruct.Follows[followerId] == nil {
+		dbStruct.Follows[followerId] = make(map[int]time.Time)
+	}
+	dbStruct.Follows[followerId][followeeId] = time.Now().UTC()
+	return db.writeDB(dbStruct)
+}
+
+// Unfollow removes followerId's follow of followeeId, if there is one.
+func (db *DB) Unfollow(followerId, followeeId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Users[followeeId]; !found {
+		return notFound(ErrUserDoesNotExist, followeeId)
+	}
+	if _, following := dbStruct.Follows[followerId][followeeId]; !following {
+		return nil
+	}
+	delete(dbStruct.Follows[followerId], followeeId)
+	return db.writeDB(dbStruct)
+}
+
+// GetFollowing returns the ids of the users userId follows, in ascending order.
+func (db *DB) GetFollowing(userId int) ([]int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(dbStruct.Follows[userId]))
+	for id := range dbStruct.Follows[userId] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// GetFollowers returns the ids of the users following userId, in ascending order.
+func (db *DB) GetFollowers(userId int) ([]int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for followerId, following := range dbStruct.Follows {
+		if _, found := following[userId]; found {
+			ids = append(ids, followerId)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (db *SQLDB) Follow(followerId, followeeId int) error {
+	if followerId == followeeId {
+		return ErrCannotFollowSelf
+	}
+	if _, err := db.GetUserById(followeeId); err != nil {
+		return err
+	}
+	_, err := db.exec(`INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		followerId, followeeId, time.Now().UTC())
+	return err
+}
+
+func (db *SQLDB) Unfollow(followerId, followeeId int) error {
+	if _, err := db.GetUserById(followeeId); err != nil {
+		return err
+	}
+	_, err := db.exec(`DELETE FROM follows WHERE follower_id = ? AND followee_id = ?`, followerId, followeeId)
+	return err
+}
+
+func (db *SQLDB) GetFollowing(userId int) ([]int, error) {
+	return db.queryIds(`SELECT followee_id FROM follows WHERE follower_id = ? ORDER BY followee_id`, userId)
+}
+
+func (db *SQLDB) GetFollowers(userId int) ([]int, error) {
+	return db.queryIds(`SELECT follower_id FROM follows WHERE followee_id = ? ORDER BY follower_id`, userId)
+}
+
+func (db *SQLDB) queryIds(query string, args ...any) ([]int, error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IdempotencyRecord is the response to the first request made with an
+// idempotency key, which retries with the same key are answered with.
+type IdempotencyRecord struct {
+	Key string
+	// RequestHash fingerprints the request, so the key cannot be reused
+	// for a different one.
+	RequestHash string
+	// Status is zero while the first request is still being handled.
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// ClaimIdempotencyKey stores record, without a response yet, unless its key
+// was used since the given time. It returns the record stored under the key
+// and whether it is the one just claimed.
+func (db *DB) ClaimIdempotencyKey(record IdempotencyRecord, since time.Time) (IdempotencyRecord, bool, error) {
+	var existing IdempotencyRecord
+	claimed := false
+	err := db.updateDB(func(dbStruct *DBStructure) (bool, error) {
+		if stored, found := dbStruct.IdempotencyKeys[record.Key]; found && !stored.CreatedAt.Before(since) {
+			existing = stored
+			return false, nil
+		}
+		record.Status = 0
+		record.CreatedAt = record.CreatedAt.UTC()
+		dbStruct.IdempotencyKeys[record.Key] = record
+		claimed = true
+		return true, nil
+	})
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if !claimed {
+		return existing, false, nil
+	}
+	return record, true, nil
+}
+
+// SaveIdempotentResponse stores the response to the request that claimed
+// record's key.
+func (db *DB) SaveIdempotentResponse(record IdempotencyRecord) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.IdempotencyKeys[record.Key]; !found {
+		return nil
+	}
+	dbStruct.IdempotencyKeys[record.Key] = record
+	return db.writeDB(dbStruct)
+}
+
+// ReleaseIdempotencyKey forgets key, so the next request with it is handled
+// afresh.
+func (db *DB) ReleaseIdempotencyKey(key string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.IdempotencyKeys[key]; !found {
+		return nil
+	}
+	delete(dbStruct.IdempotencyKeys, key)
+	return db.writeDB(dbStruct)
+}
+
+// PurgeIdempotencyKeys forgets the keys used before the given time and
+// returns how many there were.
+func (db *DB) PurgeIdempotencyKeys(before time.Time) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for key, record := range dbStruct.IdempotencyKeys {
+		if record.CreatedAt.Before(before) {
+			delete(dbStruct.IdempotencyKeys, key)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, db.writeDB(dbStruct)
+}
+
+func (db *SQLDB) ClaimIdempotencyKey(record IdempotencyRecord, since time.Time) (IdempotencyRecord, bool, error) {
+	record.Status = 0
+	record.CreatedAt = record.CreatedAt.UTC()
+	result, err := db.exec(`INSERT INTO idempotency_keys (idempotency_key, request_hash, status, content_type, body, created_at)
+		VALUES (?, ?, 0, '', NULL, ?)
+		ON CONFLICT (idempotency_key) DO UPDATE SET request_hash = excluded.request_hash, status = 0, content_type = '', body = NULL,
+			created_at = excluded.created_at
+		WHERE idempotency_keys.created_at < ?`,
+		record.Key, record.RequestHash, record.CreatedAt, since.UTC())
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if claimed > 0 {
+		return record, true, nil
+	}
+	existing := IdempotencyRecord{Key: record.Key}
+	err = db.queryRow(`SELECT request_hash, status, content_type, body, created_at FROM idempotency_keys WHERE idempotency_key = ?`, record.Key).
+		Scan(&existing.RequestHash, &existing.Status, &existing.ContentType, &existing.Body, &existing.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released in between: claim it again.
+		return db.ClaimIdempotencyKey(record, since)
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return existing, false, nil
+}
+
+func (db *SQLDB) SaveIdempotentResponse(record IdempotencyRecord) error {
+	_, err := db.exec(`UPDATE idempotency_keys SET status = ?, content_type = ?, body = ? WHERE idempotency_key = ?`,
+		record.Status, record.ContentType, record.Body, record.Key)
+	return err
+}
+
+func (db *SQLDB) ReleaseIdempotencyKey(key string) error {
+	_, err := db.exec(`DELETE FROM idempotency_keys WHERE idempotency_key = ?`, key)
+	return err
+}
+
+func (db *SQLDB) PurgeIdempotencyKeys(before time.Time) (int, error) {
+	result, err := db.exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The schemes chirp ids can be generated with.
+const (
+	// IdsSequential numbers chirps 1, 2, 3... from the store's own counter,
+	// so only one server may create chirps in a store.
+	IdsSequential = "sequential"
+	// IdsRandom gives chirps 63-bit ids made of the time and random bits,
+	// like UUIDv7, which any number of servers can hand out without
+	// talking to each other or being given node ids. They sort by age.
+	// There is no UUID scheme: chirp ids are ints everywhere, from the
+	// stores to the API, and a UUID does not fit in one.
+	IdsRandom = "random"
+	// IdsSnowflake gives chirps 63-bit ids made of the time, the server's
+	// node id and a sequence number, so servers with different node ids
+	// never clash and ids sort by age.
+	IdsSnowflake = "snowflake"
+)
+
+// IdGenerator hands out ids for new chirps. A store without one numbers
+// chirps sequentially itself.
+type IdGenerator interface {
+	NextId() (int, error)
+}
+
+// NewIdGenerator returns the generator for scheme. Node is the server's
+// node id, which only the snowflake scheme uses. The sequential scheme
+// needs no generator, so it returns nil.
+func NewIdGenerator(scheme string, node int) (IdGenerator, error) {
+	switch scheme {
+	case "", IdsSequential:
+		return nil, nil
+	case IdsRandom:
+		return NewRandomIds(), nil
+	case IdsSnowflake:
+		return NewSnowflakeIds(node)
+	}
+	return nil, fmt.Errorf("unknown chirp id scheme %q: must be %s, %s, or %s", scheme, IdsSequential, IdsRandom, IdsSnowflake)
+}
+
+// RandomIds hands out ids made of the milliseconds since snowflakeEpoch,
+// in the bits a snowflake id keeps them, and randomIdBits random bits
+// below. Ids sort by the millisecond they were made in, and those from one
+// generator always go up. Clashes between servers are unlikely but not
+// impossible, so stores check the id is free before using it.
+type RandomIds struct {
+	mux  sync.Mutex
+	last int
+	now  func() time.Time
+}
+
+// randomIdBits is how many low bits of a random id are random.
+const randomIdBits = snowflakeNodeBits + snowflakeSequenceBits
+
+func NewRandomIds() *RandomIds {
+	return &RandomIds{now: time.Now}
+}
+
+func (ids *RandomIds) NextId() (int, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	random := int64(binary.BigEndian.Uint32(b)) & (1<<randomIdBits - 1)
+	ids.mux.Lock()
+	defer ids.mux.Unlock()
+	id := int(ids.now().Sub(snowflakeEpoch).Milliseconds()<<randomIdBits | random)
+	// Ids made in the same millisecond, or after the clock went back,
+	// still go up.
+	if id <= ids.last {
+		id = ids.last + 1
+	}
+	ids.last = id
+	return id, nil
+}
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	// MaxNodeId is the largest node id a snowflake generator takes.
+	MaxNodeId = 1<<snowflakeNodeBits - 1
+)
+
+// snowflakeEpoch is the time snowflake ids count milliseconds from. The 41
+// bits left for the time last until 2093.
+var snowflakeEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeIds hands out ids made of the milliseconds since snowflakeEpoch,
+// the node id and a sequence number for ids made in the same millisecond,
+// from the highest bits down. It hands out up to 4096 ids a millisecond,
+// waiting for the next one beyond that or when the clock goes back.
+type SnowflakeIds struct {
+	node int
+	mux  sync.Mutex
+	// last is the millisecond the last id was made in, and sequence the
+	// number of ids made in it so far.
+	last     int64
+	sequence int
+	now      func() time.Time
+}
+
+func NewSnowflakeIds(node int) (*SnowflakeIds, error) {
+	if node < 0 || node > MaxNodeId {
+		return nil, fmt.Errorf("node id %d out of range: must be from 0 to %d", node, MaxNodeId)
+	}
+	return &SnowflakeIds{node: node, now: time.Now}, nil
+}
+
+func (ids *SnowflakeIds) NextId() (int, error) {
+	ids.mux.Lock()
+	defer ids.mux.Unlock()
+	ms := ids.now().Sub(snowflakeEpoch).Milliseconds()
+	for ms < ids.last {
+		time.Sleep(time.Duration(ids.last-ms) * time.Millisecond)
+		ms = ids.now().Sub(snowflakeEpoch).Milliseconds()
+	}
+	if ms == ids.last {
+		ids.sequence++
+		for ids.sequence == 1<<snowflakeSequenceBits {
+			time.Sleep(time.Millisecond)
+			if ms = ids.now().Sub(snowflakeEpoch).Milliseconds(); ms > ids.last {
+				ids.sequence = 0
+			}
+		}
+	} else {
+		ids.sequence = 0
+	}
+	ids.last = ms
+	return int(ms<<(snowflakeNodeBits+snowflakeSequenceBits) | int64(ids.node)<<snowflakeSequenceBits | int64(ids.sequence)), nil
+}
+
+// nextChirpId returns the id for a new chirp: the next sequential one, or
+// one from the generator that no chirp has yet.
+func (db *DB) nextChirpId(dbStruct DBStructure) (int, error) {
+	if db.ids == nil {
+		return dbStruct.NextChirpId, nil
+	}
+	for {
+		id, err := db.ids.NextId()
+		if err != nil {
+			return 0, err
+		}
+		if _, taken := dbStruct.Chirps[id]; !taken {
+			return id, nil
+		}
+	}
+}
+
+// SetIdGenerator makes the store number new chirps with ids, or
+// sequentially if ids is nil. Switching away from sequential ids keeps
+// the ids handed out so far.
+func (db *DB) SetIdGenerator(ids IdGenerator) {
+	db.ids = ids
+}
+
+func (db *SQLDB) SetIdGenerator(ids IdGenerator) {
+	db.ids = ids
+}
+
+// insertChirpWithGeneratedId inserts chirp under an id from the generator,
+// drawing another while the id is taken.
+func (db *SQLDB) insertChirpWithGeneratedId(chirp *Chirp, entities, media string) error {
+	for {
+		id, err := db.ids.NextId()
+		if err != nil {
+			return err
+		}
+		result, err := db.exec(`INSERT INTO chirps (id, short_id, body, author_id, parent_id, entities, media, created_at, censored, rechirp_of_id, quoted_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+			id, chirp.ShortId, chirp.Body, chirp.AuthorId, chirp.ParentId, entities, media, chirp.CreatedAt, chirp.Censored,
+			chirp.RechirpOfId, chirp.QuotedId)
+		if err != nil {
+			return err
+		}
+		if inserted, err := result.RowsAffected(); err != nil || inserted > 0 {
+			chirp.Id = id
+			return err
+		}
+	}
+}
@@ -0,0 +1,583 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/richtext"
+)
+
+// ImportPolicy decides what happens when an imported record collides with
+// one already stored.
+type ImportPolicy string
+
+const (
+	// ImportSkip keeps the stored record and drops the imported one.
+	ImportSkip ImportPolicy = "skip"
+	// ImportOverwrite replaces the stored record with the imported one.
+	ImportOverwrite ImportPolicy = "overwrite"
+	// ImportRemap gives every imported user and chirp a new id, so two
+	// instances can be merged. Users are matched by email instead.
+	ImportRemap ImportPolicy = "remap"
+)
+
+// ParseImportPolicy returns the policy named s.
+func ParseImportPolicy(s string) (ImportPolicy, error) {
+	switch policy := ImportPolicy(s); policy {
+	case ImportSkip, ImportOverwrite, ImportRemap:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q: must be skip, overwrite, or remap", s)
+}
+
+// ImportStats counts the records of each type that were imported and those
+// that were skipped, either because of the policy or because they referred
+// to a user or chirp that was itself skipped.
+type ImportStats struct {
+	Imported map[string]int `json:"imported"`
+	Skipped  map[string]int `json:"skipped"`
+}
+
+// Importer writes exported records into db. Records must arrive in the
+// order Export emits them.
+type Importer struct {
+	db     Storage
+	policy ImportPolicy
+	// users and chirps map ids in the export to stored ids; zero means
+	// the record was skipped and anything referring to it is too.
+	users  map[int]int
+	chirps map[int]int
+	Stats  ImportStats
+}
+
+func NewImporter(db Storage, policy ImportPolicy) *Importer {
+	return &Importer{
+		db:     db,
+		policy: policy,
+		users:  make(map[int]int),
+		chirps: make(map[int]int),
+		Stats:  ImportStats{Imported: make(map[string]int), Skipped: make(map[string]int)},
+	}
+}
+
+// Import stores record according to the importer's policy.
+func (im *Importer) Import(record Record) error {
+	var imported bool
+	var err error
+	switch data := record.Data.(type) {
+	case User:
+		imported, err = im.importUser(data)
+	case Follow:
+		imported, err = im.importFollow(data)
+	case Chirp:
+		imported, err = im.importChirp(data)
+	case Like:
+		imported, err = im.importLike(data)
+	default:
+		return fmt.Errorf("cannot import a %T", record.Data)
+	}
+	if err != nil {
+		return fmt.Errorf("importing %s: %w", record.Type, err)
+	}
+	if imported {
+		im.Stats.Imported[record.Type]++
+	} else {
+		im.Stats.Skipped[record.Type]++
+	}
+	return nil
+}
+
+func (im *Importer) importUser(user User) (bool, error) {
+	exportedId := user.Id
+	im.users[exportedId] = 0
+	user.Password = nil
+	byEmail, err := im.db.GetUser(user.Email)
+	if err != nil && !errors.Is(err, ErrUserDoesNotExist) {
+		return false, err
+	}
+	emailTaken := err == nil
+
+	switch im.policy {
+	case ImportRemap:
+		if emailTaken {
+			im.users[exportedId] = byEmail.Id
+			return false, nil
+		}
+		user.Id = 0
+	case ImportSkip, ImportOverwrite:
+		_, err := im.db.GetUserById(user.Id)
+		if err != nil && !errors.Is(err, ErrUserDoesNotExist) {
+			return false, err
+		}
+		exists := err == nil
+		if emailTaken && byEmail.Id != user.Id {
+			// Someone else here has this email, so the account cannot
+			// be brought over under either id.
+			return false, nil
+		}
+		if exists && im.policy == ImportSkip {
+			im.users[exportedId] = user.Id
+			return false, nil
+		}
+	}
+
+	stored, err := im.db.PutUser(user)
+	if errors.Is(err, ErrHandleTaken) {
+		user.Handle = ""
+		stored, err = im.db.PutUser(user)
+	}
+	if err != nil {
+		return false, err
+	}
+	im.users[exportedId] = stored.Id
+	return true, nil
+}
+
+func (im *Importer) importFollow(follow Follow) (bool, error) {
+	follow.FollowerId, follow.FolloweeId = im.users[follow.FollowerId], im.users[follow.FolloweeId]
+	if follow.FollowerId == 0 || follow.FolloweeId == 0 || follow.FollowerId == follow.FolloweeId {
+		return false, nil
+	}
+	return true, im.db.PutFollow(follow)
+}
+
+func (im *Importer) importChirp(chirp Chirp) (bool, error) {
+	exportedId := chirp.Id
+	im.chirps[exportedId] = 0
+	chirp.AuthorId = im.users[chirp.AuthorId]
+	if chirp.AuthorId == 0 {
+		return false, nil
+	}
+	if chirp.ParentId != nil {
+		parentId, found := im.chirps[*chirp.ParentId]
+		if !found && im.policy != ImportRemap {
+			// The parent was not in the export but may already be here.
+			if _, ok, err := im.db.GetChirp(*chirp.ParentId); err != nil {
+				return false, err
+			} else if ok {
+				parentId = *chirp.ParentId
+			}
+		}
+		if parentId == 0 {
+			if found {
+				return false, nil
+			}
+			chirp.ParentId = nil
+		} else {
+			chirp.ParentId = &parentId
+		}
+	}
+
+	switch im.policy {
+	case ImportRemap:
+		chirp.Id = 0
+	case ImportSkip:
+		stored, found, err := im.db.GetChirp(chirp.Id)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			// Only treat the stored chirp as this one if the same person
+			// wrote it; otherwise replies and likes are skipped with it.
+			if stored.AuthorId == chirp.AuthorId {
+				im.chirps[exportedId] = chirp.Id
+			}
+			return false, nil
+		}
+	}
+
+	stored, err := im.db.PutChirp(chirp)
+	if err != nil {
+		return false, err
+	}
+	im.chirps[exportedId] = stored.Id
+	return true, nil
+}
+
+func (im *Importer) importLike(like Like) (bool, error) {
+	like.UserId, like.ChirpId = im.users[like.UserId], im.chirps[like.ChirpId]
+	if like.UserId == 0 || like.ChirpId == 0 {
+		return false, nil
+	}
+	return true, im.db.PutLike(like)
+}
+
+// ReadRecords decodes an export from r, either one record per line or a
+// single JSON array, and passes each record to fn as it is read.
+func ReadRecords(r io.Reader, fn func(Record) error) error {
+	buffered := bufio.NewReader(r)
+	decoder := json.NewDecoder(buffered)
+	array := false
+	for {
+		b, err := buffered.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			array = b[0] == '['
+			break
+		}
+		buffered.ReadByte()
+	}
+	if array {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+	for decoder.More() {
+		var raw struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+		record, err := decodeRecord(raw.Type, raw.Data)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeRecord(recordType string, data json.RawMessage) (Record, error) {
+	var err error
+	record := Record{Type: recordType}
+	switch recordType {
+	case RecordUser:
+		var user User
+		err = json.Unmarshal(data, &user)
+		record.Data = user
+	case RecordFollow:
+		var follow Follow
+		err = json.Unmarshal(data, &follow)
+		record.Data = follow
+	case RecordChirp:
+		var chirp Chirp
+		err = json.Unmarshal(data, &chirp)
+		record.Data = chirp
+	case RecordLike:
+		var like Like
+		err = json.Unmarshal(data, &like)
+		record.Data = like
+	default:
+		return Record{}, fmt.Errorf("unknown record type %q", recordType)
+	}
+	return record, err
+}
+
+// PutUser stores user under its Id, or a new one when Id is zero. A stored
+// user keeps their password; a new one has none until they set one.
+func (db *DB) PutUser(user User) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user.Email = normalizeEmail(user.Email)
+	if user.Id == 0 {
+		user.Id = dbStruct.NextUserId
+	}
+	for id, other := range dbStruct.Users {
+		if id == user.Id {
+			continue
+		}
+		if other.Email == user.Email {
+			return User{}, ErrUserAlreadyExists
+		}
+		if user.Handle != "" && other.Handle == user.Handle {
+			return User{}, ErrHandleTaken
+		}
+	}
+	user.Password = dbStruct.Users[user.Id].Password
+	dbStruct.Users[user.Id] = user
+	dbStruct.NextUserId = max(dbStruct.NextUserId, user.Id+1)
+	if err := db.writeDB(dbStruct); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// PutChirp stores chirp under its Id, or a new one when Id is zero, keeping
+// its CreatedAt and EditedAt. Its entities are extracted again and its
+// counts come from the likes and replies stored with it.
+func (db *DB) PutChirp(chirp Chirp) (Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.ParentId != nil {
+		if _, found := dbStruct.Chirps[*chirp.ParentId]; !found {
+			return Chirp{}, ErrParentDoesNotExist
+		}
+	}
+	if chirp.Id == 0 {
+		if chirp.Id, err = db.nextChirpId(dbStruct); err != nil {
+			return Chirp{}, err
+		}
+	}
+	if other, taken := dbStruct.ChirpShortIds[chirp.ShortId]; taken && other != chirp.Id {
+		chirp.ShortId = ""
+	}
+	if old, found := dbStruct.Chirps[chirp.Id]; found {
+		delete(dbStruct.ChirpShortIds, old.ShortId)
+		if chirp.ShortId == "" {
+			chirp.ShortId = old.ShortId
+		}
+	}
+	if err := dbStruct.assignShortId(&chirp); err != nil {
+		return Chirp{}, err
+	}
+	if old, found := dbStruct.Chirps[chirp.Id]; found && old.ParentId != nil {
+		dbStruct.Replies[*old.ParentId] = slices.DeleteFunc(dbStruct.Replies[*old.ParentId], func(id int) bool {
+			return id == chirp.Id
+		})
+		if parent, found := dbStruct.Chirps[*old.ParentId]; found && old.DeletedAt == nil {
+			parent.ReplyCount--
+			dbStruct.Chirps[parent.Id] = parent
+		}
+	}
+	if old, found := dbStruct.Chirps[chirp.Id]; found && old.DeletedAt == nil {
+		dbStruct.countRechirp(old, -1)
+	}
+	if chirp.DeletedAt == nil {
+		dbStruct.countRechirp(chirp, 1)
+	}
+	if chirp.ParentId != nil {
+		replies := append(dbStruct.Replies[*chirp.ParentId], chirp.Id)
+		slices.Sort(replies)
+		dbStruct.Replies[*chirp.ParentId] = replies
+		if chirp.DeletedAt == nil {
+			parent := dbStruct.Chirps[*chirp.ParentId]
+			parent.ReplyCount++
+			dbStruct.Chirps[parent.Id] = parent
+		}
+	}
+	chirp.Entities = richtext.Extract(chirp.Body)
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	if chirp.CreatedAt.IsZero() {
+		chirp.CreatedAt = time.Now().UTC()
+	}
+	chirp.LikeCount = 0
+	for _, liked := range dbStruct.Likes {
+		if _, ok := liked[chirp.Id]; ok {
+			chirp.LikeCount++
+		}
+	}
+	chirp.ReplyCount = 0
+	for _, id := range dbStruct.Replies[chirp.Id] {
+		if _, live := dbStruct.liveChirp(id); live {
+			chirp.ReplyCount++
+		}
+	}
+	chirp.RechirpCount = 0
+	for _, other := range dbStruct.Chirps {
+		if other.RechirpOfId != nil && *other.RechirpOfId == chirp.Id && other.DeletedAt == nil && other.Id != chirp.Id {
+			chirp.RechirpCount++
+		}
+	}
+	dbStruct.Chirps[chirp.Id] = chirp
+	dbStruct.ChirpShortIds[chirp.ShortId] = chirp.Id
+	dbStruct.advanceNextChirpId(chirp.Id)
+	if err := db.writeDB(dbStruct); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PutFollow records follow with its original time.
+func (db *DB) PutFollow(follow Follow) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Users[follow.FolloweeId]; !found {
+		return ErrUserDoesNotExist
+	}
+	if dbStruct.Follows[follow.FollowerId] == nil {
+		dbStruct.Follows[follow.FollowerId] = make(map[int]time.Time)
+	}
+	dbStruct.Follows[follow.FollowerId][follow.FolloweeId] = follow.CreatedAt
+	return db.writeDB(dbStruct)
+}
+
+// PutLike records like with its original time.
+func (db *DB) PutLike(like Like) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, found := dbStruct.Chirps[like.ChirpId]
+	if !found {
+		return notFound(ErrChirpDoesNotExist, like.ChirpId)
+	}
+	if dbStruct.Likes[like.UserId] == nil {
+		dbStruct.Likes[like.UserId] = make(map[int]time.Time)
+	}
+	if _, liked := dbStruct.Likes[like.UserId][like.ChirpId]; !liked {
+		chirp.LikeCount++
+		dbStruct.Chirps[chirp.Id] = chirp
+	}
+	dbStruct.Likes[like.UserId][like.ChirpId] = like.CreatedAt
+	return db.writeDB(dbStruct)
+}
+
+func (db *SQLDB) PutUser(user User) (User, error) {
+	user.Email = normalizeEmail(user.Email)
+	byEmail, found, err := db.getUserByEmail(user.Email)
+	if err != nil {
+		return User{}, err
+	}
+	if found && byEmail.Id != user.Id {
+		return User{}, ErrUserAlreadyExists
+	}
+	if user.Handle != "" {
+		var taken int
+		err := db.queryRow(`SELECT COUNT(*) FROM users WHERE handle = ? AND id <> ?`, user.Handle, user.Id).Scan(&taken)
+		if err != nil {
+			return User{}, err
+		}
+		if taken > 0 {
+			return User{}, ErrHandleTaken
+		}
+	}
+	if user.Id == 0 {
+		err = db.queryRow(`INSERT INTO users (email, password, is_chirpy_red, verified, handle, display_name, bio, avatar_url, banner_url,
+				website, website_verified_at, moved_to, moved_from, feed_algorithm)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			user.Email, []byte{}, user.IsChirpyRed, user.Verified, user.Handle, user.DisplayName, user.Bio, user.AvatarURL, user.BannerURL,
+			user.Website, user.WebsiteVerifiedAt, user.MovedTo, user.MovedFrom, user.FeedAlgorithm).
+			Scan(&user.Id)
+		return user, err
+	}
+	_, err = db.exec(`INSERT INTO users (id, email, password, is_chirpy_red, verified, handle, display_name, bio, avatar_url, banner_url,
+			website, website_verified_at, moved_to, moved_from, feed_algorithm)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET email = excluded.email, is_chirpy_red = excluded.is_chirpy_red, verified = excluded.verified,
+			handle = excluded.handle, display_name = excluded.display_name, bio = excluded.bio, avatar_url = excluded.avatar_url,
+			banner_url = excluded.banner_url, website = excluded.website, website_verified_at = excluded.website_verified_at,
+			moved_to = excluded.moved_to, moved_from = excluded.moved_from, feed_algorithm = excluded.feed_algorithm`,
+		user.Id, user.Email, []byte{}, user.IsChirpyRed, user.Verified, user.Handle, user.DisplayName, user.Bio, user.AvatarURL, user.BannerURL,
+		user.Website, user.WebsiteVerifiedAt, user.MovedTo, user.MovedFrom, user.FeedAlgorithm)
+	if err != nil {
+		return User{}, err
+	}
+	return user, db.resetSerial("users")
+}
+
+func (db *SQLDB) PutChirp(chirp Chirp) (Chirp, error) {
+	if chirp.ParentId != nil {
+		if _, found, err := db.GetChirp(*chirp.ParentId); err != nil {
+			return Chirp{}, err
+		} else if !found {
+			return Chirp{}, ErrParentDoesNotExist
+		}
+	}
+	chirp.Entities = richtext.Extract(chirp.Body)
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	if chirp.CreatedAt.IsZero() {
+		chirp.CreatedAt = time.Now().UTC()
+	}
+	entities, err := json.Marshal(chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	media, err := json.Marshal(chirp.Media)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.ShortId != "" {
+		other, found, err := db.GetChirpByShortId(chirp.ShortId)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if found && other.Id != chirp.Id {
+			chirp.ShortId = ""
+		}
+	}
+	if chirp.ShortId == "" && chirp.Id != 0 {
+		if old, found, err := db.GetChirpWithDeleted(chirp.Id); err != nil {
+			return Chirp{}, err
+		} else if found {
+			chirp.ShortId = old.ShortId
+		}
+	}
+	if chirp.ShortId == "" {
+		if chirp.ShortId, err = newShortId(); err != nil {
+			return Chirp{}, err
+		}
+	}
+	if chirp.Id == 0 {
+		err = db.queryRow(`INSERT INTO chirps (short_id, body, author_id, parent_id, edited_at, entities, media, created_at, deleted_at, censored, rechirp_of_id, quoted_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			chirp.ShortId, chirp.Body, chirp.AuthorId, chirp.ParentId, chirp.EditedAt, string(entities), string(media), chirp.CreatedAt, chirp.DeletedAt, chirp.Censored,
+			chirp.RechirpOfId, chirp.QuotedId).Scan(&chirp.Id)
+	} else {
+		_, err = db.exec(`INSERT INTO chirps (id, short_id, body, author_id, parent_id, edited_at, entities, media, created_at, deleted_at, censored, rechirp_of_id, quoted_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET short_id = excluded.short_id, body = excluded.body, author_id = excluded.author_id, parent_id = excluded.parent_id,
+				edited_at = excluded.edited_at, entities = excluded.entities, media = excluded.media, created_at = excluded.created_at, deleted_at = excluded.deleted_at,
+				censored = excluded.censored, rechirp_of_id = excluded.rechirp_of_id, quoted_id = excluded.quoted_id`,
+			chirp.Id, chirp.ShortId, chirp.Body, chirp.AuthorId, chirp.ParentId, chirp.EditedAt, string(entities), string(media), chirp.CreatedAt, chirp.DeletedAt, chirp.Censored,
+			chirp.RechirpOfId, chirp.QuotedId)
+		if err == nil {
+			err = db.resetSerial("chirps")
+		}
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	if chirp.DeletedAt != nil {
+		_, err = db.exec(`DELETE FROM chirp_tags WHERE chirp_id = ?`, chirp.Id)
+	} else {
+		err = db.setChirpTags(chirp)
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	stored, _, err := db.GetChirpWithDeleted(chirp.Id)
+	return stored, err
+}
+
+func (db *SQLDB) PutFollow(follow Follow) error {
+	if _, err := db.GetUserById(follow.FolloweeId); err != nil {
+		return err
+	}
+	_, err := db.exec(`INSERT INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (follower_id, followee_id) DO UPDATE SET created_at = excluded.created_at`,
+		follow.FollowerId, follow.FolloweeId, follow.CreatedAt)
+	return err
+}
+
+func (db *SQLDB) PutLike(like Like) error {
+	if _, found, err := db.GetChirp(like.ChirpId); err != nil {
+		return err
+	} else if !found {
+		return notFound(ErrChirpDoesNotExist, like.ChirpId)
+	}
+	_, err := db.exec(`INSERT INTO likes (user_id, chirp_id, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, chirp_id) DO UPDATE SET created_at = excluded.created_at`,
+		like.UserId, like.ChirpId, like.CreatedAt)
+	return err
+}
+
+// resetSerial moves table's id sequence past rows inserted with explicit
+// ids, so the next insert without one does not collide with them.
+func (db *SQLDB) resetSerial(table string) error {
+	if db.dialect.resetSerial == "" {
+		return nil
+	}
+	_, err := db.exec(fmt.Sprintf(db.dialect.resetSerial, table))
+	return err
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Job states. Queued jobs run once RunAt has passed; jobs that fail are
+// queued again for later until they run out of attempts and are dead. A
+// running job's RunAt is when its lease ends: a worker that stops renewing
+// it, because it or its server died, loses the job to the next claim.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Job is a unit of background work. Kind says which handler runs it and
+// Payload is that handler's JSON input; Result is its output once done.
+// RequestId is the id of the request that enqueued it, if any.
+type Job struct {
+	Id          int             `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	RequestId   string          `json:"request_id,omitempty"`
+}
+
+// EnqueueJob stores job as queued. A zero RunAt runs it as soon as possible.
+func (db *DB) EnqueueJob(job Job) (Job, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Job{}, err
+	}
+	dbStruct.NextJobId = max(dbStruct.NextJobId, 1)
+	job.Id = dbStruct.NextJobId
+	dbStruct.NextJobId++
+	job.newQueued(time.Now().UTC())
+	dbStruct.Jobs[job.Id] = job
+	if err := db.writeDB(dbStruct); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func (job *Job) newQueued(now time.Time) {
+	job.State = JobQueued
+	job.Attempts = 0
+	job.LastError = ""
+	job.Result = nil
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+	job.RunAt = job.RunAt.UTC()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+}
+
+// ClaimJob marks the job that has waited longest since its RunAt as running
+// under a lease until now+lease and returns it, or reports false if none is
+// due. Running jobs whose lease has run out are claimed again.
+func (db *DB) ClaimJob(now time.Time, lease time.Duration) (Job, bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Job{}, false, err
+	}
+	var due []Job
+	for _, job := range dbStruct.Jobs {
+		if (job.State == JobQueued || job.State == JobRunning) && !job.RunAt.After(now) {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return Job{}, false, nil
+	}
+	job := slices.MinFunc(due, func(a, b Job) int {
+		if c := a.RunAt.Compare(b.RunAt); c != 0 {
+			return c
+		}
+		return a.Id - b.Id
+	})
+	if job.State == JobRunning {
+		job.LastError = jobLeaseExpired
+	}
+	job.State = JobRunning
+	job.Attempts++
+	job.RunAt = now.Add(lease).UTC()
+	job.UpdatedAt = now.UTC()
+	dbStruct.Jobs[job.Id] = job
+	if err := db.writeDB(dbStruct); err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+// jobLeaseExpired is recorded on jobs claimed again after their lease ran out.
+const jobLeaseExpired = "lease expired"
+
+// ExtendJobLease moves the end of a running job's lease to until. It returns
+// ErrJobLeaseLost if the job has since been claimed again or finished.
+func (db *DB) ExtendJobLease(id, attempts int, until time.Time) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	job, found := dbStruct.Jobs[id]
+	if !found {
+		return notFound(ErrJobDoesNotExist, id)
+	}
+	if job.State != JobRunning || job.Attempts != attempts {
+		return ErrJobLeaseLost
+	}
+	job.RunAt = until.UTC()
+	dbStruct.Jobs[id] = job
+	return db.writeDB(dbStruct)
+}
+
+// CompleteJob marks a running job done with its result.
+func (db *DB) CompleteJob(id int, result json.RawMessage) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	job, found := dbStruct.Jobs[id]
+	if !found {
+		return notFound(ErrJobDoesNotExist, id)
+	}
+	job.State = JobDone
+	job.Result = result
+	job.UpdatedAt = time.Now().UTC()
+	dbStruct.Jobs[id] = job
+	return db.writeDB(dbStruct)
+}
+
+// FailJob records why a running job failed and queues it again at retryAt,
+// or marks it dead when retryAt is nil.
+func (db *DB) FailJob(id int, reason string, retryAt *time.Time) (Job, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Job{}, err
+	}
+	job, found := dbStruct.Jobs[id]
+	if !found {
+		return Job{}, notFound(ErrJobDoesNotExist, id)
+	}
+	job.failed(reason, retryAt, time.Now().UTC())
+	dbStruct.Jobs[id] = job
+	if err := db.writeDB(dbStruct); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func (job *Job) failed(reason string, retryAt *time.Time, now time.Time) {
+	job.LastError = reason
+	job.UpdatedAt = now
+	if retryAt == nil {
+		job.State = JobDead
+		return
+	}
+	job.State = JobQueued
+	job.RunAt = retryAt.UTC()
+}
+
+func (db *DB) GetJob(id int) (Job, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Job{}, err
+	}
+	job, found := dbStruct.Jobs[id]
+	if !found {
+		return Job{}, notFound(ErrJobDoesNotExist, id)
+	}
+	return job, nil
+}
+
+// GetJobs returns the jobs in state, or every job when state is empty,
+// oldest first.
+func (db *DB) GetJobs(state string) ([]Job, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	for _, job := range dbStruct.Jobs {
+		if state == "" || job.State == state {
+			jobs = append(jobs, job)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b Job) int { return a.Id - b.Id })
+	return jobs, nil
+}
+
+// RequeueJob gives a dead job a fresh set of attempts, starting now.
+func (db *DB) RequeueJob(id int) (Job, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Job{}, err
+	}
+	job, found := dbStruct.Jobs[id]
+	if !found {
+		return Job{}, notFound(ErrJobDoesNotExist, id)
+	}
+	if job.State != JobDead {
+		return Job{}, ErrJobNotDead
+	}
+	now := time.Now().UTC()
+	job.State = JobQueued
+	job.Attempts = 0
+	job.RunAt = now
+	job.UpdatedAt = now
+	dbStruct.Jobs[id] = job
+	if err := db.writeDB(dbStruct); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+const jobColumns = `id, kind, payload, state, attempts, max_attempts, run_at, last_error, result, created_at, updated_at, request_id`
+
+func scanJob(row scanner) (Job, error) {
+	job := Job{}
+	var payload string
+	var result sql.NullString
+	err := row.Scan(&job.Id, &job.Kind, &payload, &job.State, &job.Attempts, &job.MaxAttempts, &job.RunAt,
+		&job.LastError, &result, &job.CreatedAt, &job.UpdatedAt, &job.RequestId)
+	job.Payload = json.RawMessage(payload)
+	if result.Valid {
+		job.Result = json.RawMessage(result.String)
+	}
+	return job, err
+}
+
+func (db *SQLDB) EnqueueJob(job Job) (Job, error) {
+	job.newQueued(time.Now().UTC())
+	err := db.queryRow(`INSERT INTO jobs (kind, payload, state, attempts, max_attempts, run_at, last_error, created_at, updated_at, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		job.Kind, string(job.Payload), job.State, job.Attempts, job.MaxAttempts, job.RunAt, job.LastError, job.CreatedAt, job.UpdatedAt, job.RequestId).
+		Scan(&job.Id)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimJob claims with a single conditional update, so that several
+// servers polling the same database never run a job twice.
+func (db *SQLDB) ClaimJob(now time.Time, lease time.Duration) (Job, bool, error) {
+	job, err := scanJob(db.queryRow(`UPDATE jobs SET state = ?, attempts = attempts + 1, run_at = ?, updated_at = ?,
+			last_error = CASE WHEN state = ? THEN ? ELSE last_error END
+		WHERE id = (SELECT id FROM jobs WHERE state IN (?, ?) AND run_at <= ? ORDER BY run_at, id LIMIT 1)
+			AND state IN (?, ?) AND run_at <= ?
+		RETURNING `+jobColumns,
+		JobRunning, now.Add(lease).UTC(), now.UTC(), JobRunning, jobLeaseExpired,
+		JobQueued, JobRunning, now.UTC(), JobQueued, JobRunning, now.UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, err
+	}
+	return job, true, nil
+}
+
+func (db *SQLDB) ExtendJobLease(id, attempts int, until time.Time) error {
+	res, err := db.exec(`UPDATE jobs SET run_at = ? WHERE id = ? AND state = ? AND attempts = ?`,
+		until.UTC(), id, JobRunning, attempts)
+	if err != nil {
+		return err
+	}
+	if err := requireRow(res, ErrJobLeaseLost); err != nil {
+		if _, getErr := db.GetJob(id); getErr != nil {
+			return getErr
+		}
+		return err
+	}
+	return nil
+}
+
+func (db *SQLDB) CompleteJob(id int, result json.RawMessage) error {
+	res, err := db.exec(`UPDATE jobs SET state = ?, result = ?, updated_at = ? WHERE id = ?`,
+		JobDone, string(result), time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res, notFound(ErrJobDoesNotExist, id))
+}
+
+func (db *SQLDB) FailJob(id int, reason string, retryAt *time.Time) (Job, error) {
+	job, err := db.GetJob(id)
+	if err != nil {
+		return Job{}, err
+	}
+	job.failed(reason, retryAt, time.Now().UTC())
+	_, err = db.exec(`UPDATE jobs SET state = ?, run_at = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+		job.State, job.RunAt, job.LastError, job.UpdatedAt, id)
+	if err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+func (db *SQLDB) GetJob(id int) (Job, error) {
+	job, err := scanJob(db.queryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Job{}, notFound(ErrJobDoesNotExist, id)
+	}
+	return job, err
+}
+
+func (db *SQLDB) GetJobs(state string) ([]Job, error) {
+	query, args := `SELECT `+jobColumns+` FROM jobs`, []any{}
+	if state != "" {
+		query, args = query+` WHERE state = ?`, append(args, state)
+	}
+	rows, err := db.query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (db *SQLDB) RequeueJob(id int) (Job, error) {
+	job, err := db.GetJob(id)
+	if err != nil {
+		return Job{}, err
+	}
+	now := time.Now().UTC()
+	res, err := db.exec(`UPDATE jobs SET state = ?, attempts = 0, run_at = ?, updated_at = ? WHERE id = ? AND state = ?`,
+		JobQueued, now, now, id, JobDead)
+	if err != nil {
+		return Job{}, err
+	}
+	if err := requireRow(res, ErrJobNotDead); err != nil {
+		return Job{}, err
+	}
+	job.State, job.Attempts, job.RunAt, job.UpdatedAt = JobQueued, 0, now, now
+	return job, nil
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// journalSuffix is added to the database path to name its journal.
+const journalSuffix = ".journal"
+
+// journalCompactAfter is how many entries the journal collects before they
+// are folded into a new snapshot.
+const journalCompactAfter = 500
+
+// Mutations recorded in the journal instead of rewriting the snapshot.
+const (
+	journalCreateChirp   = "create_chirp"
+	journalCreateUser    = "create_user"
+	journalDeleteSession = "delete_session"
+)
+
+// journal is the append-only log of the gob store's most frequent
+// mutations since its last snapshot. Appending an entry is much cheaper than
+// rewriting the whole file, and loading the database replays whatever the
+// snapshot does not hold yet.
+type journal struct {
+	path    string
+	seq     uint64 // last sequence number appended
+	entries int    // entries appended since the last snapshot
+}
+
+// journalEntry is one mutation. Seq orders entries and tells replay which
+// ones a snapshot already holds; the other fields depend on Op.
+type journalEntry struct {
+	Seq       uint64
+	Op        string
+	Chirp     Chirp
+	User      User
+	SessionId string
+}
+
+// apply makes entry's change to dbStruct.
+func (entry journalEntry) apply(dbStruct *DBStructure) {
+	switch entry.Op {
+	case journalCreateChirp:
+		dbStruct.addChirp(entry.Chirp)
+	case journalCreateUser:
+		dbStruct.addUser(entry.User)
+	case journalDeleteSession:
+		delete(dbStruct.Sessions, entry.SessionId)
+	}
+	dbStruct.JournalSeq = max(dbStruct.JournalSeq, entry.Seq)
+}
+
+// commit records entry, already applied to dbStruct, in the journal. The
+// journal is folded into a new snapshot of dbStruct once it grows long.
+func (db *DB) commit(dbStruct DBStructure, entry journalEntry) error {
+	if db.batch != nil {
+		return db.writeDB(dbStruct)
+	}
+	db.mux.Lock()
+	entry.Seq = db.journal.seq + 1
+	err := appendJournal(db.journal.path, entry)
+	if err == nil {
+		db.journal.seq = entry.Seq
+		db.journal.entries++
+	}
+	compact := db.journal.entries >= journalCompactAfter
+	db.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	if compact {
+		dbStruct.JournalSeq = entry.Seq
+		return db.writeDB(dbStruct)
+	}
+	return nil
+}
+
+// Compact folds the journal into a new snapshot, if it has any entries.
+func (db *DB) Compact() error {
+	db.mux.RLock()
+	entries := db.journal.entries
+	db.mux.RUnlock()
+	if entries == 0 {
+		return nil
+	}
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	return db.writeDB(dbStruct)
+}
+
+// appendJournal writes entry to the end of the journal at path and waits
+// for it to reach the disk. Each record is its length and checksum followed
+// by the entry, so a record torn by a crash is recognised and ignored.
+func appendJournal(path string, entry journalEntry) error {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(entry); err != nil {
+		return err
+	}
+	record := binary.BigEndian.AppendUint32(nil, uint32(payload.Len()))
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(payload.Bytes()))
+	record = append(record, payload.Bytes()...)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(record); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readJournal returns the entries in the journal at path, stopping at the
+// first record that was not completely written.
+func readJournal(path string) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var entries []journalEntry
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return entries, nil
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return entries, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return entries, nil
+		}
+		entry := journalEntry{}
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&entry); err != nil {
+			return entries, nil
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// replayJournal applies the journal entries dbStruct does not hold yet.
+func (db *DB) replayJournal(dbStruct *DBStructure) error {
+	entries, err := readJournal(db.journal.path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Seq > dbStruct.JournalSeq {
+			entry.apply(dbStruct)
+		}
+	}
+	return nil
+}
+
+// trimJournal drops the entries up to seq, which a snapshot now holds. It
+// must be called with the write lock held.
+func (db *DB) trimJournal(seq uint64) error {
+	entries, err := readJournal(db.journal.path)
+	if err != nil {
+		return err
+	}
+	var kept []journalEntry
+	for _, entry := range entries {
+		if entry.Seq > seq {
+			kept = append(kept, entry)
+		}
+	}
+	db.journal.entries = len(kept)
+	if len(kept) == 0 {
+		err := os.Remove(db.journal.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	dir, base := filepath.Split(db.path)
+	tmp := filepath.Join(dir, base+journalSuffix+".tmp")
+	os.Remove(tmp)
+	for _, entry := range kept {
+		if err := appendJournal(tmp, entry); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, db.journal.path)
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// LikeChirp records that userId likes chirpId. Liking a chirp twice is not an error.
+func (db *DB) LikeChirp(chirpId, userId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, found := dbStruct.liveChirp(chirpId)
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	if _, liked := dbStruct.Likes[userId][chirpId]; liked {
+		return nil
+	}
+	if dbStruct.Likes[userId] == nil {
+		dbStruct.Likes[userId] = make(map[int]time.Time)
+	}
+	dbStruct.Likes[userId][chirpId] = time.Now().UTC()
+	chirp.LikeCount++
+	dbStruct.Chirps[chirpId] = chirp
+	return db.writeDB(dbStruct)
+}
+
+// UnlikeChirp removes userId's like from chirpId, if there is one.
+func (db *DB) UnlikeChirp(chirpId, userId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, found := dbStruct.liveChirp(chirpId)
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	if _, liked := dbStruct.Likes[userId][chirpId]; !liked {
+		return nil
+	}
+	delete(dbStruct.Likes[userId], chirpId)
+	chirp.LikeCount--
+	dbStruct.Chirps[chirpId] = chirp
+	return db.writeDB(dbStruct)
+}
+
+// GetLikedChirps returns the chirps userId has liked, most recently liked first.
+func (db *DB) GetLikedChirps(userId int) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	liked := dbStruct.Likes[userId]
+	chirps := make([]Chirp, 0, len(liked))
+	for chirpId := range liked {
+		if chirp, found := dbStruct.liveChirp(chirpId); found {
+			chirps = append(chirps, chirp)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		return liked[b.Id].Compare(liked[a.Id])
+	})
+	return chirps, nil
+}
+
+func (db *SQLDB) LikeChirp(chirpId, userId int) error {
+	_, found, err := db.GetChirp(chirpId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	_, err = db.exec(`INSERT INTO likes (user_id, chirp_id, created_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		userId, chirpId, time.Now().UTC())
+	return err
+}
+
+func (db *SQLDB) UnlikeChirp(chirpId, userId int) error {
+	_, found, err := db.GetChirp(chirpId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	_, err = db.exec(`DELETE FROM likes WHERE user_id = ? AND chirp_id = ?`, userId, chirpId)
+	return err
+}
+
+func (db *SQLDB) GetLikedChirps(userId int) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps
+		JOIN likes ON likes.chirp_id = chirps.id
+		WHERE likes.user_id = ? AND chirps.deleted_at IS NULL
+		ORDER BY likes.created_at DESC`, userId)
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"cmp"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// Link is a short link standing in for a URL in a chirp. Following it
+// redirects to URL and, while tracking is on, counts a click.
+type Link struct {
+	Code      string    `json:"code"`
+	ChirpId   int       `json:"chirp_id"`
+	URL       string    `json:"url"`
+	Clicks    int       `json:"clicks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	linkCodeLength   = 7
+	linkCodeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+func newLinkCode() (string, error) {
+	code := make([]byte, linkCodeLength)
+	max := big.NewInt(int64(len(linkCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = linkCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// CreateLinks makes sure the chirp has a short link for each of urls and
+// returns all of its links. URLs that already have one keep their code.
+func (db *DB) CreateLinks(chirpId int, urls []string) ([]Link, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	if _, found := dbStruct.liveChirp(chirpId); !found {
+		return nil, notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	existing := make(map[string]bool)
+	for _, link := range dbStruct.Links {
+		if link.ChirpId == chirpId {
+			existing[link.URL] = true
+		}
+	}
+	for _, url := range urls {
+		if existing[url] {
+			continue
+		}
+		code, err := newLinkCode()
+		if err != nil {
+			return nil, err
+		}
+		for dbStruct.Links[code].Code != "" {
+			if code, err = newLinkCode(); err != nil {
+				return nil, err
+			}
+		}
+		dbStruct.Links[code] = Link{Code: code, ChirpId: chirpId, URL: url, CreatedAt: time.Now().UTC()}
+		existing[url] = true
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return nil, err
+	}
+	return dbStruct.linksFor([]int{chirpId})[chirpId], nil
+}
+
+// GetLinks returns the short links of each chirp, oldest first.
+func (db *DB) GetLinks(chirpIds []int) (map[int][]Link, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	return dbStruct.linksFor(chirpIds), nil
+}
+
+func (dbStruct DBStructure) linksFor(chirpIds []int) map[int][]Link {
+	links := make(map[int][]Link, len(chirpIds))
+	for _, link := range dbStruct.Links {
+		if slices.Contains(chirpIds, link.ChirpId) {
+			links[link.ChirpId] = append(links[link.ChirpId], link)
+		}
+	}
+	for _, chirpLinks := range links {
+		slices.SortFunc(chirpLinks, func(a, b Link) int {
+			if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Code, b.Code)
+		})
+	}
+	return links
+}
+
+// FollowLink looks up a short link, counting a click if track is set. Links
+// in deleted chirps are not found.
+func (db *DB) FollowLink(code string, track bool) (Link, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Link{}, err
+	}
+	link, found := dbStruct.Links[code]
+	if _, live := dbStruct.liveChirp(link.ChirpId); !found || !live {
+		return Link{}, notFound(ErrLinkDoesNotExist, code)
+	}
+	if !track {
+		return link, nil
+	}
+	link.Clicks++
+	dbStruct.Links[code] = link
+	if err := db.writeDB(dbStruct); err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+const linkColumns = `code, chirp_id, url, clicks, created_at`
+
+func scanLink(row scanner) (Link, error) {
+	link := Link{}
+	err := row.Scan(&link.Code, &link.ChirpId, &link.URL, &link.Clicks, &link.CreatedAt)
+	return link, err
+}
+
+func (db *SQLDB) CreateLinks(chirpId int, urls []string) ([]Link, error) {
+	_, found, err := db.GetChirp(chirpId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	links, err := db.GetLinks([]int{chirpId})
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool)
+	for _, link := range links[chirpId] {
+		existing[link.URL] = true
+	}
+	for _, url := range urls {
+		if existing[url] {
+			continue
+		}
+		code, err := newLinkCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.exec(`INSERT INTO links (code, chirp_id, url, created_at) VALUES (?, ?, ?, ?)`,
+			code, chirpId, url, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		existing[url] = true
+	}
+	links, err = db.GetLinks([]int{chirpId})
+	if err != nil {
+		return nil, err
+	}
+	return links[chirpId], nil
+}
+
+func (db *SQLDB) GetLinks(chirpIds []int) (map[int][]Link, error) {
+	links := make(map[int][]Link, len(chirpIds))
+	if len(chirpIds) == 0 {
+		return links, nil
+	}
+	args := make([]any, len(chirpIds))
+	for i, id := range chirpIds {
+		args[i] = id
+	}
+	rows, err := db.query(`SELECT `+linkColumns+` FROM links WHERE chirp_id IN (`+placeholders(len(chirpIds))+`) ORDER BY created_at, code`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links[link.ChirpId] = append(links[link.ChirpId], link)
+	}
+	return links, rows.Err()
+}
+
+func (db *SQLDB) FollowLink(code string, track bool) (Link, error) {
+	if track {
+		result, err := db.exec(`UPDATE links SET clicks = clicks + 1 WHERE code = ?
+			AND chirp_id IN (SELECT id FROM chirps WHERE deleted_at IS NULL)`, code)
+		if err != nil {
+			return Link{}, err
+		}
+		if err := requireRow(result, notFound(ErrLinkDoesNotExist, code)); err != nil {
+			return Link{}, err
+		}
+	}
+	link, err := scanLink(db.queryRow(`SELECT `+linkColumns+` FROM links WHERE code = ?
+		AND chirp_id IN (SELECT id FROM chirps WHERE deleted_at IS NULL)`, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Link{}, notFound(ErrLinkDoesNotExist, code)
+	}
+	return link, err
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Kinds of login throttles: failures are counted per account, by email
+// address, and per client address.
+const (
+	ThrottleAccount = "account"
+	ThrottleIP      = "ip"
+)
+
+// LockoutPolicy locks a subject out for Duration once it fails Limit logins
+// within Window.
+type LockoutPolicy struct {
+	Limit    int
+	Window   time.Duration
+	Duration time.Duration
+}
+
+// LoginThrottle counts the failed logins of a subject in its current
+// window, which starts at its first failure.
+type LoginThrottle struct {
+	Kind        string     `json:"kind"`
+	Subject     string     `json:"subject"`
+	Failures    int        `json:"failures"`
+	WindowStart time.Time  `json:"window_start"`
+	LockedUntil *time.Time `json:"locked_until"`
+}
+
+// Locked reports whether the subject is locked out at the given time.
+func (t LoginThrottle) Locked(at time.Time) bool {
+	return t.LockedUntil != nil && at.Before(*t.LockedUntil)
+}
+
+// stale reports whether the throttle has nothing left to enforce at the
+// given time, so the next failure starts counting afresh.
+func (t LoginThrottle) stale(at time.Time, window time.Duration) bool {
+	if t.LockedUntil != nil {
+		return !t.Locked(at)
+	}
+	return !at.Before(t.WindowStart.Add(window))
+}
+
+// fail counts a failure at the given time under policy.
+func (t LoginThrottle) fail(at time.Time, policy LockoutPolicy) LoginThrottle {
+	if t.stale(at, policy.Window) {
+		t.Failures, t.WindowStart, t.LockedUntil = 0, at, nil
+	}
+	t.Failures++
+	if t.Failures >= policy.Limit && t.LockedUntil == nil {
+		lockedUntil := at.Add(policy.Duration)
+		t.LockedUntil = &lockedUntil
+	}
+	return t
+}
+
+func throttleKey(kind, subject string) string {
+	return kind + ":" + subject
+}
+
+// GetLoginThrottle returns the failures counted for subject, or a zero
+// count when there are none.
+func (db *DB) GetLoginThrottle(kind, subject string) (LoginThrottle, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	throttle, found := dbStruct.LoginThrottles[throttleKey(kind, subject)]
+	if !found {
+		return LoginThrottle{Kind: kind, Subject: subject}, nil
+	}
+	return throttle, nil
+}
+
+// RecordLoginFailure counts a failed login of subject at the given time and
+// returns its throttle, locked when the failure reached policy's limit. It
+// also drops the throttles of kind that policy no longer enforces.
+func (db *DB) RecordLoginFailure(kind, subject string, at time.Time, policy LockoutPolicy) (LoginThrottle, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	at = at.UTC()
+	for key, throttle := range dbStruct.LoginThrottles {
+		if throttle.Kind == kind && throttle.stale(at, policy.Window) {
+			delete(dbStruct.LoginThrottles, key)
+		}
+	}
+	key := throttleKey(kind, subject)
+	throttle, found := dbStruct.LoginThrottles[key]
+	if !found {
+		throttle = LoginThrottle{Kind: kind, Subject: subject, WindowStart: at}
+	}
+	throttle = throttle.fail(at, policy)
+	dbStruct.LoginThrottles[key] = throttle
+	if err := db.writeDB(dbStruct); err != nil {
+		return LoginThrottle{}, err
+	}
+	return throttle, nil
+}
+
+// ResetLoginFailures forgets the failures of subject, after it logged in.
+func (db *DB) ResetLoginFailures(kind, subject string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	key := throttleKey(kind, subject)
+	if _, found := dbStruct.LoginThrottles[key]; !found {
+		return nil
+	}
+	delete(dbStruct.LoginThrottles, key)
+	return db.writeDB(dbStruct)
+}
+
+// ActiveLockouts counts the subjects of each kind locked out at the given
+// time.
+func (db *DB) ActiveLockouts(at time.Time) (map[string]int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	lockouts := map[string]int{ThrottleAccount: 0, ThrottleIP: 0}
+	for _, throttle := range dbStruct.LoginThrottles {
+		if throttle.Locked(at) {
+			lockouts[throttle.Kind]++
+		}
+	}
+	return lockouts, nil
+}
+
+func (db *SQLDB) GetLoginThrottle(kind, subject string) (LoginThrottle, error) {
+	throttle := LoginThrottle{Kind: kind, Subject: subject}
+	err := db.queryRow(`SELECT failures, window_start, locked_until FROM login_throttles WHERE kind = ? AND subject = ?`,
+		kind, subject).Scan(&throttle.Failures, &throttle.WindowStart, &throttle.LockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return throttle, nil
+	}
+	return throttle, err
+}
+
+func (db *SQLDB) RecordLoginFailure(kind, subject string, at time.Time, policy LockoutPolicy) (LoginThrottle, error) {
+	at = at.UTC()
+	_, err := db.exec(`DELETE FROM login_throttles
+		WHERE kind = ? AND ((locked_until IS NULL AND window_start <= ?) OR locked_until <= ?)`, kind, at.Add(-policy.Window), at)
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	throttle, err := db.GetLoginThrottle(kind, subject)
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	if throttle.Failures == 0 {
+		throttle.WindowStart = at
+	}
+	throttle = throttle.fail(at, policy)
+	_, err = db.exec(`INSERT INTO login_throttles (kind, subject, failures, window_start, locked_until) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (kind, subject) DO UPDATE SET failures = excluded.failures, window_start = excluded.window_start,
+			locked_until = excluded.locked_until`,
+		kind, subject, throttle.Failures, throttle.WindowStart, throttle.LockedUntil)
+	if err != nil {
+		return LoginThrottle{}, err
+	}
+	return throttle, nil
+}
+
+func (db *SQLDB) ResetLoginFailures(kind, subject string) error {
+	_, err := db.exec(`DELETE FROM login_throttles WHERE kind = ? AND subject = ?`, kind, subject)
+	return err
+}
+
+func (db *SQLDB) ActiveLockouts(at time.Time) (map[string]int, error) {
+	rows, err := db.query(`SELECT kind, COUNT(*) FROM login_throttles WHERE locked_until > ? GROUP BY kind`, at.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lockouts := map[string]int{ThrottleAccount: 0, ThrottleIP: 0}
+	for rows.Next() {
+		var kind string
+		var count int
+		if err := rows.Scan(&kind, &count); err != nil {
+			return nil, err
+		}
+		lockouts[kind] = count
+	}
+	return lockouts, rows.Err()
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// FeedMarker is how far down their feed a user has read, shared by all of
+// their devices. UpdatedAt is nil until the marker is first set.
+type FeedMarker struct {
+	UserId     int        `json:"user_id"`
+	LastReadId int        `json:"last_read_id"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+}
+
+func (db *DB) GetFeedMarker(userId int) (FeedMarker, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return FeedMarker{}, err
+	}
+	marker, found := dbStruct.FeedMarkers[userId]
+	if !found {
+		return FeedMarker{UserId: userId}, nil
+	}
+	return marker, nil
+}
+
+// SetFeedMarker moves the user's marker forward to lastReadId. Markers never
+// move back, so a device that fell behind cannot undo another's progress.
+func (db *DB) SetFeedMarker(userId, lastReadId int) (FeedMarker, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return FeedMarker{}, err
+	}
+	marker, found := dbStruct.FeedMarkers[userId]
+	if found && marker.LastReadId >= lastReadId {
+		return marker, nil
+	}
+	updatedAt := time.Now().UTC()
+	marker = FeedMarker{UserId: userId, LastReadId: lastReadId, UpdatedAt: &updatedAt}
+	dbStruct.FeedMarkers[userId] = marker
+	if err := db.writeDB(dbStruct); err != nil {
+		return FeedMarker{}, err
+	}
+	return marker, nil
+}
+
+func (db *SQLDB) GetFeedMarker(userId int) (FeedMarker, error) {
+	marker := FeedMarker{UserId: userId}
+	err := db.queryRow(`SELECT last_read_id, updated_at FROM feed_markers WHERE user_id = ?`, userId).
+		Scan(&marker.LastReadId, &marker.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return marker, nil
+	}
+	return marker, err
+}
+
+func (db *SQLDB) SetFeedMarker(userId, lastReadId int) (FeedMarker, error) {
+	_, err := db.exec(`INSERT INTO feed_markers (user_id, last_read_id, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET last_read_id = excluded.last_read_id, updated_at = excluded.updated_at
+		WHERE excluded.last_read_id > feed_markers.last_read_id`,
+		userId, lastReadId, time.Now().UTC())
+	if err != nil {
+		return FeedMarker{}, err
+	}
+	return db.GetFeedMarker(userId)
+}
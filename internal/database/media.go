@@ -0,0 +1,296 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Media is an uploaded file. Its bytes live in a blob store under Key.
+// Identical files share one blob: Hash is the SHA-256 of the content, and
+// media created with one already stored get that blob's Key.
+type Media struct {
+	Id          string    `json:"id"`
+	OwnerId     int       `json:"owner_id"`
+	Key         string    `json:"-"`
+	Hash        string    `json:"-"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	AltText     string    `json:"alt_text"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// MediaBlob is a stored blob and how many media share it.
+type MediaBlob struct {
+	Key  string
+	Refs int
+}
+
+// Attachment is media as it appears on a chirp.
+type Attachment struct {
+	Id          string `json:"id"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	AltText     string `json:"alt_text"`
+}
+
+// CreateMedia saves media whose content is stored under media.Key. When
+// media with the same hash already exists, the returned media points at
+// that blob instead and the caller's copy is no longer needed.
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return Media{}, err
+	}
+	media.Id = id
+	media.CreatedAt = time.Now().UTC()
+	if media.Hash != "" {
+		blob, found := dbStruct.MediaBlobs[media.Hash]
+		if found {
+			media.Key = blob.Key
+		} else {
+			blob.Key = media.Key
+		}
+		blob.Refs++
+		dbStruct.MediaBlobs[media.Hash] = blob
+	}
+	dbStruct.Media[media.Id] = media
+	if err := db.writeDB(dbStruct); err != nil {
+		return Media{}, err
+	}
+	return media, nil
+}
+
+func (db *DB) GetMedia(id string) (Media, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Media{}, err
+	}
+	media, found := dbStruct.Media[id]
+	if !found {
+		return Media{}, notFound(ErrMediaDoesNotExist, id)
+	}
+	return media, nil
+}
+
+// DeleteMedia deletes media and returns the key of its blob once no other
+// media shares it, for the caller to delete from the blob store. The key is
+// empty while the blob is still in use.
+func (db *DB) DeleteMedia(id string) (string, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return "", err
+	}
+	media, found := dbStruct.Media[id]
+	if !found {
+		return "", notFound(ErrMediaDoesNotExist, id)
+	}
+	delete(dbStruct.Media, id)
+	orphaned := media.Key
+	if blob, found := dbStruct.MediaBlobs[media.Hash]; found {
+		blob.Refs--
+		if blob.Refs > 0 {
+			dbStruct.MediaBlobs[media.Hash] = blob
+			orphaned = ""
+		} else {
+			delete(dbStruct.MediaBlobs, media.Hash)
+		}
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return "", err
+	}
+	return orphaned, nil
+}
+
+// GetOrphanedMedia returns the media created before the given time that
+// nothing refers to: no chirp, including deleted ones that may yet be
+// restored, no scheduled chirp or draft, and no avatar or banner.
+func (db *DB) GetOrphanedMedia(createdBefore time.Time) ([]Media, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, chirp := range dbStruct.Chirps {
+		addAttachments(referenced, chirp.Media)
+	}
+	for _, scheduled := range dbStruct.ScheduledChirps {
+		addAttachments(referenced, scheduled.Media)
+	}
+	for _, draft := range dbStruct.Drafts {
+		addAttachments(referenced, draft.Media)
+	}
+	for _, user := range dbStruct.Users {
+		for _, url := range []string{user.AvatarURL, user.BannerURL} {
+			if id := mediaIdInURL(url); id != "" {
+				referenced[id] = true
+			}
+		}
+	}
+	orphaned := []Media{}
+	for _, media := range dbStruct.Media {
+		if media.CreatedAt.Before(createdBefore) && !referenced[media.Id] {
+			orphaned = append(orphaned, media)
+		}
+	}
+	return orphaned, nil
+}
+
+func addAttachments(referenced map[string]bool, attachments []Attachment) {
+	for _, attachment := range attachments {
+		referenced[attachment.Id] = true
+	}
+}
+
+// mediaIdInURL returns the id of the media a URL served by /media/{id}
+// points at, or "" for any other URL.
+func mediaIdInURL(url string) string {
+	i := strings.LastIndex(url, "/media/")
+	if i < 0 {
+		return ""
+	}
+	id, _, _ := strings.Cut(url[i+len("/media/"):], "?")
+	if strings.Contains(id, "/") {
+		return ""
+	}
+	return id
+}
+
+const mediaColumns = `id, owner_id, blob_key, hash, content_type, size, alt_text, created_at`
+
+func (db *SQLDB) CreateMedia(media Media) (Media, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return Media{}, err
+	}
+	media.Id = id
+	media.CreatedAt = time.Now().UTC()
+	if media.Hash != "" {
+		err := db.queryRow(`INSERT INTO media_blobs (hash, blob_key, refs) VALUES (?, ?, 1)
+			ON CONFLICT (hash) DO UPDATE SET refs = media_blobs.refs + 1 RETURNING blob_key`,
+			media.Hash, media.Key).Scan(&media.Key)
+		if err != nil {
+			return Media{}, err
+		}
+	}
+	_, err = db.exec(`INSERT INTO media (`+mediaColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		media.Id, media.OwnerId, media.Key, media.Hash, media.ContentType, media.Size, media.AltText, media.CreatedAt)
+	if err != nil {
+		if media.Hash != "" {
+			db.releaseMediaBlob(media.Hash)
+		}
+		return Media{}, err
+	}
+	return media, nil
+}
+
+func (db *SQLDB) GetMedia(id string) (Media, error) {
+	media := Media{}
+	err := db.queryRow(`SELECT `+mediaColumns+` FROM media WHERE id = ?`, id).
+		Scan(&media.Id, &media.OwnerId, &media.Key, &media.Hash, &media.ContentType, &media.Size, &media.AltText, &media.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Media{}, notFound(ErrMediaDoesNotExist, id)
+	}
+	return media, err
+}
+
+func (db *SQLDB) DeleteMedia(id string) (string, error) {
+	var key, hash string
+	err := db.queryRow(`DELETE FROM media WHERE id = ? RETURNING blob_key, hash`, id).Scan(&key, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", notFound(ErrMediaDoesNotExist, id)
+	}
+	if err != nil || hash == "" {
+		return key, err
+	}
+	return db.releaseMediaBlob(hash)
+}
+
+// releaseMediaBlob drops a reference to the blob with hash, returning its
+// key if that was the last one. A blob taken up again in between by an
+// identical upload is kept.
+func (db *SQLDB) releaseMediaBlob(hash string) (string, error) {
+	if _, err := db.exec(`UPDATE media_blobs SET refs = refs - 1 WHERE hash = ?`, hash); err != nil {
+		return "", err
+	}
+	var key string
+	err := db.queryRow(`DELETE FROM media_blobs WHERE hash = ? AND refs <= 0 RETURNING blob_key`, hash).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return key, err
+}
+
+func (db *SQLDB) GetOrphanedMedia(createdBefore time.Time) ([]Media, error) {
+	referenced := make(map[string]bool)
+	for _, table := range []string{"chirps", "scheduled_chirps", "drafts"} {
+		if err := db.addReferencedMedia(referenced, table); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := db.query(`SELECT avatar_url, banner_url FROM users WHERE avatar_url LIKE '%/media/%' OR banner_url LIKE '%/media/%'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var avatarURL, bannerURL string
+		if err := rows.Scan(&avatarURL, &bannerURL); err != nil {
+			return nil, err
+		}
+		for _, url := range []string{avatarURL, bannerURL} {
+			if id := mediaIdInURL(url); id != "" {
+				referenced[id] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.query(`SELECT `+mediaColumns+` FROM media WHERE created_at < ?`, createdBefore.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orphaned := []Media{}
+	for rows.Next() {
+		media := Media{}
+		err := rows.Scan(&media.Id, &media.OwnerId, &media.Key, &media.Hash, &media.ContentType, &media.Size, &media.AltText, &media.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if !referenced[media.Id] {
+			orphaned = append(orphaned, media)
+		}
+	}
+	return orphaned, rows.Err()
+}
+
+// addReferencedMedia adds the media attached to the rows of table, which
+// keeps attachments as JSON in its media column.
+func (db *SQLDB) addReferencedMedia(referenced map[string]bool, table string) error {
+	rows, err := db.query(`SELECT media FROM ` + table + ` WHERE media LIKE '[{%'`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var attachments []Attachment
+		if err := json.Unmarshal([]byte(data), &attachments); err != nil {
+			return err
+		}
+		addAttachments(referenced, attachments)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,355 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Moderation action kinds.
+const (
+	ActionRemoveChirp = "remove_chirp"
+	ActionSuspend     = "suspend"
+)
+
+// Appeal resolutions. An open appeal has an empty Resolution.
+const (
+	AppealGranted = "granted"
+	AppealDenied  = "denied"
+)
+
+// ModerationAction records an admin acting against a user, and why.
+type ModerationAction struct {
+	Id        int        `json:"id"`
+	UserId    int        `json:"user_id"`
+	Kind      string     `json:"kind"`
+	ChirpId   *int       `json:"chirp_id"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"created_at"`
+	Until     *time.Time `json:"until"`      // end of a suspension; nil for a permanent one
+	RevokedAt *time.Time `json:"revoked_at"` // set when an appeal is granted
+	Appeal    *Appeal    `json:"appeal"`
+}
+
+// Active reports whether a suspension still applies at the given time.
+func (action ModerationAction) Active(at time.Time) bool {
+	if action.Kind != ActionSuspend || action.RevokedAt != nil {
+		return false
+	}
+	return action.Until == nil || at.Before(*action.Until)
+}
+
+// Appeal is a user asking admins to reconsider a moderation action.
+type Appeal struct {
+	Id         int        `json:"id"`
+	ActionId   int        `json:"action_id"`
+	UserId     int        `json:"user_id"`
+	Message    string     `json:"message"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Resolution string     `json:"resolution"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+func (db *DB) CreateModerationAction(action ModerationAction) (ModerationAction, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ModerationAction{}, err
+	}
+	if _, found := dbStruct.Users[action.UserId]; !found {
+		return ModerationAction{}, notFound(ErrUserDoesNotExist, action.UserId)
+	}
+	action.Id = dbStruct.NextActionId
+	action.CreatedAt = time.Now().UTC()
+	action.RevokedAt = nil
+	action.Appeal = nil
+	dbStruct.ModerationActions[action.Id] = action
+	dbStruct.NextActionId++
+	if err := db.writeDB(dbStruct); err != nil {
+		return ModerationAction{}, err
+	}
+	return action, nil
+}
+
+func (db *DB) GetModerationAction(id int) (ModerationAction, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ModerationAction{}, err
+	}
+	action, found := dbStruct.ModerationActions[id]
+	if !found {
+		return ModerationAction{}, notFound(ErrActionDoesNotExist, id)
+	}
+	return dbStruct.withAppeal(action), nil
+}
+
+// GetModerationActions returns the actions taken against a user, oldest first.
+func (db *DB) GetModerationActions(userId int) ([]ModerationAction, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	actions := make([]ModerationAction, 0)
+	for _, action := range dbStruct.ModerationActions {
+		if action.UserId == userId {
+			actions = append(actions, dbStruct.withAppeal(action))
+		}
+	}
+	slices.SortFunc(actions, func(a, b ModerationAction) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return actions, nil
+}
+
+func (dbStruct DBStructure) withAppeal(action ModerationAction) ModerationAction {
+	for _, appeal := range dbStruct.Appeals {
+		if appeal.ActionId == action.Id {
+			action.Appeal = &appeal
+			break
+		}
+	}
+	return action
+}
+
+func (db *DB) IsSuspended(userId int, at time.Time) (bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return false, err
+	}
+	for _, action := range dbStruct.ModerationActions {
+		if action.UserId == userId && action.Active(at) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (db *DB) CreateAppeal(appeal Appeal) (Appeal, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Appeal{}, err
+	}
+	action, found := dbStruct.ModerationActions[appeal.ActionId]
+	if !found || action.UserId != appeal.UserId {
+		return Appeal{}, notFound(ErrActionDoesNotExist, appeal.ActionId)
+	}
+	if dbStruct.withAppeal(action).Appeal != nil {
+		return Appeal{}, ErrAlreadyAppealed
+	}
+	appeal.Id = dbStruct.NextAppealId
+	appeal.CreatedAt = time.Now().UTC()
+	appeal.Resolution = ""
+	appeal.ResolvedAt = nil
+	dbStruct.Appeals[appeal.Id] = appeal
+	dbStruct.NextAppealId++
+	if err := db.writeDB(dbStruct); err != nil {
+		return Appeal{}, err
+	}
+	return appeal, nil
+}
+
+// GetOpenAppeals returns unresolved appeals, oldest first.
+func (db *DB) GetOpenAppeals() ([]Appeal, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	appeals := make([]Appeal, 0)
+	for _, appeal := range dbStruct.Appeals {
+		if appeal.Resolution == "" {
+			appeals = append(appeals, appeal)
+		}
+	}
+	slices.SortFunc(appeals, func(a, b Appeal) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return appeals, nil
+}
+
+// ResolveAppeal records the admins' decision. Granting an appeal revokes the
+// action it was made against.
+func (db *DB) ResolveAppeal(id int, resolution string) (Appeal, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Appeal{}, err
+	}
+	appeal, found := dbStruct.Appeals[id]
+	if !found {
+		return Appeal{}, notFound(ErrAppealDoesNotExist, id)
+	}
+	resolvedAt := time.Now().UTC()
+	appeal.Resolution = resolution
+	appeal.ResolvedAt = &resolvedAt
+	dbStruct.Appeals[id] = appeal
+	if action, found := dbStruct.ModerationActions[appeal.ActionId]; found && resolution == AppealGranted {
+		action.RevokedAt = &resolvedAt
+		dbStruct.ModerationActions[action.Id] = action
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return Appeal{}, err
+	}
+	return appeal, nil
+}
+
+// actionColumns selects an action together with its appeal, if any, from
+// moderation_actions LEFT JOIN appeals.
+const actionColumns = `moderation_actions.id, moderation_actions.user_id, moderation_actions.kind,
+	moderation_actions.chirp_id, moderation_actions.reason, moderation_actions.created_at,
+	moderation_actions.until, moderation_actions.revoked_at,
+	appeals.id, appeals.message, appeals.created_at, appeals.resolution, appeals.resolved_at`
+
+func scanAction(row scanner) (ModerationAction, error) {
+	action := ModerationAction{}
+	var (
+		appealId         sql.NullInt64
+		appealMessage    sql.NullString
+		appealCreatedAt  sql.NullTime
+		appealResolution sql.NullString
+		appealResolvedAt *time.Time
+	)
+	err := row.Scan(&action.Id, &action.UserId, &action.Kind, &action.ChirpId, &action.Reason,
+		&action.CreatedAt, &action.Until, &action.RevokedAt,
+		&appealId, &appealMessage, &appealCreatedAt, &appealResolution, &appealResolvedAt)
+	if err != nil {
+		return ModerationAction{}, err
+	}
+	if appealId.Valid {
+		action.Appeal = &Appeal{
+			Id:         int(appealId.Int64),
+			ActionId:   action.Id,
+			UserId:     action.UserId,
+			Message:    appealMessage.String,
+			CreatedAt:  appealCreatedAt.Time,
+			Resolution: appealResolution.String,
+			ResolvedAt: appealResolvedAt,
+		}
+	}
+	return action, nil
+}
+
+const appealColumns = `id, action_id, user_id, message, created_at, resolution, resolved_at`
+
+func scanAppeal(row scanner) (Appeal, error) {
+	appeal := Appeal{}
+	err := row.Scan(&appeal.Id, &appeal.ActionId, &appeal.UserId, &appeal.Message,
+		&appeal.CreatedAt, &appeal.Resolution, &appeal.ResolvedAt)
+	return appeal, err
+}
+
+func (db *SQLDB) CreateModerationAction(action ModerationAction) (ModerationAction, error) {
+	if _, err := db.GetUserById(action.UserId); err != nil {
+		return ModerationAction{}, err
+	}
+	action.CreatedAt = time.Now().UTC()
+	action.RevokedAt = nil
+	action.Appeal = nil
+	err := db.queryRow(`INSERT INTO moderation_actions (user_id, kind, chirp_id, reason, created_at, until)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		action.UserId, action.Kind, action.ChirpId, action.Reason, action.CreatedAt, action.Until).Scan(&action.Id)
+	if err != nil {
+		return ModerationAction{}, err
+	}
+	return action, nil
+}
+
+func (db *SQLDB) GetModerationAction(id int) (ModerationAction, error) {
+	action, err := scanAction(db.queryRow(`SELECT `+actionColumns+` FROM moderation_actions
+		LEFT JOIN appeals ON appeals.action_id = moderation_actions.id
+		WHERE moderation_actions.id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ModerationAction{}, notFound(ErrActionDoesNotExist, id)
+	}
+	return action, err
+}
+
+func (db *SQLDB) GetModerationActions(userId int) ([]ModerationAction, error) {
+	rows, err := db.query(`SELECT `+actionColumns+` FROM moderation_actions
+		LEFT JOIN appeals ON appeals.action_id = moderation_actions.id
+		WHERE moderation_actions.user_id = ? ORDER BY moderation_actions.id`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	actions := make([]ModerationAction, 0)
+	for rows.Next() {
+		action, err := scanAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+func (db *SQLDB) IsSuspended(userId int, at time.Time) (bool, error) {
+	var count int
+	err := db.queryRow(`SELECT COUNT(*) FROM moderation_actions
+		WHERE user_id = ? AND kind = ? AND revoked_at IS NULL AND (until IS NULL OR until > ?)`,
+		userId, ActionSuspend, at.UTC()).Scan(&count)
+	return count > 0, err
+}
+
+func (db *SQLDB) CreateAppeal(appeal Appeal) (Appeal, error) {
+	action, err := db.GetModerationAction(appeal.ActionId)
+	if err != nil {
+		return Appeal{}, err
+	}
+	if action.UserId != appeal.UserId {
+		return Appeal{}, notFound(ErrActionDoesNotExist, appeal.ActionId)
+	}
+	if action.Appeal != nil {
+		return Appeal{}, ErrAlreadyAppealed
+	}
+	appeal.CreatedAt = time.Now().UTC()
+	appeal.Resolution = ""
+	appeal.ResolvedAt = nil
+	err = db.queryRow(`INSERT INTO appeals (action_id, user_id, message, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		appeal.ActionId, appeal.UserId, appeal.Message, appeal.CreatedAt).Scan(&appeal.Id)
+	if err != nil {
+		return Appeal{}, err
+	}
+	return appeal, nil
+}
+
+func (db *SQLDB) GetOpenAppeals() ([]Appeal, error) {
+	rows, err := db.query(`SELECT ` + appealColumns + ` FROM appeals WHERE resolution = '' ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	appeals := make([]Appeal, 0)
+	for rows.Next() {
+		appeal, err := scanAppeal(rows)
+		if err != nil {
+			return nil, err
+		}
+		appeals = append(appeals, appeal)
+	}
+	return appeals, rows.Err()
+}
+
+func (db *SQLDB) ResolveAppeal(id int, resolution string) (Appeal, error) {
+	resolvedAt := time.Now().UTC()
+	result, err := db.exec(`UPDATE appeals SET resolution = ?, resolved_at = ? WHERE id = ?`, resolution, resolvedAt, id)
+	if err != nil {
+		return Appeal{}, err
+	}
+	if err := requireRow(result, notFound(ErrAppealDoesNotExist, id)); err != nil {
+		return Appeal{}, err
+	}
+	appeal, err := scanAppeal(db.queryRow(`SELECT `+appealColumns+` FROM appeals WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Appeal{}, notFound(ErrAppealDoesNotExist, id)
+	}
+	if err != nil {
+		return Appeal{}, err
+	}
+	if resolution == AppealGranted {
+		_, err = db.exec(`UPDATE moderation_actions SET revoked_at = ? WHERE id = ?`, resolvedAt, appeal.ActionId)
+		if err != nil {
+			return Appeal{}, err
+		}
+	}
+	return appeal, nil
+}
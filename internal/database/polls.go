@@ -0,0 +1,256 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Poll is a question attached to a chirp, which each user may answer once
+// until it closes. Each option carries its tally.
+type Poll struct {
+	ChirpId    int          `json:"-"`
+	Options    []PollOption `json:"options"`
+	VotesCount int          `json:"votes_count"`
+	ClosesAt   time.Time    `json:"closes_at"`
+	// ClosedAt is set once the poll closing worker has closed it. Votes
+	// are refused from ClosesAt on either way.
+	ClosedAt *time.Time `json:"closed_at"`
+}
+
+type PollOption struct {
+	Title string `json:"title"`
+	Votes int    `json:"votes"`
+}
+
+// open reports whether the poll takes votes at the given time.
+func (poll Poll) open(at time.Time) bool {
+	return poll.ClosedAt == nil && at.Before(poll.ClosesAt)
+}
+
+func newPoll(chirpId int, options []string, closesAt time.Time) Poll {
+	poll := Poll{ChirpId: chirpId, Options: make([]PollOption, len(options)), ClosesAt: closesAt.UTC()}
+	for i, title := range options {
+		poll.Options[i].Title = title
+	}
+	return poll
+}
+
+// CreatePoll attaches a poll with the given options to a chirp.
+func (db *DB) CreatePoll(chirpId int, options []string, closesAt time.Time) (Poll, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Poll{}, err
+	}
+	if _, found := dbStruct.liveChirp(chirpId); !found {
+		return Poll{}, notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	poll := newPoll(chirpId, options, closesAt)
+	dbStruct.Polls[chirpId] = poll
+	if err := db.writeDB(dbStruct); err != nil {
+		return Poll{}, err
+	}
+	return poll, nil
+}
+
+// GetPolls returns the polls of the chirps that have one, by chirp id.
+func (db *DB) GetPolls(chirpIds []int) (map[int]Poll, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	polls := make(map[int]Poll)
+	for _, id := range chirpIds {
+		if poll, found := dbStruct.Polls[id]; found {
+			polls[id] = poll
+		}
+	}
+	return polls, nil
+}
+
+// VotePoll records userId's vote for the option at index option of the
+// poll on chirpId and returns the poll with the new tallies.
+func (db *DB) VotePoll(chirpId, userId, option int) (Poll, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Poll{}, err
+	}
+	poll, found := dbStruct.Polls[chirpId]
+	if _, live := dbStruct.liveChirp(chirpId); !found || !live {
+		return Poll{}, notFound(ErrPollDoesNotExist, chirpId)
+	}
+	if err := checkVote(poll, option); err != nil {
+		return Poll{}, err
+	}
+	if _, voted := dbStruct.PollVotes[chirpId][userId]; voted {
+		return Poll{}, ErrAlreadyVoted
+	}
+	if dbStruct.PollVotes[chirpId] == nil {
+		dbStruct.PollVotes[chirpId] = make(map[int]int)
+	}
+	dbStruct.PollVotes[chirpId][userId] = option
+	poll.Options = append([]PollOption(nil), poll.Options...)
+	poll.Options[option].Votes++
+	poll.VotesCount++
+	dbStruct.Polls[chirpId] = poll
+	if err := db.writeDB(dbStruct); err != nil {
+		return Poll{}, err
+	}
+	return poll, nil
+}
+
+func checkVote(poll Poll, option int) error {
+	if !poll.open(time.Now()) {
+		return ErrPollClosed
+	}
+	if option < 0 || option >= len(poll.Options) {
+		return ErrInvalidPollOption
+	}
+	return nil
+}
+
+// ClosePolls closes the polls due to close at the given time and returns
+// the ids of their chirps.
+func (db *DB) ClosePolls(at time.Time) ([]int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	var closed []int
+	closedAt := at.UTC()
+	for chirpId, poll := range dbStruct.Polls {
+		if poll.ClosedAt == nil && !poll.ClosesAt.After(at) {
+			poll.ClosedAt = &closedAt
+			dbStruct.Polls[chirpId] = poll
+			closed = append(closed, chirpId)
+		}
+	}
+	if len(closed) == 0 {
+		return nil, nil
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+func (db *SQLDB) CreatePoll(chirpId int, options []string, closesAt time.Time) (Poll, error) {
+	_, found, err := db.GetChirp(chirpId)
+	if err != nil {
+		return Poll{}, err
+	}
+	if !found {
+		return Poll{}, notFound(ErrChirpDoesNotExist, chirpId)
+	}
+	titles, err := json.Marshal(options)
+	if err != nil {
+		return Poll{}, err
+	}
+	poll := newPoll(chirpId, options, closesAt)
+	_, err = db.exec(`INSERT INTO polls (chirp_id, options, closes_at) VALUES (?, ?, ?)`, chirpId, string(titles), poll.ClosesAt)
+	if err != nil {
+		return Poll{}, err
+	}
+	return poll, nil
+}
+
+func (db *SQLDB) GetPolls(chirpIds []int) (map[int]Poll, error) {
+	polls := make(map[int]Poll)
+	if len(chirpIds) == 0 {
+		return polls, nil
+	}
+	args := make([]any, len(chirpIds))
+	for i, id := range chirpIds {
+		args[i] = id
+	}
+	rows, err := db.query(`SELECT chirp_id, options, closes_at, closed_at FROM polls WHERE chirp_id IN (`+placeholders(len(chirpIds))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var chirpId int
+		var titles string
+		var closesAt time.Time
+		var closedAt *time.Time
+		if err := rows.Scan(&chirpId, &titles, &closesAt, &closedAt); err != nil {
+			return nil, err
+		}
+		var options []string
+		if err := json.Unmarshal([]byte(titles), &options); err != nil {
+			return nil, err
+		}
+		poll := newPoll(chirpId, options, closesAt)
+		poll.ClosedAt = closedAt
+		polls[chirpId] = poll
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(polls) == 0 {
+		return polls, nil
+	}
+
+	tallies, err := db.query(`SELECT chirp_id, choice, COUNT(*) FROM poll_votes WHERE chirp_id IN (`+placeholders(len(chirpIds))+`)
+		GROUP BY chirp_id, choice`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer tallies.Close()
+	for tallies.Next() {
+		var chirpId, choice, votes int
+		if err := tallies.Scan(&chirpId, &choice, &votes); err != nil {
+			return nil, err
+		}
+		poll := polls[chirpId]
+		if choice < len(poll.Options) {
+			poll.Options[choice].Votes = votes
+			poll.VotesCount += votes
+		}
+		polls[chirpId] = poll
+	}
+	return polls, tallies.Err()
+}
+
+func (db *SQLDB) VotePoll(chirpId, userId, option int) (Poll, error) {
+	polls, err := db.GetPolls([]int{chirpId})
+	if err != nil {
+		return Poll{}, err
+	}
+	poll, found := polls[chirpId]
+	if !found {
+		return Poll{}, notFound(ErrPollDoesNotExist, chirpId)
+	}
+	_, live, err := db.GetChirp(chirpId)
+	if err != nil {
+		return Poll{}, err
+	}
+	if !live {
+		return Poll{}, notFound(ErrPollDoesNotExist, chirpId)
+	}
+	if err := checkVote(poll, option); err != nil {
+		return Poll{}, err
+	}
+	result, err := db.exec(`INSERT INTO poll_votes (chirp_id, user_id, choice, created_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		chirpId, userId, option, time.Now().UTC())
+	if err != nil {
+		return Poll{}, err
+	}
+	if err := requireRow(result, ErrAlreadyVoted); err != nil {
+		return Poll{}, err
+	}
+	polls, err = db.GetPolls([]int{chirpId})
+	if err != nil {
+		return Poll{}, err
+	}
+	return polls[chirpId], nil
+}
+
+func (db *SQLDB) ClosePolls(at time.Time) ([]int, error) {
+	return queryRows(db, `UPDATE polls SET closed_at = ? WHERE closed_at IS NULL AND closes_at <= ? RETURNING chirp_id`,
+		func(row scanner) (int, error) {
+			var chirpId int
+			err := row.Scan(&chirpId)
+			return chirpId, err
+		}, at.UTC(), at.UTC())
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// BlockedWord is a pattern the profanity filter censors in chirps and
+// refuses in handles. A pattern is one or more lowercase words separated by
+// single spaces, matching that phrase; a * in a word matches any run of
+// characters.
+type BlockedWord struct {
+	Pattern   string    `json:"pattern"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DefaultBlockedWords are the patterns a new database starts with.
+var DefaultBlockedWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// AddBlockedWord adds pattern to the profanity filter, doing nothing if it
+// is already there.
+func (db *DB) AddBlockedWord(pattern string) (BlockedWord, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return BlockedWord{}, err
+	}
+	if word, found := dbStruct.BlockedWords[pattern]; found {
+		return word, nil
+	}
+	word := BlockedWord{Pattern: pattern, CreatedAt: time.Now().UTC()}
+	dbStruct.BlockedWords[pattern] = word
+	if err := db.writeDB(dbStruct); err != nil {
+		return BlockedWord{}, err
+	}
+	return word, nil
+}
+
+func (db *DB) DeleteBlockedWord(pattern string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.BlockedWords[pattern]; !found {
+		return notFound(ErrBlockedWordDoesNotExist, pattern)
+	}
+	delete(dbStruct.BlockedWords, pattern)
+	return db.writeDB(dbStruct)
+}
+
+// GetBlockedWords returns the profanity filter's patterns ordered by
+// pattern.
+func (db *DB) GetBlockedWords() ([]BlockedWord, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	words := make([]BlockedWord, 0, len(dbStruct.BlockedWords))
+	for _, word := range dbStruct.BlockedWords {
+		words = append(words, word)
+	}
+	slices.SortFunc(words, func(a, b BlockedWord) int { return cmp.Compare(a.Pattern, b.Pattern) })
+	return words, nil
+}
+
+func (db *SQLDB) AddBlockedWord(pattern string) (BlockedWord, error) {
+	_, err := db.exec(`INSERT INTO blocked_words (pattern, created_at) VALUES (?, ?) ON CONFLICT (pattern) DO NOTHING`,
+		pattern, time.Now().UTC())
+	if err != nil {
+		return BlockedWord{}, err
+	}
+	word := BlockedWord{}
+	err = db.queryRow(`SELECT pattern, created_at FROM blocked_words WHERE pattern = ?`, pattern).
+		Scan(&word.Pattern, &word.CreatedAt)
+	return word, err
+}
+
+func (db *SQLDB) DeleteBlockedWord(pattern string) error {
+	result, err := db.exec(`DELETE FROM blocked_words WHERE pattern = ?`, pattern)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrBlockedWordDoesNotExist, pattern))
+}
+
+func (db *SQLDB) GetBlockedWords() ([]BlockedWord, error) {
+	rows, err := db.query(`SELECT pattern, created_at FROM blocked_words ORDER BY pattern`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	words := []BlockedWord{}
+	for rows.Next() {
+		word := BlockedWord{}
+		if err := rows.Scan(&word.Pattern, &word.CreatedAt); err != nil {
+			return nil, err
+		}
+		words = append(words, word)
+	}
+	return words, rows.Err()
+}
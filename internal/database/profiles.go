@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Profile is the part of a user that anyone may see.
+type Profile struct {
+	Id          int    `json:"id"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	BannerURL   string `json:"banner_url"`
+	Website     string `json:"website"`
+	// WebsiteVerified is set when the website links back to the profile.
+	WebsiteVerified bool `json:"website_verified"`
+	// MovedTo points followers at the account the user moved to.
+	MovedTo string `json:"moved_to"`
+}
+
+func (user User) Profile() Profile {
+	return Profile{
+		Id:              user.Id,
+		Handle:          user.Handle,
+		DisplayName:     user.DisplayName,
+		Bio:             user.Bio,
+		AvatarURL:       user.AvatarURL,
+		BannerURL:       user.BannerURL,
+		Website:         user.Website,
+		WebsiteVerified: user.WebsiteVerifiedAt != nil,
+		MovedTo:         user.MovedTo,
+	}
+}
+
+// ProfileUpdate holds the profile fields to change. Nil fields are left alone.
+type ProfileUpdate struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+	BannerURL   *string
+	Website     *string
+	MovedTo     *string
+	MovedFrom   *string
+}
+
+func (update ProfileUpdate) apply(user *User) {
+	if update.Handle != nil {
+		user.Handle = normalizeHandle(*update.Handle)
+	}
+	if update.DisplayName != nil {
+		user.DisplayName = *update.DisplayName
+	}
+	if update.Bio != nil {
+		user.Bio = *update.Bio
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+	if update.BannerURL != nil {
+		user.BannerURL = *update.BannerURL
+	}
+	if update.Website != nil && *update.Website != user.Website {
+		user.Website = *update.Website
+		user.WebsiteVerifiedAt = nil
+	}
+	if update.MovedTo != nil {
+		user.MovedTo = *update.MovedTo
+	}
+	if update.MovedFrom != nil {
+		user.MovedFrom = *update.MovedFrom
+	}
+}
+
+// Handles are unique regardless of case.
+func normalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+}
+
+func (db *DB) GetUserById(id int) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return User{}, notFound(ErrUserDoesNotExist, id)
+	}
+	return user, nil
+}
+
+func (db *DB) UpdateProfile(id int, update ProfileUpdate) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return User{}, notFound(ErrUserDoesNotExist, id)
+	}
+	update.apply(&user)
+	if user.Handle != "" {
+		for _, other := range dbStruct.Users {
+			if other.Id != id && other.Handle == user.Handle {
+				return User{}, ErrHandleTaken
+			}
+		}
+	}
+	dbStruct.Users[id] = user
+	if err := db.writeDB(dbStruct); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// VerifyWebsite marks the user's website verified, provided it is still
+// website: the check that found the backlink may have raced a change.
+func (db *DB) VerifyWebsite(id int, website string) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return User{}, notFound(ErrUserDoesNotExist, id)
+	}
+	if user.Website == "" || user.Website != website {
+		return User{}, ErrWebsiteChanged
+	}
+	now := time.Now().UTC()
+	user.WebsiteVerifiedAt = &now
+	dbStruct.Users[id] = user
+	if err := db.writeDB(dbStruct); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUserByMovedFrom finds the user who moved here from the account at
+// profileURL on another instance.
+func (db *DB) GetUserByMovedFrom(profileURL string) (User, bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, false, err
+	}
+	if profileURL == "" {
+		return User{}, false, nil
+	}
+	for _, user := range dbStruct.Users {
+		if user.MovedFrom == profileURL {
+			return user, true, nil
+		}
+	}
+	return User{}, false, nil
+}
+
+func (db *DB) GetProfiles(ids []int) (map[int]Profile, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[int]Profile, len(ids))
+	for _, id := range ids {
+		if user, found := dbStruct.Users[id]; found {
+			profiles[id] = user.Profile()
+		}
+	}
+	return profiles, nil
+}
+
+func (db *SQLDB) GetUserById(id int) (User, error) {
+	user, err := scanUser(db.queryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, notFound(ErrUserDoesNotExist, id)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLDB) UpdateProfile(id int, update ProfileUpdate) (User, error) {
+	user, err := db.GetUserById(id)
+	if err != nil {
+		return User{}, err
+	}
+	update.apply(&user)
+	if user.Handle != "" {
+		var taken int
+		err := db.queryRow(`SELECT COUNT(*) FROM users WHERE handle = ? AND id <> ?`, user.Handle, id).Scan(&taken)
+		if err != nil {
+			return User{}, err
+		}
+		if taken > 0 {
+			return User{}, ErrHandleTaken
+		}
+	}
+	_, err = db.exec(`UPDATE users SET handle = ?, display_name = ?, bio = ?, avatar_url = ?, banner_url = ?, website = ?, website_verified_at = ?,
+		moved_to = ?, moved_from = ? WHERE id = ?`,
+		user.Handle, user.DisplayName, user.Bio, user.AvatarURL, user.BannerURL, user.Website, user.WebsiteVerifiedAt,
+		user.MovedTo, user.MovedFrom, id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLDB) VerifyWebsite(id int, website string) (User, error) {
+	if _, err := db.GetUserById(id); err != nil {
+		return User{}, err
+	}
+	result, err := db.exec(`UPDATE users SET website_verified_at = ? WHERE id = ? AND website = ? AND website <> ''`,
+		time.Now().UTC(), id, website)
+	if err != nil {
+		return User{}, err
+	}
+	if err := requireRow(result, ErrWebsiteChanged); err != nil {
+		return User{}, err
+	}
+	return db.GetUserById(id)
+}
+
+func (db *SQLDB) GetUserByMovedFrom(profileURL string) (User, bool, error) {
+	if profileURL == "" {
+		return User{}, false, nil
+	}
+	user, err := scanUser(db.queryRow(`SELECT `+userColumns+` FROM users WHERE moved_from = ? ORDER BY id LIMIT 1`, profileURL))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+func (db *SQLDB) GetProfiles(ids []int) (map[int]Profile, error) {
+	profiles := make(map[int]Profile, len(ids))
+	if len(ids) == 0 {
+		return profiles, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := db.query(`SELECT `+userColumns+` FROM users WHERE id IN (`+placeholders(len(ids))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles[user.Id] = user.Profile()
+	}
+	return profiles, rows.Err()
+}
+
+// placeholders returns n comma separated ? placeholders for an IN clause.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Report resolutions. An open report has an empty Resolution.
+const (
+	ResolutionUpheld    = "upheld"
+	ResolutionDismissed = "dismissed"
+)
+
+type Report struct {
+	Id         int        `json:"id"`
+	ChirpId    int        `json:"chirp_id"`
+	ReporterId int        `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Resolution string     `json:"resolution"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+// ReporterReputation summarises how admins have resolved a user's past reports.
+type ReporterReputation struct {
+	UserId    int `json:"user_id"`
+	Upheld    int `json:"upheld"`
+	Dismissed int `json:"dismissed"`
+}
+
+// Score estimates the chance that the user's next report is upheld. Users
+// without any resolved reports score 0.5.
+func (r ReporterReputation) Score() float64 {
+	return float64(r.Upheld+1) / float64(r.Upheld+r.Dismissed+2)
+}
+
+func (db *DB) CreateReport(report Report) (Report, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	if _, found := dbStruct.liveChirp(report.ChirpId); !found {
+		return Report{}, notFound(ErrChirpDoesNotExist, report.ChirpId)
+	}
+	report.Id = dbStruct.NextReportId
+	report.CreatedAt = time.Now().UTC()
+	report.Resolution = ""
+	report.ResolvedAt = nil
+	dbStruct.Reports[report.Id] = report
+	dbStruct.NextReportId++
+	if err := db.writeDB(dbStruct); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+func (db *DB) CountReportsSince(reporterId int, since time.Time) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, report := range dbStruct.Reports {
+		if report.ReporterId == reporterId && !report.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetOpenReports returns unresolved reports, oldest first.
+func (db *DB) GetOpenReports() ([]Report, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0)
+	for _, report := range dbStruct.Reports {
+		if report.Resolution == "" {
+			reports = append(reports, report)
+		}
+	}
+	slices.SortFunc(reports, func(a, b Report) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return reports, nil
+}
+
+func (db *DB) ResolveReport(id int, resolution string) (Report, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Report{}, err
+	}
+	report, found := dbStruct.Reports[id]
+	if !found {
+		return Report{}, notFound(ErrReportDoesNotExist, id)
+	}
+	resolvedAt := time.Now().UTC()
+	report.Resolution = resolution
+	report.ResolvedAt = &resolvedAt
+	dbStruct.Reports[id] = report
+	if err := db.writeDB(dbStruct); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+func (db *DB) GetReporterReputation(userId int) (ReporterReputation, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ReporterReputation{}, err
+	}
+	reputation := ReporterReputation{UserId: userId}
+	for _, report := range dbStruct.Reports {
+		if report.ReporterId != userId {
+			continue
+		}
+		switch report.Resolution {
+		case ResolutionUpheld:
+			reputation.Upheld++
+		case ResolutionDismissed:
+			reputation.Dismissed++
+		}
+	}
+	return reputation, nil
+}
+
+const reportColumns = `id, chirp_id, reporter_id, reason, created_at, resolution, resolved_at`
+
+func scanReport(row scanner) (Report, error) {
+	report := Report{}
+	err := row.Scan(&report.Id, &report.ChirpId, &report.ReporterId, &report.Reason,
+		&report.CreatedAt, &report.Resolution, &report.ResolvedAt)
+	return report, err
+}
+
+func (db *SQLDB) CreateReport(report Report) (Report, error) {
+	_, found, err := db.GetChirp(report.ChirpId)
+	if err != nil {
+		return Report{}, err
+	}
+	if !found {
+		return Report{}, notFound(ErrChirpDoesNotExist, report.ChirpId)
+	}
+	report.CreatedAt = time.Now().UTC()
+	report.Resolution = ""
+	report.ResolvedAt = nil
+	err = db.queryRow(`INSERT INTO reports (chirp_id, reporter_id, reason, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		report.ChirpId, report.ReporterId, report.Reason, report.CreatedAt).Scan(&report.Id)
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+func (db *SQLDB) CountReportsSince(reporterId int, since time.Time) (int, error) {
+	var count int
+	err := db.queryRow(`SELECT COUNT(*) FROM reports WHERE reporter_id = ? AND created_at >= ?`, reporterId, since.UTC()).Scan(&count)
+	return count, err
+}
+
+func (db *SQLDB) GetOpenReports() ([]Report, error) {
+	rows, err := db.query(`SELECT ` + reportColumns + ` FROM reports WHERE resolution = '' ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := make([]Report, 0)
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+func (db *SQLDB) ResolveReport(id int, resolution string) (Report, error) {
+	resolvedAt := time.Now().UTC()
+	result, err := db.exec(`UPDATE reports SET resolution = ?, resolved_at = ? WHERE id = ?`, resolution, resolvedAt, id)
+	if err != nil {
+		return Report{}, err
+	}
+	if err := requireRow(result, notFound(ErrReportDoesNotExist, id)); err != nil {
+		return Report{}, err
+	}
+	report, err := scanReport(db.queryRow(`SELECT `+reportColumns+` FROM reports WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Report{}, notFound(ErrReportDoesNotExist, id)
+	}
+	return report, err
+}
+
+func (db *SQLDB) GetReporterReputation(userId int) (ReporterReputation, error) {
+	reputation := ReporterReputation{UserId: userId}
+	err := db.queryRow(`SELECT
+			COALESCE(SUM(CASE WHEN resolution = ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN resolution = ? THEN 1 ELSE 0 END), 0)
+		FROM reports WHERE reporter_id = ?`, ResolutionUpheld, ResolutionDismissed, userId).
+		Scan(&reputation.Upheld, &reputation.Dismissed)
+	return reputation, err
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// ScheduledChirp is a chirp waiting to be published at PublishAt. Its body
+// has already been checked and censored; publishing creates the chirp as
+// if it had been posted then.
+type ScheduledChirp struct {
+	Id        int          `json:"id"`
+	AuthorId  int          `json:"author_id"`
+	Body      string       `json:"body"`
+	ParentId  *int         `json:"parent_id"`
+	Media     []Attachment `json:"media"`
+	Censored  bool         `json:"censored"`
+	QuotedId  *int         `json:"quoted_id"`
+	PublishAt time.Time    `json:"publish_at"`
+	// PublishAtLocal is PublishAt in the zone the author scheduled it in.
+	PublishAtLocal string    `json:"publish_at_local"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Chirp returns the chirp that publishing scheduled creates.
+func (scheduled ScheduledChirp) Chirp() Chirp {
+	return Chirp{
+		AuthorId: scheduled.AuthorId,
+		Body:     scheduled.Body,
+		ParentId: scheduled.ParentId,
+		Media:    scheduled.Media,
+		Censored: scheduled.Censored,
+		QuotedId: scheduled.QuotedId,
+	}
+}
+
+func sortScheduledChirps(chirps []ScheduledChirp) {
+	slices.SortFunc(chirps, func(a, b ScheduledChirp) int {
+		if c := a.PublishAt.Compare(b.PublishAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Id, b.Id)
+	})
+}
+
+func (db *DB) CreateScheduledChirp(scheduled ScheduledChirp) (ScheduledChirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	dbStruct.NextScheduledChirpId = max(dbStruct.NextScheduledChirpId, 1)
+	scheduled.Id = dbStruct.NextScheduledChirpId
+	scheduled.PublishAt = scheduled.PublishAt.UTC()
+	scheduled.CreatedAt = time.Now().UTC()
+	dbStruct.ScheduledChirps[scheduled.Id] = scheduled
+	dbStruct.NextScheduledChirpId++
+	if err := db.writeDB(dbStruct); err != nil {
+		return ScheduledChirp{}, err
+	}
+	return scheduled, nil
+}
+
+// GetScheduledChirps returns the author's scheduled chirps, soonest first.
+func (db *DB) GetScheduledChirps(authorId int) ([]ScheduledChirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	chirps := []ScheduledChirp{}
+	for _, scheduled := range dbStruct.ScheduledChirps {
+		if scheduled.AuthorId == authorId {
+			chirps = append(chirps, scheduled)
+		}
+	}
+	sortScheduledChirps(chirps)
+	return chirps, nil
+}
+
+// DeleteScheduledChirp cancels a scheduled chirp of the author. Those of
+// other authors are reported missing.
+func (db *DB) DeleteScheduledChirp(id, authorId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	scheduled, found := dbStruct.ScheduledChirps[id]
+	if !found || scheduled.AuthorId != authorId {
+		return notFound(ErrScheduledChirpDoesNotExist, id)
+	}
+	delete(dbStruct.ScheduledChirps, id)
+	return db.writeDB(dbStruct)
+}
+
+// TakeDueScheduledChirps removes and returns the chirps due to be published
+// at the given time, soonest first, so that each is published only once.
+func (db *DB) TakeDueScheduledChirps(at time.Time) ([]ScheduledChirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	var due []ScheduledChirp
+	for id, scheduled := range dbStruct.ScheduledChirps {
+		if !scheduled.PublishAt.After(at) {
+			due = append(due, scheduled)
+			delete(dbStruct.ScheduledChirps, id)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return nil, err
+	}
+	sortScheduledChirps(due)
+	return due, nil
+}
+
+const scheduledChirpColumns = `id, author_id, body, parent_id, media, censored, quoted_id, publish_at, publish_at_local, created_at`
+
+func scanScheduledChirp(row scanner) (ScheduledChirp, error) {
+	scheduled := ScheduledChirp{}
+	var media sql.NullString
+	err := row.Scan(&scheduled.Id, &scheduled.AuthorId, &scheduled.Body, &scheduled.ParentId, &media,
+		&scheduled.Censored, &scheduled.QuotedId, &scheduled.PublishAt, &scheduled.PublishAtLocal, &scheduled.CreatedAt)
+	if err != nil {
+		return scheduled, err
+	}
+	if media.Valid {
+		if err := json.Unmarshal([]byte(media.String), &scheduled.Media); err != nil {
+			return scheduled, err
+		}
+	}
+	return scheduled, nil
+}
+
+func (db *SQLDB) scanScheduledChirps(rows *sql.Rows, err error) ([]ScheduledChirp, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chirps := []ScheduledChirp{}
+	for rows.Next() {
+		scheduled, err := scanScheduledChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, scheduled)
+	}
+	return chirps, rows.Err()
+}
+
+func (db *SQLDB) CreateScheduledChirp(scheduled ScheduledChirp) (ScheduledChirp, error) {
+	media, err := json.Marshal(scheduled.Media)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	scheduled.PublishAt = scheduled.PublishAt.UTC()
+	scheduled.CreatedAt = time.Now().UTC()
+	err = db.queryRow(`INSERT INTO scheduled_chirps (author_id, body, parent_id, media, censored, quoted_id, publish_at, publish_at_local, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		scheduled.AuthorId, scheduled.Body, scheduled.ParentId, string(media), scheduled.Censored, scheduled.QuotedId,
+		scheduled.PublishAt, scheduled.PublishAtLocal, scheduled.CreatedAt).Scan(&scheduled.Id)
+	if err != nil {
+		return ScheduledChirp{}, err
+	}
+	return scheduled, nil
+}
+
+func (db *SQLDB) GetScheduledChirps(authorId int) ([]ScheduledChirp, error) {
+	return db.scanScheduledChirps(db.query(`SELECT `+scheduledChirpColumns+` FROM scheduled_chirps WHERE author_id = ? ORDER BY publish_at, id`, authorId))
+}
+
+func (db *SQLDB) DeleteScheduledChirp(id, authorId int) error {
+	result, err := db.exec(`DELETE FROM scheduled_chirps WHERE id = ? AND author_id = ?`, id, authorId)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrScheduledChirpDoesNotExist, id))
+}
+
+func (db *SQLDB) TakeDueScheduledChirps(at time.Time) ([]ScheduledChirp, error) {
+	due, err := db.scanScheduledChirps(db.query(`DELETE FROM scheduled_chirps WHERE publish_at <= ? RETURNING `+scheduledChirpColumns, at.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	sortScheduledChirps(due)
+	return due, nil
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"errors"
+	"strings"
+)
+
+// likeEscaper escapes the LIKE wildcards in a search, so they match
+// themselves.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchChirps returns the chirps whose body contains query, ignoring
+// case. Stores whose SupportsSearch is false return errors.ErrUnsupported.
+func (db *DB) SearchChirps(query, order string) ([]Chirp, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// SearchChirps folds case the way the engine's LOWER does, which for SQLite
+// is ASCII letters only.
+func (db *SQLDB) SearchChirps(query, order string) ([]Chirp, error) {
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(query)) + "%"
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps
+		WHERE LOWER(chirps.body) LIKE ? ESCAPE '\' AND chirps.deleted_at IS NULL`+orderBy(order), pattern)
+}
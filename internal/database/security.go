@@ -0,0 +1,132 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"time"
+)
+
+// The kinds of security events recorded for users.
+const (
+	SecuritySignup           = "signup"
+	SecurityLogin            = "login"
+	SecurityLoginFailed      = "login_failed"
+	SecurityPasswordChanged  = "password_changed"
+	SecurityEmailChanged     = "email_changed"
+	SecurityTOTPEnabled      = "totp_enabled"
+	SecurityRecoveryCodeUsed = "recovery_code_used"
+	SecuritySessionsRevoked  = "sessions_revoked"
+)
+
+// maxSecurityEventsPerKind is how many events of each kind are kept for a
+// user. Keeping them by kind means a run of logins never pushes out the
+// last password change.
+const maxSecurityEventsPerKind = 20
+
+// SecurityEvent is something that happened to a user's account that they
+// may want to review, such as a login or a password change.
+type SecurityEvent struct {
+	Id     int    `json:"id"`
+	UserId int    `json:"-"`
+	Kind   string `json:"kind"`
+	// IP is the address the request came from, when there was one.
+	IP        string    `json:"ip,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordSecurityEvent adds event to its user's security log, forgetting the
+// oldest event of its kind beyond maxSecurityEventsPerKind.
+func (db *DB) RecordSecurityEvent(event SecurityEvent) (SecurityEvent, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return SecurityEvent{}, err
+	}
+	if _, found := dbStruct.Users[event.UserId]; !found {
+		return SecurityEvent{}, notFound(ErrUserDoesNotExist, event.UserId)
+	}
+	dbStruct.NextSecurityEventId = max(dbStruct.NextSecurityEventId, 1)
+	event.Id = dbStruct.NextSecurityEventId
+	dbStruct.NextSecurityEventId++
+	event.CreatedAt = event.CreatedAt.UTC()
+
+	events := append(dbStruct.SecurityEvents[event.UserId], event)
+	kept := make([]SecurityEvent, 0, len(events))
+	count := make(map[string]int)
+	for i := len(events) - 1; i >= 0; i-- {
+		if count[events[i].Kind]++; count[events[i].Kind] <= maxSecurityEventsPerKind {
+			kept = append(kept, events[i])
+		}
+	}
+	slices.Reverse(kept)
+	dbStruct.SecurityEvents[event.UserId] = kept
+	if err := db.writeDB(dbStruct); err != nil {
+		return SecurityEvent{}, err
+	}
+	return event, nil
+}
+
+// GetSecurityEvents returns the security log of a user, newest first.
+func (db *DB) GetSecurityEvents(userId int) ([]SecurityEvent, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	events := slices.Clone(dbStruct.SecurityEvents[userId])
+	slices.SortFunc(events, func(a, b SecurityEvent) int { return cmp.Compare(b.Id, a.Id) })
+	if events == nil {
+		events = make([]SecurityEvent, 0)
+	}
+	return events, nil
+}
+
+// GetUserSessions returns the sessions of a user that have not expired at
+// the given time.
+func (db *DB) GetUserSessions(userId int, at time.Time) ([]Session, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0)
+	for _, session := range dbStruct.Sessions {
+		if session.UserId == userId && at.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	slices.SortFunc(sessions, func(a, b Session) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return sessions, nil
+}
+
+func scanSecurityEvent(row scanner) (SecurityEvent, error) {
+	event := SecurityEvent{}
+	err := row.Scan(&event.Id, &event.UserId, &event.Kind, &event.IP, &event.CreatedAt)
+	return event, err
+}
+
+func (db *SQLDB) RecordSecurityEvent(event SecurityEvent) (SecurityEvent, error) {
+	if _, err := db.GetUserById(event.UserId); err != nil {
+		return SecurityEvent{}, err
+	}
+	event.CreatedAt = event.CreatedAt.UTC()
+	err := db.queryRow(`INSERT INTO security_events (user_id, kind, ip, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		event.UserId, event.Kind, event.IP, event.CreatedAt).Scan(&event.Id)
+	if err != nil {
+		return SecurityEvent{}, err
+	}
+	_, err = db.exec(`DELETE FROM security_events WHERE user_id = ? AND kind = ? AND id NOT IN (
+		SELECT id FROM security_events WHERE user_id = ? AND kind = ? ORDER BY id DESC LIMIT ?)`,
+		event.UserId, event.Kind, event.UserId, event.Kind, maxSecurityEventsPerKind)
+	if err != nil {
+		return SecurityEvent{}, err
+	}
+	return event, nil
+}
+
+func (db *SQLDB) GetSecurityEvents(userId int) ([]SecurityEvent, error) {
+	return queryRows(db, `SELECT id, user_id, kind, ip, created_at FROM security_events WHERE user_id = ? ORDER BY id DESC`,
+		scanSecurityEvent, userId)
+}
+
+func (db *SQLDB) GetUserSessions(userId int, at time.Time) ([]Session, error) {
+	return queryRows(db, `SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at`,
+		scanSession, userId, at.UTC())
+}
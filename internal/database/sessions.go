@@ -0,0 +1,257 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Session is a server-side login backing an opaque refresh token. Only a
+// hash of the current token is stored, and the token changes every time the
+// session is refreshed.
+type Session struct {
+	Id                string    `json:"id"`
+	UserId            int       `json:"user_id"`
+	TokenHash         string    `json:"-"`
+	PreviousTokenHash string    `json:"-"` // the token rotated out last, kept to spot replays
+	CreatedAt         time.Time `json:"created_at"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	// MaxExpiresAt is when a capped session ends, however recently it was
+	// refreshed.
+	MaxExpiresAt *time.Time `json:"max_expires_at,omitempty"`
+}
+
+// expiry is when the session ends if it is refreshed at now for ttl.
+func (session Session) expiry(now time.Time, ttl time.Duration) time.Time {
+	expiresAt := now.Add(ttl)
+	if session.MaxExpiresAt != nil && session.MaxExpiresAt.Before(expiresAt) {
+		return *session.MaxExpiresAt
+	}
+	return expiresAt
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSession(id string, userId int, tokenHash string, now time.Time, ttl, maxAge time.Duration) Session {
+	session := Session{
+		Id:        id,
+		UserId:    userId,
+		TokenHash: tokenHash,
+		CreatedAt: now,
+	}
+	if maxAge > 0 {
+		maxExpiresAt := now.Add(maxAge)
+		session.MaxExpiresAt = &maxExpiresAt
+	}
+	session.ExpiresAt = session.expiry(now, ttl)
+	return session
+}
+
+// newSessionToken returns a fresh token and its hash.
+func newSessionToken() (string, string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, hashToken(token), nil
+}
+
+func (db *DB) CreateSession(userId int, ttl time.Duration) (Session, string, error) {
+	return db.CreateCappedSession(userId, ttl, 0)
+}
+
+// CreateCappedSession starts a session that lasts ttl from its last refresh
+// but never longer than maxAge in all. A zero maxAge means no cap.
+func (db *DB) CreateCappedSession(userId int, ttl, maxAge time.Duration) (Session, string, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Session{}, "", err
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return Session{}, "", err
+	}
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+	now := time.Now().UTC()
+	for sessionId, session := range dbStruct.Sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(dbStruct.Sessions, sessionId)
+		}
+	}
+	session := newSession(id, userId, tokenHash, now, ttl, maxAge)
+	dbStruct.Sessions[id] = session
+	if err := db.writeDB(dbStruct); err != nil {
+		return Session{}, "", err
+	}
+	return session, token, nil
+}
+
+// RotateSession swaps token for a new one and extends the session by ttl,
+// up to its cap. Presenting a token that was already rotated out ends the
+// session, since either the client or whoever copied the token is replaying
+// it.
+func (db *DB) RotateSession(token string, ttl time.Duration) (Session, string, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Session{}, "", err
+	}
+	tokenHash := hashToken(token)
+	now := time.Now().UTC()
+	for id, session := range dbStruct.Sessions {
+		if session.PreviousTokenHash == tokenHash {
+			delete(dbStruct.Sessions, id)
+			if err := db.writeDB(dbStruct); err != nil {
+				return Session{}, "", err
+			}
+			return Session{}, "", ErrSessionReplayed
+		}
+		if session.TokenHash != tokenHash {
+			continue
+		}
+		if !now.Before(session.ExpiresAt) {
+			return Session{}, "", ErrSessionDoesNotExist
+		}
+		newToken, newTokenHash, err := newSessionToken()
+		if err != nil {
+			return Session{}, "", err
+		}
+		session.PreviousTokenHash = session.TokenHash
+		session.TokenHash = newTokenHash
+		session.ExpiresAt = session.expiry(now, ttl)
+		dbStruct.Sessions[id] = session
+		if err := db.writeDB(dbStruct); err != nil {
+			return Session{}, "", err
+		}
+		return session, newToken, nil
+	}
+	return Session{}, "", ErrSessionDoesNotExist
+}
+
+func (db *DB) DeleteSession(token string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	tokenHash := hashToken(token)
+	for id, session := range dbStruct.Sessions {
+		if session.TokenHash == tokenHash {
+			entry := journalEntry{Op: journalDeleteSession, SessionId: id}
+			entry.apply(&dbStruct)
+			return db.commit(dbStruct, entry)
+		}
+	}
+	return ErrSessionDoesNotExist
+}
+
+func (db *DB) DeleteUserSessions(userId int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	for id, session := range dbStruct.Sessions {
+		if session.UserId == userId {
+			delete(dbStruct.Sessions, id)
+		}
+	}
+	return db.writeDB(dbStruct)
+}
+
+const sessionColumns = `id, user_id, token_hash, previous_token_hash, created_at, expires_at, max_expires_at`
+
+func scanSession(row scanner) (Session, error) {
+	session := Session{}
+	err := row.Scan(&session.Id, &session.UserId, &session.TokenHash, &session.PreviousTokenHash,
+		&session.CreatedAt, &session.ExpiresAt, &session.MaxExpiresAt)
+	return session, err
+}
+
+func (db *SQLDB) CreateSession(userId int, ttl time.Duration) (Session, string, error) {
+	return db.CreateCappedSession(userId, ttl, 0)
+}
+
+func (db *SQLDB) CreateCappedSession(userId int, ttl, maxAge time.Duration) (Session, string, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return Session{}, "", err
+	}
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+	now := time.Now().UTC()
+	if _, err := db.exec(`DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+		return Session{}, "", err
+	}
+	session := newSession(id, userId, tokenHash, now, ttl, maxAge)
+	_, err = db.exec(`INSERT INTO sessions (id, user_id, token_hash, created_at, expires_at, max_expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		session.Id, session.UserId, session.TokenHash, session.CreatedAt, session.ExpiresAt, session.MaxExpiresAt)
+	if err != nil {
+		return Session{}, "", err
+	}
+	return session, token, nil
+}
+
+// RotateSession is DB.RotateSession for SQL. The UPDATE matches on the old
+// hash, so of two concurrent refreshes with the same token only one wins.
+func (db *SQLDB) RotateSession(token string, ttl time.Duration) (Session, string, error) {
+	tokenHash := hashToken(token)
+	result, err := db.exec(`DELETE FROM sessions WHERE previous_token_hash = ?`, tokenHash)
+	if err != nil {
+		return Session{}, "", err
+	}
+	replayed, err := result.RowsAffected()
+	if err != nil {
+		return Session{}, "", err
+	}
+	if replayed > 0 {
+		return Session{}, "", ErrSessionReplayed
+	}
+	session, err := scanSession(db.queryRow(`SELECT `+sessionColumns+` FROM sessions WHERE token_hash = ?`, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, "", ErrSessionDoesNotExist
+	}
+	if err != nil {
+		return Session{}, "", err
+	}
+	now := time.Now().UTC()
+	if !now.Before(session.ExpiresAt) {
+		return Session{}, "", ErrSessionDoesNotExist
+	}
+	newToken, newTokenHash, err := newSessionToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+	session.PreviousTokenHash = tokenHash
+	session.TokenHash = newTokenHash
+	session.ExpiresAt = session.expiry(now, ttl)
+	result, err = db.exec(`UPDATE sessions SET token_hash = ?, previous_token_hash = ?, expires_at = ? WHERE id = ? AND token_hash = ?`,
+		session.TokenHash, session.PreviousTokenHash, session.ExpiresAt, session.Id, tokenHash)
+	if err != nil {
+		return Session{}, "", err
+	}
+	if err := requireRow(result, ErrSessionReplayed); err != nil {
+		return Session{}, "", err
+	}
+	return session, newToken, nil
+}
+
+func (db *SQLDB) DeleteSession(token string) error {
+	result, err := db.exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	if err != nil {
+		return err
+	}
+	return requireRow(result, ErrSessionDoesNotExist)
+}
+
+func (db *SQLDB) DeleteUserSessions(userId int) error {
+	_, err := db.exec(`DELETE FROM sessions WHERE user_id = ?`, userId)
+	return err
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+)
+
+// shortIdAlphabet spells short ids in base62.
+const shortIdAlphabet = linkCodeAlphabet
+
+// newShortId returns the base62 form of a random 64-bit value. Unlike chirp
+// ids, short ids say nothing about how many chirps there are or which ids
+// exist, so they are what public URLs use.
+func newShortId() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	n := binary.BigEndian.Uint64(b)
+	if n == 0 {
+		return string(shortIdAlphabet[0]), nil
+	}
+	var id []byte
+	for ; n > 0; n /= uint64(len(shortIdAlphabet)) {
+		id = append(id, shortIdAlphabet[n%uint64(len(shortIdAlphabet))])
+	}
+	return string(id), nil
+}
+
+// assignShortId gives chirp a short id no other chirp has, unless it
+// already has one.
+func (dbStruct *DBStructure) assignShortId(chirp *Chirp) error {
+	for chirp.ShortId == "" {
+		id, err := newShortId()
+		if err != nil {
+			return err
+		}
+		if _, taken := dbStruct.ChirpShortIds[id]; !taken {
+			chirp.ShortId = id
+		}
+	}
+	return nil
+}
+
+// GetChirpByShortId returns the chirp with the given short id.
+func (db *DB) GetChirpByShortId(shortId string) (Chirp, bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	id, found := dbStruct.ChirpShortIds[shortId]
+	if !found {
+		return Chirp{}, false, nil
+	}
+	chirp, found := dbStruct.liveChirp(id)
+	return chirp, found, nil
+}
+
+func (db *SQLDB) GetChirpByShortId(shortId string) (Chirp, bool, error) {
+	chirp, err := scanChirp(db.queryRow(`SELECT `+chirpColumns+` FROM chirps WHERE short_id = ? AND deleted_at IS NULL`, shortId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, false, nil
+	}
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	return chirp, true, nil
+}
+
+// backfillShortIds gives a short id to each chirp stored before they were
+// assigned. Random ids cannot be made by a migration portably, so this runs
+// after migrating instead.
+func (db *SQLDB) backfillShortIds() error {
+	rows, err := db.query(`SELECT id FROM chirps WHERE short_id IS NULL`)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		shortId, err := newShortId()
+		if err != nil {
+			return err
+		}
+		if _, err := db.exec(`UPDATE chirps SET short_id = ? WHERE id = ?`, shortId, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+)
+
+// GetChirpWithDeleted is GetChirp for callers that need deleted chirps too,
+// such as to leave a placeholder where one was in a thread. Deleted chirps
+// have DeletedAt set; their content must not be shown.
+func (db *DB) GetChirpWithDeleted(id int) (Chirp, bool, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	chirp, found := dbStruct.Chirps[id]
+	return chirp, found, nil
+}
+
+// RestoreChirp brings back a deleted chirp, as it was when it was deleted.
+func (db *DB) RestoreChirp(id int) (Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, found := dbStruct.Chirps[id]
+	if !found {
+		return Chirp{}, notFound(ErrChirpDoesNotExist, id)
+	}
+	if chirp.DeletedAt == nil {
+		return Chirp{}, ErrChirpNotDeleted
+	}
+	chirp.DeletedAt = nil
+	dbStruct.Chirps[id] = chirp
+	if chirp.ParentId != nil {
+		if parent, found := dbStruct.Chirps[*chirp.ParentId]; found {
+			parent.ReplyCount++
+			dbStruct.Chirps[parent.Id] = parent
+		}
+	}
+	dbStruct.countRechirp(chirp, 1)
+	if err := db.writeDB(dbStruct); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PurgeChirps permanently removes the chirps deleted before before, with
+// their likes, short links and polls, and returns how many there were.
+func (db *DB) PurgeChirps(before time.Time) (int, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for id, chirp := range dbStruct.Chirps {
+		if chirp.DeletedAt == nil || !chirp.DeletedAt.Before(before) {
+			continue
+		}
+		delete(dbStruct.Chirps, id)
+		delete(dbStruct.ChirpShortIds, chirp.ShortId)
+		for _, liked := range dbStruct.Likes {
+			delete(liked, id)
+		}
+		delete(dbStruct.Polls, id)
+		delete(dbStruct.PollVotes, id)
+		for code, link := range dbStruct.Links {
+			if link.ChirpId == id {
+				delete(dbStruct.Links, code)
+			}
+		}
+		if chirp.ParentId != nil {
+			dbStruct.Replies[*chirp.ParentId] = slices.DeleteFunc(dbStruct.Replies[*chirp.ParentId], func(reply int) bool {
+				return reply == id
+			})
+		}
+		purged++
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	if err := db.writeDB(dbStruct); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+func (db *SQLDB) GetChirpWithDeleted(id int) (Chirp, bool, error) {
+	chirp, err := scanChirp(db.queryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, false, nil
+	}
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	return chirp, true, nil
+}
+
+func (db *SQLDB) RestoreChirp(id int) (Chirp, error) {
+	chirp, found, err := db.GetChirpWithDeleted(id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if !found {
+		return Chirp{}, notFound(ErrChirpDoesNotExist, id)
+	}
+	if chirp.DeletedAt == nil {
+		return Chirp{}, ErrChirpNotDeleted
+	}
+	if _, err := db.exec(`UPDATE chirps SET deleted_at = NULL WHERE id = ?`, id); err != nil {
+		return Chirp{}, err
+	}
+	chirp.DeletedAt = nil
+	if err := db.setChirpTags(chirp); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLDB) PurgeChirps(before time.Time) (int, error) {
+	rows, err := db.query(`SELECT id FROM chirps WHERE deleted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if _, err := db.exec(`DELETE FROM likes WHERE chirp_id = ?`, id); err != nil {
+			return 0, err
+		}
+		for _, table := range []string{"links", "polls", "poll_votes"} {
+			if _, err := db.exec(`DELETE FROM `+table+` WHERE chirp_id = ?`, id); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := db.exec(`DELETE FROM chirps WHERE id = ?`, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,791 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/richtext"
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+// SQLDB is a Storage backed by a database/sql connection pool.
+type SQLDB struct {
+	conn    *sql.DB
+	dialect dialect
+	ids     IdGenerator
+	// tx is set on the view WriteBatch hands out, which runs every
+	// statement in it.
+	tx *sql.Tx
+	// ctx is set on the view WithContext hands out.
+	ctx context.Context
+}
+
+// dialect holds the small differences between the SQL engines we support.
+type dialect struct {
+	driver string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered bool
+	// substitutions applied to the schema migrations; a migration they
+	// leave empty is only recorded
+	types *strings.Replacer
+	// statement that moves a table's id sequence past its largest id, or
+	// empty if the engine does that itself
+	resetSerial string
+	// statement that returns the database's size in bytes
+	sizeQuery string
+}
+
+var sqliteDialect = dialect{
+	driver:   "sqlite",
+	numbered: false,
+	types: strings.NewReplacer(
+		"{{serial}}", "INTEGER PRIMARY KEY AUTOINCREMENT",
+		"{{blob}}", "BLOB",
+		"{{timestamp}}", "TIMESTAMP",
+		"{{widen_chirp_ids}}", "",
+		"{{widen_feed_markers}}", "",
+	),
+	sizeQuery: `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`,
+}
+
+// migrations are applied in order and recorded in schema_migrations, so new
+// statements must only ever be appended.
+var migrations = []string{
+	`CREATE TABLE users (
+		id {{serial}},
+		email TEXT NOT NULL UNIQUE,
+		password {{blob}} NOT NULL,
+		is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`CREATE TABLE chirps (
+		id {{serial}},
+		body TEXT NOT NULL,
+		author_id INTEGER NOT NULL
+	)`,
+	`CREATE INDEX chirps_author_id_idx ON chirps (author_id)`,
+	`CREATE TABLE revoked_refresh_tokens (
+		token TEXT PRIMARY KEY,
+		revoked_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE TABLE api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		secret TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`ALTER TABLE chirps ADD COLUMN edited_at {{timestamp}}`,
+	`ALTER TABLE chirps ADD COLUMN parent_id INTEGER`,
+	`CREATE INDEX chirps_parent_id_idx ON chirps (parent_id)`,
+	`CREATE TABLE likes (
+		user_id INTEGER NOT NULL,
+		chirp_id INTEGER NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		PRIMARY KEY (user_id, chirp_id)
+	)`,
+	`CREATE INDEX likes_chirp_id_idx ON likes (chirp_id)`,
+	`ALTER TABLE chirps ADD COLUMN entities TEXT`,
+	`CREATE TABLE reports (
+		id {{serial}},
+		chirp_id INTEGER NOT NULL,
+		reporter_id INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		resolution TEXT NOT NULL DEFAULT '',
+		resolved_at {{timestamp}}
+	)`,
+	`CREATE INDEX reports_reporter_id_idx ON reports (reporter_id)`,
+	`ALTER TABLE users ADD COLUMN handle TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX users_handle_idx ON users (handle) WHERE handle <> ''`,
+	`CREATE TABLE moderation_actions (
+		id {{serial}},
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		chirp_id INTEGER,
+		reason TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		until {{timestamp}},
+		revoked_at {{timestamp}}
+	)`,
+	`CREATE INDEX moderation_actions_user_id_idx ON moderation_actions (user_id)`,
+	`CREATE TABLE appeals (
+		id {{serial}},
+		action_id INTEGER NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL,
+		message TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		resolution TEXT NOT NULL DEFAULT '',
+		resolved_at {{timestamp}}
+	)`,
+	`DROP TABLE revoked_refresh_tokens`,
+	`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		previous_token_hash TEXT NOT NULL DEFAULT '',
+		created_at {{timestamp}} NOT NULL,
+		expires_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE INDEX sessions_user_id_idx ON sessions (user_id)`,
+	`CREATE INDEX sessions_previous_token_hash_idx ON sessions (previous_token_hash)`,
+	`CREATE TABLE links (
+		code TEXT PRIMARY KEY,
+		chirp_id INTEGER NOT NULL,
+		url TEXT NOT NULL,
+		clicks INTEGER NOT NULL DEFAULT 0,
+		created_at {{timestamp}} NOT NULL,
+		UNIQUE (chirp_id, url)
+	)`,
+	`ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE`,
+	// Users who signed up before verification existed keep posting.
+	`UPDATE users SET verified = TRUE`,
+	`CREATE TABLE verification_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL UNIQUE,
+		expires_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE TABLE media (
+		id TEXT PRIMARY KEY,
+		owner_id INTEGER NOT NULL,
+		blob_key TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		alt_text TEXT NOT NULL DEFAULT '',
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`ALTER TABLE chirps ADD COLUMN media TEXT`,
+	`ALTER TABLE chirps ADD COLUMN created_at {{timestamp}}`,
+	`ALTER TABLE users ADD COLUMN feed_algorithm TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE follows (
+		follower_id INTEGER NOT NULL,
+		followee_id INTEGER NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		PRIMARY KEY (follower_id, followee_id)
+	)`,
+	`CREATE INDEX follows_followee_id_idx ON follows (followee_id)`,
+	`CREATE TABLE feed_markers (
+		user_id INTEGER PRIMARY KEY,
+		last_read_id INTEGER NOT NULL,
+		updated_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE TABLE chirp_tags (
+		chirp_id INTEGER NOT NULL,
+		tag TEXT NOT NULL,
+		author_id INTEGER NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		PRIMARY KEY (chirp_id, tag)
+	)`,
+	`CREATE INDEX chirp_tags_tag_idx ON chirp_tags (tag, created_at)`,
+	`CREATE INDEX chirp_tags_created_at_idx ON chirp_tags (created_at)`,
+	`CREATE TABLE emoji (
+		shortcode TEXT PRIMARY KEY,
+		media_id TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE TABLE jobs (
+		id {{serial}},
+		kind TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		state TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		max_attempts INTEGER NOT NULL,
+		run_at {{timestamp}} NOT NULL,
+		last_error TEXT NOT NULL,
+		result TEXT,
+		created_at {{timestamp}} NOT NULL,
+		updated_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE INDEX jobs_state_run_at_idx ON jobs (state, run_at)`,
+	`CREATE INDEX chirps_created_at_idx ON chirps (created_at)`,
+	`ALTER TABLE jobs ADD COLUMN request_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chirps ADD COLUMN short_id TEXT`,
+	`CREATE UNIQUE INDEX chirps_short_id_idx ON chirps (short_id)`,
+	`ALTER TABLE chirps ADD COLUMN deleted_at {{timestamp}}`,
+	`CREATE INDEX chirps_deleted_at_idx ON chirps (deleted_at)`,
+	`ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN deletion_requested_at {{timestamp}}`,
+	`CREATE TABLE device_authorizations (
+		user_code TEXT PRIMARY KEY,
+		device_code_hash TEXT NOT NULL UNIQUE,
+		state TEXT NOT NULL,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		created_at {{timestamp}} NOT NULL,
+		expires_at {{timestamp}} NOT NULL,
+		polled_at {{timestamp}}
+	)`,
+	`ALTER TABLE users ADD COLUMN totp_secret {{blob}}`,
+	`ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE users ADD COLUMN recovery_codes TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sessions ADD COLUMN max_expires_at {{timestamp}}`,
+	`CREATE TABLE login_throttles (
+		kind TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		failures INTEGER NOT NULL,
+		window_start {{timestamp}} NOT NULL,
+		locked_until {{timestamp}},
+		PRIMARY KEY (kind, subject)
+	)`,
+	`CREATE TABLE uploads (
+		id TEXT PRIMARY KEY,
+		owner_id INTEGER NOT NULL,
+		length BIGINT NOT NULL,
+		upload_offset BIGINT NOT NULL,
+		chunks TEXT NOT NULL DEFAULT '',
+		alt_text TEXT NOT NULL DEFAULT '',
+		created_at {{timestamp}} NOT NULL,
+		expires_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE TABLE blocked_words (
+		pattern TEXT PRIMARY KEY,
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`INSERT INTO blocked_words (pattern, created_at) VALUES
+		('kerfuffle', CURRENT_TIMESTAMP), ('sharbert', CURRENT_TIMESTAMP), ('fornax', CURRENT_TIMESTAMP)`,
+	`ALTER TABLE chirps ADD COLUMN censored BOOLEAN NOT NULL DEFAULT FALSE`,
+	`CREATE TABLE scheduled_chirps (
+		id {{serial}},
+		author_id INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		parent_id INTEGER,
+		media TEXT,
+		censored BOOLEAN NOT NULL DEFAULT FALSE,
+		publish_at {{timestamp}} NOT NULL,
+		publish_at_local TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE INDEX scheduled_chirps_publish_at ON scheduled_chirps (publish_at)`,
+	`ALTER TABLE media ADD COLUMN hash TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE media_blobs (
+		hash TEXT PRIMARY KEY,
+		blob_key TEXT NOT NULL,
+		refs INTEGER NOT NULL
+	)`,
+	`CREATE TABLE drafts (
+		id {{serial}},
+		author_id INTEGER NOT NULL,
+		body TEXT NOT NULL,
+		parent_id INTEGER,
+		media TEXT,
+		created_at {{timestamp}} NOT NULL,
+		updated_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE INDEX drafts_author_id ON drafts (author_id)`,
+	`ALTER TABLE users ADD COLUMN banner_url TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE chirps ADD COLUMN rechirp_of_id INTEGER`,
+	`ALTER TABLE chirps ADD COLUMN quoted_id INTEGER`,
+	`CREATE INDEX chirps_rechirp_of_id ON chirps (rechirp_of_id)`,
+	`ALTER TABLE scheduled_chirps ADD COLUMN quoted_id INTEGER`,
+	`CREATE TABLE bookmarks (
+		user_id INTEGER NOT NULL,
+		chirp_id INTEGER NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		PRIMARY KEY (user_id, chirp_id)
+	)`,
+	`ALTER TABLE users ADD COLUMN website TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN website_verified_at {{timestamp}}`,
+	`ALTER TABLE users ADD COLUMN moved_to TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN moved_from TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE polls (
+		chirp_id INTEGER PRIMARY KEY,
+		options TEXT NOT NULL,
+		closes_at {{timestamp}} NOT NULL,
+		closed_at {{timestamp}}
+	)`,
+	`CREATE INDEX polls_closes_at ON polls (closes_at)`,
+	`CREATE TABLE poll_votes (
+		chirp_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		choice INTEGER NOT NULL,
+		created_at {{timestamp}} NOT NULL,
+		PRIMARY KEY (chirp_id, user_id)
+	)`,
+	`{{widen_chirp_ids}}`,
+	`CREATE TABLE webhooks (
+		id {{serial}},
+		url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE TABLE idempotency_keys (
+		idempotency_key TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		content_type TEXT NOT NULL,
+		body {{blob}},
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE INDEX idempotency_keys_created_at ON idempotency_keys (created_at)`,
+	`CREATE TABLE security_events (
+		id {{serial}},
+		user_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		created_at {{timestamp}} NOT NULL
+	)`,
+	`CREATE INDEX security_events_user_id ON security_events (user_id, kind)`,
+	`CREATE TABLE counters (
+		name TEXT NOT NULL,
+		day TEXT NOT NULL,
+		count INTEGER NOT NULL,
+		PRIMARY KEY (name, day)
+	)`,
+	`ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0`,
+	`{{widen_feed_markers}}`,
+}
+
+func NewSQLiteDB(path string) (*SQLDB, error) {
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	return openSQLDB(sqliteDialect, dsn)
+}
+
+func openSQLDB(d dialect, dsn string) (*SQLDB, error) {
+	conn, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := &SQLDB{conn: conn, dialect: d}
+	if err := db.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := db.backfillShortIds(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *SQLDB) Ping() error {
+	return db.conn.Ping()
+}
+
+func (db *SQLDB) Close() error {
+	return db.conn.Close()
+}
+
+func (db *SQLDB) migrate() error {
+	if _, err := db.conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var version int
+	if err := db.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.conn.Begin()
+		if err != nil {
+			return err
+		}
+		if statement := db.dialect.types.Replace(migrations[i]); statement != "" {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec(db.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebind rewrites ? placeholders into the form the dialect expects.
+func (db *SQLDB) rebind(query string) string {
+	if !db.dialect.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (db *SQLDB) exec(query string, args ...any) (sql.Result, error) {
+	if db.tx != nil {
+		return db.tx.ExecContext(db.context(), db.rebind(query), args...)
+	}
+	return db.conn.ExecContext(db.context(), db.rebind(query), args...)
+}
+
+func (db *SQLDB) query(query string, args ...any) (*sql.Rows, error) {
+	if db.tx != nil {
+		return db.tx.QueryContext(db.context(), db.rebind(query), args...)
+	}
+	return db.conn.QueryContext(db.context(), db.rebind(query), args...)
+}
+
+func (db *SQLDB) queryRow(query string, args ...any) *sql.Row {
+	if db.tx != nil {
+		return db.tx.QueryRowContext(db.context(), db.rebind(query), args...)
+	}
+	return db.conn.QueryRowContext(db.context(), db.rebind(query), args...)
+}
+
+func (db *SQLDB) CreateChirp(chirp Chirp) (Chirp, error) {
+	if chirp.ParentId != nil {
+		_, found, err := db.GetChirp(*chirp.ParentId)
+		if err != nil {
+			return Chirp{}, err
+		}
+		if !found {
+			return Chirp{}, ErrParentDoesNotExist
+		}
+	}
+	chirp.Entities = richtext.Extract(chirp.Body)
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	entities, err := json.Marshal(chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	media, err := json.Marshal(chirp.Media)
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp.CreatedAt = time.Now().UTC()
+	chirp.LikeCount = 0
+	chirp.ReplyCount = 0
+	chirp.RechirpCount = 0
+	chirp.ShortId, err = newShortId()
+	if err != nil {
+		return Chirp{}, err
+	}
+	if db.ids != nil {
+		err = db.insertChirpWithGeneratedId(&chirp, string(entities), string(media))
+	} else {
+		err = db.queryRow(`INSERT INTO chirps (short_id, body, author_id, parent_id, entities, media, created_at, censored, rechirp_of_id, quoted_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			chirp.ShortId, chirp.Body, chirp.AuthorId, chirp.ParentId, string(entities), string(media), chirp.CreatedAt, chirp.Censored,
+			chirp.RechirpOfId, chirp.QuotedId).Scan(&chirp.Id)
+	}
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := db.setChirpTags(chirp); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (db *SQLDB) DeleteChirp(chirpIdToDelete, idOfRequestingUser int) error {
+	chirp, found, err := db.GetChirp(chirpIdToDelete)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return notFound(ErrChirpDoesNotExist, chirpIdToDelete)
+	}
+	if chirp.AuthorId != idOfRequestingUser {
+		return ErrAuthorization
+	}
+	if _, err := db.exec(`UPDATE chirps SET deleted_at = ? WHERE id = ?`, time.Now().UTC(), chirpIdToDelete); err != nil {
+		return err
+	}
+	// Trends are counted from the tag index alone, so a deleted chirp's
+	// tags leave it until the chirp is restored.
+	_, err = db.exec(`DELETE FROM chirp_tags WHERE chirp_id = ?`, chirpIdToDelete)
+	return err
+}
+
+func (db *SQLDB) UpdateChirp(chirpIdToUpdate, idOfRequestingUser int, body string, censored bool) (Chirp, error) {
+	chirp, found, err := db.GetChirp(chirpIdToUpdate)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if !found {
+		return Chirp{}, notFound(ErrChirpDoesNotExist, chirpIdToUpdate)
+	}
+	if chirp.AuthorId != idOfRequestingUser {
+		return Chirp{}, ErrAuthorization
+	}
+	if chirp.RechirpOfId != nil {
+		return Chirp{}, ErrRechirpNotEditable
+	}
+	editedAt := time.Now().UTC()
+	chirp.Body = body
+	chirp.Censored = censored
+	chirp.Entities = richtext.Extract(body)
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	chirp.EditedAt = &editedAt
+	entities, err := json.Marshal(chirp.Entities)
+	if err != nil {
+		return Chirp{}, err
+	}
+	_, err = db.exec(`UPDATE chirps SET body = ?, censored = ?, entities = ?, edited_at = ? WHERE id = ?`,
+		chirp.Body, chirp.Censored, string(entities), editedAt, chirp.Id)
+	if err != nil {
+		return Chirp{}, err
+	}
+	if err := db.setChirpTags(chirp); err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// chirpColumns lists the columns scanChirp expects, in order. They are
+// qualified so that queries can join chirps with other tables.
+const chirpColumns = `chirps.id, chirps.short_id, chirps.body, chirps.author_id, chirps.parent_id, chirps.edited_at, chirps.entities, chirps.media,
+	chirps.created_at, chirps.deleted_at, chirps.censored, chirps.rechirp_of_id, chirps.quoted_id,
+	(SELECT COUNT(*) FROM likes WHERE likes.chirp_id = chirps.id),
+	(SELECT COUNT(*) FROM chirps AS replies WHERE replies.parent_id = chirps.id AND replies.deleted_at IS NULL),
+	(SELECT COUNT(*) FROM chirps AS rechirps WHERE rechirps.rechirp_of_id = chirps.id AND rechirps.deleted_at IS NULL)`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanChirp(row scanner) (Chirp, error) {
+	chirp := Chirp{}
+	var shortId, entities, media sql.NullString
+	var createdAt sql.NullTime
+	err := row.Scan(&chirp.Id, &shortId, &chirp.Body, &chirp.AuthorId, &chirp.ParentId, &chirp.EditedAt, &entities, &media,
+		&createdAt, &chirp.DeletedAt, &chirp.Censored, &chirp.RechirpOfId, &chirp.QuotedId, &chirp.LikeCount, &chirp.ReplyCount, &chirp.RechirpCount)
+	if err != nil {
+		return chirp, err
+	}
+	chirp.ShortId = shortId.String
+	chirp.CreatedAt = createdAt.Time
+	if media.Valid {
+		if err := json.Unmarshal([]byte(media.String), &chirp.Media); err != nil {
+			return chirp, err
+		}
+	}
+	if !entities.Valid {
+		return chirp, nil
+	}
+	if err := json.Unmarshal([]byte(entities.String), &chirp.Entities); err != nil {
+		return chirp, err
+	}
+	chirp.Tags = richtext.Tags(chirp.Entities)
+	return chirp, nil
+}
+
+func (db *SQLDB) GetRechirp(authorId, id int) (Chirp, bool, error) {
+	chirp, err := scanChirp(db.queryRow(`SELECT `+chirpColumns+` FROM chirps
+		WHERE author_id = ? AND rechirp_of_id = ? AND deleted_at IS NULL`, authorId, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, false, nil
+	}
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	return chirp, true, nil
+}
+
+func (db *SQLDB) GetChirp(id int) (Chirp, bool, error) {
+	chirp, err := scanChirp(db.queryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ? AND deleted_at IS NULL`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Chirp{}, false, nil
+	}
+	if err != nil {
+		return Chirp{}, false, err
+	}
+	return chirp, true, nil
+}
+
+func (db *SQLDB) GetChirpsByIds(ids []int) ([]Chirp, error) {
+	if len(ids) == 0 {
+		return []Chirp{}, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps WHERE id IN (`+placeholders(len(ids))+`) AND deleted_at IS NULL`+orderBy("asc"), args...)
+}
+
+func (db *SQLDB) GetChirps(order string) ([]Chirp, error) {
+	return db.queryChirps(`SELECT ` + chirpColumns + ` FROM chirps WHERE deleted_at IS NULL` + orderBy(order))
+}
+
+func (db *SQLDB) GetChirpsSince(since time.Time) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps WHERE created_at >= ? AND deleted_at IS NULL`+orderBy("asc"), since.UTC())
+}
+
+func (db *SQLDB) GetChirpsFromId(authorId int, order string) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? AND deleted_at IS NULL`+orderBy(order), authorId)
+}
+
+func (db *SQLDB) GetDescendants(rootId int) ([]Chirp, error) {
+	return db.queryChirps(`WITH RECURSIVE thread (id) AS (
+			SELECT id FROM chirps WHERE parent_id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT chirps.id FROM chirps JOIN thread ON chirps.parent_id = thread.id WHERE chirps.deleted_at IS NULL
+		)
+		SELECT `+chirpColumns+` FROM chirps WHERE id IN (SELECT id FROM thread)`+orderBy("asc"), rootId)
+}
+
+func (db *SQLDB) GetDescendantsWithDeleted(rootId int) ([]Chirp, error) {
+	return db.queryChirps(`WITH RECURSIVE thread (id) AS (
+			SELECT id FROM chirps WHERE parent_id = ?
+			UNION ALL
+			SELECT chirps.id FROM chirps JOIN thread ON chirps.parent_id = thread.id
+		)
+		SELECT `+chirpColumns+` FROM chirps WHERE id IN (SELECT id FROM thread)`+orderBy("asc"), rootId)
+}
+
+func (db *SQLDB) GetReplies(parentId int, order string) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps WHERE parent_id = ? AND deleted_at IS NULL`+orderBy(order), parentId)
+}
+
+func (db *SQLDB) queryChirps(query string, args ...any) ([]Chirp, error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	chirps := make([]Chirp, 0)
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, rows.Err()
+}
+
+// orderBy mirrors sortChirps: anything but asc or desc leaves the order unspecified.
+func orderBy(order string) string {
+	if order == "asc" {
+		return " ORDER BY chirps.created_at ASC, chirps.id ASC"
+	}
+	if order == "desc" {
+		return " ORDER BY chirps.created_at DESC, chirps.id DESC"
+	}
+	return ""
+}
+
+func (db *SQLDB) CreateUser(email, password string) (User, error) {
+	normalizedEmail := normalizeEmail(email)
+	_, found, err := db.getUserByEmail(normalizedEmail)
+	if err != nil {
+		return User{}, err
+	}
+	if found {
+		return User{}, ErrUserAlreadyExists
+	}
+	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	user := User{
+		Email:       normalizedEmail,
+		Password:    hashPass,
+		IsChirpyRed: false,
+	}
+	err = db.queryRow(`INSERT INTO users (email, password, is_chirpy_red) VALUES (?, ?, ?) RETURNING id`,
+		user.Email, user.Password, user.IsChirpyRed).Scan(&user.Id)
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLDB) GetUser(email string) (User, error) {
+	user, found, err := db.getUserByEmail(normalizeEmail(email))
+	if err != nil {
+		return User{}, err
+	}
+	if !found {
+		return User{}, ErrUserDoesNotExist
+	}
+	return user, nil
+}
+
+// userColumns lists the columns scanUser expects, in order.
+const userColumns = `id, email, password, is_chirpy_red, verified, handle, display_name, bio, avatar_url, banner_url, website, website_verified_at, moved_to, moved_from, feed_algorithm, is_admin, deletion_requested_at, totp_secret, totp_enabled, recovery_codes, totp_last_step`
+
+func scanUser(row scanner) (User, error) {
+	user := User{}
+	var recoveryCodes string
+	err := row.Scan(&user.Id, &user.Email, &user.Password, &user.IsChirpyRed, &user.Verified,
+		&user.Handle, &user.DisplayName, &user.Bio, &user.AvatarURL, &user.BannerURL, &user.Website, &user.WebsiteVerifiedAt, &user.MovedTo, &user.MovedFrom,
+		&user.FeedAlgorithm, &user.IsAdmin, &user.DeletionRequestedAt,
+		&user.TOTPSecret, &user.TOTPEnabled, &recoveryCodes, &user.TOTPLastStep)
+	user.RecoveryCodes = strings.Fields(recoveryCodes)
+	return user, err
+}
+
+func (db *SQLDB) getUserByEmail(email string) (User, bool, error) {
+	user, err := scanUser(db.queryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, false, nil
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+func (db *SQLDB) UpdateUser(id int, email, password string) error {
+	hashPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	email = normalizeEmail(email)
+	result, err := db.exec(`UPDATE users SET
+			verified = CASE WHEN email = ? THEN verified ELSE FALSE END,
+			email = ?, password = ?
+		WHERE id = ?`, email, email, hashPass, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrUserDoesNotExist, id))
+}
+
+func (db *SQLDB) UpgradeUser(id int) error {
+	result, err := db.exec(`UPDATE users SET is_chirpy_red = ? WHERE id = ?`, true, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrUserDoesNotExist, id))
+}
+
+func (db *SQLDB) SetFeedAlgorithm(id int, algorithm string) error {
+	result, err := db.exec(`UPDATE users SET feed_algorithm = ? WHERE id = ?`, algorithm, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrUserDoesNotExist, id))
+}
+
+// requireRow returns notFound if the statement did not touch any rows.
+func requireRow(result sql.Result, notFound error) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+func (db *SQLDB) ComparePasswords(password, withEmail string) error {
+	user, err := db.GetUser(withEmail)
+	if err != nil {
+		return err
+	}
+	return bcrypt.CompareHashAndPassword(user.Password, []byte(password))
+}
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSQL(t *testing.T) {
+	path := "./test_db.sqlite"
+	defer os.Remove(path)
+	defer os.Remove(path + "-wal")
+	defer os.Remove(path + "-shm")
+
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	runSQLUsersTest(t, db)
+	runSQLChirpsTest(t, db)
+	runSQLEntitiesTest(t, db)
+	runProfilesTest(t, db)
+	runModerationTest(t, db)
+	runSessionsTest(t, db)
+	runLinksTest(t, db)
+	runVerificationTest(t, db)
+	runFeedSignalsTest(t, db)
+	runFollowsTest(t, db)
+	runFeedMarkerTest(t, db)
+	runDescendantsTest(t, db)
+	runTagsTest(t, db)
+	runEmojiTest(t, db)
+	runExportTest(t, db)
+	runImportTest(t, db)
+	runJobsTest(t, db)
+	runChirpOrderTest(t, db)
+	runJobLeaseTest(t, db)
+	runBackupTest(t, db)
+	runShortIdTest(t, db)
+	runSoftDeleteTest(t, db)
+	runAdminTest(t, db)
+	runDeleteUserTest(t, db)
+	runExportUserTest(t, db)
+	runChirpsSinceTest(t, db)
+	runStatsTest(t, db)
+	runDeviceAuthorizationTest(t, db)
+	runTOTPTest(t, db)
+	runLoginThrottleTest(t, db)
+	runUploadTest(t, db)
+	runBlockedWordsTest(t, db)
+	runScheduledChirpTest(t, db)
+	runMediaDedupTest(t, db)
+	runDraftTest(t, db)
+	runOrphanedMediaTest(t, db)
+	runRechirpTest(t, db)
+	runBookmarkTest(t, db)
+	runWebsiteTest(t, db)
+	runMovedAccountTest(t, db)
+	runPollTest(t, db)
+	runIdGeneratorTest(t, db)
+	runWebhookTest(t, db)
+	runWriteBatchTest(t, db)
+	runIdempotencyTest(t, db)
+	runSecurityEventsTest(t, db)
+	runWithContextTest(t, db)
+	runCountersTest(t, db)
+	runAnonymizeTest(t, db)
+	runWipeTest(t, db)
+	runSearchTest(t, db)
+
+	runRebindTest(t, sqliteDialect, "SELECT ? WHERE a = ?", "SELECT ? WHERE a = ?")
+	runRebindTest(t, postgresDialect, "SELECT ? WHERE a = ?", "SELECT $1 WHERE a = $2")
+}
+
+func runRebindTest(t *testing.T, d dialect, query, expecting string) {
+	t.Logf("Starting test for rebind on %s with: \"%s\", and expecting: \"%s\"", d.driver, query, expecting)
+	db := &SQLDB{dialect: d}
+	got := db.rebind(query)
+	if got != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, got)
+	}
+}
+
+func runSQLUsersTest(t *testing.T, db *SQLDB) {
+	t.Logf("Starting test for SQL users with: \"%s\"", "Someone@Example.com")
+	user, err := db.CreateUser(" Someone@Example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "someone@example.com" {
+		t.Errorf("Expecting: %s, but got: %s", "someone@example.com", user.Email)
+	}
+	if _, err := db.CreateUser("someone@example.com", "hunter2"); err != ErrUserAlreadyExists {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserAlreadyExists, err)
+	}
+	if err := db.ComparePasswords("hunter2", "someone@example.com"); err != nil {
+		t.Errorf("Expecting: <nil>, but got: %v", err)
+	}
+	if err := db.UpgradeUser(user.Id); err != nil {
+		t.Error(err)
+	}
+	got, err := db.GetUser("someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsChirpyRed {
+		t.Errorf("Expecting: true, but got: %t", got.IsChirpyRed)
+	}
+	if err := db.UpgradeUser(user.Id + 100); !errors.Is(err, ErrUserDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrUserDoesNotExist, err)
+	}
+}
+
+func runSQLChirpsTest(t *testing.T, db *SQLDB) {
+	expecting := []Chirp{
+		{Id: 2, Body: "Some other chirp", AuthorId: 1},
+		{Id: 1, Body: "Some chirp", AuthorId: 1},
+	}
+	t.Logf("Starting test for SQL chirps, expecting: %v", expecting)
+	if _, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Some chirp"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "Some other chirp"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetChirpsFromId(1, "desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(expecting) {
+		t.Fatalf("Expecting: %v, but got: %v", expecting, got)
+	}
+	for i := range got {
+		if got[i].Id != expecting[i].Id || got[i].Body != expecting[i].Body || got[i].AuthorId != expecting[i].AuthorId {
+			t.Errorf("Expecting: %v, but got: %v", expecting, got)
+		}
+	}
+	if _, err := db.UpdateChirp(2, 2, "Not mine", false); err != ErrAuthorization {
+		t.Errorf("Expecting: %v, but got: %v", ErrAuthorization, err)
+	}
+	edited, err := db.UpdateChirp(2, 1, "Some edited chirp", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _, _ := db.GetChirp(2); stored.Body != "Some edited chirp" || stored.EditedAt == nil || !stored.Censored {
+		t.Errorf("Expecting: %v, but got: %v", edited, stored)
+	}
+	parentId := 2
+	reply, err := db.CreateChirp(Chirp{AuthorId: 2, Body: "A reply", ParentId: &parentId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies, err := db.GetReplies(2, "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].Id != reply.Id {
+		t.Errorf("Expecting: %v, but got: %v", []Chirp{reply}, replies)
+	}
+	missingId := 100
+	if _, err := db.CreateChirp(Chirp{AuthorId: 2, Body: "Orphan", ParentId: &missingId}); !errors.Is(err, ErrParentDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrParentDoesNotExist, err)
+	}
+	runSQLLikesTest(t, db)
+	if err := db.DeleteChirp(1, 2); err != ErrAuthorization {
+		t.Errorf("Expecting: %v, but got: %v", ErrAuthorization, err)
+	}
+	if err := db.DeleteChirp(1, 1); err != nil {
+		t.Error(err)
+	}
+	if _, found, _ := db.GetChirp(1); found {
+		t.Errorf("Expecting: false, but got: %t", found)
+	}
+}
+
+func runSQLLikesTest(t *testing.T, db *SQLDB) {
+	t.Logf("Starting test for SQL likes on chirp 2, and expecting: 1")
+	db.LikeChirp(2, 1)
+	db.LikeChirp(2, 1)
+	chirp, _, err := db.GetChirp(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.LikeCount != 1 {
+		t.Errorf("Expecting: 1, but got: %d", chirp.LikeCount)
+	}
+	liked, err := db.GetLikedChirps(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(liked) != 1 || liked[0].Id != 2 {
+		t.Errorf("Expecting: [chirp 2], but got: %v", liked)
+	}
+	db.UnlikeChirp(2, 1)
+	chirp, _, _ = db.GetChirp(2)
+	if chirp.LikeCount != 0 {
+		t.Errorf("Expecting: 0, but got: %d", chirp.LikeCount)
+	}
+	if err := db.LikeChirp(100, 1); !errors.Is(err, ErrChirpDoesNotExist) {
+		t.Errorf("Expecting: %v, but got: %v", ErrChirpDoesNotExist, err)
+	}
+}
+
+func runSQLEntitiesTest(t *testing.T, db *SQLDB) {
+	t.Logf("Starting test for SQL entities with: \"%s\", and expecting: %s", "hello #golang", "golang")
+	created, err := db.CreateChirp(Chirp{AuthorId: 1, Body: "hello #golang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := db.GetChirp(created.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Entities) != 1 || got.Entities[0].Value != "golang" {
+		t.Errorf("Expecting: %s, but got: %v", "golang", got.Entities)
+	}
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// StorageStats describes how much the database holds, for the admin stats.
+// Chirps only counts live chirps, and Sessions the refresh sessions not yet
+// logged out or rotated away. LastCompaction is when the gob store last
+// folded its journal into a snapshot, and nil for the SQL stores.
+type StorageStats struct {
+	SizeBytes      int64          `json:"size_bytes"`
+	Users          int            `json:"users"`
+	Chirps         int            `json:"chirps"`
+	Sessions       int            `json:"sessions"`
+	Jobs           map[string]int `json:"jobs"` // job state -> count
+	LastCompaction *time.Time     `json:"last_compaction"`
+}
+
+func (db *DB) Stats() (StorageStats, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return StorageStats{}, err
+	}
+	stats := StorageStats{
+		Users:    len(dbStruct.Users),
+		Sessions: len(dbStruct.Sessions),
+		Jobs:     make(map[string]int),
+	}
+	for id := range dbStruct.Chirps {
+		if _, live := dbStruct.liveChirp(id); live {
+			stats.Chirps++
+		}
+	}
+	for _, job := range dbStruct.Jobs {
+		stats.Jobs[job.State]++
+	}
+
+	// Every snapshot write folds the journal in, so the snapshot's
+	// modification time is the last compaction.
+	info, err := os.Stat(db.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return StorageStats{}, err
+	}
+	if err == nil {
+		stats.SizeBytes = info.Size()
+		compactedAt := info.ModTime().UTC()
+		stats.LastCompaction = &compactedAt
+	}
+	if info, err := os.Stat(db.path + journalSuffix); err == nil {
+		stats.SizeBytes += info.Size()
+	}
+	return stats, nil
+}
+
+func (db *SQLDB) Stats() (StorageStats, error) {
+	stats := StorageStats{Jobs: make(map[string]int)}
+	err := db.queryRow(db.dialect.sizeQuery).Scan(&stats.SizeBytes)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	err = db.queryRow(`SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM chirps WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM sessions)`).Scan(&stats.Users, &stats.Chirps, &stats.Sessions)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	rows, err := db.query(`SELECT state, COUNT(*) FROM jobs GROUP BY state`)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state string
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return StorageStats{}, err
+		}
+		stats.Jobs[state] = count
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Storage is implemented by every backend the server can persist to.
+// Handlers should depend on Storage rather than on a concrete backend.
+type Storage interface {
+	CreateChirp(chirp Chirp) (Chirp, error)
+	UpdateChirp(chirpIdToUpdate, idOfRequestingUser int, body string, censored bool) (Chirp, error)
+	DeleteChirp(chirpIdToDelete, idOfRequestingUser int) error
+	GetChirpWithDeleted(id int) (Chirp, bool, error)
+	RestoreChirp(id int) (Chirp, error)
+	PurgeChirps(before time.Time) (int, error)
+	GetChirp(id int) (Chirp, bool, error)
+	GetRechirp(authorId, id int) (Chirp, bool, error)
+	CreateScheduledChirp(scheduled ScheduledChirp) (ScheduledChirp, error)
+	GetScheduledChirps(authorId int) ([]ScheduledChirp, error)
+	DeleteScheduledChirp(id, authorId int) error
+	TakeDueScheduledChirps(at time.Time) ([]ScheduledChirp, error)
+	CreateDraft(draft Draft) (Draft, error)
+	GetDraft(id, authorId int) (Draft, error)
+	GetDrafts(authorId int) ([]Draft, error)
+	UpdateDraft(draft Draft) (Draft, error)
+	DeleteDraft(id, authorId int) error
+	GetChirpByShortId(shortId string) (Chirp, bool, error)
+	GetChirpsByIds(ids []int) ([]Chirp, error)
+	GetChirps(order string) ([]Chirp, error)
+	GetChirpsSince(since time.Time) ([]Chirp, error)
+	GetChirpsFromId(authorId int, order string) ([]Chirp, error)
+	GetReplies(parentId int, order string) ([]Chirp, error)
+	GetDescendants(rootId int) ([]Chirp, error)
+	GetDescendantsWithDeleted(rootId int) ([]Chirp, error)
+	GetChirpsByTag(tag, order string) ([]Chirp, error)
+	SearchChirps(query, order string) ([]Chirp, error)
+	GetTrends(since time.Time, limit int) ([]TrendingTag, error)
+
+	CreateMedia(media Media) (Media, error)
+	GetMedia(id string) (Media, error)
+	DeleteMedia(id string) (string, error)
+	GetOrphanedMedia(createdBefore time.Time) ([]Media, error)
+	CreateUpload(upload Upload) (Upload, error)
+	GetUpload(id string) (Upload, error)
+	AppendUploadChunk(id string, offset int64, key string, size int64) (Upload, error)
+	DeleteUpload(id string) error
+	GetExpiredUploads(at time.Time) ([]Upload, error)
+	EnqueueJob(job Job) (Job, error)
+	ClaimJob(now time.Time, lease time.Duration) (Job, bool, error)
+	ExtendJobLease(id, attempts int, until time.Time) error
+	CompleteJob(id int, result json.RawMessage) error
+	FailJob(id int, reason string, retryAt *time.Time) (Job, error)
+	GetJob(id int) (Job, error)
+	GetJobs(state string) ([]Job, error)
+	RequeueJob(id int) (Job, error)
+	CreateWebhook(url string, events []string) (Webhook, error)
+	GetWebhook(id int) (Webhook, error)
+	GetWebhooks() ([]Webhook, error)
+	DeleteWebhook(id int) error
+
+	AddBlockedWord(pattern string) (BlockedWord, error)
+	DeleteBlockedWord(pattern string) error
+	GetBlockedWords() ([]BlockedWord, error)
+	PutEmoji(shortcode, mediaId string) (Emoji, error)
+	DeleteEmoji(shortcode string) error
+	GetEmoji() ([]Emoji, error)
+
+	LikeChirp(chirpId, userId int) error
+	UnlikeChirp(chirpId, userId int) error
+	GetLikedChirps(userId int) ([]Chirp, error)
+	BookmarkChirp(chirpId, userId int) error
+	UnbookmarkChirp(chirpId, userId int) error
+	GetBookmarkedChirps(userId int) ([]Chirp, error)
+	CreatePoll(chirpId int, options []string, closesAt time.Time) (Poll, error)
+	GetPolls(chirpIds []int) (map[int]Poll, error)
+	VotePoll(chirpId, userId, option int) (Poll, error)
+	ClosePolls(at time.Time) ([]int, error)
+
+	Follow(followerId, followeeId int) error
+	Unfollow(followerId, followeeId int) error
+	GetFollowing(userId int) ([]int, error)
+	GetFollowers(userId int) ([]int, error)
+	GetFeedMarker(userId int) (FeedMarker, error)
+	SetFeedMarker(userId, lastReadId int) (FeedMarker, error)
+
+	CreateLinks(chirpId int, urls []string) ([]Link, error)
+	GetLinks(chirpIds []int) (map[int][]Link, error)
+	FollowLink(code string, track bool) (Link, error)
+
+	CreateReport(report Report) (Report, error)
+	CountReportsSince(reporterId int, since time.Time) (int, error)
+	GetOpenReports() ([]Report, error)
+	ResolveReport(id int, resolution string) (Report, error)
+	GetReporterReputation(userId int) (ReporterReputation, error)
+
+	CreateModerationAction(action ModerationAction) (ModerationAction, error)
+	GetModerationAction(id int) (ModerationAction, error)
+	GetModerationActions(userId int) ([]ModerationAction, error)
+	IsSuspended(userId int, at time.Time) (bool, error)
+	CreateAppeal(appeal Appeal) (Appeal, error)
+	GetOpenAppeals() ([]Appeal, error)
+	ResolveAppeal(id int, resolution string) (Appeal, error)
+
+	CreateUser(email, password string) (User, error)
+	GetUser(email string) (User, error)
+	UpdateUser(id int, email, password string) error
+	UpgradeUser(id int) error
+	SetFeedAlgorithm(id int, algorithm string) error
+	ComparePasswords(password, withEmail string) error
+	GetUserById(id int) (User, error)
+	UpdateProfile(id int, update ProfileUpdate) (User, error)
+	VerifyWebsite(id int, website string) (User, error)
+	GetUserByMovedFrom(profileURL string) (User, bool, error)
+	GetProfiles(ids []int) (map[int]Profile, error)
+	GetUsers() ([]User, error)
+	SetAdmin(id int, isAdmin bool) (User, error)
+	LiftSuspensions(userId int, at time.Time) (int, error)
+	RequestUserDeletion(id int, at time.Time) (User, error)
+	CancelUserDeletion(id int) error
+	DeleteUser(id int) error
+	PurgeUsers(before time.Time) (int, error)
+	CreateVerificationToken(userId int, ttl time.Duration) (string, error)
+	VerifyUser(token string) (User, error)
+	SetTOTPSecret(id int, sealed []byte) error
+	ReplaceTOTPSecret(id int, sealed []byte) error
+	EnableTOTP(id int, recoveryCodes []string) error
+	UseRecoveryCode(id int, code string) (bool, error)
+	UseTOTPStep(id int, step int64) (bool, error)
+
+	CreateSession(userId int, ttl time.Duration) (Session, string, error)
+	CreateCappedSession(userId int, ttl, maxAge time.Duration) (Session, string, error)
+	RotateSession(token string, ttl time.Duration) (Session, string, error)
+	DeleteSession(token string) error
+	DeleteUserSessions(userId int) error
+	GetUserSessions(userId int, at time.Time) ([]Session, error)
+	RecordSecurityEvent(event SecurityEvent) (SecurityEvent, error)
+	GetSecurityEvents(userId int) ([]SecurityEvent, error)
+	CreateDeviceAuthorization(ttl time.Duration) (DeviceAuthorization, string, error)
+	GetDeviceAuthorization(userCode string) (DeviceAuthorization, error)
+	DecideDeviceAuthorization(userCode string, userId int, approve bool) (DeviceAuthorization, error)
+	PollDeviceAuthorization(deviceCode string, at time.Time) (DeviceAuthorization, error)
+	GetLoginThrottle(kind, subject string) (LoginThrottle, error)
+	RecordLoginFailure(kind, subject string, at time.Time, policy LockoutPolicy) (LoginThrottle, error)
+	ResetLoginFailures(kind, subject string) error
+	ActiveLockouts(at time.Time) (map[string]int, error)
+
+	CreateAPIKey(userId int, name string) (APIKey, error)
+	GetAPIKey(id string) (APIKey, bool, error)
+	GetAPIKeys(userId int) ([]APIKey, error)
+	DeleteAPIKey(id string, idOfRequestingUser int) error
+
+	Export(emit func(Record) error) error
+	ExportUser(id int) (UserExport, error)
+	PutUser(user User) (User, error)
+	PutChirp(chirp Chirp) (Chirp, error)
+	PutFollow(follow Follow) error
+	PutLike(like Like) error
+
+	// Backup writes a copy of the whole database to w, and Restore replaces
+	// the database with such a copy.
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+	// Anonymize replaces the personal data in the database with synthetic
+	// values, so a copy of it can be shared.
+	Anonymize(password string) (AnonymizeStats, error)
+	// Wipe empties the database, for development and tests.
+	Wipe() (WipeStats, error)
+
+	// Stats counts what the database holds.
+	Stats() (StorageStats, error)
+
+	ClaimIdempotencyKey(record IdempotencyRecord, since time.Time) (IdempotencyRecord, bool, error)
+	SaveIdempotentResponse(record IdempotencyRecord) error
+	ReleaseIdempotencyKey(key string) error
+	PurgeIdempotencyKeys(before time.Time) (int, error)
+
+	AddCounters(counters []DailyCounter) error
+	GetCounters(since string) ([]DailyCounter, error)
+	GetCounterTotals() (map[string]int, error)
+	ResetCounter(name string) error
+
+	// WriteBatch runs fn against a view of the store and keeps its writes
+	// only if fn succeeds.
+	WriteBatch(fn func(Storage) error) error
+	// WithContext returns a view of the store bound to ctx.
+	WithContext(ctx context.Context) Storage
+
+	// SetIdGenerator chooses how new chirps are numbered.
+	SetIdGenerator(ids IdGenerator)
+
+	// SupportsSearch, SupportsTransactions, and SupportsStreaming report
+	// which of the optional features in Capabilities the backend offers.
+	SupportsSearch() bool
+	SupportsTransactions() bool
+	SupportsStreaming() bool
+
+	// Ping reports whether the backend is reachable.
+	Ping() error
+	// Close flushes anything still buffered and releases the backend.
+	Close() error
+}
+
+var (
+	_ Storage = (*DB)(nil)
+	_ Storage = (*SQLDB)(nil)
+)
+
+// Open connects to the backend named by driver: gob or sqlite read the file
+// at path, and postgres connects to url.
+func Open(driver, path, url string) (Storage, error) {
+	switch driver {
+	case "", "gob":
+		return NewDB(path)
+	case "sqlite":
+		return NewSQLiteDB(path)
+	case "postgres":
+		return NewPostgresDB(url)
+	}
+	return nil, fmt.Errorf("unknown database driver %q", driver)
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TrendingTag is a hashtag with how often it was used over a window: Uses
+// counts chirps and Authors the distinct people who posted them.
+type TrendingTag struct {
+	Tag     string `json:"tag"`
+	Uses    int    `json:"uses"`
+	Authors int    `json:"authors"`
+}
+
+// NormalizeTag lowercases a hashtag and drops its leading #.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+// sortTrends orders tags by how many people used them, then by how often,
+// so a single account repeating a tag cannot push it to the top.
+func sortTrends(trends []TrendingTag) {
+	slices.SortFunc(trends, func(a, b TrendingTag) int {
+		if c := cmp.Compare(b.Authors, a.Authors); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Uses, a.Uses); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Tag, b.Tag)
+	})
+}
+
+func (db *DB) GetChirpsByTag(tag, order string) ([]Chirp, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	tag = NormalizeTag(tag)
+	chirps := []Chirp{}
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.DeletedAt == nil && slices.Contains(chirp.Tags, tag) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	sortChirps(chirps, order)
+	return chirps, nil
+}
+
+// GetTrends returns the most used hashtags in chirps posted since since.
+func (db *DB) GetTrends(since time.Time, limit int) ([]TrendingTag, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	uses := make(map[string]int)
+	authors := make(map[string]map[int]bool)
+	for _, chirp := range dbStruct.Chirps {
+		if chirp.CreatedAt.Before(since) || chirp.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range chirp.Tags {
+			uses[tag]++
+			if authors[tag] == nil {
+				authors[tag] = make(map[int]bool)
+			}
+			authors[tag][chirp.AuthorId] = true
+		}
+	}
+	trends := make([]TrendingTag, 0, len(uses))
+	for tag, count := range uses {
+		trends = append(trends, TrendingTag{Tag: tag, Uses: count, Authors: len(authors[tag])})
+	}
+	sortTrends(trends)
+	if len(trends) > limit {
+		trends = trends[:limit]
+	}
+	return trends, nil
+}
+
+// setChirpTags replaces the index entries for chirp's hashtags.
+func (db *SQLDB) setChirpTags(chirp Chirp) error {
+	if _, err := db.exec(`DELETE FROM chirp_tags WHERE chirp_id = ?`, chirp.Id); err != nil {
+		return err
+	}
+	for _, tag := range chirp.Tags {
+		_, err := db.exec(`INSERT INTO chirp_tags (chirp_id, tag, author_id, created_at) VALUES (?, ?, ?, ?)`,
+			chirp.Id, tag, chirp.AuthorId, chirp.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *SQLDB) GetChirpsByTag(tag, order string) ([]Chirp, error) {
+	return db.queryChirps(`SELECT `+chirpColumns+` FROM chirps
+		JOIN chirp_tags ON chirp_tags.chirp_id = chirps.id
+		WHERE chirp_tags.tag = ? AND chirps.deleted_at IS NULL`+orderBy(order), NormalizeTag(tag))
+}
+
+func (db *SQLDB) GetTrends(since time.Time, limit int) ([]TrendingTag, error) {
+	rows, err := db.query(`SELECT tag, COUNT(*), COUNT(DISTINCT author_id) FROM chirp_tags
+		WHERE created_at >= ?
+		GROUP BY tag
+		ORDER BY COUNT(DISTINCT author_id) DESC, COUNT(*) DESC, tag
+		LIMIT ?`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	trends := []TrendingTag{}
+	for rows.Next() {
+		trend := TrendingTag{}
+		if err := rows.Scan(&trend.Tag, &trend.Uses, &trend.Authors); err != nil {
+			return nil, err
+		}
+		trends = append(trends, trend)
+	}
+	return trends, rows.Err()
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"slices"
+	"strings"
+)
+
+// SetTOTPSecret stores the encrypted TOTP secret a user is enrolling, to be
+// enabled by EnableTOTP once they prove they can generate its codes. It
+// replaces the secret of an earlier, unfinished enrollment.
+func (db *DB) SetTOTPSecret(id int, sealed []byte) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return notFound(ErrUserDoesNotExist, id)
+	}
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+	user.TOTPSecret = sealed
+	dbStruct.Users[id] = user
+	return db.writeDB(dbStruct)
+}
+
+// ReplaceTOTPSecret swaps the user's stored TOTP secret, enrolled or not,
+// for the same secret sealed under another key.
+func (db *DB) ReplaceTOTPSecret(id int, sealed []byte) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return notFound(ErrUserDoesNotExist, id)
+	}
+	user.TOTPSecret = sealed
+	dbStruct.Users[id] = user
+	return db.writeDB(dbStruct)
+}
+
+// EnableTOTP turns on two-factor authentication with the secret stored by
+// SetTOTPSecret, and keeps the hashes of the user's recovery codes.
+func (db *DB) EnableTOTP(id int, recoveryCodes []string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return notFound(ErrUserDoesNotExist, id)
+	}
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+	user.TOTPEnabled = true
+	user.RecoveryCodes = hashRecoveryCodes(recoveryCodes)
+	dbStruct.Users[id] = user
+	return db.writeDB(dbStruct)
+}
+
+// UseRecoveryCode reports whether code is one of the user's unused recovery
+// codes, and if so uses it up.
+func (db *DB) UseRecoveryCode(id int, code string) (bool, error) {
+	used := false
+	err := db.updateDB(func(dbStruct *DBStructure) (bool, error) {
+		user, found := dbStruct.Users[id]
+		if !found {
+			return false, notFound(ErrUserDoesNotExist, id)
+		}
+		i := slices.Index(user.RecoveryCodes, hashRecoveryCode(code))
+		if i < 0 {
+			return false, nil
+		}
+		user.RecoveryCodes = slices.Delete(slices.Clone(user.RecoveryCodes), i, i+1)
+		dbStruct.Users[id] = user
+		used = true
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+// UseTOTPStep records step as the time step of a TOTP code the user has
+// given, reporting false if it is not after the last one recorded.
+func (db *DB) UseTOTPStep(id int, step int64) (bool, error) {
+	used := false
+	err := db.updateDB(func(dbStruct *DBStructure) (bool, error) {
+		user, found := dbStruct.Users[id]
+		if !found {
+			return false, notFound(ErrUserDoesNotExist, id)
+		}
+		if step <= user.TOTPLastStep {
+			return false, nil
+		}
+		user.TOTPLastStep = step
+		dbStruct.Users[id] = user
+		used = true
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+// hashRecoveryCode hashes code as typed, ignoring case and dashes.
+func hashRecoveryCode(code string) string {
+	return hashToken(strings.ReplaceAll(strings.ToLower(strings.TrimSpace(code)), "-", ""))
+}
+
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+	return hashes
+}
+
+func (db *SQLDB) SetTOTPSecret(id int, sealed []byte) error {
+	user, err := db.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+	result, err := db.exec(`UPDATE users SET totp_secret = ? WHERE id = ? AND NOT totp_enabled`, sealed, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, ErrTOTPEnabled)
+}
+
+func (db *SQLDB) ReplaceTOTPSecret(id int, sealed []byte) error {
+	result, err := db.exec(`UPDATE users SET totp_secret = ? WHERE id = ?`, sealed, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrUserDoesNotExist, id))
+}
+
+func (db *SQLDB) EnableTOTP(id int, recoveryCodes []string) error {
+	user, err := db.GetUserById(id)
+	if err != nil {
+		return err
+	}
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+	result, err := db.exec(`UPDATE users SET totp_enabled = TRUE, recovery_codes = ? WHERE id = ? AND NOT totp_enabled`,
+		strings.Join(hashRecoveryCodes(recoveryCodes), " "), id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, ErrTOTPEnabled)
+}
+
+func (db *SQLDB) UseRecoveryCode(id int, code string) (bool, error) {
+	user, err := db.GetUserById(id)
+	if err != nil {
+		return false, err
+	}
+	i := slices.Index(user.RecoveryCodes, hashRecoveryCode(code))
+	if i < 0 {
+		return false, nil
+	}
+	remaining := slices.Delete(slices.Clone(user.RecoveryCodes), i, i+1)
+	// Only update the codes as they were read, so two logins racing with
+	// the same code cannot both use it.
+	result, err := db.exec(`UPDATE users SET recovery_codes = ? WHERE id = ? AND recovery_codes = ?`,
+		strings.Join(remaining, " "), id, strings.Join(user.RecoveryCodes, " "))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+func (db *SQLDB) UseTOTPStep(id int, step int64) (bool, error) {
+	result, err := db.exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, id, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 1 {
+		return affected == 1, err
+	}
+	// Nothing changed: either the step is stale or there is no such user.
+	if _, err := db.GetUserById(id); err != nil {
+		return false, err
+	}
+	return false, nil
+}
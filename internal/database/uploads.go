@@ -0,0 +1,192 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Upload is media being uploaded in chunks, so a client whose connection
+// drops can resume where it left off. Each chunk received so far is a blob
+// of its own, listed in order in Chunks; together they hold Offset bytes
+// of the Length the client announced.
+type Upload struct {
+	Id        string    `json:"id"`
+	OwnerId   int       `json:"owner_id"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Chunks    []string  `json:"-"`
+	AltText   string    `json:"alt_text"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the upload can no longer be resumed at the given
+// time.
+func (upload Upload) Expired(at time.Time) bool {
+	return !at.Before(upload.ExpiresAt)
+}
+
+func (db *DB) CreateUpload(upload Upload) (Upload, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Upload{}, err
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return Upload{}, err
+	}
+	upload.Id = id
+	upload.Offset = 0
+	upload.Chunks = nil
+	upload.CreatedAt = time.Now().UTC()
+	upload.ExpiresAt = upload.ExpiresAt.UTC()
+	dbStruct.Uploads[upload.Id] = upload
+	if err := db.writeDB(dbStruct); err != nil {
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (db *DB) GetUpload(id string) (Upload, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Upload{}, err
+	}
+	upload, found := dbStruct.Uploads[id]
+	if !found {
+		return Upload{}, notFound(ErrUploadDoesNotExist, id)
+	}
+	return upload, nil
+}
+
+// AppendUploadChunk records that the blob under key holds the size bytes
+// of the upload following offset. It fails with ErrUploadOffsetMismatch
+// when offset is not where the upload stands, as when another request
+// appended first.
+func (db *DB) AppendUploadChunk(id string, offset int64, key string, size int64) (Upload, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Upload{}, err
+	}
+	upload, found := dbStruct.Uploads[id]
+	if !found {
+		return Upload{}, notFound(ErrUploadDoesNotExist, id)
+	}
+	if upload.Offset != offset {
+		return Upload{}, ErrUploadOffsetMismatch
+	}
+	upload.Chunks = append(upload.Chunks[:len(upload.Chunks):len(upload.Chunks)], key)
+	upload.Offset += size
+	dbStruct.Uploads[id] = upload
+	if err := db.writeDB(dbStruct); err != nil {
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (db *DB) DeleteUpload(id string) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Uploads[id]; !found {
+		return notFound(ErrUploadDoesNotExist, id)
+	}
+	delete(dbStruct.Uploads, id)
+	return db.writeDB(dbStruct)
+}
+
+// GetExpiredUploads returns the uploads expired at the given time, whose
+// chunks are left for the caller to delete.
+func (db *DB) GetExpiredUploads(at time.Time) ([]Upload, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	var uploads []Upload
+	for _, upload := range dbStruct.Uploads {
+		if upload.Expired(at) {
+			uploads = append(uploads, upload)
+		}
+	}
+	return uploads, nil
+}
+
+const uploadColumns = `id, owner_id, length, upload_offset, chunks, alt_text, created_at, expires_at`
+
+func scanUpload(row scanner) (Upload, error) {
+	upload := Upload{}
+	var chunks string
+	err := row.Scan(&upload.Id, &upload.OwnerId, &upload.Length, &upload.Offset, &chunks,
+		&upload.AltText, &upload.CreatedAt, &upload.ExpiresAt)
+	upload.Chunks = strings.Fields(chunks)
+	return upload, err
+}
+
+func (db *SQLDB) CreateUpload(upload Upload) (Upload, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return Upload{}, err
+	}
+	upload.Id = id
+	upload.Offset = 0
+	upload.Chunks = nil
+	upload.CreatedAt = time.Now().UTC()
+	upload.ExpiresAt = upload.ExpiresAt.UTC()
+	_, err = db.exec(`INSERT INTO uploads (`+uploadColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		upload.Id, upload.OwnerId, upload.Length, upload.Offset, "", upload.AltText, upload.CreatedAt, upload.ExpiresAt)
+	if err != nil {
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (db *SQLDB) GetUpload(id string) (Upload, error) {
+	upload, err := scanUpload(db.queryRow(`SELECT `+uploadColumns+` FROM uploads WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, notFound(ErrUploadDoesNotExist, id)
+	}
+	return upload, err
+}
+
+func (db *SQLDB) AppendUploadChunk(id string, offset int64, key string, size int64) (Upload, error) {
+	result, err := db.exec(`UPDATE uploads SET upload_offset = upload_offset + ?, chunks = TRIM(chunks || ' ' || ?)
+		WHERE id = ? AND upload_offset = ?`, size, key, id, offset)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := requireRow(result, ErrUploadOffsetMismatch); err != nil {
+		if _, getErr := db.GetUpload(id); getErr != nil {
+			return Upload{}, getErr
+		}
+		return Upload{}, err
+	}
+	return db.GetUpload(id)
+}
+
+func (db *SQLDB) DeleteUpload(id string) error {
+	result, err := db.exec(`DELETE FROM uploads WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrUploadDoesNotExist, id))
+}
+
+func (db *SQLDB) GetExpiredUploads(at time.Time) ([]Upload, error) {
+	rows, err := db.query(`SELECT `+uploadColumns+` FROM uploads WHERE expires_at <= ?`, at.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uploads []Upload
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"time"
+)
+
+// UserExport is everything stored about one user, assembled for them to
+// take away. Deleted chirps still waiting to be purged are included, with
+// DeletedAt set.
+type UserExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Profile    User      `json:"profile"`
+	Chirps     []Chirp   `json:"chirps"`
+	Likes      []Like    `json:"likes"`
+	Following  []Follow  `json:"following"`
+	Followers  []Follow  `json:"followers"`
+}
+
+func (db *DB) ExportUser(id int) (UserExport, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return UserExport{}, err
+	}
+	user, found := dbStruct.Users[id]
+	if !found {
+		return UserExport{}, notFound(ErrUserDoesNotExist, id)
+	}
+	user.Password = nil
+	export := UserExport{
+		ExportedAt: time.Now().UTC(),
+		Profile:    user,
+		Chirps:     []Chirp{},
+		Likes:      []Like{},
+		Following:  []Follow{},
+		Followers:  []Follow{},
+	}
+	for _, chirpId := range sortedKeys(dbStruct.Chirps) {
+		if chirp := dbStruct.Chirps[chirpId]; chirp.AuthorId == id {
+			export.Chirps = append(export.Chirps, chirp)
+		}
+	}
+	liked := dbStruct.Likes[id]
+	for _, chirpId := range sortedKeys(liked) {
+		export.Likes = append(export.Likes, Like{UserId: id, ChirpId: chirpId, CreatedAt: liked[chirpId]})
+	}
+	followees := dbStruct.Follows[id]
+	for _, followeeId := range sortedKeys(followees) {
+		export.Following = append(export.Following, Follow{FollowerId: id, FolloweeId: followeeId, CreatedAt: followees[followeeId]})
+	}
+	for _, followerId := range sortedKeys(dbStruct.Follows) {
+		if followedAt, ok := dbStruct.Follows[followerId][id]; ok {
+			export.Followers = append(export.Followers, Follow{FollowerId: followerId, FolloweeId: id, CreatedAt: followedAt})
+		}
+	}
+	return export, nil
+}
+
+func (db *SQLDB) ExportUser(id int) (UserExport, error) {
+	user, err := db.GetUserById(id)
+	if err != nil {
+		return UserExport{}, err
+	}
+	user.Password = nil
+	export := UserExport{ExportedAt: time.Now().UTC(), Profile: user}
+	export.Chirps, err = db.queryChirps(`SELECT `+chirpColumns+` FROM chirps WHERE author_id = ? ORDER BY chirps.id`, id)
+	if err != nil {
+		return UserExport{}, err
+	}
+	export.Likes, err = queryRows(db, `SELECT user_id, chirp_id, created_at FROM likes WHERE user_id = ? ORDER BY chirp_id`, func(row scanner) (Like, error) {
+		like := Like{}
+		err := row.Scan(&like.UserId, &like.ChirpId, &like.CreatedAt)
+		return like, err
+	}, id)
+	if err != nil {
+		return UserExport{}, err
+	}
+	export.Following, err = queryRows(db, `SELECT follower_id, followee_id, created_at FROM follows WHERE follower_id = ? ORDER BY followee_id`, scanFollow, id)
+	if err != nil {
+		return UserExport{}, err
+	}
+	export.Followers, err = queryRows(db, `SELECT follower_id, followee_id, created_at FROM follows WHERE followee_id = ? ORDER BY follower_id`, scanFollow, id)
+	if err != nil {
+		return UserExport{}, err
+	}
+	return export, nil
+}
+
+func scanFollow(row scanner) (Follow, error) {
+	follow := Follow{}
+	err := row.Scan(&follow.FollowerId, &follow.FolloweeId, &follow.CreatedAt)
+	return follow, err
+}
+
+// queryRows runs query and scans every row it returns.
+func queryRows[T any](db *SQLDB, query string, scan func(scanner) (T, error), args ...any) ([]T, error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]T, 0)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// VerificationToken proves that whoever holds it can read the user's email.
+// Only its hash is stored.
+type VerificationToken struct {
+	UserId    int
+	ExpiresAt time.Time
+}
+
+// CreateVerificationToken issues a new token for the user, replacing any
+// token sent earlier.
+func (db *DB) CreateVerificationToken(userId int, ttl time.Duration) (string, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return "", err
+	}
+	user, found := dbStruct.Users[userId]
+	if !found {
+		return "", notFound(ErrUserDoesNotExist, userId)
+	}
+	if user.Verified {
+		return "", ErrAlreadyVerified
+	}
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	for hash, pending := range dbStruct.VerificationTokens {
+		if pending.UserId == userId || !now.Before(pending.ExpiresAt) {
+			delete(dbStruct.VerificationTokens, hash)
+		}
+	}
+	dbStruct.VerificationTokens[tokenHash] = VerificationToken{UserId: userId, ExpiresAt: now.Add(ttl)}
+	if err := db.writeDB(dbStruct); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyUser marks the owner of token verified and uses the token up.
+func (db *DB) VerifyUser(token string) (User, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return User{}, err
+	}
+	tokenHash := hashToken(token)
+	pending, found := dbStruct.VerificationTokens[tokenHash]
+	if !found || !time.Now().Before(pending.ExpiresAt) {
+		return User{}, ErrInvalidVerification
+	}
+	user, found := dbStruct.Users[pending.UserId]
+	if !found {
+		return User{}, ErrInvalidVerification
+	}
+	user.Verified = true
+	dbStruct.Users[user.Id] = user
+	delete(dbStruct.VerificationTokens, tokenHash)
+	if err := db.writeDB(dbStruct); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (db *SQLDB) CreateVerificationToken(userId int, ttl time.Duration) (string, error) {
+	user, err := db.GetUserById(userId)
+	if err != nil {
+		return "", err
+	}
+	if user.Verified {
+		return "", ErrAlreadyVerified
+	}
+	token, tokenHash, err := newSessionToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	_, err = db.exec(`DELETE FROM verification_tokens WHERE user_id = ? OR expires_at <= ?`, userId, now)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.exec(`INSERT INTO verification_tokens (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		tokenHash, userId, now.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (db *SQLDB) VerifyUser(token string) (User, error) {
+	tokenHash := hashToken(token)
+	var userId int
+	err := db.queryRow(`DELETE FROM verification_tokens WHERE token_hash = ? AND expires_at > ? RETURNING user_id`,
+		tokenHash, time.Now().UTC()).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrInvalidVerification
+	}
+	if err != nil {
+		return User{}, err
+	}
+	result, err := db.exec(`UPDATE users SET verified = ? WHERE id = ?`, true, userId)
+	if err != nil {
+		return User{}, err
+	}
+	if err := requireRow(result, ErrInvalidVerification); err != nil {
+		return User{}, err
+	}
+	return db.GetUserById(userId)
+}
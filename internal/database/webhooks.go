@@ -0,0 +1,140 @@
+package database
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// Webhook is a URL the instance posts the events it subscribes to. Its
+// secret signs every delivery and is only shown when it is registered.
+type Webhook struct {
+	Id        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook wants event.
+func (hook Webhook) Subscribes(event string) bool {
+	return slices.Contains(hook.Events, event)
+}
+
+func newWebhook(url string, events []string) (Webhook, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return Webhook{URL: url, Events: events, Secret: secret, CreatedAt: time.Now().UTC()}, nil
+}
+
+// CreateWebhook registers url for events with a new secret.
+func (db *DB) CreateWebhook(url string, events []string) (Webhook, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Webhook{}, err
+	}
+	hook, err := newWebhook(url, events)
+	if err != nil {
+		return Webhook{}, err
+	}
+	dbStruct.NextWebhookId = max(dbStruct.NextWebhookId, 1)
+	hook.Id = dbStruct.NextWebhookId
+	dbStruct.NextWebhookId++
+	dbStruct.Webhooks[hook.Id] = hook
+	if err := db.writeDB(dbStruct); err != nil {
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+func (db *DB) GetWebhook(id int) (Webhook, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return Webhook{}, err
+	}
+	hook, found := dbStruct.Webhooks[id]
+	if !found {
+		return Webhook{}, notFound(ErrWebhookDoesNotExist, id)
+	}
+	return hook, nil
+}
+
+// GetWebhooks returns every webhook, oldest first.
+func (db *DB) GetWebhooks() ([]Webhook, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]Webhook, 0, len(dbStruct.Webhooks))
+	for _, hook := range dbStruct.Webhooks {
+		hooks = append(hooks, hook)
+	}
+	slices.SortFunc(hooks, func(a, b Webhook) int { return cmp.Compare(a.Id, b.Id) })
+	return hooks, nil
+}
+
+func (db *DB) DeleteWebhook(id int) error {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	if _, found := dbStruct.Webhooks[id]; !found {
+		return notFound(ErrWebhookDoesNotExist, id)
+	}
+	delete(dbStruct.Webhooks, id)
+	return db.writeDB(dbStruct)
+}
+
+const webhookColumns = `id, url, events, secret, created_at`
+
+func scanWebhook(row scanner) (Webhook, error) {
+	hook := Webhook{}
+	var events string
+	if err := row.Scan(&hook.Id, &hook.URL, &events, &hook.Secret, &hook.CreatedAt); err != nil {
+		return Webhook{}, err
+	}
+	return hook, json.Unmarshal([]byte(events), &hook.Events)
+}
+
+func (db *SQLDB) CreateWebhook(url string, events []string) (Webhook, error) {
+	hook, err := newWebhook(url, events)
+	if err != nil {
+		return Webhook{}, err
+	}
+	data, err := json.Marshal(hook.Events)
+	if err != nil {
+		return Webhook{}, err
+	}
+	err = db.queryRow(`INSERT INTO webhooks (url, events, secret, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		hook.URL, string(data), hook.Secret, hook.CreatedAt).Scan(&hook.Id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	return hook, nil
+}
+
+func (db *SQLDB) GetWebhook(id int) (Webhook, error) {
+	hooks, err := queryRows(db, `SELECT `+webhookColumns+` FROM webhooks WHERE id = ?`, scanWebhook, id)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if len(hooks) == 0 {
+		return Webhook{}, notFound(ErrWebhookDoesNotExist, id)
+	}
+	return hooks[0], nil
+}
+
+func (db *SQLDB) GetWebhooks() ([]Webhook, error) {
+	return queryRows(db, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`, scanWebhook)
+}
+
+func (db *SQLDB) DeleteWebhook(id int) error {
+	result, err := db.exec(`DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(result, notFound(ErrWebhookDoesNotExist, id))
+}
@@ -0,0 +1,99 @@
+package database
+
+// WipeStats counts what Wipe removed.
+type WipeStats struct {
+	Users    int `json:"users"`
+	Chirps   int `json:"chirps"` // deleted ones included
+	Sessions int `json:"sessions"`
+	APIKeys  int `json:"api_keys"`
+	Media    int `json:"media"`
+	Jobs     int `json:"jobs"`
+}
+
+// wipedTables lists every table Wipe empties: all of them but the
+// profanity filter's blocked words, which are configuration, and the daily
+// counters.
+var wipedTables = []string{
+	"users", "chirps", "chirp_tags", "likes", "bookmarks", "follows", "feed_markers",
+	"reports", "moderation_actions", "appeals", "sessions", "api_keys",
+	"verification_tokens", "device_authorizations", "login_throttles", "security_events",
+	"links", "media", "media_blobs", "uploads", "emoji", "jobs",
+	"scheduled_chirps", "drafts", "polls", "poll_votes", "webhooks", "idempotency_keys",
+}
+
+// Wipe empties the database as if it had just been created, keeping only
+// the blocked words and the daily counters. Blobs in the blob store are
+// left where they are.
+func (db *DB) Wipe() (WipeStats, error) {
+	dbStruct, err := db.loadDB()
+	if err != nil {
+		return WipeStats{}, err
+	}
+	stats := WipeStats{
+		Users:    len(dbStruct.Users),
+		Chirps:   len(dbStruct.Chirps),
+		Sessions: len(dbStruct.Sessions),
+		APIKeys:  len(dbStruct.APIKeys),
+		Media:    len(dbStruct.Media),
+		Jobs:     len(dbStruct.Jobs),
+	}
+	wiped := DBStructure{
+		NextChirpId:        1,
+		NextUserId:         1,
+		Upgrades:           dbStruct.Upgrades,
+		VerifiedBackfilled: dbStruct.VerifiedBackfilled,
+		BlockedWords:       dbStruct.BlockedWords,
+		Counters:           dbStruct.Counters,
+	}
+	wiped.initMaps()
+	if db.journal != nil {
+		// Everything journaled so far predates the wipe and must not be
+		// replayed over it.
+		db.mux.RLock()
+		wiped.JournalSeq = db.journal.seq
+		db.mux.RUnlock()
+	}
+	if err := db.writeDB(wiped); err != nil {
+		return WipeStats{}, err
+	}
+	return stats, nil
+}
+
+func (db *SQLDB) Wipe() (WipeStats, error) {
+	stats := WipeStats{}
+	counted := map[string]*int{
+		"users":    &stats.Users,
+		"chirps":   &stats.Chirps,
+		"sessions": &stats.Sessions,
+		"api_keys": &stats.APIKeys,
+		"media":    &stats.Media,
+		"jobs":     &stats.Jobs,
+	}
+	err := db.WriteBatch(func(store Storage) error {
+		tx := store.(*SQLDB)
+		for _, table := range wipedTables {
+			result, err := tx.exec(`DELETE FROM ` + table)
+			if err != nil {
+				return err
+			}
+			if count, found := counted[table]; found {
+				rows, err := result.RowsAffected()
+				if err != nil {
+					return err
+				}
+				*count = int(rows)
+			}
+		}
+		if tx.dialect.driver == sqliteDialect.driver {
+			// New rows are numbered from 1 again, as in a new database.
+			if _, err := tx.exec(`DELETE FROM sqlite_sequence`); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return WipeStats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,170 @@
+// Package richtext finds links, mentions, and hashtags in chirp bodies and
+// renders bodies as safe HTML, so that clients do not each have to parse
+// chirps their own way.
+package richtext
+
+import (
+	"html/template"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	TypeURL     = "url"
+	TypeMention = "mention"
+	TypeHashtag = "hashtag"
+	TypeEmoji   = "emoji"
+)
+
+// Entity is a span of a chirp body with special meaning. Start and End are
+// byte offsets into the body, RuneStart and RuneEnd the same span counted in
+// runes; both ends are exclusive. Value is the URL, or the mention, hashtag,
+// or emoji shortcode without its colons or leading sigil. URL is only set on
+// emoji, once the server has matched them to an image.
+type Entity struct {
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	Value     string `json:"value"`
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	RuneStart int    `json:"rune_start"`
+	RuneEnd   int    `json:"rune_end"`
+	URL       string `json:"url,omitempty"`
+}
+
+var (
+	urlPattern     = regexp.MustCompile(`https?://[^\s<>"]+`)
+	mentionPattern = regexp.MustCompile(`@[A-Za-z0-9_]{1,30}`)
+	hashtagPattern = regexp.MustCompile(`#[\p{L}\p{N}_]+`)
+	emojiPattern   = regexp.MustCompile(`:` + shortcode + `:`)
+	shortcodeOnly  = regexp.MustCompile(`^` + shortcode + `$`)
+)
+
+// shortcode is what may appear between the colons of an emoji.
+const shortcode = `[A-Za-z][A-Za-z0-9_]{1,31}`
+
+// ValidShortcode reports whether s, without colons, can name an emoji.
+func ValidShortcode(s string) bool {
+	return shortcodeOnly.MatchString(s)
+}
+
+// Extract returns the entities in body ordered by position. Mentions,
+// hashtags, and emoji inside URLs are not reported. Every well-formed
+// :shortcode: is reported as an emoji, whether or not the instance has it.
+func Extract(body string) []Entity {
+	entities := make([]Entity, 0)
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		start, end := loc[0], trimURL(body, loc[0], loc[1])
+		entities = append(entities, Entity{Type: TypeURL, Text: body[start:end], Value: body[start:end], Start: start, End: end})
+	}
+	urls := slices.Clone(entities)
+	insideURL := func(start int) bool {
+		for _, url := range urls {
+			if start >= url.Start && start < url.End {
+				return true
+			}
+		}
+		return false
+	}
+	for _, sigil := range []struct {
+		kind    string
+		pattern *regexp.Regexp
+	}{{TypeMention, mentionPattern}, {TypeHashtag, hashtagPattern}, {TypeEmoji, emojiPattern}} {
+		for _, loc := range sigil.pattern.FindAllStringIndex(body, -1) {
+			start, end := loc[0], loc[1]
+			if insideURL(start) || !atWordBoundary(body, start) {
+				continue
+			}
+			value := body[start+1 : end]
+			if sigil.kind == TypeEmoji {
+				value = body[start+1 : end-1]
+			}
+			entities = append(entities, Entity{Type: sigil.kind, Text: body[start:end], Value: value, Start: start, End: end})
+		}
+	}
+	slices.SortFunc(entities, func(a, b Entity) int {
+		return a.Start - b.Start
+	})
+	for i := range entities {
+		entities[i].RuneStart = utf8.RuneCountInString(body[:entities[i].Start])
+		entities[i].RuneEnd = entities[i].RuneStart + utf8.RuneCountInString(entities[i].Text)
+	}
+	return entities
+}
+
+// Tags returns the distinct hashtags among entities, lowercased, in the
+// order they first appear.
+func Tags(entities []Entity) []string {
+	tags := []string{}
+	for _, entity := range entities {
+		if entity.Type != TypeHashtag {
+			continue
+		}
+		if tag := strings.ToLower(entity.Value); !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// trimURL drops trailing punctuation that more likely ends the sentence than the URL.
+func trimURL(body string, start, end int) int {
+	for end > start && strings.ContainsRune(".,!?;:'\")]", rune(body[end-1])) {
+		end--
+	}
+	return end
+}
+
+// atWordBoundary reports whether the sigil at start is not glued to a preceding word,
+// so that e-mail addresses and the like are not mistaken for mentions.
+func atWordBoundary(body string, start int) bool {
+	if start == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(body[:start])
+	return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// DefaultTemplates renders each entity as a link. Each template receives the Entity.
+const DefaultTemplates = `
+{{define "url"}}<a href="{{.Value}}" rel="nofollow noopener" target="_blank">{{.Text}}</a>{{end}}
+{{define "mention"}}<a href="/app/users/{{.Value}}" class="mention">{{.Text}}</a>{{end}}
+{{define "hashtag"}}<a href="/app/tags/{{.Value}}" class="hashtag">{{.Text}}</a>{{end}}
+{{define "emoji"}}<img src="{{.URL}}" alt="{{.Text}}" title="{{.Text}}" class="emoji">{{end}}
+`
+
+// Renderer turns chirp bodies into HTML using one template per entity type.
+// Text outside entities is escaped.
+type Renderer struct {
+	templates *template.Template
+}
+
+// NewRenderer parses templates, which must define "url", "mention", and
+// "hashtag". Entity types without a template, such as "emoji" in templates
+// written before emoji existed, render as plain text.
+func NewRenderer(templates string) (*Renderer, error) {
+	t, err := template.New("entities").Parse(templates)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{templates: t}, nil
+}
+
+func (r *Renderer) Render(body string, entities []Entity) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, entity := range entities {
+		b.WriteString(template.HTMLEscapeString(body[last:entity.Start]))
+		if r.templates.Lookup(entity.Type) == nil {
+			b.WriteString(template.HTMLEscapeString(entity.Text))
+		} else if err := r.templates.ExecuteTemplate(&b, entity.Type, entity); err != nil {
+			return "", err
+		}
+		last = entity.End
+	}
+	b.WriteString(template.HTMLEscapeString(body[last:]))
+	return b.String(), nil
+}
@@ -0,0 +1,72 @@
+package richtext
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test(t *testing.T) {
+	runExtractTest(t, "no entities here", nil)
+	runExtractTest(t, "hi @boots, see https://boot.dev/#learn. #golang", []Entity{
+		{Type: TypeMention, Text: "@boots", Value: "boots", Start: 3, End: 9, RuneStart: 3, RuneEnd: 9},
+		{Type: TypeURL, Text: "https://boot.dev/#learn", Value: "https://boot.dev/#learn", Start: 15, End: 38, RuneStart: 15, RuneEnd: 38},
+		{Type: TypeHashtag, Text: "#golang", Value: "golang", Start: 40, End: 47, RuneStart: 40, RuneEnd: 47},
+	})
+	runExtractTest(t, "mail me@example.com", nil)
+	runExtractTest(t, "héllo #café", []Entity{
+		{Type: TypeHashtag, Text: "#café", Value: "café", Start: 7, End: 13, RuneStart: 6, RuneEnd: 11},
+	})
+
+	runExtractTest(t, "so :blobcat: at 10:30:00, not a:bc: or https://a.io/:x_y:", []Entity{
+		{Type: TypeEmoji, Text: ":blobcat:", Value: "blobcat", Start: 3, End: 12, RuneStart: 3, RuneEnd: 12},
+		{Type: TypeURL, Text: "https://a.io/:x_y", Value: "https://a.io/:x_y", Start: 39, End: 56, RuneStart: 39, RuneEnd: 56},
+	})
+
+	renderer, err := NewRenderer(DefaultTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderTest(t, renderer, "<b>hi</b> #go", `&lt;b&gt;hi&lt;/b&gt; <a href="/app/tags/go" class="hashtag">#go</a>`)
+	runRenderTest(t, renderer, "@x https://a.io/?q=1&r=2", `<a href="/app/users/x" class="mention">@x</a> <a href="https://a.io/?q=1&amp;r=2" rel="nofollow noopener" target="_blank">https://a.io/?q=1&amp;r=2</a>`)
+
+	plain, err := NewRenderer(`{{define "url"}}{{.Text}}{{end}}{{define "mention"}}{{.Text}}{{end}}{{define "hashtag"}}{{.Text}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runRenderTest(t, plain, "<3 :blobcat:", "&lt;3 :blobcat:")
+
+	runTagsTest(t, "#Go and #go and #GoLang, not https://a.io/#go", []string{"go", "golang"})
+	runTagsTest(t, "no tags", []string{})
+}
+
+func runTagsTest(t *testing.T, body string, expecting []string) {
+	t.Logf("Starting test for Tags with: \"%s\", and expecting: %v", body, expecting)
+	got := Tags(Extract(body))
+	if !slices.Equal(got, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, got)
+	}
+}
+
+func runExtractTest(t *testing.T, body string, expecting []Entity) {
+	t.Logf("Starting test for Extract with: \"%s\", and expecting: %v", body, expecting)
+	got := Extract(body)
+	if len(got) != len(expecting) {
+		t.Fatalf("Expecting: %v, but got: %v", expecting, got)
+	}
+	for i := range got {
+		if got[i] != expecting[i] {
+			t.Errorf("Expecting: %v, but got: %v", expecting[i], got[i])
+		}
+	}
+}
+
+func runRenderTest(t *testing.T, renderer *Renderer, body, expecting string) {
+	t.Logf("Starting test for Render with: \"%s\", and expecting: \"%s\"", body, expecting)
+	got, err := renderer.Render(body, Extract(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, got)
+	}
+}
@@ -0,0 +1,210 @@
+// Package similarity groups near-duplicate texts, such as a wave of spam
+// chirps that each differ by a word or a link. Texts are compared by the
+// overlap of their word shingles, estimated with MinHash signatures, and
+// only texts that share a band of their signature are compared at all, so
+// clustering stays close to linear in the number of texts.
+package similarity
+
+import (
+	"cmp"
+	"encoding/binary"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Options tune clustering. Zero fields take the defaults below.
+type Options struct {
+	// ShingleSize is how many consecutive words make up a shingle.
+	ShingleSize int
+	// Bands and Rows shape the signature: Bands*Rows hash functions, split
+	// into Bands bands of Rows each. Texts become candidates when any band
+	// matches exactly.
+	Bands int
+	Rows  int
+	// Threshold is the estimated Jaccard similarity candidates need to be
+	// clustered together.
+	Threshold float64
+}
+
+const (
+	DefaultShingleSize = 3
+	DefaultBands       = 20
+	DefaultRows        = 5
+	DefaultThreshold   = 0.6
+)
+
+func (opts Options) withDefaults() Options {
+	if opts.ShingleSize <= 0 {
+		opts.ShingleSize = DefaultShingleSize
+	}
+	if opts.Bands <= 0 {
+		opts.Bands = DefaultBands
+	}
+	if opts.Rows <= 0 {
+		opts.Rows = DefaultRows
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultThreshold
+	}
+	return opts
+}
+
+// Doc is a text to cluster, identified by Id.
+type Doc struct {
+	Id   int
+	Text string
+}
+
+// Cluster groups docs whose texts are near duplicates of one another,
+// directly or through other docs in the group. Only groups of two or more
+// are returned, largest first, each with its ids in ascending order.
+// Docs without any words are never grouped.
+func Cluster(docs []Doc, opts Options) [][]int {
+	opts = opts.withDefaults()
+	signatures := make(map[int][]uint64, len(docs))
+	ids := make([]int, 0, len(docs))
+	for _, doc := range docs {
+		shingles := Shingles(doc.Text, opts.ShingleSize)
+		if len(shingles) == 0 {
+			continue
+		}
+		signatures[doc.Id] = signature(shingles, opts.Bands*opts.Rows)
+		ids = append(ids, doc.Id)
+	}
+
+	groups := newUnionFind(ids)
+	for band := 0; band < opts.Bands; band++ {
+		buckets := make(map[uint64][]int)
+		for _, id := range ids {
+			key := bandKey(signatures[id][band*opts.Rows : (band+1)*opts.Rows])
+			buckets[key] = append(buckets[key], id)
+		}
+		for _, bucket := range buckets {
+			for i := 1; i < len(bucket); i++ {
+				for j := 0; j < i; j++ {
+					a, b := bucket[j], bucket[i]
+					if groups.find(a) != groups.find(b) && Estimate(signatures[a], signatures[b]) >= opts.Threshold {
+						groups.union(a, b)
+					}
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for _, id := range ids {
+		root := groups.find(id)
+		members[root] = append(members[root], id)
+	}
+	clusters := make([][]int, 0)
+	for _, cluster := range members {
+		if len(cluster) > 1 {
+			slices.Sort(cluster)
+			clusters = append(clusters, cluster)
+		}
+	}
+	slices.SortFunc(clusters, func(a, b []int) int {
+		if len(a) != len(b) {
+			return cmp.Compare(len(b), len(a))
+		}
+		return cmp.Compare(a[0], b[0])
+	})
+	return clusters
+}
+
+// Shingles hashes every run of size consecutive words in text, ignoring
+// case and punctuation. A text shorter than size words is one shingle.
+func Shingles(text string, size int) []uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return nil
+	}
+	if len(words) < size {
+		size = len(words)
+	}
+	shingles := make([]uint64, 0, len(words)-size+1)
+	for i := 0; i+size <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+size], " ")))
+		shingles = append(shingles, h.Sum64())
+	}
+	return shingles
+}
+
+// signature is the MinHash signature of shingles under n hash functions.
+func signature(shingles []uint64, n int) []uint64 {
+	sig := make([]uint64, n)
+	for i := range sig {
+		seed := mix(uint64(i) + 1)
+		min := ^uint64(0)
+		for _, shingle := range shingles {
+			if h := mix(shingle ^ seed); h < min {
+				min = h
+			}
+		}
+		sig[i] = min
+	}
+	return sig
+}
+
+// Estimate returns the share of positions where two signatures agree,
+// which estimates the Jaccard similarity of the shingles behind them.
+func Estimate(a, b []uint64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+func bandKey(rows []uint64) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, row := range rows {
+		binary.LittleEndian.PutUint64(buf[:], row)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// mix is the SplitMix64 finalizer, which spreads similar inputs across the
+// whole range.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+type unionFind map[int]int
+
+func newUnionFind(ids []int) unionFind {
+	parents := make(unionFind, len(ids))
+	for _, id := range ids {
+		parents[id] = id
+	}
+	return parents
+}
+
+func (u unionFind) find(id int) int {
+	for u[id] != id {
+		u[id] = u[u[id]]
+		id = u[id]
+	}
+	return id
+}
+
+func (u unionFind) union(a, b int) {
+	u[u.find(a)] = u.find(b)
+}
@@ -0,0 +1,56 @@
+package similarity
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestCluster(t *testing.T) {
+	docs := []Doc{
+		{Id: 1, Text: "Win a free phone today, just click the link below and claim your prize now http://spam.example/a"},
+		{Id: 2, Text: "Good morning everyone, the coffee machine on the third floor is fixed"},
+		{Id: 3, Text: "WIN a FREE phone today! Just click the link below and claim your prize now http://spam.example/b"},
+		{Id: 4, Text: "Win a free phone today, just click the link below and claim your prize now!! http://spam.example/c"},
+		{Id: 5, Text: "Does anyone know a good recipe for banana bread without eggs"},
+		{Id: 6, Text: "!!!"},
+		{Id: 7, Text: "!!!"},
+	}
+	runClusterTest(t, docs, [][]int{{1, 3, 4}})
+	runClusterTest(t, docs[1:2], [][]int{})
+
+	var wave []Doc
+	for i := 0; i < 50; i++ {
+		wave = append(wave, Doc{Id: i + 1, Text: fmt.Sprintf("Cheap followers for your account, visit our shop today and use the code for a discount %d", i)})
+	}
+	wave = append(wave, Doc{Id: 100, Text: "Lovely weather for a walk along the river this afternoon"})
+	expecting := make([]int, 50)
+	for i := range expecting {
+		expecting[i] = i + 1
+	}
+	runClusterTest(t, wave, [][]int{expecting})
+}
+
+func runClusterTest(t *testing.T, docs []Doc, expecting [][]int) {
+	t.Logf("Starting test for Cluster with: %d docs, and expecting: %v", len(docs), expecting)
+	got := Cluster(docs, Options{})
+	if !slices.EqualFunc(got, expecting, slices.Equal[[]int]) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, got)
+	}
+}
+
+func TestShingles(t *testing.T) {
+	runShinglesTest(t, "one two three four", 3, 2)
+	runShinglesTest(t, "One, two!", 3, 1)
+	runShinglesTest(t, "...", 3, 0)
+	if a, b := Shingles("Hello, World", 2), Shingles("hello world", 2); !slices.Equal(a, b) {
+		t.Errorf("Expecting: case and punctuation ignored, but got: %v and %v", a, b)
+	}
+}
+
+func runShinglesTest(t *testing.T, text string, size, expecting int) {
+	t.Logf("Starting test for Shingles with: %q of %d, and expecting: %d shingles", text, size, expecting)
+	if got := len(Shingles(text, size)); got != expecting {
+		t.Errorf("Expecting: %d, but got: %d", expecting, got)
+	}
+}
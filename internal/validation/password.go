@@ -0,0 +1,125 @@
+// Package validation checks what users submit against the server's
+// policies, reporting every rule an input breaks at once so clients can
+// show them all rather than one per attempt.
+package validation
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules a password can break.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleCommon    = "common"
+	RuleLowercase = "lowercase"
+	RuleUppercase = "uppercase"
+	RuleDigit     = "digit"
+	RuleSymbol    = "symbol"
+)
+
+// MaxPasswordBytes is the longest password bcrypt can hash. Longer ones are
+// refused whatever the policy, rather than failing to hash.
+const MaxPasswordBytes = 72
+
+// Violation is a rule an input broke, with a message fit to show the user.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error lists the rules an input to Field broke.
+type Error struct {
+	Field      string
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		rules[i] = v.Rule
+	}
+	return fmt.Sprintf("%s breaks the rules: %s", e.Field, strings.Join(rules, ", "))
+}
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordPolicy is what passwords must satisfy. The zero policy accepts
+// any password bcrypt can hash.
+type PasswordPolicy struct {
+	MinLength int
+	// Blocklist holds refused passwords in lower case.
+	Blocklist map[string]bool
+	// RequireComplexity asks for a lowercase and an uppercase letter, a
+	// digit and a symbol.
+	RequireComplexity bool
+}
+
+// CommonPasswords returns a blocklist of the most often used passwords.
+func CommonPasswords() map[string]bool {
+	blocklist := make(map[string]bool)
+	// The embedded list is well formed, so reading it cannot fail.
+	AddToBlocklist(blocklist, strings.NewReader(commonPasswords))
+	return blocklist
+}
+
+// AddToBlocklist adds the passwords read from r, one per line, to
+// blocklist. Blank lines and lines starting with # are skipped.
+func AddToBlocklist(blocklist map[string]bool, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = true
+	}
+	return scanner.Err()
+}
+
+// Check returns an *Error listing the rules password breaks, or nil when it
+// satisfies the policy.
+func (p PasswordPolicy) Check(password string) error {
+	var violations []Violation
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{RuleMinLength, fmt.Sprintf("must be at least %d characters long", p.MinLength)})
+	}
+	if len(password) > MaxPasswordBytes {
+		violations = append(violations, Violation{RuleMaxLength, fmt.Sprintf("must be at most %d bytes long", MaxPasswordBytes)})
+	}
+	if p.Blocklist[strings.ToLower(password)] {
+		violations = append(violations, Violation{RuleCommon, "is too common to be safe"})
+	}
+	if p.RequireComplexity {
+		classes := []struct {
+			rule    string
+			message string
+			in      func(rune) bool
+		}{
+			{RuleLowercase, "must contain a lowercase letter", unicode.IsLower},
+			{RuleUppercase, "must contain an uppercase letter", unicode.IsUpper},
+			{RuleDigit, "must contain a digit", unicode.IsDigit},
+			{RuleSymbol, "must contain a symbol", isSymbol},
+		}
+		for _, class := range classes {
+			if !strings.ContainsFunc(password, class.in) {
+				violations = append(violations, Violation{class.rule, class.message})
+			}
+		}
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	return &Error{Field: "password", Violations: violations}
+}
+
+func isSymbol(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)
+}
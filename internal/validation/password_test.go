@@ -0,0 +1,52 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, Blocklist: CommonPasswords()}
+	runPasswordPolicyTest(t, PasswordPolicy{}, "", nil)
+	runPasswordPolicyTest(t, policy, "", []string{RuleMinLength})
+	runPasswordPolicyTest(t, policy, "hunter2", []string{RuleMinLength, RuleCommon})
+	runPasswordPolicyTest(t, policy, "Password123", []string{RuleCommon})
+	runPasswordPolicyTest(t, policy, "correct horse battery staple", nil)
+	runPasswordPolicyTest(t, policy, "héllo wörld", nil)
+	runPasswordPolicyTest(t, PasswordPolicy{}, strings.Repeat("a", MaxPasswordBytes+1), []string{RuleMaxLength})
+
+	policy.RequireComplexity = true
+	runPasswordPolicyTest(t, policy, "correct horse battery staple", []string{RuleUppercase, RuleDigit})
+	runPasswordPolicyTest(t, policy, "Correct-Horse-7", nil)
+	runPasswordPolicyTest(t, policy, "12345678901", []string{RuleLowercase, RuleUppercase, RuleSymbol})
+}
+
+func runPasswordPolicyTest(t *testing.T, policy PasswordPolicy, password string, expecting []string) {
+	t.Logf("Starting test for Check with: %q, and expecting: %v", password, expecting)
+	err := policy.Check(password)
+	var rules []string
+	var validationErr *Error
+	if errors.As(err, &validationErr) {
+		for _, v := range validationErr.Violations {
+			rules = append(rules, v.Rule)
+		}
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rules, expecting) {
+		t.Errorf("Expecting: %v, but got: %v", expecting, rules)
+	}
+}
+
+func TestAddToBlocklist(t *testing.T) {
+	blocklist := map[string]bool{}
+	t.Logf("Starting test for AddToBlocklist with: comments, blanks and mixed case, and expecting: only passwords, lowercased")
+	if err := AddToBlocklist(blocklist, strings.NewReader("# comment\n\n  Tr0ub4dor  \nchirpy\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blocklist, map[string]bool{"tr0ub4dor": true, "chirpy": true}) {
+		t.Errorf("Expecting: tr0ub4dor and chirpy, but got: %v", blocklist)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ipBanList holds client IPs that are refused service until their ban expires.
+type ipBanList struct {
+	mux  sync.RWMutex
+	bans map[string]time.Time
+}
+
+func newIPBanList() *ipBanList {
+	return &ipBanList{bans: make(map[string]time.Time)}
+}
+
+// Ban refuses ip for the given duration. A zero duration bans permanently.
+func (b *ipBanList) Ban(ip string, duration time.Duration) {
+	expiry := time.Time{}
+	if duration > 0 {
+		expiry = time.Now().Add(duration)
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.bans[ip] = expiry
+}
+
+func (b *ipBanList) IsBanned(ip string) bool {
+	b.mux.RLock()
+	expiry, found := b.bans[ip]
+	b.mux.RUnlock()
+	if !found {
+		return false
+	}
+	if expiry.IsZero() || time.Now().Before(expiry) {
+		return true
+	}
+	b.mux.Lock()
+	delete(b.bans, ip)
+	b.mux.Unlock()
+	return false
+}
+
+func (cfg *apiConfig) middlewareBan(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.bans.IsBanned(clientIP(r)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxJobRetryDelay caps the exponential backoff between attempts at a job.
+const maxJobRetryDelay = time.Hour
+
+// jobHandler runs one job. What it returns is saved as the job's result.
+type jobHandler func(ctx context.Context, payload json.RawMessage) (any, error)
+
+type jobKind struct {
+	attempts int
+	handler  jobHandler
+}
+
+// jobQueue runs the jobs persisted in the store. Work that must not be lost
+// when a request returns or the server restarts, like sending mail, is
+// enqueued here rather than done in a goroutine.
+//
+// A worker holds a lease on the job it runs and renews it while the handler
+// works. If the server crashes, the lease runs out and the job is claimed
+// again, by this server once it restarts or by any other sharing the store.
+type jobQueue struct {
+	mux        sync.RWMutex
+	kinds      map[string]jobKind
+	poll       time.Duration
+	retryDelay time.Duration
+	lease      time.Duration
+}
+
+func newJobQueue(poll, retryDelay, lease time.Duration) *jobQueue {
+	return &jobQueue{kinds: make(map[string]jobKind), poll: poll, retryDelay: retryDelay, lease: lease}
+}
+
+// handle registers the handler for jobs of kind, which is tried up to
+// attempts times before the job is dead.
+func (q *jobQueue) handle(kind string, attempts int, handler jobHandler) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	q.kinds[kind] = jobKind{attempts: attempts, handler: handler}
+}
+
+func (q *jobQueue) kind(name string) (jobKind, bool) {
+	q.mux.RLock()
+	defer q.mux.RUnlock()
+	kind, found := q.kinds[name]
+	return kind, found
+}
+
+// enqueueJob stores a job of kind for the workers to run at runAt, or as
+// soon as possible when runAt is zero. The job keeps the id of the request
+// in ctx, which its handler is run with.
+func (cfg *apiConfig) enqueueJob(ctx context.Context, kind string, payload any, runAt time.Time) (database.Job, error) {
+	registered, found := cfg.jobs.kind(kind)
+	if !found {
+		return database.Job{}, fmt.Errorf("no handler for %s jobs", kind)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return database.Job{}, err
+	}
+	// The job is stored even when the request that asked for it has gone,
+	// since what it follows up on is already done.
+	return cfg.db.EnqueueJob(database.Job{
+		Kind: kind, Payload: data, MaxAttempts: registered.attempts, RunAt: runAt, RequestId: requestId(ctx),
+	})
+}
+
+// jobWorker runs due jobs one at a time until ctx is done, polling the
+// store when there are none.
+func (cfg *apiConfig) jobWorker(ctx context.Context) error {
+	for {
+		job, found, err := cfg.store(ctx).ClaimJob(time.Now(), cfg.jobs.lease)
+		if err != nil {
+			return fmt.Errorf("claiming job: %w", err)
+		}
+		if found {
+			cfg.runJob(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.jobs.poll):
+		}
+	}
+}
+
+func (cfg *apiConfig) runJob(ctx context.Context, job database.Job) {
+	if job.Attempts > job.MaxAttempts {
+		logRequestf(job.RequestId, "Job %d (%s) lost its worker on its last attempt", job.Id, job.Kind)
+		if _, err := cfg.store(ctx).FailJob(job.Id, job.LastError, nil); err != nil {
+			logRequestf(job.RequestId, "Error recording failure of job %d: %s", job.Id, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(withRequestId(ctx, job.RequestId))
+	renewed := make(chan bool)
+	go func() {
+		renewed <- cfg.renewJobLease(ctx, cancel, job)
+	}()
+	result, err := cfg.callJobHandler(ctx, job)
+	cancel()
+	if !<-renewed {
+		logRequestf(job.RequestId, "Job %d (%s) lost its lease, leaving it to the worker that claimed it", job.Id, job.Kind)
+		return
+	}
+
+	// ctx is cancelled by now, so the outcome is recorded without it.
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(result)
+	}
+	if err == nil {
+		if err := cfg.db.CompleteJob(job.Id, data); err != nil {
+			logRequestf(job.RequestId, "Error completing job %d: %s", job.Id, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	if job.Attempts < job.MaxAttempts {
+		delay := min(cfg.jobs.retryDelay<<(job.Attempts-1), maxJobRetryDelay)
+		at := time.Now().Add(delay)
+		retryAt = &at
+		logRequestf(job.RequestId, "Job %d (%s) failed on attempt %d, retrying in %s: %s", job.Id, job.Kind, job.Attempts, delay, err)
+	} else {
+		logRequestf(job.RequestId, "Job %d (%s) failed on its last attempt: %s", job.Id, job.Kind, err)
+	}
+	if _, err := cfg.db.FailJob(job.Id, err.Error(), retryAt); err != nil {
+		logRequestf(job.RequestId, "Error recording failure of job %d: %s", job.Id, err)
+	}
+}
+
+// renewJobLease extends job's lease every third of the lease until ctx is
+// done. If the job was claimed again in the meantime it stops the handler
+// with cancel and reports false, so the stale result is dropped.
+func (cfg *apiConfig) renewJobLease(ctx context.Context, cancel context.CancelFunc, job database.Job) bool {
+	ticker := time.NewTicker(max(cfg.jobs.lease/3, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case <-ticker.C:
+		}
+		err := cfg.store(ctx).ExtendJobLease(job.Id, job.Attempts, time.Now().Add(cfg.jobs.lease))
+		if errors.Is(err, database.ErrJobLeaseLost) {
+			cancel()
+			return false
+		}
+		if err != nil {
+			logRequestf(job.RequestId, "Error renewing lease on job %d: %s", job.Id, err)
+		}
+	}
+}
+
+// callJobHandler turns a panic in the handler into an error, so one bad job
+// cannot take its worker down with it.
+func (cfg *apiConfig) callJobHandler(ctx context.Context, job database.Job) (result any, err error) {
+	kind, found := cfg.jobs.kind(job.Kind)
+	if !found {
+		return nil, fmt.Errorf("no handler for %s jobs", job.Kind)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return kind.handler(ctx, job.Payload)
+}
+
+// getJobsHandler lists jobs, optionally only those in the state parameter.
+func (cfg *apiConfig) getJobsHandler(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", database.JobQueued, database.JobRunning, database.JobDone, database.JobDead:
+	default:
+		respondValidationError(w, "state must be queued, running, done, or dead")
+		return
+	}
+	jobs, err := cfg.store(r.Context()).GetJobs(state)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	job, err := cfg.store(r.Context()).GetJob(id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// postRequeueJobHandler gives a dead job another full set of attempts.
+func (cfg *apiConfig) postRequeueJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	job, err := cfg.store(r.Context()).RequeueJob(id)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
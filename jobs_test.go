@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestJobs(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, jobs: newJobQueue(time.Millisecond, 0, time.Minute)}
+	calls := 0
+	cfg.jobs.handle("flaky", 3, func(ctx context.Context, payload json.RawMessage) (any, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("try again")
+		}
+		return map[string]string{"payload": string(payload), "request": requestId(ctx)}, nil
+	})
+	cfg.jobs.handle("broken", 2, func(ctx context.Context, payload json.RawMessage) (any, error) {
+		panic("boom")
+	})
+
+	flaky, err := cfg.enqueueJob(withRequestId(context.Background(), "req-1"), "flaky", 1, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken, err := cfg.enqueueJob(context.Background(), "broken", nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.enqueueJob(context.Background(), "unknown", nil, time.Time{}); err == nil {
+		t.Errorf("Expecting: an error for an unknown kind, but got: nil")
+	}
+	for {
+		job, found, err := db.ClaimJob(time.Now(), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			break
+		}
+		cfg.runJob(context.Background(), job)
+	}
+
+	runJobTest(t, db, flaky.Id, database.JobDone, 2, `{"payload":"1","request":"req-1"}`)
+	runJobTest(t, db, broken.Id, database.JobDead, 2, "")
+
+	cfg.jobs.handle("once", 1, func(ctx context.Context, payload json.RawMessage) (any, error) {
+		t.Errorf("Expecting: a job past its last attempt not to run, but it did")
+		return nil, nil
+	})
+	once, err := cfg.enqueueJob(context.Background(), "once", nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.ClaimJob(time.Now(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	job, found, err := db.ClaimJob(time.Now().Add(2*time.Minute), time.Minute)
+	if err != nil || !found {
+		t.Fatalf("Expecting: job %d reclaimed, but got: %v", once.Id, err)
+	}
+	cfg.runJob(context.Background(), job)
+	runJobTest(t, db, once.Id, database.JobDead, 2, "")
+}
+
+func runJobTest(t *testing.T, db database.Storage, id int, state string, attempts int, result string) {
+	t.Logf("Starting test for runJob with: job %d, and expecting: %s after %d attempts", id, state, attempts)
+	job, err := db.GetJob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != state || job.Attempts != attempts || string(job.Result) != result {
+		t.Errorf("Expecting: %s after %d attempts with result %s, but got: %+v", state, attempts, result, job)
+	}
+}
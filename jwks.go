@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/avearmin/chirpy/auth"
+)
+
+// newTokenIssuer signs tokens with the keys of the jwt_keys block, or with
+// the private key in conf.JWTKeyFile, and falls back to HS256 with
+// conf.JWTSecret when there is neither.
+func newTokenIssuer(conf config) (*auth.Issuer, error) {
+	if len(conf.JWTKeys) > 0 {
+		keys := make([]auth.Key, 0, len(conf.JWTKeys))
+		for _, key := range conf.JWTKeys {
+			k := auth.Key{Id: key.Id, Secret: []byte(key.Secret), RetireAfter: key.RetireAfter}
+			if key.KeyFile != "" {
+				pemData, err := os.ReadFile(key.KeyFile)
+				if err != nil {
+					return nil, err
+				}
+				k.PEM = pemData
+			}
+			keys = append(keys, k)
+		}
+		return auth.NewRotatingIssuer(keys)
+	}
+	if conf.JWTKeyFile == "" {
+		return auth.NewIssuer(conf.JWTSecret), nil
+	}
+	pemData, err := os.ReadFile(conf.JWTKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewKeyIssuer(pemData)
+}
+
+// getJWKSHandler publishes the public keys access tokens are signed with,
+// so other services can verify them. The set is empty in HS256 mode.
+func (cfg *apiConfig) getJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(cfg.tokens.JWKS())
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(200)
+	w.Write(data)
+}
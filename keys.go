@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+)
+
+// previousKeys splits a comma-separated list of retired encryption keys.
+func previousKeys(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// totpKeyStatus tells how far a rotation of TOTP_ENCRYPTION_KEY has got:
+// once no secret is left under a previous key, the previous keys can go.
+type totpKeyStatus struct {
+	Configured   bool `json:"configured"`
+	PreviousKeys int  `json:"previous_keys"`
+	Secrets      int  `json:"secrets"`
+	// OnPreviousKeys counts the secrets chirpyctl rotate-secret totp has
+	// yet to reseal under the current key.
+	OnPreviousKeys int `json:"on_previous_keys"`
+	// Unreadable counts the secrets no configured key opens.
+	Unreadable int `json:"unreadable"`
+}
+
+// getKeysHandler shows operators the keys the server signs and seals with,
+// without any of their secrets, so they can follow a rotation through.
+func (cfg *apiConfig) getKeysHandler(w http.ResponseWriter, r *http.Request) {
+	type returnVal struct {
+		JWT  []auth.KeyInfo `json:"jwt"`
+		TOTP totpKeyStatus  `json:"totp"`
+	}
+	totp := totpKeyStatus{Configured: cfg.totpBox != nil}
+	if cfg.totpBox != nil {
+		totp.PreviousKeys = cfg.totpBox.PreviousKeys()
+		users, err := cfg.store(r.Context()).GetUsers()
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		for _, user := range users {
+			if user.TOTPSecret == nil {
+				continue
+			}
+			totp.Secrets++
+			_, resealed, err := cfg.totpBox.Reseal(user.TOTPSecret)
+			switch {
+			case err != nil:
+				totp.Unreadable++
+			case resealed:
+				totp.OnPreviousKeys++
+			}
+		}
+	}
+	data, err := json.Marshal(returnVal{JWT: cfg.tokens.Keys(time.Now()), TOTP: totp})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(data)
+}
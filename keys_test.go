@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestGetKeys(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewRotatingIssuer([]auth.Key{
+		{Id: "new", Secret: []byte("new secret")},
+		{Secret: []byte("old secret"), RetireAfter: time.Now().Add(time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldBox, err := auth.NewSecretBox("old key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := auth.NewSecretBox("new key", "old key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sealer := range []*auth.SecretBox{oldBox, box} {
+		user, err := db.CreateUser(string(rune('a'+i))+"@example.com", "hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := sealer.Seal([]byte("a TOTP secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetTOTPSecret(user.Id, sealed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &apiConfig{db: db, tokens: tokens, totpBox: box}
+
+	t.Logf("Starting test for getKeysHandler with: a key being retired and a secret under the previous TOTP key, and expecting: both reported")
+	w := httptest.NewRecorder()
+	cfg.getKeysHandler(w, httptest.NewRequest("GET", "/admin/keys", nil))
+	if w.Code != 200 {
+		t.Fatalf("Expecting: 200, but got: %d", w.Code)
+	}
+	var status struct {
+		JWT  []auth.KeyInfo `json:"jwt"`
+		TOTP totpKeyStatus  `json:"totp"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.JWT) != 2 || status.JWT[0].Id != "new" || !status.JWT[0].Signing || status.JWT[1].RetireAfter == nil || status.JWT[1].Retired {
+		t.Errorf("Expecting: key new signing and a key retiring, but got: %+v", status.JWT)
+	}
+	expecting := totpKeyStatus{Configured: true, PreviousKeys: 1, Secrets: 2, OnPreviousKeys: 1}
+	if status.TOTP != expecting {
+		t.Errorf("Expecting: %+v, but got: %+v", expecting, status.TOTP)
+	}
+	for _, secret := range []string{"new secret", "old secret", "new key"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("Expecting: no secrets, but got: %s", w.Body.String())
+		}
+	}
+}
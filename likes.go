@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (cfg *apiConfig) postChirpLikeHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpLike(w, r, func(chirpId, userId int) error {
+		if err := cfg.store(r.Context()).LikeChirp(chirpId, userId); err != nil {
+			return err
+		}
+		cfg.analytics.track(eventLike, userId)
+		return nil
+	})
+}
+
+func (cfg *apiConfig) deleteChirpLikeHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setChirpLike(w, r, cfg.store(r.Context()).UnlikeChirp)
+}
+
+func (cfg *apiConfig) setChirpLike(w http.ResponseWriter, r *http.Request, update func(chirpId, userId int) error) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	chirpId, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	err = update(chirpId, userId)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getUserLikesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	chirps, err := cfg.store(r.Context()).GetLikedChirps(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	resp, err := cfg.renderChirps(r.Context(), chirps)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/avearmin/chirpy/internal/richtext"
+	"github.com/go-chi/chi/v5"
+)
+
+// shortenLinks gives every URL in chirp a short link, when link tracking is
+// on. A failure only costs the chirp its short links, so it is logged rather
+// than failing the request.
+func (cfg *apiConfig) shortenLinks(ctx context.Context, chirp database.Chirp) {
+	if !cfg.linkTracking {
+		return
+	}
+	entities := chirp.Entities
+	if entities == nil {
+		entities = richtext.Extract(chirp.Body)
+	}
+	urls := make([]string, 0)
+	for _, entity := range entities {
+		if entity.Type == richtext.TypeURL {
+			urls = append(urls, entity.Value)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+	if _, err := cfg.store(ctx).CreateLinks(chirp.Id, urls); err != nil {
+		log.Printf("Error creating short links for chirp %d: %s", chirp.Id, err)
+	}
+}
+
+// linkHandler redirects a short link to its URL.
+func (cfg *apiConfig) linkHandler(w http.ResponseWriter, r *http.Request) {
+	link, err := cfg.store(r.Context()).FollowLink(chi.URLParam(r, "code"), cfg.linkTracking)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// getChirpAnalyticsHandler shows a chirp's author how it is doing.
+func (cfg *apiConfig) getChirpAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	chirpId, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	chirp, found, err := cfg.store(r.Context()).GetChirp(chirpId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	if chirp.AuthorId != userId {
+		w.WriteHeader(403)
+		return
+	}
+	links, err := cfg.store(r.Context()).GetLinks([]int{chirp.Id})
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	type returnVal struct {
+		ChirpId   int             `json:"chirp_id"`
+		LikeCount int             `json:"like_count"`
+		Clicks    int             `json:"clicks"`
+		Links     []database.Link `json:"links"`
+	}
+	resp := returnVal{
+		ChirpId:   chirp.Id,
+		LikeCount: chirp.LikeCount,
+		Links:     make([]database.Link, 0),
+	}
+	for _, link := range links[chirp.Id] {
+		resp.Clicks += link.Clicks
+		resp.Links = append(resp.Links, link)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// listingLimits make bulk scraping of the chirp listing harder without
+// getting in the way of signed-in users. A zero disables either limit.
+type listingLimits struct {
+	// anonPageSize is the most chirps a page holds for anonymous clients,
+	// whatever limit they ask for.
+	anonPageSize int
+	// anonMaxPage is the last page anonymous clients may fetch; past it
+	// they have to sign in.
+	anonMaxPage int
+}
+
+// listingPage reads the page and limit query parameters, capping them for
+// anonymous clients. A limit of zero means everything on one page. It
+// answers the request itself and returns false when the parameters are
+// malformed or the client has to sign in to see the page.
+func (cfg *apiConfig) listingPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	page, limit := 1, 0
+	var err error
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			respondValidationError(w, "page must be a number from 1")
+			return 0, 0, false
+		}
+	}
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			respondValidationError(w, "limit must be a number from 1")
+			return 0, 0, false
+		}
+	}
+	if _, ok := cfg.requestUserId(r); ok {
+		return page, limit, true
+	}
+	if cfg.listing.anonMaxPage > 0 && page > cfg.listing.anonMaxPage {
+		respondSignInRequired(w, "sign in to see more than "+strconv.Itoa(cfg.listing.anonMaxPage)+" pages")
+		return 0, 0, false
+	}
+	if cfg.listing.anonPageSize > 0 && (limit == 0 || limit > cfg.listing.anonPageSize) {
+		limit = cfg.listing.anonPageSize
+	}
+	return page, limit, true
+}
+
+// paginate returns the given page of items, limit to a page, and whether
+// there are more after it.
+func paginate[T any](items []T, page, limit int) ([]T, bool) {
+	if limit == 0 {
+		return items, false
+	}
+	start := min((page-1)*limit, len(items))
+	end := min(start+limit, len(items))
+	return items[start:end], end < len(items)
+}
+
+// setNextPageLink points the client at the page after the one it asked for
+// with a Link header, so the listing itself stays a plain array.
+func setNextPageLink(w http.ResponseWriter, r *http.Request, page, limit int) {
+	next := *r.URL
+	query := next.Query()
+	query.Set("page", strconv.Itoa(page+1))
+	query.Set("limit", strconv.Itoa(limit))
+	next.RawQuery = query.Encode()
+	w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+}
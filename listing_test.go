@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/avearmin/chirpy/auth"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	runPaginateTest(t, items, 1, 0, []int{1, 2, 3, 4, 5}, false)
+	runPaginateTest(t, items, 1, 2, []int{1, 2}, true)
+	runPaginateTest(t, items, 3, 2, []int{5}, false)
+	runPaginateTest(t, items, 4, 2, []int{}, false)
+}
+
+func runPaginateTest(t *testing.T, items []int, page, limit int, expecting []int, more bool) {
+	t.Logf("Starting test for paginate with: page %d of %d, and expecting: %v (more %t)", page, limit, expecting, more)
+	got, gotMore := paginate(items, page, limit)
+	if !slices.Equal(got, expecting) || gotMore != more {
+		t.Errorf("Expecting: %v (more %t), but got: %v (more %t)", expecting, more, got, gotMore)
+	}
+}
+
+func TestListingPage(t *testing.T) {
+	tokens := auth.NewIssuer("secret")
+	token, err := tokens.NewAccessToken(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{tokens: tokens, listing: listingLimits{anonPageSize: 10, anonMaxPage: 3}}
+
+	runListingPageTest(t, cfg, "", "", 200, 1, 10)
+	runListingPageTest(t, cfg, "", "?limit=50", 200, 1, 10)
+	runListingPageTest(t, cfg, "", "?limit=5&page=3", 200, 3, 5)
+	runListingPageTest(t, cfg, "", "?page=4", 401, 0, 0)
+	runListingPageTest(t, cfg, token, "", 200, 1, 0)
+	runListingPageTest(t, cfg, token, "?limit=50&page=4", 200, 4, 50)
+	runListingPageTest(t, cfg, token, "?page=0", 400, 0, 0)
+}
+
+func runListingPageTest(t *testing.T, cfg *apiConfig, token, query string, status, page, limit int) {
+	t.Logf("Starting test for listingPage with: %q (signed in %t), and expecting: %d, page %d of %d", query, token != "", status, page, limit)
+	r := httptest.NewRequest("GET", "/api/chirps"+query, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	gotPage, gotLimit, ok := cfg.listingPage(w, r)
+	if ok != (status == 200) || w.Code != status {
+		t.Errorf("Expecting: %d, but got: %d", status, w.Code)
+	}
+	if gotPage != page || gotLimit != limit {
+		t.Errorf("Expecting: page %d of %d, but got: page %d of %d", page, limit, gotPage, gotLimit)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// loginThrottle locks out accounts and client addresses that keep failing
+// to log in, to slow down password guessing. A policy with a zero limit
+// turns its kind of lockout off.
+type loginThrottle struct {
+	account database.LockoutPolicy
+	ip      database.LockoutPolicy
+}
+
+type throttleSubject struct {
+	kind    string
+	subject string
+	policy  database.LockoutPolicy
+}
+
+// subjects returns the enabled throttles a login for email from ip counts
+// against.
+func (lt loginThrottle) subjects(email, ip string) []throttleSubject {
+	var subjects []throttleSubject
+	if lt.account.Limit > 0 {
+		subjects = append(subjects, throttleSubject{database.ThrottleAccount, strings.ToLower(strings.TrimSpace(email)), lt.account})
+	}
+	if lt.ip.Limit > 0 {
+		subjects = append(subjects, throttleSubject{database.ThrottleIP, ip, lt.ip})
+	}
+	return subjects
+}
+
+// loginLockout returns when the later of the account with email and the
+// client at ip may log in again, or nil when both may now.
+func (cfg *apiConfig) loginLockout(ctx context.Context, email, ip string, at time.Time) (*time.Time, error) {
+	var lockedUntil *time.Time
+	for _, s := range cfg.loginThrottle.subjects(email, ip) {
+		throttle, err := cfg.store(ctx).GetLoginThrottle(s.kind, s.subject)
+		if err != nil {
+			return nil, err
+		}
+		if throttle.Locked(at) && (lockedUntil == nil || throttle.LockedUntil.After(*lockedUntil)) {
+			lockedUntil = throttle.LockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+// recordLoginFailure counts a failed login for email from ip, and returns
+// when the lockout it started ends, if it started one.
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, email, ip string, at time.Time) (*time.Time, error) {
+	var lockedUntil *time.Time
+	for _, s := range cfg.loginThrottle.subjects(email, ip) {
+		throttle, err := cfg.store(ctx).RecordLoginFailure(s.kind, s.subject, at, s.policy)
+		if err != nil {
+			return nil, err
+		}
+		if throttle.Locked(at) && (lockedUntil == nil || throttle.LockedUntil.After(*lockedUntil)) {
+			lockedUntil = throttle.LockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+// resetLoginFailures forgets the failures of the account with email once
+// it logged in. Those of the client's address stay, so logging in to one
+// account cannot clear the guesses made at others.
+func (cfg *apiConfig) resetLoginFailures(ctx context.Context, email string) error {
+	if cfg.loginThrottle.account.Limit <= 0 {
+		return nil
+	}
+	return cfg.store(ctx).ResetLoginFailures(database.ThrottleAccount, strings.ToLower(strings.TrimSpace(email)))
+}
+
+// loginFailed records a failed API login. It answers with a 423 and returns
+// true when the failure locked the account or the client out, or with a
+// 500 when recording failed; otherwise the caller answers.
+func (cfg *apiConfig) loginFailed(w http.ResponseWriter, r *http.Request, email, ip string, at time.Time) bool {
+	lockedUntil, err := cfg.recordLoginFailure(r.Context(), email, ip, at)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return true
+	}
+	if lockedUntil != nil {
+		respondLockedOut(w, *lockedUntil, at)
+		return true
+	}
+	return false
+}
+
+// respondLockedOut tells the client it may not log in before lockedUntil.
+func respondLockedOut(w http.ResponseWriter, lockedUntil, now time.Time) {
+	type returnVal struct {
+		Error       string    `json:"error"`
+		LockedUntil time.Time `json:"locked_until"`
+	}
+	data, err := json.Marshal(returnVal{Error: "too many failed logins", LockedUntil: lockedUntil})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedUntil.Sub(now).Seconds()))))
+	w.WriteHeader(http.StatusLocked)
+	w.Write(data)
+}
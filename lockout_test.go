@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestLoginLockout(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"boots@example.com", "other@example.com"} {
+		if _, err := db.CreateUser(email, "hunter2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy := database.LockoutPolicy{Limit: 3, Window: time.Minute, Duration: time.Hour}
+	cfg := &apiConfig{
+		db:            db,
+		tokens:        auth.NewIssuer("secret"),
+		tokenFormat:   tokenFormatChirpy,
+		loginThrottle: loginThrottle{account: policy, ip: database.LockoutPolicy{Limit: 5, Window: time.Minute, Duration: time.Hour}},
+	}
+
+	runLoginLockoutTest(t, cfg, "boots@example.com", "hunter2", 200)
+	runLoginLockoutTest(t, cfg, "boots@example.com", "wrong", 401)
+	runLoginLockoutTest(t, cfg, "boots@example.com", "wrong", 401)
+	runLoginLockoutTest(t, cfg, "boots@example.com", "wrong", http.StatusLocked)
+	runLoginLockoutTest(t, cfg, "BOOTS@example.com", "hunter2", http.StatusLocked)
+
+	// Two more failures lock the address out, even for other accounts.
+	runLoginLockoutTest(t, cfg, "other@example.com", "wrong", 401)
+	runLoginLockoutTest(t, cfg, "other@example.com", "wrong", http.StatusLocked)
+	runLoginLockoutTest(t, cfg, "other@example.com", "hunter2", http.StatusLocked)
+
+	lockouts, err := db.ActiveLockouts(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockouts[database.ThrottleAccount] != 1 || lockouts[database.ThrottleIP] != 1 {
+		t.Errorf("Expecting: one account and one address locked out, but got: %v", lockouts)
+	}
+}
+
+func runLoginLockoutTest(t *testing.T, cfg *apiConfig, email, password string, expecting int) {
+	t.Logf("Starting test for postLoginHandler with: %s and %s, and expecting: %d", email, password, expecting)
+	resp := httptest.NewRecorder()
+	body := `{"email":"` + email + `","password":"` + password + `"}`
+	cfg.postLoginHandler(resp, httptest.NewRequest("POST", "/api/login", strings.NewReader(body)))
+	if resp.Code != expecting {
+		t.Errorf("Expecting: %d, but got: %d %s", expecting, resp.Code, resp.Body.String())
+	}
+	if expecting == http.StatusLocked && (resp.Header().Get("Retry-After") == "" || !strings.Contains(resp.Body.String(), "locked_until")) {
+		t.Errorf("Expecting: Retry-After and locked_until, but got: %v %s", resp.Header(), resp.Body.String())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+// mailer sends email to users.
+type mailer interface {
+	Send(to, subject, body string) error
+}
+
+// logMailer writes mail to the log instead of sending it, so a server
+// without SMTP configured still shows operators what would have been sent.
+type logMailer struct{}
+
+func (logMailer) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m smtpMailer) Send(to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.from, to, subject, body)
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+// newMailer sends through the SMTP server at addr, or to the log when addr
+// is empty. Credentials are optional.
+func newMailer(addr, from, username, password string) mailer {
+	if addr == "" {
+		return logMailer{}
+	}
+	m := smtpMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
@@ -1,207 +1,853 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/hooks"
+	"github.com/avearmin/chirpy/internal/blobstore"
 	"github.com/avearmin/chirpy/internal/database"
+	"github.com/avearmin/chirpy/internal/richtext"
+	"github.com/avearmin/chirpy/internal/validation"
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
 )
 
 type apiConfig struct {
-	fileserverHits int
-	jwtSecret      string
-	polkaApiKey    string
+	metrics          *metrics
+	tokens           *auth.Issuer
+	polkaApiKey      string
+	polkaSecret      string
+	polkaNonces      *nonceCache
+	signatureNonces  *nonceCache
+	db               database.Storage
+	bans             *ipBanList
+	renderer         *richtext.Renderer
+	reportDailyLimit int
+	linkTracking     bool
+	mail             mailer
+	verificationTTL  time.Duration
+	blobs            blobstore.BlobStore
+	mediaMaxBytes    int64
+	uploadTTL        time.Duration
+	mediaGCGrace     time.Duration
+	requireAltText   bool
+	maxChirpLength   int
+	scheduleMaxAhead time.Duration
+	pollMaxDuration  time.Duration
+	similarityWindow time.Duration
+	deletionGrace    time.Duration
+	forYou           *forYouFeeds
+	reservedHandles  map[string]bool
+	inboxes          *feedInboxes
+	broker           *chirpBroker
+	workers          *workerManager
+	jobs             *jobQueue
+	limiter          *rateLimiter
+	adminToken       string
+	platform         string // "dev" allows what must never run in production
+	adminEmail       string
+	numericChirpIds  bool
+	listing          listingLimits
+	backups          backupConfig
+	operatorHook     *operatorWebhook
+	tokenFormat      string
+	device           deviceFlow
+	totpBox          *auth.SecretBox
+	oauth            oauthConfig
+	remember         rememberMe
+	loginThrottle    loginThrottle
+	inFlight         *concurrencyLimiter
+	passwordPolicy   validation.PasswordPolicy
+	profanity        *profanityFilter
+	websiteClient    *http.Client
+	webhookClient    *http.Client
+	analytics        *analytics
+	bodyLimits       bodyLimits
+	cors             corsPolicies
+	mirror           *mirror
+	requestTimeout   time.Duration
 }
 
 func main() {
-	const root = "."
-	const appDir = "./app"
-	const port = "8080"
-
 	godotenv.Load()
 
+	conf, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%s", err)
+	}
+
+	db, err := database.Open(conf.DBDriver, conf.DBPath, conf.DatabaseURL)
+	if errors.Is(err, database.ErrCorruptDatabase) {
+		log.Fatalf("Error opening database: %s\nThe file was left untouched; restore it from a backup or move it aside to start empty.", err)
+	}
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	// loadConfig has checked the id scheme and node id.
+	ids, _ := conf.idGenerator()
+	db.SetIdGenerator(ids)
+
+	blobs, err := blobstore.NewDir(conf.MediaDir)
+	if err != nil {
+		log.Fatalf("Error opening media directory: %s", err)
+	}
+
+	renderer, err := loadRenderer(os.Getenv("CHIRP_TEMPLATES"))
+	if err != nil {
+		log.Fatalf("Error loading chirp templates: %s", err)
+	}
+
+	operatorHook, err := newOperatorWebhook(os.Getenv("OPERATOR_WEBHOOK_URL"), os.Getenv("OPERATOR_WEBHOOK_FORMAT"))
+	if err != nil {
+		log.Fatalf("Error configuring the operator webhook: %s", err)
+	}
+
+	tokenFormat := os.Getenv("TOKEN_RESPONSE_FORMAT")
+	if tokenFormat == "" {
+		tokenFormat = tokenFormatChirpy
+	}
+	if !validTokenFormat(tokenFormat) {
+		log.Fatalf("Invalid TOKEN_RESPONSE_FORMAT %q: must be %s or %s", tokenFormat, tokenFormatChirpy, tokenFormatOAuth2)
+	}
+
+	tokens, err := newTokenIssuer(conf)
+	if err != nil {
+		log.Fatalf("Error loading the JWT signing key: %s", err)
+	}
+
+	var totpBox *auth.SecretBox
+	if key := os.Getenv("TOTP_ENCRYPTION_KEY"); key != "" {
+		// Secrets sealed under the previous keys still open until
+		// chirpyctl rotate-secret totp has resealed them.
+		totpBox, err = auth.NewSecretBox(key, previousKeys(os.Getenv("TOTP_PREVIOUS_ENCRYPTION_KEYS"))...)
+		if err != nil {
+			log.Fatalf("Error loading the TOTP encryption key: %s", err)
+		}
+	}
+
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		log.Fatalf("Error loading the password blocklist: %s", err)
+	}
+
 	apiCfg := &apiConfig{
-		fileserverHits: 0,
-		jwtSecret:      os.Getenv("JWT_SECRET"),
-		polkaApiKey:    os.Getenv("POLKA_API_KEY"),
+		metrics:          newMetrics(),
+		tokens:           tokens,
+		polkaApiKey:      conf.PolkaAPIKey,
+		polkaSecret:      conf.PolkaSecret,
+		polkaNonces:      newNonceCache(),
+		signatureNonces:  newNonceCache(),
+		db:               db,
+		bans:             newIPBanList(),
+		renderer:         renderer,
+		reportDailyLimit: envInt("REPORT_DAILY_LIMIT", 10),
+		linkTracking:     envBool("LINK_TRACKING", false),
+		mail:             newMailer(os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")),
+		verificationTTL:  envDuration("VERIFICATION_TOKEN_TTL", 24*time.Hour),
+		blobs:            blobs,
+		mediaMaxBytes:    int64(envInt("MEDIA_MAX_BYTES", 8<<20)),
+		uploadTTL:        envDuration("UPLOAD_TTL", 24*time.Hour),
+		mediaGCGrace:     envDuration("MEDIA_GC_GRACE", 24*time.Hour),
+		requireAltText:   envBool("REQUIRE_ALT_TEXT", false),
+		maxChirpLength:   envInt("MAX_CHIRP_LENGTH", 140),
+		scheduleMaxAhead: envDuration("CHIRP_SCHEDULE_MAX_AHEAD", 365*24*time.Hour),
+		pollMaxDuration:  envDuration("POLL_MAX_DURATION", 7*24*time.Hour),
+		similarityWindow: envDuration("SIMILARITY_WINDOW", 24*time.Hour),
+		deletionGrace:    time.Duration(envInt("ACCOUNT_DELETION_GRACE_DAYS", 30)) * 24 * time.Hour,
+		forYou:           newForYouFeeds(),
+		reservedHandles:  reservedHandles(os.Getenv("RESERVED_HANDLES")),
+		inboxes:          newFeedInboxes(envInt("FEED_INBOX_SIZE", 800), envInt("FEED_FANOUT_MAX_FOLLOWERS", 10000)),
+		broker:           newChirpBroker(),
+		workers:          newWorkerManager(envDuration("WORKER_MIN_BACKOFF", time.Second), envDuration("WORKER_MAX_BACKOFF", time.Minute)),
+		jobs:             newJobQueue(envDuration("JOB_POLL_INTERVAL", time.Second), envDuration("JOB_RETRY_DELAY", 30*time.Second), envDuration("JOB_LEASE", 5*time.Minute)),
+		limiter: newRateLimiter(rateLimits{
+			ip:     rateLimit{perMinute: envInt("RATE_LIMIT_IP", 60), burst: envInt("RATE_LIMIT_IP_BURST", 20)},
+			user:   rateLimit{perMinute: envInt("RATE_LIMIT_USER", 300), burst: envInt("RATE_LIMIT_USER_BURST", 60)},
+			red:    rateLimit{perMinute: envInt("RATE_LIMIT_RED", 1200), burst: envInt("RATE_LIMIT_RED_BURST", 200)},
+			jitter: envDuration("RATE_LIMIT_JITTER", 5*time.Second),
+		}),
+		adminToken:      os.Getenv("ADMIN_TOKEN"),
+		platform:        os.Getenv("PLATFORM"),
+		cors:            newCorsPolicies(corsOrigins(os.Getenv("CORS_ORIGINS"))),
+		adminEmail:      os.Getenv("ADMIN_EMAIL"),
+		numericChirpIds: envBool("NUMERIC_CHIRP_IDS", true),
+		listing: listingLimits{
+			anonPageSize: envInt("CHIRPS_ANON_PAGE_SIZE", 100),
+			anonMaxPage:  envInt("CHIRPS_ANON_MAX_PAGE", 0),
+		},
+		backups: backupConfig{
+			dir:      os.Getenv("BACKUP_DIR"),
+			interval: envDuration("BACKUP_INTERVAL", 24*time.Hour),
+			retain:   envInt("BACKUP_RETAIN", 7),
+		},
+		operatorHook: operatorHook,
+		tokenFormat:  tokenFormat,
+		device: deviceFlow{
+			ttl:      envDuration("DEVICE_CODE_TTL", 10*time.Minute),
+			interval: envDuration("DEVICE_POLL_INTERVAL", 5*time.Second),
+		},
+		totpBox: totpBox,
+		oauth:   newOAuthConfig(os.Getenv),
+		remember: rememberMe{
+			secret: []byte(os.Getenv("REMEMBER_ME_SECRET")),
+			ttl:    envDuration("REMEMBER_ME_TTL", 30*24*time.Hour),
+			maxAge: envDuration("REMEMBER_ME_MAX_AGE", 365*24*time.Hour),
+		},
+		loginThrottle: loginThrottle{
+			account: database.LockoutPolicy{
+				Limit:    envInt("LOGIN_LOCKOUT_ATTEMPTS", 5),
+				Window:   envDuration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute),
+				Duration: envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			},
+			ip: database.LockoutPolicy{
+				Limit:    envInt("LOGIN_LOCKOUT_IP_ATTEMPTS", 50),
+				Window:   envDuration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute),
+				Duration: envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			},
+		},
+		inFlight: newConcurrencyLimiter(concurrencyLimits{
+			global:       envInt("MAX_IN_FLIGHT", 256),
+			perIP:        envInt("MAX_IN_FLIGHT_PER_IP", 16),
+			queue:        envInt("IN_FLIGHT_QUEUE", 128),
+			queueTimeout: envDuration("IN_FLIGHT_QUEUE_TIMEOUT", 2*time.Second),
+		}),
+		passwordPolicy: passwordPolicy,
+		profanity:      newProfanityFilter(nil),
+		websiteClient:  newWebsiteClient(),
+		webhookClient:  &http.Client{Timeout: 10 * time.Second},
+		analytics: newAnalytics(envBool("ANALYTICS", true),
+			newAnalyticsSink(os.Getenv("ANALYTICS_SINK"), os.Getenv("ANALYTICS_WRITE_KEY")), envInt("ANALYTICS_MAX_PENDING", 10000)),
+		requestTimeout: envDuration("REQUEST_TIMEOUT", 30*time.Second),
+	}
+	if driver := os.Getenv("MIRROR_DB_DRIVER"); driver != "" {
+		secondary, err := database.Open(driver, os.Getenv("MIRROR_DB_PATH"), os.Getenv("MIRROR_DATABASE_URL"))
+		if err != nil {
+			log.Fatalf("Error opening the mirror database: %s", err)
+		}
+		apiCfg.mirror = newMirror(secondary, envInt("MIRROR_PERCENT", 10), envDuration("MIRROR_TIMEOUT", 5*time.Second))
+		log.Printf("Mirroring %d%% of API reads to the %s store", apiCfg.mirror.percent, driver)
+	}
+	// Uploads leave room for the multipart framing, like receiveMedia.
+	mediaBodyLimit := apiCfg.mediaMaxBytes + 1<<20
+	importBodyLimit := int64(envInt("MAX_IMPORT_BODY_BYTES", 0))
+	apiCfg.bodyLimits = bodyLimits{
+		def: int64(envInt("MAX_BODY_BYTES", 1<<20)),
+		paths: map[string]int64{
+			"/api/media":           mediaBodyLimit,
+			"/api/users/me/avatar": mediaBodyLimit,
+			"/api/users/me/banner": mediaBodyLimit,
+			"/admin/import":        importBodyLimit,
+			"/admin/restore":       importBodyLimit,
+		},
+	}
+	if err := apiCfg.reloadProfanity(context.Background()); err != nil {
+		log.Fatalf("Error loading the profanity filter: %s", err)
+	}
+	if err := apiCfg.bootstrapAdmin(); err != nil {
+		log.Fatalf("Error bootstrapping the admin: %s", err)
+	}
+	apiCfg.jobs.handle(jobVerificationEmail, envInt("VERIFICATION_EMAIL_ATTEMPTS", 5), apiCfg.verificationEmailJob)
+	apiCfg.jobs.handle(jobImport, 1, apiCfg.importJob)
+	apiCfg.jobs.handle(jobOperatorWebhook, envInt("OPERATOR_WEBHOOK_ATTEMPTS", 5), apiCfg.operatorWebhookJob)
+	apiCfg.jobs.handle(jobWebhook, envInt("WEBHOOK_ATTEMPTS", 8), apiCfg.webhookJob)
+
+	honeypotPaths := defaultHoneypotPaths
+	if paths := os.Getenv("HONEYPOT_PATHS"); paths != "" {
+		honeypotPaths = strings.Split(paths, ",")
+	}
+	trap := &honeypot{
+		paths:        honeypotPaths,
+		tarpit:       envDuration("HONEYPOT_TARPIT", 5*time.Second),
+		window:       envDuration("HONEYPOT_WINDOW", 10*time.Minute),
+		banThreshold: envInt("HONEYPOT_BAN_THRESHOLD", 3),
+		banDuration:  envDuration("HONEYPOT_BAN_DURATION", 24*time.Hour),
+		bans:         apiCfg.bans,
+		probes:       make(map[string][]time.Time),
 	}
 
 	router := chi.NewRouter()
-	fshandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(appDir))))
+	fshandler := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(conf.StaticDir))))
 	router.Handle("/app/*", fshandler)
 	router.Handle("/app", fshandler)
+	router.Get("/l/{code}", apiCfg.linkHandler)
+	router.Get("/media/{id}", apiCfg.getMediaHandler)
+	router.Get("/.well-known/jwks.json", apiCfg.getJWKSHandler)
+	router.Get("/activate", apiCfg.getActivateHandler)
+	router.Method(http.MethodPost, "/activate", apiCfg.middlewareRateLimit(http.HandlerFunc(apiCfg.postActivateHandler)))
+	for _, path := range trap.paths {
+		router.HandleFunc(strings.TrimSpace(path), trap.handler)
+	}
 
 	apiRouter := chi.NewRouter()
 	apiRouter.Get("/healthz", readinessEndpointHandler)
-	apiRouter.Get("/reset", apiCfg.resetHandler)
-	apiRouter.Post("/chirps", apiCfg.postChirpsHandler)
-	apiRouter.Get("/chirps", getChirpsHandler)
-	apiRouter.Get("/chirps/{id}", getChirpIdHandler)
+	apiRouter.Get("/readyz", apiCfg.readyzHandler)
+	apiRouter.Get("/instance", apiCfg.getInstanceHandler)
+	apiRouter.With(apiCfg.middlewareIdempotency(apiCfg.chirpIdempotencyScope)).Post("/chirps", apiCfg.postChirpsHandler)
+	apiRouter.Get("/drafts", apiCfg.getDraftsHandler)
+	apiRouter.Post("/drafts", apiCfg.postDraftHandler)
+	apiRouter.Get("/drafts/{id}", apiCfg.getDraftHandler)
+	apiRouter.Put("/drafts/{id}", apiCfg.putDraftHandler)
+	apiRouter.Delete("/drafts/{id}", apiCfg.deleteDraftHandler)
+	apiRouter.Post("/drafts/{id}/publish", apiCfg.postPublishDraftHandler)
+	apiRouter.Post("/media", apiCfg.postMediaHandler)
+	apiRouter.Delete("/media/{id}", apiCfg.deleteMediaHandler)
+	apiRouter.Post("/media/uploads", apiCfg.postUploadHandler)
+	apiRouter.Head("/media/uploads/{id}", apiCfg.headUploadHandler)
+	apiRouter.Patch("/media/uploads/{id}", apiCfg.patchUploadHandler)
+	apiRouter.Delete("/media/uploads/{id}", apiCfg.deleteUploadHandler)
+	apiRouter.Get("/chirps", apiCfg.getChirpsHandler)
+	apiRouter.Get("/chirps/stream", apiCfg.getChirpEventsHandler)
+	apiRouter.Get("/chirps/scheduled", apiCfg.getScheduledChirpsHandler)
+	apiRouter.Delete("/chirps/scheduled/{id}", apiCfg.deleteScheduledChirpHandler)
+	apiRouter.Get("/feed", apiCfg.getFeedHandler)
+	apiRouter.Get("/trends", apiCfg.getTrendsHandler)
+	apiRouter.Get("/emoji", apiCfg.getEmojiHandler)
+	apiRouter.Get("/stream", apiCfg.getStreamHandler)
+	apiRouter.Get("/feed/marker", apiCfg.getFeedMarkerHandler)
+	apiRouter.Put("/feed/marker", apiCfg.putFeedMarkerHandler)
+	apiRouter.Get("/chirps/{id}", apiCfg.getChirpIdHandler)
+	apiRouter.Get("/chirps/{id}/replies", apiCfg.getChirpRepliesHandler)
+	apiRouter.Get("/chirps/{id}/context", apiCfg.getChirpContextHandler)
+	apiRouter.Get("/chirps/{id}/analytics", apiCfg.getChirpAnalyticsHandler)
+	apiRouter.Put("/chirps/{id}", apiCfg.putChirpHandler)
 	apiRouter.Delete("/chirps/{id}", apiCfg.deleteChirpHandler)
-	apiRouter.Post("/users", postUsersHandler)
+	apiRouter.Post("/chirps/{id}/like", apiCfg.postChirpLikeHandler)
+	apiRouter.Delete("/chirps/{id}/like", apiCfg.deleteChirpLikeHandler)
+	apiRouter.Post("/chirps/{id}/bookmark", apiCfg.postChirpBookmarkHandler)
+	apiRouter.Delete("/chirps/{id}/bookmark", apiCfg.deleteChirpBookmarkHandler)
+	apiRouter.Post("/chirps/{id}/rechirp", apiCfg.postRechirpHandler)
+	apiRouter.Delete("/chirps/{id}/rechirp", apiCfg.deleteRechirpHandler)
+	apiRouter.Post("/chirps/{id}/report", apiCfg.postChirpReportHandler)
+	apiRouter.Post("/chirps/{id}/vote", apiCfg.postPollVoteHandler)
+	apiRouter.Post("/users", apiCfg.postUsersHandler)
 	apiRouter.Put("/users", apiCfg.updateUserCredsHandler)
+	apiRouter.Post("/users/verify", apiCfg.postVerifyUserHandler)
+	apiRouter.Post("/users/verify/resend", apiCfg.postResendVerificationHandler)
+	apiRouter.Get("/users/me/likes", apiCfg.getUserLikesHandler)
+	apiRouter.Get("/users/me/bookmarks", apiCfg.getUserBookmarksHandler)
+	apiRouter.Patch("/users/me", apiCfg.patchUserProfileHandler)
+	apiRouter.Put("/users/me/avatar", apiCfg.putAvatarHandler)
+	apiRouter.Put("/users/me/banner", apiCfg.putBannerHandler)
+	apiRouter.Post("/users/me/website/verify", apiCfg.postVerifyWebsiteHandler)
+	apiRouter.Get("/users/me/migration", apiCfg.getAccountMigrationHandler)
+	apiRouter.Post("/users/me/migration", apiCfg.postAccountMigrationHandler)
+	apiRouter.Put("/users/me/moved_to", apiCfg.putMovedToHandler)
+	apiRouter.Delete("/users/me/moved_to", apiCfg.deleteMovedToHandler)
+	apiRouter.Delete("/users/me", apiCfg.deleteMeHandler)
+	apiRouter.Get("/users/me/export", apiCfg.getUserExportHandler)
+	apiRouter.Get("/users/{id}", apiCfg.getUserProfileHandler)
+	apiRouter.Post("/users/{id}/follow", apiCfg.postFollowHandler)
+	apiRouter.Delete("/users/{id}/follow", apiCfg.deleteFollowHandler)
+	apiRouter.Get("/users/{id}/following", apiCfg.getFollowingHandler)
+	apiRouter.Get("/users/{id}/followers", apiCfg.getFollowersHandler)
+	apiRouter.Get("/users/me/moderation", apiCfg.getUserModerationHandler)
+	apiRouter.Get("/users/me/preferences", apiCfg.getUserPreferencesHandler)
+	apiRouter.Put("/users/me/preferences", apiCfg.putUserPreferencesHandler)
+	apiRouter.Delete("/users/me/sessions", apiCfg.deleteUserSessionsHandler)
+	apiRouter.Get("/users/me/security", apiCfg.getUserSecurityHandler)
+	apiRouter.Post("/users/me/2fa/enroll", apiCfg.postTOTPEnrollHandler)
+	apiRouter.Post("/users/me/2fa/verify", apiCfg.postTOTPVerifyHandler)
+	apiRouter.Post("/appeals", apiCfg.postAppealHandler)
 	apiRouter.Post("/login", apiCfg.postLoginHandler)
 	apiRouter.Post("/refresh", apiCfg.postRefreshHandler)
 	apiRouter.Post("/revoke", apiCfg.postRevokeHandler)
-	apiRouter.Post("/polka/webhooks", apiCfg.postPolkaWebhookHandler)
-
-	router.Mount("/api", apiRouter)
+	apiRouter.Get("/oauth/{provider}/authorize", apiCfg.getOAuthAuthorizeHandler)
+	apiRouter.Get("/oauth/{provider}/callback", apiCfg.getOAuthCallbackHandler)
+	apiRouter.Post("/device/code", apiCfg.postDeviceCodeHandler)
+	apiRouter.Post("/device/token", apiCfg.postDeviceTokenHandler)
+	apiRouter.Post("/device/activate", apiCfg.postDeviceActivateHandler)
+	apiRouter.With(apiCfg.middlewareIdempotency(polkaIdempotencyScope)).Post("/polka/webhooks", apiCfg.postPolkaWebhookHandler)
+	apiRouter.Post("/apikeys", apiCfg.postAPIKeysHandler)
+	apiRouter.Get("/apikeys", apiCfg.getAPIKeysHandler)
+	apiRouter.Delete("/apikeys/{id}", apiCfg.deleteAPIKeyHandler)
 
-	adminRouter := chi.NewRouter()
-	adminRouter.Get("/metrics", apiCfg.fileServerHitsHandler)
-	router.Mount("/admin", adminRouter)
+	router.Mount("/api", apiCfg.middlewareRequestSignature(apiCfg.middlewareRateLimit(apiCfg.middlewareMirror(apiRouter))))
+	router.Mount("/admin", apiCfg.newAdminRouter())
 
-	corsMux := middlewareCors(router)
+	shutdownTimeout := envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	corsMux := middlewareRequestId(apiCfg.middlewareCors(apiCfg.middlewareBan(apiCfg.middlewareConcurrency(apiCfg.middlewareBodyLimit(apiCfg.middlewareRequestTimeout(router))))))
 	server := &http.Server{
-		Addr:    ":" + port,
+		Addr:    ":" + conf.Port,
 		Handler: corsMux,
 	}
+	var redirectServer *http.Server
+	if conf.tlsEnabled() {
+		redirectServer = configureTLS(server, conf, os.Getenv("AUTOCERT_CACHE_DIR"), os.Getenv("HTTP_REDIRECT_PORT"), os.Getenv("ACME_EMAIL"))
+	}
 
-	log.Printf("Serving files from %s on port: %s\n", appDir, port)
-	server.ListenAndServe()
-}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	forYouRefresh := envDuration("FOR_YOU_REFRESH_INTERVAL", 5*time.Minute)
+	apiCfg.workers.add("for-you-refresh", func(ctx context.Context) error {
+		return apiCfg.refreshForYouFeeds(ctx, forYouRefresh)
+	})
+	for i := 1; i <= envInt("FEED_FANOUT_WORKERS", 4); i++ {
+		apiCfg.workers.add(fmt.Sprintf("fanout-%d", i), apiCfg.fanoutWorker)
+	}
+	for i := 1; i <= envInt("JOB_WORKERS", 2); i++ {
+		apiCfg.workers.add(fmt.Sprintf("jobs-%d", i), apiCfg.jobWorker)
+	}
+	apiCfg.workers.add("rate-limit-prune", apiCfg.limiter.pruneWorker)
+	apiCfg.workers.add("honeypot-prune", trap.pruneWorker)
+	purgeAfter := time.Duration(envInt("CHIRP_PURGE_AFTER_DAYS", 30)) * 24 * time.Hour
+	purgeEvery := envDuration("CHIRP_PURGE_INTERVAL", time.Hour)
+	apiCfg.workers.add("chirp-purge", func(ctx context.Context) error {
+		return apiCfg.purgeDeletedChirps(ctx, purgeAfter, purgeEvery)
+	})
+	apiCfg.workers.add("profanity-reload", func(ctx context.Context) error {
+		return apiCfg.reloadProfanityWorker(ctx, envDuration("PROFANITY_RELOAD_INTERVAL", time.Minute))
+	})
+	apiCfg.workers.add("chirp-scheduler", func(ctx context.Context) error {
+		return apiCfg.publishScheduledChirps(ctx, envDuration("CHIRP_SCHEDULE_INTERVAL", 15*time.Second))
+	})
+	apiCfg.workers.add("poll-closer", func(ctx context.Context) error {
+		return apiCfg.closePollsWorker(ctx, envDuration("POLL_CLOSE_INTERVAL", time.Minute))
+	})
+	apiCfg.workers.add("idempotency-purge", func(ctx context.Context) error {
+		return apiCfg.purgeIdempotencyKeys(ctx, envDuration("IDEMPOTENCY_PURGE_INTERVAL", time.Hour))
+	})
+	apiCfg.workers.add("media-gc", func(ctx context.Context) error {
+		return apiCfg.collectOrphanedMediaWorker(ctx, envDuration("MEDIA_GC_INTERVAL", 6*time.Hour))
+	})
+	apiCfg.workers.add("upload-expiry", func(ctx context.Context) error {
+		return apiCfg.expireUploads(ctx, envDuration("UPLOAD_EXPIRY_INTERVAL", time.Hour))
+	})
+	if apiCfg.deletionGrace > 0 {
+		apiCfg.workers.add("account-purge", func(ctx context.Context) error {
+			return apiCfg.purgeDeletedUsers(ctx, envDuration("ACCOUNT_PURGE_INTERVAL", time.Hour))
+		})
+	}
+	if gobDB, ok := db.(*database.DB); ok {
+		compactEvery := envDuration("JOURNAL_COMPACT_INTERVAL", 5*time.Minute)
+		apiCfg.workers.add("journal-compaction", func(ctx context.Context) error {
+			return compactJournal(ctx, gobDB, compactEvery)
+		})
+	}
+	if apiCfg.backups.dir != "" {
+		apiCfg.workers.add("backups", apiCfg.backupWorker)
+	}
+	if apiCfg.analytics != nil && apiCfg.analytics.sink != nil {
+		apiCfg.workers.add("analytics-export", func(ctx context.Context) error {
+			return apiCfg.exportAnalyticsWorker(ctx, envDuration("ANALYTICS_FLUSH_INTERVAL", 10*time.Second))
+		})
+	}
+	apiCfg.workers.add("metrics-flush", func(ctx context.Context) error {
+		return apiCfg.flushMetricsWorker(ctx, envDuration("METRICS_FLUSH_INTERVAL", 10*time.Second))
+	})
+	go apiCfg.workers.startWhenReady(ctx, db.Ping)
+	go func() {
+		log.Printf("Serving files from %s on port: %s\n", conf.StaticDir, conf.Port)
+		var err error
+		if conf.tlsEnabled() {
+			err = server.ListenAndServeTLS(conf.TLSCert, conf.TLSKey)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error serving: %s", err)
+		}
+	}()
+	if redirectServer != nil {
+		go func() {
+			log.Printf("Redirecting HTTP to HTTPS on port: %s\n", strings.TrimPrefix(redirectServer.Addr, ":"))
+			if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Error serving HTTP redirects: %s", err)
+			}
+		}()
+	}
+	<-ctx.Done()
+	stop()
 
-func readinessEndpointHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(http.StatusText(http.StatusOK)))
+	log.Printf("Shutting down, waiting up to %s for requests to finish", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error draining requests: %s", err)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	apiCfg.mirror.wait()
+	if apiCfg.mirror != nil {
+		if err := apiCfg.mirror.db.Close(); err != nil {
+			log.Printf("Error closing mirror database: %s", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("Error closing database: %s", err)
+	}
 }
 
-func (cfg *apiConfig) fileServerHitsHandler(w http.ResponseWriter, r *http.Request) {
-	htmlContent := fmt.Sprintf(`
-        <html>
-          <body>
-            <h1>Welcome, Chirpy Admin</h1>
-            <p>Chirpy has been visited %d times!</p>
-          </body>
-        </html>`, cfg.fileserverHits)
-
-	w.Header().Add("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(htmlContent))
+// newAdminRouter routes the admin endpoints, every one of them behind
+// middlewareRequireAdmin.
+func (cfg *apiConfig) newAdminRouter() chi.Router {
+	adminRouter := chi.NewRouter()
+	adminRouter.Use(cfg.middlewareRequireAdmin)
+	adminRouter.Get("/metrics", cfg.getMetricsHandler)
+	adminRouter.Get("/metrics.json", cfg.getMetricsJSONHandler)
+	adminRouter.Post("/reset", cfg.resetHandler)
+	adminRouter.Post("/seed", cfg.postSeedHandler)
+	adminRouter.Get("/stats", cfg.getStatsHandler)
+	adminRouter.Get("/reports", cfg.getReportsHandler)
+	adminRouter.Post("/reports/{id}/resolve", cfg.postResolveReportHandler)
+	adminRouter.Get("/reports/clusters", cfg.getReportClustersHandler)
+	adminRouter.Post("/reports/clusters/resolve", cfg.postResolveClusterHandler)
+	adminRouter.Post("/chirps/{id}/remove", cfg.postRemoveChirpHandler)
+	adminRouter.Post("/chirps/{id}/restore", cfg.postRestoreChirpHandler)
+	adminRouter.Post("/users/{id}/suspend", cfg.postSuspendUserHandler)
+	adminRouter.Get("/appeals", cfg.getAppealsHandler)
+	adminRouter.Post("/appeals/{id}/resolve", cfg.postResolveAppealHandler)
+	adminRouter.Put("/emoji/{shortcode}", cfg.putEmojiHandler)
+	adminRouter.Delete("/emoji/{shortcode}", cfg.deleteEmojiHandler)
+	adminRouter.Get("/profanity", cfg.getBlockedWordsHandler)
+	adminRouter.Post("/profanity", cfg.postBlockedWordHandler)
+	adminRouter.Delete("/profanity", cfg.deleteBlockedWordHandler)
+	adminRouter.Post("/import", cfg.postImportHandler)
+	adminRouter.Get("/jobs", cfg.getJobsHandler)
+	adminRouter.Get("/jobs/{id}", cfg.getJobHandler)
+	adminRouter.Post("/jobs/{id}/requeue", cfg.postRequeueJobHandler)
+	adminRouter.Post("/backup", cfg.postBackupHandler)
+	adminRouter.Post("/restore", cfg.postRestoreHandler)
+	adminRouter.Get("/users", cfg.getUsersHandler)
+	adminRouter.Put("/users/{id}/admin", cfg.putAdminHandler)
+	adminRouter.Post("/users/{id}/ban", cfg.postBanUserHandler)
+	adminRouter.Delete("/users/{id}/ban", cfg.deleteBanUserHandler)
+	adminRouter.Delete("/chirps/{id}", cfg.deleteAdminChirpHandler)
+	adminRouter.Get("/media/orphaned", cfg.getOrphanedMediaHandler)
+	adminRouter.Post("/media/gc", cfg.postCollectMediaHandler)
+	adminRouter.Post("/webhooks", cfg.postWebhookHandler)
+	adminRouter.Get("/webhooks", cfg.getWebhooksHandler)
+	adminRouter.Delete("/webhooks/{id}", cfg.deleteWebhookHandler)
+	adminRouter.Get("/webhooks/{id}/deliveries", cfg.getWebhookDeliveriesHandler)
+	adminRouter.Get("/keys", cfg.getKeysHandler)
+	return adminRouter
 }
 
-func (cfg *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
-	cfg.fileserverHits = 0
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(http.StatusText(http.StatusOK)))
+// compactJournal folds the gob store's journal into a new snapshot every
+// interval until ctx is done, so replaying it on load stays cheap.
+func compactJournal(ctx context.Context, db *database.DB, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := db.Compact(); err != nil {
+			return fmt.Errorf("compacting journal: %w", err)
+		}
+	}
 }
 
-func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits++
-		next.ServeHTTP(w, r)
-	})
+// envInt reads an integer from the environment, falling back to def when unset or malformed.
+func envInt(key string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return value
 }
 
-func (cfg *apiConfig) postChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
+// envBool reads a strconv.ParseBool value from the environment, falling back to def when unset or malformed.
+func envBool(key string, def bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
 	if err != nil {
-		w.WriteHeader(401)
-		return
+		return def
 	}
-	issuer, err := parsedToken.Claims.GetIssuer()
+	return value
+}
+
+// envDuration reads a time.ParseDuration value from the environment, falling back to def when unset or malformed.
+func envDuration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
 	if err != nil {
-		respondParseTokenError(w, err)
-		return
+		return def
 	}
-	if issuer != "chirpy-access" {
-		w.WriteHeader(401)
+	return value
+}
+
+func readinessEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(http.StatusText(http.StatusOK)))
+}
+
+func (cfg *apiConfig) postChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
-	id, err := parsedToken.Claims.GetSubject()
-	if err != nil {
-		respondParseTokenError(w, err)
+	if cfg.rejectSuspended(w, r, userId) || cfg.rejectUnverified(w, r, userId) {
 		return
 	}
 
 	type parameters struct {
-		Body string `json:"body"`
-		Id   int    `json:"id"`
+		Body     string         `json:"body"`
+		Id       int            `json:"id"`
+		ParentId *int           `json:"parent_id"`
+		Media    []mediaRequest `json:"media"`
+		// QuotedId makes the chirp a quote chirp commenting on that one.
+		QuotedId *int `json:"quoted_id"`
+		// PublishAt, when set, schedules the chirp to be published then
+		// instead of now.
+		PublishAt string       `json:"publish_at"`
+		Poll      *pollRequest `json:"poll"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
+	decoder := newJSONDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondParamsDecodingError(w, err)
 		return
 	}
+	cfg.postChirp(w, r, userId, chirpRequest{
+		Body:      params.Body,
+		ParentId:  params.ParentId,
+		Media:     params.Media,
+		QuotedId:  params.QuotedId,
+		PublishAt: params.PublishAt,
+		Poll:      params.Poll,
+	})
+}
 
-	if len(params.Body) > 140 {
-		w.WriteHeader(400)
-		return
-	}
+// chirpRequest is a chirp a user asked to post, directly or from a draft.
+type chirpRequest struct {
+	Body      string
+	ParentId  *int
+	Media     []mediaRequest
+	QuotedId  *int
+	PublishAt string
+	Poll      *pollRequest
+}
 
-	db, err := database.NewDB("./database.gob")
+// postChirp checks the chirp and publishes it, or schedules it when it has
+// a publish time, answering the request either way. It reports whether the
+// chirp was accepted.
+func (cfg *apiConfig) postChirp(w http.ResponseWriter, r *http.Request, userId int, req chirpRequest) bool {
+	if reason := cfg.validateChirpBody(req.Body); reason != "" {
+		respondValidationError(w, reason)
+		return false
+	}
+	var publishAt publishTime
+	if req.PublishAt != "" {
+		var reason string
+		if publishAt, reason = cfg.parsePublishAt(req.PublishAt, time.Now()); reason != "" {
+			respondValidationError(w, reason)
+			return false
+		}
+	}
+	attachments, reason, err := cfg.attachMedia(r.Context(), userId, req.Media)
 	if err != nil {
-		respondDatabaseError(w, err)
-		return
+		respondDataFetchError(w, err)
+		return false
 	}
-
-	numericId, err := strconv.Atoi(id)
+	if reason != "" {
+		respondValidationError(w, reason)
+		return false
+	}
+	quotedId, reason, err := cfg.quotedChirp(r.Context(), req.QuotedId)
 	if err != nil {
-		respondStrconvError(w, err)
-		return
+		respondDataFetchError(w, err)
+		return false
+	}
+	if reason != "" {
+		respondValidationError(w, reason)
+		return false
+	}
+	var pollOptions []string
+	var pollClosesAt time.Time
+	if req.Poll != nil {
+		if req.PublishAt != "" {
+			respondValidationError(w, "chirps with polls cannot be scheduled")
+			return false
+		}
+		if pollOptions, pollClosesAt, reason = cfg.parsePoll(*req.Poll, time.Now()); reason != "" {
+			respondValidationError(w, reason)
+			return false
+		}
+	}
+
+	body, censored := cfg.profanity.clean(req.Body)
+	draft := hooks.ChirpDraft{AuthorId: userId, Body: body}
+	if err := hooks.PreCreate(&draft); err != nil {
+		respondHookError(w, err)
+		return false
+	}
+	if req.PublishAt != "" {
+		return cfg.scheduleChirp(w, r, database.ScheduledChirp{
+			AuthorId:       userId,
+			Body:           draft.Body,
+			ParentId:       req.ParentId,
+			Media:          attachments,
+			Censored:       censored,
+			QuotedId:       quotedId,
+			PublishAt:      publishAt.PublishAt,
+			PublishAtLocal: publishAt.PublishAtLocal,
+		})
+	}
+	var chirp database.Chirp
+	// A chirp asking a question is only posted along with its poll.
+	err = cfg.store(r.Context()).WriteBatch(func(db database.Storage) error {
+		var err error
+		chirp, err = db.CreateChirp(database.Chirp{
+			AuthorId: userId,
+			Body:     draft.Body,
+			ParentId: req.ParentId,
+			Media:    attachments,
+			Censored: censored,
+			QuotedId: quotedId,
+		})
+		if err != nil || req.Poll == nil {
+			return err
+		}
+		_, err = db.CreatePoll(chirp.Id, pollOptions, pollClosesAt)
+		return err
+	})
+	if errors.Is(err, database.ErrParentDoesNotExist) {
+		w.WriteHeader(400)
+		return false
 	}
-	chirp, err := db.CreateChirp(numericId, cleanChirp(params.Body))
 	if err != nil {
 		respondDataWriteError(w, err)
-		return
+		return false
 	}
-
-	data, err := json.Marshal(chirp)
+	resp, err := cfg.announceChirp(r.Context(), chirp)
+	if err != nil {
+		respondRenderError(w, err)
+		return true
+	}
+	data, err := json.Marshal(resp)
 	if err != nil {
 		respondJSONMarshalError(w, err)
-		return
+		return true
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(201)
 	w.Write(data)
+	return true
 }
 
-func getChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	db, err := database.NewDB("./database.gob")
+// announceChirp runs the steps that follow a new chirp, whether posted now
+// or published on schedule: the plugins, link shortening, feed fan-out and
+// the live stream. It returns the chirp as rendered for clients.
+func (cfg *apiConfig) announceChirp(ctx context.Context, chirp database.Chirp) (chirpResponse, error) {
+	hooks.PostCreate(hooks.Chirp{Id: chirp.Id, AuthorId: chirp.AuthorId, Body: chirp.Body})
+	cfg.shortenLinks(ctx, chirp)
+	cfg.enqueueFanout(ctx, chirp)
+
+	resp, err := cfg.renderChirp(ctx, chirp)
 	if err != nil {
-		respondDatabaseError(w, err)
-		return
+		return chirpResponse{}, err
 	}
+	cfg.broker.publishChirp(resp)
+	cfg.emitWebhookEvent(ctx, webhookChirpCreated, resp)
+	cfg.analytics.track(eventChirpCreated, chirp.AuthorId)
+	cfg.metrics.inc(counterChirps)
+	return resp, nil
+}
+
+// announceDelete tells the live stream and webhooks that chirp was deleted.
+func (cfg *apiConfig) announceDelete(ctx context.Context, chirp database.Chirp) {
+	cfg.broker.publishDelete(chirp)
+	cfg.emitWebhookEvent(ctx, webhookChirpDeleted, map[string]int{"chirp_id": chirp.Id, "author_id": chirp.AuthorId})
+}
+
+// getChirpsHandler lists chirps, newest first unless sort is asc. They can
+// be narrowed to an author, a tag, those containing the text q, and a window
+// of time: since and until are RFC 3339 times, since inclusive and until
+// exclusive. Searching with q needs a store that can search, and answers
+// 501 on the others.
+func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "desc"
+	}
 	id := r.URL.Query().Get("author_id")
-	var chirps []database.Chirp
+	tag := r.URL.Query().Get("tag")
+	query := r.URL.Query().Get("q")
+	since, err := parseTimeParam(r, "since")
+	if err != nil {
+		respondValidationError(w, err.Error())
+		return
+	}
+	until, err := parseTimeParam(r, "until")
+	if err != nil {
+		respondValidationError(w, err.Error())
+		return
+	}
+	page, limit, ok := cfg.listingPage(w, r)
+	if !ok {
+		return
+	}
+	authorId := 0
 	if id != "" {
-		numericId, err := strconv.Atoi(id)
+		authorId, err = strconv.Atoi(id)
 		if err != nil {
 			respondStrconvError(w, err)
 			return
 		}
-		chirps, err = db.GetChirpsFromId(numericId, sort)
-		if err != nil {
-			respondDataFetchError(w, err)
-			return
-		}
-	} else {
-		chirps, err = db.GetChirps(sort)
-		if err != nil {
-			respondDataFetchError(w, err)
-			return
-		}
+	}
+	if query != "" && !cfg.capabilities().Search {
+		respondNotImplemented(w, "this database cannot search chirps")
+		return
+	}
+	var chirps []database.Chirp
+	switch {
+	case query != "":
+		chirps, err = cfg.store(r.Context()).SearchChirps(query, sort)
+	case tag != "":
+		chirps, err = cfg.store(r.Context()).GetChirpsByTag(tag, sort)
+	case id != "":
+		chirps, err = cfg.store(r.Context()).GetChirpsFromId(authorId, sort)
+	default:
+		chirps, err = cfg.store(r.Context()).GetChirps(sort)
+	}
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	// The store applied one filter; the rest are applied here. A search
+	// leaves the tag to filter too.
+	searchedTag := ""
+	if query != "" {
+		searchedTag = database.NormalizeTag(tag)
+	}
+	chirps = slices.DeleteFunc(chirps, func(chirp database.Chirp) bool {
+		return (id != "" && chirp.AuthorId != authorId) || (searchedTag != "" && !slices.Contains(chirp.Tags, searchedTag)) ||
+			(!since.IsZero() && chirp.CreatedAt.Before(since)) || (!until.IsZero() && !chirp.CreatedAt.Before(until))
+	})
+	chirps, more := paginate(chirps, page, limit)
+	if more {
+		setNextPageLink(w, r, page, limit)
 	}
 
-	data, err := json.Marshal(chirps)
+	resp, err := cfg.renderChirps(r.Context(), chirps)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
 	if err != nil {
 		respondJSONMarshalError(w, err)
 		return
@@ -210,19 +856,57 @@ func getChirpsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func getChirpIdHandler(w http.ResponseWriter, r *http.Request) {
-	urlParam := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(urlParam)
+// parseTimeParam reads the RFC 3339 time in query parameter name, returning
+// the zero time when it is absent.
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 time such as 2006-01-02T15:04:05Z", name)
+	}
+	return t, nil
+}
+
+func (cfg *apiConfig) getChirpIdHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := cfg.chirpIdParam(r)
 	if err != nil {
-		respondParseURLError(w, err)
+		respondDataFetchError(w, err)
 		return
 	}
-	db, err := database.NewDB("./database.gob")
+	chirp, ok, err := cfg.store(r.Context()).GetChirp(id)
 	if err != nil {
-		respondDatabaseError(w, err)
+		respondDataFetchError(w, err)
+		return
+	}
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+	resp, err := cfg.renderChirp(r.Context(), chirp)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) getChirpRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
 		return
 	}
-	chirp, ok, err := db.GetChirp(id)
+	_, ok, err := cfg.store(r.Context()).GetChirp(id)
 	if err != nil {
 		respondDataFetchError(w, err)
 		return
@@ -231,7 +915,21 @@ func getChirpIdHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(404)
 		return
 	}
-	data, err := json.Marshal(chirp)
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "asc"
+	}
+	replies, err := cfg.store(r.Context()).GetReplies(id, sort)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	resp, err := cfg.renderChirps(r.Context(), replies)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
 	if err != nil {
 		respondJSONMarshalError(w, err)
 		return
@@ -241,28 +939,30 @@ func getChirpIdHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func postUsersHandler(w http.ResponseWriter, r *http.Request) {
+func (cfg *apiConfig) postUsersHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	decoder := json.NewDecoder(r.Body)
+	decoder := newJSONDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
 		respondParamsDecodingError(w, err)
 		return
 	}
-	db, err := database.NewDB("./database.gob")
-	if err != nil {
-		respondDatabaseError(w, err)
+	if !cfg.checkPassword(w, params.Password) {
 		return
 	}
-	user, err := db.CreateUser(params.Email, params.Password)
+	user, err := cfg.store(r.Context()).CreateUser(params.Email, params.Password)
 	if err != nil {
 		respondDataWriteError(w, err)
 		return
 	}
+	cfg.sendVerification(r.Context(), user)
+	cfg.analytics.track(eventSignup, user.Id)
+	cfg.metrics.inc(counterUsers)
+	cfg.recordSecurityEvent(r, user.Id, database.SecuritySignup)
 	data, err := json.Marshal(user)
 	if err != nil {
 		respondJSONMarshalError(w, err)
@@ -277,57 +977,135 @@ func (cfg *apiConfig) postLoginHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		TOTPCode string `json:"totp_code"`
+		// RememberMe asks for a long-lived session whose refresh token is
+		// set in a cookie.
+		RememberMe bool `json:"remember_me"`
 	}
-	decoder := json.NewDecoder(r.Body)
+	decoder := newJSONDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
 		respondParamsDecodingError(w, err)
 		return
 	}
-	db, err := database.NewDB("./database.gob")
+	format, ok := cfg.requestTokenFormat(w, r)
+	if !ok {
+		return
+	}
+	if params.RememberMe && !cfg.remember.enabled() {
+		respondNotImplemented(w, errRememberMeNotConfigured.Error())
+		return
+	}
+	now, ip := time.Now(), clientIP(r)
+	lockedUntil, err := cfg.loginLockout(r.Context(), params.Email, ip, now)
 	if err != nil {
-		respondDatabaseError(w, err)
+		respondDataFetchError(w, err)
 		return
 	}
-	if err = db.ComparePasswords(params.Password, params.Email); err != nil { // TODO: Better error handling. ErrUserDoesNotExist should return a 404
+	if lockedUntil != nil {
+		respondLockedOut(w, *lockedUntil, now)
+		return
+	}
+	if err = cfg.store(r.Context()).ComparePasswords(params.Password, params.Email); err != nil { // TODO: Better error handling. ErrUserDoesNotExist should return a 404
 		log.Printf(err.Error())
+		if user, err := cfg.store(r.Context()).GetUser(params.Email); err == nil {
+			cfg.recordSecurityEvent(r, user.Id, database.SecurityLoginFailed)
+		}
+		if cfg.loginFailed(w, r, params.Email, ip, now) {
+			return
+		}
 		w.WriteHeader(401)
 		return
 	}
 
+	user, err := cfg.store(r.Context()).GetUser(params.Email)
+	if err != nil {
+		respondDatabaseError(w, err)
+		return
+	}
+	ok, err = cfg.secondFactor(r, user, params.TOTPCode)
+	if err != nil {
+		respondError(w, "Error checking the second factor", err)
+		return
+	}
+	if !ok && params.TOTPCode == "" {
+		respondSignInRequired(w, "totp_code required")
+		return
+	}
+	if !ok {
+		cfg.recordSecurityEvent(r, user.Id, database.SecurityLoginFailed)
+		if cfg.loginFailed(w, r, params.Email, ip, now) {
+			return
+		}
+		respondSignInRequired(w, "invalid totp_code")
+		return
+	}
+	if err := cfg.resetLoginFailures(r.Context(), params.Email); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if user.DeletionRequestedAt != nil {
+		// Logging in again during the grace period keeps the account.
+		if err := cfg.store(r.Context()).CancelUserDeletion(user.Id); err != nil {
+			respondDataWriteError(w, err)
+			return
+		}
+	}
+	cfg.respondLogin(w, r, format, user, params.RememberMe)
+}
+
+// respondLogin starts a session for user and answers with its tokens in
+// format, the user's details included in the chirpy format. A remembered
+// session's refresh token goes in the remember-me cookie instead.
+func (cfg *apiConfig) respondLogin(w http.ResponseWriter, r *http.Request, format string, user database.User, remember bool) {
 	type returnVal struct {
 		IsChirpyRed  bool   `json:"is_chirpy_red"`
+		Verified     bool   `json:"verified"`
 		Email        string `json:"email"`
 		Id           int    `json:"id"`
 		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
-	user, err := db.GetUser(params.Email)
-	if err == database.ErrUserDoesNotExist {
-		w.WriteHeader(404)
-		return
+		RefreshToken string `json:"refresh_token,omitempty"`
+		tokenExpiry
 	}
-	if err != nil {
-		respondDatabaseError(w, err)
-		return
-	}
-	accessToken, err := cfg.createSignedAccessToken(user.Id)
+	issuedAt := time.Now()
+	accessToken, err := cfg.tokens.NewAccessToken(user.Id, userRoles(user)...)
 	if err != nil {
 		respondAccessTokenError(w, err)
 		return
 	}
-	refreshToken, err := cfg.createSignedRefreshToken(user.Id)
+	var session database.Session
+	var refreshToken string
+	if remember {
+		session, refreshToken, err = cfg.store(r.Context()).CreateCappedSession(user.Id, cfg.remember.ttl, cfg.remember.maxAge)
+	} else {
+		session, refreshToken, err = cfg.store(r.Context()).CreateSession(user.Id, auth.RefreshTokenTTL)
+	}
 	if err != nil {
 		respondRefreshTokenError(w, err)
 		return
 	}
+	cfg.recordSecurityEvent(r, user.Id, database.SecurityLogin)
+	if !mirrored(r.Context()) {
+		cfg.metrics.inc(counterLogins)
+	}
+	if remember {
+		cfg.setRememberCookie(w, r, refreshToken, session.ExpiresAt)
+		refreshToken = ""
+	}
+	expiry := newTokenExpiry(issuedAt, session)
+	if format == tokenFormatOAuth2 {
+		respondOAuth2Token(w, accessToken, refreshToken, expiry)
+		return
+	}
 	resp := returnVal{
 		IsChirpyRed:  user.IsChirpyRed,
+		Verified:     user.Verified,
 		Email:        user.Email,
 		Id:           user.Id,
 		Token:        accessToken,
 		RefreshToken: refreshToken,
+		tokenExpiry:  expiry,
 	}
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -340,27 +1118,8 @@ func (cfg *apiConfig) postLoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) updateUserCredsHandler(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
-	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-	issuer, err := parsedToken.Claims.GetIssuer()
-	if err != nil {
-		respondParseTokenError(w, err)
-		return
-	}
-	if issuer != "chirpy-access" {
-		w.WriteHeader(401)
-		return
-	}
-	id, err := parsedToken.Claims.GetSubject()
-	if err != nil {
-		respondParseTokenError(w, err)
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
 
@@ -368,31 +1127,46 @@ func (cfg *apiConfig) updateUserCredsHandler(w http.ResponseWriter, r *http.Requ
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
-	decoder := json.NewDecoder(r.Body)
+	decoder := newJSONDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondParamsDecodingError(w, err)
 		return
 	}
+	if !cfg.checkPassword(w, params.Password) {
+		return
+	}
 
 	type returnVal struct {
 		Email string `json:"email"`
 		Id    int    `json:"id"`
 	}
-	db, err := database.NewDB("./database.gob")
+	previous, err := cfg.store(r.Context()).GetUserById(userId)
 	if err != nil {
-		respondDatabaseError(w, err)
+		respondDataFetchError(w, err)
+		return
+	}
+	if err := cfg.store(r.Context()).UpdateUser(userId, params.Email, params.Password); err != nil {
+		respondDataWriteError(w, err)
 		return
 	}
-	numericId, err := strconv.Atoi(id)
+	user, err := cfg.store(r.Context()).GetUserById(userId)
 	if err != nil {
-		respondStrconvError(w, err)
+		respondDataFetchError(w, err)
+		return
+	}
+	cfg.recordSecurityEvent(r, userId, database.SecurityPasswordChanged)
+	if user.Email != previous.Email {
+		cfg.recordSecurityEvent(r, userId, database.SecurityEmailChanged)
+		// A new address has to be verified again.
+		if !user.Verified {
+			cfg.sendVerification(r.Context(), user)
+		}
 	}
-	db.UpdateUser(numericId, params.Email, params.Password)
 	resp := returnVal{
-		Email: params.Email,
-		Id:    numericId,
+		Email: user.Email,
+		Id:    userId,
 	}
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -405,58 +1179,62 @@ func (cfg *apiConfig) updateUserCredsHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (cfg *apiConfig) postRefreshHandler(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
-	if err != nil {
-		w.WriteHeader(401)
+	format, ok := cfg.requestTokenFormat(w, r)
+	if !ok {
 		return
 	}
-	issuer, err := parsedToken.Claims.GetIssuer()
-	if err != nil {
-		respondParseTokenError(w, err)
-		return
+	token, remembered := cfg.refreshToken(r)
+	ttl := auth.RefreshTokenTTL
+	if remembered {
+		ttl = cfg.remember.ttl
 	}
-	if issuer != "chirpy-refresh" {
+	session, refreshToken, err := cfg.store(r.Context()).RotateSession(token, ttl)
+	if errors.Is(err, database.ErrSessionReplayed) {
+		log.Printf("Refresh token reuse detected, ending the session")
+		if remembered {
+			clearRememberCookie(w)
+		}
 		w.WriteHeader(401)
 		return
 	}
-	db, err := database.NewDB("./database.gob")
-	if err != nil {
-		respondDatabaseError(w, err)
+	if errors.Is(err, database.ErrSessionDoesNotExist) {
+		if remembered {
+			clearRememberCookie(w)
+		}
+		w.WriteHeader(401)
 		return
 	}
-	revoked, err := db.IsTokenRevoked(token)
 	if err != nil {
-		respondDatabaseError(w, err)
+		respondDataWriteError(w, err)
 		return
 	}
-	if revoked {
-		w.WriteHeader(401)
-		return
+	if remembered {
+		cfg.setRememberCookie(w, r, refreshToken, session.ExpiresAt)
+		refreshToken = ""
 	}
 
 	type returnVal struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		tokenExpiry
 	}
-	id, err := parsedToken.Claims.GetSubject()
+	user, err := cfg.store(r.Context()).GetUserById(session.UserId)
 	if err != nil {
-		respondParseTokenError(w, err)
+		respondDataFetchError(w, err)
 		return
 	}
-	numericId, err := strconv.Atoi(id)
+	issuedAt := time.Now()
+	newAccessToken, err := cfg.tokens.NewAccessToken(user.Id, userRoles(user)...)
 	if err != nil {
-		respondStrconvError(w, err)
+		respondAccessTokenError(w, err)
 		return
 	}
-	newAccessToken, err := cfg.createSignedAccessToken(numericId)
-	if err != nil {
-		respondAccessTokenError(w, err)
+	expiry := newTokenExpiry(issuedAt, session)
+	if format == tokenFormatOAuth2 {
+		respondOAuth2Token(w, newAccessToken, refreshToken, expiry)
 		return
 	}
-	resp := returnVal{Token: newAccessToken}
+	resp := returnVal{Token: newAccessToken, RefreshToken: refreshToken, tokenExpiry: expiry}
 	data, err := json.Marshal(resp)
 	if err != nil {
 		respondJSONMarshalError(w, err)
@@ -468,111 +1246,145 @@ func (cfg *apiConfig) postRefreshHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (cfg *apiConfig) postRevokeHandler(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
-	if err != nil {
+	token, remembered := cfg.refreshToken(r)
+	if remembered {
+		clearRememberCookie(w)
+	}
+	err := cfg.store(r.Context()).DeleteSession(token)
+	if errors.Is(err, database.ErrSessionDoesNotExist) {
 		w.WriteHeader(401)
 		return
 	}
-	issuer, err := parsedToken.Claims.GetIssuer()
 	if err != nil {
-		respondParseTokenError(w, err)
+		respondDataWriteError(w, err)
 		return
 	}
-	if issuer != "chirpy-refresh" {
-		w.WriteHeader(401)
+	w.WriteHeader(200)
+}
+
+// deleteUserSessionsHandler logs the user out everywhere. Access tokens
+// already handed out stay valid until they expire.
+func (cfg *apiConfig) deleteUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
-	db, err := database.NewDB("./database.gob")
-	if err != nil {
-		respondDatabaseError(w, err)
+	if err := cfg.store(r.Context()).DeleteUserSessions(userId); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.recordSecurityEvent(r, userId, database.SecuritySessionsRevoked)
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
+	requesterId, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
-	revoked, err := db.IsTokenRevoked(token)
+	chirpIdToDelete, err := cfg.chirpIdParam(r)
 	if err != nil {
-		respondDatabaseError(w, err)
+		respondDataFetchError(w, err)
 		return
 	}
-	if revoked {
-		w.WriteHeader(409) // We're indicating a conflict. The token they want to revoke was already revoked
+	chirp, found, err := cfg.store(r.Context()).GetChirp(chirpIdToDelete)
+	if err != nil {
+		respondDataFetchError(w, err)
 		return
 	}
-	if err := db.RevokeRefreshToken(token); err != nil {
-		respondUnexpectedError(w, err) // We would have already checked for all possible errors this could be, so something unexpected would have to happend to cause this.
+	if !found {
+		w.WriteHeader(404)
 		return
 	}
-	w.WriteHeader(200)
-}
-
-func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request) {
-	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(cfg.jwtSecret), nil
-	})
-	if err != nil {
-		w.WriteHeader(401)
+	if err := hooks.PreDelete(hooks.Chirp{Id: chirp.Id, AuthorId: chirp.AuthorId, Body: chirp.Body}, requesterId); err != nil {
+		respondHookError(w, err)
 		return
 	}
-	issuer, err := parsedToken.Claims.GetIssuer()
+	err = cfg.store(r.Context()).DeleteChirp(chirpIdToDelete, requesterId)
 	if err != nil {
-		respondParseTokenError(w, err)
+		respondDatabaseError(w, err)
 		return
 	}
-	if issuer != "chirpy-access" {
-		w.WriteHeader(401)
+	cfg.announceDelete(r.Context(), chirp)
+	w.WriteHeader(200)
+}
+
+func (cfg *apiConfig) putChirpHandler(w http.ResponseWriter, r *http.Request) {
+	requesterId, ok := cfg.authenticate(w, r)
+	if !ok {
 		return
 	}
-	urlParam := chi.URLParam(r, "id")
-	if err != nil {
-		respondParseURLError(w, err)
+	if cfg.rejectSuspended(w, r, requesterId) {
 		return
 	}
-	chirpIdToDelete, err := strconv.Atoi(urlParam)
+	chirpIdToUpdate, err := cfg.chirpIdParam(r)
 	if err != nil {
-		respondStrconvError(w, err)
+		respondDataFetchError(w, err)
 		return
 	}
-	requesterId, err := parsedToken.Claims.GetSubject()
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
 	if err != nil {
-		respondParseTokenError(w, err)
+		respondParamsDecodingError(w, err)
 		return
 	}
-	db, err := database.NewDB("./database.gob")
-	if err != nil {
-		respondDatabaseError(w, err)
+	if reason := cfg.validateChirpBody(params.Body); reason != "" {
+		respondValidationError(w, reason)
 		return
 	}
-	numericRequesterId, err := strconv.Atoi(requesterId)
-	if err != nil {
-		respondStrconvError(w, err)
+
+	body, censored := cfg.profanity.clean(params.Body)
+	draft := hooks.ChirpDraft{Id: chirpIdToUpdate, AuthorId: requesterId, Body: body}
+	if err := hooks.PreCreate(&draft); err != nil {
+		respondHookError(w, err)
 		return
 	}
-	err = db.DeleteChirp(chirpIdToDelete, numericRequesterId)
-	if err == database.ErrChirpDoesNotExist {
-		w.WriteHeader(404)
+	chirp, err := cfg.store(r.Context()).UpdateChirp(chirpIdToUpdate, requesterId, draft.Body, censored)
+	if err != nil {
+		respondDataWriteError(w, err)
 		return
 	}
-	if err == database.ErrAuthorization {
-		w.WriteHeader(403)
+	cfg.shortenLinks(r.Context(), chirp)
+	resp, err := cfg.renderChirp(r.Context(), chirp)
+	if err != nil {
+		respondRenderError(w, err)
 		return
 	}
+	data, err := json.Marshal(resp)
 	if err != nil {
-		respondDatabaseError(w, err)
+		respondJSONMarshalError(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
+	w.Write(data)
 }
 
 func (cfg *apiConfig) postPolkaWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	apiKey := strings.TrimPrefix(r.Header.Get("Authorization"), "ApiKey ")
 	if cfg.polkaApiKey != apiKey {
+		log.Printf("Rejected Polka webhook with a wrong API key")
 		w.WriteHeader(401)
 		return
 	}
+	if cfg.polkaSecret != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondParamsDecodingError(w, err)
+			return
+		}
+		if reason := cfg.verifyPolkaSignature(r.Header, body, time.Now()); reason != "" {
+			log.Printf("Rejected Polka webhook: %s", reason)
+			w.WriteHeader(401)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
 
 	type parameters struct {
 		Event string `json:"event"`
@@ -580,6 +1392,8 @@ func (cfg *apiConfig) postPolkaWebhookHandler(w http.ResponseWriter, r *http.Req
 			UserId int `json:"user_id"`
 		} `json:"data"`
 	}
+	// Polka's events carry more than we use, and may grow fields at any
+	// time, so unknown fields are ignored here.
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
@@ -591,75 +1405,12 @@ func (cfg *apiConfig) postPolkaWebhookHandler(w http.ResponseWriter, r *http.Req
 		w.WriteHeader(200)
 		return
 	}
-	db, err := database.NewDB("./database.gob")
-	if err != nil {
-		respondDatabaseError(w, err)
-		return
-	}
-	if err := db.UpgradeUser(params.Data.UserId); err != nil {
+	if err := cfg.store(r.Context()).UpgradeUser(params.Data.UserId); err != nil {
 		w.WriteHeader(404)
 		return
 	}
+	cfg.emitWebhookEvent(r.Context(), webhookUserUpgraded, map[string]int{"user_id": params.Data.UserId})
+	cfg.analytics.track(eventUpgrade, params.Data.UserId)
 	w.WriteHeader(200)
 
 }
-
-func middlewareCors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
-}
-
-func (cfg *apiConfig) createSignedAccessToken(id int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy-access",
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
-		Subject:   strconv.Itoa(id),
-	})
-	signedToken, err := token.SignedString([]byte(cfg.jwtSecret))
-	if err != nil {
-		return "", err
-	}
-	return signedToken, nil
-}
-
-func (cfg *apiConfig) createSignedRefreshToken(id int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy-refresh",
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add((60 * 24) * time.Hour)),
-		Subject:   strconv.Itoa(id),
-	})
-	signedToken, err := token.SignedString([]byte(cfg.jwtSecret))
-	if err != nil {
-		return "", err
-	}
-	return signedToken, nil
-}
-
-func cleanChirp(chirp string) string {
-	chirpWords := strings.Split(chirp, " ")
-	var cleanChirpWords []string
-	for _, word := range chirpWords {
-		cleanChirpWords = append(cleanChirpWords, cleanWord(word))
-	}
-	return strings.Join(cleanChirpWords, " ")
-}
-
-func cleanWord(word string) string {
-	dirtyWords := []string{"kerfuffle", "sharbert", "fornax"}
-	for _, dirtyWord := range dirtyWords {
-		if strings.ToLower(word) == dirtyWord {
-			return "****"
-		}
-	}
-	return word
-}
@@ -2,20 +2,29 @@ package main
 
 import (
 	"testing"
+
+	"github.com/avearmin/chirpy/internal/database"
 )
 
 func Test(t *testing.T) {
-	runCleanChirpTest(t, "This kerfuffle is crazy!", "This **** is crazy!")
-	runCleanChirpTest(t, "Oh sharbert", "Oh ****")
-	runCleanChirpTest(t, "FORNAX THAT!", "**** THAT!")
-	runCleanChirpTest(t, "keRFuffle shARBert FORNax", "**** **** ****")
-	runCleanChirpTest(t, "My mama taught me not to curse", "My mama taught me not to curse")
+	filter := newProfanityFilter(database.DefaultBlockedWords)
+	runCleanChirpTest(t, filter, "This kerfuffle is crazy!", "This **** is crazy!")
+	runCleanChirpTest(t, filter, "Oh sharbert", "Oh ****")
+	runCleanChirpTest(t, filter, "FORNAX THAT!", "**** THAT!")
+	runCleanChirpTest(t, filter, "keRFuffle shARBert FORNax", "**** **** ****")
+	runCleanChirpTest(t, filter, "My mama taught me not to curse", "My mama taught me not to curse")
+
+	filter.set(append(database.DefaultBlockedWords, "what the fork*", "dang*"))
+	runCleanChirpTest(t, filter, "What the forking mess", "**** **** **** mess")
+	runCleanChirpTest(t, filter, "what the spoon", "what the spoon")
+	runCleanChirpTest(t, filter, "Dangit and DANG", "**** and ****")
+	runCleanChirpTest(t, filter, "endanger", "endanger")
 }
 
-func runCleanChirpTest(t *testing.T, base, expecting string) {
-	t.Logf("Starting test for cleanChirp with: \"%s\", and expecting: \"%s\"", base, expecting)
-	got := cleanChirp(base)
-	if got != expecting {
-		t.Errorf("Expecting: %s, but got: %s", expecting, got)
+func runCleanChirpTest(t *testing.T, filter *profanityFilter, base, expecting string) {
+	t.Logf("Starting test for clean with: \"%s\", and expecting: \"%s\"", base, expecting)
+	got, censored := filter.clean(base)
+	if got != expecting || censored != (base != expecting) {
+		t.Errorf("Expecting: %s (censored: %t), but got: %s (censored: %t)", expecting, base != expecting, got, censored)
 	}
 }
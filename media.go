@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/avearmin/chirpy/internal/blobstore"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAttachments is how many media a single chirp may carry.
+const maxAttachments = 4
+
+// mediaTypes are the content types accepted for upload, as sniffed from the
+// file itself rather than taken from the client.
+var mediaTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+func (cfg *apiConfig) postMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	media, ok := cfg.receiveMedia(w, r, userId)
+	if !ok {
+		return
+	}
+	respondMedia(w, media)
+}
+
+// receiveMedia stores the file uploaded in the "file" field of a multipart
+// form, with the alt text in its "alt_text" field, answering the request
+// if it cannot.
+func (cfg *apiConfig) receiveMedia(w http.ResponseWriter, r *http.Request, userId int) (database.Media, bool) {
+	// Leave room for the multipart framing and the other form fields.
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.mediaMaxBytes+1<<20)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondValidationError(w, "expected a multipart form with the upload in a \"file\" field")
+		return database.Media{}, false
+	}
+	defer file.Close()
+
+	media, reason, err := cfg.storeMedia(r.Context(), userId, file, strings.TrimSpace(r.FormValue("alt_text")))
+	if errors.Is(err, errMediaTooLarge) {
+		w.WriteHeader(413)
+		return database.Media{}, false
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return database.Media{}, false
+	}
+	if reason != "" {
+		respondValidationError(w, reason)
+		return database.Media{}, false
+	}
+	return media, true
+}
+
+var errMediaTooLarge = errors.New("media is too large")
+
+// storeMedia saves the file read from r as media of the user. The returned
+// reason is non-empty when the file is not media the server accepts, and
+// errMediaTooLarge reports a file over the size limit.
+func (cfg *apiConfig) storeMedia(ctx context.Context, userId int, r io.Reader, altText string) (database.Media, string, error) {
+	sniffer := bufio.NewReaderSize(r, 512)
+	head, _ := sniffer.Peek(512)
+	contentType := http.DetectContentType(head)
+	if !mediaTypes[contentType] {
+		return database.Media{}, fmt.Sprintf("unsupported media type %s", contentType), nil
+	}
+
+	key, err := newBlobKey()
+	if err != nil {
+		return database.Media{}, "", err
+	}
+	hash := sha256.New()
+	size, err := cfg.blobs.Put(key, io.TeeReader(io.LimitReader(sniffer, cfg.mediaMaxBytes+1), hash))
+	if err != nil {
+		return database.Media{}, "", err
+	}
+	if size > cfg.mediaMaxBytes {
+		cfg.blobs.Delete(key)
+		return database.Media{}, "", errMediaTooLarge
+	}
+
+	media, err := cfg.store(ctx).CreateMedia(database.Media{
+		OwnerId:     userId,
+		Key:         key,
+		Hash:        hex.EncodeToString(hash.Sum(nil)),
+		ContentType: contentType,
+		Size:        size,
+		AltText:     altText,
+	})
+	if err != nil {
+		cfg.blobs.Delete(key)
+		return database.Media{}, "", err
+	}
+	if media.Key != key {
+		// The same file was already stored, so this copy is not needed.
+		cfg.blobs.Delete(key)
+	}
+	return media, "", nil
+}
+
+// deleteMedia deletes media, and its blob once no other media shares it.
+func (cfg *apiConfig) deleteMedia(ctx context.Context, id string) error {
+	orphaned, err := cfg.store(ctx).DeleteMedia(id)
+	if err != nil {
+		return err
+	}
+	if orphaned == "" {
+		return nil
+	}
+	if err := cfg.blobs.Delete(orphaned); err != nil && err != blobstore.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func respondMedia(w http.ResponseWriter, media database.Media) {
+	data, err := json.Marshal(media)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+func newBlobKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (cfg *apiConfig) getMediaHandler(w http.ResponseWriter, r *http.Request) {
+	media, err := cfg.store(r.Context()).GetMedia(chi.URLParam(r, "id"))
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	blob, err := cfg.blobs.Open(media.Key)
+	if err == blobstore.ErrNotFound {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	defer blob.Close()
+	w.Header().Set("Content-Type", media.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(200)
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error serving media %s: %s", media.Id, err)
+	}
+}
+
+// deleteMediaHandler deletes one of the user's uploads. Chirps it is
+// attached to keep the attachment, which no longer loads.
+func (cfg *apiConfig) deleteMediaHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	media, err := cfg.store(r.Context()).GetMedia(chi.URLParam(r, "id"))
+	if err == nil && media.OwnerId != userId {
+		err = database.ErrMediaDoesNotExist
+	}
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if err := cfg.deleteMedia(r.Context(), media.Id); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// mediaRequest is how a client attaches media to a chirp. AltText, when
+// given, replaces the alt text saved with the upload.
+type mediaRequest struct {
+	Id      string `json:"id"`
+	AltText string `json:"alt_text"`
+}
+
+// attachMedia turns the media a user asked to attach into attachments. The
+// returned reason is non-empty when the request is invalid and explains
+// what to fix.
+func (cfg *apiConfig) attachMedia(ctx context.Context, userId int, requested []mediaRequest) ([]database.Attachment, string, error) {
+	if len(requested) > maxAttachments {
+		return nil, fmt.Sprintf("a chirp can have at most %d media attachments", maxAttachments), nil
+	}
+	attachments := make([]database.Attachment, 0, len(requested))
+	for _, req := range requested {
+		media, err := cfg.store(ctx).GetMedia(req.Id)
+		if errors.Is(err, database.ErrMediaDoesNotExist) || (err == nil && media.OwnerId != userId) {
+			return nil, fmt.Sprintf("media %s does not exist", req.Id), nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		altText := strings.TrimSpace(req.AltText)
+		if altText == "" {
+			altText = media.AltText
+		}
+		if altText == "" && cfg.requireAltText {
+			return nil, fmt.Sprintf("media %s needs alt_text describing it for people using screen readers", media.Id), nil
+		}
+		attachments = append(attachments, database.Attachment{
+			Id:          media.Id,
+			URL:         "/media/" + media.Id,
+			ContentType: media.ContentType,
+			AltText:     altText,
+		})
+	}
+	return attachments, "", nil
+}
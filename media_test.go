@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/avearmin/chirpy/internal/blobstore"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestMedia(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	described, _ := db.CreateMedia(database.Media{OwnerId: 1, ContentType: "image/png", AltText: "A cat"})
+	bare, _ := db.CreateMedia(database.Media{OwnerId: 1, ContentType: "image/png"})
+	someoneElses, _ := db.CreateMedia(database.Media{OwnerId: 2, ContentType: "image/png", AltText: "A dog"})
+
+	cfg := &apiConfig{db: db}
+	runAttachMediaTest(t, cfg, []mediaRequest{{Id: described.Id}, {Id: bare.Id}}, true)
+	runAttachMediaTest(t, cfg, []mediaRequest{{Id: someoneElses.Id}}, false)
+	runAttachMediaTest(t, cfg, make([]mediaRequest, maxAttachments+1), false)
+
+	cfg.requireAltText = true
+	runAttachMediaTest(t, cfg, []mediaRequest{{Id: described.Id}}, true)
+	runAttachMediaTest(t, cfg, []mediaRequest{{Id: bare.Id}}, false)
+	runAttachMediaTest(t, cfg, []mediaRequest{{Id: bare.Id, AltText: "A blank canvas"}}, true)
+}
+
+func runAttachMediaTest(t *testing.T, cfg *apiConfig, requested []mediaRequest, expecting bool) {
+	t.Logf("Starting test for attachMedia with: %v (alt text required: %t), and expecting: %t", requested, cfg.requireAltText, expecting)
+	attachments, reason, err := cfg.attachMedia(context.Background(), 1, requested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reason == ""; got != expecting {
+		t.Errorf("Expecting: %t, but got: %t (%s)", expecting, got, reason)
+	}
+	if expecting && len(attachments) != len(requested) {
+		t.Errorf("Expecting: %d, but got: %d", len(requested), len(attachments))
+	}
+}
+
+func TestMediaDedup(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	blobs, err := blobstore.NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, blobs: blobs, mediaMaxBytes: 1 << 10}
+	file := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 24)...)
+
+	t.Logf("Starting test for storeMedia with: the same file from two users, and expecting: one blob")
+	first, _, err := cfg.storeMedia(context.Background(), 1, bytes.NewReader(file), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := cfg.storeMedia(context.Background(), 2, bytes.NewReader(file), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := countBlobs(t, dir); stored != 1 || first.Id == second.Id {
+		t.Errorf("Expecting: 1 blob for two media, but got: %d blobs for %s and %s", stored, first.Id, second.Id)
+	}
+
+	t.Logf("Starting test for deleteMedia with: each of the two, and expecting: the blob kept until the last is gone")
+	if err := cfg.deleteMedia(context.Background(), first.Id); err != nil {
+		t.Fatal(err)
+	}
+	if stored := countBlobs(t, dir); stored != 1 {
+		t.Errorf("Expecting: 1 blob, but got: %d", stored)
+	}
+	if err := cfg.deleteMedia(context.Background(), second.Id); err != nil {
+		t.Fatal(err)
+	}
+	if stored := countBlobs(t, dir); stored != 0 {
+		t.Errorf("Expecting: 0 blobs, but got: %d", stored)
+	}
+}
+
+func countBlobs(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// mediaGCReport lists the orphaned media a collection found, and whether
+// they were deleted or only reported.
+type mediaGCReport struct {
+	DryRun       bool             `json:"dry_run"`
+	GraceEndedAt time.Time        `json:"grace_ended_at"`
+	Media        []database.Media `json:"media"`
+	Count        int              `json:"count"`
+	Bytes        int64            `json:"bytes"`
+}
+
+// collectOrphanedMedia finds the media nothing refers to that were uploaded
+// longer than the grace period before now, which leaves users time to
+// attach what they just uploaded, and deletes them unless dryRun is set.
+// Bytes counts the media's sizes; blobs identical media still share are
+// kept, so less may be freed.
+func (cfg *apiConfig) collectOrphanedMedia(ctx context.Context, now time.Time, dryRun bool) (mediaGCReport, error) {
+	report := mediaGCReport{DryRun: dryRun, GraceEndedAt: now.Add(-cfg.mediaGCGrace).UTC()}
+	orphaned, err := cfg.store(ctx).GetOrphanedMedia(report.GraceEndedAt)
+	if err != nil {
+		return report, err
+	}
+	slices.SortFunc(orphaned, func(a, b database.Media) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	report.Media = orphaned
+	for _, media := range orphaned {
+		report.Count++
+		report.Bytes += media.Size
+		if dryRun {
+			continue
+		}
+		if err := cfg.deleteMedia(ctx, media.Id); err != nil {
+			return report, fmt.Errorf("deleting media %s: %w", media.Id, err)
+		}
+	}
+	return report, nil
+}
+
+// collectOrphanedMediaWorker deletes orphaned media every interval until
+// ctx is done.
+func (cfg *apiConfig) collectOrphanedMediaWorker(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			report, err := cfg.collectOrphanedMedia(ctx, now, false)
+			if err != nil {
+				return fmt.Errorf("collecting orphaned media: %w", err)
+			}
+			if report.Count > 0 {
+				log.Printf("Deleted %d orphaned media of %d bytes", report.Count, report.Bytes)
+			}
+		}
+	}
+}
+
+// getOrphanedMediaHandler reports what collecting orphaned media would
+// delete now, without deleting anything.
+func (cfg *apiConfig) getOrphanedMediaHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := cfg.collectOrphanedMedia(r.Context(), time.Now(), true)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	respondMediaGCReport(w, report)
+}
+
+// postCollectMediaHandler collects orphaned media now rather than waiting
+// for the worker.
+func (cfg *apiConfig) postCollectMediaHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := cfg.collectOrphanedMedia(r.Context(), time.Now(), false)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	respondMediaGCReport(w, report)
+}
+
+func respondMediaGCReport(w http.ResponseWriter, report mediaGCReport) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
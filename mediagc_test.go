@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/blobstore"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestCollectOrphanedMedia(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	blobs, err := blobstore.NewDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, blobs: blobs, mediaMaxBytes: 1 << 10, mediaGCGrace: time.Hour}
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 24)...)
+	orphan, _, err := cfg.storeMedia(context.Background(), 1, bytes.NewReader(png), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached, _, err := cfg.storeMedia(context.Background(), 1, bytes.NewReader(append(png, 1)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(database.Chirp{AuthorId: 1, Body: "Look", Media: []database.Attachment{{Id: attached.Id}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	runCollectOrphanedMediaTest(t, cfg, dir, time.Now(), true, 0, 2)
+	runCollectOrphanedMediaTest(t, cfg, dir, time.Now().Add(2*time.Hour), true, 1, 2)
+	runCollectOrphanedMediaTest(t, cfg, dir, time.Now().Add(2*time.Hour), false, 1, 1)
+	if _, err := db.GetMedia(orphan.Id); err == nil {
+		t.Errorf("Expecting: %s deleted, but got: it kept", orphan.Id)
+	}
+}
+
+func runCollectOrphanedMediaTest(t *testing.T, cfg *apiConfig, dir string, now time.Time, dryRun bool, expecting, blobsLeft int) {
+	t.Logf("Starting test for collectOrphanedMedia with: now %s and dry run %t, and expecting: %d orphaned, %d blobs left", now.Format(time.RFC3339), dryRun, expecting, blobsLeft)
+	report, err := cfg.collectOrphanedMedia(context.Background(), now, dryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Count != expecting || len(report.Media) != expecting {
+		t.Errorf("Expecting: %d orphaned, but got: %+v", expecting, report)
+	}
+	if stored := countBlobs(t, dir); stored != blobsLeft {
+		t.Errorf("Expecting: %d blobs, but got: %d", blobsLeft, stored)
+	}
+}
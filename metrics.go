@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// The counters kept per day in the store, so they survive restarts.
+const (
+	counterHits   = "hits"
+	counterChirps = "chirps_created"
+	counterUsers  = "users_registered"
+	counterLogins = "logins"
+)
+
+// dashboardCounters are the counters the admin dashboard charts, in order.
+var dashboardCounters = []struct {
+	name, title string
+}{
+	{counterHits, "Visits to /app"},
+	{counterChirps, "Chirps created"},
+	{counterUsers, "Users registered"},
+	{counterLogins, "Logins"},
+}
+
+const (
+	defaultMetricsDays = 30
+	maxMetricsDays     = 365
+)
+
+// metrics counts events in memory and adds them to the store's daily
+// counters on flush, so a page view does not cost a write. A nil *metrics
+// counts nothing.
+type metrics struct {
+	mux     sync.Mutex
+	pending map[[2]string]int // name and day -> count not flushed yet
+}
+
+func newMetrics() *metrics {
+	return &metrics{pending: make(map[[2]string]int)}
+}
+
+// inc counts one name today.
+func (m *metrics) inc(name string) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.pending[[2]string{name, database.CounterDay(time.Now())}]++
+}
+
+// unflushed returns the counts not flushed yet.
+func (m *metrics) unflushed() []database.DailyCounter {
+	if m == nil {
+		return nil
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	counters := make([]database.DailyCounter, 0, len(m.pending))
+	for key, count := range m.pending {
+		counters = append(counters, database.DailyCounter{Name: key[0], Day: key[1], Count: count})
+	}
+	return counters
+}
+
+// forget drops the counts of name not flushed yet.
+func (m *metrics) forget(name string) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for key := range m.pending {
+		if key[0] == name {
+			delete(m.pending, key)
+		}
+	}
+}
+
+// flush adds the pending counts to db. Counts it failed to add are kept for
+// the next flush.
+func (m *metrics) flush(db database.Storage) error {
+	if m == nil {
+		return nil
+	}
+	m.mux.Lock()
+	pending := m.pending
+	m.pending = make(map[[2]string]int)
+	m.mux.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	counters := make([]database.DailyCounter, 0, len(pending))
+	for key, count := range pending {
+		counters = append(counters, database.DailyCounter{Name: key[0], Day: key[1], Count: count})
+	}
+	err := db.AddCounters(counters)
+	if err != nil {
+		m.mux.Lock()
+		for key, count := range pending {
+			m.pending[key] += count
+		}
+		m.mux.Unlock()
+	}
+	return err
+}
+
+// flushMetricsWorker flushes the counts every interval, and once more when
+// ctx is done so a clean shutdown loses none.
+func (cfg *apiConfig) flushMetricsWorker(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return cfg.metrics.flush(cfg.db)
+		case <-ticker.C:
+			if err := cfg.metrics.flush(cfg.store(ctx)); err != nil {
+				log.Printf("Error saving metrics: %s", err)
+			}
+		}
+	}
+}
+
+// metricTotals returns every counter's count over all days, including the
+// counts not flushed yet.
+func (cfg *apiConfig) metricTotals(ctx context.Context) (map[string]int, error) {
+	totals, err := cfg.store(ctx).GetCounterTotals()
+	if err != nil {
+		return nil, err
+	}
+	for _, counter := range cfg.metrics.unflushed() {
+		totals[counter.Name] += counter.Count
+	}
+	return totals, nil
+}
+
+// metricsReport is the dashboard's data: the totals and the counts of each
+// of the last days, oldest first.
+type metricsReport struct {
+	Since  string         `json:"since"`
+	Totals map[string]int `json:"totals"`
+	Daily  []dailyMetrics `json:"daily"`
+}
+
+type dailyMetrics struct {
+	Day    string         `json:"day"`
+	Counts map[string]int `json:"counts"`
+}
+
+// metricsReport reports on the days days up to and including now's.
+func (cfg *apiConfig) metricsReport(ctx context.Context, days int, now time.Time) (metricsReport, error) {
+	totals, err := cfg.metricTotals(ctx)
+	if err != nil {
+		return metricsReport{}, err
+	}
+	report := metricsReport{Since: database.CounterDay(now.AddDate(0, 0, 1-days)), Totals: make(map[string]int)}
+	byDay := make(map[string]map[string]int, days)
+	for i := days - 1; i >= 0; i-- {
+		day := dailyMetrics{Day: database.CounterDay(now.AddDate(0, 0, -i)), Counts: make(map[string]int)}
+		for _, counter := range dashboardCounters {
+			day.Counts[counter.name] = 0
+		}
+		byDay[day.Day] = day.Counts
+		report.Daily = append(report.Daily, day)
+	}
+	for _, counter := range dashboardCounters {
+		report.Totals[counter.name] = totals[counter.name]
+	}
+	counters, err := cfg.store(ctx).GetCounters(report.Since)
+	if err != nil {
+		return metricsReport{}, err
+	}
+	for _, counter := range append(counters, cfg.metrics.unflushed()...) {
+		if counts, found := byDay[counter.Day]; found {
+			if _, charted := counts[counter.Name]; charted {
+				counts[counter.Name] += counter.Count
+			}
+		}
+	}
+	return report, nil
+}
+
+// metricsDays reads the days query parameter, answering 400 when it is not
+// a number of days the dashboard can show.
+func metricsDays(w http.ResponseWriter, r *http.Request) (int, bool) {
+	param := r.URL.Query().Get("days")
+	if param == "" {
+		return defaultMetricsDays, true
+	}
+	days, err := strconv.Atoi(param)
+	if err != nil || days < 1 || days > maxMetricsDays {
+		respondValidationError(w, "days must be a number from 1 to "+strconv.Itoa(maxMetricsDays))
+		return 0, false
+	}
+	return days, true
+}
+
+func (cfg *apiConfig) getMetricsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	days, ok := metricsDays(w, r)
+	if !ok {
+		return
+	}
+	report, err := cfg.metricsReport(r.Context(), days, time.Now())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// The dashboard draws each counter as a bar chart in inline SVG, so it
+// needs no scripts or assets.
+const (
+	chartBarWidth = 14
+	chartBarGap   = 2
+	chartHeight   = 120
+)
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<html>
+  <head>
+    <title>Chirpy Admin</title>
+    <style>
+      body { font-family: sans-serif; margin: 2em; }
+      svg { background: #f4f6f8; }
+      rect { fill: #1d9bf0; }
+    </style>
+  </head>
+  <body>
+    <h1>Welcome, Chirpy Admin</h1>
+    <p>Chirpy has been visited {{.Hits}} times!</p>
+    {{range .Charts}}
+    <section>
+      <h2>{{.Title}}</h2>
+      <p>{{.Recent}} in the last {{$.Days}} days, {{.Total}} in all</p>
+      <svg width="{{.Width}}" height="{{.Height}}" role="img" aria-label="{{.Title}} per day">
+        {{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Day}}: {{.Count}}</title></rect>
+        {{end}}
+      </svg>
+    </section>
+    {{end}}
+    <p><a href="/admin/metrics.json?days={{.Days}}">These numbers as JSON</a></p>
+  </body>
+</html>
+`))
+
+type dashboardChart struct {
+	Title         string
+	Total, Recent int
+	Width, Height int
+	Bars          []dashboardBar
+}
+
+type dashboardBar struct {
+	Day                 string
+	Count               int
+	X, Y, Width, Height int
+}
+
+// getMetricsHandler serves the admin dashboard: the visit count and a
+// chart of each counter per day, over ?days= days.
+func (cfg *apiConfig) getMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	days, ok := metricsDays(w, r)
+	if !ok {
+		return
+	}
+	report, err := cfg.metricsReport(r.Context(), days, time.Now())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	charts := make([]dashboardChart, len(dashboardCounters))
+	for i, counter := range dashboardCounters {
+		chart := dashboardChart{
+			Title:  counter.title,
+			Total:  report.Totals[counter.name],
+			Width:  len(report.Daily) * (chartBarWidth + chartBarGap),
+			Height: chartHeight,
+		}
+		peak := 1
+		for _, day := range report.Daily {
+			peak = max(peak, day.Counts[counter.name])
+			chart.Recent += day.Counts[counter.name]
+		}
+		for n, day := range report.Daily {
+			count := day.Counts[counter.name]
+			height := count * chartHeight / peak
+			chart.Bars = append(chart.Bars, dashboardBar{
+				Day: day.Day, Count: count,
+				X: n * (chartBarWidth + chartBarGap), Y: chartHeight - height,
+				Width: chartBarWidth, Height: height,
+			})
+		}
+		charts[i] = chart
+	}
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	err = dashboardTemplate.Execute(w, struct {
+		Hits   int
+		Days   int
+		Charts []dashboardChart
+	}{report.Totals[counterHits], days, charts})
+	if err != nil {
+		log.Printf("Error rendering the admin dashboard: %s", err)
+	}
+}
+
+// resetHandler resets the hit count. With ?database=true it also wipes the
+// database, which it refuses to do unless PLATFORM is dev, so test
+// harnesses can start each run from nothing. It answers with what it
+// cleared.
+func (cfg *apiConfig) resetHandler(w http.ResponseWriter, r *http.Request) {
+	wipe := false
+	if param := r.URL.Query().Get("database"); param != "" {
+		var err error
+		if wipe, err = strconv.ParseBool(param); err != nil {
+			respondValidationError(w, "database must be true or false")
+			return
+		}
+	}
+	if wipe && cfg.platform != "dev" {
+		respondForbidden(w, "the database can only be wiped when PLATFORM is dev")
+		return
+	}
+
+	type returnVal struct {
+		Hits     int                 `json:"hits"`
+		Database *database.WipeStats `json:"database,omitempty"`
+	}
+	totals, err := cfg.metricTotals(r.Context())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	respBody := returnVal{Hits: totals[counterHits]}
+	cfg.metrics.forget(counterHits)
+	if err := cfg.store(r.Context()).ResetCounter(counterHits); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if wipe {
+		stats, err := cfg.store(r.Context()).Wipe()
+		if err != nil {
+			respondDataWriteError(w, err)
+			return
+		}
+		respBody.Database = &stats
+		// The cached feeds hold chirps and readers that are gone.
+		cfg.inboxes.clear()
+		cfg.forYou.clear()
+		logRequestf(requestId(r.Context()), "Wiped the database")
+	}
+
+	data, err := json.Marshal(respBody)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.metrics.inc(counterHits)
+		next.ServeHTTP(w, r)
+	})
+}
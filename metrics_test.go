@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.gob")
+	db, err := database.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := database.CounterDay(time.Now().AddDate(0, 0, -1))
+	err = db.AddCounters([]database.DailyCounter{{Name: counterHits, Day: yesterday, Count: 4}, {Name: counterHits, Day: "2000-01-01", Count: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, metrics: newMetrics()}
+	app := cfg.middlewareMetricsInc(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app/", nil))
+	}
+	cfg.metrics.inc(counterLogins)
+
+	t.Logf("Starting test for getMetricsJSONHandler with: hits stored and unflushed, and expecting: both counted")
+	report := getMetricsReport(t, cfg, "/admin/metrics.json?days=2")
+	if len(report.Daily) != 2 || report.Daily[0].Day != yesterday || report.Daily[0].Counts[counterHits] != 4 ||
+		report.Daily[1].Counts[counterHits] != 3 || report.Daily[1].Counts[counterLogins] != 1 || report.Daily[1].Counts[counterUsers] != 0 {
+		t.Errorf("Expecting: 4 hits yesterday, and 3 hits and a login today, but got: %+v", report.Daily)
+	}
+	if report.Totals[counterHits] != 17 {
+		t.Errorf("Expecting: 17 hits in all, but got: %v", report.Totals)
+	}
+
+	t.Logf("Starting test for flush with: unflushed counts, and expecting: they survive a restart")
+	if err := cfg.metrics.flush(db); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := database.NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := &apiConfig{db: reopened, metrics: newMetrics()}
+	if report := getMetricsReport(t, restarted, "/admin/metrics.json"); len(report.Daily) != defaultMetricsDays || report.Totals[counterHits] != 17 {
+		t.Errorf("Expecting: %d days and 17 hits, but got: %+v", defaultMetricsDays, report)
+	}
+
+	t.Logf("Starting test for getMetricsHandler with: 17 hits, and expecting: a dashboard with a chart per counter")
+	w := httptest.NewRecorder()
+	restarted.getMetricsHandler(w, httptest.NewRequest("GET", "/admin/metrics?days=7", nil))
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, "Chirpy has been visited 17 times!") || strings.Count(body, "<svg") != len(dashboardCounters) ||
+		!strings.Contains(body, "<title>"+yesterday+": 4</title>") {
+		t.Errorf("Expecting: the dashboard, but got: %d %s", w.Code, body)
+	}
+
+	for _, days := range []string{"0", "366", "week"} {
+		t.Logf("Starting test for getMetricsHandler with: days=%s, and expecting: 400", days)
+		w := httptest.NewRecorder()
+		restarted.getMetricsHandler(w, httptest.NewRequest("GET", "/admin/metrics?days="+days, nil))
+		if w.Code != 400 {
+			t.Errorf("Expecting: 400, but got: %d", w.Code)
+		}
+	}
+
+	t.Logf("Starting test for resetHandler with: 17 hits, and expecting: none left")
+	restarted.metrics.inc(counterHits)
+	w = httptest.NewRecorder()
+	restarted.resetHandler(w, httptest.NewRequest("POST", "/admin/reset", nil))
+	if w.Code != 200 || strings.TrimSpace(w.Body.String()) != `{"hits":18}` {
+		t.Errorf("Expecting: 18 hits cleared, but got: %d %s", w.Code, w.Body.String())
+	}
+	if report := getMetricsReport(t, restarted, "/admin/metrics.json"); report.Totals[counterHits] != 0 || report.Totals[counterLogins] != 1 {
+		t.Errorf("Expecting: no hits and a login, but got: %v", report.Totals)
+	}
+}
+
+func TestResetDatabase(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("boots@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateChirp(database.Chirp{Body: "hello", AuthorId: user.Id}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, metrics: newMetrics(), inboxes: newFeedInboxes(2, 1), forYou: newForYouFeeds()}
+	cfg.inboxes.push(user.Id, 1)
+	cfg.inboxes.setPulled(user.Id, true)
+	cfg.forYou.put(user.Id, []database.Chirp{{Id: 1}}, time.Now())
+
+	for _, platform := range []string{"", "production"} {
+		t.Logf("Starting test for resetHandler with: ?database=true and PLATFORM %q, and expecting: 403 and nothing wiped", platform)
+		cfg.platform = platform
+		w := httptest.NewRecorder()
+		cfg.resetHandler(w, httptest.NewRequest("POST", "/admin/reset?database=true", nil))
+		if w.Code != 403 {
+			t.Errorf("Expecting: 403, but got: %d", w.Code)
+		}
+		if users, err := db.GetUsers(); err != nil || len(users) != 1 {
+			t.Errorf("Expecting: the user kept, but got: %v, %v", users, err)
+		}
+	}
+
+	cfg.platform = "dev"
+	t.Logf("Starting test for resetHandler with: ?database=maybe, and expecting: 400")
+	w := httptest.NewRecorder()
+	cfg.resetHandler(w, httptest.NewRequest("POST", "/admin/reset?database=maybe", nil))
+	if w.Code != 400 {
+		t.Errorf("Expecting: 400, but got: %d", w.Code)
+	}
+
+	t.Logf("Starting test for resetHandler with: ?database=true on dev, and expecting: the user and chirp cleared")
+	cfg.metrics.inc(counterHits)
+	w = httptest.NewRecorder()
+	cfg.resetHandler(w, httptest.NewRequest("POST", "/admin/reset?database=true", nil))
+	var cleared struct {
+		Hits     int                `json:"hits"`
+		Database database.WipeStats `json:"database"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &cleared); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != 200 || cleared.Hits != 1 || cleared.Database.Users != 1 || cleared.Database.Chirps != 1 {
+		t.Errorf("Expecting: 1 hit, 1 user, and 1 chirp cleared, but got: %d %+v", w.Code, cleared)
+	}
+	if users, err := db.GetUsers(); err != nil || len(users) != 0 {
+		t.Errorf("Expecting: no users, but got: %v, %v", users, err)
+	}
+	_, inbox := cfg.inboxes.get(user.Id)
+	_, feed := cfg.forYou.get(user.Id, time.Now())
+	if inbox || feed || len(cfg.inboxes.pulledAmong([]int{user.Id})) != 0 {
+		t.Errorf("Expecting: no cached feeds, but got: inbox %t, for you %t, pulled %v", inbox, feed, cfg.inboxes.pulledAmong([]int{user.Id}))
+	}
+}
+
+func getMetricsReport(t *testing.T, cfg *apiConfig, target string) metricsReport {
+	w := httptest.NewRecorder()
+	cfg.getMetricsJSONHandler(w, httptest.NewRequest("GET", target, nil))
+	if w.Code != 200 {
+		t.Fatalf("Expecting: 200, but got: %d", w.Code)
+	}
+	var report metricsReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// accountMigration moves an account to another instance: the profile and
+// who it follows, with every account named by its profile URL, since ids
+// only mean something on the instance that issued them.
+type accountMigration struct {
+	// Source is the profile URL of the account being moved.
+	Source    string           `json:"source"`
+	Profile   database.Profile `json:"profile"`
+	Following []string         `json:"following"`
+}
+
+// profilePath is where the profile of userId is served.
+func profilePath(userId int) string {
+	return "/api/users/" + strconv.Itoa(userId)
+}
+
+// profileURL is the profile of userId on the instance r was made to.
+func profileURL(r *http.Request, userId int) string {
+	return requestBaseURL(r) + profilePath(userId)
+}
+
+// localProfileId returns the id of the user whose profile link is on the
+// host r was made to, or of the user who moved here from link.
+func (cfg *apiConfig) localProfileId(r *http.Request, link string) (int, bool, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0, false, nil
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		id, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSuffix(u.Path, "/"), "/api/users/"))
+		return id, err == nil, nil
+	}
+	user, found, err := cfg.store(r.Context()).GetUserByMovedFrom(link)
+	return user.Id, found, err
+}
+
+// getAccountMigrationHandler hands the user what the instance they move to
+// needs to set up their new account. Followees who have moved away
+// themselves are named by their new account.
+func (cfg *apiConfig) getAccountMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	following, err := cfg.store(r.Context()).GetFollowing(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	profiles, err := cfg.store(r.Context()).GetProfiles(following)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	migration := accountMigration{
+		Source:    profileURL(r, userId),
+		Profile:   user.Profile(),
+		Following: make([]string, 0, len(following)),
+	}
+	for _, followeeId := range following {
+		if movedTo := profiles[followeeId].MovedTo; movedTo != "" {
+			migration.Following = append(migration.Following, movedTo)
+		} else {
+			migration.Following = append(migration.Following, profileURL(r, followeeId))
+		}
+	}
+	data, err := json.Marshal(migration)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// postAccountMigrationHandler sets up the requesting user's account from
+// one on another instance: it copies the profile, keeping the current
+// handle if the old one is taken here, and follows every followee with an
+// account on this instance. Images stay where the old instance serves
+// them, and the website has to be verified again. It answers with the
+// profile and the followees it could not find.
+func (cfg *apiConfig) postAccountMigrationHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	decoder := newJSONDecoder(r.Body)
+	migration := accountMigration{}
+	err := decoder.Decode(&migration)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	source, err := url.Parse(migration.Source)
+	if err != nil || !validHTTPURL(migration.Source) {
+		respondValidationError(w, "source must be the http or https URL of the account moved from")
+		return
+	}
+	if strings.EqualFold(source.Host, r.Host) {
+		respondValidationError(w, "source is an account on this instance")
+		return
+	}
+
+	profile := migration.Profile
+	update := database.ProfileUpdate{
+		DisplayName: &profile.DisplayName,
+		Bio:         &profile.Bio,
+		Website:     &profile.Website,
+		MovedFrom:   &migration.Source,
+	}
+	for _, image := range []struct {
+		link string
+		set  **string
+	}{{profile.AvatarURL, &update.AvatarURL}, {profile.BannerURL, &update.BannerURL}} {
+		if image.link == "" {
+			continue
+		}
+		if resolved, err := source.Parse(image.link); err == nil {
+			link := resolved.String()
+			*image.set = &link
+		}
+	}
+	if !validateProfileUpdate(update) {
+		respondValidationError(w, "profile is not valid on this instance")
+		return
+	}
+	handle := profile.Handle
+	if handle != "" && validHandle.MatchString(handle) && cfg.handleRejection(handle) == "" {
+		update.Handle = &handle
+	}
+	user, err := cfg.store(r.Context()).UpdateProfile(userId, update)
+	if errors.Is(err, database.ErrHandleTaken) {
+		update.Handle = nil
+		user, err = cfg.store(r.Context()).UpdateProfile(userId, update)
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+
+	unresolved := make([]string, 0)
+	for _, link := range migration.Following {
+		followeeId, found, err := cfg.localProfileId(r, link)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		if found {
+			err = cfg.store(r.Context()).Follow(userId, followeeId)
+		}
+		var notFound *database.NotFoundError
+		if !found || errors.As(err, &notFound) {
+			unresolved = append(unresolved, link)
+			continue
+		}
+		if err != nil && !errors.Is(err, database.ErrCannotFollowSelf) {
+			respondDataWriteError(w, err)
+			return
+		}
+	}
+	cfg.inboxes.drop(userId)
+
+	type returnVal struct {
+		Profile    database.Profile `json:"profile"`
+		Unresolved []string         `json:"unresolved"`
+	}
+	data, err := json.Marshal(returnVal{Profile: user.Profile(), Unresolved: unresolved})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// putMovedToHandler marks the user's account as moved to the account at
+// moved_to, so clients can point followers there.
+func (cfg *apiConfig) putMovedToHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	type parameters struct {
+		MovedTo string `json:"moved_to"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if !validHTTPURL(params.MovedTo) {
+		respondValidationError(w, "moved_to must be the http or https URL of the account moved to")
+		return
+	}
+	if params.MovedTo == profileURL(r, userId) {
+		respondValidationError(w, "moved_to is this account")
+		return
+	}
+	cfg.updateProfile(w, r, userId, database.ProfileUpdate{MovedTo: &params.MovedTo})
+}
+
+// deleteMovedToHandler takes back a move, for when it was a mistake.
+func (cfg *apiConfig) deleteMovedToHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	movedTo := ""
+	cfg.updateProfile(w, r, userId, database.ProfileUpdate{MovedTo: &movedTo})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAccountMigration(t *testing.T) {
+	instance := func() (*apiConfig, *chi.Mux) {
+		db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), profanity: newProfanityFilter(nil), inboxes: newFeedInboxes(2, 1)}
+		router := chi.NewRouter()
+		router.Get("/api/users/me/migration", cfg.getAccountMigrationHandler)
+		router.Post("/api/users/me/migration", cfg.postAccountMigrationHandler)
+		router.Put("/api/users/me/moved_to", cfg.putMovedToHandler)
+		router.Delete("/api/users/me/moved_to", cfg.deleteMovedToHandler)
+		return cfg, router
+	}
+	oldCfg, oldRouter := instance()
+	alice, _ := oldCfg.db.CreateUser("alice@example.com", "hash")
+	bob, _ := oldCfg.db.CreateUser("bob@example.com", "hash")
+	carol, _ := oldCfg.db.CreateUser("carol@example.com", "hash")
+	handle, avatar, movedTo := "alice", "/media/abc", "http://new.example/api/users/2"
+	oldCfg.db.UpdateProfile(alice.Id, database.ProfileUpdate{Handle: &handle, AvatarURL: &avatar})
+	oldCfg.db.UpdateProfile(carol.Id, database.ProfileUpdate{MovedTo: &movedTo})
+	oldCfg.db.Follow(alice.Id, bob.Id)
+	oldCfg.db.Follow(alice.Id, carol.Id)
+	oldToken, _ := oldCfg.tokens.NewAccessToken(alice.Id)
+
+	resp := runDraftRequest(t, oldRouter, oldToken, "GET", "http://old.example/api/users/me/migration", "", 200)
+	migration := accountMigration{}
+	json.Unmarshal(resp.Body.Bytes(), &migration)
+	wantFollowing := []string{"http://old.example/api/users/2", movedTo}
+	if migration.Source != "http://old.example/api/users/1" || migration.Profile.Handle != "alice" || !slices.Equal(migration.Following, wantFollowing) {
+		t.Errorf("Expecting: alice following %v, but got: %+v", wantFollowing, migration)
+	}
+
+	newCfg, newRouter := instance()
+	newAlice, _ := newCfg.db.CreateUser("alice@example.com", "hash")
+	newCarol, _ := newCfg.db.CreateUser("carol@example.com", "hash")
+	newBob, _ := newCfg.db.CreateUser("bob@example.com", "hash")
+	bobSource := "http://old.example/api/users/2"
+	newCfg.db.UpdateProfile(newBob.Id, database.ProfileUpdate{MovedFrom: &bobSource, Handle: &handle})
+	newToken, _ := newCfg.tokens.NewAccessToken(newAlice.Id)
+	migration.Following = append(migration.Following, "http://elsewhere.example/api/users/9")
+	body, _ := json.Marshal(migration)
+
+	runDraftRequest(t, newRouter, newToken, "POST", "http://old.example/api/users/me/migration", string(body), 400)
+	t.Logf("Starting test for postAccountMigrationHandler with: a followee moved here and one local, and expecting: both followed")
+	resp = runDraftRequest(t, newRouter, newToken, "POST", "http://new.example/api/users/me/migration", string(body), 200)
+	result := struct {
+		Profile    database.Profile `json:"profile"`
+		Unresolved []string         `json:"unresolved"`
+	}{}
+	json.Unmarshal(resp.Body.Bytes(), &result)
+	if result.Profile.Handle != "" || result.Profile.AvatarURL != "http://old.example/media/abc" || !slices.Equal(result.Unresolved, []string{"http://elsewhere.example/api/users/9"}) {
+		t.Errorf("Expecting: the taken handle skipped and the avatar kept, but got: %+v", result)
+	}
+	following, _ := newCfg.db.GetFollowing(newAlice.Id)
+	slices.Sort(following)
+	if !slices.Equal(following, []int{newCarol.Id, newBob.Id}) {
+		t.Errorf("Expecting: %d and %d followed, but got: %v", newCarol.Id, newBob.Id, following)
+	}
+	if moved, found, _ := newCfg.db.GetUserByMovedFrom(migration.Source); !found || moved.Id != newAlice.Id {
+		t.Errorf("Expecting: %d moved from %s, but got: %+v", newAlice.Id, migration.Source, moved)
+	}
+
+	t.Logf("Starting test for putMovedToHandler with: the new account, and expecting: the old profile to point at it")
+	runDraftRequest(t, oldRouter, oldToken, "PUT", "http://old.example/api/users/me/moved_to", `{"moved_to":"http://old.example/api/users/1"}`, 400)
+	runDraftRequest(t, oldRouter, oldToken, "PUT", "http://old.example/api/users/me/moved_to", `{"moved_to":"http://new.example/api/users/1"}`, 200)
+	if user, _ := oldCfg.db.GetUserById(alice.Id); user.Profile().MovedTo != "http://new.example/api/users/1" {
+		t.Errorf("Expecting: moved to the new account, but got: %+v", user.Profile())
+	}
+	runDraftRequest(t, oldRouter, oldToken, "DELETE", "http://old.example/api/users/me/moved_to", "", 200)
+	if user, _ := oldCfg.db.GetUserById(alice.Id); user.MovedTo != "" {
+		t.Errorf("Expecting: the move taken back, but got: %q", user.MovedTo)
+	}
+}
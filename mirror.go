@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// Responses bigger than this are not compared, so mirroring never holds
+// on to much more than a page of chirps per request.
+const maxMirroredBody = 1 << 20
+
+// mirrorReplays bounds how many replays run at once. Requests sampled while
+// every slot is taken are dropped rather than queued, so a slow secondary
+// store cannot pile up work.
+const mirrorReplays = 8
+
+// mirror replays a share of the API's reads against a secondary store and
+// logs the responses that differ from the primary's, so a new backend can
+// be checked against real traffic before it takes over. Clients only ever
+// get the primary's response. A nil *mirror mirrors nothing.
+type mirror struct {
+	db      database.Storage
+	percent int
+	timeout time.Duration
+	slots   chan struct{}
+	wg      sync.WaitGroup
+
+	compared atomic.Int64
+	diverged atomic.Int64
+	dropped  atomic.Int64
+}
+
+// newMirror mirrors percent of the reads, 0 to 100, to db. Each replay
+// gets timeout to finish.
+func newMirror(db database.Storage, percent int, timeout time.Duration) *mirror {
+	return &mirror{
+		db:      db,
+		percent: min(max(percent, 0), 100),
+		timeout: timeout,
+		slots:   make(chan struct{}, mirrorReplays),
+	}
+}
+
+// mirrorStats reports how the mirrored reads compared.
+type mirrorStats struct {
+	Percent  int   `json:"percent"`
+	Compared int64 `json:"compared"`
+	Diverged int64 `json:"diverged"`
+	Dropped  int64 `json:"dropped"`
+}
+
+func (m *mirror) stats() *mirrorStats {
+	if m == nil {
+		return nil
+	}
+	return &mirrorStats{
+		Percent:  m.percent,
+		Compared: m.compared.Load(),
+		Diverged: m.diverged.Load(),
+		Dropped:  m.dropped.Load(),
+	}
+}
+
+// wait blocks until the replays in flight are done.
+func (m *mirror) wait() {
+	if m != nil {
+		m.wg.Wait()
+	}
+}
+
+type mirrorKey struct{}
+
+// mirrored reports whether ctx belongs to a replay, whose store calls go to
+// the secondary store.
+func mirrored(ctx context.Context) bool {
+	return ctx.Value(mirrorKey{}) != nil
+}
+
+// mirroredRoutes are the API routes safe to replay: they only read from the
+// store and call out to nothing else. The rest, such as the OAuth callback
+// spending its authorization code or the streams holding their connection
+// open, are never mirrored.
+var mirroredRoutes = []string{
+	"/instance",
+	"/drafts",
+	"/drafts/{id}",
+	"/chirps",
+	"/chirps/scheduled",
+	"/chirps/{id}",
+	"/chirps/{id}/replies",
+	"/chirps/{id}/context",
+	"/chirps/{id}/analytics",
+	"/feed",
+	"/feed/marker",
+	"/trends",
+	"/emoji",
+	"/users/me/likes",
+	"/users/me/bookmarks",
+	"/users/me/migration",
+	"/users/me/moderation",
+	"/users/me/preferences",
+	"/users/me/security",
+	"/users/{id}",
+	"/users/{id}/following",
+	"/users/{id}/followers",
+	"/apikeys",
+}
+
+// samples reports whether r, which routes resolves at routePath, is a read
+// picked for mirroring.
+func (m *mirror) samples(r *http.Request, routes chi.Routes, routePath string) bool {
+	if m == nil || m.percent == 0 || mirrored(r.Context()) {
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	rctx := chi.NewRouteContext()
+	if routes == nil || !routes.Match(rctx, r.Method, routePath) || !slices.Contains(mirroredRoutes, rctx.RoutePattern()) {
+		return false
+	}
+	return rand.Intn(100) < m.percent
+}
+
+// mirrorRecorder passes the response through while keeping a copy, up to
+// maxMirroredBody.
+type mirrorRecorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rec *mirrorRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *mirrorRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.body.Len()+len(b) > maxMirroredBody {
+		rec.truncated = true
+	} else {
+		rec.body.Write(b)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// shadowWriter keeps the response to a replay, which goes nowhere else.
+type shadowWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *shadowWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *shadowWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *shadowWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// middlewareMirror serves each request as usual and, for the reads it
+// samples, replays the request through next against the secondary store
+// once the client has its response. It must wrap a chi router directly,
+// as the replay is routed afresh.
+func (cfg *apiConfig) middlewareMirror(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routePath := r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+			routePath = rctx.RoutePath
+		}
+		routes, _ := next.(chi.Routes)
+		if !cfg.mirror.samples(r, routes, routePath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &mirrorRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.truncated {
+			return
+		}
+		select {
+		case cfg.mirror.slots <- struct{}{}:
+		default:
+			cfg.mirror.dropped.Add(1)
+			return
+		}
+		// The replay is cut loose from the request, which ends as soon as
+		// this returns. The primary's routing state is not reused either:
+		// chi recycles it once the request is done.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), cfg.mirror.timeout)
+		ctx = context.WithValue(ctx, mirrorKey{}, true)
+		rctx := chi.NewRouteContext()
+		rctx.RoutePath = routePath
+		replay := r.Clone(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		replay.Body = http.NoBody
+		cfg.mirror.wg.Add(1)
+		go func() {
+			defer cfg.mirror.wg.Done()
+			defer func() { <-cfg.mirror.slots }()
+			defer cancel()
+			cfg.mirror.replay(next, replay, rec.status, rec.body.Bytes())
+		}()
+	})
+}
+
+// replay serves r again through next, against the secondary store, and
+// logs how its response differs from the primary's status and body.
+func (m *mirror) replay(next http.Handler, r *http.Request, status int, body []byte) {
+	shadow := &shadowWriter{header: make(http.Header)}
+	next.ServeHTTP(shadow, r)
+	m.compared.Add(1)
+	if shadow.status == status && bytes.Equal(shadow.body.Bytes(), body) {
+		return
+	}
+	m.diverged.Add(1)
+	logRequestf(requestId(r.Context()), "Mirror diverged on %s %s: %s", r.Method, r.URL.RequestURI(),
+		describeDivergence(status, body, shadow.status, shadow.body.Bytes()))
+}
+
+// describeDivergence says how the secondary's response differs from the
+// primary's, quoting the bodies around the first difference.
+func describeDivergence(status int, body []byte, shadowStatus int, shadowBody []byte) string {
+	var parts []string
+	if status != shadowStatus {
+		parts = append(parts, fmt.Sprintf("status %d, secondary %d", status, shadowStatus))
+	}
+	if !bytes.Equal(body, shadowBody) {
+		at := 0
+		for at < len(body) && at < len(shadowBody) && body[at] == shadowBody[at] {
+			at++
+		}
+		start := max(at-20, 0)
+		parts = append(parts, fmt.Sprintf("bodies differ at byte %d: %q, secondary %q",
+			at, body[start:min(at+40, len(body))], shadowBody[start:min(at+40, len(shadowBody))]))
+	}
+	return strings.Join(parts, "; ")
+}
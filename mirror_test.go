@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestMirror(t *testing.T) {
+	stores := make([]*database.DB, 2)
+	for i, name := range []string{"primary.gob", "secondary.gob"} {
+		db, err := database.NewDB(filepath.Join(t.TempDir(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := db.CreateUser("boots@example.com", "hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, body := range []string{"same on both", "written to store " + strconv.Itoa(i)} {
+			if _, err := db.CreateChirp(database.Chirp{Body: body, AuthorId: user.Id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stores[i] = db
+	}
+	cfg := &apiConfig{db: stores[0], mirror: newMirror(stores[1], 100, time.Second)}
+
+	apiRouter := chi.NewRouter()
+	apiRouter.Get("/chirps/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+		chirp, found, err := cfg.store(r.Context()).GetChirp(id)
+		if err != nil || !found {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(chirp.Body))
+	})
+	apiRouter.Post("/chirps/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+	})
+	apiRouter.Get("/oauth/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("logged in"))
+	})
+	router := chi.NewRouter()
+	router.Mount("/api", cfg.middlewareMirror(apiRouter))
+
+	cases := []struct {
+		method, path string
+		body         string
+		stats        mirrorStats
+	}{
+		{"GET", "/api/chirps/1", "same on both", mirrorStats{Percent: 100, Compared: 1}},
+		{"GET", "/api/chirps/2", "written to store 0", mirrorStats{Percent: 100, Compared: 2, Diverged: 1}},
+		{"GET", "/api/chirps/3", "", mirrorStats{Percent: 100, Compared: 3, Diverged: 1}},
+		{"POST", "/api/chirps/1", "", mirrorStats{Percent: 100, Compared: 3, Diverged: 1}},
+		{"GET", "/api/oauth/github/callback", "logged in", mirrorStats{Percent: 100, Compared: 3, Diverged: 1}},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for middlewareMirror with: %s %s, and expecting: the primary's response and %+v", c.method, c.path, c.stats)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		cfg.mirror.wait()
+		if w.Body.String() != c.body {
+			t.Errorf("Expecting: %q, but got: %q", c.body, w.Body.String())
+		}
+		if stats := cfg.mirror.stats(); *stats != c.stats {
+			t.Errorf("Expecting: %+v, but got: %+v", c.stats, *stats)
+		}
+	}
+
+	t.Logf("Starting test for middlewareMirror with: MIRROR_PERCENT 0, and expecting: nothing mirrored")
+	cfg.mirror = newMirror(stores[1], 0, time.Second)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/chirps/2", nil))
+	cfg.mirror.wait()
+	if stats := cfg.mirror.stats(); stats.Compared != 0 {
+		t.Errorf("Expecting: nothing compared, but got: %+v", *stats)
+	}
+}
+
+func TestMirrorRendering(t *testing.T) {
+	renderer, err := loadRenderer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	stores := make([]*database.DB, 2)
+	for i, name := range []string{"primary.gob", "secondary.gob"} {
+		db, err := database.NewDB(filepath.Join(t.TempDir(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, email := range []string{"boots@example.com", "paws@example.com"} {
+			if _, err := db.CreateUser(email, "hunter2"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for id, chirp := range []database.Chirp{
+			{Body: "same on both", AuthorId: 1},
+			{Body: "see https://example.com/a", AuthorId: 1},
+			{Body: "pick one", AuthorId: 1},
+			{Body: "hello", AuthorId: 2},
+		} {
+			chirp.Id, chirp.ShortId, chirp.CreatedAt = id+1, "short"+strconv.Itoa(id+1), createdAt
+			if _, err := db.PutChirp(chirp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The stores agree on every chirp, and differ in a short link, a
+		// poll option and a display name.
+		if i == 0 {
+			if _, err := db.CreateLinks(2, []string{"https://example.com/a"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		options := []string{"yes", "no"}
+		if i == 1 {
+			options = append(options, "maybe")
+		}
+		if _, err := db.CreatePoll(3, options, createdAt.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		displayName := "Paws " + strconv.Itoa(i)
+		if _, err := db.UpdateProfile(2, database.ProfileUpdate{DisplayName: &displayName}); err != nil {
+			t.Fatal(err)
+		}
+		stores[i] = db
+	}
+	cfg := &apiConfig{
+		db:              stores[0],
+		mirror:          newMirror(stores[1], 100, time.Second),
+		renderer:        renderer,
+		linkTracking:    true,
+		numericChirpIds: true,
+	}
+	apiRouter := chi.NewRouter()
+	apiRouter.Get("/chirps/{id}", cfg.getChirpIdHandler)
+	router := chi.NewRouter()
+	router.Mount("/api", cfg.middlewareMirror(apiRouter))
+
+	for i, c := range []struct {
+		name     string
+		diverged int64
+	}{
+		{"nothing differing", 0},
+		{"a short link only the primary has", 1},
+		{"differing poll options", 2},
+		{"differing display names", 3},
+	} {
+		t.Logf("Starting test for middlewareMirror with: chirp %d and %s, and expecting: %d diverged", i+1, c.name, c.diverged)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/api/chirps/"+strconv.Itoa(i+1), nil))
+		cfg.mirror.wait()
+		if stats := cfg.mirror.stats(); w.Code != 200 || stats.Compared != int64(i+1) || stats.Diverged != c.diverged {
+			t.Errorf("Expecting: 200 and %d diverged of %d, but got: %d and %+v", c.diverged, i+1, w.Code, *stats)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// rejectSuspended answers 403 and returns true when the user is currently
+// suspended. Suspended users can still read and appeal, but not post.
+func (cfg *apiConfig) rejectSuspended(w http.ResponseWriter, r *http.Request, userId int) bool {
+	suspended, err := cfg.store(r.Context()).IsSuspended(userId, time.Now())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return true
+	}
+	if suspended {
+		w.WriteHeader(403)
+		return true
+	}
+	return false
+}
+
+func (cfg *apiConfig) postRemoveChirpHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	chirpId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if strings.TrimSpace(params.Reason) == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	chirp, found, err := cfg.store(r.Context()).GetChirp(chirpId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	err = cfg.store(r.Context()).DeleteChirp(chirp.Id, chirp.AuthorId)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.announceDelete(r.Context(), chirp)
+	cfg.recordModerationAction(w, r, database.ModerationAction{
+		UserId:  chirp.AuthorId,
+		Kind:    database.ActionRemoveChirp,
+		ChirpId: &chirp.Id,
+		Reason:  params.Reason,
+	})
+}
+
+func (cfg *apiConfig) postSuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	userId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"` // time.ParseDuration format; empty suspends indefinitely
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if strings.TrimSpace(params.Reason) == "" {
+		w.WriteHeader(400)
+		return
+	}
+	action := database.ModerationAction{
+		UserId: userId,
+		Kind:   database.ActionSuspend,
+		Reason: params.Reason,
+	}
+	if params.Duration != "" {
+		duration, err := time.ParseDuration(params.Duration)
+		if err != nil || duration <= 0 {
+			w.WriteHeader(400)
+			return
+		}
+		until := time.Now().UTC().Add(duration)
+		action.Until = &until
+	}
+	cfg.recordModerationAction(w, r, action)
+}
+
+func (cfg *apiConfig) recordModerationAction(w http.ResponseWriter, r *http.Request, action database.ModerationAction) {
+	action, err := cfg.store(r.Context()).CreateModerationAction(action)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(action)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// getUserModerationHandler lists the actions taken against the requesting
+// user, with the reason for each and the state of any appeal.
+func (cfg *apiConfig) getUserModerationHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	actions, err := cfg.store(r.Context()).GetModerationActions(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(actions)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) postAppealHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		ActionId int    `json:"action_id"`
+		Message  string `json:"message"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if strings.TrimSpace(params.Message) == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	appeal, err := cfg.store(r.Context()).CreateAppeal(database.Appeal{ActionId: params.ActionId, UserId: userId, Message: params.Message})
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(appeal)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// getAppealsHandler lists open appeals for admins, oldest first, each with
+// the action being appealed.
+func (cfg *apiConfig) getAppealsHandler(w http.ResponseWriter, r *http.Request) {
+	appeals, err := cfg.store(r.Context()).GetOpenAppeals()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	type queuedAppeal struct {
+		database.Appeal
+		Action database.ModerationAction `json:"action"`
+	}
+	queue := make([]queuedAppeal, 0, len(appeals))
+	for _, appeal := range appeals {
+		action, err := cfg.store(r.Context()).GetModerationAction(appeal.ActionId)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		action.Appeal = nil
+		queue = append(queue, queuedAppeal{Appeal: appeal, Action: action})
+	}
+
+	data, err := json.Marshal(queue)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) postResolveAppealHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	appealId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Resolution string `json:"resolution"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if params.Resolution != database.AppealGranted && params.Resolution != database.AppealDenied {
+		w.WriteHeader(400)
+		return
+	}
+
+	appeal, err := cfg.store(r.Context()).ResolveAppeal(appealId, params.Resolution)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(appeal)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
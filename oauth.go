@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// oauthStateCookie holds the state parameter of a login in progress, so the
+// callback can tell it was started by the same browser.
+const oauthStateCookie = "chirpy_oauth_state"
+
+// oauthIdentity is what Chirpy needs to know about a provider's user.
+type oauthIdentity struct {
+	Email    string
+	Verified bool
+}
+
+// oauthProvider is an OAuth2 authorization server Chirpy users can log in
+// with, using the authorization code grant.
+type oauthProvider struct {
+	clientId     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	scopes       []string
+	// identify asks the provider who the access token belongs to.
+	identify func(ctx context.Context, client *http.Client, accessToken string) (oauthIdentity, error)
+}
+
+// oauthProviders returns the providers whose client ID is set in the
+// environment, as <PROVIDER>_CLIENT_ID and <PROVIDER>_CLIENT_SECRET.
+func oauthProviders(getenv func(string) string) map[string]*oauthProvider {
+	providers := make(map[string]*oauthProvider)
+	if id := getenv("GOOGLE_CLIENT_ID"); id != "" {
+		providers["google"] = &oauthProvider{
+			clientId:     id,
+			clientSecret: getenv("GOOGLE_CLIENT_SECRET"),
+			authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL:     "https://oauth2.googleapis.com/token",
+			scopes:       []string{"openid", "email"},
+			identify:     googleIdentity("https://openidconnect.googleapis.com/v1/userinfo"),
+		}
+	}
+	if id := getenv("GITHUB_CLIENT_ID"); id != "" {
+		providers["github"] = &oauthProvider{
+			clientId:     id,
+			clientSecret: getenv("GITHUB_CLIENT_SECRET"),
+			authURL:      "https://github.com/login/oauth/authorize",
+			tokenURL:     "https://github.com/login/oauth/access_token",
+			scopes:       []string{"user:email"},
+			identify:     githubIdentity("https://api.github.com/user/emails"),
+		}
+	}
+	return providers
+}
+
+// getProviderJSON fetches url with accessToken into v.
+func getProviderJSON(ctx context.Context, client *http.Client, url, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// googleIdentity reads the OpenID Connect userinfo endpoint.
+func googleIdentity(userinfoURL string) func(context.Context, *http.Client, string) (oauthIdentity, error) {
+	return func(ctx context.Context, client *http.Client, accessToken string) (oauthIdentity, error) {
+		var info struct {
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+		}
+		if err := getProviderJSON(ctx, client, userinfoURL, accessToken, &info); err != nil {
+			return oauthIdentity{}, err
+		}
+		return oauthIdentity{Email: info.Email, Verified: info.EmailVerified}, nil
+	}
+}
+
+// githubIdentity reads the user's primary email address, which is missing
+// from their profile when they keep it private.
+func githubIdentity(emailsURL string) func(context.Context, *http.Client, string) (oauthIdentity, error) {
+	return func(ctx context.Context, client *http.Client, accessToken string) (oauthIdentity, error) {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := getProviderJSON(ctx, client, emailsURL, accessToken, &emails); err != nil {
+			return oauthIdentity{}, err
+		}
+		for _, email := range emails {
+			if email.Primary {
+				return oauthIdentity{Email: email.Email, Verified: email.Verified}, nil
+			}
+		}
+		return oauthIdentity{}, nil
+	}
+}
+
+// exchange trades the authorization code for an access token.
+func (p *oauthProvider) exchange(ctx context.Context, client *http.Client, code, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientId},
+		"client_secret": {p.clientSecret},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("reading the token response: %w", err)
+	}
+	// GitHub reports errors with a 200.
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("token request failed: %s %s", resp.Status, token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+// oauthConfig holds the providers users can log in with.
+type oauthConfig struct {
+	providers map[string]*oauthProvider
+	// redirectBase is the public URL of the server, for the callback URLs
+	// registered with the providers. Empty means the URL the request came
+	// in on.
+	redirectBase string
+	client       *http.Client
+}
+
+func newOAuthConfig(getenv func(string) string) oauthConfig {
+	return oauthConfig{
+		providers:    oauthProviders(getenv),
+		redirectBase: strings.TrimSuffix(getenv("OAUTH_REDIRECT_BASE_URL"), "/"),
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// callbackURL is where provider sends the user back to after they log in.
+func (o oauthConfig) callbackURL(r *http.Request, provider string) string {
+	base := o.redirectBase
+	if base == "" {
+		base = requestBaseURL(r)
+	}
+	return base + "/api/oauth/" + provider + "/callback"
+}
+
+// requestOAuthProvider responds with a 404 and returns false when the
+// provider named in the path is not configured.
+func (cfg *apiConfig) requestOAuthProvider(w http.ResponseWriter, r *http.Request) (string, *oauthProvider, bool) {
+	name := chi.URLParam(r, "provider")
+	provider, found := cfg.oauth.providers[name]
+	if !found {
+		w.WriteHeader(404)
+		return "", nil, false
+	}
+	return name, provider, true
+}
+
+// getOAuthAuthorizeHandler sends the browser to the provider to log in.
+func (cfg *apiConfig) getOAuthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := cfg.requestOAuthProvider(w, r)
+	if !ok {
+		return
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	state := base64.RawURLEncoding.EncodeToString(random)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/api/oauth/",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax, so the cookie comes back on the provider's redirect.
+		SameSite: http.SameSiteLaxMode,
+	})
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {provider.clientId},
+		"redirect_uri":  {cfg.oauth.callbackURL(r, name)},
+		"scope":         {strings.Join(provider.scopes, " ")},
+		"state":         {state},
+	}
+	http.Redirect(w, r, provider.authURL+"?"+query.Encode(), http.StatusFound)
+}
+
+// getOAuthCallbackHandler finishes a provider login. The user with the
+// provider's verified email address is logged in, or created when there is
+// none, and the response is the same as for a password login.
+func (cfg *apiConfig) getOAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	name, provider, ok := cfg.requestOAuthProvider(w, r)
+	if !ok {
+		return
+	}
+	format, ok := cfg.requestTokenFormat(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		respondSignInRequired(w, "the provider refused the login: "+reason)
+		return
+	}
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || query.Get("state") == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		respondValidationError(w, "state does not match the login started in this browser")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oauthStateCookie, Path: "/api/oauth/", MaxAge: -1})
+	// An authorization code is good for one exchange, and reusing it may
+	// revoke the tokens it was already exchanged for.
+	if mirrored(r.Context()) {
+		respondNotImplemented(w, "a mirrored replay cannot exchange an authorization code")
+		return
+	}
+
+	accessToken, err := provider.exchange(r.Context(), cfg.oauth.client, query.Get("code"), cfg.oauth.callbackURL(r, name))
+	if err != nil {
+		respondError(w, "Error exchanging the "+name+" authorization code", err)
+		return
+	}
+	identity, err := provider.identify(r.Context(), cfg.oauth.client, accessToken)
+	if err != nil {
+		respondError(w, "Error reading the "+name+" identity", err)
+		return
+	}
+	if identity.Email == "" || !identity.Verified {
+		respondSignInRequired(w, "your "+name+" account has no verified email address")
+		return
+	}
+
+	user, err := cfg.store(r.Context()).GetUser(identity.Email)
+	switch {
+	case errors.Is(err, database.ErrUserDoesNotExist):
+		// The new user has no password; once logged in they can set one
+		// with PUT /api/users.
+		user, err = cfg.store(r.Context()).PutUser(database.User{Email: identity.Email, Verified: true})
+		if err == nil {
+			user, err = cfg.promoteAdminEmail(cfg.store(r.Context()), user)
+		}
+		if err != nil {
+			respondDataWriteError(w, err)
+			return
+		}
+	case err != nil:
+		respondDataFetchError(w, err)
+		return
+	case !user.Verified:
+		// Whoever signed up with the address never proved they own it,
+		// and may know the account's password.
+		respondConflictError(w, "an unverified account uses this email address: verify it or log in with its password first")
+		return
+	case user.TOTPEnabled:
+		respondSignInRequired(w, "two-factor authentication is enabled: log in with your password and totp_code")
+		return
+	}
+	if user.DeletionRequestedAt != nil {
+		if err := cfg.store(r.Context()).CancelUserDeletion(user.Id); err != nil {
+			respondDataWriteError(w, err)
+			return
+		}
+	}
+	cfg.respondLogin(w, r, format, user, false)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOAuthLogin(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateUser("unverified@example.com", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	var identity oauthIdentity
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good" || r.PostFormValue("client_secret") != "shh" {
+			w.Write([]byte(`{"error":"bad_verification_code"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"provider-token","token_type":"bearer"}`))
+	}))
+	defer tokenServer.Close()
+	cfg := &apiConfig{
+		db:          db,
+		tokens:      auth.NewIssuer("secret"),
+		tokenFormat: tokenFormatChirpy,
+		oauth: oauthConfig{
+			providers: map[string]*oauthProvider{"test": {
+				clientId:     "chirpy",
+				clientSecret: "shh",
+				authURL:      "https://provider.example/authorize",
+				tokenURL:     tokenServer.URL,
+				identify: func(ctx context.Context, client *http.Client, accessToken string) (oauthIdentity, error) {
+					return identity, nil
+				},
+			}},
+			client: tokenServer.Client(),
+		},
+	}
+	router := chi.NewRouter()
+	router.Get("/api/oauth/{provider}/authorize", cfg.getOAuthAuthorizeHandler)
+	router.Get("/api/oauth/{provider}/callback", cfg.getOAuthCallbackHandler)
+
+	identity = oauthIdentity{Email: "New@Example.com", Verified: true}
+	runOAuthLoginTest(t, router, "test", "good", true, 200)
+	runOAuthLoginTest(t, router, "test", "good", true, 200)
+	runOAuthLoginTest(t, router, "test", "good", false, 400)
+	runOAuthLoginTest(t, router, "test", "bad", true, 500)
+	runOAuthLoginTest(t, router, "other", "good", true, 404)
+	user, err := db.GetUser("new@example.com")
+	if err != nil || !user.Verified {
+		t.Errorf("Expecting: a verified user, but got: %+v, %v", user, err)
+	}
+
+	t.Logf("Starting test for updateUserCredsHandler with: the new user setting a password, and expecting: a password login")
+	token, _ := cfg.tokens.NewAccessToken(user.Id)
+	r := httptest.NewRequest("PUT", "/api/users", strings.NewReader(`{"email": "new@example.com", "password": "correct horse battery"}`))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.updateUserCredsHandler(w, r)
+	if err := db.ComparePasswords("correct horse battery", "new@example.com"); w.Code != 200 || err != nil {
+		t.Errorf("Expecting: 200 and the password set, but got: %d, %v", w.Code, err)
+	}
+
+	identity = oauthIdentity{Email: "unverified@example.com", Verified: true}
+	runOAuthLoginTest(t, router, "test", "good", true, 409)
+	identity = oauthIdentity{Email: "someone@example.com", Verified: false}
+	runOAuthLoginTest(t, router, "test", "good", true, 401)
+}
+
+// runOAuthLoginTest starts a login with provider and comes back with code,
+// and with the state cookie when keepState is set.
+func runOAuthLoginTest(t *testing.T, router http.Handler, provider, code string, keepState bool, expecting int) {
+	t.Logf("Starting test for OAuth login with: provider %s, code %s and state kept %v, and expecting: %d", provider, code, keepState, expecting)
+	authorize := httptest.NewRecorder()
+	router.ServeHTTP(authorize, httptest.NewRequest("GET", "/api/oauth/"+provider+"/authorize", nil))
+	if expecting == 404 {
+		if authorize.Code != 404 {
+			t.Errorf("Expecting: 404, but got: %d", authorize.Code)
+		}
+		return
+	}
+	if authorize.Code != http.StatusFound {
+		t.Fatalf("Expecting: a redirect, but got: %d", authorize.Code)
+	}
+	location, err := url.Parse(authorize.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("redirect_uri") != "http://example.com/api/oauth/"+provider+"/callback" {
+		t.Errorf("Expecting: the callback as redirect_uri, but got: %s", location)
+	}
+
+	query := url.Values{"code": {code}, "state": {location.Query().Get("state")}}
+	req := httptest.NewRequest("GET", "/api/oauth/"+provider+"/callback?"+query.Encode(), nil)
+	if keepState {
+		for _, cookie := range authorize.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+	}
+	callback := httptest.NewRecorder()
+	router.ServeHTTP(callback, req)
+	if callback.Code != expecting {
+		t.Fatalf("Expecting: %d, but got: %d %s", expecting, callback.Code, callback.Body.String())
+	}
+	if expecting == 200 {
+		var login struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.Unmarshal(callback.Body.Bytes(), &login); err != nil || login.Token == "" || login.RefreshToken == "" {
+			t.Errorf("Expecting: a token pair, but got: %s", callback.Body.String())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// jobOperatorWebhook jobs post a moderation event to the operator webhook.
+const jobOperatorWebhook = "operator_webhook"
+
+// Operator webhook payload formats. Slack and Discord incoming webhooks each
+// want the message in their own field; the plain format sends the event as
+// is for operators with their own tooling.
+const (
+	webhookFormatJSON    = "json"
+	webhookFormatSlack   = "slack"
+	webhookFormatDiscord = "discord"
+)
+
+// operatorEvent is something the instance's operators should hear about.
+type operatorEvent struct {
+	Event     string    `json:"event"`
+	Text      string    `json:"text"`
+	Data      any       `json:"data,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// operatorWebhook posts moderation events to a URL chosen by the operator,
+// so admins of small instances hear about problems without watching the
+// report queue.
+type operatorWebhook struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+// newOperatorWebhook returns nil when url is empty, which turns operator
+// notifications off.
+func newOperatorWebhook(url, format string) (*operatorWebhook, error) {
+	if url == "" {
+		return nil, nil
+	}
+	switch format {
+	case "":
+		format = webhookFormatJSON
+	case webhookFormatJSON, webhookFormatSlack, webhookFormatDiscord:
+	default:
+		return nil, fmt.Errorf("unknown operator webhook format %q, expecting %s, %s or %s", format, webhookFormatJSON, webhookFormatSlack, webhookFormatDiscord)
+	}
+	return &operatorWebhook{url: url, format: format, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// payload shapes event for the configured format.
+func (h *operatorWebhook) payload(event operatorEvent) any {
+	switch h.format {
+	case webhookFormatSlack:
+		return map[string]string{"text": event.Text}
+	case webhookFormatDiscord:
+		return map[string]string{"content": event.Text}
+	}
+	return event
+}
+
+// Post delivers event, failing on anything but a 2xx answer so the job
+// queue retries it.
+func (h *operatorWebhook) Post(ctx context.Context, event operatorEvent) error {
+	body, err := json.Marshal(h.payload(event))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("operator webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// notifyOperator queues event for the operator webhook, if there is one.
+// Like sendVerification it only logs errors: the request that caused the
+// event should not fail because the webhook is down.
+func (cfg *apiConfig) notifyOperator(ctx context.Context, event operatorEvent) {
+	if cfg.operatorHook == nil {
+		return
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now().UTC()
+	}
+	if _, err := cfg.enqueueJob(ctx, jobOperatorWebhook, event, time.Time{}); err != nil {
+		logRequestf(requestId(ctx), "Error queueing operator webhook for %s: %s", event.Event, err)
+	}
+}
+
+func (cfg *apiConfig) operatorWebhookJob(ctx context.Context, payload json.RawMessage) (any, error) {
+	event := operatorEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return nil, cfg.operatorHook.Post(ctx, event)
+}
+
+// reportCreatedEvent tells operators a chirp was reported.
+func reportCreatedEvent(report database.Report) operatorEvent {
+	text := fmt.Sprintf("Chirp %d was reported by user %d", report.ChirpId, report.ReporterId)
+	if report.Reason != "" {
+		text += ": " + report.Reason
+	}
+	return operatorEvent{Event: "report.created", Text: text, Data: report, CreatedAt: report.CreatedAt}
+}
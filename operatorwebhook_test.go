@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOperatorWebhook(t *testing.T) {
+	var status int
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = nil
+		json.Unmarshal(body, &got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	event := operatorEvent{Event: "report.created", Text: "Chirp 1 was reported", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	cases := []struct {
+		format string
+		key    string
+	}{
+		{"", "event"},
+		{webhookFormatSlack, "text"},
+		{webhookFormatDiscord, "content"},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for operatorWebhook.Post with: format %q, and expecting: a %q field", c.format, c.key)
+		hook, err := newOperatorWebhook(server.URL, c.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status = 204
+		if err := hook.Post(context.Background(), event); err != nil {
+			t.Errorf("Expecting: no error, but got: %v", err)
+		}
+		if _, ok := got[c.key]; !ok {
+			t.Errorf("Expecting: a %q field, but got: %v", c.key, got)
+		}
+	}
+
+	t.Logf("Starting test for operatorWebhook.Post with: a 500 answer, and expecting: an error")
+	hook, _ := newOperatorWebhook(server.URL, "")
+	status = 500
+	if err := hook.Post(context.Background(), event); err == nil {
+		t.Errorf("Expecting: an error, but got: nil")
+	}
+
+	t.Logf("Starting test for newOperatorWebhook with: no URL, and expecting: no webhook")
+	if hook, err := newOperatorWebhook("", "slack"); hook != nil || err != nil {
+		t.Errorf("Expecting: nil, nil, but got: %v, %v", hook, err)
+	}
+
+	t.Logf("Starting test for newOperatorWebhook with: an unknown format, and expecting: an error")
+	if _, err := newOperatorWebhook(server.URL, "teams"); err == nil {
+		t.Errorf("Expecting: an error, but got: nil")
+	}
+}
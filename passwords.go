@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/avearmin/chirpy/internal/validation"
+)
+
+// loadPasswordPolicy builds the password policy from the environment. The
+// common passwords are refused unless PASSWORD_BLOCK_COMMON is false, and
+// PASSWORD_BLOCKLIST_FILE names a file of more, one per line.
+func loadPasswordPolicy() (validation.PasswordPolicy, error) {
+	policy := validation.PasswordPolicy{
+		MinLength:         envInt("PASSWORD_MIN_LENGTH", 8),
+		Blocklist:         make(map[string]bool),
+		RequireComplexity: envBool("PASSWORD_REQUIRE_COMPLEXITY", false),
+	}
+	if envBool("PASSWORD_BLOCK_COMMON", true) {
+		policy.Blocklist = validation.CommonPasswords()
+	}
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return validation.PasswordPolicy{}, err
+		}
+		defer file.Close()
+		if err := validation.AddToBlocklist(policy.Blocklist, file); err != nil {
+			return validation.PasswordPolicy{}, err
+		}
+	}
+	return policy, nil
+}
+
+// checkPassword answers 400 with the rules password breaks and returns
+// false when it does not meet the password policy.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, password string) bool {
+	err := cfg.passwordPolicy.Check(password)
+	var violation *validation.Error
+	if errors.As(err, &violation) {
+		respondPolicyViolation(w, violation)
+		return false
+	}
+	return true
+}
+
+// respondPolicyViolation tells the client every rule its input broke.
+func respondPolicyViolation(w http.ResponseWriter, violation *validation.Error) {
+	type returnVal struct {
+		Error      string                 `json:"error"`
+		Field      string                 `json:"field"`
+		Violations []validation.Violation `json:"violations"`
+	}
+	data, err := json.Marshal(returnVal{
+		Error:      violation.Field + " does not meet the policy",
+		Field:      violation.Field,
+		Violations: violation.Violations,
+	})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	w.Write(data)
+}
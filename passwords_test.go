@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/avearmin/chirpy/internal/validation"
+)
+
+func TestPasswordPolicyEnforced(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		db:             db,
+		tokens:         auth.NewIssuer("secret"),
+		jobs:           newJobQueue(time.Second, time.Second, time.Minute),
+		passwordPolicy: validation.PasswordPolicy{MinLength: 8, Blocklist: validation.CommonPasswords()},
+	}
+
+	runPasswordPolicyHandlerTest(t, cfg.postUsersHandler, "", `{"email":"boots@example.com","password":""}`, []string{validation.RuleMinLength})
+	runPasswordPolicyHandlerTest(t, cfg.postUsersHandler, "", `{"email":"boots@example.com","password":"password123"}`, []string{validation.RuleCommon})
+	runPasswordPolicyHandlerTest(t, cfg.postUsersHandler, "", `{"email":"boots@example.com","password":"correct horse"}`, nil)
+
+	user, err := db.GetUser("boots@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := cfg.tokens.NewAccessToken(user.Id)
+	runPasswordPolicyHandlerTest(t, cfg.updateUserCredsHandler, token, `{"email":"boots@example.com","password":"hunter2"}`, []string{validation.RuleMinLength, validation.RuleCommon})
+	if err := db.ComparePasswords("correct horse", "boots@example.com"); err != nil {
+		t.Errorf("Expecting: the password unchanged, but got: %v", err)
+	}
+}
+
+func runPasswordPolicyHandlerTest(t *testing.T, handler func(w http.ResponseWriter, r *http.Request), token, body string, expecting []string) {
+	t.Logf("Starting test for the password policy with: %s, and expecting: %v", body, expecting)
+	req := httptest.NewRequest("POST", "/api/users", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	if expecting == nil {
+		if resp.Code == 400 {
+			t.Errorf("Expecting: the password accepted, but got: %s", resp.Body.String())
+		}
+		return
+	}
+	var got struct {
+		Field      string                 `json:"field"`
+		Violations []validation.Violation `json:"violations"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, v := range got.Violations {
+		rules = append(rules, v.Rule)
+	}
+	if resp.Code != 400 || got.Field != "password" || !reflect.DeepEqual(rules, expecting) {
+		t.Errorf("Expecting: 400 with %v, but got: %d %s", expecting, resp.Code, resp.Body.String())
+	}
+}
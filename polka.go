@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// When a webhook secret is configured, Polka webhooks must carry a
+// signature as well as the API key. The signature is the hex encoded
+// HMAC-SHA256, keyed with the secret, of
+//
+//	TIMESTAMP + "." + NONCE + "." + BODY
+//
+// where TIMESTAMP is the Unix time and NONCE a value Polka never sends twice.
+// Each nonce is only accepted once, so a captured webhook cannot be replayed.
+const (
+	polkaTimestampHeader = "X-Polka-Timestamp"
+	polkaNonceHeader     = "X-Polka-Nonce"
+	polkaSignatureHeader = "X-Polka-Signature"
+)
+
+// nonceCache remembers the nonces used recently. Requests older than
+// signatureMaxSkew are refused on their timestamp alone, so a nonce only
+// needs remembering for as long as a request carrying it could be accepted.
+type nonceCache struct {
+	mux  sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// use records nonce and reports whether it is fresh.
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for seen, expiry := range c.seen {
+		if now.After(expiry) {
+			delete(c.seen, seen)
+		}
+	}
+	if _, found := c.seen[nonce]; found {
+		return false
+	}
+	c.seen[nonce] = now.Add(2 * signatureMaxSkew)
+	return true
+}
+
+func signPolkaWebhook(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + nonce + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyPolkaSignature checks the signature on a webhook with body. The
+// reason, when not empty, says why it was refused.
+func (cfg *apiConfig) verifyPolkaSignature(header http.Header, body []byte, now time.Time) string {
+	timestamp, nonce, signature := header.Get(polkaTimestampHeader), header.Get(polkaNonceHeader), header.Get(polkaSignatureHeader)
+	if signature == "" || nonce == "" {
+		return "no signature"
+	}
+	if !timestampWithinSkew(timestamp, now) {
+		return "stale timestamp " + timestamp
+	}
+	expected := signPolkaWebhook(cfg.polkaSecret, timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "bad signature"
+	}
+	// Only a correctly signed nonce is remembered, so forged requests
+	// cannot use up nonces Polka has yet to send.
+	if !cfg.polkaNonces.use(nonce, now) {
+		return "replayed nonce " + nonce
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestPolkaWebhook(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := db.CreateUser("saul@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, polkaApiKey: "polka-key", polkaSecret: "polka-secret", polkaNonces: newNonceCache()}
+	body := `{"event": "user.upgraded", "data": {"user_id": ` + strconv.Itoa(user.Id) + `}}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	cases := []struct {
+		name      string
+		apiKey    string
+		timestamp string
+		nonce     string
+		signature string
+		expecting int
+	}{
+		{"a wrong API key", "leaked", now, "n1", signPolkaWebhook("polka-secret", now, "n1", []byte(body)), 401},
+		{"no signature", "polka-key", now, "n1", "", 401},
+		{"a signature with another secret", "polka-key", now, "n1", signPolkaWebhook("guess", now, "n1", []byte(body)), 401},
+		{"a stale timestamp", "polka-key", stale, "n1", signPolkaWebhook("polka-secret", stale, "n1", []byte(body)), 401},
+		{"a valid signature", "polka-key", now, "n1", signPolkaWebhook("polka-secret", now, "n1", []byte(body)), 200},
+		{"a replayed nonce", "polka-key", now, "n1", signPolkaWebhook("polka-secret", now, "n1", []byte(body)), 401},
+		{"a fresh nonce", "polka-key", now, "n2", signPolkaWebhook("polka-secret", now, "n2", []byte(body)), 200},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for postPolkaWebhookHandler with: %s, and expecting: %d", c.name, c.expecting)
+		r := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+		r.Header.Set("Authorization", "ApiKey "+c.apiKey)
+		r.Header.Set(polkaTimestampHeader, c.timestamp)
+		r.Header.Set(polkaNonceHeader, c.nonce)
+		r.Header.Set(polkaSignatureHeader, c.signature)
+		w := httptest.NewRecorder()
+		cfg.postPolkaWebhookHandler(w, r)
+		if w.Code != c.expecting {
+			t.Errorf("Expecting: %d, but got: %d", c.expecting, w.Code)
+		}
+	}
+	if user, err := db.GetUserById(user.Id); err != nil || !user.IsChirpyRed {
+		t.Errorf("Expecting: the user upgraded, but got: %+v (err: %v)", user, err)
+	}
+
+	t.Logf("Starting test for postPolkaWebhookHandler with: no secret configured, and expecting: the API key alone accepted")
+	cfg.polkaSecret = ""
+	r := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(body))
+	r.Header.Set("Authorization", "ApiKey polka-key")
+	w := httptest.NewRecorder()
+	cfg.postPolkaWebhookHandler(w, r)
+	if w.Code != 200 {
+		t.Errorf("Expecting: 200, but got: %d", w.Code)
+	}
+}
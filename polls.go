@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 4
+	maxPollOptionLength = 50
+	minPollDuration     = 5 * time.Minute
+	defaultPollDuration = 24 * time.Hour
+)
+
+// pollRequest is a poll to attach to a new chirp. Duration is a
+// time.ParseDuration string, 24h when empty.
+type pollRequest struct {
+	Options  []string `json:"options"`
+	Duration string   `json:"duration"`
+}
+
+// parsePoll checks a poll request and returns its options, censored like
+// chirp bodies, and when it closes. The reason, when not empty, explains
+// what to fix.
+func (cfg *apiConfig) parsePoll(poll pollRequest, now time.Time) ([]string, time.Time, string) {
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return nil, time.Time{}, fmt.Sprintf("a poll has %d to %d options", minPollOptions, maxPollOptions)
+	}
+	options := make([]string, len(poll.Options))
+	seen := make(map[string]bool, len(poll.Options))
+	for i, option := range poll.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || utf8.RuneCountInString(option) > maxPollOptionLength {
+			return nil, time.Time{}, fmt.Sprintf("poll options must be 1 to %d characters long", maxPollOptionLength)
+		}
+		if seen[strings.ToLower(option)] {
+			return nil, time.Time{}, "poll options must differ from each other"
+		}
+		seen[strings.ToLower(option)] = true
+		options[i], _ = cfg.profanity.clean(option)
+	}
+	duration := defaultPollDuration
+	if poll.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(poll.Duration)
+		if err != nil {
+			return nil, time.Time{}, "poll duration must be a duration such as 30m or 24h"
+		}
+	}
+	if duration < minPollDuration || (cfg.pollMaxDuration > 0 && duration > cfg.pollMaxDuration) {
+		return nil, time.Time{}, fmt.Sprintf("poll duration must be from %s to %s", minPollDuration, cfg.pollMaxDuration)
+	}
+	return options, now.Add(duration), ""
+}
+
+// postPollVoteHandler records the user's vote in a chirp's poll and answers
+// with the chirp, tallies included.
+func (cfg *apiConfig) postPollVoteHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	chirpId, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	type parameters struct {
+		// Option is the index of the option voted for.
+		Option *int `json:"option"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if params.Option == nil {
+		respondValidationError(w, "option is required")
+		return
+	}
+	_, err = cfg.store(r.Context()).VotePoll(chirpId, userId, *params.Option)
+	if errors.Is(err, database.ErrInvalidPollOption) {
+		respondValidationError(w, err.Error())
+		return
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	chirp, found, err := cfg.store(r.Context()).GetChirp(chirpId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	resp, err := cfg.renderChirp(r.Context(), chirp)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// closePollsWorker closes the polls that are due every interval until ctx
+// is done.
+func (cfg *apiConfig) closePollsWorker(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			closed, err := cfg.store(ctx).ClosePolls(now)
+			if err != nil {
+				return fmt.Errorf("closing polls: %w", err)
+			}
+			if len(closed) > 0 {
+				log.Printf("Closed %d polls", len(closed))
+			}
+		}
+	}
+}
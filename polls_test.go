@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPolls(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		db:              db,
+		tokens:          auth.NewIssuer("secret"),
+		maxChirpLength:  140,
+		numericChirpIds: true,
+		pollMaxDuration: 7 * 24 * time.Hour,
+		profanity:       newProfanityFilter(database.DefaultBlockedWords),
+		broker:          newChirpBroker(),
+		inboxes:         newFeedInboxes(2, 1),
+	}
+	router := chi.NewRouter()
+	router.Post("/api/chirps", cfg.postChirpsHandler)
+	router.Post("/api/chirps/{id}/vote", cfg.postPollVoteHandler)
+	user, _ := db.CreateUser("pollster@example.com", "hash")
+	verification, _ := db.CreateVerificationToken(user.Id, time.Hour)
+	if _, err := db.VerifyUser(verification); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := cfg.tokens.NewAccessToken(user.Id)
+
+	for _, poll := range []string{
+		`{"options":["Only one"]}`,
+		`{"options":["A","B","C","D","E"]}`,
+		`{"options":["Same","same "]}`,
+		`{"options":["A",""]}`,
+		`{"options":["A","B"],"duration":"1m"}`,
+		`{"options":["A","B"],"duration":"30d"}`,
+		`{"options":["A","B"],"duration":"soon"}`,
+	} {
+		runDraftRequest(t, router, token, "POST", "/api/chirps", `{"body":"Which?","poll":`+poll+`}`, 400)
+	}
+	runDraftRequest(t, router, token, "POST", "/api/chirps", `{"body":"Later?","publish_at":"2099-01-01T00:00:00Z","poll":{"options":["A","B"]}}`, 400)
+
+	t.Logf("Starting test for postChirpsHandler with: a poll, and expecting: it open with no votes")
+	resp := runDraftRequest(t, router, token, "POST", "/api/chirps", `{"body":"Best word?","poll":{"options":["Kerfuffle","Hullabaloo"],"duration":"1h"}}`, 201)
+	chirp := chirpResponse{}
+	json.Unmarshal(resp.Body.Bytes(), &chirp)
+	if chirp.Poll == nil || len(chirp.Poll.Options) != 2 || chirp.Poll.Options[0].Title != censoredWord || chirp.Poll.ClosesAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("Expecting: a censored poll closing in an hour, but got: %+v", chirp.Poll)
+	}
+
+	path := "/api/chirps/" + strconv.Itoa(chirp.Id) + "/vote"
+	runDraftRequest(t, router, token, "POST", path, `{}`, 400)
+	runDraftRequest(t, router, token, "POST", path, `{"option":2}`, 400)
+	resp = runDraftRequest(t, router, token, "POST", path, `{"option":1}`, 200)
+	json.Unmarshal(resp.Body.Bytes(), &chirp)
+	if chirp.Poll.VotesCount != 1 || chirp.Poll.Options[1].Votes != 1 {
+		t.Errorf("Expecting: one vote for the second option, but got: %+v", chirp.Poll)
+	}
+	runDraftRequest(t, router, token, "POST", path, `{"option":0}`, 409)
+	runDraftRequest(t, router, token, "POST", "/api/chirps/9999/vote", `{"option":0}`, 404)
+}
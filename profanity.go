@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// censoredWord replaces each word the profanity filter catches in a chirp.
+const censoredWord = "****"
+
+// validBlockedWord allows patterns of lowercase words, in which * matches
+// any run of characters, separated by single spaces.
+var validBlockedWord = regexp.MustCompile(`^[\p{Ll}\p{N}'*-]+( [\p{Ll}\p{N}'*-]+)*$`)
+
+// wordPattern is a blocked word compiled for matching.
+type wordPattern struct {
+	// words match the consecutive words of a chirp the pattern catches.
+	words []*regexp.Regexp
+	// handle matches the pattern anywhere in a handle, which has no spaces
+	// between its words.
+	handle *regexp.Regexp
+}
+
+func compileWordPattern(pattern string) wordPattern {
+	var compiled wordPattern
+	var inHandle []string
+	for _, word := range strings.Fields(pattern) {
+		quoted := strings.ReplaceAll(regexp.QuoteMeta(word), `\*`, `.*`)
+		compiled.words = append(compiled.words, regexp.MustCompile(`(?i)^`+quoted+`$`))
+		inHandle = append(inHandle, strings.ReplaceAll(regexp.QuoteMeta(word), `\*`, `\w*`))
+	}
+	compiled.handle = regexp.MustCompile(`(?i)` + strings.Join(inHandle, `_?`))
+	return compiled
+}
+
+// profanityFilter censors blocked words in chirps. Its patterns live in the
+// database and are reloaded when admins change them, and periodically to
+// pick up changes made through other servers.
+type profanityFilter struct {
+	mux      sync.RWMutex
+	patterns []wordPattern
+}
+
+func newProfanityFilter(patterns []string) *profanityFilter {
+	f := &profanityFilter{}
+	f.set(patterns)
+	return f
+}
+
+func (f *profanityFilter) set(patterns []string) {
+	compiled := make([]wordPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		compiled = append(compiled, compileWordPattern(pattern))
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.patterns = compiled
+}
+
+// clean censors the blocked words and phrases in body, and reports whether
+// it censored any.
+func (f *profanityFilter) clean(body string) (string, bool) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	words := strings.Split(body, " ")
+	censored := false
+	for i := 0; i < len(words); i++ {
+		for _, pattern := range f.patterns {
+			if n := pattern.match(words[i:]); n > 0 {
+				for j := i; j < i+n; j++ {
+					words[j] = censoredWord
+				}
+				censored = true
+				i += n - 1
+				break
+			}
+		}
+	}
+	return strings.Join(words, " "), censored
+}
+
+// match returns how many of the leading words the pattern catches, or 0.
+func (p wordPattern) match(words []string) int {
+	if len(words) < len(p.words) {
+		return 0
+	}
+	for i, word := range p.words {
+		if !word.MatchString(words[i]) {
+			return 0
+		}
+	}
+	return len(p.words)
+}
+
+// inHandle reports whether a blocked word appears anywhere in handle.
+func (f *profanityFilter) inHandle(handle string) bool {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	for _, pattern := range f.patterns {
+		if pattern.handle.MatchString(handle) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadProfanity replaces the filter's patterns with those in the
+// database.
+func (cfg *apiConfig) reloadProfanity(ctx context.Context) error {
+	words, err := cfg.store(ctx).GetBlockedWords()
+	if err != nil {
+		return err
+	}
+	patterns := make([]string, len(words))
+	for i, word := range words {
+		patterns[i] = word.Pattern
+	}
+	cfg.profanity.set(patterns)
+	return nil
+}
+
+// reloadProfanityWorker reloads the filter every interval until ctx is done.
+func (cfg *apiConfig) reloadProfanityWorker(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := cfg.reloadProfanity(ctx); err != nil {
+				return fmt.Errorf("reloading the profanity filter: %w", err)
+			}
+		}
+	}
+}
+
+// normalizeBlockedWord lowercases pattern and collapses the spaces in it.
+func normalizeBlockedWord(pattern string) string {
+	return strings.Join(strings.Fields(strings.ToLower(pattern)), " ")
+}
+
+func (cfg *apiConfig) getBlockedWordsHandler(w http.ResponseWriter, r *http.Request) {
+	words, err := cfg.store(r.Context()).GetBlockedWords()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(words)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) postBlockedWordHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Pattern string `json:"pattern"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	pattern := normalizeBlockedWord(params.Pattern)
+	onlyWildcards := func(word string) bool { return strings.Trim(word, "*") == "" }
+	if !validBlockedWord.MatchString(pattern) || slices.ContainsFunc(strings.Fields(pattern), onlyWildcards) {
+		respondValidationError(w, "pattern must be one or more words of letters, digits, ' or -, in which * matches any characters; a word cannot be * alone")
+		return
+	}
+
+	word, err := cfg.store(r.Context()).AddBlockedWord(pattern)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if err := cfg.reloadProfanity(r.Context()); err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(word)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// deleteBlockedWordHandler takes the pattern from the query string, since
+// patterns can hold spaces.
+func (cfg *apiConfig) deleteBlockedWordHandler(w http.ResponseWriter, r *http.Request) {
+	err := cfg.store(r.Context()).DeleteBlockedWord(normalizeBlockedWord(r.URL.Query().Get("pattern")))
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if err := cfg.reloadProfanity(r.Context()); err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+// validHandle matches the same characters richtext recognises in a mention.
+var validHandle = regexp.MustCompile(`^@?[A-Za-z0-9_]{1,30}$`)
+
+// defaultReservedHandles could be mistaken for the instance itself.
+var defaultReservedHandles = []string{"admin", "api", "root", "support"}
+
+// reservedHandles parses a comma-separated list of handles nobody may
+// claim, falling back to defaultReservedHandles when the list is empty.
+func reservedHandles(list string) map[string]bool {
+	handles := defaultReservedHandles
+	if strings.TrimSpace(list) != "" {
+		handles = strings.Split(list, ",")
+	}
+	reserved := make(map[string]bool, len(handles))
+	for _, handle := range handles {
+		if handle = normalizeHandle(handle); handle != "" {
+			reserved[handle] = true
+		}
+	}
+	return reserved
+}
+
+func normalizeHandle(handle string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(handle), "@"))
+}
+
+// handleRejection explains why a well-formed handle may not be claimed, or
+// returns "" if it may. Profanity is refused anywhere in the handle, since
+// handles have no spaces to split words on.
+func (cfg *apiConfig) handleRejection(handle string) string {
+	handle = normalizeHandle(handle)
+	if cfg.reservedHandles[handle] {
+		return "handle @" + handle + " is reserved"
+	}
+	if cfg.profanity.inHandle(handle) {
+		return "handle @" + handle + " contains a word that is not allowed"
+	}
+	return ""
+}
+
+// validateProfileUpdate reports whether every field set in update is
+// acceptable. An empty string clears a field and is always allowed.
+func validateProfileUpdate(update database.ProfileUpdate) bool {
+	if update.Handle != nil && *update.Handle != "" && !validHandle.MatchString(*update.Handle) {
+		return false
+	}
+	if update.DisplayName != nil && utf8.RuneCountInString(*update.DisplayName) > maxDisplayNameLength {
+		return false
+	}
+	if update.Bio != nil && utf8.RuneCountInString(*update.Bio) > maxBioLength {
+		return false
+	}
+	for _, link := range []*string{update.AvatarURL, update.BannerURL, update.Website, update.MovedTo, update.MovedFrom} {
+		if link != nil && *link != "" && !validHTTPURL(*link) {
+			return false
+		}
+	}
+	return true
+}
+
+// validHTTPURL reports whether link is an absolute http or https URL.
+func validHTTPURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (cfg *apiConfig) patchUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	type parameters struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+		BannerURL   *string `json:"banner_url"`
+		Website     *string `json:"website"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	update := database.ProfileUpdate{
+		Handle:      params.Handle,
+		DisplayName: params.DisplayName,
+		Bio:         params.Bio,
+		AvatarURL:   params.AvatarURL,
+		BannerURL:   params.BannerURL,
+		Website:     params.Website,
+	}
+	if update.DisplayName != nil {
+		trimmed := strings.TrimSpace(*update.DisplayName)
+		update.DisplayName = &trimmed
+	}
+	if !validateProfileUpdate(update) {
+		w.WriteHeader(400)
+		return
+	}
+	if update.Handle != nil && *update.Handle != "" {
+		if reason := cfg.handleRejection(*update.Handle); reason != "" {
+			respondRejectedError(w, reason)
+			return
+		}
+	}
+	cfg.updateProfile(w, r, userId, update)
+}
+
+func (cfg *apiConfig) updateProfile(w http.ResponseWriter, r *http.Request, userId int, update database.ProfileUpdate) {
+	user, err := cfg.store(r.Context()).UpdateProfile(userId, update)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// putAvatarHandler sets the user's avatar to an image uploaded like media.
+// The image it replaces is left for media garbage collection.
+func (cfg *apiConfig) putAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.putProfileImage(w, r, func(update *database.ProfileUpdate, url string) { update.AvatarURL = &url })
+}
+
+// putBannerHandler sets the image across the top of the user's profile.
+func (cfg *apiConfig) putBannerHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.putProfileImage(w, r, func(update *database.ProfileUpdate, url string) { update.BannerURL = &url })
+}
+
+func (cfg *apiConfig) putProfileImage(w http.ResponseWriter, r *http.Request, set func(*database.ProfileUpdate, string)) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	media, ok := cfg.receiveMedia(w, r, userId)
+	if !ok {
+		return
+	}
+	update := database.ProfileUpdate{}
+	set(&update, "/media/"+media.Id)
+	cfg.updateProfile(w, r, userId, update)
+}
+
+func (cfg *apiConfig) getUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(user.Profile())
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/blobstore"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestHandles(t *testing.T) {
+	cfg := &apiConfig{reservedHandles: reservedHandles(""), profanity: newProfanityFilter(database.DefaultBlockedWords)}
+	runHandleRejectionTest(t, cfg, "chirper", true)
+	runHandleRejectionTest(t, cfg, "@Admin", false)
+	runHandleRejectionTest(t, cfg, "support", false)
+	runHandleRejectionTest(t, cfg, "the_Kerfuffle_king", false)
+	cfg.profanity.set([]string{"what the fork*"})
+	runHandleRejectionTest(t, cfg, "what_the_forker", false)
+	runHandleRejectionTest(t, cfg, "the_Kerfuffle_king", true)
+
+	cfg.reservedHandles = reservedHandles(" staff, @Mod ")
+	runHandleRejectionTest(t, cfg, "admin", true)
+	runHandleRejectionTest(t, cfg, "mod", false)
+	runHandleRejectionTest(t, cfg, "@staff", false)
+}
+
+func runHandleRejectionTest(t *testing.T, cfg *apiConfig, handle string, expecting bool) {
+	t.Logf("Starting test for handleRejection with: %s, and expecting: %t", handle, expecting)
+	reason := cfg.handleRejection(handle)
+	if got := reason == ""; got != expecting {
+		t.Errorf("Expecting: %t, but got: %t (%s)", expecting, got, reason)
+	}
+}
+
+func TestProfileBanner(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), blobs: blobs, mediaMaxBytes: 1 << 10}
+	user, _ := db.CreateUser("banner@example.com", "hash")
+	token, _ := cfg.tokens.NewAccessToken(user.Id)
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "banner.png")
+	part.Write(append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 24)...))
+	writer.Close()
+
+	t.Logf("Starting test for putBannerHandler with: a png, and expecting: the profile's banner_url pointing at it")
+	req := httptest.NewRequest("PUT", "/api/users/me/banner", &form)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	cfg.putBannerHandler(resp, req)
+	if resp.Code != 200 {
+		t.Fatalf("Expecting: 200, but got: %d %s", resp.Code, resp.Body.String())
+	}
+	updated, _ := db.GetUserById(user.Id)
+	media, err := db.GetMedia(strings.TrimPrefix(updated.Profile().BannerURL, "/media/"))
+	if err != nil || media.OwnerId != user.Id || updated.AvatarURL != "" {
+		t.Errorf("Expecting: a banner of the user's media, but got: %+v (%v)", updated.Profile(), err)
+	}
+
+	bad := "javascript:alert(1)"
+	t.Logf("Starting test for validateProfileUpdate with: banner_url %q, and expecting: false", bad)
+	if validateProfileUpdate(database.ProfileUpdate{BannerURL: &bad}) {
+		t.Errorf("Expecting: false, but got: true")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitPrune is how often idle buckets are dropped.
+const rateLimitPrune = time.Minute
+
+// rateLimitExempt lists paths that are never limited, so health checks keep
+// working for a load balancer sharing an address with busy clients.
+var rateLimitExempt = map[string]bool{
+	"/api/healthz": true,
+	"/api/readyz":  true,
+}
+
+// rateLimit allows perMinute requests a minute on average, and up to burst
+// at once. A perMinute of zero or less disables the limit.
+type rateLimit struct {
+	perMinute int
+	burst     int
+}
+
+// rateLimits holds the limit for each kind of client: anonymous clients by
+// address, signed-in users by id, and Chirpy Red members by id. Up to jitter
+// is added at random to each Retry-After, so that scrapers backing off do
+// not all come back at the same moment and cannot time the limit exactly.
+type rateLimits struct {
+	ip     rateLimit
+	user   rateLimit
+	red    rateLimit
+	jitter time.Duration
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled, after which it can be
+	// dropped since a new bucket would behave the same.
+	full time.Time
+}
+
+// rateLimiter keeps a token bucket per client key.
+type rateLimiter struct {
+	mux     sync.Mutex
+	limits  rateLimits
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(limits rateLimits) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from key's bucket at now. It returns how many tokens
+// are left and, when the bucket is empty, how long until the next one.
+func (l *rateLimiter) allow(key string, limit rateLimit, now time.Time) (int, time.Duration, bool) {
+	if limit.perMinute <= 0 {
+		return 0, 0, true
+	}
+	burst := float64(max(limit.burst, 1))
+	perSecond := float64(limit.perMinute) / 60
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = min(burst, bucket.tokens+max(elapsed, 0)*perSecond)
+	bucket.last = now
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.full = now.Add(time.Duration((burst - bucket.tokens) / perSecond * float64(time.Second)))
+	if !allowed {
+		return 0, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second)), false
+	}
+	return int(bucket.tokens), 0, true
+}
+
+// prune drops the buckets that have refilled by now.
+func (l *rateLimiter) prune(now time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for key, bucket := range l.buckets {
+		if !now.Before(bucket.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// pruneWorker drops refilled buckets every rateLimitPrune until ctx is done.
+func (l *rateLimiter) pruneWorker(ctx context.Context) error {
+	ticker := time.NewTicker(rateLimitPrune)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			l.prune(now)
+		}
+	}
+}
+
+// rateLimitKey picks the bucket and limit for r: the user's when the request
+// is signed or carries a valid access token, and the client address's
+// otherwise.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) (string, rateLimit) {
+	userId, ok := cfg.requestUserId(r)
+	if !ok {
+		return "ip:" + clientIP(r), cfg.limiter.limits.ip
+	}
+	key := "user:" + strconv.Itoa(userId)
+	if user, err := cfg.store(r.Context()).GetUserById(userId); err == nil && user.IsChirpyRed {
+		return key, cfg.limiter.limits.red
+	}
+	return key, cfg.limiter.limits.user
+}
+
+// middlewareRateLimit refuses requests over the client's limit with 429 and
+// a Retry-After header saying when to try again.
+func (cfg *apiConfig) middlewareRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rateLimitExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, limit := cfg.rateLimitKey(r)
+		remaining, retryAfter, ok := cfg.limiter.allow(key, limit, time.Now())
+		if limit.perMinute > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.perMinute))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		}
+		if !ok {
+			if cfg.limiter.limits.jitter > 0 {
+				retryAfter += time.Duration(rand.Int63n(int64(cfg.limiter.limits.jitter)))
+			}
+			respondRateLimited(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: "too many requests"})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(data)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(rateLimits{})
+	limit := rateLimit{perMinute: 60, burst: 2}
+	start := time.Now()
+
+	runRateLimitTest(t, limiter, "ip:a", limit, start, true, 0)
+	runRateLimitTest(t, limiter, "ip:a", limit, start, true, 0)
+	runRateLimitTest(t, limiter, "ip:a", limit, start, false, time.Second)
+	runRateLimitTest(t, limiter, "ip:b", limit, start, true, 0)
+	runRateLimitTest(t, limiter, "ip:a", limit, start.Add(500*time.Millisecond), false, 500*time.Millisecond)
+	runRateLimitTest(t, limiter, "ip:a", limit, start.Add(time.Second), true, 0)
+	runRateLimitTest(t, limiter, "ip:a", rateLimit{}, start.Add(time.Second), true, 0)
+
+	limiter.prune(start.Add(time.Second))
+	if _, found := limiter.buckets["ip:a"]; !found {
+		t.Errorf("Expecting: ip:a kept while refilling, but got: pruned")
+	}
+	limiter.prune(start.Add(time.Minute))
+	if len(limiter.buckets) != 0 {
+		t.Errorf("Expecting: no buckets after refilling, but got: %d", len(limiter.buckets))
+	}
+}
+
+func runRateLimitTest(t *testing.T, limiter *rateLimiter, key string, limit rateLimit, now time.Time, allowed bool, retryAfter time.Duration) {
+	t.Logf("Starting test for rateLimiter.allow with: %s at %s, and expecting: %t (retry after %s)", key, now.Format(time.StampMilli), allowed, retryAfter)
+	_, gotRetry, ok := limiter.allow(key, limit, now)
+	if ok != allowed {
+		t.Errorf("Expecting: %t, but got: %t", allowed, ok)
+	}
+	if gotRetry.Round(time.Millisecond) != retryAfter {
+		t.Errorf("Expecting: retry after %s, but got: %s", retryAfter, gotRetry)
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	cfg := &apiConfig{
+		tokens:  auth.NewIssuer("secret"),
+		limiter: newRateLimiter(rateLimits{ip: rateLimit{perMinute: 1, burst: 1}}),
+	}
+	handler := cfg.middlewareRateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+
+	runMiddlewareRateLimitTest(t, handler, "/api/chirps", 200, "")
+	runMiddlewareRateLimitTest(t, handler, "/api/chirps", 429, "60")
+	runMiddlewareRateLimitTest(t, handler, "/api/healthz", 200, "")
+
+	cfg.limiter.limits.jitter = 10 * time.Second
+	t.Logf("Starting test for middlewareRateLimit with: 10s of jitter, and expecting: Retry-After from 60 to 70")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/chirps", nil))
+	if seconds, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || seconds < 60 || seconds > 70 {
+		t.Errorf("Expecting: Retry-After from 60 to 70, but got: %q", w.Header().Get("Retry-After"))
+	}
+}
+
+func runMiddlewareRateLimitTest(t *testing.T, handler http.Handler, path string, status int, retryAfter string) {
+	t.Logf("Starting test for middlewareRateLimit with: %s, and expecting: %d (Retry-After %q)", path, status, retryAfter)
+	r := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != status {
+		t.Errorf("Expecting: %d, but got: %d", status, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != retryAfter {
+		t.Errorf("Expecting: Retry-After %q, but got: %q", retryAfter, got)
+	}
+}
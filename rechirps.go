@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// original returns the chirp with id, or the chirp it reposts if it is a
+// rechirp, so that rechirps and quotes always point at what was written.
+func (cfg *apiConfig) original(ctx context.Context, id int) (database.Chirp, bool, error) {
+	chirp, found, err := cfg.store(ctx).GetChirp(id)
+	if err != nil || !found || chirp.RechirpOfId == nil {
+		return chirp, found, err
+	}
+	return cfg.store(ctx).GetChirp(*chirp.RechirpOfId)
+}
+
+// quotedChirp checks the chirp a new chirp quotes, returning the id to
+// store. The returned reason, when not empty, explains what to fix.
+func (cfg *apiConfig) quotedChirp(ctx context.Context, id *int) (*int, string, error) {
+	if id == nil {
+		return nil, "", nil
+	}
+	quoted, found, err := cfg.original(ctx, *id)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		return nil, "quoted chirp does not exist", nil
+	}
+	return &quoted.Id, "", nil
+}
+
+// postRechirpHandler reposts a chirp to the user's followers. Rechirping a
+// rechirp reposts the original, and each user can rechirp a chirp once.
+func (cfg *apiConfig) postRechirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) || cfg.rejectUnverified(w, r, userId) {
+		return
+	}
+	id, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	original, found, err := cfg.original(r.Context(), id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	_, rechirped, err := cfg.store(r.Context()).GetRechirp(userId, original.Id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if rechirped {
+		respondDataWriteError(w, database.ErrAlreadyRechirped)
+		return
+	}
+	chirp, err := cfg.store(r.Context()).CreateChirp(database.Chirp{AuthorId: userId, RechirpOfId: &original.Id})
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	resp, err := cfg.announceChirp(r.Context(), chirp)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// deleteRechirpHandler undoes the user's rechirp of a chirp.
+func (cfg *apiConfig) deleteRechirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	id, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	rechirp, found, err := cfg.store(r.Context()).GetRechirp(userId, id)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if !found {
+		w.WriteHeader(404)
+		return
+	}
+	if err := cfg.store(r.Context()).DeleteChirp(rechirp.Id, userId); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.announceDelete(r.Context(), rechirp)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRechirps(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		db:              db,
+		tokens:          auth.NewIssuer("secret"),
+		maxChirpLength:  140,
+		numericChirpIds: true,
+		profanity:       newProfanityFilter(nil),
+		broker:          newChirpBroker(),
+		inboxes:         newFeedInboxes(2, 1),
+	}
+	router := chi.NewRouter()
+	router.Post("/api/chirps", cfg.postChirpsHandler)
+	router.Post("/api/chirps/{id}/rechirp", cfg.postRechirpHandler)
+	router.Delete("/api/chirps/{id}/rechirp", cfg.deleteRechirpHandler)
+	author, _ := db.CreateUser("author@example.com", "hash")
+	sharer, _ := db.CreateUser("sharer@example.com", "hash")
+	for _, user := range []database.User{author, sharer} {
+		verification, _ := db.CreateVerificationToken(user.Id, time.Hour)
+		if _, err := db.VerifyUser(verification); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, _ := cfg.tokens.NewAccessToken(sharer.Id)
+	original, _ := db.CreateChirp(database.Chirp{AuthorId: author.Id, Body: "Worth sharing"})
+	path := "/api/chirps/" + strconv.Itoa(original.Id) + "/rechirp"
+
+	resp := runDraftRequest(t, router, token, "POST", path, "", 201)
+	rechirp := struct {
+		chirpResponse
+		RechirpOf database.Chirp `json:"rechirp_of"`
+	}{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &rechirp); err != nil {
+		t.Fatal(err)
+	}
+	if rechirp.RechirpOf.Id != original.Id || rechirp.RechirpOf.Body != "Worth sharing" || rechirp.RechirpOf.RechirpCount != 1 {
+		t.Errorf("Expecting: the original embedded with 1 rechirp, but got: %+v", rechirp.RechirpOf)
+	}
+	runDraftRequest(t, router, token, "POST", path, "", 409)
+	runDraftRequest(t, router, token, "POST", "/api/chirps/"+strconv.Itoa(rechirp.Id)+"/rechirp", "", 409)
+
+	t.Logf("Starting test for postChirpsHandler with: a quote of the rechirp, and expecting: the original quoted")
+	resp = runDraftRequest(t, router, token, "POST", "/api/chirps", `{"body":"So true","quoted_id":`+strconv.Itoa(rechirp.Id)+`}`, 201)
+	quote := database.Chirp{}
+	json.Unmarshal(resp.Body.Bytes(), &quote)
+	if quote.QuotedId == nil || *quote.QuotedId != original.Id {
+		t.Errorf("Expecting: a quote of %d, but got: %+v", original.Id, quote)
+	}
+	runDraftRequest(t, router, token, "POST", "/api/chirps", `{"body":"Huh","quoted_id":9999}`, 400)
+
+	runDraftRequest(t, router, token, "DELETE", path, "", 204)
+	runDraftRequest(t, router, token, "DELETE", path, "", 404)
+	if got, _, _ := db.GetChirp(original.Id); got.RechirpCount != 0 {
+		t.Errorf("Expecting: 0 rechirps, but got: %d", got.RechirpCount)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxWebsiteBytes is as much of a user's website as is searched for a
+// backlink. rel="me" links belong in the head or near the top of the page.
+const maxWebsiteBytes = 1 << 20
+
+var errNonPublicAddress = errors.New("address is not public")
+
+// newWebsiteClient returns the client that fetches the websites users link
+// from their profiles. Users choose those URLs, so it dials only public
+// addresses, checked after the name resolves, and ignores any proxy that
+// would dial for it.
+func newWebsiteClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !publicAddress(addrPort.Addr()) {
+				return fmt.Errorf("dialing %s: %w", address, errNonPublicAddress)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// publicAddress reports whether addr is reachable on the public internet,
+// rather than the server's own loopback, private or link-local networks.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !addr.IsLoopback() && !addr.IsLinkLocalUnicast()
+}
+
+// relMeLinks returns the targets of the <a> and <link> elements with rel="me"
+// in the page read from r, resolved against the page's URL.
+func relMeLinks(r io.Reader, page *url.URL) []*url.URL {
+	var links []*url.URL
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data != "a" && token.Data != "link" {
+				continue
+			}
+			var rel, href string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					href = attr.Val
+				}
+			}
+			if !hasRelMe(rel) || href == "" {
+				continue
+			}
+			if link, err := page.Parse(href); err == nil {
+				links = append(links, link)
+			}
+		}
+	}
+}
+
+// hasRelMe reports whether the space-separated rel attribute holds "me".
+func hasRelMe(rel string) bool {
+	for _, value := range strings.Fields(rel) {
+		if strings.EqualFold(value, "me") {
+			return true
+		}
+	}
+	return false
+}
+
+// linksToProfile reports whether link points at the profile of userId on
+// the host the request was made to. The scheme is not compared, so sites
+// that link to http:// still count behind a TLS-terminating proxy.
+func linksToProfile(link *url.URL, host string, userId int) bool {
+	return strings.EqualFold(link.Host, host) && strings.TrimSuffix(link.Path, "/") == profilePath(userId)
+}
+
+// findRelMe fetches website and reports whether it links back to the
+// profile of userId with rel="me". The error explains to the user why the
+// site could not be checked.
+func (cfg *apiConfig) findRelMe(ctx context.Context, website, host string, userId int) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", website, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := cfg.websiteClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errNonPublicAddress) {
+			return false, errors.New("website is not on a public address")
+		}
+		return false, errors.New("website could not be reached")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("website answered %s", resp.Status)
+	}
+	// The page redirected to is the one whose relative links count.
+	for _, link := range relMeLinks(io.LimitReader(resp.Body, maxWebsiteBytes), resp.Request.URL) {
+		if linksToProfile(link, host, userId) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// postVerifyWebsiteHandler marks the user's website verified when it links
+// back to their profile with rel="me", as a sign the user controls it.
+func (cfg *apiConfig) postVerifyWebsiteHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if user.Website == "" {
+		respondValidationError(w, "set a website on your profile first")
+		return
+	}
+	found, err := cfg.findRelMe(r.Context(), user.Website, r.Host, userId)
+	if err != nil {
+		respondRejectedError(w, err.Error())
+		return
+	}
+	if !found {
+		respondRejectedError(w, `no rel="me" link to `+profileURL(r, userId)+" found on "+user.Website)
+		return
+	}
+	user, err = cfg.store(r.Context()).VerifyWebsite(userId, user.Website)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestVerifyWebsite(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), profanity: newProfanityFilter(nil), websiteClient: http.DefaultClient}
+	router := chi.NewRouter()
+	router.Patch("/api/users/me", cfg.patchUserProfileHandler)
+	router.Post("/api/users/me/website/verify", cfg.postVerifyWebsiteHandler)
+	user, _ := db.CreateUser("owner@example.com", "hash")
+	token, _ := cfg.tokens.NewAccessToken(user.Id)
+
+	page := ""
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/about/", http.StatusMovedPermanently)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer site.Close()
+
+	runDraftRequest(t, router, token, "POST", "/api/users/me/website/verify", "", 400)
+	runDraftRequest(t, router, token, "PATCH", "/api/users/me", `{"website":"javascript:alert(1)"}`, 400)
+	runDraftRequest(t, router, token, "PATCH", "/api/users/me", `{"website":"`+site.URL+`/old"}`, 200)
+
+	page = `<a href="https://elsewhere.example/api/users/1" rel="me">Me</a><a href="https://example.com/api/users/1">Not me</a><a rel="me" href="/api/users/1">Me</a>`
+	runDraftRequest(t, router, token, "POST", "/api/users/me/website/verify", "", 422)
+
+	t.Logf("Starting test for postVerifyWebsiteHandler with: a rel=\"me\" link back after a redirect, and expecting: the website verified")
+	page = `<html><head><link rel="authn ME" href="//example.com/api/users/1/"></head></html>`
+	resp := runDraftRequest(t, router, token, "POST", "/api/users/me/website/verify", "", 200)
+	got := database.User{}
+	json.Unmarshal(resp.Body.Bytes(), &got)
+	if got.WebsiteVerifiedAt == nil {
+		t.Errorf("Expecting: the website verified, but got: %+v", got)
+	}
+	if stored, _ := db.GetUserById(user.Id); !stored.Profile().WebsiteVerified {
+		t.Errorf("Expecting: a verified profile, but got: %+v", stored.Profile())
+	}
+
+	t.Logf("Starting test for patchUserProfileHandler with: a new website, and expecting: the verification cleared")
+	runDraftRequest(t, router, token, "PATCH", "/api/users/me", `{"website":"`+site.URL+`/elsewhere"}`, 200)
+	if stored, _ := db.GetUserById(user.Id); stored.WebsiteVerifiedAt != nil {
+		t.Errorf("Expecting: an unverified website, but got: %v", stored.WebsiteVerifiedAt)
+	}
+
+	t.Logf("Starting test for newWebsiteClient with: a loopback website, and expecting: it refused")
+	cfg.websiteClient = newWebsiteClient()
+	runDraftRequest(t, router, token, "POST", "/api/users/me/website/verify", "", 422)
+	for addr, public := range map[string]bool{
+		"93.184.216.34": true, "2606:2800:220:1::1": true, "127.0.0.1": false, "10.1.2.3": false,
+		"169.254.169.254": false, "::1": false, "::ffff:192.168.0.1": false, "fd00::1": false, "0.0.0.0": false,
+	} {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("Expecting: %s public %t, but got: %t", addr, public, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rememberCookie carries the refresh token of a remember-me login, signed
+// so the server only ever looks up tokens it handed out itself.
+const rememberCookie = "chirpy_remember"
+
+var errRememberMeNotConfigured = errors.New("remember me is not configured: set REMEMBER_ME_SECRET")
+
+// rememberMe configures remember-me logins for browser clients. Their
+// refresh token lives in an HttpOnly cookie instead of the response body,
+// and every refresh extends the session by ttl, up to maxAge after the
+// login.
+type rememberMe struct {
+	secret []byte
+	ttl    time.Duration
+	maxAge time.Duration
+}
+
+func (rm rememberMe) enabled() bool {
+	return len(rm.secret) > 0
+}
+
+func (rm rememberMe) signature(token string) string {
+	mac := hmac.New(sha256.New, rm.secret)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookieValue is token followed by its signature.
+func (rm rememberMe) cookieValue(token string) string {
+	return token + "." + rm.signature(token)
+}
+
+// token returns the refresh token of a cookie value, if it is signed by
+// this server.
+func (rm rememberMe) token(value string) (string, bool) {
+	token, signature, found := strings.Cut(value, ".")
+	if !found || !rm.enabled() || !hmac.Equal([]byte(signature), []byte(rm.signature(token))) {
+		return "", false
+	}
+	return token, true
+}
+
+// setRememberCookie hands the browser token, to keep until expiresAt. The
+// cookie is only sent to the API on same-site requests, so other sites
+// cannot refresh or revoke the session.
+func (cfg *apiConfig) setRememberCookie(w http.ResponseWriter, r *http.Request, token string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Value:    cfg.remember.cookieValue(token),
+		Path:     "/api/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func clearRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: rememberCookie, Path: "/api/", MaxAge: -1})
+}
+
+// refreshToken returns the refresh token r presents, and whether it came
+// from the remember-me cookie. A bearer token wins over the cookie.
+func (cfg *apiConfig) refreshToken(r *http.Request) (string, bool) {
+	if r.Header.Get("Authorization") != "" {
+		return bearerToken(r), false
+	}
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return "", false
+	}
+	token, ok := cfg.remember.token(cookie.Value)
+	return token, ok
+}
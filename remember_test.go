@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestRememberMe(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateUser("boots@example.com", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{
+		db:          db,
+		tokens:      auth.NewIssuer("secret"),
+		tokenFormat: tokenFormatChirpy,
+		remember:    rememberMe{secret: []byte("cookie secret"), ttl: time.Hour, maxAge: 2 * time.Hour},
+	}
+
+	t.Logf("Starting test for postLoginHandler with: remember_me, and expecting: the refresh token in a cookie only")
+	login := httptest.NewRecorder()
+	cfg.postLoginHandler(login, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"boots@example.com","password":"hunter2","remember_me":true}`)))
+	if login.Code != 200 || strings.Contains(login.Body.String(), `"refresh_token":`) {
+		t.Fatalf("Expecting: 200 without a refresh_token, but got: %d %s", login.Code, login.Body.String())
+	}
+	cookie := runRememberCookieTest(t, login, time.Hour)
+
+	t.Logf("Starting test for postRefreshHandler with: the remember-me cookie, and expecting: a new cookie")
+	refresh := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/refresh", nil)
+	req.AddCookie(cookie)
+	cfg.postRefreshHandler(refresh, req)
+	if refresh.Code != 200 || strings.Contains(refresh.Body.String(), `"refresh_token":`) {
+		t.Fatalf("Expecting: 200 without a refresh_token, but got: %d %s", refresh.Code, refresh.Body.String())
+	}
+	rotated := runRememberCookieTest(t, refresh, time.Hour)
+	if rotated.Value == cookie.Value {
+		t.Errorf("Expecting: a rotated token, but got: the same cookie")
+	}
+
+	t.Logf("Starting test for postRefreshHandler with: a forged cookie, and expecting: 401")
+	token, _, _ := strings.Cut(rotated.Value, ".")
+	forged := httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: rememberCookie, Value: token + ".forged"})
+	cfg.postRefreshHandler(forged, req)
+	if forged.Code != 401 {
+		t.Errorf("Expecting: 401, but got: %d", forged.Code)
+	}
+
+	t.Logf("Starting test for postRevokeHandler with: the remember-me cookie, and expecting: the cookie cleared")
+	revoke := httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/api/revoke", nil)
+	req.AddCookie(rotated)
+	cfg.postRevokeHandler(revoke, req)
+	if revoke.Code != 200 {
+		t.Errorf("Expecting: 200, but got: %d", revoke.Code)
+	}
+	if cleared := revoke.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Errorf("Expecting: the cookie cleared, but got: %v", cleared)
+	}
+
+	t.Logf("Starting test for postLoginHandler with: remember_me and no secret, and expecting: 501")
+	cfg.remember.secret = nil
+	disabled := httptest.NewRecorder()
+	cfg.postLoginHandler(disabled, httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"boots@example.com","password":"hunter2","remember_me":true}`)))
+	if disabled.Code != http.StatusNotImplemented {
+		t.Errorf("Expecting: 501, but got: %d", disabled.Code)
+	}
+}
+
+// runRememberCookieTest checks the response set an HttpOnly remember-me
+// cookie lasting about ttl, and returns it.
+func runRememberCookieTest(t *testing.T, resp *httptest.ResponseRecorder, ttl time.Duration) *http.Cookie {
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name != rememberCookie {
+			continue
+		}
+		if !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("Expecting: an HttpOnly SameSite=Strict cookie, but got: %v", cookie)
+		}
+		if lasts := time.Until(cookie.Expires); lasts > ttl || lasts < ttl-time.Minute {
+			t.Errorf("Expecting: a cookie lasting %v, but got: %v", ttl, lasts)
+		}
+		return cookie
+	}
+	t.Fatalf("Expecting: a %s cookie, but got: %v", rememberCookie, resp.Result().Cookies())
+	return nil
+}
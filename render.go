@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/avearmin/chirpy/internal/richtext"
+)
+
+// chirpResponse is a chirp as the API returns it, with its body also
+// rendered to HTML and its author's public profile attached. Author is a
+// tombstone once the author's account is gone.
+//
+// A rechirp or quote chirp embeds the chirp it refers to, rendered the same
+// way but without embedding further, or a tombstone if it was deleted. A
+// chirp with a poll carries it with its current tallies.
+type chirpResponse struct {
+	database.Chirp
+	HTML      string         `json:"html"`
+	Author    any            `json:"author"`
+	RechirpOf any            `json:"rechirp_of,omitempty"`
+	Quoted    any            `json:"quoted,omitempty"`
+	Poll      *database.Poll `json:"poll,omitempty"`
+}
+
+// tombstone stands in for a deleted chirp or user that something still
+// refers to, so threads keep their shape without showing what was deleted.
+type tombstone struct {
+	Id      int  `json:"id"`
+	Deleted bool `json:"deleted"`
+}
+
+func newTombstone(id int) tombstone {
+	return tombstone{Id: id, Deleted: true}
+}
+
+// loadRenderer uses the entity templates in the file at path, or the
+// defaults when path is empty.
+func loadRenderer(path string) (*richtext.Renderer, error) {
+	if path == "" {
+		return richtext.NewRenderer(richtext.DefaultTemplates)
+	}
+	templates, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return richtext.NewRenderer(string(templates))
+}
+
+func (cfg *apiConfig) renderChirp(ctx context.Context, chirp database.Chirp) (chirpResponse, error) {
+	resp, err := cfg.renderChirps(ctx, []database.Chirp{chirp})
+	if err != nil {
+		return chirpResponse{}, err
+	}
+	return resp[0], nil
+}
+
+// shortLinks fetches the short links of chirps, or none while link
+// tracking is off so that chirps render with their original URLs.
+func (cfg *apiConfig) shortLinks(ctx context.Context, chirpIds []int) (map[int][]database.Link, error) {
+	if !cfg.linkTracking {
+		return nil, nil
+	}
+	return cfg.store(ctx).GetLinks(chirpIds)
+}
+
+func (cfg *apiConfig) renderChirpWith(chirp database.Chirp, profiles map[int]database.Profile, links map[int][]database.Link, emoji map[string]string) (chirpResponse, error) {
+	if chirp.Entities == nil {
+		chirp.Entities = richtext.Extract(chirp.Body)
+	}
+	chirp.Entities = withEmoji(chirp.Entities, emoji)
+	if chirp.Media == nil {
+		chirp.Media = []database.Attachment{}
+	}
+	if chirp.Tags == nil {
+		chirp.Tags = []string{}
+	}
+	html, err := cfg.renderer.Render(chirp.Body, withShortLinks(chirp.Entities, links[chirp.Id]))
+	if err != nil {
+		return chirpResponse{}, err
+	}
+	resp := chirpResponse{Chirp: chirp, HTML: html}
+	if author, ok := profiles[chirp.AuthorId]; ok {
+		resp.Author = &author
+	} else {
+		resp.Author = newTombstone(chirp.AuthorId)
+	}
+	return resp, nil
+}
+
+func (cfg *apiConfig) renderChirps(ctx context.Context, chirps []database.Chirp) ([]chirpResponse, error) {
+	resp, err := cfg.renderChirpList(ctx, chirps)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.embedReferenced(ctx, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// embedReferenced attaches the chirps that rechirps and quote chirps in
+// resp refer to, fetching them all at once.
+func (cfg *apiConfig) embedReferenced(ctx context.Context, resp []chirpResponse) error {
+	var ids []int
+	for _, chirp := range resp {
+		if chirp.RechirpOfId != nil {
+			ids = append(ids, *chirp.RechirpOfId)
+		}
+		if chirp.QuotedId != nil {
+			ids = append(ids, *chirp.QuotedId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	referenced, err := cfg.store(ctx).GetChirpsByIds(ids)
+	if err != nil {
+		return err
+	}
+	rendered, err := cfg.renderChirpList(ctx, referenced)
+	if err != nil {
+		return err
+	}
+	byId := make(map[int]chirpResponse, len(rendered))
+	for _, chirp := range rendered {
+		byId[chirp.Id] = chirp
+	}
+	embed := func(id int) any {
+		if chirp, ok := byId[id]; ok {
+			return &chirp
+		}
+		return newTombstone(id)
+	}
+	for i := range resp {
+		if resp[i].RechirpOfId != nil {
+			resp[i].RechirpOf = embed(*resp[i].RechirpOfId)
+		}
+		if resp[i].QuotedId != nil {
+			resp[i].Quoted = embed(*resp[i].QuotedId)
+		}
+	}
+	return nil
+}
+
+// renderChirpList renders chirps without embedding the chirps they refer
+// to.
+func (cfg *apiConfig) renderChirpList(ctx context.Context, chirps []database.Chirp) ([]chirpResponse, error) {
+	// Fetch every author at once rather than once per chirp.
+	authorIds := make([]int, 0, len(chirps))
+	seen := make(map[int]bool, len(chirps))
+	for _, chirp := range chirps {
+		if !seen[chirp.AuthorId] {
+			seen[chirp.AuthorId] = true
+			authorIds = append(authorIds, chirp.AuthorId)
+		}
+	}
+	profiles, err := cfg.store(ctx).GetProfiles(authorIds)
+	if err != nil {
+		return nil, err
+	}
+	chirpIds := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpIds = append(chirpIds, chirp.Id)
+	}
+	links, err := cfg.shortLinks(ctx, chirpIds)
+	if err != nil {
+		return nil, err
+	}
+	emoji, err := cfg.customEmoji(ctx)
+	if err != nil {
+		return nil, err
+	}
+	polls, err := cfg.store(ctx).GetPolls(chirpIds)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]chirpResponse, 0, len(chirps))
+	for _, chirp := range chirps {
+		rendered, err := cfg.renderChirpWith(chirp, profiles, links, emoji)
+		if err != nil {
+			return nil, err
+		}
+		if poll, ok := polls[chirp.Id]; ok {
+			rendered.Poll = &poll
+		}
+		resp = append(resp, rendered)
+	}
+	return resp, nil
+}
+
+// withShortLinks points each URL entity that has a short link at it instead.
+// The chirp's own entities are left untouched.
+func withShortLinks(entities []richtext.Entity, links []database.Link) []richtext.Entity {
+	if len(links) == 0 {
+		return entities
+	}
+	codes := make(map[string]string, len(links))
+	for _, link := range links {
+		codes[link.URL] = link.Code
+	}
+	rewritten := make([]richtext.Entity, len(entities))
+	for i, entity := range entities {
+		if code, ok := codes[entity.Value]; ok && entity.Type == richtext.TypeURL {
+			entity.Value = "/l/" + code
+		}
+		rewritten[i] = entity
+	}
+	return rewritten
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// reportLimit is how many reports a user may file per day. It scales with
+// how often their past reports were upheld: a new reporter gets the base
+// limit, a reliable one up to twice that, and one whose reports keep being
+// dismissed is throttled down to a single report a day.
+func reportLimit(base int, reputation database.ReporterReputation) int {
+	limit := int(math.Round(float64(base) * 2 * reputation.Score()))
+	return max(limit, 1)
+}
+
+func (cfg *apiConfig) postChirpReportHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	chirpId, err := cfg.chirpIdParam(r)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+
+	reputation, err := cfg.store(r.Context()).GetReporterReputation(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	filed, err := cfg.store(r.Context()).CountReportsSince(userId, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if filed >= reportLimit(cfg.reportDailyLimit, reputation) {
+		w.WriteHeader(429)
+		return
+	}
+
+	report, err := cfg.store(r.Context()).CreateReport(database.Report{ChirpId: chirpId, ReporterId: userId, Reason: params.Reason})
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.notifyOperator(r.Context(), reportCreatedEvent(report))
+	data, err := json.Marshal(report)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// getReportsHandler lists open reports for moderators, putting reports from
+// reporters with the best track record first.
+func (cfg *apiConfig) getReportsHandler(w http.ResponseWriter, r *http.Request) {
+	reports, err := cfg.store(r.Context()).GetOpenReports()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	type queuedReport struct {
+		database.Report
+		Reporter   database.ReporterReputation `json:"reporter"`
+		Reputation float64                     `json:"reputation"`
+	}
+	reputations := make(map[int]database.ReporterReputation)
+	queue := make([]queuedReport, 0, len(reports))
+	for _, report := range reports {
+		reputation, found := reputations[report.ReporterId]
+		if !found {
+			reputation, err = cfg.store(r.Context()).GetReporterReputation(report.ReporterId)
+			if err != nil {
+				respondDataFetchError(w, err)
+				return
+			}
+			reputations[report.ReporterId] = reputation
+		}
+		queue = append(queue, queuedReport{Report: report, Reporter: reputation, Reputation: reputation.Score()})
+	}
+	slices.SortStableFunc(queue, func(a, b queuedReport) int {
+		return cmp.Compare(b.Reputation, a.Reputation)
+	})
+
+	data, err := json.Marshal(queue)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) postResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	reportId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+
+	type parameters struct {
+		Resolution string `json:"resolution"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if params.Resolution != database.ResolutionUpheld && params.Resolution != database.ResolutionDismissed {
+		w.WriteHeader(400)
+		return
+	}
+
+	report, err := cfg.store(r.Context()).ResolveReport(reportId, params.Resolution)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
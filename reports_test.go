@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestReportLimit(t *testing.T) {
+	runReportLimitTest(t, 10, database.ReporterReputation{}, 10)
+	runReportLimitTest(t, 10, database.ReporterReputation{Upheld: 8}, 18)
+	runReportLimitTest(t, 10, database.ReporterReputation{Dismissed: 8}, 2)
+	runReportLimitTest(t, 10, database.ReporterReputation{Dismissed: 50}, 1)
+}
+
+func runReportLimitTest(t *testing.T, base int, reputation database.ReporterReputation, expecting int) {
+	t.Logf("Starting test for reportLimit with: %d, %+v, and expecting: %d", base, reputation, expecting)
+	got := reportLimit(base, reputation)
+	if got != expecting {
+		t.Errorf("Expecting: %d, but got: %d", expecting, got)
+	}
+}
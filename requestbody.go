@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// bodyLimits caps the size of request bodies, so a client cannot make the
+// server buffer more than it is willing to. The cap is applied before any
+// handler or middleware reads the body, which is why it is chosen by path
+// rather than set on each route.
+type bodyLimits struct {
+	// def caps the bodies of the paths without a limit of their own.
+	def int64
+	// paths holds the limits for the paths under each prefix, the longest
+	// prefix winning. Zero means no limit.
+	paths map[string]int64
+}
+
+// limit returns the cap for bodies sent to path, zero for none.
+func (l bodyLimits) limit(path string) int64 {
+	limit, longest := l.def, -1
+	for prefix, prefixLimit := range l.paths {
+		if len(prefix) > longest && (path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")) {
+			limit, longest = prefixLimit, len(prefix)
+		}
+	}
+	return limit
+}
+
+// middlewareBodyLimit refuses bodies declared larger than the path's limit
+// with 413, and stops reading the others at the limit: reads past it fail
+// with an *http.MaxBytesError, which respondParamsDecodingError turns into
+// 413 as well.
+func (cfg *apiConfig) middlewareBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := cfg.bodyLimits.limit(r.URL.Path)
+		if limit > 0 && r.Body != nil {
+			if r.ContentLength > limit {
+				respondBodyTooLarge(w, limit)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newJSONDecoder returns a decoder for a request body that refuses fields
+// the handler does not know, so a misspelt field is reported instead of
+// silently ignored.
+func newJSONDecoder(body io.Reader) *json.Decoder {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	return decoder
+}
+
+// describeDecodingError explains what is wrong with a request body that
+// could not be decoded, naming the offending field when there is one.
+func describeDecodingError(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return "request body is empty: expected a JSON object"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "request body is not valid JSON: it ends too early"
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("request body is not valid JSON: %s at byte %d", syntaxErr.Error(), syntaxErr.Offset)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("%s must be %s, not a JSON %s", typeErr.Field, describeJSONType(typeErr.Type), typeErr.Value)
+	case errors.As(err, &typeErr):
+		return fmt.Sprintf("request body must be %s, not a JSON %s", describeJSONType(typeErr.Type), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	}
+	return "request body is not valid JSON"
+}
+
+// describeJSONType names the JSON value a Go type is decoded from.
+func describeJSONType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		if t.String() == "time.Time" {
+			return "an RFC 3339 time string"
+		}
+		return "an object"
+	}
+	return "a " + t.String()
+}
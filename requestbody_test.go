@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimits(t *testing.T) {
+	limits := bodyLimits{def: 10, paths: map[string]int64{"/api/media": 100, "/api/media/uploads": 1000, "/admin/import": 0}}
+	cases := []struct {
+		path      string
+		expecting int64
+	}{
+		{"/api/chirps", 10},
+		{"/api/media", 100},
+		{"/api/media/uploads/abc", 1000},
+		{"/api/mediafile", 10},
+		{"/admin/import", 0},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for bodyLimits.limit with: %s, and expecting: %d", c.path, c.expecting)
+		if limit := limits.limit(c.path); limit != c.expecting {
+			t.Errorf("Expecting: %d, but got: %d", c.expecting, limit)
+		}
+	}
+
+	cfg := &apiConfig{bodyLimits: limits}
+	handler := cfg.middlewareBodyLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]string{}
+		if err := newJSONDecoder(r.Body).Decode(&params); err != nil {
+			respondParamsDecodingError(w, err)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	requests := []struct {
+		name      string
+		path      string
+		body      string
+		chunked   bool
+		expecting int
+	}{
+		{"a body under the limit", "/api/chirps", `{"a":"b"}`, false, 200},
+		{"a body declared over the limit", "/api/chirps", `{"a":"bcdefgh"}`, false, 413},
+		{"a body over the limit of unknown length", "/api/chirps", `{"a":"bcdefgh"}`, true, 413},
+		{"a large body where there is no limit", "/admin/import", `{"a":"` + strings.Repeat("b", 1000) + `"}`, true, 200},
+	}
+	for _, c := range requests {
+		t.Logf("Starting test for middlewareBodyLimit with: %s, and expecting: %d", c.name, c.expecting)
+		var body io.Reader = strings.NewReader(c.body)
+		if c.chunked {
+			body = io.MultiReader(body)
+		}
+		r := httptest.NewRequest("POST", c.path, body)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.expecting {
+			t.Errorf("Expecting: %d, but got: %d (%s)", c.expecting, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDescribeDecodingError(t *testing.T) {
+	type parameters struct {
+		Body    string   `json:"body"`
+		Options []string `json:"options"`
+		Count   *int     `json:"count"`
+	}
+	cases := []struct {
+		body      string
+		expecting string
+	}{
+		{``, "request body is empty: expected a JSON object"},
+		{`{"body": "hi"`, "request body is not valid JSON: it ends too early"},
+		{`{"body": hi}`, "request body is not valid JSON: invalid character 'h' looking for beginning of value at byte 10"},
+		{`{"body": 12}`, "body must be a string, not a JSON number"},
+		{`{"count": "12"}`, "count must be a whole number, not a JSON string"},
+		{`{"options": "yes"}`, "options must be an array, not a JSON string"},
+		{`["hi"]`, "request body must be an object, not a JSON array"},
+		{`{"bdoy": "hi"}`, `unknown field "bdoy"`},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for describeDecodingError with: %s, and expecting: %s", c.body, c.expecting)
+		params := parameters{}
+		err := newJSONDecoder(strings.NewReader(c.body)).Decode(&params)
+		if err == nil {
+			t.Errorf("Expecting: an error, but got: %+v", params)
+			continue
+		}
+		if reason := describeDecodingError(err); reason != c.expecting {
+			t.Errorf("Expecting: %s, but got: %s", c.expecting, reason)
+		}
+	}
+
+	t.Logf("Starting test for respondParamsDecodingError with: a number where an object belongs, and expecting: 400 describing it")
+	w := httptest.NewRecorder()
+	respondParamsDecodingError(w, json.Unmarshal([]byte(`1`), &struct{}{}))
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "must be an object") {
+		t.Errorf("Expecting: 400 describing the error, but got: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// requestIdHeader carries the id of a request in and out. A proxy in front
+// of the server can set it to tie the server's logs to its own.
+const requestIdHeader = "X-Request-Id"
+
+const requestIdKey contextKey = "requestId"
+
+// validRequestId limits what is accepted from clients, since ids end up in
+// logs and the job queue.
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// middlewareRequestId gives every request an id, taken from its
+// X-Request-Id header when that is usable and made up otherwise. The id is
+// echoed in the response, logged with any error while serving it, and
+// carried along with the background work the request leads to.
+func middlewareRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(requestIdHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestId(r.Context(), id)))
+	})
+}
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+func withRequestId(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+// requestId returns the id of the request ctx belongs to, or "" for work
+// no request started.
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// logRequestf logs like log.Printf, prefixed with the request id if there
+// is one.
+func logRequestf(id, format string, args ...any) {
+	if id == "" {
+		log.Printf(format, args...)
+		return
+	}
+	log.Printf("[request %s] %s", id, fmt.Sprintf(format, args...))
+}
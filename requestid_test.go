@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareRequestId(t *testing.T) {
+	runMiddlewareRequestIdTest(t, "edge-42", "edge-42")
+	runMiddlewareRequestIdTest(t, "", "")
+	runMiddlewareRequestIdTest(t, "has spaces\nand newlines", "")
+}
+
+// runMiddlewareRequestIdTest checks the id seen by the handler matches the
+// one echoed back, and is the given one when expecting is set.
+func runMiddlewareRequestIdTest(t *testing.T, given, expecting string) {
+	t.Logf("Starting test for middlewareRequestId with: %q, and expecting: %q", given, expecting)
+	var seen string
+	handler := middlewareRequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestId(r.Context())
+	}))
+	r := httptest.NewRequest("GET", "/api/chirps", nil)
+	if given != "" {
+		r.Header.Set(requestIdHeader, given)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	echoed := w.Header().Get(requestIdHeader)
+	if seen == "" || seen != echoed {
+		t.Errorf("Expecting: the same id in the handler and response, but got: %q and %q", seen, echoed)
+	}
+	if expecting != "" && echoed != expecting {
+		t.Errorf("Expecting: %q, but got: %q", expecting, echoed)
+	}
+	if expecting == "" && echoed == given {
+		t.Errorf("Expecting: a new id, but got: %q", echoed)
+	}
+}
@@ -1,21 +1,34 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+
+	"github.com/avearmin/chirpy/hooks"
+	"github.com/avearmin/chirpy/internal/database"
 )
 
 func respondError(w http.ResponseWriter, logMessage string, err error) {
-	log.Printf("%s: %s", logMessage, err)
+	logRequestf(w.Header().Get(requestIdHeader), "%s: %s", logMessage, err)
 	w.WriteHeader(http.StatusInternalServerError)
 }
 
 func respondDatabaseError(w http.ResponseWriter, err error) {
-	respondError(w, "Error connecting to database", err)
+	respondStoreError(w, "Error connecting to database", err)
 }
 
+// respondParamsDecodingError tells the client what is wrong with a request
+// body that could not be decoded, or that it was too large.
 func respondParamsDecodingError(w http.ResponseWriter, err error) {
-	respondError(w, "Error decoding parameters", err)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondBodyTooLarge(w, tooLarge.Limit)
+		return
+	}
+	respondValidationError(w, describeDecodingError(err))
 }
 
 func respondStrconvError(w http.ResponseWriter, err error) {
@@ -31,11 +44,11 @@ func respondRefreshTokenError(w http.ResponseWriter, err error) {
 }
 
 func respondDataFetchError(w http.ResponseWriter, err error) {
-	respondError(w, "Error fetching data from database", err)
+	respondStoreError(w, "Error fetching data from database", err)
 }
 
 func respondDataWriteError(w http.ResponseWriter, err error) {
-	respondError(w, "Error writing to database", err)
+	respondStoreError(w, "Error writing to database", err)
 }
 
 func respondJSONMarshalError(w http.ResponseWriter, err error) {
@@ -50,6 +63,156 @@ func respondParseURLError(w http.ResponseWriter, err error) {
 	respondError(w, "Error parsing URL", err)
 }
 
+func respondRenderError(w http.ResponseWriter, err error) {
+	respondError(w, "Error rendering chirp", err)
+}
+
 func respondUnexpectedError(w http.ResponseWriter, err error) {
 	respondError(w, "Something went wrong", err)
 }
+
+// respondStoreError answers with the status for an error from the store:
+// 404 when something is missing, 409 with the reason for a conflict, 403
+// when the user may not make the change, 503 when the request ran out of
+// time or was cancelled, and 500 for anything else, logged with
+// logMessage.
+func respondStoreError(w http.ResponseWriter, logMessage string, err error) {
+	var notFound *database.NotFoundError
+	var conflict *database.ConflictError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		logRequestf(w.Header().Get(requestIdHeader), "%s: request timed out: %s", logMessage, err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case errors.Is(err, context.Canceled):
+		// The client has gone; there is no one to answer.
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case errors.As(err, &notFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.As(err, &conflict):
+		respondConflictError(w, conflict.Reason)
+	case errors.Is(err, database.ErrAuthorization):
+		w.WriteHeader(http.StatusForbidden)
+	default:
+		respondError(w, logMessage, err)
+	}
+}
+
+// respondConflictError tells the client why the state of what it tried to
+// change refused the change.
+func respondConflictError(w http.ResponseWriter, reason string) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: reason})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	w.Write(data)
+}
+
+// respondHookError tells the client why a plugin refused its request, or
+// reports a server error if the plugin failed for any other reason.
+func respondHookError(w http.ResponseWriter, err error) {
+	var rejection *hooks.Rejection
+	if !errors.As(err, &rejection) {
+		respondError(w, "Error running plugin hook", err)
+		return
+	}
+	respondRejectedError(w, rejection.Reason)
+}
+
+// respondRejectedError tells the client why a well-formed request was refused.
+func respondRejectedError(w http.ResponseWriter, reason string) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: reason})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(data)
+}
+
+// respondValidationError tells the client what to fix in its request.
+func respondValidationError(w http.ResponseWriter, reason string) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: reason})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	w.Write(data)
+}
+
+// respondNotImplemented tells the client the server cannot do what it asked
+// with its current setup.
+func respondNotImplemented(w http.ResponseWriter, reason string) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: reason})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotImplemented)
+	w.Write(data)
+}
+
+// respondSignInRequired tells an anonymous client it has to sign in for
+// what it asked for, and why.
+func respondSignInRequired(w http.ResponseWriter, reason string) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: reason})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(data)
+}
+
+// respondForbidden tells the client why the server will not do what it
+// asked, whoever asks.
+func respondForbidden(w http.ResponseWriter, reason string) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: reason})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(data)
+}
+
+// respondBodyTooLarge tells the client the request body is over limit bytes.
+func respondBodyTooLarge(w http.ResponseWriter, limit int64) {
+	type returnVal struct {
+		Error string `json:"error"`
+	}
+	data, err := json.Marshal(returnVal{Error: fmt.Sprintf("request body must be at most %d bytes", limit)})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write(data)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// publishTime is when a scheduled chirp goes out, normalized to UTC, along
+// with the same instant in the zone the author gave it in so clients can
+// show it back to them as they wrote it.
+type publishTime struct {
+	PublishAt      time.Time `json:"publish_at"`
+	PublishAtLocal string    `json:"publish_at_local"`
+}
+
+// parsePublishAt reads an RFC 3339 publish_at, which always names its zone
+// as Z or an offset, and checks that it is after now and no further ahead
+// than the instance allows. The returned reason, when not empty, explains
+// what to fix.
+func (cfg *apiConfig) parsePublishAt(value string, now time.Time) (publishTime, string) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return publishTime{}, "publish_at must be an RFC 3339 time with a zone, like 2006-01-02T15:04:05+07:00"
+	}
+	if !at.After(now) {
+		return publishTime{}, "publish_at must be in the future"
+	}
+	if cfg.scheduleMaxAhead > 0 && at.Sub(now) > cfg.scheduleMaxAhead {
+		return publishTime{}, fmt.Sprintf("publish_at can be at most %s ahead", cfg.scheduleMaxAhead)
+	}
+	return publishTime{PublishAt: at.UTC(), PublishAtLocal: at.Format(time.RFC3339)}, ""
+}
+
+// scheduleChirp stores a checked chirp to be published later and answers
+// 202 with it, reporting whether it was stored. A reply's parent must exist
+// now; if it is gone by the time the chirp is due, the chirp is dropped.
+func (cfg *apiConfig) scheduleChirp(w http.ResponseWriter, r *http.Request, scheduled database.ScheduledChirp) bool {
+	if scheduled.ParentId != nil {
+		_, found, err := cfg.store(r.Context()).GetChirp(*scheduled.ParentId)
+		if err != nil {
+			respondDataFetchError(w, err)
+			return false
+		}
+		if !found {
+			w.WriteHeader(400)
+			return false
+		}
+	}
+	scheduled, err := cfg.store(r.Context()).CreateScheduledChirp(scheduled)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return false
+	}
+	data, err := json.Marshal(scheduled)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(202)
+	w.Write(data)
+	return true
+}
+
+func (cfg *apiConfig) getScheduledChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	chirps, err := cfg.store(r.Context()).GetScheduledChirps(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(chirps)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) deleteScheduledChirpHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	if err := cfg.store(r.Context()).DeleteScheduledChirp(id, userId); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// publishScheduledChirps publishes the scheduled chirps that have come due,
+// checking every interval until ctx is done. Chirps of authors suspended in
+// the meantime, and replies whose parent has gone, are dropped.
+func (cfg *apiConfig) publishScheduledChirps(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := cfg.publishDueChirps(ctx, now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// publishDueChirps publishes the scheduled chirps due at now. Those left
+// when one fails are put back so the next run tries them again.
+func (cfg *apiConfig) publishDueChirps(ctx context.Context, now time.Time) error {
+	due, err := cfg.store(ctx).TakeDueScheduledChirps(now)
+	if err != nil {
+		return fmt.Errorf("taking due scheduled chirps: %w", err)
+	}
+	for i, scheduled := range due {
+		if err := cfg.publishScheduledChirp(ctx, scheduled); err != nil {
+			cfg.reschedule(due[i:])
+			return fmt.Errorf("publishing scheduled chirp %d: %w", scheduled.Id, err)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) publishScheduledChirp(ctx context.Context, scheduled database.ScheduledChirp) error {
+	suspended, err := cfg.store(ctx).IsSuspended(scheduled.AuthorId, time.Now())
+	if err != nil {
+		return err
+	}
+	if suspended {
+		log.Printf("Dropped scheduled chirp %d of suspended user %d", scheduled.Id, scheduled.AuthorId)
+		return nil
+	}
+	chirp, err := cfg.store(ctx).CreateChirp(scheduled.Chirp())
+	if errors.Is(err, database.ErrParentDoesNotExist) {
+		log.Printf("Dropped scheduled chirp %d, its parent is gone", scheduled.Id)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.announceChirp(ctx, chirp); err != nil {
+		log.Printf("Published scheduled chirp %d as %d, but could not render it: %v", scheduled.Id, chirp.Id, err)
+	}
+	return nil
+}
+
+// reschedule puts back taken chirps that were not published.
+func (cfg *apiConfig) reschedule(chirps []database.ScheduledChirp) {
+	for _, scheduled := range chirps {
+		if _, err := cfg.db.CreateScheduledChirp(scheduled); err != nil {
+			log.Printf("Lost scheduled chirp %d of user %d: %v", scheduled.Id, scheduled.AuthorId, err)
+		}
+	}
+}
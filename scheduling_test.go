@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestParsePublishAt(t *testing.T) {
+	cfg := &apiConfig{scheduleMaxAhead: 365 * 24 * time.Hour}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	runParsePublishAtTest(t, cfg, now, "2024-03-01T15:30:00+02:00", "2024-03-01T13:30:00Z", "2024-03-01T15:30:00+02:00", true)
+	runParsePublishAtTest(t, cfg, now, "2024-03-02T00:00:00Z", "2024-03-02T00:00:00Z", "2024-03-02T00:00:00Z", true)
+	runParsePublishAtTest(t, cfg, now, "2024-03-01T12:00:00Z", "", "", false)
+	runParsePublishAtTest(t, cfg, now, "2024-03-01T13:00:00+02:00", "", "", false)
+	runParsePublishAtTest(t, cfg, now, "2025-03-02T12:00:00Z", "", "", false)
+	runParsePublishAtTest(t, cfg, now, "2024-03-02T00:00:00", "", "", false)
+	runParsePublishAtTest(t, cfg, now, "tomorrow", "", "", false)
+	runParsePublishAtTest(t, &apiConfig{}, now, "2030-01-01T00:00:00Z", "2030-01-01T00:00:00Z", "2030-01-01T00:00:00Z", true)
+}
+
+func runParsePublishAtTest(t *testing.T, cfg *apiConfig, now time.Time, value, utc, local string, ok bool) {
+	t.Logf("Starting test for parsePublishAt with: %q (max ahead %s), and expecting: %q, %q, ok %t", value, cfg.scheduleMaxAhead, utc, local, ok)
+	got, reason := cfg.parsePublishAt(value, now)
+	if (reason == "") != ok {
+		t.Errorf("Expecting: ok %t, but got: %q", ok, reason)
+		return
+	}
+	if !ok {
+		return
+	}
+	if got.PublishAt.Format(time.RFC3339) != utc || got.PublishAt.Location() != time.UTC || got.PublishAtLocal != local {
+		t.Errorf("Expecting: %q, %q, but got: %q, %q", utc, local, got.PublishAt.Format(time.RFC3339), got.PublishAtLocal)
+	}
+}
+
+func TestPublishDueChirps(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, broker: newChirpBroker(), inboxes: newFeedInboxes(2, 1)}
+	author, _ := db.CreateUser("author@example.com", "hash")
+	suspended, _ := db.CreateUser("suspended@example.com", "hash")
+	if _, err := db.CreateModerationAction(database.ModerationAction{UserId: suspended.Id, Kind: database.ActionSuspend, Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	parent, _ := db.CreateChirp(database.Chirp{AuthorId: author.Id, Body: "Parent"})
+	now := time.Now()
+	schedule := func(authorId int, body string, parentId *int, at time.Time) {
+		if _, err := db.CreateScheduledChirp(database.ScheduledChirp{AuthorId: authorId, Body: body, ParentId: parentId, PublishAt: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	schedule(author.Id, "Due", nil, now.Add(-time.Minute))
+	schedule(author.Id, "Orphan", &parent.Id, now.Add(-time.Minute))
+	schedule(suspended.Id, "Suspended", nil, now.Add(-time.Minute))
+	schedule(author.Id, "Later", nil, now.Add(time.Hour))
+	if err := db.DeleteChirp(parent.Id, author.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Starting test for publishDueChirps with: a due chirp, an orphaned reply, a suspended author and a later chirp, and expecting: only the due chirp published")
+	if err := cfg.publishDueChirps(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := db.GetChirps("asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for _, chirp := range chirps {
+		bodies = append(bodies, chirp.Body)
+	}
+	if len(bodies) != 1 || bodies[0] != "Due" {
+		t.Errorf("Expecting: [Due], but got: %v", bodies)
+	}
+	if left, _ := db.GetScheduledChirps(author.Id); len(left) != 1 || left[0].Body != "Later" {
+		t.Errorf("Expecting: Later still scheduled, but got: %+v", left)
+	}
+}
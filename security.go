@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// maxRecentSecurityEvents is how many events getUserSecurityHandler lists.
+const maxRecentSecurityEvents = 20
+
+// recordSecurityEvent adds an event of kind, caused by r, to the user's
+// security log. Like notifyOperator it only logs errors: the request should
+// not fail because its event could not be recorded.
+func (cfg *apiConfig) recordSecurityEvent(r *http.Request, userId int, kind string) {
+	event := database.SecurityEvent{UserId: userId, Kind: kind, IP: clientIP(r), CreatedAt: time.Now()}
+	if _, err := cfg.store(r.Context()).RecordSecurityEvent(event); err != nil {
+		logRequestf(requestId(r.Context()), "Error recording %s for user %d: %s", kind, userId, err)
+	}
+}
+
+// getUserSecurityHandler sums up the health of the user's account from its
+// security log: when the password last changed, how many sessions are
+// live, whether two-factor authentication is on, and what happened lately.
+func (cfg *apiConfig) getUserSecurityHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	sessions, err := cfg.store(r.Context()).GetUserSessions(userId, time.Now())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	events, err := cfg.store(r.Context()).GetSecurityEvents(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+
+	type twoFactor struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+	type returnVal struct {
+		Email    string `json:"email"`
+		Verified bool   `json:"verified"`
+		// PasswordChangedAt is when the password was last set, at signup or
+		// since; null for accounts older than the security log.
+		PasswordChangedAt *time.Time               `json:"password_changed_at"`
+		ActiveSessions    int                      `json:"active_sessions"`
+		TwoFactor         twoFactor                `json:"two_factor"`
+		LastLoginAt       *time.Time               `json:"last_login_at"`
+		RecentEvents      []database.SecurityEvent `json:"recent_events"`
+	}
+	resp := returnVal{
+		Email:          user.Email,
+		Verified:       user.Verified,
+		ActiveSessions: len(sessions),
+		TwoFactor:      twoFactor{Enabled: user.TOTPEnabled, RecoveryCodesLeft: len(user.RecoveryCodes)},
+		RecentEvents:   events[:min(len(events), maxRecentSecurityEvents)],
+	}
+	// Events come newest first, so the first of a kind is the latest.
+	for i := range events {
+		switch events[i].Kind {
+		case database.SecurityPasswordChanged, database.SecuritySignup:
+			if resp.PasswordChangedAt == nil {
+				resp.PasswordChangedAt = &events[i].CreatedAt
+			}
+		case database.SecurityLogin:
+			if resp.LastLoginAt == nil {
+				resp.LastLoginAt = &events[i].CreatedAt
+			}
+		}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(data)
+}
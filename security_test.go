@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestUserSecurity(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), tokenFormat: tokenFormatChirpy, jobs: newJobQueue(time.Second, time.Second, time.Minute)}
+	run := func(handler http.HandlerFunc, path, body string, expecting int) {
+		t.Logf("Starting test for POST %s with: %s, and expecting: %d", path, body, expecting)
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		if w.Code != expecting {
+			t.Errorf("Expecting: %d, but got: %d %s", expecting, w.Code, w.Body.String())
+		}
+	}
+	run(cfg.postUsersHandler, "/api/users", `{"email": "sec@example.com", "password": "correct horse battery"}`, 201)
+	run(cfg.postLoginHandler, "/api/login", `{"email": "sec@example.com", "password": "wrong"}`, 401)
+	run(cfg.postLoginHandler, "/api/login", `{"email": "sec@example.com", "password": "correct horse battery"}`, 200)
+	run(cfg.postLoginHandler, "/api/login", `{"email": "sec@example.com", "password": "correct horse battery"}`, 200)
+
+	t.Logf("Starting test for getUserSecurityHandler with: a signup, a failed login and two logins, and expecting: 2 sessions and the events newest first")
+	user, err := db.GetUser("sec@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := cfg.tokens.NewAccessToken(user.Id)
+	r := httptest.NewRequest("GET", "/api/users/me/security", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	cfg.getUserSecurityHandler(w, r)
+	if w.Code != 200 {
+		t.Fatalf("Expecting: 200, but got: %d", w.Code)
+	}
+	summary := struct {
+		Email             string  `json:"email"`
+		PasswordChangedAt *string `json:"password_changed_at"`
+		LastLoginAt       *string `json:"last_login_at"`
+		ActiveSessions    int     `json:"active_sessions"`
+		TwoFactor         struct {
+			Enabled bool `json:"enabled"`
+		} `json:"two_factor"`
+		RecentEvents []database.SecurityEvent `json:"recent_events"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, event := range summary.RecentEvents {
+		kinds = append(kinds, event.Kind)
+	}
+	expecting := []string{database.SecurityLogin, database.SecurityLogin, database.SecurityLoginFailed, database.SecuritySignup}
+	if strings.Join(kinds, ",") != strings.Join(expecting, ",") {
+		t.Errorf("Expecting: %v, but got: %v", expecting, kinds)
+	}
+	if summary.Email != user.Email || summary.ActiveSessions != 2 || summary.TwoFactor.Enabled || summary.PasswordChangedAt == nil || summary.LastLoginAt == nil {
+		t.Errorf("Expecting: 2 sessions, no 2FA, and the password and login times set, but got: %s", w.Body.String())
+	}
+
+	t.Logf("Starting test for getUserSecurityHandler with: no token, and expecting: 401")
+	w = httptest.NewRecorder()
+	cfg.getUserSecurityHandler(w, httptest.NewRequest("GET", "/api/users/me/security", nil))
+	if w.Code != 401 {
+		t.Errorf("Expecting: 401, but got: %d", w.Code)
+	}
+}
+
+// failingUpdates is a store whose user updates always fail.
+type failingUpdates struct {
+	database.Storage
+}
+
+func (s failingUpdates) WithContext(ctx context.Context) database.Storage {
+	return failingUpdates{s.Storage.WithContext(ctx)}
+}
+
+func (failingUpdates) UpdateUser(int, string, string) error {
+	return errors.New("disk full")
+}
+
+func TestUpdateUserCreds(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), jobs: newJobQueue(time.Second, time.Second, time.Minute)}
+	user, err := db.CreateUser("creds@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification, err := db.CreateVerificationToken(user.Id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.VerifyUser(verification); err != nil {
+		t.Fatal(err)
+	}
+	put := func(userId int, body string) int {
+		token, _ := cfg.tokens.NewAccessToken(userId)
+		r := httptest.NewRequest("PUT", "/api/users", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.updateUserCredsHandler(w, r)
+		return w.Code
+	}
+
+	t.Logf("Starting test for updateUserCredsHandler with: the same address in another case, and expecting: no email change")
+	if code := put(user.Id, `{"email": " Creds@Example.com ", "password": "another horse battery"}`); code != 200 {
+		t.Fatalf("Expecting: 200, but got: %d", code)
+	}
+	user, err = db.GetUserById(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := db.GetSecurityEvents(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Verified || len(events) != 1 || events[0].Kind != database.SecurityPasswordChanged {
+		t.Errorf("Expecting: still verified with only %s, but got: %t %+v", database.SecurityPasswordChanged, user.Verified, events)
+	}
+
+	t.Logf("Starting test for updateUserCredsHandler with: a store that cannot write, and expecting: 500 and no new events")
+	cfg.db = failingUpdates{db}
+	if code := put(user.Id, `{"email": "new@example.com", "password": "third horse battery"}`); code != 500 {
+		t.Errorf("Expecting: 500, but got: %d", code)
+	}
+	if events, err := db.GetSecurityEvents(user.Id); err != nil || len(events) != 1 {
+		t.Errorf("Expecting: 1 event, but got: %+v, %v", events, err)
+	}
+}
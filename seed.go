@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// seedPassword is the password of every seeded user.
+const seedPassword = "password"
+
+// seedEmailDomain ends the email of every seeded user.
+const seedEmailDomain = "@seed.example.com"
+
+const (
+	defaultSeedUsers  = 20
+	defaultSeedChirps = 200
+	// Each seeded user costs a bcrypt hash, which is what caps them.
+	maxSeedUsers  = 200
+	maxSeedChirps = 10000
+	// seedFollows is how many others each seeded user follows at most.
+	seedFollows = 5
+	// seedDays is how far back the seeded chirps go.
+	seedDays = 30
+)
+
+var (
+	seedFirstNames = []string{"Ada", "Boots", "Cleo", "Dax", "Edie", "Fitz", "Gus", "Hana", "Ivo", "June", "Kit", "Lola", "Milo", "Nia", "Otto", "Pia"}
+	seedLastNames  = []string{"Archer", "Brook", "Crane", "Dale", "Ellis", "Frost", "Gray", "Hale", "Irving", "Jones", "Kerr", "Lane"}
+	seedWords      = []string{
+		"coffee", "morning", "deploy", "weekend", "garden", "river", "coding", "bread", "train", "music",
+		"finally", "today", "again", "really", "quiet", "bright", "late", "early", "new", "old",
+		"just", "shipped", "tried", "loved", "missed", "found", "made", "watched", "read", "walked",
+		"the", "a", "my", "this", "that", "with", "without", "before", "after", "during",
+	}
+	seedTags = []string{"golang", "chirpy", "monday", "gardening", "music", "til"}
+)
+
+// seedOptions says how much to seed. The same options on an empty database
+// always produce the same users, chirps, and follows.
+type seedOptions struct {
+	Users  int   `json:"users"`
+	Chirps int   `json:"chirps"`
+	Seed   int64 `json:"seed"`
+}
+
+type seedStats struct {
+	Users    int    `json:"users"`
+	Chirps   int    `json:"chirps"`
+	Follows  int    `json:"follows"`
+	Password string `json:"password"`
+}
+
+// seedDatabase fills db with made-up users who follow each other and
+// chirp, spread over the last seedDays before now.
+func seedDatabase(db database.Storage, opts seedOptions, now time.Time) (seedStats, error) {
+	// Not every store keeps emails unique, so an earlier seed is looked
+	// for up front.
+	users, err := db.GetUsers()
+	if err != nil {
+		return seedStats{}, err
+	}
+	for _, user := range users {
+		if strings.HasSuffix(user.Email, seedEmailDomain) {
+			return seedStats{}, database.ErrUserAlreadyExists
+		}
+	}
+
+	random := rand.New(rand.NewSource(opts.Seed))
+	stats := seedStats{Password: seedPassword}
+	userIds := make([]int, 0, opts.Users)
+	for i := 1; i <= opts.Users; i++ {
+		user, err := db.CreateUser(fmt.Sprintf("seed%d%s", i, seedEmailDomain), seedPassword)
+		if err != nil {
+			return stats, err
+		}
+		first := seedFirstNames[random.Intn(len(seedFirstNames))]
+		last := seedLastNames[random.Intn(len(seedLastNames))]
+		handle := fmt.Sprintf("%s%d", strings.ToLower(first), i)
+		displayName := first + " " + last
+		bio := "Seeded account " + fmt.Sprint(i) + "."
+		_, err = db.UpdateProfile(user.Id, database.ProfileUpdate{Handle: &handle, DisplayName: &displayName, Bio: &bio})
+		if err != nil {
+			return stats, err
+		}
+		userIds = append(userIds, user.Id)
+		stats.Users++
+	}
+	if len(userIds) == 0 {
+		return stats, nil
+	}
+
+	start := now.Add(-seedDays * 24 * time.Hour)
+	err = db.WriteBatch(func(db database.Storage) error {
+		for _, followerId := range userIds {
+			for _, n := range random.Perm(len(userIds))[:min(seedFollows, len(userIds))] {
+				if userIds[n] == followerId {
+					continue
+				}
+				err := db.PutFollow(database.Follow{FollowerId: followerId, FolloweeId: userIds[n], CreatedAt: start})
+				if err != nil {
+					return err
+				}
+				stats.Follows++
+			}
+		}
+		step := seedDays * 24 * time.Hour / time.Duration(max(opts.Chirps, 1))
+		for i := 0; i < opts.Chirps; i++ {
+			chirp := database.Chirp{
+				Body:      seedChirpBody(random),
+				AuthorId:  userIds[random.Intn(len(userIds))],
+				CreatedAt: start.Add(time.Duration(i) * step).UTC(),
+			}
+			if _, err := db.PutChirp(chirp); err != nil {
+				return err
+			}
+			stats.Chirps++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// seedChirpBody makes up a chirp of a few words, some with a tag.
+func seedChirpBody(random *rand.Rand) string {
+	words := make([]string, 3+random.Intn(10))
+	for i := range words {
+		words[i] = seedWords[random.Intn(len(seedWords))]
+	}
+	words[0] = strings.ToUpper(words[0][:1]) + words[0][1:]
+	body := strings.Join(words, " ") + "."
+	if random.Intn(4) == 0 {
+		body += " #" + seedTags[random.Intn(len(seedTags))]
+	}
+	return body
+}
+
+// postSeedHandler fills the database with made-up users and chirps for
+// load tests and front-end work. Like wiping it, it is only allowed when
+// PLATFORM is dev.
+func (cfg *apiConfig) postSeedHandler(w http.ResponseWriter, r *http.Request) {
+	if cfg.platform != "dev" {
+		respondForbidden(w, "the database can only be seeded when PLATFORM is dev")
+		return
+	}
+	opts := seedOptions{Users: defaultSeedUsers, Chirps: defaultSeedChirps, Seed: 1}
+	if err := newJSONDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if opts.Users < 1 || opts.Users > maxSeedUsers {
+		respondValidationError(w, fmt.Sprintf("users must be from 1 to %d", maxSeedUsers))
+		return
+	}
+	if opts.Chirps < 0 || opts.Chirps > maxSeedChirps {
+		respondValidationError(w, fmt.Sprintf("chirps must be from 0 to %d", maxSeedChirps))
+		return
+	}
+
+	// Seeding takes a while and is not undone if the client leaves.
+	stats, err := seedDatabase(cfg.db, opts, time.Now())
+	if errors.Is(err, database.ErrUserAlreadyExists) {
+		respondConflictError(w, "the database is already seeded: wipe it with POST /admin/reset?database=true first")
+		return
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	logRequestf(requestId(r.Context()), "Seeded %d users and %d chirps", stats.Users, stats.Chirps)
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
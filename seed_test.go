@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestPostSeed(t *testing.T) {
+	newCfg := func(platform string) *apiConfig {
+		db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &apiConfig{db: db, platform: platform}
+	}
+	seed := func(cfg *apiConfig, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		cfg.postSeedHandler(w, httptest.NewRequest("POST", "/admin/seed", strings.NewReader(body)))
+		return w
+	}
+
+	t.Logf("Starting test for postSeedHandler with: PLATFORM production, and expecting: 403")
+	if w := seed(newCfg("production"), ""); w.Code != 403 {
+		t.Errorf("Expecting: 403, but got: %d", w.Code)
+	}
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"users":0}`, 400},
+		{`{"users":1000}`, 400},
+		{`{"chirps":-1}`, 400},
+		{`{"user":3}`, 400},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for postSeedHandler with: %s, and expecting: %d", c.body, c.status)
+		if w := seed(newCfg("dev"), c.body); w.Code != c.status {
+			t.Errorf("Expecting: %d, but got: %d", c.status, w.Code)
+		}
+	}
+
+	t.Logf("Starting test for postSeedHandler with: the same options twice, and expecting: the same chirps")
+	var seeded [2][]database.Chirp
+	for i := range seeded {
+		cfg := newCfg("dev")
+		w := seed(cfg, `{"users":4,"chirps":30,"seed":7}`)
+		var stats seedStats
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != 201 || stats.Users != 4 || stats.Chirps != 30 || stats.Follows == 0 {
+			t.Errorf("Expecting: 201 with 4 users, 30 chirps, and some follows, but got: %d %+v", w.Code, stats)
+		}
+		chirps, err := cfg.db.GetChirps("asc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeded[i] = chirps
+		if i == 0 {
+			t.Logf("Starting test for postSeedHandler with: a seeded database, and expecting: 409")
+			if w := seed(cfg, ""); w.Code != 409 {
+				t.Errorf("Expecting: 409, but got: %d", w.Code)
+			}
+		}
+	}
+	if len(seeded[0]) != 30 || len(seeded[1]) != 30 {
+		t.Fatalf("Expecting: 30 chirps each, but got: %d and %d", len(seeded[0]), len(seeded[1]))
+	}
+	for i := range seeded[0] {
+		a, b := seeded[0][i], seeded[1][i]
+		if a.Body != b.Body || a.AuthorId != b.AuthorId {
+			t.Errorf("Expecting: %q by %d, but got: %q by %d", a.Body, a.AuthorId, b.Body, b.AuthorId)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// chirpIdParam resolves the chirp named by the id URL parameter to its id.
+// Chirps are named by their short id, or by their id while numeric ids are
+// accepted. Turning numeric ids off keeps clients from walking through every
+// chirp by counting.
+func (cfg *apiConfig) chirpIdParam(r *http.Request) (int, error) {
+	param := chi.URLParam(r, "id")
+	chirp, found, err := cfg.store(r.Context()).GetChirpByShortId(param)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return chirp.Id, nil
+	}
+	if cfg.numericChirpIds {
+		if id, err := strconv.Atoi(param); err == nil {
+			return id, nil
+		}
+	}
+	return 0, &database.NotFoundError{Kind: database.ErrChirpDoesNotExist.Kind, ID: param}
+}
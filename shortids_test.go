@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestChirpIdParam(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp(database.Chirp{AuthorId: 1, Body: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	numeric := strconv.Itoa(chirp.Id)
+
+	runChirpIdParamTest(t, &apiConfig{db: db, numericChirpIds: true}, chirp.ShortId, chirp.Id, nil)
+	runChirpIdParamTest(t, &apiConfig{db: db, numericChirpIds: true}, numeric, chirp.Id, nil)
+	runChirpIdParamTest(t, &apiConfig{db: db}, chirp.ShortId, chirp.Id, nil)
+	runChirpIdParamTest(t, &apiConfig{db: db}, numeric, 0, database.ErrChirpDoesNotExist)
+	runChirpIdParamTest(t, &apiConfig{db: db, numericChirpIds: true}, "nope", 0, database.ErrChirpDoesNotExist)
+}
+
+func runChirpIdParamTest(t *testing.T, cfg *apiConfig, param string, expecting int, expectingErr error) {
+	t.Logf("Starting test for chirpIdParam with: %q (numeric ids %t), and expecting: %d, %v", param, cfg.numericChirpIds, expecting, expectingErr)
+	r := httptest.NewRequest("GET", "/chirps/"+param, nil)
+	var id int
+	var err error
+	router := chi.NewRouter()
+	router.Get("/chirps/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err = cfg.chirpIdParam(r)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	if id != expecting || !errors.Is(err, expectingErr) || (expectingErr == nil && err != nil) {
+		t.Errorf("Expecting: %d, %v, but got: %d, %v", expecting, expectingErr, id, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// postRestoreChirpHandler brings back a chirp deleted by its author or a
+// moderator, until it is purged.
+func (cfg *apiConfig) postRestoreChirpHandler(w http.ResponseWriter, r *http.Request) {
+	urlParam := chi.URLParam(r, "id")
+	chirpId, err := strconv.Atoi(urlParam)
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	chirp, err := cfg.store(r.Context()).RestoreChirp(chirpId)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	resp, err := cfg.renderChirp(r.Context(), chirp)
+	if err != nil {
+		respondRenderError(w, err)
+		return
+	}
+	cfg.broker.publishChirp(resp)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// purgeDeletedChirps permanently removes chirps deleted more than
+// retention ago, checking every interval until ctx is done.
+func (cfg *apiConfig) purgeDeletedChirps(ctx context.Context, retention, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			purged, err := cfg.store(ctx).PurgeChirps(now.Add(-retention))
+			if err != nil {
+				return fmt.Errorf("purging deleted chirps: %w", err)
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted chirps", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// lastBackup returns when the newest backup in dir was taken, or nil when
+// there is none.
+func lastBackup(dir string) (*time.Time, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	names, err := listBackups(dir)
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	name := names[len(names)-1]
+	stamp := strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)
+	takenAt, err := time.Parse(backupTimeFormat, stamp)
+	if err != nil {
+		return nil, err
+	}
+	return &takenAt, nil
+}
+
+// getStatsHandler reports the state of the server's background workers and
+// of its storage, so operators can tell how the instance is doing at a
+// glance.
+func (cfg *apiConfig) getStatsHandler(w http.ResponseWriter, r *http.Request) {
+	type returnVal struct {
+		FileserverHits int                   `json:"fileserver_hits"`
+		Storage        database.StorageStats `json:"storage"`
+		LastBackup     *time.Time            `json:"last_backup"`
+		Workers        []workerStatus        `json:"workers"`
+		Lockouts       map[string]int        `json:"lockouts"` // kind -> subjects locked out now
+		ProductEvents  map[string]int        `json:"product_events"`
+		Mirror         *mirrorStats          `json:"mirror,omitempty"`
+		Capabilities   database.Capabilities `json:"capabilities"`
+	}
+	storage, err := cfg.store(r.Context()).Stats()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	totals, err := cfg.metricTotals(r.Context())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	lockouts, err := cfg.store(r.Context()).ActiveLockouts(time.Now())
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	backupAt, err := lastBackup(cfg.backups.dir)
+	if err != nil {
+		respondError(w, "Error listing backups", err)
+		return
+	}
+	workers, _ := cfg.workers.statuses()
+	data, err := json.Marshal(returnVal{
+		FileserverHits: totals[counterHits],
+		Storage:        storage,
+		LastBackup:     backupAt,
+		Workers:        workers,
+		Lockouts:       lockouts,
+		ProductEvents:  cfg.analytics.snapshot(),
+		Mirror:         cfg.mirror.stats(),
+		Capabilities:   cfg.capabilities(),
+	})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
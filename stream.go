@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamBuffer is how many events a stream can fall behind by before
+	// it is disconnected.
+	streamBuffer = 64
+	// streamHistory is how many recent events are kept for clients that
+	// reconnect and resume from the last one they saw.
+	streamHistory = 256
+	// streamPingPeriod is how often streams are pinged; a client that does
+	// not answer within streamPongWait is disconnected.
+	streamPingPeriod = 30 * time.Second
+	streamPongWait   = 2 * streamPingPeriod
+	streamWriteWait  = 10 * time.Second
+	// streamHeartbeat is how often an idle event stream gets a comment, so
+	// proxies do not time it out.
+	streamHeartbeat = 15 * time.Second
+)
+
+// Stream event types.
+const (
+	eventChirp  = "chirp"
+	eventDelete = "delete"
+)
+
+// streamEvent is a chirp being created or deleted. Ids increase by one with
+// each event the server publishes.
+type streamEvent struct {
+	Id      uint64         `json:"id"`
+	Event   string         `json:"event"`
+	Chirp   *chirpResponse `json:"chirp,omitempty"`
+	ChirpId int            `json:"chirp_id"`
+	// chirp is what filters match against, including for deletions.
+	chirp database.Chirp
+}
+
+// chirpBroker passes each event to every stream whose filter it matches,
+// and keeps the latest few so that reconnecting streams can resume.
+type chirpBroker struct {
+	mux         sync.Mutex
+	subscribers map[*subscription]bool
+	lastId      uint64
+	history     []streamEvent // oldest first
+}
+
+// subscription is one stream's view of the broker. Its events channel is
+// closed if the stream falls too far behind, so that the client reconnects
+// and catches up rather than silently missing chirps.
+type subscription struct {
+	filter streamFilter
+	events chan streamEvent
+}
+
+// streamFilter limits a stream to chirps by one author or with one hashtag.
+// Zero values match everything.
+type streamFilter struct {
+	authorId int
+	tag      string
+}
+
+func newChirpBroker() *chirpBroker {
+	return &chirpBroker{subscribers: make(map[*subscription]bool)}
+}
+
+// subscribe starts a stream with the kept events that came after
+// lastEventId and match filter. A lastEventId of zero starts with nothing.
+func (b *chirpBroker) subscribe(filter streamFilter, lastEventId uint64) *subscription {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	var backlog []streamEvent
+	if lastEventId != 0 {
+		for _, event := range b.history {
+			if event.Id > lastEventId && filter.matches(event.chirp) {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+	sub := &subscription{filter: filter, events: make(chan streamEvent, streamBuffer+len(backlog))}
+	for _, event := range backlog {
+		sub.events <- event
+	}
+	b.subscribers[sub] = true
+	return sub
+}
+
+func (b *chirpBroker) unsubscribe(sub *subscription) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// publishChirp announces a newly created chirp.
+func (b *chirpBroker) publishChirp(chirp chirpResponse) {
+	b.publish(streamEvent{Event: eventChirp, Chirp: &chirp, ChirpId: chirp.Id, chirp: chirp.Chirp})
+}
+
+// publishDelete announces that chirp was deleted.
+func (b *chirpBroker) publishDelete(chirp database.Chirp) {
+	b.publish(streamEvent{Event: eventDelete, ChirpId: chirp.Id, chirp: chirp})
+}
+
+// publish never blocks: subscribers with a full buffer are dropped.
+func (b *chirpBroker) publish(event streamEvent) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.lastId++
+	event.Id = b.lastId
+	b.history = append(b.history, event)
+	if len(b.history) > streamHistory {
+		b.history = slices.Delete(b.history, 0, len(b.history)-streamHistory)
+	}
+	for sub := range b.subscribers {
+		if !sub.filter.matches(event.chirp) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (f streamFilter) matches(chirp database.Chirp) bool {
+	if f.authorId != 0 && chirp.AuthorId != f.authorId {
+		return false
+	}
+	return f.tag == "" || slices.Contains(chirp.Tags, f.tag)
+}
+
+// parseStreamFilter reads the author_id and tag query parameters. The
+// returned reason is non-empty when they are invalid.
+func parseStreamFilter(query url.Values) (streamFilter, string) {
+	filter := streamFilter{tag: database.NormalizeTag(query.Get("tag"))}
+	if value := query.Get("author_id"); value != "" {
+		authorId, err := strconv.Atoi(value)
+		if err != nil || authorId < 1 {
+			return streamFilter{}, "author_id must be a user id"
+		}
+		filter.authorId = authorId
+	}
+	return filter, ""
+}
+
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// getStreamHandler upgrades to a WebSocket and sends each chirp created
+// from then on as a {"event": "chirp", "chirp": ...} message, and each one
+// deleted as {"event": "delete", "chirp_id": ...}, optionally only those by
+// author_id or tagged with tag. Clients that fall behind are disconnected
+// with a try-again-later close and should reconnect.
+func (cfg *apiConfig) getStreamHandler(w http.ResponseWriter, r *http.Request) {
+	filter, reason := parseStreamFilter(r.URL.Query())
+	if reason != "" {
+		respondValidationError(w, reason)
+		return
+	}
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already responded to the client.
+		return
+	}
+	defer conn.Close()
+
+	sub := cfg.broker.subscribe(filter, 0)
+	defer cfg.broker.unsubscribe(sub)
+
+	// Clients only ever send control frames, but they must be read for
+	// pongs and closes to be handled.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case event, ok := <-sub.events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream fell behind"))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// getChirpEventsHandler serves the same events as getStreamHandler as
+// text/event-stream, for clients that cannot use WebSockets. Each event
+// carries its id, so a client that reconnects with Last-Event-ID (or the
+// last_event_id parameter) gets the events it missed, as long as they are
+// recent enough to still be kept. A stream that falls behind is simply
+// ended, and the client resumes the same way.
+func (cfg *apiConfig) getChirpEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, reason := parseStreamFilter(r.URL.Query())
+	if reason != "" {
+		respondValidationError(w, reason)
+		return
+	}
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastEventId != "" {
+		var err error
+		after, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			respondValidationError(w, "Last-Event-ID must be an event id")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, "Error streaming events", fmt.Errorf("%T cannot flush", w))
+		return
+	}
+
+	sub := cfg.broker.subscribe(filter, after)
+	defer cfg.broker.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error marshalling stream event %d: %s", event.Id, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Event, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/gorilla/websocket"
+)
+
+func TestStream(t *testing.T) {
+	cfg := &apiConfig{broker: newChirpBroker()}
+	server := httptest.NewServer(http.HandlerFunc(cfg.getStreamHandler))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?tag=%23Go", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForSubscribers(t, cfg.broker, 1)
+
+	cfg.broker.publishChirp(chirpResponse{Chirp: database.Chirp{Id: 1, Tags: []string{"rust"}}})
+	cfg.broker.publishChirp(chirpResponse{Chirp: database.Chirp{Id: 2, Tags: []string{"go"}}})
+	runStreamTest(t, conn, 2)
+	cfg.broker.publishDelete(database.Chirp{Id: 2, Tags: []string{"go"}})
+	runStreamTest(t, conn, 2)
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?author_id=me", nil); err == nil || resp.StatusCode != 400 {
+		t.Errorf("Expecting: 400 for a bad author_id, but got: %v", resp)
+	}
+
+	t.Logf("Starting test for publish with: a full buffer, and expecting: the subscriber dropped")
+	slow := cfg.broker.subscribe(streamFilter{}, 0)
+	for i := 0; i <= streamBuffer; i++ {
+		cfg.broker.publishChirp(chirpResponse{Chirp: database.Chirp{Id: i}})
+	}
+	for range slow.events {
+	}
+	cfg.broker.unsubscribe(slow)
+}
+
+func TestStreamFilter(t *testing.T) {
+	chirp := database.Chirp{AuthorId: 1, Tags: []string{"go"}}
+	runStreamFilterTest(t, streamFilter{}, chirp, true)
+	runStreamFilterTest(t, streamFilter{authorId: 1, tag: "go"}, chirp, true)
+	runStreamFilterTest(t, streamFilter{authorId: 2}, chirp, false)
+	runStreamFilterTest(t, streamFilter{tag: "rust"}, chirp, false)
+}
+
+func runStreamFilterTest(t *testing.T, filter streamFilter, chirp database.Chirp, expecting bool) {
+	t.Logf("Starting test for streamFilter.matches with: %+v, and expecting: %t", filter, expecting)
+	if got := filter.matches(chirp); got != expecting {
+		t.Errorf("Expecting: %t, but got: %t", expecting, got)
+	}
+}
+
+func runStreamTest(t *testing.T, conn *websocket.Conn, expecting int) {
+	t.Logf("Starting test for getStreamHandler, and expecting: chirp %d", expecting)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event streamEvent
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ChirpId != expecting || (event.Event == eventChirp) != (event.Chirp != nil) {
+		t.Errorf("Expecting: chirp %d, but got: %+v", expecting, event)
+	}
+}
+
+func waitForSubscribers(t *testing.T, broker *chirpBroker, n int) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		broker.mux.Lock()
+		count := len(broker.subscribers)
+		broker.mux.Unlock()
+		if count == n {
+			return
+		}
+	}
+	t.Fatalf("Expecting: %d subscribers, but got none in time", n)
+}
+
+func TestChirpEvents(t *testing.T) {
+	cfg := &apiConfig{broker: newChirpBroker()}
+	server := httptest.NewServer(http.HandlerFunc(cfg.getChirpEventsHandler))
+	defer server.Close()
+	cfg.broker.publishChirp(chirpResponse{Chirp: database.Chirp{Id: 1, AuthorId: 1}})
+	cfg.broker.publishChirp(chirpResponse{Chirp: database.Chirp{Id: 2, AuthorId: 2}})
+	cfg.broker.publishDelete(database.Chirp{Id: 1, AuthorId: 1})
+
+	t.Logf("Starting test for getChirpEventsHandler with: Last-Event-ID 1, author 1, and expecting: the deletion of chirp 1")
+	req, _ := http.NewRequest("GET", server.URL+"?author_id=1", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expecting: text/event-stream, but got: %s", resp.Header.Get("Content-Type"))
+	}
+	expecting := "id: 3\nevent: delete\ndata: {\"id\":3,\"event\":\"delete\",\"chirp_id\":1}\n\n"
+	got := make([]byte, len(expecting))
+	if _, err := io.ReadFull(resp.Body, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expecting {
+		t.Errorf("Expecting: %q, but got: %q", expecting, got)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// streamingPaths are the endpoints that hold their connection open for as
+// long as the client listens, which the request timeout must not cut.
+var streamingPaths = []string{"/api/chirps/stream", "/api/stream"}
+
+// store returns the store bound to ctx, so its calls give up once the
+// request they serve is cancelled, times out, or the worker stops.
+// Requests the mirror replays go to its secondary store instead.
+func (cfg *apiConfig) store(ctx context.Context) database.Storage {
+	if cfg.mirror != nil && mirrored(ctx) {
+		return cfg.mirror.db.WithContext(ctx)
+	}
+	return cfg.db.WithContext(ctx)
+}
+
+// middlewareRequestTimeout gives each request cfg.requestTimeout to finish
+// its work, after which its context is done and the store calls it makes
+// fail. A client that goes away cancels the context sooner. A zero timeout
+// leaves requests to run until the client leaves.
+func (cfg *apiConfig) middlewareRequestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.requestTimeout <= 0 || slices.Contains(streamingPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, requestTimeout: 10 * time.Millisecond}
+	handler := cfg.middlewareRequestTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, found := r.Context().Deadline(); !found {
+			w.WriteHeader(200)
+			return
+		}
+		<-r.Context().Done()
+		if _, err := cfg.store(r.Context()).GetUsers(); err != nil {
+			respondDataFetchError(w, err)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+
+	cases := []struct {
+		name      string
+		path      string
+		timeout   time.Duration
+		expecting int
+	}{
+		{"a request that outlives the timeout", "/api/users", 10 * time.Millisecond, 503},
+		{"a stream", "/api/chirps/stream", 10 * time.Millisecond, 200},
+		{"no timeout", "/api/users", 0, 200},
+	}
+	for _, c := range cases {
+		t.Logf("Starting test for middlewareRequestTimeout with: %s, and expecting: %d", c.name, c.expecting)
+		cfg.requestTimeout = c.timeout
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		if w.Code != c.expecting {
+			t.Errorf("Expecting: %d, but got: %d", c.expecting, w.Code)
+		}
+	}
+}
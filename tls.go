@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsEnabled reports whether the server should serve HTTPS, with either
+// the configured certificate or ones from Let's Encrypt.
+func (cfg config) tlsEnabled() bool {
+	return cfg.TLSCert != "" || cfg.Domain != ""
+}
+
+// configureTLS sets server up to serve HTTPS and returns the plain HTTP
+// server that redirects to it, or nil when there should be none. With a
+// domain, certificates come from Let's Encrypt and are cached in cacheDir;
+// the redirect server then defaults to port 80 because it also answers the
+// ACME HTTP challenges. With a certificate file it only runs when
+// redirectPort is set.
+func configureTLS(server *http.Server, cfg config, cacheDir, redirectPort, acmeEmail string) *http.Server {
+	var redirect http.Handler = httpsRedirect(cfg.Port)
+	if cfg.Domain != "" {
+		if cacheDir == "" {
+			cacheDir = "./certs"
+		}
+		if redirectPort == "" {
+			redirectPort = "80"
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(cfg.Domain, ",")...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      acmeEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if redirectPort == "" {
+		return nil
+	}
+	return &http.Server{Addr: ":" + redirectPort, Handler: redirect}
+}
+
+// httpsRedirect permanently redirects requests to the same URL over HTTPS
+// on port, leaving the port out when it is the default.
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSRedirect(t *testing.T) {
+	runHTTPSRedirectTest(t, "443", "chirpy.example", "/api/chirps?author_id=1", "https://chirpy.example/api/chirps?author_id=1")
+	runHTTPSRedirectTest(t, "443", "chirpy.example:80", "/app/", "https://chirpy.example/app/")
+	runHTTPSRedirectTest(t, "8443", "chirpy.example:8080", "/app/", "https://chirpy.example:8443/app/")
+	runHTTPSRedirectTest(t, "8443", "[::1]", "/", "https://[::1]:8443/")
+	runHTTPSRedirectTest(t, "443", "[::1]:80", "/", "https://[::1]/")
+}
+
+func runHTTPSRedirectTest(t *testing.T, port, host, uri, expecting string) {
+	t.Logf("Starting test for httpsRedirect with: port %s, host %s and %s, and expecting: %s", port, host, uri, expecting)
+	r := httptest.NewRequest(http.MethodGet, uri, nil)
+	r.Host = host
+	w := httptest.NewRecorder()
+	httpsRedirect(port).ServeHTTP(w, r)
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("Expecting: %d, but got: %d", http.StatusMovedPermanently, w.Code)
+	}
+	if got := w.Header().Get("Location"); got != expecting {
+		t.Errorf("Expecting: %s, but got: %s", expecting, got)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTrendsWindow = 24 * time.Hour
+	maxTrendsWindow     = 7 * 24 * time.Hour
+	defaultTrendsLimit  = 10
+	maxTrendsLimit      = 50
+)
+
+// getTrendsHandler returns the hashtags used by the most people over the
+// last window (a time.ParseDuration string, 24h by default).
+func (cfg *apiConfig) getTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	window := defaultTrendsWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 || parsed > maxTrendsWindow {
+			respondValidationError(w, "window must be a positive duration of at most "+maxTrendsWindow.String())
+			return
+		}
+		window = parsed
+	}
+	limit := defaultTrendsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTrendsLimit {
+			respondValidationError(w, "limit must be a number from 1 to "+strconv.Itoa(maxTrendsLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	trends, err := cfg.store(r.Context()).GetTrends(time.Now().Add(-window), limit)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(trends)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// totpIssuer names the service in users' authenticator apps.
+const totpIssuer = "Chirpy"
+
+// recoveryCodeCount is how many recovery codes a user gets when enabling
+// two-factor authentication.
+const recoveryCodeCount = 10
+
+var errTOTPNotConfigured = errors.New("two-factor authentication is not configured: set TOTP_ENCRYPTION_KEY")
+
+// newRecoveryCodes returns recoveryCodeCount codes like 4kq7d-m2xpa.
+func newRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, 6)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(random))
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// secondFactor checks the TOTP or recovery code given by a user logging in
+// with their password in r. Users without two-factor authentication need
+// none.
+func (cfg *apiConfig) secondFactor(r *http.Request, user database.User, code string) (bool, error) {
+	if !user.TOTPEnabled {
+		return true, nil
+	}
+	if code == "" {
+		return false, nil
+	}
+	if cfg.totpBox == nil {
+		return false, errTOTPNotConfigured
+	}
+	secret, err := cfg.totpBox.Open(user.TOTPSecret)
+	if err != nil {
+		return false, err
+	}
+	if step, ok := auth.MatchTOTP(secret, code, time.Now()); ok {
+		// A code is good once; a replay of it, or of an older one, fails.
+		return cfg.store(r.Context()).UseTOTPStep(user.Id, step)
+	}
+	used, err := cfg.store(r.Context()).UseRecoveryCode(user.Id, code)
+	if used {
+		cfg.recordSecurityEvent(r, user.Id, database.SecurityRecoveryCodeUsed)
+	}
+	return used, err
+}
+
+// postTOTPEnrollHandler gives the user a new TOTP secret to add to their
+// authenticator app. Logins do not ask for codes until the user confirms
+// the app has it with postTOTPVerifyHandler.
+func (cfg *apiConfig) postTOTPEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.totpBox == nil {
+		respondNotImplemented(w, errTOTPNotConfigured.Error())
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	sealed, err := cfg.totpBox.Seal(secret)
+	if err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	if err := cfg.store(r.Context()).SetTOTPSecret(userId, sealed); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+
+	type returnVal struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	data, err := json.Marshal(returnVal{
+		Secret:     auth.EncodeTOTPSecret(secret),
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// postTOTPVerifyHandler enables two-factor authentication once the user
+// sends a code from the secret they enrolled, and answers with their
+// recovery codes. They are shown only this once.
+func (cfg *apiConfig) postTOTPVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if cfg.totpBox == nil {
+		respondNotImplemented(w, errTOTPNotConfigured.Error())
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if user.TOTPEnabled {
+		respondDataWriteError(w, database.ErrTOTPEnabled)
+		return
+	}
+	if user.TOTPSecret == nil {
+		respondValidationError(w, "enroll before verifying a code")
+		return
+	}
+	secret, err := cfg.totpBox.Open(user.TOTPSecret)
+	if err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	step, ok := auth.MatchTOTP(secret, params.Code, time.Now())
+	if !ok {
+		respondValidationError(w, "invalid code")
+		return
+	}
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	if err := cfg.store(r.Context()).EnableTOTP(userId, codes); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	// The code that enabled it cannot then be used to log in.
+	if _, err := cfg.store(r.Context()).UseTOTPStep(userId, step); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	cfg.recordSecurityEvent(r, userId, database.SecurityTOTPEnabled)
+
+	type returnVal struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	data, err := json.Marshal(returnVal{RecoveryCodes: codes})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(data)
+}
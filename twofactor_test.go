@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+func TestSecondFactor(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := auth.NewSecretBox("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, totpBox: box}
+
+	plain, err := db.CreateUser("plain@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrolled, err := db.CreateUser("enrolled@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTOTPSecret(enrolled.Id, sealed); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(codes[0]) != 11 || codes[0][5] != '-' {
+		t.Fatalf("Expecting: %d codes like xxxxx-xxxxx, but got: %v", recoveryCodeCount, codes)
+	}
+	runSecondFactorTest(t, cfg, enrolled.Id, "", true)
+	if err := db.EnableTOTP(enrolled.Id, codes); err != nil {
+		t.Fatal(err)
+	}
+
+	runSecondFactorTest(t, cfg, plain.Id, "", true)
+	runSecondFactorTest(t, cfg, enrolled.Id, "", false)
+	runSecondFactorTest(t, cfg, enrolled.Id, "000000", auth.TOTPCode(secret, time.Now()) == "000000")
+	runSecondFactorTest(t, cfg, enrolled.Id, auth.TOTPCode(secret, time.Now()), true)
+	runSecondFactorTest(t, cfg, enrolled.Id, auth.TOTPCode(secret, time.Now()), false)
+	runSecondFactorTest(t, cfg, enrolled.Id, auth.TOTPCode(secret, time.Now().Add(-auth.TOTPPeriod)), false)
+	runSecondFactorTest(t, cfg, enrolled.Id, auth.TOTPCode(secret, time.Now().Add(auth.TOTPPeriod)), true)
+	runSecondFactorTest(t, cfg, enrolled.Id, codes[0], true)
+	runSecondFactorTest(t, cfg, enrolled.Id, codes[0], false)
+}
+
+func runSecondFactorTest(t *testing.T, cfg *apiConfig, userId int, code string, expecting bool) {
+	t.Logf("Starting test for secondFactor with: user %d and code %q, and expecting: %v", userId, code, expecting)
+	user, err := cfg.db.GetUserById(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := cfg.secondFactor(httptest.NewRequest("POST", "/api/login", nil), user, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != expecting {
+		t.Errorf("Expecting: %v, but got: %v", expecting, ok)
+	}
+}
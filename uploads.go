@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/blobstore"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// Chunked uploads borrow the core of the tus protocol (https://tus.io), so
+// a client on a flaky network can resume a large upload rather than start
+// over:
+//
+//   - POST /api/media/uploads with {"length", "alt_text"} starts an upload
+//     and answers with its URL in Location.
+//   - HEAD on that URL tells how many bytes arrived, in Upload-Offset.
+//   - PATCH on it with Upload-Offset and a body of Content-Type
+//     application/offset+octet-stream appends the body there. Bytes that
+//     arrive before a connection drops are kept.
+//   - The PATCH that completes the upload answers 201 with the media.
+//   - DELETE on it abandons the upload.
+const (
+	uploadOffsetHeader = "Upload-Offset"
+	uploadLengthHeader = "Upload-Length"
+	uploadChunkType    = "application/offset+octet-stream"
+)
+
+func (cfg *apiConfig) postUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+
+	type parameters struct {
+		Length  int64  `json:"length"`
+		AltText string `json:"alt_text"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if params.Length <= 0 {
+		respondValidationError(w, "length must be the size of the file in bytes")
+		return
+	}
+	if params.Length > cfg.mediaMaxBytes {
+		w.WriteHeader(413)
+		return
+	}
+
+	upload, err := cfg.store(r.Context()).CreateUpload(database.Upload{
+		OwnerId:   userId,
+		Length:    params.Length,
+		AltText:   strings.TrimSpace(params.AltText),
+		ExpiresAt: time.Now().Add(cfg.uploadTTL),
+	})
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/media/uploads/"+upload.Id)
+	w.Header().Set(uploadOffsetHeader, "0")
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+// userUpload returns the upload in the URL if it belongs to the user and
+// can still be resumed, and answers 404 or 410 otherwise.
+func (cfg *apiConfig) userUpload(w http.ResponseWriter, r *http.Request, userId int) (database.Upload, bool) {
+	upload, err := cfg.store(r.Context()).GetUpload(chi.URLParam(r, "id"))
+	if errors.Is(err, database.ErrUploadDoesNotExist) || (err == nil && upload.OwnerId != userId) {
+		w.WriteHeader(404)
+		return database.Upload{}, false
+	}
+	if err != nil {
+		respondDataFetchError(w, err)
+		return database.Upload{}, false
+	}
+	if upload.Expired(time.Now()) {
+		w.WriteHeader(http.StatusGone)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) headUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	upload, ok := cfg.userUpload(w, r, userId)
+	if !ok {
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set(uploadLengthHeader, strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+}
+
+func (cfg *apiConfig) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if cfg.rejectSuspended(w, r, userId) {
+		return
+	}
+	upload, ok := cfg.userUpload(w, r, userId)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != uploadChunkType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		respondValidationError(w, "Upload-Offset must be the number of bytes already uploaded")
+		return
+	}
+	if offset != upload.Offset {
+		respondOffsetMismatch(w, upload.Offset)
+		return
+	}
+
+	chunk := &partialReader{r: io.LimitReader(r.Body, upload.Length-upload.Offset+1)}
+	key, err := newBlobKey()
+	if err != nil {
+		respondUnexpectedError(w, err)
+		return
+	}
+	size, err := cfg.blobs.Put(key, chunk)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if size > upload.Length-upload.Offset {
+		cfg.blobs.Delete(key)
+		w.WriteHeader(413)
+		return
+	}
+	if size > 0 {
+		// The chunk is saved even when the client has gone, which cancels
+		// the request's context, so it is not bound to it.
+		appended, err := cfg.store(r.Context()).AppendUploadChunk(upload.Id, offset, key, size)
+		if errors.Is(err, database.ErrUploadOffsetMismatch) {
+			cfg.blobs.Delete(key)
+			current, err := cfg.store(r.Context()).GetUpload(upload.Id)
+			if err != nil {
+				respondDataFetchError(w, err)
+				return
+			}
+			respondOffsetMismatch(w, current.Offset)
+			return
+		}
+		if err != nil {
+			cfg.blobs.Delete(key)
+			respondDataWriteError(w, err)
+			return
+		}
+		upload = appended
+	} else {
+		cfg.blobs.Delete(key)
+	}
+	if chunk.err != nil {
+		// The client is gone; what it sent is saved for it to resume.
+		log.Printf("Upload %s interrupted at %d of %d bytes: %s", upload.Id, upload.Offset, upload.Length, chunk.err)
+		return
+	}
+
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	if upload.Offset < upload.Length {
+		w.WriteHeader(204)
+		return
+	}
+	cfg.finishUpload(w, r, upload)
+}
+
+// finishUpload turns a complete upload into media. A failure to store the
+// media leaves the upload in place, so a PATCH with an empty body at the
+// final offset can try again.
+func (cfg *apiConfig) finishUpload(w http.ResponseWriter, r *http.Request, upload database.Upload) {
+	chunks := &chunkReader{blobs: cfg.blobs, keys: upload.Chunks}
+	media, reason, err := cfg.storeMedia(r.Context(), upload.OwnerId, chunks, upload.AltText)
+	chunks.Close()
+	if chunks.err != nil {
+		respondDataFetchError(w, chunks.err)
+		return
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	if reason != "" {
+		if err := cfg.discardUpload(r.Context(), upload); err != nil {
+			log.Printf("Error discarding upload %s: %s", upload.Id, err)
+		}
+		respondValidationError(w, reason)
+		return
+	}
+	if err := cfg.discardUpload(r.Context(), upload); err != nil {
+		log.Printf("Error discarding completed upload %s: %s", upload.Id, err)
+	}
+	respondMedia(w, media)
+}
+
+func (cfg *apiConfig) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	upload, ok := cfg.userUpload(w, r, userId)
+	if !ok {
+		return
+	}
+	if err := cfg.discardUpload(r.Context(), upload); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// discardUpload deletes an upload and the chunks it received.
+func (cfg *apiConfig) discardUpload(ctx context.Context, upload database.Upload) error {
+	if err := cfg.store(ctx).DeleteUpload(upload.Id); err != nil && !errors.Is(err, database.ErrUploadDoesNotExist) {
+		return err
+	}
+	for _, key := range upload.Chunks {
+		if err := cfg.blobs.Delete(key); err != nil && err != blobstore.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireUploads discards the uploads left unfinished past their expiry,
+// checking every interval until ctx is done.
+func (cfg *apiConfig) expireUploads(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			uploads, err := cfg.store(ctx).GetExpiredUploads(now)
+			if err != nil {
+				return fmt.Errorf("finding expired uploads: %w", err)
+			}
+			for _, upload := range uploads {
+				if err := cfg.discardUpload(ctx, upload); err != nil {
+					return fmt.Errorf("discarding upload %s: %w", upload.Id, err)
+				}
+			}
+			if len(uploads) > 0 {
+				log.Printf("Discarded %d expired uploads", len(uploads))
+			}
+		}
+	}
+}
+
+// respondOffsetMismatch tells the client its chunk does not start where
+// the upload stands, and where it does.
+func respondOffsetMismatch(w http.ResponseWriter, offset int64) {
+	type returnVal struct {
+		Error  string `json:"error"`
+		Offset int64  `json:"offset"`
+	}
+	data, err := json.Marshal(returnVal{Error: "Upload-Offset does not match the bytes received", Offset: offset})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(offset, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(409)
+	w.Write(data)
+}
+
+// partialReader ends at the first read error as if at the end of the
+// input, so a chunk cut short by a dropped connection is still stored. The
+// error is kept in err.
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		p.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// chunkReader reads the chunks of an upload in order, opening one blob at
+// a time. The first error reading a chunk is kept in err.
+type chunkReader struct {
+	blobs   blobstore.BlobStore
+	keys    []string
+	current io.ReadCloser
+	err     error
+}
+
+func (c *chunkReader) Read(b []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.keys) == 0 {
+				return 0, io.EOF
+			}
+			blob, err := c.blobs.Open(c.keys[0])
+			if err != nil {
+				c.err = fmt.Errorf("opening upload chunk: %w", err)
+				return 0, c.err
+			}
+			c.current, c.keys = blob, c.keys[1:]
+		}
+		n, err := c.current.Read(b)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		if err != nil {
+			c.err = err
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/auth"
+	"github.com/avearmin/chirpy/internal/blobstore"
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blobstore.NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, tokens: auth.NewIssuer("secret"), blobs: blobs, mediaMaxBytes: 1 << 10, uploadTTL: time.Hour}
+	router := chi.NewRouter()
+	router.Post("/api/media/uploads", cfg.postUploadHandler)
+	router.Head("/api/media/uploads/{id}", cfg.headUploadHandler)
+	router.Patch("/api/media/uploads/{id}", cfg.patchUploadHandler)
+	router.Delete("/api/media/uploads/{id}", cfg.deleteUploadHandler)
+	owner, _ := cfg.tokens.NewAccessToken(1)
+	stranger, _ := cfg.tokens.NewAccessToken(2)
+
+	file := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 24)...)
+	resp := runUploadRequest(t, router, owner, "POST", "/api/media/uploads", "", `{"length":`+strconv.Itoa(len(file))+`,"alt_text":"A cat"}`, 201)
+	location := resp.Header().Get("Location")
+
+	runUploadRequest(t, router, owner, "PATCH", location, "5", string(file[:8]), 409)
+	runUploadRequest(t, router, owner, "PATCH", location, "0", string(file[:8]), 204)
+	runUploadRequest(t, router, stranger, "HEAD", location, "", "", 404)
+	if resp := runUploadRequest(t, router, owner, "HEAD", location, "", "", 200); resp.Header().Get(uploadOffsetHeader) != "8" {
+		t.Errorf("Expecting: offset 8, but got: %v", resp.Header())
+	}
+
+	t.Logf("Starting test for patchUploadHandler with: a connection dropped mid-chunk, and expecting: the bytes that arrived kept")
+	req := httptest.NewRequest("PATCH", location, io.MultiReader(bytes.NewReader(file[8:16]), errReader{}))
+	req.Header.Set("Authorization", "Bearer "+owner)
+	req.Header.Set("Content-Type", uploadChunkType)
+	req.Header.Set(uploadOffsetHeader, "8")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if resp := runUploadRequest(t, router, owner, "HEAD", location, "", "", 200); resp.Header().Get(uploadOffsetHeader) != "16" {
+		t.Errorf("Expecting: offset 16, but got: %v", resp.Header())
+	}
+
+	runUploadRequest(t, router, owner, "PATCH", location, "16", string(file[16:])+"extra", 413)
+	resp = runUploadRequest(t, router, owner, "PATCH", location, "16", string(file[16:]), 201)
+	media := database.Media{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &media); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetMedia(media.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := blobs.Open(stored.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if !bytes.Equal(data, file) || stored.ContentType != "image/png" || stored.AltText != "A cat" {
+		t.Errorf("Expecting: the whole png with its alt text, but got: %+v with %d bytes", stored, len(data))
+	}
+	runUploadRequest(t, router, owner, "HEAD", location, "", "", 404)
+
+	runUploadRequest(t, router, owner, "POST", "/api/media/uploads", "", `{"length":2048}`, 413)
+	resp = runUploadRequest(t, router, owner, "POST", "/api/media/uploads", "", `{"length":4}`, 201)
+	runUploadRequest(t, router, owner, "PATCH", resp.Header().Get("Location"), "0", "text", 400)
+	runUploadRequest(t, router, owner, "HEAD", resp.Header().Get("Location"), "", "", 404)
+
+	cfg.uploadTTL = -time.Second
+	resp = runUploadRequest(t, router, owner, "POST", "/api/media/uploads", "", `{"length":4}`, 201)
+	runUploadRequest(t, router, owner, "PATCH", resp.Header().Get("Location"), "0", "text", http.StatusGone)
+}
+
+func runUploadRequest(t *testing.T, router http.Handler, token, method, path, offset, body string, expecting int) *httptest.ResponseRecorder {
+	t.Logf("Starting test for %s %s with: offset %q and %d bytes, and expecting: %d", method, path, offset, len(body), expecting)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", uploadChunkType)
+		req.Header.Set(uploadOffsetHeader, offset)
+	}
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	if resp.Code != expecting {
+		t.Errorf("Expecting: %d, but got: %d %s", expecting, resp.Code, resp.Body.String())
+	}
+	return resp
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+)
+
+// jobVerificationEmail jobs mail a user a fresh verification token.
+const jobVerificationEmail = "verification_email"
+
+type verificationEmailJob struct {
+	UserId int `json:"user_id"`
+}
+
+// sendVerification queues a verification email for user. Signing up should
+// not fail because mail is down, so errors are only logged; the user can
+// ask for another token later.
+func (cfg *apiConfig) sendVerification(ctx context.Context, user database.User) {
+	if _, err := cfg.enqueueJob(ctx, jobVerificationEmail, verificationEmailJob{UserId: user.Id}, time.Time{}); err != nil {
+		logRequestf(requestId(ctx), "Error queueing verification email for user %d: %s", user.Id, err)
+	}
+}
+
+// verificationEmailJob creates the token only when the mail is about to be
+// sent, so tokens are never stored in the job queue. Users who verified in
+// the meantime are not mailed.
+func (cfg *apiConfig) verificationEmailJob(ctx context.Context, payload json.RawMessage) (any, error) {
+	params := verificationEmailJob{}
+	if err := json.Unmarshal(payload, &params); err != nil {
+		return nil, err
+	}
+	user, err := cfg.store(ctx).GetUserById(params.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if user.Verified {
+		return nil, nil
+	}
+	token, err := cfg.store(ctx).CreateVerificationToken(user.Id, cfg.verificationTTL)
+	if err != nil {
+		return nil, err
+	}
+	body := fmt.Sprintf("Confirm your email address by sending this token to POST /api/users/verify:\n\n%s\n\nIt expires in %s.", token, cfg.verificationTTL)
+	return nil, cfg.mail.Send(user.Email, "Verify your Chirpy account", body)
+}
+
+// rejectUnverified answers 403 and returns true when the user has not
+// verified their email address yet.
+func (cfg *apiConfig) rejectUnverified(w http.ResponseWriter, r *http.Request, userId int) bool {
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return true
+	}
+	if !user.Verified {
+		w.WriteHeader(403)
+		return true
+	}
+	return false
+}
+
+func (cfg *apiConfig) postVerifyUserHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	user, err := cfg.store(r.Context()).VerifyUser(params.Token)
+	if errors.Is(err, database.ErrInvalidVerification) {
+		w.WriteHeader(400)
+		return
+	}
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	user, err = cfg.promoteAdminEmail(cfg.store(r.Context()), user)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) postResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := cfg.authenticate(w, r)
+	if !ok {
+		return
+	}
+	user, err := cfg.store(r.Context()).GetUserById(userId)
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	if user.Verified {
+		w.WriteHeader(409)
+		return
+	}
+	cfg.sendVerification(r.Context(), user)
+	w.WriteHeader(204)
+}
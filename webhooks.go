@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+// jobWebhook jobs deliver one event to one registered webhook. The job
+// queue retries failed deliveries with exponential backoff, and the job
+// records how each delivery went.
+const jobWebhook = "webhook"
+
+// The events webhooks can subscribe to.
+const (
+	webhookChirpCreated = "chirp.created"
+	webhookChirpDeleted = "chirp.deleted"
+	webhookUserUpgraded = "user.upgraded"
+)
+
+var webhookEvents = []string{webhookChirpCreated, webhookChirpDeleted, webhookUserUpgraded}
+
+// Deliveries are signed like requests made with an API key, keyed with the
+// webhook's secret, so receivers can check them the same way. The event is
+// also named in its own header.
+const webhookEventHeader = "X-Chirpy-Event"
+
+// webhookEvent is the body posted to webhooks, shaped like the events
+// Polka posts to us.
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	Data      any       `json:"data"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// webhookDelivery is the payload of a jobWebhook job.
+type webhookDelivery struct {
+	WebhookId int             `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// emitWebhookEvent queues a delivery of event to every webhook subscribed
+// to it. Like notifyOperator it only logs errors, so a request does not
+// fail because a receiver is down.
+func (cfg *apiConfig) emitWebhookEvent(ctx context.Context, event string, data any) {
+	webhooks, err := cfg.store(ctx).GetWebhooks()
+	if err != nil {
+		logRequestf(requestId(ctx), "Error loading webhooks for %s: %s", event, err)
+		return
+	}
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(data); err != nil {
+				logRequestf(requestId(ctx), "Error encoding %s for webhooks: %s", event, err)
+				return
+			}
+		}
+		delivery := webhookDelivery{WebhookId: webhook.Id, Event: event, Data: payload, CreatedAt: time.Now().UTC()}
+		if _, err := cfg.enqueueJob(ctx, jobWebhook, delivery, time.Time{}); err != nil {
+			logRequestf(requestId(ctx), "Error queueing %s for webhook %d: %s", event, webhook.Id, err)
+		}
+	}
+}
+
+// webhookJob posts a delivery, failing on anything but a 2xx answer so the
+// job queue retries it. Deliveries to webhooks deleted since are dropped.
+func (cfg *apiConfig) webhookJob(ctx context.Context, payload json.RawMessage) (any, error) {
+	delivery := webhookDelivery{}
+	if err := json.Unmarshal(payload, &delivery); err != nil {
+		return nil, err
+	}
+	webhook, err := cfg.store(ctx).GetWebhook(delivery.WebhookId)
+	var notFound *database.NotFoundError
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(webhookEvent{Event: delivery.Event, Data: delivery.Data, CreatedAt: delivery.CreatedAt})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := newBlobKey()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, delivery.Event)
+	req.Header.Set(signatureTimestampHeader, timestamp)
+	req.Header.Set(signatureNonceHeader, nonce)
+	req.Header.Set(signatureHeader, signRequest(webhook.Secret, req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return map[string]int{"status": resp.StatusCode}, nil
+}
+
+func (cfg *apiConfig) postWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	decoder := newJSONDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondParamsDecodingError(w, err)
+		return
+	}
+	if !validHTTPURL(params.URL) {
+		respondValidationError(w, "url must be an http or https URL")
+		return
+	}
+	if len(params.Events) == 0 {
+		respondValidationError(w, "subscribe to at least one event")
+		return
+	}
+	for _, event := range params.Events {
+		if !slices.Contains(webhookEvents, event) {
+			respondValidationError(w, fmt.Sprintf("unknown event %q: events are %s, %s and %s", event, webhookChirpCreated, webhookChirpDeleted, webhookUserUpgraded))
+			return
+		}
+	}
+	webhook, err := cfg.store(r.Context()).CreateWebhook(params.URL, params.Events)
+	if err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+
+	type returnVal struct {
+		database.Webhook
+		Secret string `json:"secret"`
+	}
+	data, err := json.Marshal(returnVal{Webhook: webhook, Secret: webhook.Secret})
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := cfg.store(r.Context()).GetWebhooks()
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	data, err := json.Marshal(webhooks)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+func (cfg *apiConfig) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	if err := cfg.store(r.Context()).DeleteWebhook(id); err != nil {
+		respondDataWriteError(w, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// getWebhookDeliveriesHandler lists the delivery jobs of a webhook, newest
+// first, with their state, attempts and last error.
+func (cfg *apiConfig) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		respondParseURLError(w, err)
+		return
+	}
+	if _, err := cfg.store(r.Context()).GetWebhook(id); err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	jobs, err := cfg.store(r.Context()).GetJobs("")
+	if err != nil {
+		respondDataFetchError(w, err)
+		return
+	}
+	deliveries := make([]database.Job, 0)
+	for i := len(jobs) - 1; i >= 0; i-- {
+		delivery := webhookDelivery{}
+		if jobs[i].Kind != jobWebhook || json.Unmarshal(jobs[i].Payload, &delivery) != nil || delivery.WebhookId != id {
+			continue
+		}
+		deliveries = append(deliveries, jobs[i])
+	}
+	data, err := json.Marshal(deliveries)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avearmin/chirpy/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestWebhooks(t *testing.T) {
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.gob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{db: db, jobs: newJobQueue(time.Millisecond, time.Minute, time.Minute), webhookClient: http.DefaultClient}
+	cfg.jobs.handle(jobWebhook, 3, cfg.webhookJob)
+
+	status := 204
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	t.Logf("Starting test for postWebhookHandler with: an unknown event, and expecting: 400")
+	w := httptest.NewRecorder()
+	cfg.postWebhookHandler(w, httptest.NewRequest("POST", "/admin/webhooks", strings.NewReader(`{"url": "`+server.URL+`/hook", "events": ["chirp.liked"]}`)))
+	if w.Code != 400 {
+		t.Errorf("Expecting: 400, but got: %d", w.Code)
+	}
+
+	t.Logf("Starting test for postWebhookHandler with: chirp.created, and expecting: the webhook with its secret")
+	w = httptest.NewRecorder()
+	cfg.postWebhookHandler(w, httptest.NewRequest("POST", "/admin/webhooks", strings.NewReader(`{"url": "`+server.URL+`/hook", "events": ["chirp.created"]}`)))
+	created := struct {
+		Id     int    `json:"id"`
+		Secret string `json:"secret"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); w.Code != 201 || err != nil || created.Secret == "" {
+		t.Fatalf("Expecting: 201 with a secret, but got: %d %s", w.Code, w.Body)
+	}
+
+	deliver := func() database.Job {
+		t.Helper()
+		job, found, err := db.ClaimJob(time.Now(), time.Minute)
+		if err != nil || !found {
+			t.Fatalf("Expecting: a delivery to run, but got: %v", err)
+		}
+		cfg.runJob(context.Background(), job)
+		job, err = db.GetJob(job.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	t.Logf("Starting test for emitWebhookEvent with: an event nobody subscribed to, and expecting: no delivery")
+	cfg.emitWebhookEvent(context.Background(), webhookUserUpgraded, map[string]int{"user_id": 1})
+	if _, found, _ := db.ClaimJob(time.Now(), time.Minute); found {
+		t.Errorf("Expecting: no delivery, but got one")
+	}
+
+	t.Logf("Starting test for webhookJob with: chirp.created, and expecting: a signed POST")
+	cfg.emitWebhookEvent(context.Background(), webhookChirpCreated, map[string]int{"id": 7})
+	job := deliver()
+	if job.State != database.JobDone || string(job.Result) != `{"status":204}` {
+		t.Errorf("Expecting: done with status 204, but got: %+v", job)
+	}
+	if received == nil {
+		t.Fatal("Expecting: a delivery, but got none")
+	}
+	signature := signRequest(created.Secret, "POST", "/hook", received.Header.Get(signatureTimestampHeader), received.Header.Get(signatureNonceHeader), body)
+	if received.Header.Get(signatureHeader) != signature || received.Header.Get(webhookEventHeader) != webhookChirpCreated {
+		t.Errorf("Expecting: signature %s for %s, but got: %v", signature, webhookChirpCreated, received.Header)
+	}
+	event := webhookEvent{}
+	if err := json.Unmarshal(body, &event); err != nil || event.Event != webhookChirpCreated || event.Data.(map[string]any)["id"] != 7.0 {
+		t.Errorf("Expecting: the chirp.created event, but got: %s", body)
+	}
+
+	t.Logf("Starting test for webhookJob with: a 500 answer, and expecting: a retry later")
+	status = 500
+	cfg.emitWebhookEvent(context.Background(), webhookChirpCreated, map[string]int{"id": 8})
+	job = deliver()
+	if job.State != database.JobQueued || job.Attempts != 1 || !strings.Contains(job.LastError, "500") || !job.RunAt.After(time.Now()) {
+		t.Errorf("Expecting: queued again after a failed attempt, but got: %+v", job)
+	}
+
+	t.Logf("Starting test for getWebhookDeliveriesHandler with: two deliveries, and expecting: newest first")
+	w = httptest.NewRecorder()
+	router := chi.NewRouter()
+	router.Get("/admin/webhooks/{id}/deliveries", cfg.getWebhookDeliveriesHandler)
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/webhooks/1/deliveries", nil))
+	deliveries := []database.Job{}
+	if err := json.Unmarshal(w.Body.Bytes(), &deliveries); err != nil || len(deliveries) != 2 || deliveries[0].State != database.JobQueued {
+		t.Errorf("Expecting: the failed delivery then the done one, but got: %s", w.Body)
+	}
+
+	t.Logf("Starting test for webhookJob with: a deleted webhook, and expecting: the delivery dropped")
+	if err := db.DeleteWebhook(created.Id); err != nil {
+		t.Fatal(err)
+	}
+	job, found, err := db.ClaimJob(time.Now().Add(time.Hour), time.Minute)
+	if err != nil || !found {
+		t.Fatalf("Expecting: the retry to run, but got: %v", err)
+	}
+	received = nil
+	cfg.runJob(context.Background(), job)
+	if job, _ = db.GetJob(job.Id); job.State != database.JobDone || received != nil {
+		t.Errorf("Expecting: done without a POST, but got: %+v", job)
+	}
+}
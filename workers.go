@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Worker states as reported by /api/readyz and /admin/stats.
+const (
+	workerPending = "pending"
+	workerRunning = "running"
+	workerBackoff = "backing_off"
+	workerStopped = "stopped"
+)
+
+// workerStableAfter is how long a worker must run before a failure is
+// treated as new rather than a continuation of the last one, resetting its
+// backoff.
+const workerStableAfter = time.Minute
+
+// workerManager runs the server's background workers once the store is
+// ready, restarting any that fail or panic with exponential backoff.
+type workerManager struct {
+	mux        sync.Mutex
+	workers    []*worker
+	started    bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+type worker struct {
+	name   string
+	run    func(ctx context.Context) error
+	status workerStatus
+}
+
+type workerStatus struct {
+	Name      string     `json:"name"`
+	State     string     `json:"state"`
+	Restarts  int        `json:"restarts"`
+	LastError string     `json:"last_error,omitempty"`
+	FailedAt  *time.Time `json:"failed_at,omitempty"`
+}
+
+func newWorkerManager(minBackoff, maxBackoff time.Duration) *workerManager {
+	return &workerManager{minBackoff: minBackoff, maxBackoff: maxBackoff}
+}
+
+// add registers a worker. run should block until ctx is done; returning
+// early, with or without an error, counts as a failure.
+func (m *workerManager) add(name string, run func(ctx context.Context) error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.workers = append(m.workers, &worker{name: name, run: run, status: workerStatus{Name: name, State: workerPending}})
+}
+
+// startWhenReady waits for ready to succeed, retrying with backoff, then
+// starts every worker.
+func (m *workerManager) startWhenReady(ctx context.Context, ready func() error) {
+	backoff := m.minBackoff
+	for {
+		err := ready()
+		if err == nil {
+			break
+		}
+		log.Printf("Store not ready, retrying in %s: %s", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, m.maxBackoff)
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.started = true
+	for _, w := range m.workers {
+		go m.supervise(ctx, w)
+	}
+}
+
+func (m *workerManager) supervise(ctx context.Context, w *worker) {
+	backoff := m.minBackoff
+	for {
+		m.setState(w, workerRunning, nil)
+		began := time.Now()
+		err := runWorker(ctx, w)
+		if ctx.Err() != nil {
+			m.setState(w, workerStopped, nil)
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("worker exited")
+		}
+		if time.Since(began) > workerStableAfter {
+			backoff = m.minBackoff
+		}
+		log.Printf("Worker %s failed, restarting in %s: %s", w.name, backoff, err)
+		m.setState(w, workerBackoff, err)
+		select {
+		case <-ctx.Done():
+			m.setState(w, workerStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, m.maxBackoff)
+	}
+}
+
+// runWorker turns a panic in the worker into an error.
+func runWorker(ctx context.Context, w *worker) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return w.run(ctx)
+}
+
+func (m *workerManager) setState(w *worker, state string, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if w.status.State == workerBackoff && state == workerRunning {
+		w.status.Restarts++
+	}
+	w.status.State = state
+	if err != nil {
+		now := time.Now().UTC()
+		w.status.LastError = err.Error()
+		w.status.FailedAt = &now
+	}
+}
+
+// statuses reports every worker, and whether all of them are running.
+func (m *workerManager) statuses() ([]workerStatus, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	statuses := make([]workerStatus, len(m.workers))
+	healthy := m.started
+	for i, w := range m.workers {
+		statuses[i] = w.status
+		healthy = healthy && w.status.State == workerRunning
+	}
+	return statuses, healthy
+}
+
+// readyzHandler reports 200 once the store is reachable and every worker
+// is running, and 503 otherwise, so load balancers only send traffic to
+// instances that are fully up. /api/healthz only says the process is alive.
+func (cfg *apiConfig) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	type returnVal struct {
+		Ready   bool           `json:"ready"`
+		Store   string         `json:"store"`
+		Workers []workerStatus `json:"workers"`
+	}
+	workers, healthy := cfg.workers.statuses()
+	resp := returnVal{Ready: healthy, Store: "ok", Workers: workers}
+	if err := cfg.store(r.Context()).Ping(); err != nil {
+		resp.Ready = false
+		resp.Store = err.Error()
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		respondJSONMarshalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Ready {
+		w.WriteHeader(200)
+	} else {
+		w.WriteHeader(503)
+	}
+	w.Write(data)
+}
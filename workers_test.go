@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerManager(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := newWorkerManager(time.Millisecond, 5*time.Millisecond)
+	var runs atomic.Int32
+	m.add("flaky", func(ctx context.Context) error {
+		switch runs.Add(1) {
+		case 1:
+			panic("boom")
+		case 2:
+			return errors.New("broken")
+		}
+		<-ctx.Done()
+		return nil
+	})
+	m.add("steady", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	runWorkerStatusTest(t, m, false, "")
+	var pings atomic.Int32
+	go m.startWhenReady(ctx, func() error {
+		if pings.Add(1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	waitForWorkers(t, m, true)
+	runWorkerStatusTest(t, m, true, "broken")
+
+	t.Logf("Starting test for workerManager.supervise with: a cancelled context, and expecting: every worker stopped")
+	cancel()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		statuses, _ := m.statuses()
+		if statuses[0].State == workerStopped && statuses[1].State == workerStopped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expecting: %s, but got: %+v", workerStopped, statuses)
+		}
+	}
+}
+
+func runWorkerStatusTest(t *testing.T, m *workerManager, healthy bool, lastError string) {
+	t.Logf("Starting test for workerManager.statuses, and expecting: healthy %t, last error %q", healthy, lastError)
+	statuses, got := m.statuses()
+	if got != healthy {
+		t.Errorf("Expecting: healthy %t, but got: %+v", healthy, statuses)
+	}
+	if statuses[0].LastError != lastError || (lastError != "" && statuses[0].Restarts != 2) {
+		t.Errorf("Expecting: 2 restarts after %q, but got: %+v", lastError, statuses[0])
+	}
+}
+
+func waitForWorkers(t *testing.T, m *workerManager, healthy bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, got := m.statuses(); got == healthy {
+			return
+		}
+	}
+	t.Fatalf("Expecting: healthy %t, but it never was", healthy)
+}